@@ -0,0 +1,49 @@
+//go:build amqp
+// +build amqp
+
+package main
+
+import (
+	"context"
+	"os"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/streadway/amqp"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/config"
+	"github.com/cage1016/gokit-gae/internal/app/add/endpoints"
+	"github.com/cage1016/gokit-gae/internal/app/add/transports"
+)
+
+func init() {
+	optionalServers = append(optionalServers, startAMQPServer)
+}
+
+// startAMQPServer consumes the requests of the AMQP queues of the broker at
+// QS_ADD_AMQP_URL, when set, until ctx is done.
+func startAMQPServer(ctx context.Context, wg *sync.WaitGroup, endpoints endpoints.Endpoints, cfg config.Config, logger log.Logger) {
+	if cfg.AMQPURL.Value == "" {
+		return
+	}
+	wg.Add(1)
+	defer wg.Done()
+
+	conn, err := amqp.Dial(cfg.AMQPURL.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.AMQPURL.Env, "err", err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+	ch, err := conn.Channel()
+	if err != nil {
+		level.Error(logger).Log("protocol", "AMQP", "err", err)
+		os.Exit(1)
+	}
+
+	level.Info(logger).Log("protocol", "AMQP", "exposed", transports.AMQPSumQueue+","+transports.AMQPConcatQueue)
+	if err := transports.ServeAMQP(ctx, ch, endpoints, logger); err != nil {
+		level.Error(logger).Log("protocol", "AMQP", "err", err)
+	}
+}
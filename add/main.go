@@ -2,125 +2,1306 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	stdjwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
 	kitgrpc "github.com/go-kit/kit/transport/grpc"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/health"
 	healthgrpc "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 
+	"github.com/cage1016/gokit-gae/internal/app/add/config"
+	"github.com/cage1016/gokit-gae/internal/app/add/delivery"
 	"github.com/cage1016/gokit-gae/internal/app/add/endpoints"
+	"github.com/cage1016/gokit-gae/internal/app/add/middlewares"
+	"github.com/cage1016/gokit-gae/internal/app/add/repository"
 	"github.com/cage1016/gokit-gae/internal/app/add/service"
 	"github.com/cage1016/gokit-gae/internal/app/add/transports"
+	"github.com/cage1016/gokit-gae/internal/pkg/anomaly"
+	"github.com/cage1016/gokit-gae/internal/pkg/appengine"
+	"github.com/cage1016/gokit-gae/internal/pkg/auth"
+	"github.com/cage1016/gokit-gae/internal/pkg/bloom"
+	"github.com/cage1016/gokit-gae/internal/pkg/breaker"
+	"github.com/cage1016/gokit-gae/internal/pkg/bulkhead"
+	"github.com/cage1016/gokit-gae/internal/pkg/cache"
+	"github.com/cage1016/gokit-gae/internal/pkg/canary"
+	"github.com/cage1016/gokit-gae/internal/pkg/capability"
+	"github.com/cage1016/gokit-gae/internal/pkg/capture"
+	"github.com/cage1016/gokit-gae/internal/pkg/chain"
+	"github.com/cage1016/gokit-gae/internal/pkg/chaos"
+	"github.com/cage1016/gokit-gae/internal/pkg/clock"
+	"github.com/cage1016/gokit-gae/internal/pkg/compat"
+	"github.com/cage1016/gokit-gae/internal/pkg/cron"
+	"github.com/cage1016/gokit-gae/internal/pkg/degrade"
+	"github.com/cage1016/gokit-gae/internal/pkg/discovery"
+	"github.com/cage1016/gokit-gae/internal/pkg/download"
+	"github.com/cage1016/gokit-gae/internal/pkg/drift"
+	"github.com/cage1016/gokit-gae/internal/pkg/dsindex"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/eventstore"
+	"github.com/cage1016/gokit-gae/internal/pkg/expand"
+	"github.com/cage1016/gokit-gae/internal/pkg/experiment"
+	"github.com/cage1016/gokit-gae/internal/pkg/featureflags"
+	"github.com/cage1016/gokit-gae/internal/pkg/gcp"
+	"github.com/cage1016/gokit-gae/internal/pkg/id"
+	"github.com/cage1016/gokit-gae/internal/pkg/idempotency"
+	"github.com/cage1016/gokit-gae/internal/pkg/killswitch"
+	"github.com/cage1016/gokit-gae/internal/pkg/kpi"
+	"github.com/cage1016/gokit-gae/internal/pkg/lifecycle"
+	"github.com/cage1016/gokit-gae/internal/pkg/limiter"
+	"github.com/cage1016/gokit-gae/internal/pkg/loadshed"
+	"github.com/cage1016/gokit-gae/internal/pkg/logger"
+	"github.com/cage1016/gokit-gae/internal/pkg/manifest"
+	"github.com/cage1016/gokit-gae/internal/pkg/metering"
+	"github.com/cage1016/gokit-gae/internal/pkg/mirror"
+	"github.com/cage1016/gokit-gae/internal/pkg/nonce"
+	"github.com/cage1016/gokit-gae/internal/pkg/notify"
+	"github.com/cage1016/gokit-gae/internal/pkg/opbudget"
+	"github.com/cage1016/gokit-gae/internal/pkg/outbox"
+	"github.com/cage1016/gokit-gae/internal/pkg/privileged"
+	"github.com/cage1016/gokit-gae/internal/pkg/pubsub"
+	"github.com/cage1016/gokit-gae/internal/pkg/saga"
+	"github.com/cage1016/gokit-gae/internal/pkg/session"
+	"github.com/cage1016/gokit-gae/internal/pkg/signature"
+	"github.com/cage1016/gokit-gae/internal/pkg/snapshot"
+	"github.com/cage1016/gokit-gae/internal/pkg/startup"
+	"github.com/cage1016/gokit-gae/internal/pkg/tenant"
+	"github.com/cage1016/gokit-gae/internal/pkg/tokenexchange"
+	"github.com/cage1016/gokit-gae/internal/pkg/tracing"
+	"github.com/cage1016/gokit-gae/internal/pkg/transform"
+	"github.com/cage1016/gokit-gae/internal/pkg/wiring"
 	pb "github.com/cage1016/gokit-gae/pb/add"
 )
 
-const (
-	defServiceName string = "add"
-	defLogLevel    string = "error"
-	defServiceHost string = "localhost"
-	defHTTPPort    string = "8180"
-	defGRPCPort    string = "8181"
-	envZipkinV2URL string = "QS_ZIPKIN_V2_URL"
-	envServiceName string = "QS_ADD_SERVICE_NAME"
-	envLogLevel    string = "QS_ADD_LOG_LEVEL"
-	envServiceHost string = "QS_ADD_SERVICE_HOST"
-	envHTTPPort    string = "QS_ADD_HTTP_PORT"
-	envGRPCPort    string = "QS_ADD_GRPC_PORT"
-)
+// optionalServers start the servers of the transports built in with a build
+// tag, such as amqp. They return once ctx is done and they're stopped.
+var optionalServers []func(ctx context.Context, wg *sync.WaitGroup, endpoints endpoints.Endpoints, cfg config.Config, logger log.Logger)
+
+// started is the time the process started, before package initialization,
+// so the cold start report accounts for it.
+var started = time.Now()
+
+func main() {
+	printResources := flag.Bool("print-resources", false, "print the manifest of the GCP resources the configuration expects, and exit")
+	replayHistory := flag.Bool("replay-history", false, "rebuild the history from the events of QS_ADD_EVENT_STORE, and exit")
+	flag.Parse()
+
+	boot := startup.NewReport(started)
+	boot.Mark("init")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if *printResources {
+		if err := writeResources(ctx, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	var (
+		logger         log.Logger
+		cfg            config.Config
+		status         drift.Status
+		lc             *lifecycle.Recorder
+		tp             trace.TracerProvider
+		ids            id.Generator
+		historyBreaker *breaker.Breaker
+		repo           repository.Repository
+		events         outbox.Store
+		dispatcher     *outbox.Dispatcher
+		eventStore     eventstore.Store
+		flags          *featureflags.Flags
+		svc            service.AddService
+		eps            endpoints.Endpoints
+		hs             *health.Server
+		snapshots      *snapshot.Manager
+		meter          *metering.Meter
+		ah             *appengine.Hooks
+		jobs           *cron.Jobs
+		state          *gcp.Datastore
+		notifications  notify.Service
+		notifier       *notify.Notifier
+		downloads      download.Store
+		downloadIssuer capability.Issuer
+		maxDownload    int
+		exports        transports.HTTPOption
+		deliveries     *delivery.Deliveries
+	)
+	g := wiring.New()
+	g.Provide("logger", nil, func(ctx context.Context) error {
+		logger = newLogger(ctx)
+		logger = level.NewFilter(logger, level.AllowInfo())
+		logger = log.With(logger, "ts", log.DefaultTimestampUTC)
+		logger = log.With(logger, "caller", log.DefaultCaller)
+		return nil
+	})
+	g.Provide("config", []string{"logger"}, func(ctx context.Context) error {
+		cfg = loadConfig(ctx, logger, secretManager)
+		logger = log.With(logger, "service", cfg.ServiceName.Value)
+		errors.SetVerbosity(newErrorVerbosity(cfg, logger))
+		level.Info(logger).Log("version", service.Version, "commitHash", service.CommitHash, "buildTimeStamp", service.BuildTimeStamp)
+		return nil
+	})
+	g.Provide("lifecycle", []string{"config"}, func(ctx context.Context) error {
+		status = newStatus(cfg)
+		lc = newLifecycleRecorder(ctx, cfg, status, logger)
+		return nil
+	})
+	g.Provide("tracing", []string{"lifecycle"}, func(ctx context.Context) error {
+		tp = newTracerProvider(ctx, cfg, status, logger)
+		return nil
+	})
+	g.Provide("state", []string{"config"}, func(ctx context.Context) error {
+		state = newStateStore(ctx, cfg, logger)
+		return nil
+	})
+	g.Provide("notify", []string{"state"}, func(ctx context.Context) error {
+		notifications, notifier = newNotifications(ctx, cfg, state, logger)
+		return nil
+	})
+	g.Provide("ids", []string{"config"}, func(ctx context.Context) error {
+		ids = newIDGenerator(cfg, logger)
+		return nil
+	})
+	g.Provide("repository", []string{"tracing", "ids", "state", "notify"}, func(ctx context.Context) error {
+		requireTenant, err := strconv.ParseBool(cfg.RequireTenant.Value)
+		if err != nil {
+			level.Error(logger).Log("env", cfg.RequireTenant.Env, "err", err)
+			os.Exit(1)
+		}
+		historyBreaker = newHistoryBreaker(cfg, logger)
+		repo = repository.NewCountingRepository(repository.NewBreakingRepository(newRepository(ctx, cfg, logger), historyBreaker))
+		events, dispatcher = newOutbox(ctx, cfg, notifier, logger)
+		eventStore = newEventStore(ctx, cfg, logger)
+		var tenants tenant.Directory
+		if requireTenant {
+			tenants = newTenantDirectory(cfg, state, logger)
+		}
+		svc = NewServer(repo, ids, events, eventStore, requireTenant, tenants, logger)
+		return nil
+	})
+	g.Provide("metering", []string{"config"}, func(ctx context.Context) error {
+		meter = newMeter(cfg, logger)
+		return nil
+	})
+	g.Provide("featureflags", []string{"snapshot"}, func(ctx context.Context) error {
+		flags = newFeatureFlags(ctx, cfg, snapshots, logger)
+		return nil
+	})
+	g.Provide("endpoints", []string{"repository", "metering", "featureflags"}, func(ctx context.Context) error {
+		stages, err := chain.Parse(cfg.MiddlewareOrder.Value)
+		if err != nil {
+			level.Error(logger).Log("env", cfg.MiddlewareOrder.Env, "err", err)
+			os.Exit(1)
+		}
+		stages = stages.Use(chain.Auth, newAuthStage(cfg, logger))
+		stages = stages.Use(chain.RateLimit, newRateLimitStage(cfg, meter, logger))
+		stages = stages.Use(chain.CircuitBreaker, newCircuitBreakerStage(ctx, cfg, historyBreaker, logger))
+		eps, err = endpoints.NewFastPath(svc, stages, logger, middlewares.NewPrometheusMetrics("add", "endpoint"), newObservability(cfg, logger))
+		if err != nil {
+			level.Error(logger).Log("env", cfg.MiddlewareOrder.Env, "err", err)
+			os.Exit(1)
+		}
+		eps = newCanaryMiddleware(cfg, eps, logger)
+		eps = newOpBudgetMiddleware(cfg, eps, logger)
+		eps = endpoints.FeatureFlagMiddleware(flags, eps)
+		eps = newExperimentMiddleware(cfg, eps, logger)
+		eps = newBulkheadMiddleware(cfg, eps, logger)
+		eps = newLoadSheddingMiddleware(cfg, eps, logger)
+		eps = newChaosMiddleware(cfg, eps, logger)
+		return nil
+	})
+	g.Provide("health", []string{"config"}, func(ctx context.Context) error {
+		hs = health.NewServer()
+		hs.SetServingStatus(cfg.ServiceName.Value, healthgrpc.HealthCheckResponse_SERVING)
+		if cfg.DriftPeers.Value != "" {
+			go newDriftChecker(cfg, status, logger)(ctx)
+		}
+		if cfg.CompatPeers.Value != "" {
+			go newCompatProbe(cfg, hs, logger)(ctx)
+		}
+		return nil
+	})
+	g.Provide("anomaly", []string{"config"}, func(ctx context.Context) error {
+		if cfg.AnomalyMetrics.Value != "" {
+			go newAnomalyEvaluator(cfg, logger)(ctx)
+		}
+		return nil
+	})
+	g.Provide("snapshot", []string{"lifecycle"}, func(ctx context.Context) error {
+		snapshots = newSnapshots(ctx, cfg, status, logger)
+		return nil
+	})
+	g.Provide("appengine", []string{"tracing", "repository", "snapshot"}, func(ctx context.Context) error {
+		ah = newAppEngineHooks(repo, tp, snapshots, logger)
+		return nil
+	})
+	g.Provide("downloads", []string{"state"}, func(ctx context.Context) error {
+		if cfg.DownloadTTL.Value != "0" {
+			downloads, downloadIssuer, maxDownload = newDownloads(cfg, state, logger)
+			exports = transports.ResumableExports(downloads, downloadIssuer, maxDownload)
+		}
+		return nil
+	})
+	g.Provide("deliveries", []string{"endpoints", "notify", "downloads"}, func(ctx context.Context) error {
+		deliveries = newDeliveries(cfg, eps, downloads, downloadIssuer, maxDownload, notifications, notifier, state, logger)
+		return nil
+	})
+	g.Provide("cron", []string{"repository", "snapshot", "deliveries"}, func(ctx context.Context) error {
+		jobs = newCronJobs(snapshots, dispatcher, deliveries, logger)
+		return nil
+	})
+	if err := g.Build(ctx, boot.Mark); err != nil {
+		if logger == nil {
+			logger = log.NewLogfmtLogger(os.Stderr)
+		}
+		level.Error(logger).Log("wiring", "build", "err", err)
+		os.Exit(1)
+	}
+	if *replayHistory {
+		if eventStore == nil {
+			level.Error(logger).Log("replay", "history", "err", fmt.Sprintf("%s not set", cfg.EventStore.Env))
+			os.Exit(1)
+		}
+		n, err := service.ReplayHistory(ctx, eventStore, repo)
+		if err != nil {
+			level.Error(logger).Log("replay", "history", "events", n, "err", err)
+			os.Exit(1)
+		}
+		level.Info(logger).Log("replay", "history", "events", n)
+		return
+	}
+	lc.Emit(ctx, lifecycle.Warmed)
+
+	wg := &sync.WaitGroup{}
+	listening := &sync.WaitGroup{}
+	listening.Add(2)
+
+	go startHTTPServer(ctx, wg, listening, eps, ids, cfg, status, snapshots, meter, ah, jobs, state, notifications, exports, deliveries, logger)
+	go startGRPCServer(ctx, wg, listening, eps, cfg.GRPCPort.Value, hs, logger)
+	go startPubSubServer(ctx, wg, eps, cfg, logger)
+	for _, start := range optionalServers {
+		go start(ctx, wg, eps, cfg, logger)
+	}
+	go func() {
+		listening.Wait()
+		boot.Mark("listen")
+		boot.Done(logger, kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: "add",
+			Subsystem: "startup",
+			Name:      "step_duration_seconds",
+			Help:      "Duration in seconds of the bootstrap steps of the instance, and their total.",
+		}, []string{"step"}))
+		lc.Emit(ctx, lifecycle.Serving)
+	}()
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	<-c
+
+	// ctx is about to be canceled, the last events must still get out
+	lc.Emit(context.Background(), lifecycle.Draining)
+	stopCtx, stopCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ah.Stop(stopCtx)
+	stopCancel()
+	cancel()
+	wg.Wait()
+	if err := tracing.Shutdown(tp, 5*time.Second); err != nil {
+		level.Warn(logger).Log("tracing", "shutdown", "err", err)
+	}
+	lc.Emit(context.Background(), lifecycle.Stopped)
+
+	fmt.Println("main: all goroutines have told us they've finished")
+}
+
+// newLogger returns the logger in the QS_ADD_LOG_FORMAT format, "logfmt" or
+// "stackdriver". When unset, App Engine gets the Cloud Logging JSON format.
+// It's created before the configuration is loaded, to report its errors.
+func newLogger(ctx context.Context) log.Logger {
+	format := os.Getenv("QS_ADD_LOG_FORMAT")
+	if format == "" {
+		format = "logfmt"
+		if os.Getenv("GAE_ENV") != "" {
+			format = "stackdriver"
+		}
+	}
+	if format != "stackdriver" {
+		return log.NewLogfmtLogger(os.Stderr)
+	}
+
+	projectID, _ := gcp.ProjectID(ctx)
+	labels := map[string]string{}
+	if v := os.Getenv("GAE_SERVICE"); v != "" {
+		labels["service"] = v
+	}
+	if v := os.Getenv("GAE_VERSION"); v != "" {
+		labels["version"] = v
+	}
+	return logger.NewStackdriverLogger(os.Stderr, projectID, labels)
+}
+
+// newErrorVerbosity returns the QS_ADD_ERROR_VERBOSITY of the errors,
+// "full" or "sanitized". When unset, App Engine gets sanitized errors,
+// except on the local development server.
+func newErrorVerbosity(cfg config.Config, logger log.Logger) errors.Verbosity {
+	name := cfg.ErrorVerbosity.Value
+	if name == "" {
+		name = "full"
+		if env := os.Getenv("GAE_ENV"); env != "" && env != "localdev" {
+			name = "sanitized"
+		}
+	}
+	v, err := errors.ParseVerbosity(name)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.ErrorVerbosity.Env, "err", err)
+		os.Exit(1)
+	}
+	return v
+}
+
+// writeResources writes the manifest of the GCP resources the configuration
+// of the environment expects to w: its Pub/Sub topics, Cloud Tasks queues,
+// secrets, Cloud Storage buckets and Datastore indexes. The secrets are
+// listed rather than accessed, so it runs without credentials.
+func writeResources(ctx context.Context, w io.Writer) error {
+	// the secrets are left as references, found in the values afterwards
+	cfg := loadConfig(ctx, log.NewLogfmtLogger(os.Stderr), expand.ResolverFunc(func(_ context.Context, name string) (string, error) {
+		return "${SECRET:" + name + "}", nil
+	}))
+	m := manifest.New(cfg.ServiceName.Value, service.Version)
+	for key, v := range cfg.Values() {
+		for _, ref := range secretRef.FindAllStringSubmatch(v, -1) {
+			m.Secrets = append(m.Secrets, manifest.Resource{Name: ref[1], Env: key, Purpose: "configuration value"})
+		}
+	}
+	if cfg.LifecycleTopic.Value != "" {
+		m.Topics = append(m.Topics, manifest.Resource{Name: cfg.LifecycleTopic.Value, Env: cfg.LifecycleTopic.Env, Purpose: "instance lifecycle events"})
+	}
+	if cfg.OutboxTopic.Value != "" {
+		m.Topics = append(m.Topics, manifest.Resource{Name: cfg.OutboxTopic.Value, Env: cfg.OutboxTopic.Env, Purpose: "domain events"})
+	}
+	if cfg.CaptureBucket.Value != "" && cfg.JWTKey.Value != "" {
+		m.Buckets = append(m.Buckets, manifest.Resource{Name: cfg.CaptureBucket.Value, Env: cfg.CaptureBucket.Env, Purpose: "wire-level captures"})
+	}
+	if cfg.CacheSnapshot.Value != "" {
+		bucket := strings.TrimPrefix(cfg.CacheSnapshot.Value, "gs://")
+		if i := strings.IndexByte(bucket, '/'); i > 0 {
+			bucket = bucket[:i]
+		}
+		m.Buckets = append(m.Buckets, manifest.Resource{Name: bucket, Env: cfg.CacheSnapshot.Env, Purpose: "cache snapshots"})
+	}
+	if cfg.HistoryStore.Value == "datastore" {
+		m.Indexes = append(m.Indexes, repository.DatastoreIndexes...)
+	}
+	return m.Write(w)
+}
 
-type config struct {
-	serviceName string `json:""`
-	logLevel    string `json:""`
-	serviceHost string `json:""`
-	httpPort    string `json:""`
-	grpcPort    string `json:""`
+// secretRef matches the secret references left in the configuration by
+// writeResources.
+var secretRef = regexp.MustCompile(`\$\{SECRET:([^}]+)\}`)
+
+// secretManager resolves the ${SECRET:name} references of the
+// configuration with Secret Manager.
+var secretManager = expand.ResolverFunc(func(ctx context.Context, name string) (string, error) {
+	projectID, err := gcp.ProjectID(ctx)
+	if err != nil {
+		return "", err
+	}
+	return gcp.NewSecretAccessor(projectID).Access(ctx, name)
+})
+
+// loadConfig reads the configuration from the environment, expanding
+// references such as ${GAE_SERVICE} or ${SECRET:jwt-key} in the values, the
+// secrets resolved by secrets.
+func loadConfig(ctx context.Context, logger log.Logger, secrets expand.Resolver) config.Config {
+	cfg, err := config.Load(ctx, secrets)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		os.Exit(1)
+	}
+	return cfg
+}
+
+// instanceName returns the name of the App Engine instance, the host name
+// elsewhere.
+func instanceName() string {
+	if instance := os.Getenv("GAE_INSTANCE"); instance != "" {
+		return instance
+	}
+	instance, _ := os.Hostname()
+	return instance
+}
+
+// newStatus fingerprints the effective configuration and exports it as the
+// add_config_info metric.
+func newStatus(cfg config.Config) drift.Status {
+	version := os.Getenv("GAE_VERSION")
+	if version == "" {
+		version = service.Version
+	}
+	status := drift.Status{
+		Instance:    instanceName(),
+		Version:     version,
+		Fingerprint: drift.Fingerprint(cfg.Values()),
+		Contract:    transports.ContractVersion,
+		StartedAt:   time.Now().UTC(),
+	}
+	kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+		Namespace: "add",
+		Subsystem: "config",
+		Name:      "info",
+		Help:      "Fingerprint of the effective configuration, always 1.",
+	}, []string{"version", "fingerprint"}).With("version", status.Version, "fingerprint", status.Fingerprint).Set(1)
+	return status
+}
+
+// newLifecycleRecorder returns a lifecycle.Recorder logging the lifecycle
+// events, and publishing them to the configured Pub/Sub topic if any.
+func newLifecycleRecorder(ctx context.Context, cfg config.Config, status drift.Status, logger log.Logger) *lifecycle.Recorder {
+	var publisher lifecycle.Publisher
+	if cfg.LifecycleTopic.Value != "" {
+		projectID, err := gcp.ProjectID(ctx)
+		if err != nil {
+			level.Error(logger).Log("env", cfg.LifecycleTopic.Env, "err", err)
+			os.Exit(1)
+		}
+		publisher = gcp.NewPublisher(projectID, cfg.LifecycleTopic.Value)
+	}
+	return lifecycle.NewRecorder(ctx, status.Instance, status.Version, publisher, logger)
+}
+
+// newTracerProvider installs the OpenTelemetry tracer provider exporting
+// to the configured backend.
+func newTracerProvider(ctx context.Context, cfg config.Config, status drift.Status, logger log.Logger) trace.TracerProvider {
+	ratio, err := strconv.ParseFloat(cfg.TraceSampleRatio.Value, 64)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.TraceSampleRatio.Env, "err", err)
+		os.Exit(1)
+	}
+	endpoint := cfg.TraceEndpoint.Value
+	if cfg.TraceExporter.Value == tracing.ExporterCloudTrace && endpoint == "" {
+		if endpoint, err = gcp.ProjectID(ctx); err != nil {
+			level.Error(logger).Log("env", cfg.TraceEndpoint.Env, "err", err)
+			os.Exit(1)
+		}
+	}
+	tp, err := tracing.NewTracerProvider(tracing.Config{
+		ServiceName: cfg.ServiceName.Value,
+		Version:     status.Version,
+		Exporter:    cfg.TraceExporter.Value,
+		Endpoint:    endpoint,
+		SampleRatio: ratio,
+	})
+	if err != nil {
+		level.Error(logger).Log("env", cfg.TraceExporter.Env, "err", err)
+		os.Exit(1)
+	}
+	return tp
+}
+
+// privilegedQuota returns the per caller quota of the privileged headers,
+// burst uses refilled one every interval.
+func privilegedQuota(cfg config.Config, logger log.Logger) (burst int, interval time.Duration) {
+	interval, err := time.ParseDuration(cfg.PrivilegedInterval.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.PrivilegedInterval.Env, "err", err)
+		os.Exit(1)
+	}
+	burst, err = strconv.Atoi(cfg.PrivilegedBurst.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.PrivilegedBurst.Env, "err", err)
+		os.Exit(1)
+	}
+	return burst, interval
+}
+
+// newAuthStage returns the factory of the auth stage of the endpoints,
+// verifying the JWT of the callers with newAuthn. It's disabled when
+// QS_ADD_JWT_KEY is not set.
+func newAuthStage(cfg config.Config, logger log.Logger) chain.Factory {
+	authn := newAuthn(cfg, logger)
+	if authn == nil {
+		return nil
+	}
+	return endpoints.AuthStage(authn)
+}
+
+// newRateLimitStage returns the factory of the ratelimit stage of the
+// endpoints: the daily quota of meter, and the quota of the privileged
+// headers of privilegedQuota.
+func newRateLimitStage(cfg config.Config, meter *metering.Meter, logger log.Logger) chain.Factory {
+	burst, interval := privilegedQuota(cfg, logger)
+	uses := kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "add",
+		Subsystem: "privileged",
+		Name:      "uses_total",
+		Help:      "Requests carrying privileged headers, by outcome.",
+	}, []string{"outcome"})
+	lim := limiter.NewKeyed(clock.System, limiter.Every(interval), burst, time.Duration(burst)*interval)
+	meter.SetRateLimits(metering.RateLimit{Scope: "privileged-headers", Burst: burst, Interval: interval.String()})
+	return endpoints.RateLimitStage(meter.Middleware, privileged.Middleware(lim, uses, newAuthn(cfg, logger), log.With(logger, "component", "privileged")))
+}
+
+// newObservability returns the stages the endpoints are observed with: all
+// of them, unless QS_ADD_FAST_PATH leaves out the ones recording nothing,
+// the info logs unless QS_ADD_LOG_LEVEL is debug or info, the tracing when
+// QS_ADD_TRACE_EXPORTER is none, and the metrics when
+// QS_ADD_ENDPOINT_METRICS is false.
+func newObservability(cfg config.Config, logger log.Logger) endpoints.Observability {
+	fast, err := strconv.ParseBool(cfg.FastPath.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.FastPath.Env, "err", err)
+		os.Exit(1)
+	}
+	metrics, err := strconv.ParseBool(cfg.EndpointMetrics.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.EndpointMetrics.Env, "err", err)
+		os.Exit(1)
+	}
+	if !fast {
+		return endpoints.FullObservability
+	}
+	return endpoints.Observability{
+		Logging: cfg.LogLevel.Value == "debug" || cfg.LogLevel.Value == "info",
+		Tracing: cfg.TraceExporter.Value != tracing.ExporterNone && cfg.TraceExporter.Value != "",
+		Metrics: metrics,
+	}
+}
+
+// newAuthn returns the middleware verifying the JWT of the callers signed
+// with QS_ADD_JWT_KEY, tolerating QS_ADD_JWT_LEEWAY of clock skew on their
+// time claims, nil when the key is not set.
+func newAuthn(cfg config.Config, logger log.Logger) endpoint.Middleware {
+	if cfg.JWTKey.Value == "" {
+		return nil
+	}
+	leeway, err := time.ParseDuration(cfg.JWTLeeway.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.JWTLeeway.Env, "err", err)
+		os.Exit(1)
+	}
+	return auth.NewParser(func(*stdjwt.Token) (interface{}, error) {
+		return []byte(cfg.JWTKey.Value), nil
+	}, stdjwt.SigningMethodHS256, clock.System, leeway)
+}
+
+// newMeter returns the meter of the usage of the authenticated callers,
+// allowing them QS_ADD_DAILY_QUOTA requests a day, and reporting their usage
+// of the last QS_ADD_USAGE_DAYS days at metering.Path. The usage is counted
+// in the Redis server of QS_ADD_METERING_REDIS, for the quota to apply to
+// all the instances, or else in the memory of each.
+func newMeter(cfg config.Config, logger log.Logger) *metering.Meter {
+	quota, err := strconv.ParseInt(cfg.DailyQuota.Value, 10, 64)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.DailyQuota.Env, "err", err)
+		os.Exit(1)
+	}
+	days, err := strconv.Atoi(cfg.UsageDays.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.UsageDays.Env, "err", err)
+		os.Exit(1)
+	}
+	store, scope := metering.NewMemoryStore(), metering.ScopeInstance
+	if cfg.MeteringRedis.Value != "" {
+		store, scope = metering.NewRedisStore(cfg.MeteringRedis.Value), metering.ScopeGlobal
+	}
+	return metering.New(instanceName(), store, scope, quota, days, newAuthn(cfg, logger), log.With(logger, "component", "metering"))
+}
+
+// newCanaryMiddleware routes QS_ADD_CANARY_PERCENT of the Sum and Concat
+// calls to the deployment of QS_ADD_CANARY_URL, or, when
+// QS_ADD_CANARY_SHADOW is set, calls it as well to compare its responses
+// with those served, within QS_ADD_CANARY_SHADOW_TIMEOUT. The URL is a
+// discovery.NewInstancer specification: a base URL, a list of them, or the
+// dnssrv:// or dns:// name of the instances, the calls being balanced over
+// them but not retried, so their failures count. The calls carry the
+// caller's JWT exchanged by newTokenExchange. No URL disables it.
+func newCanaryMiddleware(cfg config.Config, eps endpoints.Endpoints, logger log.Logger) endpoints.Endpoints {
+	if cfg.CanaryURL.Value == "" {
+		return eps
+	}
+	percent, err := strconv.ParseFloat(cfg.CanaryPercent.Value, 64)
+	if err != nil || percent < 0 || percent > 100 {
+		if err == nil {
+			err = fmt.Errorf("%v not a percentage", percent)
+		}
+		level.Error(logger).Log("env", cfg.CanaryPercent.Env, "err", err)
+		os.Exit(1)
+	}
+	shadow, err := strconv.ParseBool(cfg.CanaryShadow.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.CanaryShadow.Env, "err", err)
+		os.Exit(1)
+	}
+	timeout, err := time.ParseDuration(cfg.CanaryShadowTimeout.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.CanaryShadowTimeout.Env, "err", err)
+		os.Exit(1)
+	}
+	instancer, err := discovery.NewInstancer(cfg.CanaryURL.Value, 0, log.With(logger, "component", "canary"))
+	if err != nil {
+		level.Error(logger).Log("env", cfg.CanaryURL.Env, "err", err)
+		os.Exit(1)
+	}
+	d := transports.Discovery{Instancer: instancer, Attempts: 1}
+	client, err := transports.NewHTTPDiscoveryClient(d, transports.HTTPClientOptions{TokenExchange: newTokenExchange(cfg, logger)}, nil, nil, logger)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.CanaryURL.Env, "err", err)
+		os.Exit(1)
+	}
+	var options []canary.Option
+	if shadow {
+		options = append(options, canary.Shadow(timeout))
+	}
+	c := canary.New(percent, kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "add",
+		Subsystem: "canary",
+		Name:      "calls_total",
+		Help:      "Calls by method and target: primary, canary or shadow.",
+	}, []string{"method", "target"}), kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "add",
+		Subsystem: "canary",
+		Name:      "comparisons_total",
+		Help:      "Shadow calls by method and result: match, diverged or error.",
+	}, []string{"method", "result"}), log.With(logger, "component", "canary"), options...)
+	alt := endpoints.Endpoints{
+		SumEndpoint:    endpoints.MakeSumEndpoint(client),
+		ConcatEndpoint: endpoints.MakeConcatEndpoint(client),
+	}
+	return endpoints.CanaryMiddleware(c.Middleware, alt, eps)
+}
+
+// newTokenExchange returns the exchange of the caller's JWT for a token of
+// QS_ADD_TOKEN_EXCHANGE_AUDIENCE and QS_ADD_TOKEN_EXCHANGE_SCOPES, space
+// separated, at the RFC 8693 token endpoint QS_ADD_TOKEN_EXCHANGE_URL, or nil
+// when unset: the downstream calls then carry no JWT. The exchanged tokens
+// are cached and every exchange is audited.
+func newTokenExchange(cfg config.Config, logger log.Logger) *transports.TokenExchange {
+	if cfg.TokenExchangeURL.Value == "" {
+		return nil
+	}
+	if cfg.TokenExchangeAudience.Value == "" {
+		level.Error(logger).Log("env", cfg.TokenExchangeAudience.Env, "err", "required with "+cfg.TokenExchangeURL.Env)
+		os.Exit(1)
+	}
+	skew, err := time.ParseDuration(cfg.TokenExchangeCacheSkew.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.TokenExchangeCacheSkew.Env, "err", err)
+		os.Exit(1)
+	}
+	var exchanger tokenexchange.Exchanger
+	{
+		exchanger = tokenexchange.NewHTTPExchanger(cfg.TokenExchangeURL.Value, cfg.TokenExchangeClientID.Value, cfg.TokenExchangeClientSecret.Value, nil)
+		exchanger = tokenexchange.AuditMiddleware(logger)(exchanger)
+		exchanger = tokenexchange.CachingMiddleware(skew)(exchanger)
+	}
+	return &transports.TokenExchange{
+		Exchanger: exchanger,
+		Audience:  cfg.TokenExchangeAudience.Value,
+		Scopes:    strings.Fields(cfg.TokenExchangeScopes.Value),
+	}
+}
+
+// newOpBudgetMiddleware counts the history store operations of every request
+// against QS_ADD_OP_BUDGET, logging the requests over it, or failing them
+// when QS_ADD_OP_BUDGET_STRICT is set, as in dev, so that an endpoint
+// querying per item shows up before production. A budget of 0 disables it.
+func newOpBudgetMiddleware(cfg config.Config, eps endpoints.Endpoints, logger log.Logger) endpoints.Endpoints {
+	budget, err := strconv.Atoi(cfg.OpBudget.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.OpBudget.Env, "err", err)
+		os.Exit(1)
+	}
+	if budget == 0 {
+		return eps
+	}
+	strict, err := strconv.ParseBool(cfg.OpBudgetStrict.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.OpBudgetStrict.Env, "err", err)
+		os.Exit(1)
+	}
+	exceeded := kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "add",
+		Subsystem: "opbudget",
+		Name:      "exceeded_total",
+		Help:      "Requests doing more store operations than their budget.",
+	}, []string{"method"})
+	logger = log.With(logger, "component", "opbudget")
+	return endpoints.OpBudgetMiddleware(func(method string) endpoint.Middleware {
+		return opbudget.Middleware(method, budget, strict, exceeded.With("method", method), logger)
+	}, eps)
+}
+
+// newExperimentMiddleware splits the requests between the variants of the
+// experiment of QS_ADD_EXPERIMENT, e.g. "history-v2:control=90,treatment=10",
+// see experiment.Parse. None disables it.
+func newExperimentMiddleware(cfg config.Config, eps endpoints.Endpoints, logger log.Logger) endpoints.Endpoints {
+	if cfg.Experiment.Value == "" {
+		return eps
+	}
+	e, err := experiment.Parse(cfg.Experiment.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.Experiment.Env, "err", err)
+		os.Exit(1)
+	}
+	requests := kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "add",
+		Subsystem: "experiment",
+		Name:      "requests_total",
+		Help:      "Requests by experiment variant, method and status.",
+	}, []string{"experiment", "variant", "method", "status"})
+	duration := kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+		Namespace: "add",
+		Subsystem: "experiment",
+		Name:      "request_duration_seconds",
+		Help:      "Request duration in seconds by experiment variant and method.",
+		Buckets:   stdprometheus.DefBuckets,
+	}, []string{"experiment", "variant", "method"})
+	return endpoints.ExperimentMiddleware(func(method string) endpoint.Middleware {
+		return e.Middleware(method, requests, duration)
+	}, eps)
+}
+
+// newBulkheadMiddleware caps the requests in flight of every endpoint to
+// QS_ADD_BULKHEAD_LIMIT, queuing up to QS_ADD_BULKHEAD_QUEUE of them for
+// QS_ADD_BULKHEAD_MAX_WAIT and shedding the others. A limit of 0 disables
+// it.
+func newBulkheadMiddleware(cfg config.Config, eps endpoints.Endpoints, logger log.Logger) endpoints.Endpoints {
+	limit, err := strconv.Atoi(cfg.BulkheadLimit.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.BulkheadLimit.Env, "err", err)
+		os.Exit(1)
+	}
+	if limit == 0 {
+		return eps
+	}
+	queue, err := strconv.Atoi(cfg.BulkheadQueue.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.BulkheadQueue.Env, "err", err)
+		os.Exit(1)
+	}
+	maxWait, err := time.ParseDuration(cfg.BulkheadMaxWait.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.BulkheadMaxWait.Env, "err", err)
+		os.Exit(1)
+	}
+	rejected := kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "add",
+		Subsystem: "bulkhead",
+		Name:      "rejected_total",
+		Help:      "Requests shed as their endpoint had too many in flight.",
+	}, []string{"method"})
+	return endpoints.BulkheadMiddleware(func(method string) endpoint.Middleware {
+		return bulkhead.New(limit, queue, maxWait).Middleware(rejected.With("method", method))
+	}, eps)
+}
+
+// newLoadSheddingMiddleware sheds the requests past a concurrency limit
+// adapted to their latency, starting at QS_ADD_LOADSHED_LIMIT and never
+// above QS_ADD_LOADSHED_MAX_LIMIT. The limit is shared by the endpoints, the
+// load being the one of the instance. A limit of 0 disables it.
+func newLoadSheddingMiddleware(cfg config.Config, eps endpoints.Endpoints, logger log.Logger) endpoints.Endpoints {
+	initial, err := strconv.Atoi(cfg.LoadShedLimit.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.LoadShedLimit.Env, "err", err)
+		os.Exit(1)
+	}
+	if initial == 0 {
+		return eps
+	}
+	max, err := strconv.Atoi(cfg.LoadShedMaxLimit.Value)
+	if err != nil || max < initial {
+		if err == nil {
+			err = fmt.Errorf("below %s %d", cfg.LoadShedLimit.Env, initial)
+		}
+		level.Error(logger).Log("env", cfg.LoadShedMaxLimit.Env, "err", err)
+		os.Exit(1)
+	}
+	shed := kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "add",
+		Subsystem: "loadshed",
+		Name:      "shed_total",
+		Help:      "Requests shed as the instance was at its concurrency limit.",
+	}, []string{"method"})
+	limit := kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+		Namespace: "add",
+		Subsystem: "loadshed",
+		Name:      "limit",
+		Help:      "Concurrency limit adapted to the latency of the requests.",
+	}, []string{})
+	limit.Set(float64(initial))
+	limiter := loadshed.New(initial, 1, max)
+	return endpoints.LoadSheddingMiddleware(func(method string) endpoint.Middleware {
+		return limiter.Middleware(shed.With("method", method), limit)
+	}, eps)
+}
+
+// newChaosMiddleware returns the endpoints injecting the faults of
+// QS_ADD_CHAOS, e.g. "latency=300ms,latency_percent=25,error=10,abort=2",
+// into the requests carrying the chaos header unless
+// QS_ADD_CHAOS_REQUIRE_HEADER is false. Meant for staging: faults are never
+// injected when QS_ADD_CHAOS is unset, whatever the requests ask for.
+func newChaosMiddleware(cfg config.Config, eps endpoints.Endpoints, logger log.Logger) endpoints.Endpoints {
+	if cfg.Chaos.Value == "" {
+		return eps
+	}
+	spec, err := chaos.ParseSpec(cfg.Chaos.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.Chaos.Env, "err", err)
+		os.Exit(1)
+	}
+	requireHeader, err := strconv.ParseBool(cfg.ChaosRequireHeader.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.ChaosRequireHeader.Env, "err", err)
+		os.Exit(1)
+	}
+	maxLatency, err := time.ParseDuration(cfg.ChaosMaxLatency.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.ChaosMaxLatency.Env, "err", err)
+		os.Exit(1)
+	}
+	level.Warn(logger).Log("chaos", cfg.Chaos.Value, "require_header", requireHeader)
+	c := chaos.New(spec, requireHeader, maxLatency, kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "add",
+		Subsystem: "chaos",
+		Name:      "injected_total",
+		Help:      "Faults injected by method and fault: latency, error or abort.",
+	}, []string{"method", "fault"}))
+	return endpoints.ChaosMiddleware(c.Middleware, eps)
+}
+
+// newIDGenerator returns the generator of QS_ADD_ID_GENERATOR, uuidv7, ulid
+// or sonyflake, the instances generating sonyflake IDs told apart by
+// QS_ADD_ID_MACHINE_ID, derived from their private IP address by default.
+func newIDGenerator(cfg config.Config, logger log.Logger) id.Generator {
+	machineID := id.MachineID()
+	if cfg.IDMachineID.Value != "" {
+		n, err := strconv.ParseUint(cfg.IDMachineID.Value, 10, 16)
+		if err != nil {
+			level.Error(logger).Log("env", cfg.IDMachineID.Env, "err", err)
+			os.Exit(1)
+		}
+		machineID = uint16(n)
+	}
+	ids, err := id.NewGenerator(cfg.IDGenerator.Value, clock.System, machineID)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.IDGenerator.Env, "err", err)
+		os.Exit(1)
+	}
+	return ids
+}
+
+// newHistoryBreaker returns the breaker guarding the history store.
+func newHistoryBreaker(cfg config.Config, logger log.Logger) *breaker.Breaker {
+	threshold, err := strconv.Atoi(cfg.BreakerThreshold.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.BreakerThreshold.Env, "err", err)
+		os.Exit(1)
+	}
+	cooldown, err := time.ParseDuration(cfg.BreakerCooldown.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.BreakerCooldown.Env, "err", err)
+		os.Exit(1)
+	}
+	return breaker.New(repository.Dependency, threshold, cooldown)
+}
+
+// newCircuitBreakerStage returns the factory of the circuitbreaker stage of
+// the endpoints, applying their fallbacks while the history store is down,
+// see endpoints.DegradeStage. The history records deferred meanwhile are
+// saved once it's back.
+func newCircuitBreakerStage(ctx context.Context, cfg config.Config, history *breaker.Breaker, logger log.Logger) chain.Factory {
+	size, err := strconv.Atoi(cfg.DegradeQueueSize.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.DegradeQueueSize.Env, "err", err)
+		os.Exit(1)
+	}
+	degraded := kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "add",
+		Subsystem: "degrade",
+		Name:      "requests_total",
+		Help:      "Requests served degraded, by method, dependency down and fallback mode.",
+	}, []string{"method", "dependency", "mode"})
+	policy := degrade.NewPolicy(func(dependency string) bool {
+		return dependency == history.Name() && history.Open()
+	}, degraded)
+	queue := degrade.NewQueue(size)
+	go queue.Run(ctx, func() bool { return !history.Open() }, 5*time.Second, log.With(logger, "component", "degrade"))
+	return endpoints.DegradeStage(policy, queue)
+}
+
+func newDriftChecker(cfg config.Config, status drift.Status, logger log.Logger) func(context.Context) {
+	interval, err := time.ParseDuration(cfg.DriftInterval.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.DriftInterval.Env, "err", err)
+		os.Exit(1)
+	}
+	drifted := kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+		Namespace: "add",
+		Subsystem: "config",
+		Name:      "drifted",
+		Help:      "1 when instances of this version serve with different configurations.",
+	}, []string{})
+	checker := drift.NewChecker(status, drift.NewHTTPPeers(strings.Split(cfg.DriftPeers.Value, ","), nil), drifted, logger)
+	return func(ctx context.Context) {
+		checker.Run(ctx, interval)
+	}
+}
+
+// newCompatProbe returns the probe of the contract served by the services at
+// QS_ADD_COMPAT_PEERS, which the clients of package transports call. The
+// instance is reported not serving while one of them is incompatible.
+func newCompatProbe(cfg config.Config, hs *health.Server, logger log.Logger) func(context.Context) {
+	interval, err := time.ParseDuration(cfg.CompatInterval.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.CompatInterval.Env, "err", err)
+		os.Exit(1)
+	}
+	want, err := compat.Parse(transports.ContractVersion)
+	if err != nil {
+		level.Error(logger).Log("compat", "contract", "err", err)
+		os.Exit(1)
+	}
+	incompatible := kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+		Namespace: "add",
+		Subsystem: "compat",
+		Name:      "incompatible",
+		Help:      "1 when the downstream service serves a contract the client can't call.",
+	}, []string{"target"})
+	probe := compat.NewProbe(want, strings.Split(cfg.CompatPeers.Value, ","), incompatible, log.With(logger, "component", "compat"))
+	probe.OnChange(func(ready bool) {
+		status := healthgrpc.HealthCheckResponse_SERVING
+		if !ready {
+			status = healthgrpc.HealthCheckResponse_NOT_SERVING
+		}
+		hs.SetServingStatus(cfg.ServiceName.Value, status)
+	})
+	return func(ctx context.Context) {
+		probe.Run(ctx, interval)
+	}
+}
+
+// anomalyWindow is the number of rounds the anomaly detectors remember.
+const anomalyWindow = 60
+
+// newAnomalyEvaluator returns the evaluator of the metrics listed in
+// QS_ADD_ANOMALY_METRICS, alerting the webhooks of QS_ADD_ANOMALY_WEBHOOKS
+// of their anomalies.
+func newAnomalyEvaluator(cfg config.Config, logger log.Logger) func(context.Context) {
+	interval, err := time.ParseDuration(cfg.AnomalyInterval.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.AnomalyInterval.Env, "err", err)
+		os.Exit(1)
+	}
+	if _, err := anomaly.NewDetector(cfg.AnomalyDetector.Value, anomalyWindow); err != nil {
+		level.Error(logger).Log("env", cfg.AnomalyDetector.Env, "err", err)
+		os.Exit(1)
+	}
+	var channels []notify.Channel
+	if cfg.AnomalyWebhooks.Value != "" {
+		for _, target := range strings.Split(cfg.AnomalyWebhooks.Value, ",") {
+			channels = append(channels, notify.Channel{Type: notify.Webhook, Target: target, Verified: true})
+		}
+	}
+	alerts := kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "add",
+		Subsystem: "anomaly",
+		Name:      "alerts_total",
+		Help:      "Number of anomalies detected in the business metrics.",
+	}, []string{"metric"})
+	sender := notify.NewWebhookSender(10 * time.Second)
+	logger = log.With(logger, "component", "anomaly")
+
+	evaluator := anomaly.NewEvaluator(
+		anomaly.PrometheusSource(stdprometheus.DefaultGatherer, strings.Split(cfg.AnomalyMetrics.Value, ",")...),
+		func() anomaly.Detector {
+			d, _ := anomaly.NewDetector(cfg.AnomalyDetector.Value, anomalyWindow)
+			return d
+		},
+		func(ctx context.Context, a anomaly.Alert) {
+			alerts.With("metric", a.Metric).Add(1)
+			if err := notify.Deliver(ctx, sender, channels, notify.Event{Type: "anomaly", Time: a.Time, Data: a}); err != nil {
+				level.Warn(logger).Log("anomaly", a.Metric, "err", err)
+			}
+		},
+		logger,
+	)
+	return func(ctx context.Context) {
+		evaluator.Run(ctx, interval)
+	}
+}
+
+// NewServer returns the add service, keying its records and events with ids,
+// counting its business metrics and rejecting the calls without tenant when
+// requireTenant is set, and the ones of the tenants unknown to tenants
+// unless nil.
+func NewServer(repo repository.Repository, ids id.Generator, events outbox.Store, eventStore eventstore.Store, requireTenant bool, tenants tenant.Directory, logger log.Logger) service.AddService {
+	svc := service.New(repo, ids, logger)
+	if events != nil {
+		svc = service.OutboxMiddleware(events, ids)(svc)
+	}
+	if eventStore != nil {
+		svc = service.EventSourcingMiddleware(eventStore)(svc)
+	}
+	svc = service.KPIMiddleware(kpi.New("add"))(svc)
+	if requireTenant {
+		svc = service.TenantMiddleware(tenants)(svc)
+	}
+	return svc
+}
+
+func newRepository(ctx context.Context, cfg config.Config, logger log.Logger) repository.Repository {
+	if cfg.IndexFile.Value != "" {
+		checkIndexes(cfg.IndexFile, logger)
+	}
+	switch cfg.HistoryStore.Value {
+	case "datastore":
+		projectID, err := gcp.ProjectID(ctx)
+		if err != nil {
+			level.Error(logger).Log("history", "datastore", "err", err)
+			os.Exit(1)
+		}
+		window, err := time.ParseDuration(cfg.BatchWindow.Value)
+		if err != nil {
+			level.Error(logger).Log("env", cfg.BatchWindow.Env, "err", err)
+			os.Exit(1)
+		}
+		// a Datastore commit holds up to 500 mutations
+		size, err := strconv.Atoi(cfg.BatchSize.Value)
+		if err != nil || size < 1 || size > 500 {
+			level.Error(logger).Log("env", cfg.BatchSize.Env, "err", "want a size within 1 and 500")
+			os.Exit(1)
+		}
+		namespace := datastoreNamespace(cfg, logger)
+		if namespace != cfg.DatastoreNamespace.Value {
+			level.Info(logger).Log("history", "datastore", "namespace", namespace, "profile", cfg.Profile.Value)
+		}
+		return repository.NewBatchingRepository(ctx, repository.NewDatastoreRepository(projectID, namespace), window, size)
+	case "memory":
+		return repository.NewMemoryRepository()
+	default:
+		level.Error(logger).Log("env", cfg.HistoryStore.Env, "err", "unknown history store "+cfg.HistoryStore.Value)
+		os.Exit(1)
+		return nil
+	}
+}
+
+// datastoreNamespace returns the Datastore namespace of the profile of the
+// deployment, see repository.ProfileNamespace.
+func datastoreNamespace(cfg config.Config, logger log.Logger) string {
+	namespace, err := repository.ProfileNamespace(cfg.DatastoreNamespace.Value, cfg.Profile.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.Profile.Env, "err", err)
+		os.Exit(1)
+	}
+	return namespace
+}
+
+// newOutbox returns the outbox the domain events are written to, in
+// Datastore along with the history when it's stored there, and the
+// dispatcher publishing them to QS_ADD_OUTBOX_TOPIC, and delivering them to
+// the channels of their caller with notifier unless nil, every
+// QS_ADD_OUTBOX_INTERVAL, 0 leaving it to the outbox cron job. It returns
+// nils when there's neither.
+func newOutbox(ctx context.Context, cfg config.Config, notifier *notify.Notifier, logger log.Logger) (outbox.Store, *outbox.Dispatcher) {
+	if cfg.OutboxTopic.Value == "" && notifier == nil {
+		return nil, nil
+	}
+	interval, err := time.ParseDuration(cfg.OutboxInterval.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.OutboxInterval.Env, "err", err)
+		os.Exit(1)
+	}
+	store := outbox.NewMemoryStore()
+	var publisher outbox.Publisher
+	if cfg.OutboxTopic.Value != "" || cfg.HistoryStore.Value == "datastore" {
+		projectID, err := gcp.ProjectID(ctx)
+		if err != nil {
+			level.Error(logger).Log("env", cfg.OutboxTopic.Env, "err", err)
+			os.Exit(1)
+		}
+		if cfg.OutboxTopic.Value != "" {
+			publisher = gcp.NewPublisher(projectID, cfg.OutboxTopic.Value)
+		}
+		if cfg.HistoryStore.Value == "datastore" {
+			store = outbox.NewDatastoreStore(projectID, datastoreNamespace(cfg, logger))
+		}
+	}
+	if notifier != nil {
+		publisher = service.NotifyingPublisher(publisher, notifier, log.With(logger, "component", "notify"))
+	}
+	d := outbox.NewDispatcher(store, publisher, 100, kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "add",
+		Subsystem: "outbox",
+		Name:      "published_total",
+		Help:      "Domain events published from the outbox, by result.",
+	}, []string{"result"}), log.With(logger, "component", "outbox"))
+	if interval > 0 {
+		go d.Run(ctx, interval)
+	}
+	return store, d
 }
 
-// Env reads specified environment variable. If no value has been found,
-// fallback is returned.
-func env(key string, fallback string) string {
-	if v := os.Getenv(key); v != "" {
-		return v
+// newTenantDirectory returns the directory of the tenants the service
+// serves, when QS_ADD_TENANT_DIRECTORY is datastore: the Tenant entities of
+// state, read through a cache keeping them fresh QS_ADD_TENANT_CACHE_TTL,
+// plus up to QS_ADD_TENANT_CACHE_JITTER, and serving them stale
+// QS_ADD_TENANT_CACHE_STALE longer while they're reloaded. It returns nil
+// when not set, any tenant being served.
+func newTenantDirectory(cfg config.Config, state *gcp.Datastore, logger log.Logger) tenant.Directory {
+	switch cfg.TenantDirectory.Value {
+	case "":
+		return nil
+	case "datastore":
+	default:
+		level.Error(logger).Log("env", cfg.TenantDirectory.Env, "err", "unknown tenant directory "+cfg.TenantDirectory.Value)
+		os.Exit(1)
+	}
+	if state == nil {
+		level.Error(logger).Log("env", cfg.TenantDirectory.Env, "err", fmt.Sprintf("%s must be datastore", cfg.StateStore.Env))
+		os.Exit(1)
+	}
+	ttl, err := time.ParseDuration(cfg.TenantCacheTTL.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.TenantCacheTTL.Env, "err", err)
+		os.Exit(1)
+	}
+	jitter, err := time.ParseDuration(cfg.TenantCacheJitter.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.TenantCacheJitter.Env, "err", err)
+		os.Exit(1)
+	}
+	stale, err := time.ParseDuration(cfg.TenantCacheStale.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.TenantCacheStale.Env, "err", err)
+		os.Exit(1)
 	}
-	return fallback
+	lookups := kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "add",
+		Subsystem: "cache",
+		Name:      "lookups_total",
+		Help:      "Lookups of the read-through caches, by cache and result: hit, miss or stale.",
+	}, []string{"cache", "result"})
+	return tenant.NewCachedDirectory(tenant.NewDatastoreDirectory(state), cache.Options{TTL: ttl, Jitter: jitter, Stale: stale}, lookups)
 }
 
-func main() {
-	var logger log.Logger
-	{
-		logger = log.NewLogfmtLogger(os.Stderr)
-		logger = level.NewFilter(logger, level.AllowInfo())
-		logger = log.With(logger, "ts", log.DefaultTimestampUTC)
-		logger = log.With(logger, "caller", log.DefaultCaller)
+// newStateStore returns the Datastore of the state the instances share,
+// such as the nonces of the signed requests or the sessions, when
+// QS_ADD_STATE_STORE is datastore, or nil when it's memory, every instance
+// keeping its own.
+func newStateStore(ctx context.Context, cfg config.Config, logger log.Logger) *gcp.Datastore {
+	switch cfg.StateStore.Value {
+	case "memory":
+		return nil
+	case "datastore":
+		projectID, err := gcp.ProjectID(ctx)
+		if err != nil {
+			level.Error(logger).Log("env", cfg.StateStore.Env, "err", err)
+			os.Exit(1)
+		}
+		return gcp.NewDatastore(projectID, datastoreNamespace(cfg, logger))
+	default:
+		level.Error(logger).Log("env", cfg.StateStore.Env, "err", "unknown state store "+cfg.StateStore.Value)
+		os.Exit(1)
+		return nil
 	}
-	cfg := loadConfig(logger)
-	logger = log.With(logger, "service", cfg.serviceName)
-	level.Info(logger).Log("version", service.Version, "commitHash", service.CommitHash, "buildTimeStamp", service.BuildTimeStamp)
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	service := NewServer(logger)
-	endpoints := endpoints.New(service, logger)
-
-	hs := health.NewServer()
-	hs.SetServingStatus(cfg.serviceName, healthgrpc.HealthCheckResponse_SERVING)
-
-	wg := &sync.WaitGroup{}
-
-	go startHTTPServer(ctx, wg, endpoints, cfg.httpPort, logger)
-	go startGRPCServer(ctx, wg, endpoints, cfg.grpcPort, hs, logger)
-
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	<-c
-
-	cancel()
-	wg.Wait()
-
-	fmt.Println("main: all goroutines have told us they've finished")
 }
 
-func loadConfig(logger log.Logger) (cfg config) {
-	cfg.serviceName = env(envServiceName, defServiceName)
-	cfg.logLevel = env(envLogLevel, defLogLevel)
-	cfg.serviceHost = env(envServiceHost, defServiceHost)
-	cfg.httpPort = env(envHTTPPort, defHTTPPort)
-	cfg.grpcPort = env(envGRPCPort, defGRPCPort)
-	return cfg
+// newEventStore returns the event store of QS_ADD_EVENT_STORE the
+// calculations are recorded to, memory or datastore, along the history
+// entities in the latter, or nil when not set.
+func newEventStore(ctx context.Context, cfg config.Config, logger log.Logger) eventstore.Store {
+	switch cfg.EventStore.Value {
+	case "":
+		return nil
+	case "memory":
+		return eventstore.NewMemoryStore()
+	case "datastore":
+		projectID, err := gcp.ProjectID(ctx)
+		if err != nil {
+			level.Error(logger).Log("env", cfg.EventStore.Env, "err", err)
+			os.Exit(1)
+		}
+		return eventstore.NewDatastoreStore(projectID, datastoreNamespace(cfg, logger))
+	default:
+		level.Error(logger).Log("env", cfg.EventStore.Env, "err", "unknown event store "+cfg.EventStore.Value)
+		os.Exit(1)
+		return nil
+	}
 }
 
-func NewServer(logger log.Logger) service.AddService {
-	service := service.New(logger)
-	return service
+// checkIndexes exits, writing the index.yaml entries missing to stderr,
+// when the index file of file, e.g. default/index.yaml in development,
+// doesn't declare every composite index the history queries need.
+func checkIndexes(file config.Var, logger log.Logger) {
+	f, err := os.Open(file.Value)
+	if err != nil {
+		level.Error(logger).Log("env", file.Env, "err", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	declared, err := dsindex.Parse(f)
+	if err != nil {
+		level.Error(logger).Log("env", file.Env, "err", err)
+		os.Exit(1)
+	}
+	if err := dsindex.Check(repository.DatastoreQueries(), declared); err != nil {
+		level.Error(logger).Log("env", file.Env, "err", err, "msg", "add the entries below to "+file.Value)
+		dsindex.Write(os.Stderr, dsindex.Missing(repository.DatastoreQueries(), declared))
+		os.Exit(1)
+	}
 }
 
-func startHTTPServer(ctx context.Context, wg *sync.WaitGroup, endpoints endpoints.Endpoints, port string, logger log.Logger) {
+func startHTTPServer(ctx context.Context, wg *sync.WaitGroup, listening *sync.WaitGroup, endpoints endpoints.Endpoints, ids id.Generator, cfg config.Config, status drift.Status, snapshots *snapshot.Manager, meter *metering.Meter, ah *appengine.Hooks, jobs *cron.Jobs, state *gcp.Datastore, notifications notify.Service, exports transports.HTTPOption, deliveries *delivery.Deliveries, logger log.Logger) {
 	wg.Add(1)
 	defer wg.Done()
 
+	port := cfg.HTTPPort.Value
 	if port == "" {
 		level.Error(logger).Log("protocol", "HTTP", "exposed", port, "err", "port is not assigned exist")
+		listening.Done()
 		return
 	}
 
 	p := fmt.Sprintf(":%s", port)
 	// create a server
-	srv := &http.Server{Addr: p, Handler: transports.NewHTTPHandler(endpoints, logger)}
+	srv := &http.Server{Addr: p, Handler: newHTTPHandler(ctx, endpoints, ids, cfg, status, snapshots, meter, ah, jobs, state, notifications, exports, deliveries, logger)}
+	listener, err := net.Listen("tcp", p)
+	if err != nil {
+		level.Error(logger).Log("protocol", "HTTP", "listen", port, "err", err)
+		os.Exit(1)
+	}
+	listening.Done()
 	level.Info(logger).Log("protocol", "HTTP", "exposed", port)
 	go func() {
 		// service connections
-		if err := srv.ListenAndServe(); err != nil {
+		if err := srv.Serve(listener); err != nil {
 			level.Info(logger).Log("Listen", err)
 		}
 	}()
@@ -137,7 +1318,596 @@ func startHTTPServer(ctx context.Context, wg *sync.WaitGroup, endpoints endpoint
 	level.Info(logger).Log("protocol", "HTTP", "Shutdown", "http server gracefully stopped")
 }
 
-func startGRPCServer(ctx context.Context, wg *sync.WaitGroup, endpoints endpoints.Endpoints, port string, hs *health.Server, logger log.Logger) {
+// newHTTPHandler mounts the transport handler behind idempotency key handling,
+// together with the status endpoint and the optional request signature
+// verification, session management, wire-level capture and usage reports.
+func newHTTPHandler(ctx context.Context, endpoints endpoints.Endpoints, ids id.Generator, cfg config.Config, status drift.Status, snapshots *snapshot.Manager, meter *metering.Meter, ah *appengine.Hooks, jobs *cron.Jobs, state *gcp.Datastore, notifications notify.Service, exports transports.HTTPOption, deliveries *delivery.Deliveries, logger log.Logger) http.Handler {
+	idempotencyTTL, err := time.ParseDuration(cfg.IdempotencyTTL.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.IdempotencyTTL.Env, "err", err)
+		os.Exit(1)
+	}
+	errors.SetHelpURL(cfg.ErrorHelpURL.Value)
+	handler := transports.NewHTTPHandler(endpoints, logger, newHTTPOptions(cfg, exports, logger)...)
+	maxBodyBytes, err := strconv.ParseInt(cfg.MaxBodyBytes.Value, 10, 64)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.MaxBodyBytes.Env, "err", err)
+		os.Exit(1)
+	}
+	handler = idempotency.Middleware(newIdempotencyStore(ctx, cfg, state, logger), idempotencyTTL, maxBodyBytes, newAuthn(cfg, logger), logger)(handler)
+	if cfg.MirrorURL.Value != "" {
+		handler = newMirror(cfg, logger).Middleware(handler)
+	}
+	if cfg.Transforms.Value != "" {
+		handler = newTransformer(cfg, logger).Middleware(handler)
+	}
+
+	authn := newAuthn(cfg, logger)
+
+	var rec *capture.Recorder
+	if cfg.CaptureBucket.Value != "" && authn != nil {
+		rec = capture.NewRecorder(gcp.NewBucket(cfg.CaptureBucket.Value), status.Version, log.With(logger, "component", "capture"))
+		handler = rec.Middleware(handler)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", handler)
+	mux.Handle(drift.StatusPath, drift.StatusHandler(status))
+	mux.Handle(appengine.WarmupPath, ah.WarmupHandler())
+	mux.Handle(appengine.StopPath, ah.StopHandler())
+	mux.Handle(cron.PathPrefix, cron.MakeHTTPHandler(jobs, logger))
+	if cfg.SigKeys.Value != "" {
+		mux.Handle("/api/", newSignatureMiddleware(cfg, state, logger)(handler))
+	}
+	if cfg.SessionKey.Value != "" && authn != nil {
+		sessions := newSessionManager(cfg, state, logger)
+		mux.Handle("/api/sessions", sessions.StartHandler(authn))
+		mux.Handle("/api/sessions/others", sessions.RevokeOthersHandler())
+	}
+	if authn != nil {
+		mux.Handle("/api/notifications/", notify.MakeHTTPHandler(notifications, authn, logger))
+		mux.Handle(metering.Path, metering.MakeHTTPHandler(meter, authn, logger))
+		if deliveries != nil {
+			h := delivery.MakeHTTPHandler(deliveries, authn, logger)
+			mux.Handle(delivery.Path, h)
+			mux.Handle(delivery.Path+"/", h)
+		}
+	}
+	if rec != nil {
+		mux.Handle(capture.Path, capture.MakeHTTPHandler(rec, authn, logger))
+	}
+
+	var h http.Handler = mux
+	if cfg.RouteFlags.Value != "" {
+		h = newKillSwitches(ctx, cfg, snapshots, logger).Middleware(h)
+	}
+	h = id.RequestIDHandler(ids, transports.HeaderRequestID, h)
+	return tracing.TaskHandler(otelhttp.NewHandler(h, cfg.ServiceName.Value))
+}
+
+// newAppEngineHooks returns the hooks of the App Engine lifecycle requests:
+// warmup reads the history, to set up its client and token, and exports a
+// span, to set up the one of the tracer; stop saves the cache snapshot and
+// flushes the spans.
+func newAppEngineHooks(repo repository.Repository, tp trace.TracerProvider, snapshots *snapshot.Manager, logger log.Logger) *appengine.Hooks {
+	ah := appengine.New(log.With(logger, "component", "appengine"))
+	ah.OnWarmup("history", func(ctx context.Context) error {
+		_, _, err := repo.List(ctx, repository.Filter{}, "", 1)
+		return err
+	})
+	ah.OnWarmup("tracing", func(ctx context.Context) error {
+		_, span := tp.Tracer("appengine").Start(ctx, "warmup")
+		span.End()
+		return tracing.Flush(ctx, tp)
+	})
+	if snapshots != nil {
+		ah.OnStop("snapshot", snapshots.Save)
+	}
+	ah.OnStop("tracing", func(ctx context.Context) error {
+		return tracing.Flush(ctx, tp)
+	})
+	return ah
+}
+
+// newCronJobs returns the jobs cron.yaml may schedule, the ones configured:
+// snapshot saves the cache snapshot, outbox publishes the pending domain
+// events, deliveries resumes the export deliveries of the instances that
+// stopped.
+func newCronJobs(snapshots *snapshot.Manager, dispatcher *outbox.Dispatcher, deliveries *delivery.Deliveries, logger log.Logger) *cron.Jobs {
+	jobs := cron.New(kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "add",
+		Subsystem: "cron",
+		Name:      "runs_total",
+		Help:      "Cron job runs by result: ok, error or skipped as the job was still running.",
+	}, []string{"job", "result"}), kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+		Namespace: "add",
+		Subsystem: "cron",
+		Name:      "run_duration_seconds",
+		Help:      "Duration in seconds of the cron job runs.",
+		Buckets:   stdprometheus.ExponentialBuckets(0.01, 4, 8),
+	}, []string{"job"}), log.With(logger, "component", "cron"))
+	if snapshots != nil {
+		jobs.Register("snapshot", func(ctx context.Context, _ interface{}) (interface{}, error) {
+			return nil, snapshots.Save(ctx)
+		})
+	}
+	if dispatcher != nil {
+		jobs.Register("outbox", func(ctx context.Context, _ interface{}) (interface{}, error) {
+			n, err := dispatcher.Dispatch(ctx)
+			return map[string]int{"published": n}, err
+		})
+	}
+	if deliveries != nil {
+		jobs.Register("deliveries", func(ctx context.Context, _ interface{}) (interface{}, error) {
+			n, err := deliveries.Resume(ctx)
+			return map[string]int{"resumed": n}, err
+		})
+	}
+	return jobs
+}
+
+// newSnapshots loads the snapshot of the instance-local caches from the
+// gs://bucket/object of QS_ADD_CACHE_SNAPSHOT, and saves it back
+// periodically. It returns nil when snapshots aren't configured.
+func newSnapshots(ctx context.Context, cfg config.Config, status drift.Status, logger log.Logger) *snapshot.Manager {
+	if cfg.CacheSnapshot.Value == "" {
+		return nil
+	}
+	object := strings.TrimPrefix(cfg.CacheSnapshot.Value, "gs://")
+	i := strings.IndexByte(object, '/')
+	if !strings.HasPrefix(cfg.CacheSnapshot.Value, "gs://") || i <= 0 || i == len(object)-1 {
+		level.Error(logger).Log("env", cfg.CacheSnapshot.Env, "err", "want gs://bucket/object")
+		os.Exit(1)
+	}
+	interval, err := time.ParseDuration(cfg.CacheSnapshotInterval.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.CacheSnapshotInterval.Env, "err", err)
+		os.Exit(1)
+	}
+	maxAge, err := time.ParseDuration(cfg.CacheSnapshotMaxAge.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.CacheSnapshotMaxAge.Env, "err", err)
+		os.Exit(1)
+	}
+
+	m := snapshot.NewManager(gcp.NewBucket(object[:i]), object[i+1:], status.Version, log.With(logger, "component", "snapshot"))
+	loadCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := m.Load(loadCtx, maxAge); err != nil {
+		// a missing or stale snapshot only means a cold start
+		level.Info(logger).Log("snapshot", "load", "err", err)
+	}
+	if interval > 0 {
+		go m.Run(ctx, interval)
+	}
+	return m
+}
+
+// newMirror returns the Mirror copying a share of the API requests to
+// cfg.MirrorURL.Value, for the shadow deployment there to be load tested.
+func newMirror(cfg config.Config, logger log.Logger) *mirror.Mirror {
+	percent, err := strconv.ParseFloat(cfg.MirrorPercent.Value, 64)
+	if err != nil || percent < 0 || percent > 100 {
+		if err == nil {
+			err = fmt.Errorf("%v not a percentage", percent)
+		}
+		level.Error(logger).Log("env", cfg.MirrorPercent.Env, "err", err)
+		os.Exit(1)
+	}
+	timeout, err := time.ParseDuration(cfg.MirrorTimeout.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.MirrorTimeout.Env, "err", err)
+		os.Exit(1)
+	}
+	m, err := mirror.New(cfg.MirrorURL.Value, percent, kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "add",
+		Subsystem: "mirror",
+		Name:      "requests_total",
+		Help:      "Requests copied to the shadow by result: sent, dropped or error.",
+	}, []string{"result"}), log.With(logger, "component", "mirror"), mirror.Timeout(timeout), mirror.PathPrefixes("/api/"))
+	if err != nil {
+		level.Error(logger).Log("env", cfg.MirrorURL.Env, "err", err)
+		os.Exit(1)
+	}
+	return m
+}
+
+// newHTTPOptions returns the options of the API handler, the features of
+// the transport enabled by the configuration.
+func newHTTPOptions(cfg config.Config, exports transports.HTTPOption, logger log.Logger) []transports.HTTPOption {
+	var options []transports.HTTPOption
+	if pooling, err := strconv.ParseBool(cfg.Pooling.Value); err != nil {
+		level.Error(logger).Log("env", cfg.Pooling.Env, "err", err)
+		os.Exit(1)
+	} else if pooling {
+		options = append(options, transports.Pooling())
+	}
+	if maxBodyBytes, err := strconv.ParseInt(cfg.MaxBodyBytes.Value, 10, 64); err != nil {
+		level.Error(logger).Log("env", cfg.MaxBodyBytes.Env, "err", err)
+		os.Exit(1)
+	} else {
+		options = append(options, transports.MaxBodyBytes(maxBodyBytes))
+	}
+	if gzip, err := strconv.ParseBool(cfg.Gzip.Value); err != nil {
+		level.Error(logger).Log("env", cfg.Gzip.Env, "err", err)
+		os.Exit(1)
+	} else if gzip {
+		options = append(options, transports.Compression())
+	}
+	if cfg.CanonicalJSON.Value == "*" {
+		options = append(options, transports.CanonicalJSON())
+	} else if cfg.CanonicalJSON.Value != "" {
+		options = append(options, transports.CanonicalJSON(strings.Split(cfg.CanonicalJSON.Value, ",")...))
+	}
+	if cfg.XMLRoutes.Value == "*" {
+		options = append(options, transports.XMLRequests())
+	} else if cfg.XMLRoutes.Value != "" {
+		options = append(options, transports.XMLRequests(strings.Split(cfg.XMLRoutes.Value, ",")...))
+	}
+	if exports != nil {
+		options = append(options, exports)
+	}
+	if strict, err := strconv.ParseBool(cfg.StrictDecoding.Value); err != nil {
+		level.Error(logger).Log("env", cfg.StrictDecoding.Env, "err", err)
+		os.Exit(1)
+	} else if strict {
+		options = append(options, transports.StrictDecoding())
+	}
+	if envelope, err := strconv.ParseBool(cfg.Envelope.Value); err != nil {
+		level.Error(logger).Log("env", cfg.Envelope.Env, "err", err)
+		os.Exit(1)
+	} else if envelope {
+		options = append(options, transports.Envelope())
+	}
+	burst, interval := privilegedQuota(cfg, logger)
+	return append(options, transports.RateLimits(transports.RateLimit{Scope: "privileged-headers", Burst: burst, Interval: interval.String()}))
+}
+
+// newDownloads returns the store of the resumable exports, and of the
+// delivered ones, kept for QS_ADD_DOWNLOAD_TTL in the bucket
+// QS_ADD_DOWNLOAD_BUCKET, or in memory without one, the issuer of their
+// download URLs and their maximum size, QS_ADD_DOWNLOAD_MAX_BYTES. The URLs
+// are signed for as long with QS_ADD_DOWNLOAD_KEY, base64 encoded, which
+// the instances sharing the bucket share; without one, with a random key of
+// the instance. The tokens of a download are revoked once it completed, in
+// state unless nil.
+func newDownloads(cfg config.Config, state *gcp.Datastore, logger log.Logger) (download.Store, capability.Issuer, int) {
+	ttl, err := time.ParseDuration(cfg.DownloadTTL.Value)
+	if err != nil || ttl <= 0 {
+		if err == nil {
+			err = fmt.Errorf("%v not positive", ttl)
+		}
+		level.Error(logger).Log("env", cfg.DownloadTTL.Env, "err", err)
+		os.Exit(1)
+	}
+	maxBytes, err := strconv.Atoi(cfg.DownloadMaxBytes.Value)
+	if err != nil || maxBytes <= 0 {
+		if err == nil {
+			err = fmt.Errorf("%v not positive", maxBytes)
+		}
+		level.Error(logger).Log("env", cfg.DownloadMaxBytes.Env, "err", err)
+		os.Exit(1)
+	}
+	key := newSigningKey(cfg.DownloadKey, logger)
+	var store download.Store
+	if cfg.DownloadBucket.Value != "" {
+		store = download.NewBucketStore(gcp.NewBucket(cfg.DownloadBucket.Value), "downloads/", clock.System, ttl, maxBytes)
+	} else {
+		// the memory holds a few of the largest exports
+		store = download.NewMemoryStore(clock.System, ttl, 4*maxBytes)
+	}
+	revocations := capability.NewMemoryRevocations()
+	if state != nil {
+		revocations = capability.NewDatastoreRevocations(state)
+	}
+	return store, capability.New(key, ttl, revocations), maxBytes
+}
+
+// newDeliveries returns the deliveries of the exports of eps to the
+// notification channels of the callers, linking their downloads under
+// QS_ADD_DELIVERY_URL, the base URL of the service, e.g.
+// https://add.example.com; nil when it's unset. They need the resumable
+// exports, for downloads, and the notifications. Their state is kept in
+// state, from which the deliveries cron job resumes them, or in the memory
+// of the instance when nil.
+func newDeliveries(cfg config.Config, eps endpoints.Endpoints, downloads download.Store, issuer capability.Issuer, maxBytes int, notifications notify.Service, notifier *notify.Notifier, state *gcp.Datastore, logger log.Logger) *delivery.Deliveries {
+	if cfg.DeliveryURL.Value == "" {
+		return nil
+	}
+	base, err := url.Parse(cfg.DeliveryURL.Value)
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		level.Error(logger).Log("env", cfg.DeliveryURL.Env, "err", "want the base URL of the service, e.g. https://add.example.com")
+		os.Exit(1)
+	}
+	switch {
+	case downloads == nil:
+		level.Error(logger).Log("env", cfg.DeliveryURL.Env, "err", fmt.Sprintf("deliveries need the resumable exports, %s", cfg.DownloadTTL.Env))
+		os.Exit(1)
+	case notifier == nil:
+		level.Error(logger).Log("env", cfg.DeliveryURL.Env, "err", fmt.Sprintf("deliveries need the notifications, %s", cfg.JWTKey.Env))
+		os.Exit(1)
+	}
+	store := saga.NewMemoryStore()
+	if state != nil {
+		store = saga.NewDatastoreStore(state)
+	}
+	link := base.ResolveReference(&url.URL{Path: transports.DownloadPath})
+	return delivery.New(eps, downloads, issuer, maxBytes, link, notifications, notifier, store, log.With(logger, "component", "delivery"))
+}
+
+// newSigningKey returns the base64 encoded key of v, which the instances
+// share, or a random key of the instance when v is empty.
+func newSigningKey(v config.Var, logger log.Logger) []byte {
+	key, err := base64.StdEncoding.DecodeString(v.Value)
+	if err == nil && len(key) == 0 {
+		key = make([]byte, 32)
+		_, err = rand.Read(key)
+	}
+	if err != nil {
+		level.Error(logger).Log("env", v.Env, "err", err)
+		os.Exit(1)
+	}
+	return key
+}
+
+// newNotifications returns the notification preferences of the callers
+// authenticated with QS_ADD_JWT_KEY, kept in state unless nil, and the
+// notifier delivering their domain events as the preferences tell; nils
+// without a key. The webhook channels are verified with a challenge, the
+// Pub/Sub ones, of the topics of other projects, with a test publish, and
+// the email ones, sent through QS_ADD_NOTIFY_SMTP_ADDR when set, with a
+// link to QS_ADD_NOTIFY_CONFIRM_URL valid for QS_ADD_NOTIFY_CONFIRM_TTL,
+// signed with QS_ADD_NOTIFY_KEY.
+func newNotifications(ctx context.Context, cfg config.Config, state *gcp.Datastore, logger log.Logger) (notify.Service, *notify.Notifier) {
+	if cfg.JWTKey.Value == "" {
+		return nil, nil
+	}
+	repo := notify.NewMemoryRepository()
+	if state != nil {
+		repo = notify.NewDatastoreRepository(state)
+	}
+	verifiers := map[notify.ChannelType]notify.Verifier{notify.Webhook: notify.NewWebhookVerifier(10 * time.Second)}
+	senders := map[notify.ChannelType]notify.Sender{notify.Webhook: notify.NewWebhookSender(10 * time.Second)}
+	if projectID, err := gcp.ProjectID(ctx); err != nil {
+		level.Info(logger).Log("notify", "pubsub channels disabled", "err", err)
+	} else {
+		topics := notify.GCPTopics()
+		verifiers[notify.PubSub] = notify.NewPubSubVerifier(topics, projectID)
+		senders[notify.PubSub] = notify.NewPubSubSender(topics)
+	}
+	if cfg.NotifySMTPAddr.Value != "" {
+		ttl, err := time.ParseDuration(cfg.NotifyConfirmTTL.Value)
+		if err != nil || ttl <= 0 {
+			level.Error(logger).Log("env", cfg.NotifyConfirmTTL.Env, "err", "want a positive duration")
+			os.Exit(1)
+		}
+		if u, err := url.Parse(cfg.NotifyConfirmURL.Value); err != nil || u.Scheme == "" || u.Host == "" {
+			level.Error(logger).Log("env", cfg.NotifyConfirmURL.Env, "err", "want the base URL of the service, e.g. https://add.example.com")
+			os.Exit(1)
+		}
+		revocations := capability.NewMemoryRevocations()
+		if state != nil {
+			revocations = capability.NewDatastoreRevocations(state)
+		}
+		issuer := capability.New(newSigningKey(cfg.NotifyKey, logger), ttl, revocations)
+		mailer := notify.NewSMTPMailer(cfg.NotifySMTPAddr.Value, cfg.NotifySMTPFrom.Value, cfg.NotifySMTPUsername.Value, cfg.NotifySMTPPassword.Value)
+		verifiers[notify.Email] = notify.NewEmailVerifier(mailer, issuer, strings.TrimSuffix(cfg.NotifyConfirmURL.Value, "/"), ttl)
+		senders[notify.Email] = notify.NewEmailSender(mailer)
+	}
+	svc := notify.NewService(repo, verifiers, logger)
+	return svc, notify.NewNotifier(svc, senders)
+}
+
+// newTransformer returns the Transformer of the rules of the file
+// cfg.Transforms.Value, rewriting the responses of the API routes for legacy
+// clients.
+func newTransformer(cfg config.Config, logger log.Logger) *transform.Transformer {
+	b, err := ioutil.ReadFile(cfg.Transforms.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.Transforms.Env, "err", err)
+		os.Exit(1)
+	}
+	t, err := transform.Parse(b, log.With(logger, "component", "transform"))
+	if err != nil {
+		level.Error(logger).Log("env", cfg.Transforms.Env, "err", err)
+		os.Exit(1)
+	}
+	return t
+}
+
+// newKillSwitches watches the route flags document, read from a file, an
+// http(s) URL or a gs://bucket/object. Until the first reload, the rules come
+// from the cache snapshot.
+func newKillSwitches(ctx context.Context, cfg config.Config, snapshots *snapshot.Manager, logger log.Logger) *killswitch.Switches {
+	interval, err := time.ParseDuration(cfg.RouteFlagsInterval.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.RouteFlagsInterval.Env, "err", err)
+		os.Exit(1)
+	}
+	var src killswitch.Source
+	switch {
+	case strings.HasPrefix(cfg.RouteFlags.Value, "gs://"):
+		u := "https://storage.googleapis.com/" + strings.TrimPrefix(cfg.RouteFlags.Value, "gs://")
+		src = killswitch.NewURLSource(u, gcp.NewClient("https://www.googleapis.com/auth/devstorage.read_only"))
+	case strings.HasPrefix(cfg.RouteFlags.Value, "http://"), strings.HasPrefix(cfg.RouteFlags.Value, "https://"):
+		src = killswitch.NewURLSource(cfg.RouteFlags.Value, &http.Client{Timeout: 10 * time.Second})
+	default:
+		src = killswitch.NewFileSource(cfg.RouteFlags.Value)
+	}
+	switches := killswitch.New()
+	snapshots.Register("route_flags", switches)
+	go switches.Watch(ctx, src, interval, logger)
+	return switches
+}
+
+// newFeatureFlags returns the feature flags of QS_ADD_FEATURE_FLAGS, reloaded
+// every QS_ADD_FEATURE_FLAGS_INTERVAL: env for the QS_ADD_FLAG_<NAME>
+// variables, datastore for the FeatureFlag entities, a gs:// or http(s) URL,
+// or a file path.
+func newFeatureFlags(ctx context.Context, cfg config.Config, snapshots *snapshot.Manager, logger log.Logger) *featureflags.Flags {
+	interval, err := time.ParseDuration(cfg.FeatureFlagsInterval.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.FeatureFlagsInterval.Env, "err", err)
+		os.Exit(1)
+	}
+	var src featureflags.Source
+	switch {
+	case cfg.FeatureFlags.Value == "env":
+		src = featureflags.NewEnvSource("QS_ADD_FLAG_")
+	case cfg.FeatureFlags.Value == "datastore":
+		projectID, err := gcp.ProjectID(ctx)
+		if err != nil {
+			level.Error(logger).Log("env", cfg.FeatureFlags.Env, "err", err)
+			os.Exit(1)
+		}
+		src = featureflags.NewDatastoreSource(projectID, datastoreNamespace(cfg, logger))
+	case strings.HasPrefix(cfg.FeatureFlags.Value, "gs://"):
+		u := "https://storage.googleapis.com/" + strings.TrimPrefix(cfg.FeatureFlags.Value, "gs://")
+		src = featureflags.NewURLSource(u, gcp.NewClient("https://www.googleapis.com/auth/devstorage.read_only"))
+	case strings.HasPrefix(cfg.FeatureFlags.Value, "http://"), strings.HasPrefix(cfg.FeatureFlags.Value, "https://"):
+		src = featureflags.NewURLSource(cfg.FeatureFlags.Value, &http.Client{Timeout: 10 * time.Second})
+	default:
+		src = featureflags.NewFileSource(cfg.FeatureFlags.Value)
+	}
+	flags := featureflags.New()
+	logger = log.With(logger, "component", "featureflags")
+	flags.OnChange(func(old, new featureflags.Flag) {
+		name := new.Name
+		if name == "" {
+			name = old.Name
+		}
+		level.Info(logger).Log("flag", name, "enabled", new.Enabled, "tenants", strings.Join(new.Tenants, ","))
+	})
+	snapshots.Register("feature_flags", flags)
+	go flags.Watch(ctx, src, interval, logger)
+	return flags
+}
+
+// newIdempotencyStore returns the store of the idempotency records, in the
+// Redis server of QS_ADD_IDEMPOTENCY_REDIS when set, else in state unless
+// nil, so that a retry landing on another instance is deduplicated. The
+// store is behind a bloom filter of its keys when
+// QS_ADD_IDEMPOTENCY_FILTER_SIZE is set, built from the store before
+// serving and rebuilt every QS_ADD_IDEMPOTENCY_FILTER_REFRESH.
+func newIdempotencyStore(ctx context.Context, cfg config.Config, state *gcp.Datastore, logger log.Logger) idempotency.Store {
+	var store idempotency.Store
+	switch {
+	case cfg.IdempotencyRedis.Value != "":
+		store = idempotency.NewRedisStore(cfg.IdempotencyRedis.Value)
+	case state != nil:
+		store = idempotency.NewDatastoreStore(state)
+	default:
+		store = idempotency.NewMemoryStore(clock.System)
+	}
+
+	size, err := strconv.Atoi(cfg.IdemFilterSize.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.IdemFilterSize.Env, "err", err)
+		os.Exit(1)
+	}
+	fpRate, err := strconv.ParseFloat(cfg.IdemFilterFPRate.Value, 64)
+	if err != nil || fpRate <= 0 || fpRate >= 1 {
+		level.Error(logger).Log("env", cfg.IdemFilterFPRate.Env, "err", "want a rate within 0 and 1")
+		os.Exit(1)
+	}
+	refresh, err := time.ParseDuration(cfg.IdemFilterRefresh.Value)
+	if err != nil || refresh <= 0 {
+		level.Error(logger).Log("env", cfg.IdemFilterRefresh.Env, "err", "want a positive duration")
+		os.Exit(1)
+	}
+	lister, ok := store.(idempotency.KeyLister)
+	if size <= 0 || !ok {
+		return store
+	}
+
+	cache := bloom.NewNegativeCache(lister.Keys, size, fpRate, kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "add",
+		Subsystem: "idempotency_filter",
+		Name:      "lookups_total",
+		Help:      "Idempotency key lookups by bloom filter result; false_positive / (false_positive + true_positive) is the false positive rate.",
+	}, []string{"result"}))
+	// an empty filter is only slower, the keys it misses falling back to Reserve
+	if err := cache.Refresh(ctx); err != nil {
+		level.Warn(logger).Log("bloom", "refresh", "err", err)
+	}
+	go cache.Watch(ctx, refresh, logger)
+	return idempotency.NewFilteredStore(store, cache)
+}
+
+// newSignatureMiddleware verifies the request signatures made with the
+// QS_ADD_SIGNATURE_KEYS, "id:secret" pairs, within QS_ADD_SIGNATURE_WINDOW,
+// on bodies of up to QS_ADD_MAX_BODY_BYTES. The nonces are kept in state
+// unless nil, so that a request replayed to another instance is caught.
+func newSignatureMiddleware(cfg config.Config, state *gcp.Datastore, logger log.Logger) func(http.Handler) http.Handler {
+	keys := map[string][]byte{}
+	for _, kv := range strings.Split(cfg.SigKeys.Value, ",") {
+		if i := strings.Index(kv, ":"); i > 0 {
+			keys[kv[:i]] = []byte(kv[i+1:])
+		}
+	}
+	window, err := time.ParseDuration(cfg.SigWindow.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.SigWindow.Env, "err", err)
+		os.Exit(1)
+	}
+	maxBody, err := strconv.ParseInt(cfg.MaxBodyBytes.Value, 10, 64)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.MaxBodyBytes.Env, "err", err)
+		os.Exit(1)
+	}
+	rejected := kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "add",
+		Subsystem: "signature",
+		Name:      "rejected_total",
+		Help:      "Requests rejected by signature verification, by reason.",
+	}, []string{"reason"})
+
+	nonces := nonce.NewMemoryStore()
+	if state != nil {
+		nonces = nonce.NewDatastoreStore(state)
+	}
+
+	return signature.Middleware(func(keyID string) ([]byte, error) {
+		if key, ok := keys[keyID]; ok {
+			return key, nil
+		}
+		return nil, signature.ErrInvalidSignature
+	}, nonces, window, maxBody, rejected, logger)
+}
+
+// newSessionManager returns the manager of the cookie sessions, started at
+// /api/sessions from a JWT verified by newAuthn, lasting QS_ADD_SESSION_TTL,
+// at most QS_ADD_SESSION_MAX_PER_PRINCIPAL of them per principal. They're
+// kept in state unless nil, so that any instance serves them.
+func newSessionManager(cfg config.Config, state *gcp.Datastore, logger log.Logger) *session.Manager {
+	key, err := base64.StdEncoding.DecodeString(cfg.SessionKey.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.SessionKey.Env, "err", err)
+		os.Exit(1)
+	}
+	codec, err := session.NewCodec(key)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.SessionKey.Env, "err", err)
+		os.Exit(1)
+	}
+	ttl, err := time.ParseDuration(cfg.SessionTTL.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.SessionTTL.Env, "err", err)
+		os.Exit(1)
+	}
+	maxPer, err := strconv.Atoi(cfg.SessionMax.Value)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.SessionMax.Env, "err", err)
+		os.Exit(1)
+	}
+	store := session.NewMemoryStore()
+	if state != nil {
+		store = session.NewDatastoreStore(state)
+	}
+	return session.NewManager(store, codec, ttl, maxPer)
+}
+
+func startGRPCServer(ctx context.Context, wg *sync.WaitGroup, listening *sync.WaitGroup, endpoints endpoints.Endpoints, port string, hs *health.Server, logger log.Logger) {
 	wg.Add(1)
 	defer wg.Done()
 
@@ -147,10 +1917,14 @@ func startGRPCServer(ctx context.Context, wg *sync.WaitGroup, endpoints endpoint
 		level.Error(logger).Log("protocol", "GRPC", "listen", port, "err", err)
 		os.Exit(1)
 	}
+	listening.Done()
 
 	var server *grpc.Server
 	level.Info(logger).Log("protocol", "GRPC", "exposed", port)
-	server = grpc.NewServer(grpc.UnaryInterceptor(kitgrpc.Interceptor))
+	server = grpc.NewServer(
+		grpc.UnaryInterceptor(chainUnaryInterceptors(tracing.UnaryServerInterceptor(), kitgrpc.Interceptor)),
+		grpc.StreamInterceptor(tracing.StreamServerInterceptor()),
+	)
 	pb.RegisterAddServer(server, transports.MakeGRPCServer(endpoints, logger))
 	healthgrpc.RegisterHealthServer(server, hs)
 	reflection.Register(server)
@@ -170,3 +1944,106 @@ func startGRPCServer(ctx context.Context, wg *sync.WaitGroup, endpoints endpoint
 
 	fmt.Println("grpc server gracefully stopped")
 }
+
+// chainUnaryInterceptors returns the interceptor calling outer, which calls
+// inner, which calls the handler; grpc.NewServer takes a single one.
+func chainUnaryInterceptors(outer, inner grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return outer(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return inner(ctx, req, info, handler)
+		})
+	}
+}
+
+// startPubSubServer runs the requests of the messages of the subscription
+// QS_ADD_PUBSUB_SUBSCRIPTION, when set, until ctx is done, with the flow
+// control of QS_ADD_PUBSUB_MAX_OUTSTANDING_MESSAGES,
+// QS_ADD_PUBSUB_MAX_OUTSTANDING_BYTES, QS_ADD_PUBSUB_WORKERS,
+// QS_ADD_PUBSUB_ACK_DEADLINE and QS_ADD_PUBSUB_MAX_EXTENSION. The messages
+// of an ordering key are run in order, the key being the attribute
+// QS_ADD_PUBSUB_ORDERING_ATTRIBUTE when set, for the messages published
+// without ordering keys.
+func startPubSubServer(ctx context.Context, wg *sync.WaitGroup, endpoints endpoints.Endpoints, cfg config.Config, logger log.Logger) {
+	if cfg.PubSubSubscription.Value == "" {
+		return
+	}
+	wg.Add(1)
+	defer wg.Done()
+
+	fc := pubsub.FlowControl{}
+	for _, v := range []struct {
+		env, value string
+		n          *int
+	}{
+		{cfg.PubSubMaxMessages.Env, cfg.PubSubMaxMessages.Value, &fc.MaxOutstandingMessages},
+		{cfg.PubSubMaxBytes.Env, cfg.PubSubMaxBytes.Value, &fc.MaxOutstandingBytes},
+		{cfg.PubSubWorkers.Env, cfg.PubSubWorkers.Value, &fc.Workers},
+	} {
+		n, err := strconv.Atoi(v.value)
+		if err != nil || n <= 0 {
+			if err == nil {
+				err = fmt.Errorf("%v not positive", n)
+			}
+			level.Error(logger).Log("env", v.env, "err", err)
+			os.Exit(1)
+		}
+		*v.n = n
+	}
+	var err error
+	if fc.AckDeadline, err = time.ParseDuration(cfg.PubSubAckDeadline.Value); err == nil && (fc.AckDeadline < 10*time.Second || fc.AckDeadline > 10*time.Minute) {
+		err = fmt.Errorf("%v not between 10s and 10m", fc.AckDeadline)
+	}
+	if err != nil {
+		level.Error(logger).Log("env", cfg.PubSubAckDeadline.Env, "err", err)
+		os.Exit(1)
+	}
+	if fc.MaxExtension, err = time.ParseDuration(cfg.PubSubMaxExtension.Value); err == nil && fc.MaxExtension <= 0 {
+		err = fmt.Errorf("%v not positive", fc.MaxExtension)
+	}
+	if err != nil {
+		level.Error(logger).Log("env", cfg.PubSubMaxExtension.Env, "err", err)
+		os.Exit(1)
+	}
+	projectID, err := gcp.ProjectID(ctx)
+	if err != nil {
+		level.Error(logger).Log("env", cfg.PubSubSubscription.Env, "err", err)
+		os.Exit(1)
+	}
+
+	key := pubsub.OrderingKey
+	if cfg.PubSubOrderingAttribute.Value != "" {
+		key = pubsub.AttributeKey(cfg.PubSubOrderingAttribute.Value)
+	}
+
+	r := pubsub.NewReceiver(gcp.NewSubscription(projectID, cfg.PubSubSubscription.Value), fc, key, pubsub.Metrics{
+		AckLatency: kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: "add",
+			Subsystem: "pubsub",
+			Name:      "ack_latency_seconds",
+			Help:      "Seconds from the pull of the messages to their acknowledgement, by result.",
+		}, []string{"result"}),
+		Expired: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "add",
+			Subsystem: "pubsub",
+			Name:      "expired_total",
+			Help:      "Messages whose lease expired before they were handled.",
+		}, []string{}),
+		Paused: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "add",
+			Subsystem: "pubsub",
+			Name:      "paused_total",
+			Help:      "Pauses of the pulls by the flow control, by reason.",
+		}, []string{"reason"}),
+		Outstanding: kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: "add",
+			Subsystem: "pubsub",
+			Name:      "outstanding",
+			Help:      "Messages and bytes pulled but not yet acknowledged, by unit.",
+		}, []string{"unit"}),
+	}, log.With(logger, "component", "pubsub"))
+
+	level.Info(logger).Log("protocol", "PubSub", "exposed", cfg.PubSubSubscription.Value)
+	if err := transports.ServePubSub(ctx, r, endpoints, logger); err != nil {
+		level.Error(logger).Log("protocol", "PubSub", "err", err)
+	}
+}
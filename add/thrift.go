@@ -0,0 +1,57 @@
+//go:build thrift
+// +build thrift
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/config"
+	"github.com/cage1016/gokit-gae/internal/app/add/endpoints"
+	"github.com/cage1016/gokit-gae/internal/app/add/transports"
+	addthrift "github.com/cage1016/gokit-gae/pb/add/thrift/gen-go/add"
+)
+
+func init() {
+	optionalServers = append(optionalServers, startThriftServer)
+}
+
+// startThriftServer serves the Thrift service on QS_ADD_THRIFT_PORT, when
+// set, with the binary protocol over buffered transports, until ctx is done.
+func startThriftServer(ctx context.Context, wg *sync.WaitGroup, endpoints endpoints.Endpoints, cfg config.Config, logger log.Logger) {
+	if cfg.ThriftPort.Value == "" {
+		return
+	}
+	wg.Add(1)
+	defer wg.Done()
+
+	socket, err := thrift.NewTServerSocket(fmt.Sprintf(":%s", cfg.ThriftPort.Value))
+	if err != nil {
+		level.Error(logger).Log("protocol", "Thrift", "listen", cfg.ThriftPort.Value, "err", err)
+		os.Exit(1)
+	}
+	server := thrift.NewTSimpleServer4(
+		addthrift.NewAddServiceProcessor(transports.NewThriftServer(endpoints)),
+		socket,
+		thrift.NewTBufferedTransportFactory(8192),
+		thrift.NewTBinaryProtocolFactoryConf(nil),
+	)
+
+	level.Info(logger).Log("protocol", "Thrift", "exposed", cfg.ThriftPort.Value)
+	go func() {
+		if err := server.Serve(); err != nil {
+			level.Error(logger).Log("protocol", "Thrift", "err", err)
+		}
+	}()
+
+	<-ctx.Done()
+	server.Stop()
+	fmt.Println("thrift server stopped")
+}
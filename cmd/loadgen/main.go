@@ -0,0 +1,254 @@
+// Command loadgen drives the add service at a constant rate and reports the
+// latency percentiles and the error rate of every method:
+//
+//	loadgen -target https://add-dot-my-project.appspot.com -rps 200 \
+//		-concurrency 50 -duration 1m -method mix -token "$JWT"
+//
+// The rate is kept whatever the latency, up to -concurrency requests in
+// flight; the calls beyond are counted as dropped, a sign the target or the
+// generator saturates. -pushgateway pushes the results to a Prometheus
+// Pushgateway, for comparing runs over time.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/log"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"google.golang.org/grpc"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/service"
+	"github.com/cage1016/gokit-gae/internal/app/add/transports"
+	"github.com/cage1016/gokit-gae/internal/pkg/discovery"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/tracing"
+)
+
+// The methods driven.
+var methods = map[string]func(ctx context.Context, svc service.AddService) error{
+	"sum": func(ctx context.Context, svc service.AddService) error {
+		_, err := svc.Sum(ctx, rand.Int63n(1000), rand.Int63n(1000))
+		return err
+	},
+	"concat": func(ctx context.Context, svc service.AddService) error {
+		_, err := svc.Concat(ctx, fmt.Sprint(rand.Intn(1000)), fmt.Sprint(rand.Intn(1000)))
+		return err
+	},
+}
+
+// stats collects the outcome of the calls of a method.
+type stats struct {
+	latencies []time.Duration
+	errors    map[string]int
+}
+
+type recorder struct {
+	mu      sync.Mutex
+	stats   map[string]*stats
+	dropped int
+
+	latency *stdprometheus.HistogramVec
+}
+
+func (r *recorder) record(method string, d time.Duration, err error) {
+	result := "ok"
+	if err != nil {
+		if result = errors.Code(err); result == "" {
+			result = "error"
+		}
+	}
+	r.latency.WithLabelValues(method, result).Observe(d.Seconds())
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.stats[method]
+	if !ok {
+		s = &stats{errors: map[string]int{}}
+		r.stats[method] = s
+	}
+	s.latencies = append(s.latencies, d)
+	if err != nil {
+		s.errors[result]++
+	}
+}
+
+// percentile returns the p-th percentile of the sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted))*p/100+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+// report writes the results of a run of elapsed with concurrency workers.
+func (r *recorder) report(elapsed time.Duration, concurrency int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.stats))
+	for name := range r.stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Printf("%-8s %8s %8s %7s %9s %9s %9s %9s\n", "method", "calls", "rps", "errors", "p50", "p95", "p99", "max")
+	for _, name := range names {
+		s := r.stats[name]
+		sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+		var failed int
+		for _, n := range s.errors {
+			failed += n
+		}
+		n := len(s.latencies)
+		fmt.Printf("%-8s %8d %8.1f %6.2f%% %9s %9s %9s %9s\n", name, n, float64(n)/elapsed.Seconds(), 100*float64(failed)/float64(n),
+			round(percentile(s.latencies, 50)), round(percentile(s.latencies, 95)), round(percentile(s.latencies, 99)), round(s.latencies[n-1]))
+		codes := make([]string, 0, len(s.errors))
+		for code := range s.errors {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		for _, code := range codes {
+			fmt.Printf("         %s: %d\n", code, s.errors[code])
+		}
+	}
+	if r.dropped > 0 {
+		fmt.Printf("dropped: %d calls, all %d workers busy\n", r.dropped, concurrency)
+	}
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(10 * time.Microsecond)
+}
+
+func main() {
+	var (
+		target      = flag.String("target", "localhost:8180", "the instances over HTTP, a discovery specification such as a base URL, host:port or dnssrv://name, the host:port of the instance over gRPC")
+		transport   = flag.String("transport", "http", "http or grpc")
+		rps         = flag.Float64("rps", 50, "the calls per second")
+		concurrency = flag.Int("concurrency", 10, "the calls in flight at most")
+		duration    = flag.Duration("duration", 30*time.Second, "the length of the run")
+		method      = flag.String("method", "sum", "sum, concat, or mix for both")
+		timeout     = flag.Duration("timeout", 5*time.Second, "the timeout of every call")
+		token       = flag.String("token", "", "the JWT the calls send")
+		pushgateway = flag.String("pushgateway", "", "the Prometheus Pushgateway URL to push the results to")
+		job         = flag.String("job", "loadgen", "the job of the pushed metrics")
+	)
+	flag.Parse()
+
+	var drive []string
+	switch *method {
+	case "mix":
+		drive = []string{"sum", "concat"}
+	case "sum", "concat":
+		drive = []string{*method}
+	default:
+		fmt.Fprintf(os.Stderr, "loadgen: unknown method %q\n", *method)
+		os.Exit(2)
+	}
+	if *rps <= 0 || *concurrency <= 0 {
+		fmt.Fprintln(os.Stderr, "loadgen: -rps and -concurrency must be positive")
+		os.Exit(2)
+	}
+
+	logger := log.NewNopLogger()
+	var svc service.AddService
+	switch *transport {
+	case "http":
+		instancer, err := discovery.NewInstancer(*target, 0, logger)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "loadgen: %v\n", err)
+			os.Exit(2)
+		}
+		// the calls aren't retried, their latency is the one of one call
+		d := transports.Discovery{Instancer: instancer, Attempts: 1}
+		if svc, err = transports.NewHTTPDiscoveryClient(d, transports.HTTPClientOptions{MaxIdleConnsPerHost: *concurrency, Token: *token}, nil, nil, logger); err != nil {
+			fmt.Fprintf(os.Stderr, "loadgen: %v\n", err)
+			os.Exit(1)
+		}
+	case "grpc":
+		conn, err := grpc.Dial(*target, grpc.WithInsecure(), grpc.WithUnaryInterceptor(tracing.UnaryClientInterceptor()))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "loadgen: %v\n", err)
+			os.Exit(1)
+		}
+		defer conn.Close()
+		svc = transports.NewGRPCClient(conn, nil, nil, logger)
+	default:
+		fmt.Fprintf(os.Stderr, "loadgen: unknown transport %q\n", *transport)
+		os.Exit(2)
+	}
+
+	rec := &recorder{
+		stats: map[string]*stats{},
+		latency: stdprometheus.NewHistogramVec(stdprometheus.HistogramOpts{
+			Namespace: "loadgen",
+			Name:      "call_duration_seconds",
+			Help:      "Latency of the calls by method and result, ok or the error code.",
+			Buckets:   stdprometheus.ExponentialBuckets(0.001, 2, 15),
+		}, []string{"method", "result"}),
+	}
+
+	calls := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := range calls {
+				ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+				if *token != "" {
+					ctx = context.WithValue(ctx, kitjwt.JWTTokenContextKey, *token)
+				}
+				start := time.Now()
+				err := methods[m](ctx, svc)
+				rec.record(m, time.Since(start), err)
+				cancel()
+			}
+		}()
+	}
+
+	fmt.Printf("driving %s %s over %s at %.0f rps for %s\n", *target, strings.Join(drive, "+"), *transport, *rps, *duration)
+	start := time.Now()
+	tick := time.NewTicker(time.Duration(float64(time.Second) / *rps))
+	end := time.After(*duration)
+	for i := 0; ; i++ {
+		select {
+		case <-end:
+			tick.Stop()
+			close(calls)
+			wg.Wait()
+			elapsed := time.Since(start)
+			rec.report(elapsed, *concurrency)
+			if *pushgateway != "" {
+				if err := push.New(*pushgateway, *job).Collector(rec.latency).Push(); err != nil {
+					fmt.Fprintf(os.Stderr, "loadgen: push: %v\n", err)
+					os.Exit(1)
+				}
+			}
+			return
+		case <-tick.C:
+			select {
+			case calls <- drive[i%len(drive)]:
+			default:
+				rec.mu.Lock()
+				rec.dropped++
+				rec.mu.Unlock()
+			}
+		}
+	}
+}
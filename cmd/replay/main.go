@@ -0,0 +1,111 @@
+// Command replay replays the requests of a capture session against a target
+// instance and reports the responses differing from the captured ones, for
+// regression testing a new version on real traffic:
+//
+//	replay -bucket my-captures -prefix v1.2.0 -session 20200101T120000Z \
+//		-target https://v1-3-0-dot-add-dot-my-project.appspot.com \
+//		-token "$(gcloud auth print-access-token)" \
+//		-H "Authorization: Bearer $JWT"
+//
+// It exits with 1 when an exchange differs or can't be replayed.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/capture"
+	"github.com/cage1016/gokit-gae/internal/pkg/gcp"
+)
+
+// headers is a repeatable flag of "Name: value" headers.
+type headers http.Header
+
+func (h headers) String() string {
+	return fmt.Sprint(http.Header(h))
+}
+
+func (h headers) Set(v string) error {
+	i := strings.IndexByte(v, ':')
+	if i <= 0 {
+		return fmt.Errorf("header %q isn't Name: value", v)
+	}
+	http.Header(h).Add(strings.TrimSpace(v[:i]), strings.TrimSpace(v[i+1:]))
+	return nil
+}
+
+func main() {
+	var (
+		bucket  = flag.String("bucket", "", "the bucket of the captures (QS_ADD_CAPTURE_BUCKET)")
+		prefix  = flag.String("prefix", "", "the prefix of the captures in the bucket, the version of the capturing instance")
+		dir     = flag.String("dir", "", "read the captures from this directory rather than a bucket")
+		session = flag.String("session", "", "the capture session to replay")
+		target  = flag.String("target", "", "the base URL of the instance to replay against")
+		token   = flag.String("token", "", "the OAuth2 access token reading the bucket, the metadata server's when empty")
+		ignore  = flag.String("ignore", strings.Join(capture.DefaultIgnored, ","), "comma separated body fields not compared")
+		timeout = flag.Duration("timeout", 10*time.Second, "the timeout of every replayed request")
+		verbose = flag.Bool("v", false, "report the matching exchanges too")
+		header  = headers{}
+	)
+	flag.Var(header, "H", `a header replacing the redacted ones, e.g. "Authorization: Bearer ...", repeatable`)
+	flag.Parse()
+	if *session == "" || *target == "" || (*bucket == "") == (*dir == "") {
+		fmt.Fprintln(os.Stderr, "replay: -session, -target and one of -bucket and -dir are required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var src capture.Source
+	switch {
+	case *dir != "":
+		src = capture.NewDirSource(*dir)
+	case *token != "":
+		src = capture.NewBucketSource(gcp.NewBucketClient(*bucket, &http.Client{Transport: &gcp.Transport{Source: gcp.StaticTokenSource(*token)}}), *prefix)
+	default:
+		src = capture.NewBucketSource(gcp.NewBucket(*bucket), *prefix)
+	}
+
+	var ignored []string
+	for _, f := range strings.Split(*ignore, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			ignored = append(ignored, f)
+		}
+	}
+	r := capture.NewReplayer(*target, &http.Client{Timeout: *timeout}, http.Header(header), ignored)
+
+	var replayed, failed int
+	err := r.Replay(context.Background(), src, *session, func(res capture.Result) {
+		replayed++
+		ex := res.Exchange
+		line := fmt.Sprintf("%04d %s %s", ex.Seq, ex.Request.Method, ex.Request.URL)
+		if res.Redacted {
+			line += " (redacted)"
+		}
+		switch {
+		case res.Err != nil:
+			failed++
+			fmt.Printf("ERROR %s: %v\n", line, res.Err)
+		case !res.OK():
+			failed++
+			fmt.Printf("DIFF  %s\n", line)
+			for _, d := range res.Diffs {
+				fmt.Printf("      %s\n", d)
+			}
+		case *verbose:
+			fmt.Printf("OK    %s %d\n", line, res.Status)
+		}
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%d replayed, %d matching, %d differing\n", replayed, replayed-failed, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,298 @@
+// Package config reads the configuration of the add service from the
+// environment. Every variable is a field of Config, tagged with its
+// environment variable and default value, and kept as read: its users parse
+// it, reporting its Env when it's invalid.
+package config
+
+import (
+	"context"
+	"os"
+	"reflect"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/expand"
+)
+
+// Var is a configuration variable.
+type Var struct {
+	// Env is the environment variable it's read from.
+	Env string
+	// Value is its value, or its default when the variable is unset, with
+	// its references expanded.
+	Value string
+}
+
+// Config is the configuration of the add service.
+type Config struct {
+	// The service and its listeners.
+	ServiceName Var `env:"QS_ADD_SERVICE_NAME" default:"add"`
+	LogLevel    Var `env:"QS_ADD_LOG_LEVEL" default:"error"`
+	ServiceHost Var `env:"QS_ADD_SERVICE_HOST" default:"localhost"`
+	HTTPPort    Var `env:"QS_ADD_HTTP_PORT" default:"8180"`
+	GRPCPort    Var `env:"QS_ADD_GRPC_PORT" default:"8181"`
+
+	// Request signatures, see newSignatureMiddleware.
+	SigKeys   Var `env:"QS_ADD_SIGNATURE_KEYS" default:""`
+	SigWindow Var `env:"QS_ADD_SIGNATURE_WINDOW" default:"5m"`
+
+	// Sessions, see newSessionManager.
+	SessionKey Var `env:"QS_ADD_SESSION_KEY" default:""`
+	SessionTTL Var `env:"QS_ADD_SESSION_TTL" default:"24h"`
+	SessionMax Var `env:"QS_ADD_SESSION_MAX_PER_PRINCIPAL" default:"5"`
+
+	// Idempotency keys, see newHTTPHandler.
+	IdempotencyTTL   Var `env:"QS_ADD_IDEMPOTENCY_TTL" default:"24h"`
+	IdempotencyRedis Var `env:"QS_ADD_IDEMPOTENCY_REDIS" default:""`
+
+	// Bloom filter of the idempotency keys, see newIdempotencyStore.
+	IdemFilterSize    Var `env:"QS_ADD_IDEMPOTENCY_FILTER_SIZE" default:"0"`
+	IdemFilterFPRate  Var `env:"QS_ADD_IDEMPOTENCY_FILTER_FP_RATE" default:"0.01"`
+	IdemFilterRefresh Var `env:"QS_ADD_IDEMPOTENCY_FILTER_REFRESH" default:"10m"`
+
+	// Authentication of the callers, see newAuthn.
+	JWTKey Var `env:"QS_ADD_JWT_KEY" default:""`
+
+	// The history repository, see newRepository.
+	HistoryStore       Var `env:"QS_ADD_HISTORY_STORE" default:"memory"`
+	DatastoreNamespace Var `env:"QS_ADD_DATASTORE_NAMESPACE" default:""`
+
+	// State shared by the instances, see newStateStore.
+	StateStore Var `env:"QS_ADD_STATE_STORE" default:"memory"`
+
+	// Configuration drift between instances, see newDriftChecker.
+	DriftPeers    Var `env:"QS_ADD_DRIFT_PEERS" default:""`
+	DriftInterval Var `env:"QS_ADD_DRIFT_INTERVAL" default:"1m"`
+
+	// Instance lifecycle events, see newLifecycleRecorder.
+	LifecycleTopic Var `env:"QS_ADD_LIFECYCLE_TOPIC" default:""`
+
+	// Tracing, see newTracerProvider.
+	TraceExporter    Var `env:"QS_ADD_TRACE_EXPORTER" default:"none"`
+	TraceEndpoint    Var `env:"QS_ADD_TRACE_ENDPOINT" default:""`
+	TraceSampleRatio Var `env:"QS_ADD_TRACE_SAMPLE_RATIO" default:"1"`
+
+	// Routes turned off at runtime, see newKillSwitches.
+	RouteFlags         Var `env:"QS_ADD_ROUTE_FLAGS" default:""`
+	RouteFlagsInterval Var `env:"QS_ADD_ROUTE_FLAGS_INTERVAL" default:"30s"`
+
+	// Quota of the privileged headers, see newRateLimitStage.
+	PrivilegedInterval Var `env:"QS_ADD_PRIVILEGED_INTERVAL" default:"1m"`
+	PrivilegedBurst    Var `env:"QS_ADD_PRIVILEGED_BURST" default:"5"`
+
+	// Log format, see newLogger.
+	LogFormat Var `env:"QS_ADD_LOG_FORMAT" default:""`
+
+	// Wire-level captures of the requests.
+	CaptureBucket Var `env:"QS_ADD_CAPTURE_BUCKET" default:""`
+
+	// Request and response bodies of the HTTP transport.
+	Pooling      Var `env:"QS_ADD_POOLING" default:"false"`
+	MaxBodyBytes Var `env:"QS_ADD_MAX_BODY_BYTES" default:"1048576"`
+	Gzip         Var `env:"QS_ADD_GZIP" default:"false"`
+
+	// Cache snapshots shared between instances, see newSnapshots.
+	CacheSnapshot         Var `env:"QS_ADD_CACHE_SNAPSHOT" default:""`
+	CacheSnapshotInterval Var `env:"QS_ADD_CACHE_SNAPSHOT_INTERVAL" default:"10m"`
+	CacheSnapshotMaxAge   Var `env:"QS_ADD_CACHE_SNAPSHOT_MAX_AGE" default:"1h"`
+
+	// Batched history writes, see newRepository.
+	BatchWindow Var `env:"QS_ADD_BATCH_WINDOW" default:"0s"`
+	BatchSize   Var `env:"QS_ADD_BATCH_SIZE" default:"100"`
+
+	// Tenancy, see newTenantDirectory.
+	RequireTenant     Var `env:"QS_ADD_REQUIRE_TENANT" default:"false"`
+	TenantDirectory   Var `env:"QS_ADD_TENANT_DIRECTORY" default:""`
+	TenantCacheTTL    Var `env:"QS_ADD_TENANT_CACHE_TTL" default:"1m"`
+	TenantCacheJitter Var `env:"QS_ADD_TENANT_CACHE_JITTER" default:"10s"`
+	TenantCacheStale  Var `env:"QS_ADD_TENANT_CACHE_STALE" default:"5m"`
+
+	// Canonical JSON responses.
+	CanonicalJSON Var `env:"QS_ADD_CANONICAL_JSON" default:""`
+
+	// Order of the endpoint middlewares, see package chain.
+	MiddlewareOrder Var `env:"QS_ADD_MIDDLEWARE_ORDER" default:"logging,tracing,metrics,auth,ratelimit,circuitbreaker"`
+
+	// Transports built in with a build tag.
+	AMQPURL    Var `env:"QS_ADD_AMQP_URL" default:""`
+	ThriftPort Var `env:"QS_ADD_THRIFT_PORT" default:""`
+
+	// Compatibility probe of the peers, see newCompatProbe.
+	CompatPeers    Var `env:"QS_ADD_COMPAT_PEERS" default:""`
+	CompatInterval Var `env:"QS_ADD_COMPAT_INTERVAL" default:"1m"`
+
+	// History circuit breaker and degraded mode, see newHistoryBreaker.
+	BreakerThreshold Var `env:"QS_ADD_HISTORY_BREAKER_THRESHOLD" default:"5"`
+	BreakerCooldown  Var `env:"QS_ADD_HISTORY_BREAKER_COOLDOWN" default:"30s"`
+	DegradeQueueSize Var `env:"QS_ADD_DEGRADE_QUEUE_SIZE" default:"1000"`
+
+	// Response envelope.
+	Envelope Var `env:"QS_ADD_ENVELOPE" default:"false"`
+
+	// Anomaly detection, see newAnomalyEvaluator.
+	AnomalyMetrics  Var `env:"QS_ADD_ANOMALY_METRICS" default:""`
+	AnomalyDetector Var `env:"QS_ADD_ANOMALY_DETECTOR" default:"zscore"`
+	AnomalyInterval Var `env:"QS_ADD_ANOMALY_INTERVAL" default:"1m"`
+	AnomalyWebhooks Var `env:"QS_ADD_ANOMALY_WEBHOOKS" default:""`
+
+	// Usage metering, see newMeter.
+	DailyQuota    Var `env:"QS_ADD_DAILY_QUOTA" default:"0"`
+	UsageDays     Var `env:"QS_ADD_USAGE_DAYS" default:"30"`
+	MeteringRedis Var `env:"QS_ADD_METERING_REDIS" default:""`
+
+	// Bulkhead, see newBulkheadMiddleware.
+	BulkheadLimit   Var `env:"QS_ADD_BULKHEAD_LIMIT" default:"0"`
+	BulkheadQueue   Var `env:"QS_ADD_BULKHEAD_QUEUE" default:"50"`
+	BulkheadMaxWait Var `env:"QS_ADD_BULKHEAD_MAX_WAIT" default:"100ms"`
+
+	// Adaptive load shedding, see newLoadSheddingMiddleware.
+	LoadShedLimit    Var `env:"QS_ADD_LOADSHED_LIMIT" default:"0"`
+	LoadShedMaxLimit Var `env:"QS_ADD_LOADSHED_MAX_LIMIT" default:"1000"`
+
+	// Datastore index definitions checked at startup.
+	IndexFile Var `env:"QS_ADD_INDEX_FILE" default:""`
+
+	// Configuration profile.
+	Profile Var `env:"QS_ADD_PROFILE" default:""`
+
+	// Outbox of the domain events, see newOutbox.
+	OutboxTopic    Var `env:"QS_ADD_OUTBOX_TOPIC" default:""`
+	OutboxInterval Var `env:"QS_ADD_OUTBOX_INTERVAL" default:"10s"`
+
+	// Operation budget of the requests, see newOpBudgetMiddleware.
+	OpBudget       Var `env:"QS_ADD_OP_BUDGET" default:"10"`
+	OpBudgetStrict Var `env:"QS_ADD_OP_BUDGET_STRICT" default:"false"`
+
+	// Event store, see newEventStore.
+	EventStore Var `env:"QS_ADD_EVENT_STORE" default:""`
+
+	// Clock skew tolerated on the JWT time claims.
+	JWTLeeway Var `env:"QS_ADD_JWT_LEEWAY" default:"0s"`
+
+	// Feature flags, see newFeatureFlags.
+	FeatureFlags         Var `env:"QS_ADD_FEATURE_FLAGS" default:"env"`
+	FeatureFlagsInterval Var `env:"QS_ADD_FEATURE_FLAGS_INTERVAL" default:"1m"`
+
+	// Experiments, see newExperimentMiddleware.
+	Experiment Var `env:"QS_ADD_EXPERIMENT" default:""`
+
+	// Calculation IDs, see newIDGenerator.
+	IDGenerator Var `env:"QS_ADD_ID_GENERATOR" default:"uuidv7"`
+	IDMachineID Var `env:"QS_ADD_ID_MACHINE_ID" default:""`
+
+	// Canary routing, see newCanaryMiddleware.
+	CanaryURL           Var `env:"QS_ADD_CANARY_URL" default:""`
+	CanaryPercent       Var `env:"QS_ADD_CANARY_PERCENT" default:"5"`
+	CanaryShadow        Var `env:"QS_ADD_CANARY_SHADOW" default:"false"`
+	CanaryShadowTimeout Var `env:"QS_ADD_CANARY_SHADOW_TIMEOUT" default:"5s"`
+
+	// Token exchange of the downstream calls, see newTokenExchange.
+	TokenExchangeURL          Var `env:"QS_ADD_TOKEN_EXCHANGE_URL" default:""`
+	TokenExchangeClientID     Var `env:"QS_ADD_TOKEN_EXCHANGE_CLIENT_ID" default:""`
+	TokenExchangeClientSecret Var `env:"QS_ADD_TOKEN_EXCHANGE_CLIENT_SECRET" default:""`
+	TokenExchangeAudience     Var `env:"QS_ADD_TOKEN_EXCHANGE_AUDIENCE" default:""`
+	TokenExchangeScopes       Var `env:"QS_ADD_TOKEN_EXCHANGE_SCOPES" default:""`
+	TokenExchangeCacheSkew    Var `env:"QS_ADD_TOKEN_EXCHANGE_CACHE_SKEW" default:"30s"`
+
+	// Documentation links of the errors.
+	ErrorHelpURL Var `env:"QS_ADD_ERROR_HELP_URL" default:""`
+
+	// Traffic mirroring, see newMirror.
+	MirrorURL     Var `env:"QS_ADD_MIRROR_URL" default:""`
+	MirrorPercent Var `env:"QS_ADD_MIRROR_PERCENT" default:"1"`
+	MirrorTimeout Var `env:"QS_ADD_MIRROR_TIMEOUT" default:"10s"`
+
+	// Error details returned, see newErrorVerbosity.
+	ErrorVerbosity Var `env:"QS_ADD_ERROR_VERBOSITY" default:""`
+
+	// Fault injection, see newChaosMiddleware.
+	Chaos              Var `env:"QS_ADD_CHAOS" default:""`
+	ChaosRequireHeader Var `env:"QS_ADD_CHAOS_REQUIRE_HEADER" default:"true"`
+	ChaosMaxLatency    Var `env:"QS_ADD_CHAOS_MAX_LATENCY" default:"30s"`
+
+	// Request and response transforms, see newTransformer.
+	Transforms Var `env:"QS_ADD_TRANSFORMS" default:""`
+
+	// Routes accepting XML requests.
+	XMLRoutes Var `env:"QS_ADD_XML_ROUTES" default:""`
+
+	// Strict decoding of the JSON requests.
+	StrictDecoding Var `env:"QS_ADD_STRICT_DECODING" default:"false"`
+
+	// Resumable exports, see newDownloads.
+	DownloadTTL      Var `env:"QS_ADD_DOWNLOAD_TTL" default:"0"`
+	DownloadBucket   Var `env:"QS_ADD_DOWNLOAD_BUCKET" default:""`
+	DownloadMaxBytes Var `env:"QS_ADD_DOWNLOAD_MAX_BYTES" default:"33554432"`
+	DownloadKey      Var `env:"QS_ADD_DOWNLOAD_KEY" default:""`
+
+	// Notification preferences, see newNotifications.
+	NotifyKey          Var `env:"QS_ADD_NOTIFY_KEY" default:""`
+	NotifyConfirmURL   Var `env:"QS_ADD_NOTIFY_CONFIRM_URL" default:""`
+	NotifyConfirmTTL   Var `env:"QS_ADD_NOTIFY_CONFIRM_TTL" default:"24h"`
+	NotifySMTPAddr     Var `env:"QS_ADD_NOTIFY_SMTP_ADDR" default:""`
+	NotifySMTPFrom     Var `env:"QS_ADD_NOTIFY_SMTP_FROM" default:""`
+	NotifySMTPUsername Var `env:"QS_ADD_NOTIFY_SMTP_USERNAME" default:""`
+	NotifySMTPPassword Var `env:"QS_ADD_NOTIFY_SMTP_PASSWORD" default:""`
+
+	// Export deliveries, see newDeliveries.
+	DeliveryURL Var `env:"QS_ADD_DELIVERY_URL" default:""`
+
+	// Pub/Sub subscriber, see startPubSubServer.
+	PubSubSubscription      Var `env:"QS_ADD_PUBSUB_SUBSCRIPTION" default:""`
+	PubSubMaxMessages       Var `env:"QS_ADD_PUBSUB_MAX_OUTSTANDING_MESSAGES" default:"1000"`
+	PubSubMaxBytes          Var `env:"QS_ADD_PUBSUB_MAX_OUTSTANDING_BYTES" default:"1073741824"`
+	PubSubWorkers           Var `env:"QS_ADD_PUBSUB_WORKERS" default:"10"`
+	PubSubAckDeadline       Var `env:"QS_ADD_PUBSUB_ACK_DEADLINE" default:"1m"`
+	PubSubMaxExtension      Var `env:"QS_ADD_PUBSUB_MAX_EXTENSION" default:"1h"`
+	PubSubOrderingAttribute Var `env:"QS_ADD_PUBSUB_ORDERING_ATTRIBUTE" default:""`
+
+	// Fast path of the endpoints, see newObservability.
+	FastPath        Var `env:"QS_ADD_FAST_PATH" default:"false"`
+	EndpointMetrics Var `env:"QS_ADD_ENDPOINT_METRICS" default:"true"`
+}
+
+// Error is the error of a variable whose references failed to expand.
+type Error struct {
+	Env string
+	Err error
+}
+
+func (e *Error) Error() string {
+	return e.Env + ": " + e.Err.Error()
+}
+
+// Load reads the configuration from the environment, expanding references
+// such as ${GAE_SERVICE} or ${SECRET:jwt-key} in the values, the secrets
+// resolved by secrets.
+func Load(ctx context.Context, secrets expand.Resolver) (Config, error) {
+	expander := expand.New(map[string]expand.Resolver{
+		"SECRET": secrets,
+	})
+	var cfg Config
+	v := reflect.ValueOf(&cfg).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		env := f.Tag.Get("env")
+		value := os.Getenv(env)
+		if value == "" {
+			value = f.Tag.Get("default")
+		}
+		value, err := expander.Expand(ctx, value)
+		if err != nil {
+			return Config{}, &Error{Env: env, Err: err}
+		}
+		v.Field(i).Set(reflect.ValueOf(Var{Env: env, Value: value}))
+	}
+	return cfg, nil
+}
+
+// Values returns the effective configuration, keyed by environment
+// variable.
+func (c Config) Values() map[string]string {
+	v := reflect.ValueOf(c)
+	values := make(map[string]string, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i).Interface().(Var)
+		values[f.Env] = f.Value
+	}
+	return values
+}
@@ -0,0 +1,293 @@
+// Package delivery delivers the exports of the history of the callers to
+// their notification channels, sparing them a connection kept open for a
+// large export: the export is written to the download store, then its
+// signed download link is notified as an EventReady event. A delivery is a
+// saga, see package saga, whose state is saved at every step: the export is
+// deleted, and its link revoked, when the notification fails, and the
+// deliveries of an instance that stopped are resumed by Resume.
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/url"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/repository"
+	"github.com/cage1016/gokit-gae/internal/app/add/service"
+	"github.com/cage1016/gokit-gae/internal/pkg/capability"
+	"github.com/cage1016/gokit-gae/internal/pkg/clock"
+	"github.com/cage1016/gokit-gae/internal/pkg/download"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/notify"
+	"github.com/cage1016/gokit-gae/internal/pkg/saga"
+	"github.com/cage1016/gokit-gae/internal/pkg/tenant"
+)
+
+const (
+	// SagaName is the saga of the deliveries, naming their saved states.
+	SagaName = "export-delivery"
+
+	// EventReady is the type of the events notifying the deliveries.
+	EventReady = "export.ready"
+
+	// ResumeAfter is how long a delivery is left to the instance running
+	// it, since it last saved its state, before Resume resumes it: longer
+	// than its slowest step, the notification retried.
+	ResumeAfter = 5 * time.Minute
+)
+
+// backoff is the delay before the second attempt of a step, doubled at
+// every attempt after it.
+var backoff = time.Second
+
+var (
+	// ErrNoChannel indicates a caller without verified channel subscribed
+	// to EventReady, whom the delivery couldn't reach.
+	ErrNoChannel = errors.Register(errors.KindFailedPrecondition, errors.NewCoded("DELIVERY-001", "no verified channel subscribed to export.ready"))
+
+	// ErrNotFound indicates a delivery unknown, or of another caller.
+	ErrNotFound = errors.Register(errors.KindNotFound, errors.NewCoded("DELIVERY-002", "delivery not found"))
+
+	// ErrMissingPrincipal indicates a request without authenticated caller.
+	ErrMissingPrincipal = errors.Register(errors.KindUnauthenticated, errors.NewCoded("DELIVERY-003", "missing principal"))
+)
+
+// Request is the delivery of the history of Principal, of Tenant when
+// the service serves tenants, filtered as an export.
+type Request struct {
+	Principal string    `json:"principal"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Method    string    `json:"method,omitempty"`
+	Since     time.Time `json:"since,omitempty"`
+	Until     time.Time `json:"until,omitempty"`
+}
+
+// Delivery is the status of a delivery.
+type Delivery struct {
+	ID     string      `json:"id"`
+	Status saga.Status `json:"status"`
+	// URL is the download link of the export, once delivered.
+	URL       string    `json:"url,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// artifact is the result of the export step.
+type artifact struct {
+	Name  string `json:"name"`
+	Bytes int    `json:"bytes"`
+}
+
+// link is the result of the link step.
+type link struct {
+	URL string `json:"url"`
+}
+
+// ReadyEvent is the data of the EventReady events.
+type ReadyEvent struct {
+	ID    string `json:"id"`
+	URL   string `json:"url"`
+	Name  string `json:"name"`
+	Bytes int    `json:"bytes"`
+}
+
+// Deliveries runs the deliveries.
+type Deliveries struct {
+	svc       service.AddService
+	downloads download.Store
+	issuer    capability.Issuer
+	maxBytes  int
+	link      *url.URL
+	channels  notify.Service
+	notifier  *notify.Notifier
+	store     saga.Store
+	saga      *saga.Saga
+	logger    log.Logger
+}
+
+// New returns the deliveries of the exports of svc, of at most maxBytes,
+// kept in downloads under the delivery ID and served at link, the absolute
+// URL of the download route, with a token of issuer. They're notified with
+// notifier to the channels channels has verified, and their state is saved
+// to store.
+func New(svc service.AddService, downloads download.Store, issuer capability.Issuer, maxBytes int, link *url.URL, channels notify.Service, notifier *notify.Notifier, store saga.Store, logger log.Logger) *Deliveries {
+	d := &Deliveries{
+		svc:       svc,
+		downloads: downloads,
+		issuer:    issuer,
+		maxBytes:  maxBytes,
+		link:      link,
+		channels:  channels,
+		notifier:  notifier,
+		store:     store,
+		logger:    logger,
+	}
+	d.saga = saga.New(SagaName, store, logger,
+		saga.Step{Name: "export", Action: d.export, Compensate: d.remove, Attempts: 3, Backoff: backoff},
+		saga.Step{Name: "link", Action: d.sign, Compensate: d.revoke, Attempts: 3, Backoff: backoff},
+		saga.Step{Name: "notify", Action: d.notify, Attempts: 5, Backoff: backoff},
+	)
+	return d
+}
+
+// Start starts the delivery of req, run in the background, failing with
+// ErrNoChannel when the caller couldn't be notified.
+func (d *Deliveries) Start(ctx context.Context, req Request) (Delivery, error) {
+	if err := d.reachable(ctx, req.Principal); err != nil {
+		return Delivery{}, err
+	}
+	s, err := d.saga.Start(ctx, download.NewToken(), req)
+	if err != nil {
+		return Delivery{}, err
+	}
+	go d.run(context.Background(), s.ID)
+	return view(s), nil
+}
+
+// Get returns the delivery id of principal.
+func (d *Deliveries) Get(ctx context.Context, principal, id string) (Delivery, error) {
+	s, err := d.store.Load(ctx, id)
+	if err != nil {
+		if errors.Contains(errors.Cast(err), saga.ErrNotFound) {
+			return Delivery{}, ErrNotFound
+		}
+		return Delivery{}, err
+	}
+	var req Request
+	if s.Saga != SagaName || s.DecodeInput(&req) != nil || req.Principal != principal {
+		return Delivery{}, ErrNotFound
+	}
+	return view(s), nil
+}
+
+// Resume runs the deliveries left unfinished ResumeAfter ago, by an
+// instance that stopped, to their end, and returns their number. The
+// deliveries failing are only logged, as they are when not resumed.
+func (d *Deliveries) Resume(ctx context.Context) (int, error) {
+	ids, err := d.store.Unfinished(ctx, SagaName, clock.System.Now().Add(-ResumeAfter))
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		d.run(ctx, id)
+	}
+	return len(ids), nil
+}
+
+// run runs the delivery id to its end.
+func (d *Deliveries) run(ctx context.Context, id string) {
+	if _, err := d.saga.Run(ctx, id, nil); err != nil {
+		level.Warn(d.logger).Log("delivery", id, "err", err)
+	}
+}
+
+// reachable returns ErrNoChannel when principal has no channel to notify
+// the delivery to.
+func (d *Deliveries) reachable(ctx context.Context, principal string) error {
+	channels, err := d.channels.Channels(ctx, principal, EventReady)
+	if err != nil {
+		return err
+	}
+	if len(channels) == 0 {
+		return ErrNoChannel
+	}
+	return nil
+}
+
+// export writes the export of the request of s to the artifact of s.ID,
+// replacing the one of an earlier attempt.
+func (d *Deliveries) export(ctx context.Context, s *saga.State) (interface{}, error) {
+	var req Request
+	if err := s.DecodeInput(&req); err != nil {
+		return nil, err
+	}
+	if req.Tenant != "" {
+		ctx = tenant.HeaderToContext(ctx, req.Tenant)
+	}
+	it, err := d.svc.Export(ctx, repository.Filter{Method: req.Method, Caller: req.Principal, Since: req.Since, Until: req.Until})
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for {
+		c, err := it.Next(ctx)
+		if err == repository.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := enc.Encode(c); err != nil {
+			return nil, err
+		}
+		if buf.Len() > d.maxBytes {
+			return nil, download.ErrTooLarge
+		}
+	}
+	a := download.Artifact{Name: "export.ndjson", ContentType: "application/x-ndjson", Created: clock.System.Now(), Data: buf.Bytes()}
+	if err := d.downloads.Put(ctx, s.ID, a); err != nil {
+		return nil, err
+	}
+	return artifact{Name: a.Name, Bytes: len(a.Data)}, nil
+}
+
+// remove compensates export.
+func (d *Deliveries) remove(ctx context.Context, s *saga.State) (interface{}, error) {
+	return nil, d.downloads.Delete(ctx, s.ID)
+}
+
+// sign returns the download link of the artifact of s.ID.
+func (d *Deliveries) sign(ctx context.Context, s *saga.State) (interface{}, error) {
+	u := *d.link
+	u.RawQuery = url.Values{"id": {s.ID}}.Encode()
+	signed, err := d.issuer.SignURL(&u, s.ID, capability.ScopeDownload, 0)
+	if err != nil {
+		return nil, err
+	}
+	return link{URL: signed.String()}, nil
+}
+
+// revoke compensates sign.
+func (d *Deliveries) revoke(ctx context.Context, s *saga.State) (interface{}, error) {
+	return nil, d.issuer.Revoke(ctx, s.ID)
+}
+
+// notify notifies the download link of s to the channels of its caller,
+// failing with ErrNoChannel when none remains.
+func (d *Deliveries) notify(ctx context.Context, s *saga.State) (interface{}, error) {
+	var (
+		req Request
+		a   artifact
+		l   link
+	)
+	if err := s.DecodeInput(&req); err != nil {
+		return nil, err
+	}
+	if err := s.DecodeResult("export", &a); err != nil {
+		return nil, err
+	}
+	if err := s.DecodeResult("link", &l); err != nil {
+		return nil, err
+	}
+	if err := d.reachable(ctx, req.Principal); err != nil {
+		return nil, err
+	}
+	e := notify.Event{Type: EventReady, Time: clock.System.Now(), Data: ReadyEvent{ID: s.ID, URL: l.URL, Name: a.Name, Bytes: a.Bytes}}
+	return nil, d.notifier.Notify(ctx, req.Principal, e)
+}
+
+// view returns the delivery of s.
+func view(s *saga.State) Delivery {
+	dl := Delivery{ID: s.ID, Status: s.Status, Error: s.Error, UpdatedAt: s.UpdatedAt}
+	var l link
+	if s.Status == saga.Completed && s.DecodeResult("link", &l) == nil {
+		dl.URL = l.URL
+	}
+	return dl
+}
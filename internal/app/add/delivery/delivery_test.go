@@ -0,0 +1,159 @@
+package delivery
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/repository"
+	"github.com/cage1016/gokit-gae/internal/app/add/service/mocks"
+	"github.com/cage1016/gokit-gae/internal/pkg/capability"
+	"github.com/cage1016/gokit-gae/internal/pkg/clock"
+	"github.com/cage1016/gokit-gae/internal/pkg/download"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/notify"
+	"github.com/cage1016/gokit-gae/internal/pkg/saga"
+)
+
+func init() {
+	backoff = time.Millisecond
+}
+
+// sliceIterator iterates over calculations.
+type sliceIterator []repository.Calculation
+
+func (it *sliceIterator) Next(context.Context) (repository.Calculation, error) {
+	if len(*it) == 0 {
+		return repository.Calculation{}, repository.Done
+	}
+	c := (*it)[0]
+	*it = (*it)[1:]
+	return c, nil
+}
+
+// sender records the events sent, failing them all with err unless nil.
+type sender struct {
+	mu     sync.Mutex
+	err    error
+	events []notify.Event
+}
+
+func (s *sender) Send(_ context.Context, _ notify.Channel, e notify.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, e)
+	return s.err
+}
+
+type fixture struct {
+	deliveries *Deliveries
+	downloads  download.Store
+	issuer     capability.Issuer
+	store      saga.Store
+	sender     *sender
+}
+
+func newFixture(t *testing.T, sendErr error) fixture {
+	svc := &mocks.Service{ExportFunc: func(ctx context.Context, filter repository.Filter) (repository.Iterator, error) {
+		if filter.Caller != "alice" {
+			t.Errorf("export of %q, want alice", filter.Caller)
+		}
+		return &sliceIterator{{ID: "1", Method: "sum", A: "1", B: "2", Result: "3", Caller: "alice"}}, nil
+	}}
+	prefs := notify.NewMemoryRepository()
+	prefs.Save(context.Background(), notify.Preferences{Principal: "alice", Channels: []notify.Channel{{Type: notify.Webhook, Target: "https://alice.example.com/hook", Verified: true}}})
+	channels := notify.NewService(prefs, nil, log.NewNopLogger())
+	f := fixture{
+		downloads: download.NewMemoryStore(clock.System, time.Hour, 1<<20),
+		issuer:    capability.New([]byte("key"), time.Hour, capability.NewMemoryRevocations()),
+		store:     saga.NewMemoryStore(),
+		sender:    &sender{err: sendErr},
+	}
+	notifier := notify.NewNotifier(channels, map[notify.ChannelType]notify.Sender{notify.Webhook: f.sender})
+	link, _ := url.Parse("https://add.example.com/api/add/history/export/download")
+	f.deliveries = New(svc, f.downloads, f.issuer, 1<<20, link, channels, notifier, f.store, log.NewNopLogger())
+	return f
+}
+
+// wait returns the delivery id of alice once it ended.
+func (f fixture) wait(t *testing.T, id string) Delivery {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		d, err := f.deliveries.Get(context.Background(), "alice", id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Status != saga.Running && d.Status != saga.Compensating {
+			return d
+		}
+	}
+	t.Fatalf("delivery %s didn't end", id)
+	return Delivery{}
+}
+
+func TestDelivered(t *testing.T) {
+	f := newFixture(t, nil)
+	d, err := f.deliveries.Start(context.Background(), Request{Principal: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.deliveries.Get(context.Background(), "bob", d.ID); err != ErrNotFound {
+		t.Errorf("Get of bob err = %v, want %v", err, ErrNotFound)
+	}
+	d = f.wait(t, d.ID)
+	if d.Status != saga.Completed || d.URL == "" {
+		t.Fatalf("delivery = %+v", d)
+	}
+
+	u, _ := url.Parse(d.URL)
+	if err := f.issuer.Verify(context.Background(), u.Query().Get(capability.QueryParam), d.ID, capability.ScopeDownload); err != nil {
+		t.Errorf("link token: %v", err)
+	}
+	a, err := f.downloads.Get(context.Background(), u.Query().Get("id"))
+	if err != nil || string(a.Data) != `{"id":"1","method":"sum","a":"1","b":"2","result":"3","caller":"alice","createdAt":"0001-01-01T00:00:00Z"}`+"\n" {
+		t.Errorf("artifact = %q, %v", a.Data, err)
+	}
+	if len(f.sender.events) != 1 || f.sender.events[0].Type != EventReady || f.sender.events[0].Data.(ReadyEvent).URL != d.URL {
+		t.Errorf("events = %+v", f.sender.events)
+	}
+}
+
+func TestNotificationFailed(t *testing.T) {
+	f := newFixture(t, errors.New("webhook down"))
+	d, err := f.deliveries.Start(context.Background(), Request{Principal: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d = f.wait(t, d.ID)
+	if d.Status != saga.Compensated || d.URL != "" {
+		t.Fatalf("delivery = %+v", d)
+	}
+	if len(f.sender.events) != 5 {
+		t.Errorf("%d notifications, want 5 attempts", len(f.sender.events))
+	}
+
+	// the export is deleted, and its link revoked
+	if _, err := f.downloads.Get(context.Background(), d.ID); err != download.ErrNotFound {
+		t.Errorf("artifact err = %v, want %v", err, download.ErrNotFound)
+	}
+	s, _ := f.store.Load(context.Background(), d.ID)
+	var l link
+	if err := s.DecodeResult("link", &l); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(l.URL)
+	err = f.issuer.Verify(context.Background(), u.Query().Get(capability.QueryParam), d.ID, capability.ScopeDownload)
+	if err == nil || !errors.Contains(errors.Cast(err), capability.ErrTokenRevoked) {
+		t.Errorf("link token err = %v, want %v", err, capability.ErrTokenRevoked)
+	}
+}
+
+func TestNoChannel(t *testing.T) {
+	f := newFixture(t, nil)
+	if _, err := f.deliveries.Start(context.Background(), Request{Principal: "bob"}); err != ErrNoChannel {
+		t.Errorf("err = %v, want %v", err, ErrNoChannel)
+	}
+}
@@ -0,0 +1,123 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/go-zoo/bone"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/endpoints"
+	"github.com/cage1016/gokit-gae/internal/pkg/auth"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/responses"
+	"github.com/cage1016/gokit-gae/internal/pkg/tenant"
+	"github.com/cage1016/gokit-gae/internal/pkg/timecodec"
+)
+
+// Path is where the callers start their deliveries, and get them at
+// Path/<id>.
+const Path = "/api/add/deliveries"
+
+type getRequest struct {
+	ID string
+}
+
+// MakeHTTPHandler returns a handler starting the deliveries of the caller
+// with POST Path, filtered by the method, since and until query parameters
+// of the exports, answered 202 Accepted with their Location, and serving
+// them at GET Path/<id>. The caller is the "sub" claim of the JWT verified
+// by authn, e.g. a kitjwt.NewParser middleware; its tenant is resolved as
+// the API resolves it.
+func MakeHTTPHandler(d *Deliveries, authn endpoint.Middleware, logger log.Logger) http.Handler {
+	options := []httptransport.ServerOption{
+		httptransport.ServerErrorEncoder(encodeError),
+		httptransport.ServerErrorLogger(logger),
+		httptransport.ServerBefore(kitjwt.HTTPToContext(), tenant.HTTPToContext()),
+	}
+
+	mux := bone.New()
+	mux.Post(Path, httptransport.NewServer(
+		authn(tenant.Middleware()(makeStartEndpoint(d))),
+		decodeStartRequest,
+		encodeStartResponse,
+		options...,
+	))
+	mux.Get(Path+"/:id", httptransport.NewServer(
+		authn(makeGetEndpoint(d)),
+		decodeGetRequest,
+		encodeResponse,
+		options...,
+	))
+	return mux
+}
+
+func makeStartEndpoint(d *Deliveries) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(Request)
+		if req.Principal = auth.Principal(ctx); req.Principal == "" {
+			return nil, ErrMissingPrincipal
+		}
+		req.Tenant = tenant.FromContext(ctx)
+		return d.Start(ctx, req)
+	}
+}
+
+func makeGetEndpoint(d *Deliveries) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		principal := auth.Principal(ctx)
+		if principal == "" {
+			return nil, ErrMissingPrincipal
+		}
+		return d.Get(ctx, principal, request.(getRequest).ID)
+	}
+}
+
+func decodeStartRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	q := r.URL.Query()
+	rng, err := timecodec.ParseRange(q, "since", "until")
+	if err != nil {
+		return nil, err
+	}
+	switch q.Get("method") {
+	case "", "sum", "concat":
+	default:
+		return nil, endpoints.ErrInvalidQueryParams
+	}
+	return Request{Method: q.Get("method"), Since: rng.Since, Until: rng.Until}, nil
+}
+
+func decodeGetRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return getRequest{ID: bone.GetValue(r, "id")}, nil
+}
+
+func encodeStartResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	w.Header().Set("Location", Path+"/"+response.(Delivery).ID)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusAccepted)
+	return json.NewEncoder(w).Encode(responses.DataRes{Data: response})
+}
+
+func encodeResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	return json.NewEncoder(w).Encode(responses.DataRes{Data: response})
+}
+
+func encodeError(_ context.Context, err error, w http.ResponseWriter) {
+	code := errors.HTTPStatus(errors.KindOf(err))
+	ce := errors.Cast(err)
+	switch {
+	case errors.Contains(ce, kitjwt.ErrTokenContextMissing), errors.Contains(ce, kitjwt.ErrTokenInvalid),
+		errors.Contains(ce, kitjwt.ErrTokenExpired), errors.Contains(ce, kitjwt.ErrTokenMalformed),
+		errors.Contains(ce, kitjwt.ErrTokenNotActive):
+		code = http.StatusUnauthorized
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(responses.ErrorRes{Error: responses.ErrorResItem{Code: code, ErrorCode: errors.Code(err), Message: ce.Msg(), Errors: ce.Errors()}})
+}
@@ -0,0 +1,123 @@
+package endpoints
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-kit/kit/endpoint"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/chain"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// batchWorkers bounds how many operations of a concurrent batch run at once.
+const batchWorkers = 4
+
+type contextKey int
+
+// batchContextKey marks the context of the operations of a batch.
+const batchContextKey contextKey = iota
+
+// OncePerBatch returns f with its middlewares skipping the operations of a
+// batch, which run through the endpoints they wrap: they apply to the
+// batch as a whole only, e.g. to charge it once.
+func OncePerBatch(f chain.Factory) chain.Factory {
+	return func(method string) endpoint.Middleware {
+		m := f(method)
+		return func(next endpoint.Endpoint) endpoint.Endpoint {
+			wrapped := m(next)
+			return func(ctx context.Context, request interface{}) (interface{}, error) {
+				if ctx.Value(batchContextKey) != nil {
+					return next(ctx, request)
+				}
+				return wrapped(ctx, request)
+			}
+		}
+	}
+}
+
+// MakeBatchEndpoint returns an endpoint that runs each operation of a batch
+// through the given Sum and Concat endpoints, so the batch goes through the
+// same middlewares as single calls. A failing operation doesn't fail the
+// batch; its error is reported in its result and in the errors of the
+// response. Only when every operation fails does the batch fail, with an
+// errors.MultiError of ErrBatchFailed holding the error of each.
+func MakeBatchEndpoint(sum endpoint.Endpoint, concat endpoint.Endpoint) (ep endpoint.Endpoint) {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(BatchRequest)
+		if err := req.validate(); err != nil {
+			return BatchResponse{}, err
+		}
+
+		ctx = context.WithValue(ctx, batchContextKey, true)
+		results := make([]BatchResult, len(req.Operations))
+		failed := errors.NewMulti(ErrBatchFailed, "operations")
+		run := func(i int) {
+			var err error
+			results[i], err = runBatchOperation(ctx, i, req.Operations[i], sum, concat)
+			failed.Add(i, err)
+		}
+
+		if !req.Concurrent {
+			for i := range req.Operations {
+				run(i)
+			}
+			return batchResponse(results, failed)
+		}
+
+		jobs := make(chan int)
+		var wg sync.WaitGroup
+		for w := 0; w < batchWorkers && w < len(req.Operations); w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range jobs {
+					run(i)
+				}
+			}()
+		}
+		for i := range req.Operations {
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+
+		return batchResponse(results, failed)
+	}
+}
+
+// batchResponse returns the response of a batch, failing with failed when
+// every operation failed.
+func batchResponse(results []BatchResult, failed *errors.MultiError) (interface{}, error) {
+	if failed.Len() == len(results) {
+		return BatchResponse{}, failed
+	}
+	var errs []errors.Errors
+	if failed.Len() > 0 {
+		errs = failed.Errors()[1:]
+	}
+	return BatchResponse{Results: results, Errors: errs}, nil
+}
+
+func runBatchOperation(ctx context.Context, i int, op BatchOperation, sum endpoint.Endpoint, concat endpoint.Endpoint) (BatchResult, error) {
+	res := BatchResult{Index: i}
+	switch {
+	case op.Sum != nil:
+		resp, err := sum(ctx, *op.Sum)
+		if err != nil {
+			res.Error = err.Error()
+			return res, err
+		}
+		r := resp.(SumResponse)
+		res.Sum = &r
+	case op.Concat != nil:
+		resp, err := concat(ctx, *op.Concat)
+		if err != nil {
+			res.Error = err.Error()
+			return res, err
+		}
+		r := resp.(ConcatResponse)
+		res.Concat = &r
+	}
+	return res, nil
+}
@@ -0,0 +1,210 @@
+package endpoints_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	stdjwt "github.com/dgrijalva/jwt-go"
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/endpoints"
+	"github.com/cage1016/gokit-gae/internal/app/add/middlewares"
+	"github.com/cage1016/gokit-gae/internal/app/add/service/mocks"
+	"github.com/cage1016/gokit-gae/internal/pkg/auth"
+	"github.com/cage1016/gokit-gae/internal/pkg/chain"
+	"github.com/cage1016/gokit-gae/internal/pkg/clock"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// metrics are registered once, with the default Prometheus registry.
+var metrics = middlewares.NewPrometheusMetrics("chain_test", "endpoint")
+
+var errSum = errors.New("sum failed")
+
+// sumService answers at once, failing when a is negative.
+var sumService = &mocks.Service{SumFunc: func(_ context.Context, a, b int64) (int64, error) {
+	if a < 0 {
+		return 0, errSum
+	}
+	return a + b, nil
+}}
+
+func fastPath(tb testing.TB, order string, logger log.Logger, obs endpoints.Observability) endpoint.Endpoint {
+	c, err := chain.Parse(order)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	c = c.Use(chain.Auth, nil).Use(chain.RateLimit, nil).Use(chain.CircuitBreaker, nil)
+	eps, err := endpoints.NewFastPath(sumService, c, logger, metrics, obs)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return eps.SumEndpoint
+}
+
+// TestMissingStage checks a stage of the order without middleware fails
+// the endpoints instead of being skipped.
+func TestMissingStage(t *testing.T) {
+	c, err := chain.Parse("logging,ratelimit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := endpoints.NewFastPath(sumService, c, log.NewNopLogger(), metrics, endpoints.Observability{}); err == nil || !errors.Contains(errors.Cast(err), chain.ErrMissingStage) {
+		t.Errorf("NewFastPath err = %v, want %v", err, chain.ErrMissingStage)
+	}
+}
+
+// TestRateLimitOncePerBatch checks a batch is charged once, not once more
+// for each of its operations.
+func TestRateLimitOncePerBatch(t *testing.T) {
+	charged := map[string]int{}
+	quota := func(method string) endpoint.Middleware {
+		return func(next endpoint.Endpoint) endpoint.Endpoint {
+			return func(ctx context.Context, request interface{}) (interface{}, error) {
+				charged[method]++
+				return next(ctx, request)
+			}
+		}
+	}
+	c, err := chain.Parse("ratelimit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = c.Use(chain.RateLimit, endpoints.RateLimitStage(quota, func(next endpoint.Endpoint) endpoint.Endpoint { return next }))
+	eps, err := endpoints.NewFastPath(sumService, c, log.NewNopLogger(), metrics, endpoints.Observability{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := endpoints.BatchRequest{Operations: []endpoints.BatchOperation{
+		{Sum: &endpoints.SumRequest{A: 1, B: 2}},
+		{Sum: &endpoints.SumRequest{A: 3, B: 4}},
+	}}
+	if _, err := eps.BatchEndpoint(context.Background(), batch); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := eps.SumEndpoint(context.Background(), endpoints.SumRequest{A: 1, B: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"batch": 1, "sum": 1}; !reflect.DeepEqual(charged, want) {
+		t.Errorf("charged %v, want %v", charged, want)
+	}
+}
+
+// TestFastPath checks every Observability answers as the full chain does,
+// and only logs the failed calls when Logging is false.
+func TestFastPath(t *testing.T) {
+	cases := []struct {
+		name string
+		obs  endpoints.Observability
+		// logged tells whether the successful calls are logged.
+		logged bool
+	}{
+		{"full", endpoints.FullObservability, true},
+		{"none", endpoints.Observability{}, false},
+		{"logging", endpoints.Observability{Logging: true}, true},
+		{"tracing", endpoints.Observability{Tracing: true}, false},
+		{"metrics", endpoints.Observability{Metrics: true}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			e := fastPath(t, "", log.NewLogfmtLogger(&buf), tc.obs)
+
+			ctx := middlewares.StartLatency(context.Background())
+			res, err := e(ctx, endpoints.SumRequest{A: 1, B: 2})
+			if err != nil || res.(endpoints.SumResponse).Res != 3 {
+				t.Fatalf("Sum(1, 2) = %#v, %v, want 3", res, err)
+			}
+			if logged := buf.Len() > 0; logged != tc.logged {
+				t.Errorf("successful call logged %v, want %v: %s", logged, tc.logged, buf.String())
+			}
+
+			buf.Reset()
+			ctx = middlewares.StartLatency(context.Background())
+			if _, err := e(ctx, endpoints.SumRequest{A: -1, B: 2}); err == nil || !errors.Contains(errors.Cast(err), errSum) {
+				t.Fatalf("Sum(-1, 2) err = %v, want %v", err, errSum)
+			}
+			if !strings.Contains(buf.String(), "level=error") {
+				t.Errorf("failed call not logged as an error: %q", buf.String())
+			}
+		})
+	}
+}
+
+// TestFastPathAllocs checks the fast path saves the allocations of the
+// stages it leaves out.
+func TestFastPathAllocs(t *testing.T) {
+	logger := level.NewFilter(log.NewLogfmtLogger(ioutil.Discard), level.AllowInfo())
+	allocs := func(e endpoint.Endpoint) float64 {
+		return testing.AllocsPerRun(100, func() {
+			ctx := middlewares.StartLatency(context.Background())
+			e(ctx, endpoints.SumRequest{A: 1, B: 2})
+			middlewares.RecordLatency(ctx)
+		})
+	}
+	full := allocs(fastPath(t, "", logger, endpoints.FullObservability))
+	fast := allocs(fastPath(t, "", logger, endpoints.Observability{}))
+	if fast >= full {
+		t.Errorf("fast path allocates %v times, full chain %v", fast, full)
+	}
+}
+
+func BenchmarkChain(b *testing.B) {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.AlwaysSample())))
+	logger := level.NewFilter(log.NewLogfmtLogger(ioutil.Discard), level.AllowInfo())
+	logger = log.With(logger, "ts", log.DefaultTimestampUTC, "caller", log.DefaultCaller)
+
+	const key = "chain_test"
+	authn := auth.NewParser(func(*stdjwt.Token) (interface{}, error) {
+		return []byte(key), nil
+	}, stdjwt.SigningMethodHS256, clock.System, 0)
+	token, err := stdjwt.NewWithClaims(stdjwt.SigningMethodHS256, stdjwt.MapClaims{"sub": key}).SignedString([]byte(key))
+	if err != nil {
+		b.Fatal(err)
+	}
+	base := context.WithValue(context.Background(), kitjwt.JWTTokenContextKey, token)
+
+	// stage returns the Sum endpoint wrapped with the stages of order
+	// enabled by obs.
+	stage := func(order string, obs endpoints.Observability) func() endpoint.Endpoint {
+		return func() endpoint.Endpoint { return fastPath(b, order, logger, obs) }
+	}
+	benches := []struct {
+		name  string
+		build func() endpoint.Endpoint
+	}{
+		{"bare", func() endpoint.Endpoint { return endpoints.MakeSumEndpoint(sumService) }},
+		{"tenant+hooks", stage("auth", endpoints.Observability{})},
+		{"logging", stage("logging", endpoints.Observability{Logging: true})},
+		{"logging errors", stage("logging", endpoints.Observability{})},
+		{"tracing", stage("tracing", endpoints.Observability{Tracing: true})},
+		{"metrics", stage("metrics", endpoints.Observability{Metrics: true})},
+		{"auth", func() endpoint.Endpoint { return authn(endpoints.MakeSumEndpoint(sumService)) }},
+		{"full", func() endpoint.Endpoint { return authn(stage("", endpoints.FullObservability)()) }},
+		{"fast path", func() endpoint.Endpoint { return authn(stage("", endpoints.Observability{})()) }},
+	}
+	request := endpoints.SumRequest{A: 1, B: 2}
+	for _, bench := range benches {
+		b.Run(bench.name, func(b *testing.B) {
+			e := bench.build()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				ctx := middlewares.StartLatency(base)
+				if _, err := e(ctx, request); err != nil {
+					b.Fatal(err)
+				}
+				middlewares.RecordLatency(ctx)
+			}
+		})
+	}
+}
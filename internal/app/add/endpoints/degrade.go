@@ -0,0 +1,41 @@
+package endpoints
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-kit/kit/endpoint"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/repository"
+	"github.com/cage1016/gokit-gae/internal/pkg/auth"
+	"github.com/cage1016/gokit-gae/internal/pkg/chain"
+	"github.com/cage1016/gokit-gae/internal/pkg/degrade"
+	"github.com/cage1016/gokit-gae/internal/pkg/tenant"
+)
+
+// staleHistoryPages bounds the history pages kept to be served stale.
+const staleHistoryPages = 1000
+
+// DegradeStage returns the factory of the chain.CircuitBreaker stage, the
+// fallbacks the endpoints declare for the outages of the history store:
+// Sum, Concat and Batch still calculate, their history records deferred to
+// queue; History serves the page it last served for the same request and
+// caller. The operations of a batch run with the fallback of their batch.
+func DegradeStage(p *degrade.Policy, queue *degrade.Queue) chain.Factory {
+	stale := degrade.NewStale(historyPageKey, staleHistoryPages)
+	record := degrade.Fallback{Dependency: repository.Dependency, Mode: degrade.ModeQueue, Queue: queue}
+	p.Declare("sum", record).Declare("concat", record).Declare("batch", record)
+	p.Declare("history", degrade.Fallback{Dependency: repository.Dependency, Mode: degrade.ModeServeStale, Endpoint: stale.Endpoint()})
+
+	return OncePerBatch(func(method string) endpoint.Middleware {
+		if method == "history" {
+			return endpoint.Chain(p.Middleware(method), stale.Middleware())
+		}
+		return p.Middleware(method)
+	})
+}
+
+// historyPageKey identifies the history page of a request and its caller.
+func historyPageKey(ctx context.Context, request interface{}) string {
+	return fmt.Sprintf("%s|%s|%+v", tenant.FromContext(ctx), auth.Principal(ctx), request)
+}
@@ -6,47 +6,121 @@ import (
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
 
+	"github.com/cage1016/gokit-gae/internal/app/add/middlewares"
+	"github.com/cage1016/gokit-gae/internal/app/add/repository"
 	"github.com/cage1016/gokit-gae/internal/app/add/service"
+	"github.com/cage1016/gokit-gae/internal/pkg/chain"
+	"github.com/cage1016/gokit-gae/internal/pkg/hooks"
+	"github.com/cage1016/gokit-gae/internal/pkg/pagination"
+	"github.com/cage1016/gokit-gae/internal/pkg/tenant"
+	"github.com/cage1016/gokit-gae/internal/pkg/tracing"
 )
 
 // Endpoints collects all of the endpoints that compose the add service. It's
 // meant to be used as a helper struct, to collect all of the endpoints into a
 // single parameter.
 type Endpoints struct {
-	SumEndpoint    endpoint.Endpoint `json:""`
-	ConcatEndpoint endpoint.Endpoint `json:""`
+	SumEndpoint     endpoint.Endpoint `json:""`
+	ConcatEndpoint  endpoint.Endpoint `json:""`
+	HistoryEndpoint endpoint.Endpoint `json:""`
+	ExportEndpoint  endpoint.Endpoint `json:""`
+	BatchEndpoint   endpoint.Endpoint `json:""`
 }
 
 // New return a new instance of the endpoint that wraps the provided service.
-func New(svc service.AddService, logger log.Logger) (ep Endpoints) {
-	var sumEndpoint endpoint.Endpoint
-	{
-		method := "sum"
-		sumEndpoint = MakeSumEndpoint(svc)
-		sumEndpoint = LoggingMiddleware(log.With(logger, "method", method))(sumEndpoint)
-		ep.SumEndpoint = sumEndpoint
-	}
+// The logging, tracing and metrics middlewares are applied in the order of c,
+// together with the auth, ratelimit and circuitbreaker stages c must be
+// given, see chain.Chain.Then. The tenant is resolved and the latency
+// claimed before them, the service latency is measured within them. The
+// hooks registered with package hooks run right after the tenant is
+// resolved.
+func New(svc service.AddService, c *chain.Chain, logger log.Logger, metrics middlewares.Metrics) (Endpoints, error) {
+	return NewFastPath(svc, c, logger, metrics, FullObservability)
+}
+
+// Observability tells which of the logging, tracing and metrics stages
+// record anything.
+type Observability struct {
+	// Logging is false when only the failed calls are logged.
+	Logging bool
+	// Tracing is false when the spans are discarded.
+	Tracing bool
+	// Metrics is false when the endpoint metrics aren't exported.
+	Metrics bool
+}
+
+// FullObservability is the Observability of New.
+var FullObservability = Observability{Logging: true, Tracing: true, Metrics: true}
 
-	var concatEndpoint endpoint.Endpoint
-	{
-		method := "concat"
-		concatEndpoint = MakeConcatEndpoint(svc)
-		concatEndpoint = LoggingMiddleware(log.With(logger, "method", method))(concatEndpoint)
-		ep.ConcatEndpoint = concatEndpoint
+// NewFastPath returns the endpoints of New, leaving out the wrappers of the
+// stages obs disables, whose allocations every call would pay for nothing:
+// the calls are only logged when they fail, with ErrorLoggingMiddleware,
+// and the tracing and metrics stages are skipped, the latency layers with
+// the latter.
+func NewFastPath(svc service.AddService, c *chain.Chain, logger log.Logger, metrics middlewares.Metrics, obs Observability) (ep Endpoints, err error) {
+	c = c.Use(chain.Logging, func(method string) endpoint.Middleware {
+		if !obs.Logging {
+			return ErrorLoggingMiddleware(log.With(logger, "method", method))
+		}
+		return LoggingMiddleware(log.With(logger, "method", method))
+	})
+	c = c.Use(chain.Tracing, nil)
+	if obs.Tracing {
+		c = c.Use(chain.Tracing, tracing.TraceServer)
+	}
+	c = c.Use(chain.Metrics, nil)
+	if obs.Metrics {
+		c = c.Use(chain.Metrics, func(method string) endpoint.Middleware {
+			return middlewares.InstrumentingMiddleware(metrics, method)
+		})
+	}
+	wrap := func(method string, e endpoint.Endpoint) endpoint.Endpoint {
+		if err != nil {
+			return nil
+		}
+		if obs.Metrics {
+			e = middlewares.ServiceLatencyMiddleware(method)(e)
+		}
+		if e, err = c.Then(method, e); err != nil {
+			return nil
+		}
+		if obs.Metrics {
+			e = middlewares.LatencyMiddleware(metrics, method)(e)
+		}
+		e = hooks.Middleware(method)(e)
+		return tenant.Middleware()(e)
 	}
 
-	return ep
+	ep.SumEndpoint = wrap("sum", MakeSumEndpoint(svc))
+	ep.ConcatEndpoint = wrap("concat", MakeConcatEndpoint(svc))
+	ep.HistoryEndpoint = wrap("history", MakeHistoryEndpoint(svc))
+	ep.ExportEndpoint = wrap("export", MakeExportEndpoint(svc))
+	ep.BatchEndpoint = wrap("batch", MakeBatchEndpoint(ep.SumEndpoint, ep.ConcatEndpoint))
+	return ep, err
 }
 
 // MakeSumEndpoint returns an endpoint that invokes Sum on the service.
 // Primarily useful in a server.
+// A pooled *SumRequest is answered with a pooled *SumResponse, see AcquireSumRequest.
 func MakeSumEndpoint(svc service.AddService) (ep endpoint.Endpoint) {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
-		req := request.(SumRequest)
+		var req SumRequest
+		r, pooled := request.(*SumRequest)
+		if pooled {
+			req = *r
+			Release(r)
+		} else {
+			req = request.(SumRequest)
+		}
 		if err := req.validate(); err != nil {
 			return SumResponse{}, err
 		}
 		res, err := svc.Sum(ctx, req.A, req.B)
+		if pooled {
+			resp := sumResponsePool.Get().(*SumResponse)
+			resp.Res = res
+			return resp, err
+		}
 		return SumResponse{Res: res}, err
 	}
 }
@@ -64,13 +138,26 @@ func (e Endpoints) Sum(ctx context.Context, a int64, b int64) (res int64, err er
 
 // MakeConcatEndpoint returns an endpoint that invokes Concat on the service.
 // Primarily useful in a server.
+// A pooled *ConcatRequest is answered with a pooled *ConcatResponse, see AcquireConcatRequest.
 func MakeConcatEndpoint(svc service.AddService) (ep endpoint.Endpoint) {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
-		req := request.(ConcatRequest)
+		var req ConcatRequest
+		r, pooled := request.(*ConcatRequest)
+		if pooled {
+			req = *r
+			Release(r)
+		} else {
+			req = request.(ConcatRequest)
+		}
 		if err := req.validate(); err != nil {
 			return ConcatResponse{}, err
 		}
 		res, err := svc.Concat(ctx, req.A, req.B)
+		if pooled {
+			resp := concatResponsePool.Get().(*ConcatResponse)
+			resp.Res = res
+			return resp, err
+		}
 		return ConcatResponse{Res: res}, err
 	}
 }
@@ -85,3 +172,66 @@ func (e Endpoints) Concat(ctx context.Context, a string, b string) (res string,
 	response := resp.(ConcatResponse)
 	return response.Res, nil
 }
+
+// MakeHistoryEndpoint returns an endpoint that invokes History on the service.
+// Primarily useful in a server.
+func MakeHistoryEndpoint(svc service.AddService) (ep endpoint.Endpoint) {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(HistoryRequest)
+		if err := req.validate(); err != nil {
+			return HistoryResponse{}, err
+		}
+		filter := repository.Filter{Method: req.Method, Caller: req.Caller, Since: req.Since, Until: req.Until}
+		items, next, err := svc.History(ctx, filter, req.Cursor, req.Limit)
+		page := pagination.Cursor{Cursor: req.Cursor, Limit: req.Limit}
+		return HistoryResponse{Items: items, NextCursor: next, Page: page.Meta(len(items), next)}, err
+	}
+}
+
+// History implements the service interface, so Endpoints may be used as a service.
+// This is primarily useful in the context of a client library.
+func (e Endpoints) History(ctx context.Context, filter repository.Filter, cursor string, limit int) (items []repository.Calculation, next string, err error) {
+	resp, err := e.HistoryEndpoint(ctx, HistoryRequest{
+		Method: filter.Method,
+		Caller: filter.Caller,
+		Since:  filter.Since,
+		Until:  filter.Until,
+		Cursor: cursor,
+		Limit:  limit,
+	})
+	if err != nil {
+		return
+	}
+	response := resp.(HistoryResponse)
+	return response.Items, response.NextCursor, nil
+}
+
+// MakeExportEndpoint returns an endpoint that invokes Export on the service.
+// Primarily useful in a server.
+func MakeExportEndpoint(svc service.AddService) (ep endpoint.Endpoint) {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ExportRequest)
+		if err := req.validate(); err != nil {
+			return ExportResponse{}, err
+		}
+		filter := repository.Filter{Method: req.Method, Caller: req.Caller, Since: req.Since, Until: req.Until}
+		it, err := svc.Export(ctx, filter)
+		return ExportResponse{Items: it}, err
+	}
+}
+
+// Export implements the service interface, so Endpoints may be used as a service.
+// This is primarily useful in the context of a client library.
+func (e Endpoints) Export(ctx context.Context, filter repository.Filter) (it repository.Iterator, err error) {
+	resp, err := e.ExportEndpoint(ctx, ExportRequest{
+		Method: filter.Method,
+		Caller: filter.Caller,
+		Since:  filter.Since,
+		Until:  filter.Until,
+	})
+	if err != nil {
+		return
+	}
+	response := resp.(ExportResponse)
+	return response.Items, nil
+}
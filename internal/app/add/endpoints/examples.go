@@ -0,0 +1,76 @@
+package endpoints
+
+import (
+	"context"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/repository"
+	"github.com/cage1016/gokit-gae/internal/pkg/examples"
+)
+
+// The examples of the endpoints, the single source of the examples of the
+// API documentation, the stub endpoints and the golden checks.
+func init() {
+	examples.Register("sum",
+		examples.Example{
+			Name:     "small",
+			Summary:  "Adds two small integers.",
+			Request:  SumRequest{A: 1, B: 2},
+			Response: SumResponse{Res: 3},
+		},
+		examples.Example{
+			Name:     "negative",
+			Summary:  "Operands may be negative.",
+			Request:  SumRequest{A: -5, B: 3},
+			Response: SumResponse{Res: -2},
+		},
+	)
+	examples.Register("concat", examples.Example{
+		Name:     "words",
+		Summary:  "Joins two strings.",
+		Request:  ConcatRequest{A: "foo", B: "bar"},
+		Response: ConcatResponse{Res: "foobar"},
+	})
+	examples.Register("history", examples.Example{
+		Name:    "by method",
+		Summary: "Lists the latest sums.",
+		Request: HistoryRequest{Method: "sum", Limit: 1},
+		Response: HistoryResponse{
+			Items: []repository.Calculation{{
+				ID:        "5629499534213120",
+				Method:    "sum",
+				A:         "1",
+				B:         "2",
+				Result:    "3",
+				CreatedAt: time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC),
+			}},
+			NextCursor: "CjoSNGoKc35nb2tpdC1nYWVyHQsSEENhbGN1bGF0aW9uGICAgICAgIAKDBgAIAA=",
+		},
+	})
+	examples.Register("batch", examples.Example{
+		Name:    "mixed",
+		Summary: "Runs a sum and a concat in one call.",
+		Request: BatchRequest{Operations: []BatchOperation{
+			{Sum: &SumRequest{A: 1, B: 2}},
+			{Concat: &ConcatRequest{A: "foo", B: "bar"}},
+		}},
+		Response: BatchResponse{Results: []BatchResult{
+			{Index: 0, Sum: &SumResponse{Res: 3}},
+			{Index: 1, Concat: &ConcatResponse{Res: "foobar"}},
+		}},
+	})
+}
+
+// NewStub returns endpoints answering the registered examples instead of
+// calling a service, for stub servers.
+func NewStub() Endpoints {
+	return Endpoints{
+		SumEndpoint:     examples.Stub("sum"),
+		ConcatEndpoint:  examples.Stub("concat"),
+		HistoryEndpoint: examples.Stub("history"),
+		ExportEndpoint: func(context.Context, interface{}) (interface{}, error) {
+			return ExportResponse{Items: repository.Iterate(repository.NewMemoryRepository(), repository.Filter{}, 1)}, nil
+		},
+		BatchEndpoint: examples.Stub("batch"),
+	}
+}
@@ -7,6 +7,12 @@ import (
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/auth"
+	"github.com/cage1016/gokit-gae/internal/pkg/chain"
+	"github.com/cage1016/gokit-gae/internal/pkg/featureflags"
+	pkglogger "github.com/cage1016/gokit-gae/internal/pkg/logger"
+	"github.com/cage1016/gokit-gae/internal/pkg/tenant"
 )
 
 // LoggingMiddleware returns an endpoint middleware that logs the
@@ -14,6 +20,10 @@ import (
 func LoggingMiddleware(logger log.Logger) endpoint.Middleware {
 	return func(next endpoint.Endpoint) endpoint.Endpoint {
 		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			logger := pkglogger.WithContext(ctx, logger)
+			if id := tenant.FromContext(ctx); id != "" {
+				logger = log.With(logger, "tenant", id)
+			}
 			defer func(begin time.Time) {
 				if err == nil {
 					level.Info(logger).Log("transport_error", err, "took", time.Since(begin))
@@ -26,6 +36,26 @@ func LoggingMiddleware(logger log.Logger) endpoint.Middleware {
 	}
 }
 
+// ErrorLoggingMiddleware returns the LoggingMiddleware of the calls whose
+// info lines would be dropped: only the failed calls are logged, their
+// logger being built then only.
+func ErrorLoggingMiddleware(logger log.Logger) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			begin := time.Now()
+			response, err := next(ctx, request)
+			if err != nil {
+				logger := pkglogger.WithContext(ctx, logger)
+				if id := tenant.FromContext(ctx); id != "" {
+					logger = log.With(logger, "tenant", id)
+				}
+				level.Error(logger).Log("transport_error", err, "took", time.Since(begin))
+			}
+			return response, err
+		}
+	}
+}
+
 // AuthnMiddleware returns an endpoint middleware that apply authentication func
 func AuthnMiddleware(n endpoint.Middleware, endpoints Endpoints) Endpoints {
 	return endpoints
@@ -35,3 +65,104 @@ func AuthnMiddleware(n endpoint.Middleware, endpoints Endpoints) Endpoints {
 func AuthzMiddleware(z func(action string, resource string) endpoint.Middleware, endpoints Endpoints) Endpoints {
 	return endpoints
 }
+
+// AuthStage returns the factory of the chain.Auth stage, authenticating the
+// callers with authn, see auth.Middleware.
+func AuthStage(authn endpoint.Middleware) chain.Factory {
+	return func(string) endpoint.Middleware {
+		return auth.Middleware(authn)
+	}
+}
+
+// RateLimitStage returns the factory of the chain.RateLimit stage, charging
+// the requests to the daily quota of their caller, quota giving the
+// middleware of every method, then the ones carrying privileged headers to
+// the quota of privileged. The operations of a batch aren't charged again.
+func RateLimitStage(quota func(method string) endpoint.Middleware, privileged endpoint.Middleware) chain.Factory {
+	return OncePerBatch(func(method string) endpoint.Middleware {
+		return endpoint.Chain(quota(method), privileged)
+	})
+}
+
+// BulkheadMiddleware returns the endpoints wrapped with the middleware
+// capping their requests in flight, m giving the one of every method.
+// Batch items take no slot of their own.
+func BulkheadMiddleware(m func(method string) endpoint.Middleware, endpoints Endpoints) Endpoints {
+	endpoints.SumEndpoint = m("sum")(endpoints.SumEndpoint)
+	endpoints.ConcatEndpoint = m("concat")(endpoints.ConcatEndpoint)
+	endpoints.HistoryEndpoint = m("history")(endpoints.HistoryEndpoint)
+	endpoints.ExportEndpoint = m("export")(endpoints.ExportEndpoint)
+	endpoints.BatchEndpoint = m("batch")(endpoints.BatchEndpoint)
+	return endpoints
+}
+
+// LoadSheddingMiddleware returns the endpoints wrapped with the middleware
+// shedding their requests when the instance is overloaded, m giving the one
+// of every method. Batch items aren't admitted again.
+func LoadSheddingMiddleware(m func(method string) endpoint.Middleware, endpoints Endpoints) Endpoints {
+	endpoints.SumEndpoint = m("sum")(endpoints.SumEndpoint)
+	endpoints.ConcatEndpoint = m("concat")(endpoints.ConcatEndpoint)
+	endpoints.HistoryEndpoint = m("history")(endpoints.HistoryEndpoint)
+	endpoints.ExportEndpoint = m("export")(endpoints.ExportEndpoint)
+	endpoints.BatchEndpoint = m("batch")(endpoints.BatchEndpoint)
+	return endpoints
+}
+
+// ChaosMiddleware returns the endpoints wrapped with the middleware
+// injecting faults in their requests, m giving the one of every method.
+func ChaosMiddleware(m func(method string) endpoint.Middleware, endpoints Endpoints) Endpoints {
+	endpoints.SumEndpoint = m("sum")(endpoints.SumEndpoint)
+	endpoints.ConcatEndpoint = m("concat")(endpoints.ConcatEndpoint)
+	endpoints.HistoryEndpoint = m("history")(endpoints.HistoryEndpoint)
+	endpoints.ExportEndpoint = m("export")(endpoints.ExportEndpoint)
+	endpoints.BatchEndpoint = m("batch")(endpoints.BatchEndpoint)
+	return endpoints
+}
+
+// ExperimentMiddleware returns the endpoints wrapped with the middleware
+// assigning their requests the variant of an experiment, m giving the one
+// of every method. Batch items run in the variant of their batch.
+func ExperimentMiddleware(m func(method string) endpoint.Middleware, endpoints Endpoints) Endpoints {
+	endpoints.SumEndpoint = m("sum")(endpoints.SumEndpoint)
+	endpoints.ConcatEndpoint = m("concat")(endpoints.ConcatEndpoint)
+	endpoints.HistoryEndpoint = m("history")(endpoints.HistoryEndpoint)
+	endpoints.ExportEndpoint = m("export")(endpoints.ExportEndpoint)
+	endpoints.BatchEndpoint = m("batch")(endpoints.BatchEndpoint)
+	return endpoints
+}
+
+// CanaryMiddleware returns the endpoints routing a share of the Sum and
+// Concat calls to those of alt, m giving the middleware of every method.
+// History and Export, whose responses depend on the store, aren't routed.
+// The calls to alt resolve their tenant, for it to be propagated, as the
+// endpoints resolve theirs.
+func CanaryMiddleware(m func(method string, alt endpoint.Endpoint) endpoint.Middleware, alt Endpoints, endpoints Endpoints) Endpoints {
+	endpoints.SumEndpoint = unpooled(m("sum", tenant.Middleware()(alt.SumEndpoint))(endpoints.SumEndpoint))
+	endpoints.ConcatEndpoint = unpooled(m("concat", tenant.Middleware()(alt.ConcatEndpoint))(endpoints.ConcatEndpoint))
+	return endpoints
+}
+
+// OpBudgetMiddleware returns the endpoints wrapped with the middleware
+// counting the store operations of their requests against a budget, m
+// giving the one of every method. Export and Batch aren't budgeted, their
+// operations growing with the rows exported and the items batched.
+func OpBudgetMiddleware(m func(method string) endpoint.Middleware, endpoints Endpoints) Endpoints {
+	endpoints.SumEndpoint = m("sum")(endpoints.SumEndpoint)
+	endpoints.ConcatEndpoint = m("concat")(endpoints.ConcatEndpoint)
+	endpoints.HistoryEndpoint = m("history")(endpoints.HistoryEndpoint)
+	return endpoints
+}
+
+// FeatureFlagMiddleware returns the endpoints putting flags in the context
+// of their requests, for the service to branch on, and gating Export behind
+// the export flag, on unless set. The gate resolves the tenant it checks
+// the flag for, the endpoints resolving it after it.
+func FeatureFlagMiddleware(flags *featureflags.Flags, endpoints Endpoints) Endpoints {
+	m := featureflags.Middleware(flags)
+	endpoints.SumEndpoint = m(endpoints.SumEndpoint)
+	endpoints.ConcatEndpoint = m(endpoints.ConcatEndpoint)
+	endpoints.HistoryEndpoint = m(endpoints.HistoryEndpoint)
+	endpoints.ExportEndpoint = m(tenant.Middleware()(featureflags.Gate(flags, "export", true)(endpoints.ExportEndpoint)))
+	endpoints.BatchEndpoint = m(endpoints.BatchEndpoint)
+	return endpoints
+}
@@ -0,0 +1,79 @@
+package endpoints
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+// The request and response structs of the hot Sum and Concat methods can be
+// recycled to reduce GC pressure. A transport opts in per request by passing
+// a request from AcquireSumRequest or AcquireConcatRequest; the endpoint then
+// answers with a pooled *SumResponse or *ConcatResponse. The endpoint
+// releases the request as soon as it's read, the transport releases the
+// response with Release once encoded; neither must be used afterwards.
+
+var (
+	sumRequestPool     = sync.Pool{New: func() interface{} { return new(SumRequest) }}
+	sumResponsePool    = sync.Pool{New: func() interface{} { return new(SumResponse) }}
+	concatRequestPool  = sync.Pool{New: func() interface{} { return new(ConcatRequest) }}
+	concatResponsePool = sync.Pool{New: func() interface{} { return new(ConcatResponse) }}
+)
+
+// Reset zeroes r for reuse.
+func (r *SumRequest) Reset() { *r = SumRequest{} }
+
+// Reset zeroes r for reuse.
+func (r *SumResponse) Reset() { *r = SumResponse{} }
+
+// Reset zeroes r for reuse.
+func (r *ConcatRequest) Reset() { *r = ConcatRequest{} }
+
+// Reset zeroes r for reuse.
+func (r *ConcatResponse) Reset() { *r = ConcatResponse{} }
+
+// AcquireSumRequest returns a zeroed SumRequest from the pool.
+func AcquireSumRequest() *SumRequest {
+	return sumRequestPool.Get().(*SumRequest)
+}
+
+// AcquireConcatRequest returns a zeroed ConcatRequest from the pool.
+func AcquireConcatRequest() *ConcatRequest {
+	return concatRequestPool.Get().(*ConcatRequest)
+}
+
+// Release resets v, a pooled request or response, and returns it to its
+// pool. Other values are ignored.
+func Release(v interface{}) {
+	switch v := v.(type) {
+	case *SumRequest:
+		v.Reset()
+		sumRequestPool.Put(v)
+	case *SumResponse:
+		v.Reset()
+		sumResponsePool.Put(v)
+	case *ConcatRequest:
+		v.Reset()
+		concatRequestPool.Put(v)
+	case *ConcatResponse:
+		v.Reset()
+		concatResponsePool.Put(v)
+	}
+}
+
+// unpooled returns e called with copies of the pooled requests, released
+// first, for the middlewares passing a request to more than one endpoint.
+func unpooled(e endpoint.Endpoint) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		switch r := request.(type) {
+		case *SumRequest:
+			request = *r
+			Release(r)
+		case *ConcatRequest:
+			request = *r
+			Release(r)
+		}
+		return e(ctx, request)
+	}
+}
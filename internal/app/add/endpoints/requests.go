@@ -1,5 +1,36 @@
 package endpoints
 
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/jsonnum"
+)
+
+const (
+	// MaxHistoryLimit caps the page size of History.
+	MaxHistoryLimit = 100
+	// DefaultHistoryLimit is the page size of History when none is given.
+	DefaultHistoryLimit = 20
+	// MaxBatchSize caps the number of operations in a batch.
+	MaxBatchSize = 50
+)
+
+var (
+	// ErrInvalidQueryParams indicates invalid query parameters.
+	ErrInvalidQueryParams = errors.Register(errors.KindInvalidArgument, errors.NewCoded("ADD-001", "invalid query params"))
+
+	// ErrMalformedEntity indicates a malformed entity specification.
+	ErrMalformedEntity = errors.Register(errors.KindInvalidArgument, errors.NewCoded("ADD-002", "malformed entity specification"))
+
+	// ErrBatchFailed indicates a batch some operations of which failed, see
+	// errors.MultiError.
+	ErrBatchFailed = errors.Register(errors.KindInvalidArgument, errors.NewCoded("ADD-010", "batch operations failed"))
+)
+
 type Request interface {
 	validate() error
 }
@@ -14,6 +45,34 @@ func (r SumRequest) validate() error {
 	return nil // TBA
 }
 
+// UnmarshalJSON decodes the operands as json.Number, so integral values in
+// any notation, such as 1e3, are accepted, while fractional or out of range
+// values fail with jsonnum.ErrPrecisionLoss or jsonnum.ErrOverflow instead of
+// a generic type error.
+func (r *SumRequest) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		A json.Number `json:"a"`
+		B json.Number `json:"b"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return err
+	}
+	var err error
+	if raw.A != "" {
+		if r.A, err = jsonnum.Int64(raw.A); err != nil {
+			return errors.Wrap(errors.Cast(err), fmt.Errorf("a: %s", raw.A))
+		}
+	}
+	if raw.B != "" {
+		if r.B, err = jsonnum.Int64(raw.B); err != nil {
+			return errors.Wrap(errors.Cast(err), fmt.Errorf("b: %s", raw.B))
+		}
+	}
+	return nil
+}
+
 // ConcatRequest collects the request parameters for the Concat method.
 type ConcatRequest struct {
 	A string `json:"a"`
@@ -23,3 +82,66 @@ type ConcatRequest struct {
 func (r ConcatRequest) validate() error {
 	return nil // TBA
 }
+
+// HistoryRequest collects the request parameters for the History method.
+type HistoryRequest struct {
+	Method string    `json:"method"`
+	Caller string    `json:"caller"`
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until"`
+	Cursor string    `json:"cursor"`
+	Limit  int       `json:"limit"`
+}
+
+func (r HistoryRequest) validate() error {
+	if r.Limit < 1 || r.Limit > MaxHistoryLimit {
+		return ErrInvalidQueryParams
+	}
+	switch r.Method {
+	case "", "sum", "concat":
+	default:
+		return ErrInvalidQueryParams
+	}
+	if !r.Since.IsZero() && !r.Until.IsZero() && !r.Since.Before(r.Until) {
+		return ErrInvalidQueryParams
+	}
+	return nil
+}
+
+// ExportRequest collects the request parameters for the Export method.
+type ExportRequest struct {
+	Method string    `json:"method"`
+	Caller string    `json:"caller"`
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until"`
+}
+
+func (r ExportRequest) validate() error {
+	return HistoryRequest{Method: r.Method, Caller: r.Caller, Since: r.Since, Until: r.Until, Limit: 1}.validate()
+}
+
+// BatchOperation is a single operation of a batch. Exactly one of Sum and
+// Concat must be set.
+type BatchOperation struct {
+	Sum    *SumRequest    `json:"sum,omitempty"`
+	Concat *ConcatRequest `json:"concat,omitempty"`
+}
+
+// BatchRequest collects the operations of a batch. Concurrent operations run
+// on a bounded worker pool; results are always returned in request order.
+type BatchRequest struct {
+	Operations []BatchOperation `json:"operations"`
+	Concurrent bool             `json:"concurrent"`
+}
+
+func (r BatchRequest) validate() error {
+	if len(r.Operations) == 0 || len(r.Operations) > MaxBatchSize {
+		return ErrMalformedEntity
+	}
+	for _, op := range r.Operations {
+		if (op.Sum == nil) == (op.Concat == nil) {
+			return ErrMalformedEntity
+		}
+	}
+	return nil
+}
@@ -2,10 +2,14 @@ package endpoints
 
 import (
 	"net/http"
+	"net/url"
 
 	httptransport "github.com/go-kit/kit/transport/http"
 
+	"github.com/cage1016/gokit-gae/internal/app/add/repository"
 	"github.com/cage1016/gokit-gae/internal/app/add/service"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/pagination"
 	"github.com/cage1016/gokit-gae/internal/pkg/responses"
 )
 
@@ -17,6 +21,16 @@ var (
 	_ httptransport.Headerer = (*ConcatResponse)(nil)
 
 	_ httptransport.StatusCoder = (*ConcatResponse)(nil)
+
+	_ httptransport.Headerer = (*HistoryResponse)(nil)
+
+	_ httptransport.StatusCoder = (*HistoryResponse)(nil)
+
+	_ pagination.Paged = (*HistoryResponse)(nil)
+
+	_ httptransport.Headerer = (*BatchResponse)(nil)
+
+	_ httptransport.StatusCoder = (*BatchResponse)(nil)
 )
 
 // SumResponse collects the response values for the Sum method.
@@ -54,3 +68,66 @@ func (r ConcatResponse) Headers() http.Header {
 func (r ConcatResponse) Response() interface{} {
 	return responses.DataRes{APIVersion: service.Version, Data: r}
 }
+
+// HistoryResponse collects the response values for the History method.
+type HistoryResponse struct {
+	Items      []repository.Calculation `json:"items"`
+	NextCursor string                   `json:"nextCursor,omitempty"`
+	Page       pagination.CursorMeta    `json:"page"`
+	Err        error                    `json:"err,omitempty"`
+}
+
+func (r HistoryResponse) StatusCode() int {
+	return http.StatusOK // TBA
+}
+
+func (r HistoryResponse) Headers() http.Header {
+	return http.Header{}
+}
+
+// Link implements pagination.Paged.
+func (r HistoryResponse) Link(u *url.URL) string {
+	return r.Page.Link(u)
+}
+
+func (r HistoryResponse) Response() interface{} {
+	return responses.DataRes{APIVersion: service.Version, Data: r}
+}
+
+// ExportResponse collects the response values for the Export method. Items
+// is read as the response is written, see the streaming encoders of the
+// transports.
+type ExportResponse struct {
+	Items repository.Iterator `json:"-"`
+	Err   error               `json:"err,omitempty"`
+}
+
+// BatchResult is the outcome of a single batch operation. Index is the
+// position of the operation in the request.
+type BatchResult struct {
+	Index  int             `json:"index"`
+	Sum    *SumResponse    `json:"sum,omitempty"`
+	Concat *ConcatResponse `json:"concat,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// BatchResponse collects the response values for the Batch method.
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+	// Errors are the errors of the failed operations, each with its index,
+	// see errors.MultiError.
+	Errors []errors.Errors `json:"errors,omitempty"`
+	Err    error           `json:"err,omitempty"`
+}
+
+func (r BatchResponse) StatusCode() int {
+	return http.StatusOK // TBA
+}
+
+func (r BatchResponse) Headers() http.Header {
+	return http.Header{}
+}
+
+func (r BatchResponse) Response() interface{} {
+	return responses.DataRes{APIVersion: service.Version, Data: r}
+}
@@ -0,0 +1,83 @@
+package middlewares
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/tenant"
+)
+
+// Metrics collects the instruments recorded by InstrumentingMiddleware.
+type Metrics struct {
+	// Requests counts requests by method, status and tenant.
+	Requests metrics.Counter
+	// Errors counts failed requests by method and tenant.
+	Errors metrics.Counter
+	// Duration observes the request latency in seconds by method and status.
+	Duration metrics.Histogram
+	// Layers observes the request latency in seconds by method and layer,
+	// see LatencyMiddleware.
+	Layers metrics.Histogram
+}
+
+// NewPrometheusMetrics returns Metrics registered with the default Prometheus
+// registry, hence exported by the /metrics handler.
+func NewPrometheusMetrics(namespace, subsystem string) Metrics {
+	return Metrics{
+		Requests: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "requests_total",
+			Help:      "Number of requests received.",
+		}, []string{"method", "status", "tenant"}),
+		Errors: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "errors_total",
+			Help:      "Number of requests that failed.",
+		}, []string{"method", "tenant"}),
+		Duration: kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "request_duration_seconds",
+			Help:      "Request duration in seconds.",
+			Buckets:   stdprometheus.DefBuckets,
+		}, []string{"method", "status"}),
+		Layers: kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "layer_duration_seconds",
+			Help:      "Request duration in seconds spent in the serialization, middleware and service layers.",
+			Buckets:   stdprometheus.DefBuckets,
+		}, []string{"method", "layer"}),
+	}
+}
+
+// InstrumentingMiddleware returns an endpoint middleware that records the
+// count, errors and latency of the invocations of method.
+func InstrumentingMiddleware(m Metrics, method string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			defer func(begin time.Time) {
+				status := "ok"
+				if err != nil {
+					status = "error"
+				} else if f, ok := response.(endpoint.Failer); ok && f.Failed() != nil {
+					status = "error"
+				}
+				t := tenant.FromContext(ctx)
+				if status == "error" {
+					m.Errors.With("method", method, "tenant", t).Add(1)
+				}
+				m.Requests.With("method", method, "status", status, "tenant", t).Add(1)
+				m.Duration.With("method", method, "status", status).Observe(time.Since(begin).Seconds())
+			}(time.Now())
+			return next(ctx, request)
+		}
+	}
+}
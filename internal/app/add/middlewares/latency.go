@@ -0,0 +1,95 @@
+package middlewares
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+)
+
+// The layers the latency of a request is attributed to.
+const (
+	// LayerSerialization is the time spent decoding the request and
+	// encoding the response in the transport.
+	LayerSerialization = "serialization"
+	// LayerMiddleware is the overhead of the endpoint middlewares and the
+	// transport plumbing, what remains once the other layers are subtracted.
+	LayerMiddleware = "middleware"
+	// LayerService is the time spent in the service itself.
+	LayerService = "service"
+)
+
+type latencyContextKey struct{}
+
+// latency accumulates the time spent in every layer of one request. The
+// first endpoint of the request claims it, so nested endpoints such as the
+// operations of a batch don't count twice.
+type latency struct {
+	begin         time.Time
+	serialization time.Duration
+	service       time.Duration
+
+	layers metrics.Histogram
+	method string
+}
+
+// StartLatency returns ctx tracking the latency of a request from now. It's
+// meant to run first, in a transport ServerBefore.
+func StartLatency(ctx context.Context) context.Context {
+	return context.WithValue(ctx, latencyContextKey{}, &latency{begin: time.Now()})
+}
+
+// ObserveSerialization attributes the time since begin to serialization.
+func ObserveSerialization(ctx context.Context, begin time.Time) {
+	if l, ok := ctx.Value(latencyContextKey{}).(*latency); ok {
+		l.serialization += time.Since(begin)
+	}
+}
+
+// RecordLatency observes the latency of the request tracked in ctx by layer.
+// It's meant to run last, in a transport ServerFinalizer.
+func RecordLatency(ctx context.Context) {
+	l, ok := ctx.Value(latencyContextKey{}).(*latency)
+	if !ok || l.layers == nil {
+		return
+	}
+	middleware := time.Since(l.begin) - l.serialization - l.service
+	if middleware < 0 {
+		middleware = 0
+	}
+	l.layers.With("method", l.method, "layer", LayerSerialization).Observe(l.serialization.Seconds())
+	l.layers.With("method", l.method, "layer", LayerMiddleware).Observe(middleware.Seconds())
+	l.layers.With("method", l.method, "layer", LayerService).Observe(l.service.Seconds())
+}
+
+// LatencyMiddleware returns an endpoint middleware claiming the latency
+// tracked in ctx for method. It's meant to wrap the endpoint outermost.
+func LatencyMiddleware(m Metrics, method string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if l, ok := ctx.Value(latencyContextKey{}).(*latency); ok && l.method == "" {
+				l.layers, l.method = m.Layers, method
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// ServiceLatencyMiddleware returns an endpoint middleware attributing the
+// time spent in next to the service layer. It's meant to wrap the endpoint
+// innermost.
+func ServiceLatencyMiddleware(method string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			l, ok := ctx.Value(latencyContextKey{}).(*latency)
+			if !ok || l.method != method {
+				return next(ctx, request)
+			}
+			defer func(begin time.Time) {
+				l.service += time.Since(begin)
+			}(time.Now())
+			return next(ctx, request)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+// BatchSaver is implemented by repositories that can record several
+// calculations in one call.
+type BatchSaver interface {
+	// SaveAll records cs in one call. A failure may leave some of cs
+	// recorded.
+	SaveAll(ctx context.Context, cs []Calculation) error
+}
+
+// batchTimeout bounds the call saving a batch. The batch outlives the
+// requests of its writes, so it can't use their contexts.
+const batchTimeout = 10 * time.Second
+
+type write struct {
+	c    Calculation
+	done chan error
+}
+
+type batchingRepository struct {
+	Repository
+	saver  BatchSaver
+	window time.Duration
+	max    int
+	writes chan write
+	closed chan struct{}
+}
+
+// NewBatchingRepository returns next with the Saves occurring within window
+// of the first one coalesced into a single SaveAll of up to max writes,
+// reducing the API calls under load at the cost of up to window of latency.
+// When a batch fails, its writes are saved one by one so every Save gets
+// its own error back. Pending writes are flushed when ctx is done. next is
+// returned as is when it isn't a BatchSaver.
+func NewBatchingRepository(ctx context.Context, next Repository, window time.Duration, max int) Repository {
+	saver, ok := next.(BatchSaver)
+	if !ok || window <= 0 || max < 2 {
+		return next
+	}
+	r := &batchingRepository{Repository: next, saver: saver, window: window, max: max, writes: make(chan write), closed: make(chan struct{})}
+	go r.run(ctx)
+	return r
+}
+
+func (r *batchingRepository) Save(ctx context.Context, c Calculation) error {
+	w := write{c: c, done: make(chan error, 1)}
+	select {
+	case r.writes <- w:
+	case <-r.closed:
+		return r.Repository.Save(ctx, c)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-w.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run collects the writes into batches until ctx is done. Later writes are
+// saved one by one.
+func (r *batchingRepository) run(ctx context.Context) {
+	defer close(r.closed)
+	var (
+		batch []write
+		timer *time.Timer
+		fire  <-chan time.Time
+	)
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, fire = nil, nil
+		}
+		if len(batch) > 0 {
+			go r.flush(batch)
+			batch = nil
+		}
+	}
+
+	for {
+		select {
+		case w := <-r.writes:
+			batch = append(batch, w)
+			if len(batch) == 1 {
+				timer = time.NewTimer(r.window)
+				fire = timer.C
+			}
+			if len(batch) >= r.max {
+				flush()
+			}
+		case <-fire:
+			timer, fire = nil, nil
+			flush()
+		case <-ctx.Done():
+			flush()
+			return
+		}
+	}
+}
+
+// flush saves batch and fans the errors back to its writes.
+func (r *batchingRepository) flush(batch []write) {
+	ctx, cancel := context.WithTimeout(context.Background(), batchTimeout)
+	defer cancel()
+
+	cs := make([]Calculation, len(batch))
+	for i, w := range batch {
+		cs[i] = w.c
+	}
+	err := r.saver.SaveAll(ctx, cs)
+	if err == nil || len(batch) == 1 {
+		for _, w := range batch {
+			w.done <- err
+		}
+		return
+	}
+	for _, w := range batch {
+		w.done <- r.Repository.Save(ctx, w.c)
+	}
+}
@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/breaker"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// Dependency names the history store among the dependencies of the service,
+// see package degrade.
+const Dependency = "history"
+
+type breakingRepository struct {
+	next Repository
+	b    *breaker.Breaker
+}
+
+// NewBreakingRepository returns next guarded by b: calls fail right away
+// with breaker.ErrOpen while b is open. Invalid cursors and canceled
+// requests don't count as failures of the store.
+func NewBreakingRepository(next Repository, b *breaker.Breaker) Repository {
+	return &breakingRepository{next: next, b: b}
+}
+
+func (r *breakingRepository) Save(ctx context.Context, c Calculation) error {
+	if !r.b.Allow() {
+		return breaker.ErrOpen
+	}
+	err := r.next.Save(ctx, c)
+	r.report(ctx, err)
+	return err
+}
+
+func (r *breakingRepository) List(ctx context.Context, f Filter, cursor string, limit int) ([]Calculation, string, error) {
+	if !r.b.Allow() {
+		return nil, "", breaker.ErrOpen
+	}
+	items, next, err := r.next.List(ctx, f, cursor, limit)
+	r.report(ctx, err)
+	return items, next, err
+}
+
+func (r *breakingRepository) report(ctx context.Context, err error) {
+	switch {
+	case err == nil, errors.Contains(errors.Cast(err), ErrInvalidCursor):
+		r.b.Success()
+	case ctx.Err() != nil:
+		// the caller gave up, the store may be fine
+		r.b.Release()
+	default:
+		r.b.Failure()
+	}
+}
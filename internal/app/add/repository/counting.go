@@ -0,0 +1,28 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/opbudget"
+)
+
+type countingRepository struct {
+	next Repository
+}
+
+// NewCountingRepository returns next counting its operations, history.save
+// and history.list, in the operation budget of the request, see package
+// opbudget.
+func NewCountingRepository(next Repository) Repository {
+	return &countingRepository{next: next}
+}
+
+func (r *countingRepository) Save(ctx context.Context, c Calculation) error {
+	opbudget.Count(ctx, "history.save")
+	return r.next.Save(ctx, c)
+}
+
+func (r *countingRepository) List(ctx context.Context, f Filter, cursor string, limit int) ([]Calculation, string, error) {
+	opbudget.Count(ctx, "history.list")
+	return r.next.List(ctx, f, cursor, limit)
+}
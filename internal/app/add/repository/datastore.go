@@ -0,0 +1,285 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/dsindex"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/gcp"
+	"github.com/cage1016/gokit-gae/internal/pkg/manifest"
+)
+
+const datastoreScope = "https://www.googleapis.com/auth/datastore"
+
+// ErrDatastore indicates the Datastore API rejected a call.
+var ErrDatastore = errors.Register(errors.KindInternal, errors.NewCoded("ADD-006", "datastore request failed"))
+
+// DatastoreIndexes are the composite indexes the List queries need: the
+// equality filters on method and caller with the descending order on
+// createdAt, which the range filters on createdAt share.
+var DatastoreIndexes = dsindex.Required(DatastoreQueries())
+
+// DatastoreQueries returns the shapes of the queries of List, one for every
+// combination of the fields of Filter set.
+func DatastoreQueries() []dsindex.Query {
+	var qs []dsindex.Query
+	t := time.Unix(0, 0)
+	for set := 0; set < 1<<4; set++ {
+		var f Filter
+		if set&1 != 0 {
+			f.Method = "-"
+		}
+		if set&2 != 0 {
+			f.Caller = "-"
+		}
+		if set&4 != 0 {
+			f.Since = t
+		}
+		if set&8 != 0 {
+			f.Until = t
+		}
+		qs = append(qs, listQuery(f))
+	}
+	return qs
+}
+
+// listQuery returns the shape of the List query of f.
+func listQuery(f Filter) dsindex.Query {
+	q := dsindex.Query{
+		Kind:   Kind,
+		Orders: []manifest.Property{{Name: "createdAt", Direction: dsindex.Desc}},
+	}
+	if f.Method != "" {
+		q.Equality = append(q.Equality, "method")
+	}
+	if f.Caller != "" {
+		q.Equality = append(q.Equality, "caller")
+	}
+	if !f.Since.IsZero() || !f.Until.IsZero() {
+		q.Inequality = "createdAt"
+	}
+	return q
+}
+
+type datastoreRepository struct {
+	baseURL   string
+	projectID string
+	namespace string
+	client    *http.Client
+}
+
+// NewDatastoreRepository returns a Repository backed by Cloud Datastore (or
+// Firestore in Datastore mode) through its REST API. Calls authenticate as
+// the default service account, or go unauthenticated to the emulator when
+// DATASTORE_EMULATOR_HOST is set.
+func NewDatastoreRepository(projectID, namespace string) Repository {
+	r := &datastoreRepository{
+		baseURL:   "https://datastore.googleapis.com/v1/projects/" + projectID,
+		projectID: projectID,
+		namespace: namespace,
+		client:    gcp.NewClient(datastoreScope),
+	}
+	if host := os.Getenv("DATASTORE_EMULATOR_HOST"); host != "" {
+		r.baseURL = "http://" + host + "/v1/projects/" + projectID
+		r.client = http.DefaultClient
+	}
+	return r
+}
+
+type dsPartition struct {
+	ProjectID   string `json:"projectId"`
+	NamespaceID string `json:"namespaceId,omitempty"`
+}
+
+type dsPathElement struct {
+	Kind string `json:"kind"`
+	Name string `json:"name,omitempty"`
+}
+
+type dsKey struct {
+	PartitionID dsPartition     `json:"partitionId"`
+	Path        []dsPathElement `json:"path"`
+}
+
+type dsValue struct {
+	StringValue    *string `json:"stringValue,omitempty"`
+	TimestampValue *string `json:"timestampValue,omitempty"`
+}
+
+type dsEntity struct {
+	Key        dsKey              `json:"key"`
+	Properties map[string]dsValue `json:"properties"`
+}
+
+func str(s string) dsValue { return dsValue{StringValue: &s} }
+
+func ts(t time.Time) dsValue {
+	s := t.UTC().Format(time.RFC3339Nano)
+	return dsValue{TimestampValue: &s}
+}
+
+func (r *datastoreRepository) key(id string) dsKey {
+	return dsKey{
+		PartitionID: dsPartition{ProjectID: r.projectID, NamespaceID: r.namespace},
+		Path:        []dsPathElement{{Kind: Kind, Name: id}},
+	}
+}
+
+func toEntity(k dsKey, c Calculation) dsEntity {
+	return dsEntity{Key: k, Properties: map[string]dsValue{
+		"method":    str(c.Method),
+		"a":         str(c.A),
+		"b":         str(c.B),
+		"result":    str(c.Result),
+		"caller":    str(c.Caller),
+		"createdAt": ts(c.CreatedAt),
+	}}
+}
+
+func fromEntity(e dsEntity) Calculation {
+	get := func(name string) string {
+		if v, ok := e.Properties[name]; ok && v.StringValue != nil {
+			return *v.StringValue
+		}
+		return ""
+	}
+	c := Calculation{Method: get("method"), A: get("a"), B: get("b"), Result: get("result"), Caller: get("caller")}
+	if n := len(e.Key.Path); n > 0 {
+		c.ID = e.Key.Path[n-1].Name
+	}
+	if v, ok := e.Properties["createdAt"]; ok && v.TimestampValue != nil {
+		c.CreatedAt, _ = time.Parse(time.RFC3339Nano, *v.TimestampValue)
+	}
+	return c
+}
+
+func (r *datastoreRepository) Save(ctx context.Context, c Calculation) error {
+	body := map[string]interface{}{
+		"mode": "NON_TRANSACTIONAL",
+		"mutations": []interface{}{
+			map[string]interface{}{"upsert": toEntity(r.key(c.ID), c)},
+		},
+	}
+	return r.call(ctx, "commit", body, nil)
+}
+
+// SaveAll upserts cs in a single commit. Datastore rejects commits writing
+// an entity twice, so only the last of the calculations sharing an ID is
+// written.
+func (r *datastoreRepository) SaveAll(ctx context.Context, cs []Calculation) error {
+	last := make(map[string]int, len(cs))
+	for i, c := range cs {
+		last[c.ID] = i
+	}
+	mutations := make([]interface{}, 0, len(last))
+	for i, c := range cs {
+		if last[c.ID] == i {
+			mutations = append(mutations, map[string]interface{}{"upsert": toEntity(r.key(c.ID), c)})
+		}
+	}
+	body := map[string]interface{}{
+		"mode":      "NON_TRANSACTIONAL",
+		"mutations": mutations,
+	}
+	return r.call(ctx, "commit", body, nil)
+}
+
+func (r *datastoreRepository) List(ctx context.Context, f Filter, cursor string, limit int) ([]Calculation, string, error) {
+	var filters []interface{}
+	prop := func(name, op string, v dsValue) {
+		filters = append(filters, map[string]interface{}{
+			"propertyFilter": map[string]interface{}{
+				"property": map[string]string{"name": name},
+				"op":       op,
+				"value":    v,
+			},
+		})
+	}
+	if f.Method != "" {
+		prop("method", "EQUAL", str(f.Method))
+	}
+	if f.Caller != "" {
+		prop("caller", "EQUAL", str(f.Caller))
+	}
+	if !f.Since.IsZero() {
+		prop("createdAt", "GREATER_THAN_OR_EQUAL", ts(f.Since))
+	}
+	if !f.Until.IsZero() {
+		prop("createdAt", "LESS_THAN", ts(f.Until))
+	}
+
+	query := map[string]interface{}{
+		"kind":  []interface{}{map[string]string{"name": Kind}},
+		"order": []interface{}{map[string]interface{}{"property": map[string]string{"name": "createdAt"}, "direction": "DESCENDING"}},
+		"limit": limit,
+	}
+	if len(filters) > 0 {
+		query["filter"] = map[string]interface{}{
+			"compositeFilter": map[string]interface{}{"op": "AND", "filters": filters},
+		}
+	}
+	if cursor != "" {
+		query["startCursor"] = cursor
+	}
+
+	var resp struct {
+		Batch struct {
+			EntityResults []struct {
+				Entity dsEntity `json:"entity"`
+			} `json:"entityResults"`
+			EndCursor   string `json:"endCursor"`
+			MoreResults string `json:"moreResults"`
+		} `json:"batch"`
+	}
+	body := map[string]interface{}{
+		"partitionId": dsPartition{ProjectID: r.projectID, NamespaceID: r.namespace},
+		"query":       query,
+	}
+	if err := r.call(ctx, "runQuery", body, &resp); err != nil {
+		return nil, "", err
+	}
+
+	res := make([]Calculation, 0, len(resp.Batch.EntityResults))
+	for _, er := range resp.Batch.EntityResults {
+		res = append(res, fromEntity(er.Entity))
+	}
+	next := ""
+	if resp.Batch.MoreResults != "NO_MORE_RESULTS" && len(res) == limit {
+		next = resp.Batch.EndCursor
+	}
+	return res, next, nil
+}
+
+func (r *datastoreRepository) call(ctx context.Context, method string, in interface{}, out interface{}) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, r.baseURL+":"+method, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return errors.Wrap(ErrDatastore, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return errors.Wrap(ErrDatastore, fmt.Errorf("%s: %s %s", method, resp.Status, bytes.TrimSpace(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// Done is returned by Iterator.Next once the calculations are exhausted.
+var Done = errors.New("no more calculations")
+
+// Iterator returns calculations one at a time, so that a listing of any
+// size is read in constant memory.
+type Iterator interface {
+	// Next returns the next calculation, or Done once there are no more.
+	// An iterator failing keeps returning its error.
+	Next(ctx context.Context) (Calculation, error)
+}
+
+type pageIterator struct {
+	repo   Repository
+	filter Filter
+	size   int
+
+	page   []Calculation
+	cursor string
+	last   bool
+	err    error
+}
+
+// Iterate returns an Iterator over the calculations of r matching f, newest
+// first, read with List a page of size calculations at a time: the store is
+// queried as the iterator advances, and only the current page is held.
+func Iterate(r Repository, f Filter, size int) Iterator {
+	return &pageIterator{repo: r, filter: f, size: size}
+}
+
+func (it *pageIterator) Next(ctx context.Context) (Calculation, error) {
+	for len(it.page) == 0 {
+		if it.err != nil {
+			return Calculation{}, it.err
+		}
+		if it.last {
+			return Calculation{}, Done
+		}
+		it.page, it.cursor, it.err = it.repo.List(ctx, it.filter, it.cursor, it.size)
+		it.last = it.cursor == ""
+	}
+	c := it.page[0]
+	it.page = it.page[1:]
+	return c, nil
+}
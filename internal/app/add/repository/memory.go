@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+type memoryRepository struct {
+	mu    sync.RWMutex
+	items []Calculation
+}
+
+// NewMemoryRepository returns an instance-local Repository, useful for
+// development and tests.
+func NewMemoryRepository() Repository {
+	return &memoryRepository{}
+}
+
+func (r *memoryRepository) Save(_ context.Context, c Calculation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// keep newest first
+	i := sort.Search(len(r.items), func(i int) bool { return !r.items[i].CreatedAt.After(c.CreatedAt) })
+	r.items = append(r.items, Calculation{})
+	copy(r.items[i+1:], r.items[i:])
+	r.items[i] = c
+	return nil
+}
+
+func (r *memoryRepository) List(_ context.Context, f Filter, cursor string, limit int) ([]Calculation, string, error) {
+	offset := 0
+	if cursor != "" {
+		n, err := strconv.Atoi(cursor)
+		if err != nil || n < 0 {
+			return nil, "", ErrInvalidCursor
+		}
+		offset = n
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	res := []Calculation{}
+	i := offset
+	for ; i < len(r.items) && len(res) < limit; i++ {
+		if f.Match(r.items[i]) {
+			res = append(res, r.items[i])
+		}
+	}
+	for ; i < len(r.items); i++ {
+		if f.Match(r.items[i]) {
+			return res, strconv.Itoa(i), nil
+		}
+	}
+	return res, "", nil
+}
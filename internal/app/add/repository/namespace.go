@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// ErrInvalidNamespace indicates a namespace Datastore wouldn't accept.
+var ErrInvalidNamespace = errors.New("invalid datastore namespace")
+
+// validNamespace matches the namespaces Datastore accepts, the ones
+// starting with two underscores being reserved.
+var validNamespace = regexp.MustCompile(`^[0-9A-Za-z._-]{0,100}$`)
+
+// ProfileNamespace returns the namespace of the entities of a deployment
+// profile, e.g. dev or staging, so that the profiles can share a project
+// without their data colliding: namespace suffixed with the profile, or the
+// profile alone when namespace is the default one. The empty profile keeps
+// namespace as it is.
+func ProfileNamespace(namespace, profile string) (string, error) {
+	ns := namespace
+	switch {
+	case profile == "":
+	case ns == "":
+		ns = profile
+	default:
+		ns = namespace + "-" + profile
+	}
+	if !validNamespace.MatchString(ns) || len(ns) > 1 && ns[:2] == "__" {
+		return "", errors.Wrap(ErrInvalidNamespace, fmt.Errorf("%q", ns))
+	}
+	return ns, nil
+}
@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/timecodec"
+)
+
+// Kind is the Datastore kind calculations are stored under.
+const Kind = "Calculation"
+
+// ErrInvalidCursor indicates a cursor that wasn't returned by List.
+var ErrInvalidCursor = errors.Register(errors.KindInvalidArgument, errors.NewCoded("ADD-005", "invalid cursor"))
+
+// Calculation is a single recorded Sum or Concat invocation. Operands and
+// result are kept in their string form so both methods share one kind.
+type Calculation struct {
+	ID        string    `json:"id"`
+	Method    string    `json:"method"`
+	A         string    `json:"a"`
+	B         string    `json:"b"`
+	Result    string    `json:"result"`
+	Caller    string    `json:"caller,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// MarshalJSON implements json.Marshaler, writing CreatedAt in UTC as the
+// time policy of the APIs has it, see package timecodec.
+func (c Calculation) MarshalJSON() ([]byte, error) {
+	type calculation Calculation
+	return json.Marshal(struct {
+		calculation
+		CreatedAt timecodec.Time `json:"createdAt"`
+	}{calculation(c), timecodec.Time{Time: c.CreatedAt}})
+}
+
+// Filter narrows a history listing. Zero fields don't filter.
+type Filter struct {
+	Method string
+	Caller string
+	Since  time.Time
+	Until  time.Time
+}
+
+// Match reports whether c passes the filter.
+func (f Filter) Match(c Calculation) bool {
+	return (f.Method == "" || c.Method == f.Method) &&
+		(f.Caller == "" || c.Caller == f.Caller) &&
+		(f.Since.IsZero() || !c.CreatedAt.Before(f.Since)) &&
+		(f.Until.IsZero() || c.CreatedAt.Before(f.Until))
+}
+
+// Repository persists calculation history.
+type Repository interface {
+	// Save records a calculation.
+	Save(ctx context.Context, c Calculation) error
+
+	// List returns up to limit calculations matching f, newest first,
+	// starting at cursor. The returned cursor is empty on the last page.
+	List(ctx context.Context, f Filter, cursor string, limit int) ([]Calculation, string, error)
+}
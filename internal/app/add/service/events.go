@@ -0,0 +1,132 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/repository"
+	"github.com/cage1016/gokit-gae/internal/pkg/eventstore"
+	"github.com/cage1016/gokit-gae/internal/pkg/tenant"
+)
+
+// streamPrefix is the stream of the events of the calculations without
+// tenant, the streams of the tenants being it followed by their ID.
+const streamPrefix = "calculations"
+
+// Stream returns the event stream of the calculations of tenant id.
+func Stream(id string) string {
+	if id == "" {
+		return streamPrefix
+	}
+	return streamPrefix + ":" + id
+}
+
+type eventSourcingMiddleware struct {
+	store eventstore.Store `json:""`
+	next  AddService       `json:""`
+}
+
+// EventSourcingMiddleware appends the event of every successful Sum and
+// Concat to the stream of its tenant in store, see Stream, the events the
+// history is rebuilt from by ReplayHistory. A calculation whose event can't
+// be appended fails, so that the streams miss none the caller saw succeed.
+func EventSourcingMiddleware(store eventstore.Store) Middleware {
+	return func(next AddService) AddService {
+		return eventSourcingMiddleware{store, next}
+	}
+}
+
+func (em eventSourcingMiddleware) Sum(ctx context.Context, a int64, b int64) (res int64, err error) {
+	if res, err = em.next.Sum(ctx, a, b); err != nil {
+		return res, err
+	}
+	if err := em.append(ctx, EventSumComputed, a, b, res); err != nil {
+		return 0, err
+	}
+	return res, nil
+}
+
+func (em eventSourcingMiddleware) Concat(ctx context.Context, a string, b string) (res string, err error) {
+	if res, err = em.next.Concat(ctx, a, b); err != nil {
+		return res, err
+	}
+	if err := em.append(ctx, EventConcatComputed, a, b, res); err != nil {
+		return "", err
+	}
+	return res, nil
+}
+
+func (em eventSourcingMiddleware) History(ctx context.Context, filter repository.Filter, cursor string, limit int) (items []repository.Calculation, next string, err error) {
+	return em.next.History(ctx, filter, cursor, limit)
+}
+
+func (em eventSourcingMiddleware) Export(ctx context.Context, filter repository.Filter) (it repository.Iterator, err error) {
+	return em.next.Export(ctx, filter)
+}
+
+func (em eventSourcingMiddleware) append(ctx context.Context, typ string, a, b, res interface{}) error {
+	e, err := eventstore.NewEvent(typ, newCalculationEvent(ctx, a, b, res))
+	if err != nil {
+		return err
+	}
+	_, err = em.store.Append(ctx, Stream(tenant.FromContext(ctx)), eventstore.AnyVersion, e)
+	return err
+}
+
+// HistoryProjection returns the projection of the events of the streams to
+// the history of repo, for eventstore.Replay. The calculations are
+// identified by their stream and version, replaying a stream again saving
+// the same ones; events of other types are skipped.
+func HistoryProjection(repo repository.Repository) func(ctx context.Context, e eventstore.Event) error {
+	return func(ctx context.Context, e eventstore.Event) error {
+		var method string
+		switch e.Type {
+		case EventSumComputed:
+			method = "sum"
+		case EventConcatComputed:
+			method = "concat"
+		default:
+			return nil
+		}
+		var data struct {
+			Caller string          `json:"caller"`
+			A      json.RawMessage `json:"a"`
+			B      json.RawMessage `json:"b"`
+			Result json.RawMessage `json:"result"`
+		}
+		if err := json.Unmarshal(e.Data, &data); err != nil {
+			return fmt.Errorf("%s version %d: %v", e.Stream, e.Version, err)
+		}
+		id := sha256.Sum256([]byte(e.Stream + "/" + strconv.FormatInt(e.Version, 10)))
+		return repo.Save(ctx, repository.Calculation{
+			ID:        hex.EncodeToString(id[:16]),
+			Method:    method,
+			A:         operand(data.A),
+			B:         operand(data.B),
+			Result:    operand(data.Result),
+			Caller:    data.Caller,
+			CreatedAt: e.RecordedAt,
+		})
+	}
+}
+
+// ReplayHistory rebuilds the history of repo from the events of every stream
+// of store, and returns the number of events replayed.
+func ReplayHistory(ctx context.Context, store eventstore.Store, repo repository.Repository) (int64, error) {
+	return eventstore.ReplayAll(ctx, store, HistoryProjection(repo))
+}
+
+// operand returns the string form of a JSON operand or result, as the
+// history keeps them: strings unquoted, numbers as they are.
+func operand(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(bytes.TrimSpace(raw))
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/repository"
+	"github.com/cage1016/gokit-gae/internal/pkg/auth"
+	"github.com/cage1016/gokit-gae/internal/pkg/degrade"
+	"github.com/cage1016/gokit-gae/internal/pkg/id"
+	"github.com/cage1016/gokit-gae/internal/pkg/progress"
+)
+
+type historyMiddleware struct {
+	repo   repository.Repository `json:""`
+	ids    id.Generator          `json:""`
+	logger log.Logger            `json:""`
+	next   AddService            `json:""`
+}
+
+// HistoryMiddleware records every successful Sum and Concat invocation,
+// with its caller, in repo. A failure to record is logged but doesn't fail
+// the calculation. While the history store is degraded, records are
+// skipped or deferred as the degradation says, see package degrade. The
+// records are keyed with ids. The progress of both steps is reported, see
+// package progress, so streaming clients learn the result is on its way.
+func HistoryMiddleware(repo repository.Repository, ids id.Generator, logger log.Logger) Middleware {
+	return func(next AddService) AddService {
+		return historyMiddleware{repo, ids, logger, next}
+	}
+}
+
+func (hm historyMiddleware) Sum(ctx context.Context, a int64, b int64) (res int64, err error) {
+	if res, err = hm.next.Sum(ctx, a, b); err == nil {
+		progress.Report(ctx, 50, "calculated")
+		hm.record(ctx, "sum", strconv.FormatInt(a, 10), strconv.FormatInt(b, 10), strconv.FormatInt(res, 10))
+	}
+	return res, err
+}
+
+func (hm historyMiddleware) Concat(ctx context.Context, a string, b string) (res string, err error) {
+	if res, err = hm.next.Concat(ctx, a, b); err == nil {
+		progress.Report(ctx, 50, "calculated")
+		hm.record(ctx, "concat", a, b, res)
+	}
+	return res, err
+}
+
+func (hm historyMiddleware) History(ctx context.Context, filter repository.Filter, cursor string, limit int) (items []repository.Calculation, next string, err error) {
+	return hm.next.History(ctx, filter, cursor, limit)
+}
+
+func (hm historyMiddleware) Export(ctx context.Context, filter repository.Filter) (it repository.Iterator, err error) {
+	return hm.next.Export(ctx, filter)
+}
+
+func (hm historyMiddleware) record(ctx context.Context, method, a, b, res string) {
+	c := repository.Calculation{
+		ID:        hm.ids.New(),
+		Method:    method,
+		A:         a,
+		B:         b,
+		Result:    res,
+		Caller:    auth.Principal(ctx),
+		CreatedAt: time.Now().UTC(),
+	}
+	progress.Report(ctx, 100, hm.save(ctx, c))
+}
+
+// save saves c unless the history store is degraded, and returns what
+// became of it.
+func (hm historyMiddleware) save(ctx context.Context, c repository.Calculation) string {
+	switch mode, _ := degrade.FromContext(ctx, repository.Dependency); mode {
+	case degrade.ModeSkipEnrichment:
+		return "history skipped"
+	case degrade.ModeQueue:
+		save := func(ctx context.Context) error { return hm.repo.Save(ctx, c) }
+		if degrade.Defer(ctx, repository.Dependency, save) {
+			return "history deferred"
+		}
+	}
+	if err := hm.repo.Save(ctx, c); err != nil {
+		level.Error(hm.logger).Log("method", c.Method, "history", "save", "err", err)
+		return "history not recorded"
+	}
+	return "history recorded"
+}
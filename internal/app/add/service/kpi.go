@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"unicode/utf8"
+
+	"github.com/go-kit/kit/metrics"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/repository"
+	"github.com/cage1016/gokit-gae/internal/pkg/kpi"
+	"github.com/cage1016/gokit-gae/internal/pkg/tenant"
+)
+
+type kpiMiddleware struct {
+	sums       metrics.Counter `json:""`
+	characters metrics.Counter `json:""`
+	next       AddService      `json:""`
+}
+
+// KPIMiddleware counts the business metrics of the service in r, by tenant:
+// the sums computed and the characters concatenated.
+func KPIMiddleware(r *kpi.Registry) Middleware {
+	sums := r.Counter("sums_computed", "Number of sums computed.", "tenant")
+	characters := r.Counter("concat_characters", "Number of characters concatenated.", "tenant")
+	return func(next AddService) AddService {
+		return kpiMiddleware{sums, characters, next}
+	}
+}
+
+func (km kpiMiddleware) Sum(ctx context.Context, a int64, b int64) (res int64, err error) {
+	res, err = km.next.Sum(ctx, a, b)
+	if err == nil {
+		km.sums.With("tenant", tenant.FromContext(ctx)).Add(1)
+	}
+	return res, err
+}
+
+func (km kpiMiddleware) Concat(ctx context.Context, a string, b string) (res string, err error) {
+	res, err = km.next.Concat(ctx, a, b)
+	if err == nil {
+		km.characters.With("tenant", tenant.FromContext(ctx)).Add(float64(utf8.RuneCountInString(res)))
+	}
+	return res, err
+}
+
+func (km kpiMiddleware) History(ctx context.Context, filter repository.Filter, cursor string, limit int) (items []repository.Calculation, next string, err error) {
+	return km.next.History(ctx, filter, cursor, limit)
+}
+
+func (km kpiMiddleware) Export(ctx context.Context, filter repository.Filter) (it repository.Iterator, err error) {
+	return km.next.Export(ctx, filter)
+}
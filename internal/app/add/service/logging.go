@@ -2,9 +2,12 @@ package service
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/repository"
 )
 
 type loggingMiddleware struct {
@@ -35,3 +38,19 @@ func (lm loggingMiddleware) Concat(ctx context.Context, a string, b string) (res
 
 	return lm.next.Concat(ctx, a, b)
 }
+
+func (lm loggingMiddleware) History(ctx context.Context, filter repository.Filter, cursor string, limit int) (items []repository.Calculation, next string, err error) {
+	defer func() {
+		lm.logger.Log("method", "History", "filter", fmt.Sprintf("%+v", filter), "cursor", cursor, "limit", limit, "err", err)
+	}()
+
+	return lm.next.History(ctx, filter, cursor, limit)
+}
+
+func (lm loggingMiddleware) Export(ctx context.Context, filter repository.Filter) (it repository.Iterator, err error) {
+	defer func() {
+		lm.logger.Log("method", "Export", "filter", fmt.Sprintf("%+v", filter), "err", err)
+	}()
+
+	return lm.next.Export(ctx, filter)
+}
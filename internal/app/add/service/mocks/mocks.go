@@ -0,0 +1,147 @@
+// Package mocks provides test doubles of the add service, for the consumers
+// of its client to unit test against it without a live backend: a mock
+// AddService, the endpoints of any AddService, and a fake server serving
+// them over HTTP with the transports of the real one.
+package mocks
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/endpoints"
+	"github.com/cage1016/gokit-gae/internal/app/add/repository"
+	"github.com/cage1016/gokit-gae/internal/app/add/service"
+	"github.com/cage1016/gokit-gae/internal/app/add/transports"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/id"
+)
+
+// ErrUnexpectedCall is returned by the methods of a Service whose function
+// isn't set.
+var ErrUnexpectedCall = errors.Register(errors.KindUnimplemented, errors.New("mocks: unexpected call"))
+
+var _ service.AddService = (*Service)(nil)
+
+// Call is a call recorded by a Service, its arguments in order, the context
+// left out.
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+// Service is a mock AddService whose methods call the function of the same
+// name, failing with ErrUnexpectedCall when it's not set, and record their
+// calls. It's safe for concurrent use.
+type Service struct {
+	SumFunc     func(ctx context.Context, a int64, b int64) (int64, error)
+	ConcatFunc  func(ctx context.Context, a string, b string) (string, error)
+	HistoryFunc func(ctx context.Context, filter repository.Filter, cursor string, limit int) ([]repository.Calculation, string, error)
+	ExportFunc  func(ctx context.Context, filter repository.Filter) (repository.Iterator, error)
+
+	mu    sync.Mutex
+	calls []Call
+}
+
+func (s *Service) record(method string, args ...interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, Call{Method: method, Args: args})
+}
+
+// Calls returns the calls recorded so far, in order.
+func (s *Service) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+// CallsTo returns the calls of method recorded so far, in order.
+func (s *Service) CallsTo(method string) []Call {
+	var res []Call
+	for _, c := range s.Calls() {
+		if c.Method == method {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// Reset forgets the calls recorded so far.
+func (s *Service) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = nil
+}
+
+func (s *Service) Sum(ctx context.Context, a int64, b int64) (res int64, err error) {
+	s.record("Sum", a, b)
+	if s.SumFunc == nil {
+		return 0, ErrUnexpectedCall
+	}
+	return s.SumFunc(ctx, a, b)
+}
+
+func (s *Service) Concat(ctx context.Context, a string, b string) (res string, err error) {
+	s.record("Concat", a, b)
+	if s.ConcatFunc == nil {
+		return "", ErrUnexpectedCall
+	}
+	return s.ConcatFunc(ctx, a, b)
+}
+
+func (s *Service) History(ctx context.Context, filter repository.Filter, cursor string, limit int) (items []repository.Calculation, next string, err error) {
+	s.record("History", filter, cursor, limit)
+	if s.HistoryFunc == nil {
+		return nil, "", ErrUnexpectedCall
+	}
+	return s.HistoryFunc(ctx, filter, cursor, limit)
+}
+
+func (s *Service) Export(ctx context.Context, filter repository.Filter) (it repository.Iterator, err error) {
+	s.record("Export", filter)
+	if s.ExportFunc == nil {
+		return nil, ErrUnexpectedCall
+	}
+	return s.ExportFunc(ctx, filter)
+}
+
+// NewFake returns the real AddService on an in-memory repository, its
+// records keyed calc-1, calc-2 and so on, for tests that need its behavior
+// rather than canned answers.
+func NewFake() service.AddService {
+	return service.New(repository.NewMemoryRepository(), id.NewSequence("calc-"), log.NewNopLogger())
+}
+
+// Endpoints returns the endpoints of svc, without the middlewares of the
+// server. See endpoints.NewStub for endpoints answering the documented
+// examples instead.
+func Endpoints(svc service.AddService) endpoints.Endpoints {
+	eps := endpoints.Endpoints{
+		SumEndpoint:     endpoints.MakeSumEndpoint(svc),
+		ConcatEndpoint:  endpoints.MakeConcatEndpoint(svc),
+		HistoryEndpoint: endpoints.MakeHistoryEndpoint(svc),
+		ExportEndpoint:  endpoints.MakeExportEndpoint(svc),
+	}
+	eps.BatchEndpoint = endpoints.MakeBatchEndpoint(eps.SumEndpoint, eps.ConcatEndpoint)
+	return eps
+}
+
+// Server is a fake add server, serving an AddService over HTTP on a local
+// port with the transports of the real server.
+type Server struct {
+	*httptest.Server
+}
+
+// NewServer starts a Server serving svc, to be closed by the test.
+func NewServer(svc service.AddService) *Server {
+	return &Server{httptest.NewServer(transports.NewHTTPHandler(Endpoints(svc), log.NewNopLogger()))}
+}
+
+// NewClient returns an AddService calling s with the HTTP client of the
+// transports.
+func (s *Server) NewClient() (service.AddService, error) {
+	return transports.NewHTTPClient(s.URL, transports.HTTPClientOptions{}, nil, nil, log.NewNopLogger())
+}
@@ -0,0 +1,41 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/notify"
+	"github.com/cage1016/gokit-gae/internal/pkg/outbox"
+)
+
+type notifyingPublisher struct {
+	next     outbox.Publisher
+	notifier *notify.Notifier
+	logger   log.Logger
+}
+
+// NotifyingPublisher returns an outbox.Publisher delivering the domain
+// events to the channels their caller subscribed to with notifier, before
+// publishing them with next unless nil. A failed notification is logged,
+// the event being published anyway: retrying it would publish it again.
+func NotifyingPublisher(next outbox.Publisher, notifier *notify.Notifier, logger log.Logger) outbox.Publisher {
+	return &notifyingPublisher{next: next, notifier: notifier, logger: logger}
+}
+
+func (p *notifyingPublisher) Publish(ctx context.Context, data []byte, attrs map[string]string) (string, error) {
+	var e CalculationEvent
+	if err := json.Unmarshal(data, &e); err == nil && e.Caller != "" {
+		typ := attrs[outbox.AttrEventType]
+		if err := p.notifier.Notify(ctx, e.Caller, notify.Event{Type: typ, Time: time.Now().UTC(), Data: e}); err != nil {
+			level.Warn(p.logger).Log("notify", typ, "event", attrs[outbox.AttrEventID], "caller", e.Caller, "err", err)
+		}
+	}
+	if p.next == nil {
+		return attrs[outbox.AttrEventID], nil
+	}
+	return p.next.Publish(ctx, data, attrs)
+}
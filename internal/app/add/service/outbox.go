@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/repository"
+	"github.com/cage1016/gokit-gae/internal/pkg/auth"
+	"github.com/cage1016/gokit-gae/internal/pkg/id"
+	"github.com/cage1016/gokit-gae/internal/pkg/outbox"
+	"github.com/cage1016/gokit-gae/internal/pkg/tenant"
+)
+
+// The types of the domain events of the service.
+const (
+	EventSumComputed    = "add.sum.computed"
+	EventConcatComputed = "add.concat.computed"
+)
+
+// CalculationEvent is the data of the domain events of the service.
+type CalculationEvent struct {
+	Tenant string      `json:"tenant,omitempty"`
+	Caller string      `json:"caller,omitempty"`
+	A      interface{} `json:"a"`
+	B      interface{} `json:"b"`
+	Result interface{} `json:"result"`
+}
+
+// newCalculationEvent returns the event of the calculation of res from a
+// and b in ctx.
+func newCalculationEvent(ctx context.Context, a, b, res interface{}) CalculationEvent {
+	return CalculationEvent{Tenant: tenant.FromContext(ctx), Caller: auth.Principal(ctx), A: a, B: b, Result: res}
+}
+
+type outboxMiddleware struct {
+	store outbox.Store `json:""`
+	ids   id.Generator `json:""`
+	next  AddService   `json:""`
+}
+
+// OutboxMiddleware writes the domain event of every successful Sum and
+// Concat to the outbox store, for an outbox.Dispatcher to publish. A
+// calculation whose event can't be written fails, so that no event is lost
+// for a calculation the caller saw succeed. The events are identified with
+// ids.
+func OutboxMiddleware(store outbox.Store, ids id.Generator) Middleware {
+	return func(next AddService) AddService {
+		return outboxMiddleware{store, ids, next}
+	}
+}
+
+func (om outboxMiddleware) Sum(ctx context.Context, a int64, b int64) (res int64, err error) {
+	if res, err = om.next.Sum(ctx, a, b); err != nil {
+		return res, err
+	}
+	if err := om.raise(ctx, EventSumComputed, a, b, res); err != nil {
+		return 0, err
+	}
+	return res, nil
+}
+
+func (om outboxMiddleware) Concat(ctx context.Context, a string, b string) (res string, err error) {
+	if res, err = om.next.Concat(ctx, a, b); err != nil {
+		return res, err
+	}
+	if err := om.raise(ctx, EventConcatComputed, a, b, res); err != nil {
+		return "", err
+	}
+	return res, nil
+}
+
+func (om outboxMiddleware) History(ctx context.Context, filter repository.Filter, cursor string, limit int) (items []repository.Calculation, next string, err error) {
+	return om.next.History(ctx, filter, cursor, limit)
+}
+
+func (om outboxMiddleware) Export(ctx context.Context, filter repository.Filter) (it repository.Iterator, err error) {
+	return om.next.Export(ctx, filter)
+}
+
+func (om outboxMiddleware) raise(ctx context.Context, typ string, a, b, res interface{}) error {
+	e, err := outbox.NewEvent(om.ids.New(), typ, newCalculationEvent(ctx, a, b, res))
+	if err != nil {
+		return err
+	}
+	return om.store.Add(ctx, e)
+}
@@ -4,6 +4,9 @@ import (
 	"context"
 
 	"github.com/go-kit/kit/log"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/repository"
+	"github.com/cage1016/gokit-gae/internal/pkg/id"
 )
 
 // Middleware describes a service (as opposed to endpoint) middleware.
@@ -17,19 +20,29 @@ type AddService interface {
 	Sum(ctx context.Context, a int64, b int64) (res int64, err error)
 	// [method=post,expose=true,router=api/add/concat]
 	Concat(ctx context.Context, a string, b string) (res string, err error)
+	// [method=get,expose=true,router=api/add/history]
+	History(ctx context.Context, filter repository.Filter, cursor string, limit int) (items []repository.Calculation, next string, err error)
+	// [method=get,expose=true,router=api/add/history/export]
+	Export(ctx context.Context, filter repository.Filter) (it repository.Iterator, err error)
 }
 
+// exportPageSize is the number of calculations Export reads from the
+// repository at a time.
+const exportPageSize = 100
+
 // the concrete implementation of service interface
 type stubAddService struct {
-	logger log.Logger `json:"logger"`
+	logger log.Logger            `json:"logger"`
+	repo   repository.Repository `json:"repo"`
 }
 
-// New return a new instance of the service.
+// New return a new instance of the service, keying its records with ids.
 // If you want to add service middleware this is the place to put them.
-func New(logger log.Logger) (s AddService) {
+func New(repo repository.Repository, ids id.Generator, logger log.Logger) (s AddService) {
 	var svc AddService
 	{
-		svc = &stubAddService{logger: logger}
+		svc = &stubAddService{logger: logger, repo: repo}
+		svc = HistoryMiddleware(repo, ids, logger)(svc)
 		svc = LoggingMiddleware(logger)(svc)
 	}
 	return svc
@@ -44,3 +57,13 @@ func (ad *stubAddService) Sum(ctx context.Context, a int64, b int64) (res int64,
 func (ad *stubAddService) Concat(ctx context.Context, a string, b string) (res string, err error) {
 	return a + b, err
 }
+
+// Implement the business logic of History
+func (ad *stubAddService) History(ctx context.Context, filter repository.Filter, cursor string, limit int) (items []repository.Calculation, next string, err error) {
+	return ad.repo.List(ctx, filter, cursor, limit)
+}
+
+// Implement the business logic of Export
+func (ad *stubAddService) Export(ctx context.Context, filter repository.Filter) (it repository.Iterator, err error) {
+	return repository.Iterate(ad.repo, filter, exportPageSize), nil
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/repository"
+	"github.com/cage1016/gokit-gae/internal/pkg/tenant"
+)
+
+type tenantMiddleware struct {
+	tenants tenant.Directory `json:""`
+	next    AddService       `json:""`
+}
+
+// TenantMiddleware rejects the calls without tenant, see tenant.Middleware,
+// with tenant.ErrMissingTenant, and, unless tenants is nil, the ones of the
+// tenants it doesn't know or has disabled, see tenant.Verify.
+func TenantMiddleware(tenants tenant.Directory) Middleware {
+	return func(next AddService) AddService {
+		return tenantMiddleware{tenants, next}
+	}
+}
+
+func (tm tenantMiddleware) verify(ctx context.Context) error {
+	if tm.tenants == nil {
+		return tenant.Require(ctx)
+	}
+	return tenant.Verify(ctx, tm.tenants)
+}
+
+func (tm tenantMiddleware) Sum(ctx context.Context, a int64, b int64) (res int64, err error) {
+	if err := tm.verify(ctx); err != nil {
+		return 0, err
+	}
+	return tm.next.Sum(ctx, a, b)
+}
+
+func (tm tenantMiddleware) Concat(ctx context.Context, a string, b string) (res string, err error) {
+	if err := tm.verify(ctx); err != nil {
+		return "", err
+	}
+	return tm.next.Concat(ctx, a, b)
+}
+
+func (tm tenantMiddleware) History(ctx context.Context, filter repository.Filter, cursor string, limit int) (items []repository.Calculation, next string, err error) {
+	if err := tm.verify(ctx); err != nil {
+		return nil, "", err
+	}
+	return tm.next.History(ctx, filter, cursor, limit)
+}
+
+func (tm tenantMiddleware) Export(ctx context.Context, filter repository.Filter) (it repository.Iterator, err error) {
+	if err := tm.verify(ctx); err != nil {
+		return nil, err
+	}
+	return tm.next.Export(ctx, filter)
+}
@@ -0,0 +1,217 @@
+//go:build amqp
+// +build amqp
+
+package transports
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	amqptransport "github.com/go-kit/kit/transport/amqp"
+	"github.com/streadway/amqp"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/endpoints"
+	"github.com/cage1016/gokit-gae/internal/app/add/service"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/responses"
+	"github.com/cage1016/gokit-gae/internal/pkg/tenant"
+)
+
+// The queues the AMQP server consumes the requests of.
+const (
+	AMQPSumQueue    = "add.sum"
+	AMQPConcatQueue = "add.concat"
+)
+
+// amqpResponse is the body of the replies: the response as the HTTP
+// transport renders it, or its error.
+type amqpResponse struct {
+	Data  json.RawMessage         `json:"data,omitempty"`
+	Error *responses.ErrorResItem `json:"error,omitempty"`
+}
+
+// ServeAMQP consumes the Sum and Concat requests of their queues on ch until
+// ctx is done. Every request is answered on its reply-to queue with its
+// correlation ID, and acknowledged once answered, errors included. The
+// queues are declared durable if they don't exist.
+func ServeAMQP(ctx context.Context, ch *amqp.Channel, endpoints endpoints.Endpoints, logger log.Logger) error {
+	options := []amqptransport.SubscriberOption{
+		amqptransport.SubscriberBefore(amqptransport.SetContentType(contentType), tenantFromAMQP),
+		amqptransport.SubscriberResponsePublisher(amqpPublishAndAck),
+		amqptransport.SubscriberErrorEncoder(amqpEncodeError),
+		amqptransport.SubscriberErrorLogger(logger),
+	}
+	subscribers := map[string]*amqptransport.Subscriber{
+		AMQPSumQueue:    amqptransport.NewSubscriber(endpoints.SumEndpoint, decodeAMQPSumRequest, encodeAMQPResponse, options...),
+		AMQPConcatQueue: amqptransport.NewSubscriber(endpoints.ConcatEndpoint, decodeAMQPConcatRequest, encodeAMQPResponse, options...),
+	}
+
+	for queue, sub := range subscribers {
+		if _, err := ch.QueueDeclare(queue, true, false, false, false, nil); err != nil {
+			return err
+		}
+		deliveries, err := ch.Consume(queue, "", false, false, false, false, nil)
+		if err != nil {
+			return err
+		}
+		serve := sub.ServeDelivery(ch)
+		go func(queue string) {
+			for d := range deliveries {
+				d := d
+				serve(&d)
+			}
+			level.Info(logger).Log("protocol", "AMQP", "queue", queue, "consumer", "closed")
+		}(queue)
+	}
+
+	<-ctx.Done()
+	return ch.Close()
+}
+
+// tenantFromAMQP moves the tenant header of the delivery into ctx, to be
+// resolved by tenant.Middleware.
+func tenantFromAMQP(ctx context.Context, _ *amqp.Publishing, d *amqp.Delivery) context.Context {
+	if id, ok := d.Headers[tenant.MetadataKey].(string); ok && id != "" {
+		return tenant.HeaderToContext(ctx, id)
+	}
+	return ctx
+}
+
+// decodeAMQPSumRequest is a transport/amqp.DecodeRequestFunc that decodes a
+// JSON-encoded Sum request from the delivery body. Primarily useful in a
+// server.
+func decodeAMQPSumRequest(_ context.Context, d *amqp.Delivery) (interface{}, error) {
+	var req endpoints.SumRequest
+	if err := json.Unmarshal(d.Body, &req); err != nil {
+		return nil, errors.Wrap(ErrMalformedEntity, err)
+	}
+	return req, nil
+}
+
+// decodeAMQPConcatRequest is a transport/amqp.DecodeRequestFunc that decodes
+// a JSON-encoded Concat request from the delivery body. Primarily useful in a
+// server.
+func decodeAMQPConcatRequest(_ context.Context, d *amqp.Delivery) (interface{}, error) {
+	var req endpoints.ConcatRequest
+	if err := json.Unmarshal(d.Body, &req); err != nil {
+		return nil, errors.Wrap(ErrMalformedEntity, err)
+	}
+	return req, nil
+}
+
+// encodeAMQPResponse is a transport/amqp.EncodeResponseFunc that JSON-encodes
+// the response to the reply body. Primarily useful in a server.
+func encodeAMQPResponse(_ context.Context, pub *amqp.Publishing, response interface{}) error {
+	if ar, ok := response.(responses.Responser); ok {
+		response = ar.Response()
+	}
+	b, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	pub.Body = b
+	return nil
+}
+
+// amqpPublishAndAck publishes the reply, then acknowledges the delivery.
+func amqpPublishAndAck(ctx context.Context, d *amqp.Delivery, ch amqptransport.Channel, pub *amqp.Publishing) error {
+	if err := amqptransport.DefaultResponsePublisher(ctx, d, ch, pub); err != nil {
+		return err
+	}
+	return d.Ack(false)
+}
+
+// amqpEncodeError answers the delivery with the error body of the HTTP
+// transport, and acknowledges it: requests failing once fail again.
+func amqpEncodeError(ctx context.Context, err error, d *amqp.Delivery, ch amqptransport.Channel, pub *amqp.Publishing) {
+	item := httpErrorItem(ctx, err)
+	b, err := json.Marshal(amqpResponse{Error: &item})
+	if err == nil {
+		pub.Body = b
+		amqptransport.DefaultResponsePublisher(ctx, d, ch, pub)
+	}
+	d.Ack(false)
+}
+
+// NewAMQPClient returns an AddService backed by the AMQP server consuming
+// the requests queues on ch. Replies are received on an exclusive queue
+// declared for the client. History isn't served over AMQP.
+func NewAMQPClient(ch *amqp.Channel) (service.AddService, error) {
+	replies, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	publisher := func(queue string, dec amqptransport.DecodeResponseFunc) endpoint.Endpoint {
+		return amqptransport.NewPublisher(ch, &replies, encodeAMQPRequest, dec, amqptransport.PublisherBefore(
+			amqptransport.SetPublishKey(queue),
+			amqptransport.SetContentType(contentType),
+			tenantToAMQP,
+		)).Endpoint()
+	}
+
+	return endpoints.Endpoints{
+		SumEndpoint:    publisher(AMQPSumQueue, decodeAMQPSumResponse),
+		ConcatEndpoint: publisher(AMQPConcatQueue, decodeAMQPConcatResponse),
+		HistoryEndpoint: func(context.Context, interface{}) (interface{}, error) {
+			return nil, errors.New("History is only served over HTTP")
+		},
+		ExportEndpoint: func(context.Context, interface{}) (interface{}, error) {
+			return nil, errors.New("Export is only served over HTTP")
+		},
+	}, nil
+}
+
+// tenantToAMQP propagates the tenant of ctx in the headers of the request,
+// see tenant.ContextToHTTP.
+func tenantToAMQP(ctx context.Context, pub *amqp.Publishing, _ *amqp.Delivery) context.Context {
+	if id := tenant.FromContext(ctx); id != "" {
+		if pub.Headers == nil {
+			pub.Headers = amqp.Table{}
+		}
+		pub.Headers[tenant.MetadataKey] = id
+	}
+	return ctx
+}
+
+// encodeAMQPRequest is a transport/amqp.EncodeRequestFunc that JSON-encodes
+// any request to the publishing body. Primarily useful in a client.
+func encodeAMQPRequest(_ context.Context, pub *amqp.Publishing, request interface{}) error {
+	b, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	pub.Body = b
+	return nil
+}
+
+// decodeAMQPResponse decodes the reply d into response, or returns the error
+// it carries.
+func decodeAMQPResponse(d *amqp.Delivery, response interface{}) error {
+	var res amqpResponse
+	if err := json.Unmarshal(d.Body, &res); err != nil {
+		return err
+	}
+	if res.Error != nil {
+		return errors.New(res.Error.Message)
+	}
+	return json.Unmarshal(res.Data, response)
+}
+
+// decodeAMQPSumResponse is a transport/amqp.DecodeResponseFunc that decodes a
+// JSON-encoded Sum reply. Primarily useful in a client.
+func decodeAMQPSumResponse(_ context.Context, d *amqp.Delivery) (interface{}, error) {
+	var resp endpoints.SumResponse
+	err := decodeAMQPResponse(d, &resp)
+	return resp, err
+}
+
+// decodeAMQPConcatResponse is a transport/amqp.DecodeResponseFunc that
+// decodes a JSON-encoded Concat reply. Primarily useful in a client.
+func decodeAMQPConcatResponse(_ context.Context, d *amqp.Delivery) (interface{}, error) {
+	var resp endpoints.ConcatResponse
+	err := decodeAMQPResponse(d, &resp)
+	return resp, err
+}
@@ -0,0 +1,90 @@
+package transports
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/sd"
+	"github.com/go-kit/kit/sd/lb"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// Defaults of Discovery.
+const (
+	defaultAttempts = 3
+	defaultTimeout  = 10 * time.Second
+)
+
+// Balancer builds the load balancer over the endpoints of the discovered
+// instances.
+type Balancer func(sd.Endpointer) lb.Balancer
+
+// RoundRobin returns a Balancer cycling through the instances.
+func RoundRobin() Balancer {
+	return lb.NewRoundRobin
+}
+
+// Random returns a Balancer picking instances at random.
+func Random() Balancer {
+	return func(e sd.Endpointer) lb.Balancer {
+		return lb.NewRandom(e, time.Now().UnixNano())
+	}
+}
+
+// Discovery configures how the clients find and call the instances of the
+// service.
+type Discovery struct {
+	// Instancer yields the instances, e.g. from discovery.NewInstancer.
+	Instancer sd.Instancer
+	// Balancer picks the instance of every attempt, RoundRobin by default.
+	Balancer Balancer
+	// Attempts is the maximum number of attempts of a call, 3 by default.
+	Attempts int
+	// Timeout bounds all the attempts of a call, 10s by default.
+	Timeout time.Duration
+}
+
+// endpoint returns the load balanced endpoint over the instances, creating
+// the endpoint of every instance with factory.
+func (d Discovery) endpoint(factory sd.Factory, logger log.Logger) endpoint.Endpoint {
+	balancer, attempts, timeout := d.Balancer, d.Attempts, d.Timeout
+	if balancer == nil {
+		balancer = RoundRobin()
+	}
+	if attempts <= 0 {
+		attempts = defaultAttempts
+	}
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	endpointer := sd.NewEndpointer(d.Instancer, factory, logger)
+	return unwrapRetryError(lb.RetryWithCallback(timeout, balancer(endpointer), retryable(attempts)))
+}
+
+// retryable retries failures that another instance may not have, transport
+// errors and unavailable instances, up to attempts times. Errors of the
+// service, such as invalid arguments, are returned right away.
+func retryable(attempts int) lb.Callback {
+	return func(n int, err error) (bool, error) {
+		switch errors.KindOf(err) {
+		case errors.KindUnknown, errors.KindUnavailable:
+			return n < attempts, nil
+		}
+		return false, nil
+	}
+}
+
+// unwrapRetryError returns the last error of the attempts instead of the
+// lb.RetryError, so callers can match it with errors.Contains.
+func unwrapRetryError(next endpoint.Endpoint) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		response, err := next(ctx, request)
+		if re, ok := err.(lb.RetryError); ok && re.Final != nil {
+			return nil, re.Final
+		}
+		return response, err
+	}
+}
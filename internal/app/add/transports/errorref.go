@@ -0,0 +1,79 @@
+package transports
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// errorRef holds the reference of the server error of a request, shared by
+// the error handler logging it and the error encoder returning it.
+type errorRef struct {
+	once sync.Once
+	ref  string
+}
+
+func (r *errorRef) get() string {
+	r.once.Do(func() { r.ref = errors.NewReference() })
+	return r.ref
+}
+
+type errorRefContextKey struct{}
+
+// errorRefToContext is an http RequestFunc putting the holder of the error
+// reference of the request into ctx.
+func errorRefToContext(ctx context.Context, _ *http.Request) context.Context {
+	return context.WithValue(ctx, errorRefContextKey{}, &errorRef{})
+}
+
+// grpcErrorRefToContext is the grpc RequestFunc of errorRefToContext.
+func grpcErrorRefToContext(ctx context.Context, _ metadata.MD) context.Context {
+	return context.WithValue(ctx, errorRefContextKey{}, &errorRef{})
+}
+
+// errorReference returns the reference of err, a server error, in the
+// request of ctx, or "" for the other errors and outside of requests.
+func errorReference(ctx context.Context, err error) string {
+	r, ok := ctx.Value(errorRefContextKey{}).(*errorRef)
+	if !ok || errors.HTTPStatus(errors.KindOf(err)) < http.StatusInternalServerError {
+		return ""
+	}
+	return r.get()
+}
+
+// errorRefHandler is a transport.ErrorHandler logging the errors, with
+// their reference when they're server errors, for support to find the log
+// entry of the error a user reports.
+type errorRefHandler struct {
+	logger log.Logger
+}
+
+func (h errorRefHandler) Handle(ctx context.Context, err error) {
+	if ref := errorReference(ctx, err); ref != "" {
+		h.logger.Log("err", err, "error_ref", ref)
+		return
+	}
+	h.logger.Log("err", err)
+}
+
+// grpcWithErrorReference returns the gRPC status error st with the
+// reference of err in a RequestInfo detail, when it has one.
+func grpcWithErrorReference(ctx context.Context, err error, st error) error {
+	ref := errorReference(ctx, err)
+	s, ok := status.FromError(st)
+	if ref == "" || !ok {
+		return st
+	}
+	d, derr := s.WithDetails(&errdetails.RequestInfo{RequestId: ref})
+	if derr != nil {
+		return st
+	}
+	return d.Err()
+}
@@ -0,0 +1,35 @@
+package transports
+
+import (
+	"context"
+	"io"
+
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/endpoint"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// The kinds of the third-party errors the transports report.
+func init() {
+	errors.Register(errors.KindInvalidArgument, errors.Cast(io.EOF))
+	errors.Register(errors.KindInvalidArgument, errors.Cast(io.ErrUnexpectedEOF))
+	for _, err := range []error{
+		kitjwt.ErrTokenContextMissing,
+		kitjwt.ErrTokenInvalid,
+		kitjwt.ErrTokenExpired,
+		kitjwt.ErrTokenMalformed,
+		kitjwt.ErrTokenNotActive,
+	} {
+		errors.Register(errors.KindUnauthenticated, errors.Cast(err))
+	}
+}
+
+// grpcDecodeError turns the gRPC status errors of a client endpoint back into
+// the registered errors the server reported, see errors.FromGRPCStatus.
+func grpcDecodeError(next endpoint.Endpoint) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		response, err := next(ctx, request)
+		return response, errors.FromGRPCStatus(err)
+	}
+}
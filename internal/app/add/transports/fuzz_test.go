@@ -0,0 +1,139 @@
+package transports
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/endpoints"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// The fuzz targets of the HTTP decoders and of the error encoder check the
+// invariants of their codec; run one with e.g.
+//
+//	go test -run='^$' -fuzz=FuzzSumRequest ./internal/app/add/transports
+//
+// Without -fuzz, go test runs them on their seeds.
+
+// fuzzRequest returns the POST request of the JSON body data.
+func fuzzRequest(data []byte) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/add/sum", bytes.NewReader(data))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+// fuzzDecodeRequest decodes data with dec, leniently then strictly, and
+// checks that a request decoded strictly is decoded the same leniently, and
+// that it survives the client encoding, enc.
+func fuzzDecodeRequest(t *testing.T, data []byte, dec func(context.Context, *http.Request) (interface{}, error), enc func(context.Context, *http.Request, interface{}) error) {
+	ctx := context.Background()
+	lenient, lerr := dec(ctx, fuzzRequest(data))
+	req, err := (&httpOptions{strict: true}).decodeStrict(dec)(ctx, fuzzRequest(data))
+	if err != nil {
+		return
+	}
+	if lerr != nil || !reflect.DeepEqual(lenient, req) {
+		t.Fatalf("strict decoding of %q = %#v, lenient = %#v, %v", data, req, lenient, lerr)
+	}
+
+	r := fuzzRequest(nil)
+	if err := enc(ctx, r, req); err != nil {
+		t.Fatal(err)
+	}
+	again, err := dec(ctx, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, req) {
+		t.Fatalf("round trip of %#v = %#v", req, again)
+	}
+}
+
+func FuzzSumRequest(f *testing.F) {
+	for _, seed := range []string{
+		`{"a":1,"b":2}`,
+		`{"a":-9223372036854775808,"b":9223372036854775807}`,
+		`{"a":"1","b":2}`,
+		`{"a":1e3}`,
+		`{"a":1,"b":2,"c":3}`,
+		`{"a":00000000000000000000000001}`,
+		`{}`,
+		`[]`,
+		``,
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzDecodeRequest(t, data, decodeHTTPSumRequest, encodeHTTPSumRequest)
+	})
+}
+
+func FuzzConcatRequest(f *testing.F) {
+	for _, seed := range []string{
+		`{"a":"x","b":"y"}`,
+		`{"a":"\u0000","b":"\ud800"}`,
+		`{"a":1,"b":"y"}`,
+		`{"a":"x","A":"y"}`,
+		`{"a":null}`,
+		`null`,
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzDecodeRequest(t, data, decodeHTTPConcatRequest, encodeHTTPConcatRequest)
+	})
+}
+
+func FuzzJSONErrorDecoder(f *testing.F) {
+	for _, seed := range []string{
+		`{"errors":[{"code":"ADD-001","message":"malformed entity"}]}`,
+		`{"error":"boom"}`,
+		`{"errors":null}`,
+		`not json`,
+		``,
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		res := &http.Response{
+			StatusCode: http.StatusBadRequest,
+			Header:     http.Header{"Content-Type": []string{contentType}},
+			Body:       ioutil.NopCloser(bytes.NewReader(data)),
+		}
+		if err := JSONErrorDecoder(res); err == nil {
+			t.Fatalf("no error decoded from %q", data)
+		}
+	})
+}
+
+// FuzzErrorEncoder fuzzes httpEncodeError with the errors of messages, as
+// the errors of other services are rebuilt, and checks that the client
+// decodes the error back.
+func FuzzErrorEncoder(f *testing.F) {
+	for _, seed := range []string{"boom", "", "ADD-001: malformed entity", "a : b : c", "\x00\xff"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, msg string) {
+		errs := []error{
+			errors.New(msg),
+			errors.FromMessage(msg),
+			errors.Wrap(endpoints.ErrMalformedEntity, errors.New(msg)),
+		}
+		for _, err := range errs {
+			w := httptest.NewRecorder()
+			httpEncodeError(context.Background(), err, w)
+			res := w.Result()
+			if res.StatusCode < http.StatusBadRequest {
+				t.Fatalf("%v encoded with status %d", err, res.StatusCode)
+			}
+			if JSONErrorDecoder(res) == nil {
+				t.Fatalf("%v encoded as %q not decoded", err, w.Body)
+			}
+		}
+	})
+}
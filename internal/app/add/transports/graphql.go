@@ -0,0 +1,211 @@
+//go:build graphql
+// +build graphql
+
+package transports
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	httptransport "github.com/go-kit/kit/transport/http"
+	graphql "github.com/graph-gophers/graphql-go"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/endpoints"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/privileged"
+	"github.com/cage1016/gokit-gae/internal/pkg/responses"
+	"github.com/cage1016/gokit-gae/internal/pkg/router"
+	"github.com/cage1016/gokit-gae/internal/pkg/tenant"
+)
+
+// GraphQLSchema is the schema served at /graphql. Sum and Concat are both
+// queries and mutations: they have no side effect the caller cares about,
+// but the history records them.
+const GraphQLSchema = `
+schema {
+	query: Query
+	mutation: Mutation
+}
+
+# Int64 is a 64-bit integer. Values beyond 2^53 are exact when passed as
+# strings.
+scalar Int64
+
+type Query {
+	sum(a: Int64!, b: Int64!): Int64!
+	concat(a: String!, b: String!): String!
+}
+
+type Mutation {
+	sum(a: Int64!, b: Int64!): Int64!
+	concat(a: String!, b: String!): String!
+}
+`
+
+func init() {
+	features = append(features, "graphql")
+	optionalRoutes = append(optionalRoutes, func(m *router.Router, endpoints endpoints.Endpoints, logger log.Logger) {
+		h := NewGraphQLHandler(endpoints, logger)
+		m.Get("/graphql", h)
+		m.Post("/graphql", h)
+	})
+}
+
+type graphqlServer struct {
+	schema *graphql.Schema
+	before []httptransport.RequestFunc
+	logger log.Logger
+}
+
+// graphqlRequest is the body of a GraphQL POST request.
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// NewGraphQLHandler returns a handler executing the GraphQL operations of
+// GraphQLSchema against endpoints, POSTed as JSON or passed in the query
+// string of a GET. Errors are GraphQL errors whose extensions carry the
+// error code and the HTTP status of the error.
+func NewGraphQLHandler(endpoints endpoints.Endpoints, logger log.Logger) http.Handler {
+	return &graphqlServer{
+		schema: graphql.MustParseSchema(GraphQLSchema, &graphqlResolver{
+			sum:    endpoints.SumEndpoint,
+			concat: endpoints.ConcatEndpoint,
+		}),
+		before: []httptransport.RequestFunc{
+			httptransport.PopulateRequestContext,
+			privileged.HTTPToContext(),
+			tenant.HTTPToContext(),
+			kitjwt.HTTPToContext(),
+		},
+		logger: logger,
+	}
+}
+
+func (s *graphqlServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	for _, f := range s.before {
+		ctx = f(ctx, r)
+	}
+
+	var req graphqlRequest
+	if r.Method == http.MethodGet {
+		q := r.URL.Query()
+		req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				httpEncodeError(ctx, errors.Wrap(ErrMalformedEntity, err), w)
+				return
+			}
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpEncodeError(ctx, errors.Wrap(ErrMalformedEntity, err), w)
+		return
+	}
+
+	resp := s.schema.Exec(ctx, req.Query, req.OperationName, req.Variables)
+	for _, err := range resp.Errors {
+		level.Error(s.logger).Log("protocol", "GraphQL", "operation", req.OperationName, "err", err)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// graphqlResolver resolves the fields of Query and Mutation.
+type graphqlResolver struct {
+	sum    endpoint.Endpoint
+	concat endpoint.Endpoint
+}
+
+func (r *graphqlResolver) Sum(ctx context.Context, args struct{ A, B int64Scalar }) (int64Scalar, error) {
+	response, err := r.sum(ctx, endpoints.SumRequest{A: int64(args.A), B: int64(args.B)})
+	if err != nil {
+		return 0, newGraphQLError(ctx, err)
+	}
+	resp := response.(endpoints.SumResponse)
+	return int64Scalar(resp.Res), nil
+}
+
+func (r *graphqlResolver) Concat(ctx context.Context, args struct{ A, B string }) (string, error) {
+	response, err := r.concat(ctx, endpoints.ConcatRequest{A: args.A, B: args.B})
+	if err != nil {
+		return "", newGraphQLError(ctx, err)
+	}
+	resp := response.(endpoints.ConcatResponse)
+	return resp.Res, nil
+}
+
+// graphqlError reports an endpoint error as a GraphQL error, with the error
+// code and the HTTP status of the error body of the HTTP transport as
+// extensions.
+type graphqlError struct {
+	item responses.ErrorResItem
+}
+
+func newGraphQLError(ctx context.Context, err error) graphqlError {
+	return graphqlError{httpErrorItem(ctx, err)}
+}
+
+func (e graphqlError) Error() string {
+	return e.item.Message
+}
+
+func (e graphqlError) Extensions() map[string]interface{} {
+	item := e.item
+	ext := map[string]interface{}{"status": item.Code, "timestamp": item.Timestamp}
+	if item.ErrorCode != "" {
+		ext["code"] = item.ErrorCode
+	}
+	if item.TraceID != "" {
+		ext["trace_id"] = item.TraceID
+	}
+	if item.HelpURL != "" {
+		ext["help_url"] = item.HelpURL
+	}
+	return ext
+}
+
+// int64Scalar is the Int64 scalar of GraphQLSchema. It's written as a JSON
+// number and read from numbers or decimal strings.
+type int64Scalar int64
+
+func (int64Scalar) ImplementsGraphQLType(name string) bool {
+	return name == "Int64"
+}
+
+func (i *int64Scalar) UnmarshalGraphQL(input interface{}) error {
+	switch v := input.(type) {
+	case int32:
+		*i = int64Scalar(v)
+	case int64:
+		*i = int64Scalar(v)
+	case float64:
+		if v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+			return fmt.Errorf("Int64 cannot represent %v", v)
+		}
+		*i = int64Scalar(v)
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("Int64 cannot represent %q", v)
+		}
+		*i = int64Scalar(n)
+	default:
+		return fmt.Errorf("Int64 cannot represent %T", input)
+	}
+	return nil
+}
+
+func (i int64Scalar) MarshalJSON() ([]byte, error) {
+	return strconv.AppendInt(nil, int64(i), 10), nil
+}
@@ -6,8 +6,6 @@ import (
 	kitjwt "github.com/go-kit/kit/auth/jwt"
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
-	"github.com/go-kit/kit/tracing/opentracing"
-	"github.com/go-kit/kit/tracing/zipkin"
 	grpctransport "github.com/go-kit/kit/transport/grpc"
 	stdopentracing "github.com/opentracing/opentracing-go"
 	stdzipkin "github.com/openzipkin/zipkin-go"
@@ -17,7 +15,13 @@ import (
 
 	"github.com/cage1016/gokit-gae/internal/app/add/endpoints"
 	"github.com/cage1016/gokit-gae/internal/app/add/service"
+	"github.com/cage1016/gokit-gae/internal/pkg/chaos"
+	"github.com/cage1016/gokit-gae/internal/pkg/degrade"
 	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/experiment"
+	"github.com/cage1016/gokit-gae/internal/pkg/hooks"
+	"github.com/cage1016/gokit-gae/internal/pkg/tenant"
+	"github.com/cage1016/gokit-gae/internal/pkg/tracing"
 	pb "github.com/cage1016/gokit-gae/pb/add"
 )
 
@@ -27,18 +31,18 @@ type grpcServer struct {
 }
 
 func (s *grpcServer) Sum(ctx context.Context, req *pb.SumRequest) (rep *pb.SumResponse, err error) {
-	_, rp, err := s.sum.ServeGRPC(ctx, req)
+	ctx, rp, err := s.sum.ServeGRPC(ctx, req)
 	if err != nil {
-		return nil, grpcEncodeError(errors.Cast(err))
+		return nil, grpcWithErrorReference(ctx, err, grpcEncodeError(errors.Cast(err)))
 	}
 	rep = rp.(*pb.SumResponse)
 	return rep, nil
 }
 
 func (s *grpcServer) Concat(ctx context.Context, req *pb.ConcatRequest) (rep *pb.ConcatResponse, err error) {
-	_, rp, err := s.concat.ServeGRPC(ctx, req)
+	ctx, rp, err := s.concat.ServeGRPC(ctx, req)
 	if err != nil {
-		return nil, grpcEncodeError(errors.Cast(err))
+		return nil, grpcWithErrorReference(ctx, err, grpcEncodeError(errors.Cast(err)))
 	}
 	rep = rp.(*pb.ConcatResponse)
 	return rep, nil
@@ -47,22 +51,25 @@ func (s *grpcServer) Concat(ctx context.Context, req *pb.ConcatRequest) (rep *pb
 // MakeGRPCServer makes a set of endpoints available as a gRPC server.
 func MakeGRPCServer(endpoints endpoints.Endpoints, logger log.Logger) (req pb.AddServer) { // Zipkin GRPC Server Trace can either be instantiated per gRPC method with a
 	options := []grpctransport.ServerOption{
-		grpctransport.ServerErrorLogger(logger),
+		grpctransport.ServerErrorHandler(errorRefHandler{logger}),
+		grpctransport.ServerBefore(grpcErrorRefToContext, degrade.GRPCToContext, hooks.GRPCToContext, experiment.GRPCToContext, chaos.GRPCToContext),
+		grpctransport.ServerAfter(degrade.GRPCResponseHeaders, hooks.GRPCResponseHeaders, experiment.GRPCResponseHeaders),
 	}
+	options = append(options, grpcLatencyOptions...)
 
 	return &grpcServer{
 		sum: grpctransport.NewServer(
 			endpoints.SumEndpoint,
-			decodeGRPCSumRequest,
-			encodeGRPCSumResponse,
-			append(options, grpctransport.ServerBefore(kitjwt.GRPCToContext()))...,
+			timeGRPCDecode(decodeGRPCSumRequest),
+			timeGRPCEncode(encodeGRPCSumResponse),
+			append(options, grpctransport.ServerBefore(tracing.GRPCToContext(), tenant.GRPCToContext(), kitjwt.GRPCToContext()))...,
 		),
 
 		concat: grpctransport.NewServer(
 			endpoints.ConcatEndpoint,
-			decodeGRPCConcatRequest,
-			encodeGRPCConcatResponse,
-			append(options, grpctransport.ServerBefore(kitjwt.GRPCToContext()))...,
+			timeGRPCDecode(decodeGRPCConcatRequest),
+			timeGRPCEncode(encodeGRPCConcatResponse),
+			append(options, grpctransport.ServerBefore(tracing.GRPCToContext(), tenant.GRPCToContext(), kitjwt.GRPCToContext()))...,
 		),
 	}
 }
@@ -77,7 +84,10 @@ func decodeGRPCSumRequest(_ context.Context, grpcReq interface{}) (interface{},
 // encodeGRPCSumResponse is a transport/grpc.EncodeResponseFunc that converts a
 // user-domain response to a gRPC reply. Primarily useful in a server.
 func encodeGRPCSumResponse(_ context.Context, grpcReply interface{}) (res interface{}, err error) {
-	reply := grpcReply.(endpoints.SumResponse)
+	reply, ok := grpcReply.(endpoints.SumResponse)
+	if !ok {
+		reply = *grpcReply.(*endpoints.SumResponse)
+	}
 	return &pb.SumResponse{Res: reply.Res}, grpcEncodeError(errors.Cast(reply.Err))
 }
 
@@ -91,27 +101,22 @@ func decodeGRPCConcatRequest(_ context.Context, grpcReq interface{}) (interface{
 // encodeGRPCConcatResponse is a transport/grpc.EncodeResponseFunc that converts a
 // user-domain response to a gRPC reply. Primarily useful in a server.
 func encodeGRPCConcatResponse(_ context.Context, grpcReply interface{}) (res interface{}, err error) {
-	reply := grpcReply.(endpoints.ConcatResponse)
+	reply, ok := grpcReply.(endpoints.ConcatResponse)
+	if !ok {
+		reply = *grpcReply.(*endpoints.ConcatResponse)
+	}
 	return &pb.ConcatResponse{Res: reply.Res}, grpcEncodeError(errors.Cast(reply.Err))
 }
 
 // NewGRPCClient returns an AddService backed by a gRPC server at the other end
 // of the conn. The caller is responsible for constructing the conn, and
 // eventually closing the underlying transport. We bake-in certain middlewares,
-// implementing the client library pattern.
-func NewGRPCClient(conn *grpc.ClientConn, otTracer stdopentracing.Tracer, zipkinTracer *stdzipkin.Tracer, logger log.Logger) service.AddService { // Zipkin GRPC Client Trace can either be instantiated per gRPC method with a
-	// provided operation name or a global tracing client can be instantiated
-	// without an operation name and fed to each Go kit client as ClientOption.
-	// In the latter case, the operation name will be the endpoint's grpc method
-	// path.
-	//
-	// In this example, we demonstrace a global tracing client.
-	zipkinClient := zipkin.GRPCClientTrace(zipkinTracer)
-
-	// global client middlewares
-	options := []grpctransport.ClientOption{
-		zipkinClient,
-	}
+// implementing the client library pattern. otTracer and zipkinTracer may be
+// nil when not tracing with them. Dial conn with the
+// tracing.UnaryClientInterceptor for the calls to have spans of their own.
+func NewGRPCClient(conn *grpc.ClientConn, otTracer stdopentracing.Tracer, zipkinTracer *stdzipkin.Tracer, logger log.Logger) service.AddService {
+	// global client middlewares, the tracers are optional
+	options := grpcClientTracing(otTracer, zipkinTracer, logger, tracing.ContextToGRPC(), tenant.ContextToGRPC(), kitjwt.ContextToGRPC())
 
 	// The Sum endpoint is the same thing, with slightly different
 	// middlewares to demonstrate how to specialize per-endpoint.
@@ -124,9 +129,11 @@ func NewGRPCClient(conn *grpc.ClientConn, otTracer stdopentracing.Tracer, zipkin
 			encodeGRPCSumRequest,
 			decodeGRPCSumResponse,
 			pb.SumResponse{},
-			append(options, grpctransport.ClientBefore(opentracing.ContextToGRPC(otTracer, logger), kitjwt.ContextToGRPC()))...,
+			options...,
 		).Endpoint()
-		sumEndpoint = opentracing.TraceClient(otTracer, "Sum")(sumEndpoint)
+		sumEndpoint = openTracingClient(otTracer, "Sum")(sumEndpoint)
+		sumEndpoint = grpcDecodeError(sumEndpoint)
+		sumEndpoint = tracing.TraceClient("Sum")(sumEndpoint)
 	}
 
 	// The Concat endpoint is the same thing, with slightly different
@@ -140,14 +147,26 @@ func NewGRPCClient(conn *grpc.ClientConn, otTracer stdopentracing.Tracer, zipkin
 			encodeGRPCConcatRequest,
 			decodeGRPCConcatResponse,
 			pb.ConcatResponse{},
-			append(options, grpctransport.ClientBefore(opentracing.ContextToGRPC(otTracer, logger), kitjwt.ContextToGRPC()))...,
+			options...,
 		).Endpoint()
-		concatEndpoint = opentracing.TraceClient(otTracer, "Concat")(concatEndpoint)
+		concatEndpoint = openTracingClient(otTracer, "Concat")(concatEndpoint)
+		concatEndpoint = grpcDecodeError(concatEndpoint)
+		concatEndpoint = tracing.TraceClient("Concat")(concatEndpoint)
+	}
+
+	// History and Export have no gRPC method yet.
+	historyEndpoint := func(context.Context, interface{}) (interface{}, error) {
+		return nil, status.Error(codes.Unimplemented, "History is only served over HTTP")
+	}
+	exportEndpoint := func(context.Context, interface{}) (interface{}, error) {
+		return nil, status.Error(codes.Unimplemented, "Export is only served over HTTP")
 	}
 
 	return endpoints.Endpoints{
-		SumEndpoint:    sumEndpoint,
-		ConcatEndpoint: concatEndpoint,
+		SumEndpoint:     sumEndpoint,
+		ConcatEndpoint:  concatEndpoint,
+		HistoryEndpoint: historyEndpoint,
+		ExportEndpoint:  exportEndpoint,
 	}
 }
 
@@ -179,21 +198,11 @@ func decodeGRPCConcatResponse(_ context.Context, grpcReply interface{}) (interfa
 	return endpoints.ConcatResponse{Res: reply.Res}, nil
 }
 
+// grpcEncodeError reports err with the gRPC code of its kind, see
+// errors.Register.
 func grpcEncodeError(err errors.Error) error {
 	if err == nil {
 		return nil
 	}
-
-	st, ok := status.FromError(err)
-	if ok {
-		return status.Error(st.Code(), st.Message())
-	}
-
-	switch {
-	// TODO write your own custom error check here
-	case errors.Contains(err, kitjwt.ErrTokenContextMissing):
-		return status.Error(codes.Unauthenticated, err.Error())
-	default:
-		return status.Error(codes.Internal, "internal server error")
-	}
+	return errors.GRPCStatus(err)
 }
@@ -0,0 +1,137 @@
+package transports
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/cage1016/gokit-gae/pb/add"
+)
+
+const (
+	grpcWebContentType     = "application/grpc-web"
+	grpcWebTextContentType = "application/grpc-web-text"
+
+	// grpcWebTrailerFlag marks the frame carrying the trailers.
+	grpcWebTrailerFlag byte = 0x80
+)
+
+// NewGRPCWebHandler returns a handler serving the gRPC-Web protocol for the
+// given gRPC server, so browser clients can call it over HTTP/1.1. Both the
+// binary and the base64 "-text" encodings of unary calls are supported.
+func NewGRPCWebHandler(server pb.AddServer, logger log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ct := r.Header.Get("Content-Type")
+		if !strings.HasPrefix(ct, grpcWebContentType) {
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		text := strings.HasPrefix(ct, grpcWebTextContentType)
+
+		var body io.Reader = r.Body
+		if text {
+			body = base64.NewDecoder(base64.StdEncoding, r.Body)
+		}
+		payload, err := readGRPCWebFrame(body)
+		if err != nil {
+			writeGRPCWeb(w, ct, text, nil, status.New(codes.InvalidArgument, err.Error()), logger)
+			return
+		}
+
+		ctx := metadata.NewIncomingContext(r.Context(), grpcWebMetadata(r.Header))
+		var resp proto.Message
+		switch r.URL.Path {
+		case "/pb.Add/Sum":
+			req := &pb.SumRequest{}
+			if err = proto.Unmarshal(payload, req); err == nil {
+				resp, err = server.Sum(ctx, req)
+			}
+		case "/pb.Add/Concat":
+			req := &pb.ConcatRequest{}
+			if err = proto.Unmarshal(payload, req); err == nil {
+				resp, err = server.Concat(ctx, req)
+			}
+		default:
+			err = status.Errorf(codes.Unimplemented, "unknown method %s", r.URL.Path)
+		}
+		st, ok := status.FromError(err)
+		if !ok {
+			st = status.New(codes.InvalidArgument, err.Error())
+		}
+		writeGRPCWeb(w, ct, text, resp, st, logger)
+	})
+}
+
+// readGRPCWebFrame returns the payload of the single data frame of a unary call.
+func readGRPCWebFrame(r io.Reader) ([]byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("reading frame header: %v", err)
+	}
+	if hdr[0] != 0 {
+		return nil, fmt.Errorf("unsupported frame flags %#x", hdr[0])
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(hdr[1:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("reading frame: %v", err)
+	}
+	return payload, nil
+}
+
+// grpcWebMetadata turns the request headers into gRPC metadata, so the JWT in
+// the authorization header reaches kitjwt.GRPCToContext.
+func grpcWebMetadata(h http.Header) metadata.MD {
+	md := metadata.MD{}
+	for k, v := range h {
+		k = strings.ToLower(k)
+		if k == "content-type" || k == "content-length" || k == "connection" {
+			continue
+		}
+		md[k] = v
+	}
+	return md
+}
+
+func writeGRPCWeb(w http.ResponseWriter, ct string, text bool, resp proto.Message, st *status.Status, logger log.Logger) {
+	var buf bytes.Buffer
+	if resp != nil && st.Code() == codes.OK {
+		b, err := proto.Marshal(resp)
+		if err != nil {
+			st = status.New(codes.Internal, err.Error())
+		} else {
+			writeGRPCWebFrame(&buf, 0, b)
+		}
+	}
+	trailer := fmt.Sprintf("grpc-status: %d\r\ngrpc-message: %s\r\n", st.Code(), st.Message())
+	writeGRPCWebFrame(&buf, grpcWebTrailerFlag, []byte(trailer))
+
+	out := buf.Bytes()
+	if text {
+		out = []byte(base64.StdEncoding.EncodeToString(out))
+	}
+	w.Header().Set("Content-Type", ct)
+	w.Header().Set("Access-Control-Expose-Headers", "grpc-status, grpc-message")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(out); err != nil {
+		level.Warn(logger).Log("transport", "grpc-web", "err", err)
+	}
+}
+
+func writeGRPCWebFrame(buf *bytes.Buffer, flag byte, payload []byte) {
+	var hdr [5]byte
+	hdr[0] = flag
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(payload)))
+	buf.Write(hdr[:])
+	buf.Write(payload)
+}
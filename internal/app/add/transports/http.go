@@ -7,128 +7,331 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	kitjwt "github.com/go-kit/kit/auth/jwt"
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
-	"github.com/go-kit/kit/tracing/opentracing"
-	"github.com/go-kit/kit/tracing/zipkin"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/sd"
 	httptransport "github.com/go-kit/kit/transport/http"
-	"github.com/go-zoo/bone"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
 	stdopentracing "github.com/opentracing/opentracing-go"
 	stdzipkin "github.com/openzipkin/zipkin-go"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc/status"
 
 	"github.com/cage1016/gokit-gae/internal/app/add/endpoints"
 	"github.com/cage1016/gokit-gae/internal/app/add/service"
+	"github.com/cage1016/gokit-gae/internal/pkg/chaos"
+	"github.com/cage1016/gokit-gae/internal/pkg/degrade"
 	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/examples"
+	"github.com/cage1016/gokit-gae/internal/pkg/experiment"
+	"github.com/cage1016/gokit-gae/internal/pkg/hooks"
+	"github.com/cage1016/gokit-gae/internal/pkg/pagination"
+	"github.com/cage1016/gokit-gae/internal/pkg/privileged"
 	"github.com/cage1016/gokit-gae/internal/pkg/responses"
+	"github.com/cage1016/gokit-gae/internal/pkg/router"
+	"github.com/cage1016/gokit-gae/internal/pkg/tasks"
+	"github.com/cage1016/gokit-gae/internal/pkg/tenant"
+	"github.com/cage1016/gokit-gae/internal/pkg/timecodec"
+	"github.com/cage1016/gokit-gae/internal/pkg/tracing"
+	pb "github.com/cage1016/gokit-gae/pb/add"
 )
 
 const (
 	contentType string = "application/json"
 )
 
+// ContractVersion is the MAJOR.MINOR version of the API contract the
+// transports serve and the clients expect. Bump the minor version when
+// adding to the API, the major one when breaking it.
+const ContractVersion = "1.0"
+
 func JSONErrorDecoder(r *http.Response) error {
 	contentType := r.Header.Get("Content-Type")
 	if !strings.Contains(contentType, "application/json") {
 		return fmt.Errorf("expected JSON formatted error, got Content-Type %s", contentType)
 	}
-	var w responses.ErrorWrapper
-	if err := json.NewDecoder(r.Body).Decode(&w); err != nil {
+	var res responses.ErrorRes
+	if err := json.NewDecoder(r.Body).Decode(&res); err != nil {
 		return err
 	}
-	return errors.New(w.Error)
+	var msgs []string
+	for _, e := range res.Error.Errors {
+		if e.Index == nil {
+			msgs = append(msgs, e.Message)
+		}
+	}
+	if len(msgs) == 0 {
+		msgs = append(msgs, res.Error.Message)
+	}
+	err := errors.FromMessage(strings.Join(msgs, " → "))
+	if res.Error.RetryAfter > 0 {
+		err = errors.WithRetryAfter(err, time.Duration(res.Error.RetryAfter)*time.Second)
+	}
+	if res.Error.Reference != "" {
+		err = errors.WithReference(err, res.Error.Reference)
+	}
+	return err
+}
+
+// optionalRoutes mount the routes of the transports built in with tags, such
+// as graphql.
+var optionalRoutes []func(m *router.Router, endpoints endpoints.Endpoints, logger log.Logger)
+
+// HTTPVersion binds an endpoint set to an API version.
+type HTTPVersion struct {
+	router.Version
+	Endpoints endpoints.Endpoints
 }
 
 // NewHTTPHandler returns a handler that makes a set of endpoints available on
-// predefined paths.
-func NewHTTPHandler(endpoints endpoints.Endpoints, logger log.Logger) http.Handler { // Zipkin HTTP Server Trace can either be instantiated per endpoint with a
+// predefined paths, as version v1 of the API, with the optional features
+// enabled by options.
+func NewHTTPHandler(endpoints endpoints.Endpoints, logger log.Logger, options ...HTTPOption) http.Handler {
+	return NewVersionedHTTPHandler([]HTTPVersion{{Version: router.Version{Name: "v1"}, Endpoints: endpoints}}, logger, options...)
+}
+
+// NewVersionedHTTPHandler returns a handler that makes every version of the
+// API available under /api/<version>/add/..., each with its own endpoint
+// set. The unversioned /api/add/... paths serve the version negotiated
+// through the Accept header, the first one by default, which also backs the
+// gRPC-Web and grpc-gateway routes.
+func NewVersionedHTTPHandler(versions []HTTPVersion, logger log.Logger, httpOptions ...HTTPOption) http.Handler { // Zipkin HTTP Server Trace can either be instantiated per endpoint with a
+	o := newHTTPOptions(httpOptions)
+	o.errorHandler = errorRefHandler{logger}
 	options := []httptransport.ServerOption{
 		httptransport.ServerErrorEncoder(httpEncodeError),
-		httptransport.ServerErrorLogger(logger),
-	}
-
-	m := bone.New()
-	m.Post("/api/add/sum", httptransport.NewServer(
-		endpoints.SumEndpoint,
-		decodeHTTPSumRequest,
-		encodeJSONResponse,
-		append(options, httptransport.ServerBefore(kitjwt.HTTPToContext()))...,
-	))
-	m.Post("/api/add/concat", httptransport.NewServer(
-		endpoints.ConcatEndpoint,
-		decodeHTTPConcatRequest,
-		encodeJSONResponse,
-		append(options, httptransport.ServerBefore(kitjwt.HTTPToContext()))...,
-	))
+		httptransport.ServerErrorHandler(errorRefHandler{logger}),
+		httptransport.ServerBefore(httptransport.PopulateRequestContext, errorRefToContext, privileged.HTTPToContext(), tenant.HTTPToContext(), tasks.HTTPToContext(), degrade.HTTPToContext, hooks.HTTPToContext, fieldMaskToContext, conditionalToContext, localeToContext, experiment.HTTPToContext, chaos.HTTPToContext),
+		httptransport.ServerAfter(degrade.HTTPResponseHeaders, hooks.HTTPResponseHeaders, experiment.HTTPResponseHeaders),
+	}
+	if o.envelope {
+		options = append(options, httptransport.ServerBefore(envelopeToContext))
+	}
+	options = append(options, httpLatencyOptions...)
+
+	m := router.New()
+	negotiated := map[string]map[string]http.Handler{}
+	for _, v := range versions {
+		g := m.Group("/api/"+v.Name+"/add", v.Headers)
+		for _, rt := range o.makeAPIRoutes(v.Endpoints, options, logger) {
+			g.Handle(rt.method, rt.path, rt.handler)
+			key := rt.method + " " + rt.path
+			if negotiated[key] == nil {
+				negotiated[key] = map[string]http.Handler{}
+			}
+			negotiated[key][v.Name] = v.Headers(rt.handler)
+		}
+	}
+	for key, handlers := range negotiated {
+		i := strings.IndexByte(key, ' ')
+		m.Handle(key[:i], "/api/add"+key[i+1:], router.Negotiated(handlers, versions[0].Name))
+	}
+	endpoints := versions[0].Endpoints
 	m.Get("/metrics", promhttp.Handler())
-	return m
+	m.Get("/api/add/examples", examples.Handler())
+	m.Get("/api/add/capabilities", capabilitiesHandler(o.capabilities(versions)))
+	m.Post("/rpc", NewJSONRPCHandler(endpoints, logger))
+	for _, mount := range optionalRoutes {
+		mount(m, endpoints, logger)
+	}
+
+	// browsers and REST consumers reach the gRPC server through gRPC-Web and
+	// the grpc-gateway mappings of pb/add/add_gateway.yaml
+	grpcServer := MakeGRPCServer(endpoints, logger)
+	grpcWeb := NewGRPCWebHandler(grpcServer, logger)
+	m.Post("/pb.Add/Sum", grpcWeb)
+	m.Post("/pb.Add/Concat", grpcWeb)
+	gw := runtime.NewServeMux(runtime.WithIncomingHeaderMatcher(func(key string) (string, bool) {
+		if http.CanonicalHeaderKey(key) == tenant.Header {
+			return tenant.MetadataKey, true
+		}
+		return runtime.DefaultHeaderMatcher(key)
+	}))
+	if err := pb.RegisterAddHandlerServer(context.Background(), gw, grpcServer); err != nil {
+		level.Error(logger).Log("transport", "grpc-gateway", "err", err)
+	}
+	m.Post("/v1/add/sum", gw)
+	m.Post("/v1/add/concat", gw)
+	return bodyHandler(m, o.maxBodyBytes, o.compression)
+}
+
+type apiRoute struct {
+	method  string
+	path    string
+	handler http.Handler
+}
+
+// makeAPIRoutes returns the routes of the API serving endpoints, relative to
+// the /api/<version>/add prefix.
+func (o *httpOptions) makeAPIRoutes(endpoints endpoints.Endpoints, options []httptransport.ServerOption, logger log.Logger) []apiRoute {
+	decodeSum, decodeConcat := decodeHTTPSumRequest, decodeHTTPConcatRequest
+	if o.pooling {
+		decodeSum, decodeConcat = decodePooledHTTPSumRequest, decodePooledHTTPConcatRequest
+	}
+	decodeSum, decodeConcat = o.decodeStrict(decodeSum), o.decodeStrict(decodeConcat)
+
+	return []apiRoute{
+		{http.MethodPost, "/sum", withSSEMode(
+			NewSSEServer(endpoints.SumEndpoint, o.decodeStrict(decodeHTTPSumRequest), logger, httptransport.PopulateRequestContext, privileged.HTTPToContext(), tenant.HTTPToContext(), kitjwt.HTTPToContext()),
+			httptransport.NewServer(
+				endpoints.SumEndpoint,
+				timeHTTPDecode(decodeXMLOr(sumRequestType, decodeSum)),
+				timeHTTPEncode(releaseAfter(encodeNegotiatedResponse(encodeGRPCSumResponse, encodeLocalized(o.jsonEncoder("/sum"))))),
+				append(options, httptransport.ServerBefore(kitjwt.HTTPToContext(), o.xmlToContext("/sum")))...,
+			),
+		)},
+		{http.MethodPost, "/concat", withSSEMode(
+			NewSSEServer(endpoints.ConcatEndpoint, o.decodeStrict(decodeHTTPConcatRequest), logger, httptransport.PopulateRequestContext, privileged.HTTPToContext(), tenant.HTTPToContext(), kitjwt.HTTPToContext()),
+			httptransport.NewServer(
+				endpoints.ConcatEndpoint,
+				timeHTTPDecode(decodeXMLOr(concatRequestType, decodeConcat)),
+				timeHTTPEncode(releaseAfter(encodeNegotiatedResponse(encodeGRPCConcatResponse, o.jsonEncoder("/concat")))),
+				append(options, httptransport.ServerBefore(kitjwt.HTTPToContext(), o.xmlToContext("/concat")))...,
+			),
+		)},
+		{http.MethodGet, "/history", httptransport.NewServer(
+			endpoints.HistoryEndpoint,
+			timeHTTPDecode(decodeHTTPHistoryRequest),
+			timeHTTPEncode(encodePageLinks(encodeCSVOr(encodeLocalized(encodeETag(o.jsonEncoder("/history")))))),
+			append(options, httptransport.ServerBefore(kitjwt.HTTPToContext(), csvToContext))...,
+		)},
+		{http.MethodGet, "/history/export", httptransport.NewServer(
+			endpoints.ExportEndpoint,
+			timeHTTPDecode(decodeHTTPExportRequest),
+			encodeHTTPExportResponse,
+			append(options, httptransport.ServerBefore(kitjwt.HTTPToContext(), csvToContext, o.resumableToContext))...,
+		)},
+		{http.MethodGet, downloadPath, o.downloadHandler()},
+		{http.MethodPost, "/batch", httptransport.NewServer(
+			endpoints.BatchEndpoint,
+			timeHTTPDecode(decodeXMLOr(batchRequestType, o.decodeStrict(decodeHTTPBatchRequest))),
+			timeHTTPEncode(o.jsonEncoder("/batch")),
+			append(options, httptransport.ServerBefore(kitjwt.HTTPToContext(), o.xmlToContext("/batch")))...,
+		)},
+		{http.MethodGet, "/stream", NewWSHandler(endpoints, logger)},
+	}
 }
 
 // decodeHTTPSumRequest is a transport/http.DecodeRequestFunc that decodes a
-// JSON-encoded request from the HTTP request body. Primarily useful in a server.
-func decodeHTTPSumRequest(_ context.Context, r *http.Request) (interface{}, error) {
+// JSON-encoded, or protobuf-encoded when the Content-Type says so, request
+// from the HTTP request body. Primarily useful in a server.
+func decodeHTTPSumRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	if isProtobufRequest(r) {
+		return decodeProtobufRequest(ctx, r, &pb.SumRequest{}, decodeGRPCSumRequest)
+	}
 	var req endpoints.SumRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err := decodeJSONBody(ctx, r.Body, &req)
 	return req, err
 }
 
 // decodeHTTPConcatRequest is a transport/http.DecodeRequestFunc that decodes a
-// JSON-encoded request from the HTTP request body. Primarily useful in a server.
-func decodeHTTPConcatRequest(_ context.Context, r *http.Request) (interface{}, error) {
+// JSON-encoded, or protobuf-encoded when the Content-Type says so, request
+// from the HTTP request body. Primarily useful in a server.
+func decodeHTTPConcatRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	if isProtobufRequest(r) {
+		return decodeProtobufRequest(ctx, r, &pb.ConcatRequest{}, decodeGRPCConcatRequest)
+	}
 	var req endpoints.ConcatRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err := decodeJSONBody(ctx, r.Body, &req)
+	return req, err
+}
+
+// decodeHTTPHistoryRequest is a transport/http.DecodeRequestFunc that decodes
+// the filter and paging query parameters of a history request. Primarily
+// useful in a server.
+func decodeHTTPHistoryRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	page, err := pagination.DecodeCursor(r.URL.Query(), pagination.Limits{Default: endpoints.DefaultHistoryLimit, Max: endpoints.MaxHistoryLimit})
+	if err != nil {
+		return nil, errors.Wrap(endpoints.ErrInvalidQueryParams, err)
+	}
+	req, err := decodeHTTPHistoryFilter(r)
+	if err != nil {
+		return nil, err
+	}
+	req.Cursor, req.Limit = page.Cursor, page.Limit
+	return req, nil
+}
+
+// decodeHTTPHistoryFilter decodes the filter query parameters of the history
+// requests.
+func decodeHTTPHistoryFilter(r *http.Request) (req endpoints.HistoryRequest, err error) {
+	q := r.URL.Query()
+	req.Method, req.Caller = q.Get("method"), q.Get("caller")
+	rng, err := timecodec.ParseRange(q, "since", "until")
+	if err != nil {
+		return req, err
+	}
+	req.Since, req.Until = rng.Since, rng.Until
+	return req, nil
+}
+
+// decodeHTTPBatchRequest is a transport/http.DecodeRequestFunc that decodes a
+// JSON-encoded batch request from the HTTP request body. Primarily useful in a server.
+func decodeHTTPBatchRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req endpoints.BatchRequest
+	err := decodeJSONBody(ctx, r.Body, &req)
 	return req, err
 }
 
 // NewHTTPClient returns an AddService backed by an HTTP server living at the
 // remote instance. We expect instance to come from a service discovery system,
 // so likely of the form "host:port". We bake-in certain middlewares,
-// implementing the client library pattern.
-func NewHTTPClient(instance string, otTracer stdopentracing.Tracer, zipkinTracer *stdzipkin.Tracer, logger log.Logger) (service.AddService, error) { // Quickly sanitize the instance string.
-	if !strings.HasPrefix(instance, "http") {
-		instance = "http://" + instance
-	}
-	u, err := url.Parse(instance)
-	if err != nil {
+// implementing the client library pattern. o configures the connection pool
+// and the timeouts. otTracer and zipkinTracer may be nil when not tracing
+// with them.
+func NewHTTPClient(instance string, o HTTPClientOptions, otTracer stdopentracing.Tracer, zipkinTracer *stdzipkin.Tracer, logger log.Logger) (service.AddService, error) {
+	if _, err := instanceURL(instance); err != nil {
 		return nil, err
 	}
+	return NewHTTPDiscoveryClient(Discovery{Instancer: sd.FixedInstancer{instance}, Attempts: 1}, o, otTracer, zipkinTracer, logger)
+}
 
-	// Zipkin HTTP Client Trace can either be instantiated per endpoint with a
-	// provided operation name or a global tracing client can be instantiated
-	// without an operation name and fed to each Go kit endpoint as ClientOption.
-	// In the latter case, the operation name will be the endpoint's http method.
-	zipkinClient := zipkin.HTTPClientTrace(zipkinTracer)
+// NewHTTPDiscoveryClient returns an AddService backed by the HTTP servers of
+// the instances found by d. Every call is load balanced over the instances
+// and retried on another one when it fails in transport. The instances share
+// the connection pool configured by o, which also sets the JWT the calls
+// send: the caller's JWT is never forwarded as is.
+func NewHTTPDiscoveryClient(d Discovery, o HTTPClientOptions, otTracer stdopentracing.Tracer, zipkinTracer *stdzipkin.Tracer, logger log.Logger) (service.AddService, error) {
+	// global client middlewares, the tracers are optional
+	options := httpClientTracing(otTracer, zipkinTracer, logger, tracing.ContextToHTTP(), tenant.ContextToHTTP())
+	options = append(options, o.clientOptions(logger)...)
 
-	// global client middlewares
-	options := []httptransport.ClientOption{
-		zipkinClient,
+	// factory returns the factory of the endpoint of every instance for the
+	// given method and path.
+	factory := func(method, path string, enc httptransport.EncodeRequestFunc, dec httptransport.DecodeResponseFunc) sd.Factory {
+		return func(instance string) (endpoint.Endpoint, io.Closer, error) {
+			u, err := instanceURL(instance)
+			if err != nil {
+				return nil, nil, err
+			}
+			return httptransport.NewClient(method, copyURL(u, path), enc, dec, options...).Endpoint(), nil, nil
+		}
 	}
 
 	e := endpoints.Endpoints{}
 
-	// Each individual endpoint is an http/transport.Client (which implements
-	// endpoint.Endpoint) that gets wrapped with various middlewares. If you
+	// Each individual endpoint is load balanced over the http/transport.Client
+	// endpoints of the instances, then wrapped with various middlewares. If you
 	// made your own client library, you'd do this work there, so your server
 	// could rely on a consistent set of client behavior.
-	// The Sum endpoint is the same thing, with slightly different
-	// middlewares to demonstrate how to specialize per-endpoint.
 	var sumEndpoint endpoint.Endpoint
 	{
-		sumEndpoint = httptransport.NewClient(
-			"POST",
-			copyURL(u, "/sum"),
-			encodeHTTPSumRequest,
-			decodeHTTPSumResponse,
-			append(options, httptransport.ClientBefore(opentracing.ContextToHTTP(otTracer, logger)))...,
-		).Endpoint()
-		sumEndpoint = opentracing.TraceClient(otTracer, "Sum")(sumEndpoint)
-		sumEndpoint = zipkin.TraceEndpoint(zipkinTracer, "Sum")(sumEndpoint)
+		sumEndpoint = d.endpoint(factory("POST", "/api/add/sum", encodeHTTPSumRequest, decodeHTTPSumResponse), logger)
+		sumEndpoint = o.exchange()(sumEndpoint)
+		sumEndpoint = o.timeout("Sum")(sumEndpoint)
+		sumEndpoint = openTracingClient(otTracer, "Sum")(sumEndpoint)
+		sumEndpoint = tracing.TraceClient("Sum")(sumEndpoint)
+		sumEndpoint = zipkinClient(zipkinTracer, "Sum")(sumEndpoint)
 		e.SumEndpoint = sumEndpoint
 	}
 
@@ -136,24 +339,47 @@ func NewHTTPClient(instance string, otTracer stdopentracing.Tracer, zipkinTracer
 	// middlewares to demonstrate how to specialize per-endpoint.
 	var concatEndpoint endpoint.Endpoint
 	{
-		concatEndpoint = httptransport.NewClient(
-			"POST",
-			copyURL(u, "/concat"),
-			encodeHTTPConcatRequest,
-			decodeHTTPConcatResponse,
-			append(options, httptransport.ClientBefore(opentracing.ContextToHTTP(otTracer, logger)))...,
-		).Endpoint()
-		concatEndpoint = opentracing.TraceClient(otTracer, "Concat")(concatEndpoint)
-		concatEndpoint = zipkin.TraceEndpoint(zipkinTracer, "Concat")(concatEndpoint)
+		concatEndpoint = d.endpoint(factory("POST", "/api/add/concat", encodeHTTPConcatRequest, decodeHTTPConcatResponse), logger)
+		concatEndpoint = o.exchange()(concatEndpoint)
+		concatEndpoint = o.timeout("Concat")(concatEndpoint)
+		concatEndpoint = openTracingClient(otTracer, "Concat")(concatEndpoint)
+		concatEndpoint = tracing.TraceClient("Concat")(concatEndpoint)
+		concatEndpoint = zipkinClient(zipkinTracer, "Concat")(concatEndpoint)
 		e.ConcatEndpoint = concatEndpoint
 	}
 
+	// The History endpoint is the same thing, with slightly different
+	// middlewares to demonstrate how to specialize per-endpoint.
+	var historyEndpoint endpoint.Endpoint
+	{
+		historyEndpoint = d.endpoint(factory("GET", "/api/add/history", encodeHTTPHistoryRequest, decodeHTTPHistoryResponse), logger)
+		historyEndpoint = o.exchange()(historyEndpoint)
+		historyEndpoint = o.timeout("History")(historyEndpoint)
+		historyEndpoint = openTracingClient(otTracer, "History")(historyEndpoint)
+		historyEndpoint = tracing.TraceClient("History")(historyEndpoint)
+		historyEndpoint = zipkinClient(zipkinTracer, "History")(historyEndpoint)
+		e.HistoryEndpoint = historyEndpoint
+	}
+
+	// Export streams, which the client doesn't read yet.
+	e.ExportEndpoint = func(context.Context, interface{}) (interface{}, error) {
+		return nil, errors.New("Export is only served to streaming HTTP clients")
+	}
+
 	// Returning the endpoint.Set as a service.Service relies on the
 	// endpoint.Set implementing the Service methods. That's just a simple bit
 	// of glue code.
 	return e, nil
 }
 
+// instanceURL returns the base URL of instance, quickly sanitized.
+func instanceURL(instance string) (*url.URL, error) {
+	if !strings.HasPrefix(instance, "http") {
+		instance = "http://" + instance
+	}
+	return url.Parse(instance)
+}
+
 //
 func copyURL(base *url.URL, path string) *url.URL {
 	next := *base
@@ -180,9 +406,11 @@ func decodeHTTPSumResponse(_ context.Context, r *http.Response) (interface{}, er
 	if r.StatusCode != http.StatusOK {
 		return nil, JSONErrorDecoder(r)
 	}
-	var resp endpoints.SumResponse
+	var resp struct {
+		Data endpoints.SumResponse `json:"data"`
+	}
 	err := json.NewDecoder(r.Body).Decode(&resp)
-	return resp, err
+	return resp.Data, err
 }
 
 // encodeHTTPConcatRequest is a transport/http.EncodeRequestFunc that
@@ -204,56 +432,147 @@ func decodeHTTPConcatResponse(_ context.Context, r *http.Response) (interface{},
 	if r.StatusCode != http.StatusOK {
 		return nil, JSONErrorDecoder(r)
 	}
-	var resp endpoints.ConcatResponse
+	var resp struct {
+		Data endpoints.ConcatResponse `json:"data"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp.Data, err
+}
+
+// encodeHTTPHistoryRequest is a transport/http.EncodeRequestFunc that
+// encodes a history request as query parameters. Primarily useful in a client.
+func encodeHTTPHistoryRequest(_ context.Context, r *http.Request, request interface{}) (err error) {
+	req := request.(endpoints.HistoryRequest)
+	q := r.URL.Query()
+	if req.Method != "" {
+		q.Set("method", req.Method)
+	}
+	if req.Caller != "" {
+		q.Set("caller", req.Caller)
+	}
+	timecodec.Range{Since: req.Since, Until: req.Until}.Set(q, "since", "until")
+	if req.Cursor != "" {
+		q.Set("cursor", req.Cursor)
+	}
+	q.Set("limit", strconv.Itoa(req.Limit))
+	r.URL.RawQuery = q.Encode()
+	return nil
+}
+
+// decodeHTTPHistoryResponse is a transport/http.DecodeResponseFunc that decodes a
+// JSON-encoded history response from the HTTP response body. If the response has a
+// non-200 status code, we will interpret that as an error and attempt to decode
+// the specific error message from the response body. Primarily useful in a client.
+func decodeHTTPHistoryResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, JSONErrorDecoder(r)
+	}
+	var resp struct {
+		Data endpoints.HistoryResponse `json:"data"`
+	}
 	err := json.NewDecoder(r.Body).Decode(&resp)
-	return resp, err
+	return resp.Data, err
 }
 
-func httpEncodeError(_ context.Context, err error, w http.ResponseWriter) {
-	code := http.StatusInternalServerError
+func httpEncodeError(ctx context.Context, err error, w http.ResponseWriter) {
+	if chaos.Aborted(err) {
+		// net/http closes the connection without a response
+		panic(http.ErrAbortHandler)
+	}
+	item := httpErrorItem(ctx, err)
+	if item.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(item.RetryAfter))
+	}
+	if codec := negotiatedCodec(ctx); isXMLRequest(ctx) && codec.ContentType() == responses.XMLContentType {
+		w.Header().Set("Content-Type", responses.XMLContentType)
+		w.WriteHeader(item.Code)
+		codec.Encode(w, responses.ErrorRes{Error: item})
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(item.Code)
+	json.NewEncoder(w).Encode(responses.ErrorRes{Error: item})
+}
+
+// httpErrorItem maps err to the HTTP status code of its kind, see
+// errors.Register, and the error body shared by every transport that reports
+// errors the HTTP way, stamped with the time and the trace of the request of
+// ctx. The body discloses err as the errors.Verbosity tells.
+func httpErrorItem(ctx context.Context, err error) responses.ErrorResItem {
+	code := errors.HTTPStatus(errors.KindOf(err))
+	if errors.Contains(errors.Cast(err), ErrBodyTooLarge) {
+		code = http.StatusRequestEntityTooLarge
+	}
 	var message string
 	var errs []errors.Errors
-	w.Header().Set("Content-Type", contentType)
 	if s, ok := status.FromError(err); !ok {
 		// HTTP
-		switch errorVal := err.(type) {
-		case errors.Error:
-			switch {
-			// TODO write your own custom error check here
+		shown := err
+		switch err.(type) {
+		case *json.SyntaxError, *json.UnmarshalTypeError:
+			code = http.StatusBadRequest
+			if errors.CurrentVerbosity() == errors.VerbositySanitized {
+				shown = errors.Wrap(ErrMalformedEntity, err)
 			}
-
+		}
+		if errors.CurrentVerbosity() == errors.VerbositySanitized {
+			shown = errors.Sanitize(shown)
+		}
+		switch errorVal := shown.(type) {
+		case errors.Error:
 			if errorVal.Msg() != "" {
 				message, errs = errorVal.Msg(), errorVal.Errors()
 			}
 		default:
-			switch err {
-			case io.ErrUnexpectedEOF, io.EOF:
-				code = http.StatusBadRequest
-			case kitjwt.ErrTokenContextMissing:
-				code = http.StatusUnauthorized
-			default:
-				switch err.(type) {
-				case *json.SyntaxError, *json.UnmarshalTypeError:
-					code = http.StatusBadRequest
-				}
-			}
-
 			errs = errors.FromError(err.Error())
 			message = errs[0].Message
 		}
 	} else {
 		// GRPC
-		code = HTTPStatusFromCode(s.Code())
 		errs = errors.FromError(s.Message())
 		message = errs[0].Message
 	}
 
-	w.WriteHeader(code)
-	json.NewEncoder(w).Encode(responses.ErrorRes{responses.ErrorResItem{code, message, errs}})
+	item := responses.ErrorResItem{
+		Code:      code,
+		ErrorCode: errors.Code(err),
+		Message:   message,
+		Errors:    errs,
+		Timestamp: timecodec.Format(time.Now()),
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		item.TraceID = sc.TraceID().String()
+	}
+	if errors.CurrentVerbosity() == errors.VerbosityFull {
+		item.StackID, item.Stack = errors.StackID(err), errors.Stack(err)
+	}
+	if item.Code >= http.StatusInternalServerError {
+		item.Reference = errorReference(ctx, err)
+	}
+	item.HelpURL = errors.HelpURL(item.ErrorCode)
+	if d, ok := errors.RetryAfter(err); ok {
+		item.RetryAfter = int(math.Ceil(d.Seconds()))
+	}
+	return item
 }
 
-func encodeJSONResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+// encodeJSONResponse is a transport/http.EncodeResponseFunc writing the
+// response in the codec of package responses the Accept header prefers,
+// JSON by default. The Accept header is put in the context by
+// httptransport.PopulateRequestContext.
+func encodeJSONResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	body, err := maskBody(ctx, envelopeBody(ctx, response))
+	if err != nil {
+		return err
+	}
+
+	codec := negotiatedCodec(ctx)
+	w.Header().Add("Vary", "Accept")
+	if codec.ContentType() == responses.JSONContentType {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", codec.ContentType())
+	}
 	if headerer, ok := response.(httptransport.Headerer); ok {
 		for k, values := range headerer.Headers() {
 			for _, v := range values {
@@ -270,9 +589,11 @@ func encodeJSONResponse(_ context.Context, w http.ResponseWriter, response inter
 		return nil
 	}
 
-	if ar, ok := response.(responses.Responser); ok {
-		return json.NewEncoder(w).Encode(ar.Response())
-	}
+	return codec.Encode(w, body)
+}
 
-	return json.NewEncoder(w).Encode(response)
+// negotiatedCodec returns the codec the Accept header of the request prefers.
+func negotiatedCodec(ctx context.Context) responses.Codec {
+	accept, _ := ctx.Value(httptransport.ContextKeyRequestAccept).(string)
+	return responses.Negotiate(accept)
 }
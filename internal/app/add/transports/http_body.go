@@ -0,0 +1,173 @@
+package transports
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// ErrBodyTooLarge indicates a request body, once decompressed, exceeding the
+// limit set with MaxBodyBytes. It's reported as 413 Request Entity Too Large.
+var ErrBodyTooLarge = errors.Register(errors.KindOutOfRange, errors.NewCoded("ADD-007", "request body too large"))
+
+// ErrUnsupportedEncoding indicates a request body in a Content-Encoding other
+// than gzip or identity.
+var ErrUnsupportedEncoding = errors.Register(errors.KindInvalidArgument, errors.NewCoded("ADD-008", "unsupported content encoding"))
+
+// bodyHandler decompresses gzip request bodies, limits their size, and
+// compresses the responses of callers accepting gzip.
+func bodyHandler(next http.Handler, maxBytes int64, compress bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch enc := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); enc {
+		case "", "identity":
+		case "gzip":
+			r.Body = &gzipBody{src: r.Body}
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
+		default:
+			httpEncodeError(r.Context(), errors.Wrap(ErrUnsupportedEncoding, errors.New(enc)), w)
+			return
+		}
+		if maxBytes > 0 {
+			if r.ContentLength > maxBytes {
+				httpEncodeError(r.Context(), ErrBodyTooLarge, w)
+				return
+			}
+			r.Body = &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, maxBytes), n: maxBytes}
+		}
+
+		if compress && acceptsGzip(r) {
+			gw := &gzipResponseWriter{ResponseWriter: w}
+			defer gw.Close()
+			w = gw
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acceptsGzip reports whether the response to r may be gzip compressed.
+// Upgrades, event streams and ranged requests are left alone.
+func acceptsGzip(r *http.Request) bool {
+	if r.Header.Get("Upgrade") != "" || r.Header.Get("Range") != "" || strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		return false
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		if i := strings.IndexByte(part, ';'); i >= 0 {
+			if strings.TrimSpace(part[i+1:]) == "q=0" {
+				continue
+			}
+			part = part[:i]
+		}
+		if strings.TrimSpace(part) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipBody decompresses src, reading the gzip header on first use so errors
+// surface to the request decoder.
+type gzipBody struct {
+	src io.ReadCloser
+	zr  *gzip.Reader
+}
+
+func (b *gzipBody) Read(p []byte) (int, error) {
+	if b.zr == nil {
+		zr, err := gzip.NewReader(b.src)
+		if err != nil {
+			return 0, errors.Wrap(ErrMalformedEntity, err)
+		}
+		b.zr = zr
+	}
+	return b.zr.Read(p)
+}
+
+func (b *gzipBody) Close() error {
+	return b.src.Close()
+}
+
+// limitedBody reports the error of http.MaxBytesReader as ErrBodyTooLarge.
+type limitedBody struct {
+	io.ReadCloser
+	n    int64
+	read int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if err != nil && err != io.EOF && b.read >= b.n {
+		return n, ErrBodyTooLarge
+	}
+	return n, err
+}
+
+// gzipResponseWriter compresses the response body, unless the handler set a
+// Content-Encoding of its own, or a Digest of the uncompressed body.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	zw          *gzip.Writer
+	wroteHeader bool
+	passThrough bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	h := w.Header()
+	h.Add("Vary", "Accept-Encoding")
+	if h.Get("Content-Encoding") != "" || h.Get("Digest") != "" || code == http.StatusNoContent || code == http.StatusNotModified {
+		w.passThrough = true
+	} else {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		w.zw = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.passThrough {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.zw.Write(b)
+}
+
+// Flush flushes the compressed bytes written so far.
+func (w *gzipResponseWriter) Flush() {
+	if w.zw != nil {
+		w.zw.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack keeps websocket upgrades working.
+func (w *gzipResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return h.Hijack()
+}
+
+// Close writes the gzip footer.
+func (w *gzipResponseWriter) Close() error {
+	if w.zw == nil {
+		return nil
+	}
+	return w.zw.Close()
+}
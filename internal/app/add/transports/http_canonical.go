@@ -0,0 +1,50 @@
+package transports
+
+import (
+	"context"
+	"net/http"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/canonjson"
+)
+
+// jsonEncoder returns the JSON EncodeResponseFunc of the API route path,
+// writing canonical JSON when the route does, see CanonicalJSON.
+func (o *httpOptions) jsonEncoder(path string) httptransport.EncodeResponseFunc {
+	if o.canonicalRoutes[""] || o.canonicalRoutes[path] {
+		return encodeCanonicalJSONResponse
+	}
+	return encodeJSONResponse
+}
+
+// encodeCanonicalJSONResponse is encodeJSONResponse writing canonical JSON.
+func encodeCanonicalJSONResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	body, err := maskBody(ctx, envelopeBody(ctx, response))
+	if err != nil {
+		return err
+	}
+	b, err := canonjson.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if headerer, ok := response.(httptransport.Headerer); ok {
+		for k, values := range headerer.Headers() {
+			for _, v := range values {
+				w.Header().Add(k, v)
+			}
+		}
+	}
+	code := http.StatusOK
+	if sc, ok := response.(httptransport.StatusCoder); ok {
+		code = sc.StatusCode()
+	}
+	w.WriteHeader(code)
+	if code == http.StatusNoContent {
+		return nil
+	}
+	_, err = w.Write(b)
+	return err
+}
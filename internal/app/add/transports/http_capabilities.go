@@ -0,0 +1,99 @@
+package transports
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/endpoints"
+	"github.com/cage1016/gokit-gae/internal/app/add/service"
+	"github.com/cage1016/gokit-gae/internal/pkg/responses"
+)
+
+// Capabilities describes what the server supports, so that clients adapt to
+// it rather than hard-code its limits. It's served at
+// /api/add/capabilities.
+type Capabilities struct {
+	// Contract is the ContractVersion of the API.
+	Contract string `json:"contract"`
+	// Versions are the API versions served, the default one first, and
+	// Deprecated the ones among them about to go away.
+	Versions   []string `json:"versions"`
+	Deprecated []string `json:"deprecated,omitempty"`
+	// Features are the optional features enabled, e.g. "compression".
+	Features []string `json:"features"`
+	// Codecs are the content types the API routes answer, see
+	// responses.RegisterCodec. They accept JSON and protobuf.
+	Codecs []string `json:"codecs"`
+	Limits Limits   `json:"limits"`
+}
+
+// Limits are the limits the server enforces.
+type Limits struct {
+	// MaxBatchSize is the maximum number of operations of /batch, and
+	// MaxJSONRPCBatch the one of calls of a JSON-RPC batch.
+	MaxBatchSize    int `json:"maxBatchSize"`
+	MaxJSONRPCBatch int `json:"maxJsonRpcBatch"`
+	// MaxBodyBytes is the maximum size of request bodies, after
+	// decompression, when limited.
+	MaxBodyBytes int64 `json:"maxBodyBytes,omitempty"`
+	// RateLimits are the quotas of every caller.
+	RateLimits []RateLimit `json:"rateLimits,omitempty"`
+}
+
+// RateLimit is a quota of burst requests per caller, refilled one every
+// interval.
+type RateLimit struct {
+	// Scope names the requests charged to the quota.
+	Scope    string `json:"scope"`
+	Burst    int    `json:"burst"`
+	Interval string `json:"interval"`
+}
+
+// features are the features built in, see Capabilities.Features.
+var features = []string{"batch", "history", "export", "sse", "websocket", "jsonrpc", "grpc-web", "grpc-gateway", "fields", "pagination", "etag", "locale", "csv"}
+
+// capabilities returns the capabilities of a handler serving versions.
+func (o *httpOptions) capabilities(versions []HTTPVersion) Capabilities {
+	c := Capabilities{
+		Contract: ContractVersion,
+		Features: append([]string(nil), features...),
+		Codecs:   append(responses.ContentTypes(), protobufContentType),
+		Limits: Limits{
+			MaxBatchSize:    endpoints.MaxBatchSize,
+			MaxJSONRPCBatch: jsonrpcMaxBatch,
+			MaxBodyBytes:    o.maxBodyBytes,
+			RateLimits:      o.rateLimits,
+		},
+	}
+	for _, v := range versions {
+		c.Versions = append(c.Versions, v.Name)
+		if v.Deprecated {
+			c.Deprecated = append(c.Deprecated, v.Name)
+		}
+	}
+	if o.compression {
+		c.Features = append(c.Features, "compression")
+	}
+	if len(o.canonicalRoutes) > 0 {
+		c.Features = append(c.Features, "canonical-json")
+	}
+	if o.downloads != nil {
+		c.Features = append(c.Features, "resumable-export")
+	}
+	if len(o.xmlRoutes) > 0 {
+		c.Features = append(c.Features, "xml-requests")
+	}
+	if o.envelope {
+		c.Features = append(c.Features, "envelope")
+	}
+	return c
+}
+
+// capabilitiesHandler serves c.
+func capabilitiesHandler(c Capabilities) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(responses.DataRes{APIVersion: service.Version, Data: c})
+	})
+}
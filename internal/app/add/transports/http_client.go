@@ -0,0 +1,174 @@
+package transports
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	httptransport "github.com/go-kit/kit/transport/http"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/gcp"
+	"github.com/cage1016/gokit-gae/internal/pkg/mirror"
+	"github.com/cage1016/gokit-gae/internal/pkg/tokenexchange"
+)
+
+// HTTPClientOptions configures the connection pool and the timeouts of the
+// HTTP clients. Zero fields take the documented defaults.
+type HTTPClientOptions struct {
+	// MaxIdleConns bounds the idle connections kept open, 100 by default.
+	MaxIdleConns int
+	// MaxIdleConnsPerHost bounds the idle connections kept open per
+	// instance, 10 by default.
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout closes idle connections after it, 90s by default.
+	IdleConnTimeout time.Duration
+	// DisableKeepAlives opens a new connection for every request.
+	DisableKeepAlives bool
+	// KeepAlive is the TCP keep-alive period, 30s by default.
+	KeepAlive time.Duration
+	// DialTimeout bounds establishing connections, 5s by default.
+	DialTimeout time.Duration
+	// TLSHandshakeTimeout bounds TLS handshakes, 5s by default.
+	TLSHandshakeTimeout time.Duration
+	// ResponseHeaderTimeout bounds waiting for the response headers once the
+	// request is sent, 10s by default.
+	ResponseHeaderTimeout time.Duration
+	// IdentityTokens, when set, signs every request with a Google-signed
+	// identity token, for targets requiring authenticated invocations such
+	// as IAP, Cloud Run or App Engine behind IAP. See gcp.NewIDTokenSource.
+	IdentityTokens gcp.TokenSource
+	// IdentityHeader is the header carrying the identity token,
+	// Authorization by default. Use X-Serverless-Authorization for Cloud Run
+	// or Proxy-Authorization for IAP to keep Authorization for the caller's
+	// JWT.
+	IdentityHeader string
+	// Timeouts bounds the calls by method name ("Sum", "Concat", "History"),
+	// retries included. Methods without a timeout are only bounded by the
+	// context of the caller and the timeout of the Discovery.
+	Timeouts map[string]time.Duration
+	// Mirror, when set, copies its share of the calls to its shadow
+	// backend, see mirror.Mirror.RoundTripper.
+	Mirror *mirror.Mirror
+	// TokenExchange, when set, sends every call on behalf of the caller with
+	// a token exchanged for the caller's JWT, narrowed to the target. The
+	// caller's JWT itself is never sent.
+	TokenExchange *TokenExchange
+	// Token, when set and TokenExchange isn't, is the JWT of every call, for
+	// clients calling on their own behalf such as loadgen.
+	Token string
+}
+
+// TokenExchange configures the exchange of the caller's JWT for the token
+// of the calls, see tokenexchange.Middleware.
+type TokenExchange struct {
+	Exchanger tokenexchange.Exchanger
+	// Audience and Scopes are those requested for the exchanged token.
+	Audience string
+	Scopes   []string
+}
+
+// client returns the *http.Client configured by o.
+func (o HTTPClientOptions) client() *http.Client {
+	or := func(v, def time.Duration) time.Duration {
+		if v > 0 {
+			return v
+		}
+		return def
+	}
+	maxIdle, maxIdlePerHost := o.MaxIdleConns, o.MaxIdleConnsPerHost
+	if maxIdle <= 0 {
+		maxIdle = 100
+	}
+	if maxIdlePerHost <= 0 {
+		maxIdlePerHost = 10
+	}
+
+	var transport http.RoundTripper = &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   or(o.DialTimeout, 5*time.Second),
+			KeepAlive: or(o.KeepAlive, 30*time.Second),
+		}).DialContext,
+		MaxIdleConns:          maxIdle,
+		MaxIdleConnsPerHost:   maxIdlePerHost,
+		IdleConnTimeout:       or(o.IdleConnTimeout, 90*time.Second),
+		DisableKeepAlives:     o.DisableKeepAlives,
+		TLSHandshakeTimeout:   or(o.TLSHandshakeTimeout, 5*time.Second),
+		ResponseHeaderTimeout: or(o.ResponseHeaderTimeout, 10*time.Second),
+		ExpectContinueTimeout: time.Second,
+	}
+	if o.Mirror != nil {
+		transport = o.Mirror.RoundTripper(transport)
+	}
+	return &http.Client{Transport: transport}
+}
+
+// IdentityToken returns a ClientBefore request func setting header, or
+// Authorization when empty, to a bearer identity token of src. When no token
+// can be minted the header is left out, the target then rejects the call as
+// unauthenticated, and the error is logged.
+func IdentityToken(src gcp.TokenSource, header string, logger log.Logger) httptransport.RequestFunc {
+	if header == "" {
+		header = "Authorization"
+	}
+	return func(ctx context.Context, r *http.Request) context.Context {
+		token, err := src.Token(ctx)
+		if err != nil {
+			level.Warn(logger).Log("identity_token", r.URL.Host, "err", err)
+			return ctx
+		}
+		r.Header.Set(header, "Bearer "+token)
+		return ctx
+	}
+}
+
+// clientOptions returns the client options configured by o.
+func (o HTTPClientOptions) clientOptions(logger log.Logger) []httptransport.ClientOption {
+	options := []httptransport.ClientOption{httptransport.SetClient(o.client())}
+	switch {
+	case o.TokenExchange != nil:
+		// the context token is the exchanged one, see exchange
+		options = append(options, httptransport.ClientBefore(kitjwt.ContextToHTTP()))
+	case o.Token != "":
+		options = append(options, httptransport.ClientBefore(func(ctx context.Context, r *http.Request) context.Context {
+			r.Header.Set("Authorization", "Bearer "+o.Token)
+			return ctx
+		}))
+	}
+	if o.IdentityTokens != nil {
+		// after the request funcs propagating the caller's JWT, so the
+		// identity token wins when both use Authorization
+		options = append(options, httptransport.ClientBefore(IdentityToken(o.IdentityTokens, o.IdentityHeader, logger)))
+	}
+	return options
+}
+
+// exchange returns an endpoint middleware swapping the caller's JWT in the
+// context for the token exchanged by o.TokenExchange, if any.
+func (o HTTPClientOptions) exchange() endpoint.Middleware {
+	if o.TokenExchange == nil {
+		return passThrough
+	}
+	return tokenexchange.Middleware(o.TokenExchange.Exchanger, o.TokenExchange.Audience, o.TokenExchange.Scopes...)
+}
+
+// timeout returns an endpoint middleware bounding the calls of method by its
+// timeout, if any.
+func (o HTTPClientOptions) timeout(method string) endpoint.Middleware {
+	d, ok := o.Timeouts[method]
+	if !ok || d <= 0 {
+		return passThrough
+	}
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+			return next(ctx, request)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package transports
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/endpoints"
+	"github.com/cage1016/gokit-gae/internal/app/add/repository"
+	"github.com/cage1016/gokit-gae/internal/pkg/pagination"
+)
+
+// httpContract is the HTTP contract of an endpoint: sample values and the
+// codecs of the client and the server they go through.
+type httpContract struct {
+	// endpoint is the field of endpoints.Endpoints.
+	endpoint string
+
+	method, path   string
+	request        interface{}
+	encodeRequest  httptransport.EncodeRequestFunc
+	decodeRequest  httptransport.DecodeRequestFunc
+	response       interface{}
+	encodeResponse httptransport.EncodeResponseFunc
+	decodeResponse httptransport.DecodeResponseFunc
+}
+
+var contractCalculations = []repository.Calculation{
+	{
+		ID:        "calc-1",
+		Method:    "sum",
+		A:         "1",
+		B:         "2",
+		Result:    "3",
+		Caller:    "user@example.com",
+		CreatedAt: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	},
+	{
+		ID:        "calc-2",
+		Method:    "concat",
+		A:         "a \"quoted\"",
+		B:         "ünïcode ✓",
+		Result:    "a \"quoted\"ünïcode ✓",
+		Caller:    "user@example.com",
+		CreatedAt: time.Date(2020, 1, 2, 3, 4, 6, 0, time.UTC),
+	},
+}
+
+// httpContracts lists the contracts of every endpoint. An endpoint added to
+// endpoints.Endpoints without its contract here fails TestHTTPContracts.
+var httpContracts = []httpContract{
+	{
+		endpoint:       "SumEndpoint",
+		method:         http.MethodPost,
+		path:           "/api/add/sum",
+		request:        endpoints.SumRequest{A: 1, B: -9007199254740993},
+		encodeRequest:  encodeHTTPSumRequest,
+		decodeRequest:  decodeHTTPSumRequest,
+		response:       endpoints.SumResponse{Res: -9007199254740992},
+		decodeResponse: decodeHTTPSumResponse,
+	},
+	{
+		endpoint:       "ConcatEndpoint",
+		method:         http.MethodPost,
+		path:           "/api/add/concat",
+		request:        endpoints.ConcatRequest{A: "a \"quoted\" <b>", B: "ünïcode ✓"},
+		encodeRequest:  encodeHTTPConcatRequest,
+		decodeRequest:  decodeHTTPConcatRequest,
+		response:       endpoints.ConcatResponse{Res: "a \"quoted\" <b>ünïcode ✓"},
+		decodeResponse: decodeHTTPConcatResponse,
+	},
+	{
+		endpoint: "HistoryEndpoint",
+		method:   http.MethodGet,
+		path:     "/api/add/history",
+		request: endpoints.HistoryRequest{
+			Method: "sum",
+			Caller: "user@example.com",
+			Since:  time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+			Until:  time.Date(2020, 2, 3, 4, 5, 6, 0, time.UTC),
+			Cursor: "Y3Vyc29y",
+			Limit:  7,
+		},
+		encodeRequest: encodeHTTPHistoryRequest,
+		decodeRequest: decodeHTTPHistoryRequest,
+		response: endpoints.HistoryResponse{
+			Items:      contractCalculations[:1],
+			NextCursor: "bmV4dA",
+			Page:       pagination.CursorMeta{Limit: 7, Count: 1, NextCursor: "bmV4dA"},
+		},
+		decodeResponse: decodeHTTPHistoryResponse,
+	},
+	{
+		endpoint: "ExportEndpoint",
+		method:   http.MethodGet,
+		path:     "/api/add/history/export",
+		request: endpoints.ExportRequest{
+			Method: "concat",
+			Caller: "user@example.com",
+			Since:  time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+			Until:  time.Date(2020, 2, 3, 4, 5, 6, 0, time.UTC),
+		},
+		encodeRequest:  encodeExportRequest,
+		decodeRequest:  decodeHTTPExportRequest,
+		response:       contractCalculations,
+		encodeResponse: encodeExportRows,
+		decodeResponse: decodeExportRows,
+	},
+	{
+		endpoint: "BatchEndpoint",
+		method:   http.MethodPost,
+		path:     "/api/add/batch",
+		request: endpoints.BatchRequest{
+			Operations: []endpoints.BatchOperation{
+				{Sum: &endpoints.SumRequest{A: 1, B: -9007199254740993}},
+				{Concat: &endpoints.ConcatRequest{A: "a \"quoted\" <b>", B: "ünïcode ✓"}},
+			},
+			Concurrent: true,
+		},
+		// a batch is JSON-encoded as any other request body
+		encodeRequest: encodeHTTPSumRequest,
+		decodeRequest: decodeHTTPBatchRequest,
+		response: endpoints.BatchResponse{
+			Results: []endpoints.BatchResult{
+				{Index: 0, Sum: &endpoints.SumResponse{Res: -9007199254740992}},
+				{Index: 1, Concat: &endpoints.ConcatResponse{Res: "a \"quoted\" <b>ünïcode ✓"}},
+			},
+		},
+		decodeResponse: decodeBatchResponse,
+	},
+}
+
+// TestHTTPContracts round-trips the sample request of every endpoint
+// through the request encoder of the client and the decoder of the server,
+// and its sample response through the response encoder of the server and
+// the decoder of the client, to catch the drift of the codecs.
+func TestHTTPContracts(t *testing.T) {
+	known := map[string]bool{}
+	for _, c := range httpContracts {
+		known[c.endpoint] = true
+		t.Run(c.endpoint, c.check)
+	}
+	typ := reflect.TypeOf(endpoints.Endpoints{})
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.Type == reflect.TypeOf(endpoint.Endpoint(nil)) && !known[f.Name] {
+			t.Errorf("%s has no contract", f.Name)
+		}
+	}
+}
+
+// check round-trips the request and the response of c.
+func (c httpContract) check(t *testing.T) {
+	ctx := context.Background()
+	r, err := http.NewRequest(c.method, "http://contract"+c.path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.encodeRequest(ctx, r, c.request); err != nil {
+		t.Fatalf("encoding the request: %v", err)
+	}
+	req, err := c.decodeRequest(ctx, r)
+	if err != nil {
+		t.Fatalf("decoding the request: %v", err)
+	}
+	if !reflect.DeepEqual(req, c.request) {
+		t.Errorf("request sent %+v, received %+v", c.request, req)
+	}
+
+	encodeResponse := c.encodeResponse
+	if encodeResponse == nil {
+		encodeResponse = encodeJSONResponse
+	}
+	w := httptest.NewRecorder()
+	if err := encodeResponse(ctx, w, c.response); err != nil {
+		t.Fatalf("encoding the response: %v", err)
+	}
+	res, err := c.decodeResponse(ctx, w.Result())
+	if err != nil {
+		t.Fatalf("decoding the response: %v", err)
+	}
+	if !reflect.DeepEqual(res, c.response) {
+		t.Errorf("response sent %+v, received %+v", c.response, res)
+	}
+}
+
+// The HTTP client calls neither Export nor Batch, the codecs below are the
+// ones of the clients of their routes.
+
+// encodeExportRequest sets the filter query parameters of an export, the
+// ones of a history listing.
+func encodeExportRequest(ctx context.Context, r *http.Request, request interface{}) error {
+	req := request.(endpoints.ExportRequest)
+	if err := encodeHTTPHistoryRequest(ctx, r, endpoints.HistoryRequest{Method: req.Method, Caller: req.Caller, Since: req.Since, Until: req.Until}); err != nil {
+		return err
+	}
+	q := r.URL.Query()
+	q.Del("limit")
+	r.URL.RawQuery = q.Encode()
+	return nil
+}
+
+// encodeExportRows streams the calculations of response through
+// encodeHTTPExportResponse.
+func encodeExportRows(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	return encodeHTTPExportResponse(ctx, w, endpoints.ExportResponse{Items: &sliceIterator{items: response.([]repository.Calculation)}})
+}
+
+// decodeExportRows reads the newline-delimited calculations of an export.
+func decodeExportRows(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, JSONErrorDecoder(r)
+	}
+	if ct := r.Header.Get("Content-Type"); ct != ndjsonContentType {
+		return nil, fmt.Errorf("Content-Type %q", ct)
+	}
+	var items []repository.Calculation
+	s := bufio.NewScanner(r.Body)
+	for s.Scan() {
+		var c repository.Calculation
+		if err := json.Unmarshal(s.Bytes(), &c); err != nil {
+			return nil, err
+		}
+		items = append(items, c)
+	}
+	return items, s.Err()
+}
+
+// decodeBatchResponse reads the enveloped endpoints.BatchResponse.
+func decodeBatchResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, JSONErrorDecoder(r)
+	}
+	var resp struct {
+		Data endpoints.BatchResponse `json:"data"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp.Data, err
+}
+
+// sliceIterator is a repository.Iterator over items.
+type sliceIterator struct {
+	items []repository.Calculation
+}
+
+func (it *sliceIterator) Next(context.Context) (repository.Calculation, error) {
+	if len(it.items) == 0 {
+		return repository.Calculation{}, repository.Done
+	}
+	c := it.items[0]
+	it.items = it.items[1:]
+	return c, nil
+}
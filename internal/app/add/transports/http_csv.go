@@ -0,0 +1,139 @@
+package transports
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/endpoints"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/responses"
+)
+
+const (
+	// ParamColumns is the query parameter listing, in order, the columns of
+	// the CSV tables, e.g. columns=a,b,result. All of them by default.
+	ParamColumns = "columns"
+	// ParamBOM is the query parameter asking for the CSV tables to start
+	// with the UTF-8 BOM, bom=true, for spreadsheets to read them as UTF-8.
+	ParamBOM = "bom"
+
+	csvContentType = responses.CSVContentType + "; charset=utf-8; header=present"
+)
+
+// calculationColumns are the columns of the CSV tables of calculations, the
+// JSON keys of repository.Calculation.
+var calculationColumns = []string{"id", "method", "a", "b", "result", "caller", "createdAt"}
+
+// csvTable is the CSV table a request asks for.
+type csvTable struct {
+	columns []string
+	bom     bool
+}
+
+type csvContextKey struct{}
+
+// csvToContext is an http RequestFunc putting the csvTable of the requests
+// preferring text/csv in the context.
+func csvToContext(ctx context.Context, r *http.Request) context.Context {
+	if !prefersCSV(r.Header.Get("Accept")) {
+		return ctx
+	}
+	q := r.URL.Query()
+	t := csvTable{}
+	if s := q.Get(ParamColumns); s != "" {
+		t.columns = strings.Split(s, ",")
+	}
+	t.bom, _ = strconv.ParseBool(q.Get(ParamBOM))
+	return context.WithValue(ctx, csvContextKey{}, t)
+}
+
+// prefersCSV reports whether text/csv has the highest quality of the media
+// types of accept.
+func prefersCSV(accept string) bool {
+	var csvQ, otherQ float64
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if mediaType == responses.CSVContentType {
+			csvQ = q
+		} else if q > otherQ {
+			otherQ = q
+		}
+	}
+	return csvQ > 0 && csvQ >= otherQ
+}
+
+// csvColumns returns the columns of t, checked against those of the table.
+func (t csvTable) csvColumns(all []string) ([]string, error) {
+	if t.columns == nil {
+		return all, nil
+	}
+	known := map[string]bool{}
+	for _, c := range all {
+		known[c] = true
+	}
+	for i, c := range t.columns {
+		c = strings.TrimSpace(c)
+		if !known[c] {
+			return nil, errors.Wrap(endpoints.ErrInvalidQueryParams, errors.New(ParamColumns+": unknown column "+c))
+		}
+		t.columns[i] = c
+	}
+	return t.columns, nil
+}
+
+// csvWriter returns the CSVWriter of the table the request of ctx asks for,
+// having set the headers of w, or false when it doesn't ask for CSV.
+func csvWriter(ctx context.Context, w http.ResponseWriter, name string, all []string) (*responses.CSVWriter, bool, error) {
+	t, ok := ctx.Value(csvContextKey{}).(csvTable)
+	if !ok {
+		return nil, false, nil
+	}
+	columns, err := t.csvColumns(all)
+	if err != nil {
+		return nil, true, err
+	}
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Content-Type", csvContentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.csv"`)
+	return responses.NewCSVWriter(w, columns, t.bom), true, nil
+}
+
+// encodeCSVOr returns the EncodeResponseFunc writing the history responses
+// as a CSV table of their items when the request asks for it, see
+// csvToContext, and with enc otherwise.
+func encodeCSVOr(enc httptransport.EncodeResponseFunc) httptransport.EncodeResponseFunc {
+	return func(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+		r, ok := response.(endpoints.HistoryResponse)
+		if !ok || r.Err != nil {
+			return enc(ctx, w, response)
+		}
+		cw, ok, err := csvWriter(ctx, w, "history", calculationColumns)
+		if !ok {
+			return enc(ctx, w, response)
+		}
+		if err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusOK)
+		for _, c := range r.Items {
+			if err := cw.Write(c); err != nil {
+				return err
+			}
+		}
+		return cw.Flush()
+	}
+}
@@ -0,0 +1,182 @@
+package transports
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/endpoints"
+	"github.com/cage1016/gokit-gae/internal/app/add/repository"
+	"github.com/cage1016/gokit-gae/internal/pkg/capability"
+	"github.com/cage1016/gokit-gae/internal/pkg/clock"
+	"github.com/cage1016/gokit-gae/internal/pkg/download"
+)
+
+const (
+	// ParamResumable is the query parameter asking for a resumable export,
+	// resumable=true, see ResumableExports.
+	ParamResumable = "resumable"
+	// ParamID is the query parameter of the artifact ID of the download
+	// route, whose capability token is in capability.QueryParam.
+	ParamID = "id"
+
+	// downloadPath is the route serving the resumable exports.
+	downloadPath = "/history/export/download"
+
+	// DownloadPath is the unversioned path of the route serving the
+	// resumable exports, and the artifacts kept under their ID elsewhere,
+	// e.g. by package delivery.
+	DownloadPath = "/api/add" + downloadPath
+)
+
+type resumableContextKey struct{}
+
+// resumableExport is an export request asking for a resumable export, with
+// the options of the handler serving it: the store keeping the export, its
+// maximum size and the issuer of its download tokens.
+type resumableExport struct {
+	r *http.Request
+	o *httpOptions
+}
+
+// resumableToContext is an http RequestFunc putting the export requests
+// asking for a resumable export in the context, for their Range headers,
+// when the handler serves them, see ResumableExports.
+func (o *httpOptions) resumableToContext(ctx context.Context, r *http.Request) context.Context {
+	if o.downloads == nil {
+		return ctx
+	}
+	if ok, _ := strconv.ParseBool(r.URL.Query().Get(ParamResumable)); !ok && r.Header.Get("Range") == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, resumableContextKey{}, resumableExport{r: r, o: o})
+}
+
+// resumableRequest returns the request of ctx asking for a resumable
+// export, if any.
+func resumableRequest(ctx context.Context) (resumableExport, bool) {
+	e, ok := ctx.Value(resumableContextKey{}).(resumableExport)
+	return e, ok
+}
+
+// encodeResumableExport writes the export of response, in the format the
+// request asks for, to an artifact, keeps it under a new ID, then serves it
+// with the download URL of the artifact, signed with a capability token
+// granting its download, revoked once the artifact was sent to its end. As
+// the artifact is complete before anything is sent, the errors of the
+// export are answered as errors rather than as a last row.
+func encodeResumableExport(ctx context.Context, w http.ResponseWriter, e resumableExport, response endpoints.ExportResponse) error {
+	r, o := e.r, e.o
+	it := &recordingIterator{Iterator: response.Items}
+	bw := &bufferWriter{header: http.Header{}, max: o.maxDownloadBytes}
+	if err := encodeHTTPExportResponse(context.WithValue(ctx, resumableContextKey{}, nil), bw, endpoints.ExportResponse{Items: it}); err != nil {
+		return err
+	}
+	switch {
+	case it.err != nil:
+		return it.err
+	case bw.overflow:
+		return download.ErrTooLarge
+	}
+
+	contentType := bw.header.Get("Content-Type")
+	name := "export.ndjson"
+	if strings.HasPrefix(contentType, csvContentType) {
+		name = "export.csv"
+	}
+	a := download.Artifact{Name: name, ContentType: contentType, Created: clock.System.Now(), Data: bw.body.Bytes()}
+	id := download.NewToken()
+	if err := o.downloads.Put(ctx, id, a); err != nil {
+		return err
+	}
+	loc := &url.URL{Path: strings.TrimSuffix(r.URL.Path, "/history/export") + downloadPath, RawQuery: url.Values{ParamID: {id}}.Encode()}
+	loc, err := o.downloadIssuer.SignURL(loc, id, capability.ScopeDownload, 0)
+	if err != nil {
+		return err
+	}
+	token := loc.Query().Get(capability.QueryParam)
+	w.Header().Set("Content-Location", loc.String())
+	for _, v := range bw.header["Vary"] {
+		w.Header().Add("Vary", v)
+	}
+	if download.Serve(w, r, token, a) {
+		o.revoke(ctx, id)
+	}
+	return nil
+}
+
+// downloadHandler serves the resumable exports of their ID to the requests
+// whose capability token grants their download, revoking the token once the
+// artifact was sent to its end: a client fetching ranges out of order
+// fetches the last one last.
+func (o *httpOptions) downloadHandler() http.Handler {
+	get := capability.NewVerifier(o.downloadIssuer, capability.ScopeDownload, func(request interface{}) string {
+		return request.(string)
+	})(func(ctx context.Context, request interface{}) (interface{}, error) {
+		return o.downloads.Get(ctx, request.(string))
+	})
+	tokenToContext := capability.HTTPToContext()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if o.downloads == nil {
+			httpEncodeError(r.Context(), download.ErrNotFound, w)
+			return
+		}
+		ctx := tokenToContext(r.Context(), r)
+		a, err := get(ctx, r.URL.Query().Get(ParamID))
+		if err != nil {
+			httpEncodeError(ctx, err, w)
+			return
+		}
+		token, _ := capability.FromContext(ctx)
+		if download.Serve(w, r, token, a.(download.Artifact)) {
+			o.revoke(ctx, r.URL.Query().Get(ParamID))
+		}
+	})
+}
+
+// revoke revokes the capability tokens of the download of id, which
+// completed. A failure is only logged, the tokens expiring anyway.
+func (o *httpOptions) revoke(ctx context.Context, id string) {
+	if err := o.downloadIssuer.Revoke(ctx, id); err != nil {
+		o.errorHandler.Handle(ctx, err)
+	}
+}
+
+// recordingIterator records the error ending an iteration, other than
+// repository.Done.
+type recordingIterator struct {
+	repository.Iterator
+	err error
+}
+
+func (it *recordingIterator) Next(ctx context.Context) (repository.Calculation, error) {
+	c, err := it.Iterator.Next(ctx)
+	if err != nil && err != repository.Done {
+		it.err = err
+	}
+	return c, err
+}
+
+// bufferWriter is an http.ResponseWriter keeping up to max bytes of the
+// body, failing the writes past them.
+type bufferWriter struct {
+	header   http.Header
+	body     bytes.Buffer
+	max      int
+	overflow bool
+}
+
+func (w *bufferWriter) Header() http.Header { return w.header }
+
+func (w *bufferWriter) WriteHeader(int) {}
+
+func (w *bufferWriter) Write(b []byte) (int, error) {
+	if w.body.Len()+len(b) > w.max {
+		w.overflow = true
+		return 0, download.ErrTooLarge
+	}
+	return w.body.Write(b)
+}
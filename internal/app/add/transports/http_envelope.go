@@ -0,0 +1,61 @@
+package transports
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/service"
+	"github.com/cage1016/gokit-gae/internal/pkg/responses"
+)
+
+// HeaderRequestID is the header a caller may identify its request with, to
+// find it in the meta of the envelope.
+const HeaderRequestID = "X-Request-Id"
+
+type envelopeContextKey struct{}
+
+// envelopeStart is what the envelope reports of a request.
+type envelopeStart struct {
+	begin     time.Time
+	requestID string
+}
+
+// envelopeToContext is an http RequestFunc recording the start of the
+// request and its ID: the one sent by the caller, or the trace ID. It's only
+// a RequestFunc of the handlers answering in an envelope, see Envelope.
+func envelopeToContext(ctx context.Context, r *http.Request) context.Context {
+	id := r.Header.Get(HeaderRequestID)
+	if id == "" {
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			id = sc.TraceID().String()
+		}
+	}
+	return context.WithValue(ctx, envelopeContextKey{}, envelopeStart{begin: time.Now(), requestID: id})
+}
+
+// envelopeBody returns the body of the JSON response answering response:
+// its Response when it's a Responser, in the envelope when the request was
+// put in ctx by envelopeToContext.
+func envelopeBody(ctx context.Context, response interface{}) interface{} {
+	body := response
+	if ar, ok := response.(responses.Responser); ok {
+		body = ar.Response()
+	}
+	s, ok := ctx.Value(envelopeContextKey{}).(envelopeStart)
+	if !ok {
+		return body
+	}
+	if _, ok := response.(responses.Unenveloped); ok {
+		return body
+	}
+
+	meta := &responses.Meta{Version: service.Version, RequestID: s.requestID, Duration: time.Since(s.begin).String()}
+	if res, ok := body.(responses.DataRes); ok {
+		res.Meta = meta
+		return res
+	}
+	return responses.DataRes{APIVersion: service.Version, Data: body, Meta: meta}
+}
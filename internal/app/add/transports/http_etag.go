@@ -0,0 +1,130 @@
+package transports
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/responses"
+)
+
+// ErrPreconditionFailed indicates an If-Match header matching none of the
+// current representation.
+var ErrPreconditionFailed = errors.Register(errors.KindFailedPrecondition, errors.NewCoded("ADD-009", "precondition failed"))
+
+type conditionalContextKey struct{}
+
+// conditional are the conditional headers of a request.
+type conditional struct {
+	safe        bool
+	ifNoneMatch string
+	ifMatch     string
+}
+
+// conditionalToContext is an http RequestFunc recording the conditional
+// headers of the request for encodeETag.
+func conditionalToContext(ctx context.Context, r *http.Request) context.Context {
+	return context.WithValue(ctx, conditionalContextKey{}, conditional{
+		safe:        r.Method == http.MethodGet || r.Method == http.MethodHead,
+		ifNoneMatch: r.Header.Get("If-None-Match"),
+		ifMatch:     r.Header.Get("If-Match"),
+	})
+}
+
+// encodeETag wraps enc, tagging the successful responses with an ETag and
+// honouring the conditional headers of the request: If-None-Match answers
+// 304 Not Modified to the GET and HEAD requests of a matching
+// representation, If-Match fails with ErrPreconditionFailed when it matches
+// none.
+//
+// The ETag is the one of the headers of the response when it's an
+// httptransport.Headerer supplying it, e.g. from the version of a record,
+// else a hash of the body and its negotiated representation, the envelope meta excluded as it
+// changes on every request.
+func encodeETag(enc httptransport.EncodeResponseFunc) httptransport.EncodeResponseFunc {
+	return func(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+		c, ok := ctx.Value(conditionalContextKey{}).(conditional)
+		if !ok {
+			return enc(ctx, w, response)
+		}
+		if sc, ok := response.(httptransport.StatusCoder); ok && sc.StatusCode() != http.StatusOK {
+			return enc(ctx, w, response)
+		}
+		tag, err := etag(ctx, response)
+		if err != nil {
+			return err
+		}
+
+		w.Header().Set("ETag", tag)
+		if c.ifMatch != "" && !etagMatch(c.ifMatch, tag, false) {
+			return ErrPreconditionFailed
+		}
+		if c.safe && c.ifNoneMatch != "" && etagMatch(c.ifNoneMatch, tag, true) {
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+		return enc(ctx, w, response)
+	}
+}
+
+// etag returns the ETag of response.
+func etag(ctx context.Context, response interface{}) (string, error) {
+	if h, ok := response.(httptransport.Headerer); ok {
+		if tag := h.Headers().Get("ETag"); tag != "" {
+			return tag, nil
+		}
+	}
+
+	body := response
+	if ar, ok := response.(responses.Responser); ok {
+		body = ar.Response()
+	}
+	body, err := maskBody(ctx, body)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.New()
+	// the other representations than JSON have tags of their own
+	if acceptsProtobuf(ctx) {
+		sum.Write([]byte(protobufContentType))
+	} else if c := negotiatedCodec(ctx); c.ContentType() != responses.JSONContentType {
+		sum.Write([]byte(c.ContentType()))
+	}
+	sum.Write(b)
+	return `"` + hex.EncodeToString(sum.Sum(nil)[:16]) + `"`, nil
+}
+
+// etagMatch reports whether the entity tag list of a conditional header
+// matches tag, by weak or strong comparison (RFC 7232, section 2.3.2).
+func etagMatch(list, tag string, weak bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	if weak {
+		tag = strings.TrimPrefix(tag, "W/")
+	} else if strings.HasPrefix(tag, "W/") {
+		return false
+	}
+	for _, t := range strings.Split(list, ",") {
+		t = strings.TrimSpace(t)
+		if weak {
+			t = strings.TrimPrefix(t, "W/")
+		} else if strings.HasPrefix(t, "W/") {
+			continue
+		}
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package transports
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/endpoints"
+	"github.com/cage1016/gokit-gae/internal/app/add/repository"
+	"github.com/cage1016/gokit-gae/internal/pkg/fieldmask"
+	"github.com/cage1016/gokit-gae/internal/pkg/responses"
+)
+
+const (
+	ndjsonContentType = "application/x-ndjson"
+
+	// exportFlushRows is the number of rows written between flushes, so
+	// that the client gets rows as the repository pages come.
+	exportFlushRows = 100
+)
+
+// decodeHTTPExportRequest is a transport/http.DecodeRequestFunc that decodes
+// the filter query parameters of an export request. Primarily useful in a
+// server.
+func decodeHTTPExportRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	req, err := decodeHTTPHistoryFilter(r)
+	if err != nil {
+		return nil, err
+	}
+	return endpoints.ExportRequest{Method: req.Method, Caller: req.Caller, Since: req.Since, Until: req.Until}, nil
+}
+
+// encodeHTTPExportResponse is a transport/http.EncodeResponseFunc streaming
+// the calculations of an endpoints.ExportResponse as newline-delimited JSON,
+// one row at a time as they're read from the repository, each reduced to
+// the fields asked for, or as a CSV table when the request asks for it. The
+// resumable exports are served as a whole, see ResumableExports. An error
+// before the first row is returned, for the error encoder to answer it;
+// past it, the status is sent already and the error is written as a last
+// {"error": ...} row.
+func encodeHTTPExportResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	if e, ok := resumableRequest(ctx); ok {
+		return encodeResumableExport(ctx, w, e, response.(endpoints.ExportResponse))
+	}
+	it := response.(endpoints.ExportResponse).Items
+	c, err := it.Next(ctx)
+	if err != nil && err != repository.Done {
+		return err
+	}
+
+	if cw, ok, cerr := csvWriter(ctx, w, "export", calculationColumns); ok {
+		if cerr != nil {
+			return cerr
+		}
+		return encodeCSVExport(ctx, w, cw, it, c, err)
+	}
+
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	m, _ := ctx.Value(fieldMaskContextKey{}).(fieldmask.Mask)
+	enc := json.NewEncoder(w)
+	for rows := 1; err == nil; rows++ {
+		var row interface{} = c
+		if m != nil {
+			if row, err = m.Apply(c); err != nil {
+				break
+			}
+		}
+		if err = enc.Encode(row); err != nil {
+			// the client is gone
+			return nil
+		}
+		if flusher != nil && rows%exportFlushRows == 0 {
+			flusher.Flush()
+		}
+		c, err = it.Next(ctx)
+	}
+	if err != repository.Done {
+		enc.Encode(responses.ErrorRes{Error: httpErrorItem(ctx, err)})
+	}
+	return nil
+}
+
+// encodeCSVExport streams the calculations of it as a CSV table, c and err
+// the outcome of reading the first one. Past the header row, an error ends
+// the table with a last row holding "#error" and the error message.
+func encodeCSVExport(ctx context.Context, w http.ResponseWriter, cw *responses.CSVWriter, it repository.Iterator, c repository.Calculation, err error) error {
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	for rows := 1; err == nil; rows++ {
+		if err = cw.Write(c); err != nil {
+			// the client is gone
+			return nil
+		}
+		if flusher != nil && rows%exportFlushRows == 0 {
+			cw.Flush()
+			flusher.Flush()
+		}
+		c, err = it.Next(ctx)
+	}
+	if err != repository.Done {
+		cw.WriteRecord("#error", httpErrorItem(ctx, err).Message)
+	}
+	cw.Flush()
+	return nil
+}
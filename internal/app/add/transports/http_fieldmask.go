@@ -0,0 +1,43 @@
+package transports
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/fieldmask"
+	"github.com/cage1016/gokit-gae/internal/pkg/responses"
+)
+
+// ParamFields is the query parameter listing the fields of the partial
+// response a caller asks for, see package fieldmask.
+const ParamFields = "fields"
+
+type fieldMaskContextKey struct{}
+
+// fieldMaskToContext is an http RequestFunc putting the mask of the fields
+// query parameter in the context.
+func fieldMaskToContext(ctx context.Context, r *http.Request) context.Context {
+	m := fieldmask.Parse(r.URL.Query().Get(ParamFields))
+	if m == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, fieldMaskContextKey{}, m)
+}
+
+// maskBody returns body reduced to the fields asked for, those of its data
+// when it's a responses.DataRes so the envelope is kept whole.
+func maskBody(ctx context.Context, body interface{}) (interface{}, error) {
+	m, _ := ctx.Value(fieldMaskContextKey{}).(fieldmask.Mask)
+	if m == nil {
+		return body, nil
+	}
+	if res, ok := body.(responses.DataRes); ok {
+		data, err := m.Apply(res.Data)
+		if err != nil {
+			return nil, err
+		}
+		res.Data = data
+		return res, nil
+	}
+	return m.Apply(body)
+}
@@ -0,0 +1,87 @@
+package transports
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/endpoints"
+	"github.com/cage1016/gokit-gae/internal/app/add/service"
+	"github.com/cage1016/gokit-gae/internal/pkg/locale"
+	"github.com/cage1016/gokit-gae/internal/pkg/responses"
+)
+
+type localeContextKey struct{}
+
+// localeToContext is an http RequestFunc putting the formatter negotiated by
+// the Accept-Language and X-Units headers in the context, if any.
+func localeToContext(ctx context.Context, r *http.Request) context.Context {
+	f, ok := locale.Negotiate(r)
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, localeContextKey{}, f)
+}
+
+// localizedSumResponse is a SumResponse with its result formatted.
+type localizedSumResponse struct {
+	endpoints.SumResponse
+	Display map[string]string `json:"display"`
+}
+
+func (r localizedSumResponse) Response() interface{} {
+	return responses.DataRes{APIVersion: service.Version, Data: r}
+}
+
+// localizedHistoryResponse is a HistoryResponse with the results of its sum
+// items formatted, by item ID.
+type localizedHistoryResponse struct {
+	endpoints.HistoryResponse
+	Display map[string]string `json:"display"`
+}
+
+func (r localizedHistoryResponse) Response() interface{} {
+	return responses.DataRes{APIVersion: service.Version, Data: r}
+}
+
+// encodeLocalized decorates enc to add the formatted values of the response
+// when the caller negotiated a formatter, under display, setting
+// Content-Language. The values themselves are left as they are, for the
+// callers reading them as data; concat results, being text, aren't
+// formatted.
+func encodeLocalized(enc httptransport.EncodeResponseFunc) httptransport.EncodeResponseFunc {
+	return func(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+		w.Header().Add("Vary", locale.HeaderAcceptLanguage+", "+locale.HeaderUnits)
+		f, ok := ctx.Value(localeContextKey{}).(locale.Formatter)
+		if !ok {
+			return enc(ctx, w, response)
+		}
+		switch r := response.(type) {
+		case endpoints.SumResponse:
+			if r.Err != nil {
+				break
+			}
+			response = localizedSumResponse{r, map[string]string{"res": f.FormatInt(r.Res)}}
+		case endpoints.HistoryResponse:
+			if r.Err != nil {
+				break
+			}
+			display := map[string]string{}
+			for _, c := range r.Items {
+				if c.Method != "sum" {
+					continue
+				}
+				if n, err := strconv.ParseInt(c.Result, 10, 64); err == nil {
+					display[c.ID] = f.FormatInt(n)
+				}
+			}
+			response = localizedHistoryResponse{r, display}
+		default:
+			return enc(ctx, w, response)
+		}
+		w.Header().Set(locale.HeaderContentLanguage, f.Language())
+		return enc(ctx, w, response)
+	}
+}
@@ -0,0 +1,133 @@
+package transports
+
+import (
+	"github.com/go-kit/kit/transport"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/capability"
+	"github.com/cage1016/gokit-gae/internal/pkg/download"
+)
+
+// HTTPOption sets an optional parameter of the handlers of NewHTTPHandler,
+// as httptransport.ServerOption does for the servers of its routes.
+type HTTPOption func(*httpOptions)
+
+// httpOptions are the optional parameters of a handler of NewHTTPHandler.
+type httpOptions struct {
+	pooling          bool
+	maxBodyBytes     int64
+	compression      bool
+	canonicalRoutes  map[string]bool
+	xmlRoutes        map[string]bool
+	strict           bool
+	envelope         bool
+	downloads        download.Store
+	downloadIssuer   capability.Issuer
+	maxDownloadBytes int
+	rateLimits       []RateLimit
+
+	// errorHandler handles the errors of the handler past its response,
+	// set by NewVersionedHTTPHandler.
+	errorHandler transport.ErrorHandler
+}
+
+// newHTTPOptions returns the parameters set by options.
+func newHTTPOptions(options []HTTPOption) *httpOptions {
+	o := &httpOptions{canonicalRoutes: map[string]bool{}, xmlRoutes: map[string]bool{}}
+	for _, option := range options {
+		option(o)
+	}
+	return o
+}
+
+// Pooling recycles the request and response structs of Sum and Concat,
+// which saves their allocations on high-QPS deployments. See
+// endpoints.AcquireSumRequest.
+func Pooling() HTTPOption {
+	return func(o *httpOptions) { o.pooling = true }
+}
+
+// MaxBodyBytes rejects the request bodies larger than n bytes, after
+// decompression, with 413 Request Entity Too Large. Zero or less means no
+// limit, the default.
+func MaxBodyBytes(n int64) HTTPOption {
+	return func(o *httpOptions) { o.maxBodyBytes = n }
+}
+
+// Compression gzips the responses of the clients sending Accept-Encoding:
+// gzip. Request bodies sent with Content-Encoding: gzip are always
+// decompressed.
+func Compression() HTTPOption {
+	return func(o *httpOptions) { o.compression = true }
+}
+
+// CanonicalJSON answers canonical JSON on the given API routes, such as
+// "/sum" or "/history", or on all of them when none is given, for consumers
+// signing or hashing the responses. See package canonjson.
+func CanonicalJSON(routes ...string) HTTPOption {
+	return func(o *httpOptions) { addRoutes(o.canonicalRoutes, routes) }
+}
+
+// XMLRequests accepts XML request bodies on the given API routes, such as
+// "/sum" or "/batch", or on all of them when none is given, for legacy
+// callers that only speak XML. The XML requests are answered in XML, errors
+// included, unless their Accept header asks otherwise.
+//
+// The XML of a request mirrors the XML responses of package responses: a
+// root element of any name holding an element per field, named after its
+// JSON key, the case, hyphens and underscores aside, and a child element of
+// any name per array element:
+//
+//	<SumRequest><A>1</A><B>2</B></SumRequest>
+//	<batch><operations><item><sum><a>1</a><b>2</b></sum></item></operations></batch>
+//
+// The elements of no field are ignored, as JSON ignores unknown fields.
+func XMLRequests(routes ...string) HTTPOption {
+	return func(o *httpOptions) { addRoutes(o.xmlRoutes, routes) }
+}
+
+// StrictDecoding rejects the JSON request bodies with fields the requests
+// don't have, with ErrUnknownField, or number literals longer than any they
+// take, with ErrNumberTooLong, rather than ignoring the former and spending
+// time on the latter.
+func StrictDecoding() HTTPOption {
+	return func(o *httpOptions) { o.strict = true }
+}
+
+// Envelope answers every JSON response in a responses.DataRes envelope whose
+// meta carries the request ID, the duration of the request and the version
+// of the service. Responses implementing responses.Unenveloped are written
+// as before.
+func Envelope() HTTPOption {
+	return func(o *httpOptions) { o.envelope = true }
+}
+
+// ResumableExports answers the export requests asking for it with
+// resumable=true, or sending a Range header, with an artifact of at most
+// maxBytes kept in store: served with its Digest and Content-MD5, and with
+// Range support, see package download. Its download URL, in
+// Content-Location, carries a capability token of issuer granting the
+// download of the artifact, which resumes it on the download route, e.g.
+//
+//	GET /api/add/history/export/download?id=...&access_token=...
+//	Range: bytes=1048576-
+//	If-Range: "<ETag>"
+func ResumableExports(store download.Store, issuer capability.Issuer, maxBytes int) HTTPOption {
+	return func(o *httpOptions) { o.downloads, o.downloadIssuer, o.maxDownloadBytes = store, issuer, maxBytes }
+}
+
+// RateLimits advertises the quotas limits in the capabilities. The quotas
+// are enforced elsewhere, e.g. by privileged.Middleware.
+func RateLimits(limits ...RateLimit) HTTPOption {
+	return func(o *httpOptions) { o.rateLimits = append(o.rateLimits, limits...) }
+}
+
+// addRoutes adds routes to set, the empty path, standing for every route,
+// when there's none.
+func addRoutes(set map[string]bool, routes []string) {
+	if len(routes) == 0 {
+		routes = []string{""}
+	}
+	for _, r := range routes {
+		set[r] = true
+	}
+}
@@ -0,0 +1,29 @@
+package transports
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/pagination"
+)
+
+// encodePageLinks wraps enc, setting the Link header of the responses
+// implementing pagination.Paged to their first and next pages. The URL of
+// the request is put in the context by httptransport.PopulateRequestContext.
+func encodePageLinks(enc httptransport.EncodeResponseFunc) httptransport.EncodeResponseFunc {
+	return func(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+		if p, ok := response.(pagination.Paged); ok {
+			if uri, ok := ctx.Value(httptransport.ContextKeyRequestURI).(string); ok {
+				if u, err := url.ParseRequestURI(uri); err == nil {
+					if l := p.Link(u); l != "" {
+						w.Header().Set("Link", l)
+					}
+				}
+			}
+		}
+		return enc(ctx, w, response)
+	}
+}
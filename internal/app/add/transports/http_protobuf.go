@@ -0,0 +1,92 @@
+package transports
+
+import (
+	"context"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/golang/protobuf/proto"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+const (
+	protobufContentType string = "application/x-protobuf"
+)
+
+// ErrMalformedEntity indicates a request body that could not be decoded.
+var ErrMalformedEntity = errors.Register(errors.KindInvalidArgument, errors.NewCoded("ADD-003", "malformed entity specification"))
+
+// isProtobufRequest reports whether the request body is protobuf encoded.
+func isProtobufRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == protobufContentType
+}
+
+// acceptsProtobuf reports whether the caller asked for a protobuf response.
+// The Accept header is put in the context by httptransport.PopulateRequestContext.
+func acceptsProtobuf(ctx context.Context) bool {
+	accept, _ := ctx.Value(httptransport.ContextKeyRequestAccept).(string)
+	for _, part := range strings.Split(accept, ",") {
+		if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part)); err == nil && mediaType == protobufContentType {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeProtobufRequest reads a protobuf body into msg and converts it to a
+// user-domain request with the gRPC decoder, so both transports share one mapping.
+func decodeProtobufRequest(ctx context.Context, r *http.Request, msg proto.Message, dec grpctransport.DecodeRequestFunc) (interface{}, error) {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := proto.Unmarshal(b, msg); err != nil {
+		return nil, errors.Wrap(ErrMalformedEntity, err)
+	}
+	return dec(ctx, msg)
+}
+
+// encodeNegotiatedResponse returns an EncodeResponseFunc that writes protobuf
+// when the caller accepts it, using the gRPC encoder for the conversion, and
+// falls back to the JSON encoder otherwise.
+func encodeNegotiatedResponse(enc grpctransport.EncodeResponseFunc, encodeJSON httptransport.EncodeResponseFunc) httptransport.EncodeResponseFunc {
+	return func(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+		if !acceptsProtobuf(ctx) {
+			return encodeJSON(ctx, w, response)
+		}
+
+		msg, err := enc(ctx, response)
+		if err != nil {
+			return err
+		}
+		b, err := proto.Marshal(msg.(proto.Message))
+		if err != nil {
+			return err
+		}
+
+		w.Header().Set("Content-Type", protobufContentType)
+		if headerer, ok := response.(httptransport.Headerer); ok {
+			for k, values := range headerer.Headers() {
+				for _, v := range values {
+					w.Header().Add(k, v)
+				}
+			}
+		}
+		code := http.StatusOK
+		if sc, ok := response.(httptransport.StatusCoder); ok {
+			code = sc.StatusCode()
+		}
+		w.WriteHeader(code)
+		if code == http.StatusNoContent {
+			return nil
+		}
+		_, err = w.Write(b)
+		return err
+	}
+}
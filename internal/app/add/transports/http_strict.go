@@ -0,0 +1,111 @@
+package transports
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// maxNumberLen bounds the length of the number literals of strict request
+// bodies, the 20 digits of an int64 and its sign with room for notations
+// such as 1.5e3.
+const maxNumberLen = 32
+
+var (
+	// ErrUnknownField indicates a field of a strict request body the request
+	// doesn't have.
+	ErrUnknownField = errors.Register(errors.KindInvalidArgument, errors.NewCoded("ADD-011", "unknown field"))
+
+	// ErrNumberTooLong indicates a number literal of a strict request body
+	// longer than any the request takes.
+	ErrNumberTooLong = errors.Register(errors.KindInvalidArgument, errors.NewCoded("ADD-012", "number too long"))
+)
+
+type strictContextKey struct{}
+
+// decodeStrict returns dec decoding the JSON request bodies strictly when
+// the handler does, see StrictDecoding.
+func (o *httpOptions) decodeStrict(dec httptransport.DecodeRequestFunc) httptransport.DecodeRequestFunc {
+	if !o.strict {
+		return dec
+	}
+	return func(ctx context.Context, r *http.Request) (interface{}, error) {
+		return dec(context.WithValue(ctx, strictContextKey{}, true), r)
+	}
+}
+
+// decodeJSONBody decodes the JSON of body into v, strictly when the decoder
+// of ctx is, see decodeStrict.
+func decodeJSONBody(ctx context.Context, body io.Reader, v interface{}) error {
+	if strict, _ := ctx.Value(strictContextKey{}).(bool); !strict {
+		return json.NewDecoder(body).Decode(v)
+	}
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if err := checkStrictJSON(b, reflect.TypeOf(v)); err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// checkStrictJSON checks the JSON of b against the type t it's decoded to,
+// its keys matched case-insensitively as encoding/json does. It also applies
+// to the types decoding themselves, such as
+// endpoints.SumRequest, that json.Decoder.DisallowUnknownFields doesn't
+// reach. Syntax errors are left to the decoding.
+func checkStrictJSON(b []byte, t reflect.Type) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil
+	}
+	return checkStrictValue("", v, t)
+}
+
+func checkStrictValue(path string, v interface{}, t reflect.Type) error {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch v := v.(type) {
+	case json.Number:
+		if len(v) > maxNumberLen {
+			return errors.Wrap(ErrNumberTooLong, errors.New(strings.TrimPrefix(path, ".")))
+		}
+	case []interface{}:
+		var et reflect.Type
+		if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+			et = t.Elem()
+		}
+		for _, e := range v {
+			if err := checkStrictValue(path+"[]", e, et); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		for k, e := range v {
+			var ft reflect.Type
+			if t != nil && t.Kind() == reflect.Struct {
+				var ok bool
+				if _, ft, ok = jsonField(t, k, strings.ToLower); !ok {
+					return errors.Wrap(ErrUnknownField, errors.New(strings.TrimPrefix(path+"."+k, ".")))
+				}
+			}
+			if err := checkStrictValue(path+"."+k, e, ft); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package transports
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/endpoints"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/responses"
+)
+
+// The types of the requests decoded from XML, see decodeXMLOr.
+var (
+	sumRequestType    = reflect.TypeOf(endpoints.SumRequest{})
+	concatRequestType = reflect.TypeOf(endpoints.ConcatRequest{})
+	batchRequestType  = reflect.TypeOf(endpoints.BatchRequest{})
+)
+
+type xmlContextKey struct{}
+
+// xmlToContext returns the http RequestFunc of the API route path marking
+// its XML requests, when the route accepts them, see XMLRequests, and making
+// their responses XML when the Accept header doesn't ask for another type.
+func (o *httpOptions) xmlToContext(path string) httptransport.RequestFunc {
+	accepted := o.xmlRoutes[""] || o.xmlRoutes[path]
+	return func(ctx context.Context, r *http.Request) context.Context {
+		if !accepted {
+			return ctx
+		}
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || (mediaType != responses.XMLContentType && mediaType != "text/xml") {
+			return ctx
+		}
+		ctx = context.WithValue(ctx, xmlContextKey{}, true)
+		if accept := strings.TrimSpace(r.Header.Get("Accept")); accept == "" || accept == "*/*" {
+			ctx = context.WithValue(ctx, httptransport.ContextKeyRequestAccept, responses.XMLContentType)
+		}
+		return ctx
+	}
+}
+
+// isXMLRequest reports whether the request of ctx is an XML request of a
+// route accepting them.
+func isXMLRequest(ctx context.Context) bool {
+	ok, _ := ctx.Value(xmlContextKey{}).(bool)
+	return ok
+}
+
+// decodeXMLOr returns the DecodeRequestFunc decoding the XML requests, see
+// isXMLRequest, into a request of type t, the others with dec.
+func decodeXMLOr(t reflect.Type, dec httptransport.DecodeRequestFunc) httptransport.DecodeRequestFunc {
+	return func(ctx context.Context, r *http.Request) (interface{}, error) {
+		if !isXMLRequest(ctx) {
+			return dec(ctx, r)
+		}
+		req := reflect.New(t)
+		if err := decodeXMLRequest(r.Body, req.Interface()); err != nil {
+			return nil, err
+		}
+		return req.Elem().Interface(), nil
+	}
+}
+
+// decodeXMLRequest decodes the XML of body into req, a pointer to a request
+// struct. The XML is mapped to the JSON of the struct, decoded then as the
+// JSON requests are, so both share their validation.
+func decodeXMLRequest(body io.Reader, req interface{}) error {
+	dec := xml.NewDecoder(body)
+	var root xml.StartElement
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return errors.Wrap(ErrMalformedEntity, err)
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			root = se
+			break
+		}
+	}
+	v, err := xmlValue(dec, root, reflect.TypeOf(req).Elem())
+	if err != nil {
+		return errors.Wrap(ErrMalformedEntity, err)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(ErrMalformedEntity, err)
+	}
+	return json.Unmarshal(b, req)
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// xmlValue reads the element start into the JSON value of a field of type
+// t: an object of its child elements for structs, an array of them for
+// slices, its text for the other types.
+func xmlValue(dec *xml.Decoder, start xml.StartElement, t reflect.Type) (interface{}, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t.Kind() == reflect.Struct && t != timeType:
+		obj := map[string]interface{}{}
+		err := xmlChildren(dec, func(child xml.StartElement) error {
+			key, ft, ok := jsonField(t, child.Name.Local, xmlName)
+			if !ok {
+				return dec.Skip()
+			}
+			v, err := xmlValue(dec, child, ft)
+			obj[key] = v
+			return err
+		})
+		return obj, err
+	case t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8:
+		arr := []interface{}{}
+		err := xmlChildren(dec, func(child xml.StartElement) error {
+			v, err := xmlValue(dec, child, t.Elem())
+			arr = append(arr, v)
+			return err
+		})
+		return arr, err
+	}
+
+	var text strings.Builder
+	err := xmlChildren(dec, func(child xml.StartElement) error {
+		return fmt.Errorf("<%s> has no fields, got <%s>", start.Name.Local, child.Name.Local)
+	}, func(cd xml.CharData) {
+		text.Write(cd)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s := text.String()
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return json.Number(strings.TrimSpace(s)), nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("<%s>: %v", start.Name.Local, err)
+		}
+		return b, nil
+	}
+	if t == timeType {
+		return strings.TrimSpace(s), nil
+	}
+	return s, nil
+}
+
+// xmlChildren reads the content of an element up to its end, calling child
+// with its child elements, which must read them up to their end, and text
+// with its character data, if given.
+func xmlChildren(dec *xml.Decoder, child func(xml.StartElement) error, text ...func(xml.CharData)) error {
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if err := child(tok); err != nil {
+				return err
+			}
+		case xml.CharData:
+			for _, f := range text {
+				f(tok)
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// xmlName normalizes the names of the elements and the JSON keys they map
+// to, ignoring the case, hyphens and underscores.
+func xmlName(s string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", "_", "").Replace(s))
+}
+
+// jsonField returns the JSON key and the type of the field of the struct
+// type t whose key is name, both normalized with norm.
+func jsonField(t reflect.Type, name string, norm func(string) string) (string, reflect.Type, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		key := f.Name
+		if tag := f.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if k := strings.Split(tag, ",")[0]; k != "" {
+				key = k
+			}
+		}
+		if norm(key) == norm(name) {
+			return key, f.Type, true
+		}
+	}
+	return "", nil, false
+}
@@ -0,0 +1,227 @@
+package transports
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/log"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/endpoints"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/privileged"
+	"github.com/cage1016/gokit-gae/internal/pkg/tenant"
+)
+
+const (
+	// jsonrpcMaxBatch bounds the number of calls of a batch.
+	jsonrpcMaxBatch int = 64
+	// jsonrpcServerError is the code of the errors with no JSON-RPC
+	// counterpart, in the range reserved to implementation-defined errors.
+	jsonrpcServerError int = -32000
+)
+
+type jsonrpcContextKey int
+
+const jsonrpcIDContextKey jsonrpcContextKey = iota
+
+// jsonrpcErrorResponse is the response of a failed call. Unlike
+// jsonrpc.Response, it echoes the id of the call, null when unknown.
+type jsonrpcErrorResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Error   jsonrpc.Error   `json:"error"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type jsonrpcHandler struct {
+	server *jsonrpc.Server
+}
+
+// NewJSONRPCHandler returns a handler serving the add.sum and add.concat
+// methods over JSON-RPC 2.0, batches included. Errors are answered as
+// JSON-RPC error objects, their data being the error body of the HTTP
+// transport.
+func NewJSONRPCHandler(endpoints endpoints.Endpoints, logger log.Logger) http.Handler {
+	ecm := jsonrpc.EndpointCodecMap{
+		"add.sum": {
+			Endpoint: endpoints.SumEndpoint,
+			Decode:   decodeJSONRPCSumRequest,
+			Encode:   encodeJSONRPCResponse,
+		},
+		"add.concat": {
+			Endpoint: endpoints.ConcatEndpoint,
+			Decode:   decodeJSONRPCConcatRequest,
+			Encode:   encodeJSONRPCResponse,
+		},
+	}
+	return &jsonrpcHandler{server: jsonrpc.NewServer(ecm,
+		jsonrpc.ServerErrorEncoder(jsonrpcEncodeError),
+		jsonrpc.ServerErrorLogger(logger),
+		jsonrpc.ServerBefore(httptransport.PopulateRequestContext, privileged.HTTPToContext(), tenant.HTTPToContext(), kitjwt.HTTPToContext()),
+	)}
+}
+
+func (h *jsonrpcHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.server.ServeHTTP(w, r)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		jsonrpcEncodeError(r.Context(), err, w)
+		return
+	}
+
+	if b := bytes.TrimSpace(body); len(b) == 0 || b[0] != '[' {
+		rec, notification := h.call(r, body)
+		if notification {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		rec.writeTo(w)
+		return
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(body, &batch); err != nil {
+		jsonrpcEncodeError(r.Context(), jsonrpc.Error{Code: jsonrpc.ParseError, Message: err.Error()}, w)
+		return
+	}
+	if len(batch) == 0 || len(batch) > jsonrpcMaxBatch {
+		jsonrpcEncodeError(r.Context(), jsonrpc.Error{Code: jsonrpc.InvalidRequestError, Message: jsonrpc.ErrorMessage(jsonrpc.InvalidRequestError)}, w)
+		return
+	}
+	results := make([]json.RawMessage, 0, len(batch))
+	for _, call := range batch {
+		rec, notification := h.call(r, call)
+		if !notification {
+			results = append(results, bytes.TrimSpace(rec.body.Bytes()))
+		}
+	}
+	if len(results) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", jsonrpc.ContentType)
+	json.NewEncoder(w).Encode(results)
+}
+
+// call serves a single call of r, and reports whether it's a notification,
+// whose response must not be sent.
+func (h *jsonrpcHandler) call(r *http.Request, call json.RawMessage) (rec *jsonrpcRecorder, notification bool) {
+	rec = &jsonrpcRecorder{header: http.Header{}, code: http.StatusOK}
+	var req struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(call, &req); err != nil {
+		code := jsonrpc.InvalidRequestError
+		if _, ok := err.(*json.SyntaxError); ok {
+			code = jsonrpc.ParseError
+		}
+		jsonrpcEncodeError(r.Context(), jsonrpc.Error{Code: code, Message: err.Error()}, rec)
+		return rec, false
+	}
+
+	ctx := r.Context()
+	if req.ID != nil {
+		ctx = context.WithValue(ctx, jsonrpcIDContextKey, req.ID)
+	}
+	cr := r.WithContext(ctx)
+	cr.Body = ioutil.NopCloser(bytes.NewReader(call))
+	h.server.ServeHTTP(rec, cr)
+	return rec, req.ID == nil
+}
+
+// jsonrpcRecorder buffers the response of a call, to be sent alone or within
+// the response of a batch.
+type jsonrpcRecorder struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (rec *jsonrpcRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *jsonrpcRecorder) WriteHeader(code int) {
+	rec.code = code
+}
+
+func (rec *jsonrpcRecorder) Write(b []byte) (int, error) {
+	return rec.body.Write(b)
+}
+
+func (rec *jsonrpcRecorder) writeTo(w http.ResponseWriter) {
+	for k, v := range rec.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(rec.code)
+	w.Write(rec.body.Bytes())
+}
+
+// decodeJSONRPCSumRequest is a transport/http/jsonrpc.DecodeRequestFunc that
+// decodes the params of an add.sum call. Primarily useful in a server.
+func decodeJSONRPCSumRequest(_ context.Context, params json.RawMessage) (interface{}, error) {
+	var req endpoints.SumRequest
+	if err := json.Unmarshal(params, &req); err != nil {
+		return nil, errors.Wrap(ErrMalformedEntity, err)
+	}
+	return req, nil
+}
+
+// decodeJSONRPCConcatRequest is a transport/http/jsonrpc.DecodeRequestFunc
+// that decodes the params of an add.concat call. Primarily useful in a
+// server.
+func decodeJSONRPCConcatRequest(_ context.Context, params json.RawMessage) (interface{}, error) {
+	var req endpoints.ConcatRequest
+	if err := json.Unmarshal(params, &req); err != nil {
+		return nil, errors.Wrap(ErrMalformedEntity, err)
+	}
+	return req, nil
+}
+
+// encodeJSONRPCResponse is a transport/http/jsonrpc.EncodeResponseFunc that
+// JSON-encodes the response as the result of the call. Primarily useful in a
+// server.
+func encodeJSONRPCResponse(_ context.Context, response interface{}) (json.RawMessage, error) {
+	return json.Marshal(response)
+}
+
+// jsonrpcEncodeError answers the call of ctx with the JSON-RPC error object
+// of err.
+func jsonrpcEncodeError(ctx context.Context, err error, w http.ResponseWriter) {
+	id, _ := ctx.Value(jsonrpcIDContextKey).(json.RawMessage)
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	w.Header().Set("Content-Type", jsonrpc.ContentType)
+	json.NewEncoder(w).Encode(jsonrpcErrorResponse{JSONRPC: jsonrpc.Version, Error: jsonrpcError(ctx, err), ID: id})
+}
+
+// jsonrpcError maps err to a JSON-RPC error object: the protocol errors keep
+// their code, invalid arguments are invalid params, unknown methods are
+// methods not found, internal errors are internal, the others are server
+// errors. The data is the error body of the HTTP transport.
+func jsonrpcError(ctx context.Context, err error) jsonrpc.Error {
+	if coder, ok := err.(jsonrpc.ErrorCoder); ok {
+		return jsonrpc.Error{Code: coder.ErrorCode(), Message: err.Error()}
+	}
+
+	item := httpErrorItem(ctx, err)
+	e := jsonrpc.Error{Code: jsonrpcServerError, Message: item.Message, Data: item}
+	switch {
+	case errors.Contains(errors.Cast(err), ErrUnknownMethod):
+		e.Code = jsonrpc.MethodNotFoundError
+	case errors.KindOf(err) == errors.KindInvalidArgument:
+		e.Code = jsonrpc.InvalidParamsError
+	case errors.KindOf(err) == errors.KindInternal, errors.KindOf(err) == errors.KindUnknown:
+		e.Code = jsonrpc.InternalError
+	}
+	return e
+}
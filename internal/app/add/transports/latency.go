@@ -0,0 +1,67 @@
+package transports
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/middlewares"
+)
+
+// httpLatencyOptions track the latency of a request by layer, see
+// middlewares.LatencyMiddleware.
+var httpLatencyOptions = []httptransport.ServerOption{
+	httptransport.ServerBefore(func(ctx context.Context, _ *http.Request) context.Context {
+		return middlewares.StartLatency(ctx)
+	}),
+	httptransport.ServerFinalizer(func(ctx context.Context, _ int, _ *http.Request) {
+		middlewares.RecordLatency(ctx)
+	}),
+}
+
+// grpcLatencyOptions track the latency of a request by layer, see
+// middlewares.LatencyMiddleware.
+var grpcLatencyOptions = []grpctransport.ServerOption{
+	grpctransport.ServerBefore(func(ctx context.Context, _ metadata.MD) context.Context {
+		return middlewares.StartLatency(ctx)
+	}),
+	grpctransport.ServerFinalizer(func(ctx context.Context, _ error) {
+		middlewares.RecordLatency(ctx)
+	}),
+}
+
+// timeHTTPDecode attributes the time spent in dec to serialization.
+func timeHTTPDecode(dec httptransport.DecodeRequestFunc) httptransport.DecodeRequestFunc {
+	return func(ctx context.Context, r *http.Request) (interface{}, error) {
+		defer middlewares.ObserveSerialization(ctx, time.Now())
+		return dec(ctx, r)
+	}
+}
+
+// timeHTTPEncode attributes the time spent in enc to serialization.
+func timeHTTPEncode(enc httptransport.EncodeResponseFunc) httptransport.EncodeResponseFunc {
+	return func(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+		defer middlewares.ObserveSerialization(ctx, time.Now())
+		return enc(ctx, w, response)
+	}
+}
+
+// timeGRPCDecode attributes the time spent in dec to serialization.
+func timeGRPCDecode(dec grpctransport.DecodeRequestFunc) grpctransport.DecodeRequestFunc {
+	return func(ctx context.Context, req interface{}) (interface{}, error) {
+		defer middlewares.ObserveSerialization(ctx, time.Now())
+		return dec(ctx, req)
+	}
+}
+
+// timeGRPCEncode attributes the time spent in enc to serialization.
+func timeGRPCEncode(enc grpctransport.EncodeResponseFunc) grpctransport.EncodeResponseFunc {
+	return func(ctx context.Context, response interface{}) (interface{}, error) {
+		defer middlewares.ObserveSerialization(ctx, time.Now())
+		return enc(ctx, response)
+	}
+}
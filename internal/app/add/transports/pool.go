@@ -0,0 +1,47 @@
+package transports
+
+import (
+	"context"
+	"net/http"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/endpoints"
+	pb "github.com/cage1016/gokit-gae/pb/add"
+)
+
+// decodePooledHTTPSumRequest is decodeHTTPSumRequest decoding JSON into a
+// pooled request.
+func decodePooledHTTPSumRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	if isProtobufRequest(r) {
+		return decodeProtobufRequest(ctx, r, &pb.SumRequest{}, decodeGRPCSumRequest)
+	}
+	req := endpoints.AcquireSumRequest()
+	if err := decodeJSONBody(ctx, r.Body, req); err != nil {
+		endpoints.Release(req)
+		return nil, err
+	}
+	return req, nil
+}
+
+// decodePooledHTTPConcatRequest is decodeHTTPConcatRequest decoding JSON into
+// a pooled request.
+func decodePooledHTTPConcatRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	if isProtobufRequest(r) {
+		return decodeProtobufRequest(ctx, r, &pb.ConcatRequest{}, decodeGRPCConcatRequest)
+	}
+	req := endpoints.AcquireConcatRequest()
+	if err := decodeJSONBody(ctx, r.Body, req); err != nil {
+		endpoints.Release(req)
+		return nil, err
+	}
+	return req, nil
+}
+
+// releaseAfter releases the pooled response once enc wrote it.
+func releaseAfter(enc httptransport.EncodeResponseFunc) httptransport.EncodeResponseFunc {
+	return func(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+		defer endpoints.Release(response)
+		return enc(ctx, w, response)
+	}
+}
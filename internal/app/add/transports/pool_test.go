@@ -0,0 +1,130 @@
+package transports_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/endpoints"
+	"github.com/cage1016/gokit-gae/internal/app/add/service/mocks"
+	"github.com/cage1016/gokit-gae/internal/app/add/transports"
+)
+
+// newPoolHandler returns the HTTP handler of a service answering at once.
+func newPoolHandler(options ...transports.HTTPOption) http.Handler {
+	svc := &mocks.Service{
+		SumFunc:    func(_ context.Context, a, b int64) (int64, error) { return a + b, nil },
+		ConcatFunc: func(_ context.Context, a, b string) (string, error) { return a + b, nil },
+	}
+	ep := endpoints.Endpoints{
+		SumEndpoint:    endpoints.MakeSumEndpoint(svc),
+		ConcatEndpoint: endpoints.MakeConcatEndpoint(svc),
+	}
+	return transports.NewHTTPHandler(ep, log.NewNopLogger(), options...)
+}
+
+func post(h http.Handler, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// TestPoolingConcurrent checks no pooled request or response is shared
+// between concurrent calls; run it with -race.
+func TestPoolingConcurrent(t *testing.T) {
+	h := newPoolHandler(transports.Pooling())
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				a, b := int64(g*1000+i), int64(i)
+				w := post(h, "/api/v1/add/sum", fmt.Sprintf(`{"a":%d,"b":%d}`, a, b))
+				var sum struct{ Data struct{ Res int64 } }
+				if err := json.Unmarshal(w.Body.Bytes(), &sum); err != nil || w.Code != http.StatusOK || sum.Data.Res != a+b {
+					t.Errorf("sum(%d, %d) = %d %s, want %d", a, b, w.Code, w.Body, a+b)
+					return
+				}
+
+				x, y := fmt.Sprint("g", g), fmt.Sprint("i", i)
+				w = post(h, "/api/v1/add/concat", fmt.Sprintf(`{"a":%q,"b":%q}`, x, y))
+				var concat struct{ Data struct{ Res string } }
+				if err := json.Unmarshal(w.Body.Bytes(), &concat); err != nil || w.Code != http.StatusOK || concat.Data.Res != x+y {
+					t.Errorf("concat(%q, %q) = %d %s, want %q", x, y, w.Code, w.Body, x+y)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+// TestPoolingErrors checks the pooled requests failing to decode or to
+// validate don't leak into the next calls.
+func TestPoolingErrors(t *testing.T) {
+	h := newPoolHandler(transports.Pooling())
+	cases := []struct {
+		name   string
+		body   string
+		status int
+		res    int64
+	}{
+		{"malformed", `{"a":`, http.StatusBadRequest, 0},
+		{"valid", `{"a":1,"b":2}`, http.StatusOK, 3},
+		{"missing field", `{"a":5}`, http.StatusOK, 5},
+		{"valid again", `{"b":7}`, http.StatusOK, 7},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := post(h, "/api/v1/add/sum", tc.body)
+			if w.Code != tc.status {
+				t.Fatalf("status = %d %s, want %d", w.Code, w.Body, tc.status)
+			}
+			if tc.status != http.StatusOK {
+				return
+			}
+			var sum struct{ Data struct{ Res int64 } }
+			if err := json.Unmarshal(w.Body.Bytes(), &sum); err != nil {
+				t.Fatal(err)
+			}
+			if sum.Data.Res != tc.res {
+				t.Errorf("res = %d, want %d", sum.Data.Res, tc.res)
+			}
+		})
+	}
+}
+
+func BenchmarkSum(b *testing.B) {
+	benches := []struct {
+		name    string
+		options []transports.HTTPOption
+	}{
+		{"plain", nil},
+		{"pooled", []transports.HTTPOption{transports.Pooling()}},
+	}
+	for _, bench := range benches {
+		b.Run(bench.name, func(b *testing.B) {
+			h := newPoolHandler(bench.options...)
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if w := post(h, "/api/v1/add/sum", `{"a":1,"b":2}`); w.Code != http.StatusOK {
+						b.Errorf("status = %d %s", w.Code, w.Body)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package transports
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/endpoints"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/gcp"
+	"github.com/cage1016/gokit-gae/internal/pkg/pubsub"
+	"github.com/cage1016/gokit-gae/internal/pkg/tenant"
+)
+
+// PubSubMethodAttribute is the attribute of the Pub/Sub messages naming
+// the method they call, sum or concat, their data being the JSON request.
+const PubSubMethodAttribute = "method"
+
+// pubsubMethod decodes the request of a message and calls its endpoint.
+type pubsubMethod struct {
+	endpoint endpoint.Endpoint
+	decode   func(data []byte) (interface{}, error)
+}
+
+// ServePubSub runs the Sum and Concat requests of the messages r receives
+// until ctx is done. There's no one to answer: the messages are
+// acknowledged once handled, and the ones failing on the client's side,
+// which would fail again, are logged and acknowledged too; the ones failing
+// on the server's side are delivered again, along with the ones of their
+// ordering key received after them, to keep the key in order.
+func ServePubSub(ctx context.Context, r *pubsub.Receiver, endpoints endpoints.Endpoints, logger log.Logger) error {
+	methods := map[string]pubsubMethod{
+		"sum":    {endpoints.SumEndpoint, decodePubSubSumRequest},
+		"concat": {endpoints.ConcatEndpoint, decodePubSubConcatRequest},
+	}
+	return r.Receive(ctx, func(ctx context.Context, m gcp.Message) error {
+		method, ok := methods[m.Attributes[PubSubMethodAttribute]]
+		if !ok {
+			level.Warn(logger).Log("protocol", "PubSub", "message", m.ID, "err", ErrUnknownMethod)
+			return nil
+		}
+		if id := m.Attributes[tenant.MetadataKey]; id != "" {
+			ctx = tenant.HeaderToContext(ctx, id)
+		}
+		request, err := method.decode(m.Data)
+		if err == nil {
+			_, err = method.endpoint(ctx, request)
+		}
+		if err == nil {
+			return nil
+		}
+		if errors.HTTPStatus(errors.KindOf(err)) >= http.StatusInternalServerError {
+			level.Error(logger).Log("protocol", "PubSub", "message", m.ID, "err", err)
+			return err
+		}
+		level.Warn(logger).Log("protocol", "PubSub", "message", m.ID, "err", err)
+		return nil
+	})
+}
+
+// decodePubSubSumRequest decodes a JSON-encoded Sum request from the data
+// of a message.
+func decodePubSubSumRequest(data []byte) (interface{}, error) {
+	var req endpoints.SumRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, errors.Wrap(ErrMalformedEntity, err)
+	}
+	return req, nil
+}
+
+// decodePubSubConcatRequest decodes a JSON-encoded Concat request from the
+// data of a message.
+func decodePubSubConcatRequest(data []byte) (interface{}, error) {
+	var req endpoints.ConcatRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, errors.Wrap(ErrMalformedEntity, err)
+	}
+	return req, nil
+}
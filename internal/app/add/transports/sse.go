@@ -0,0 +1,159 @@
+package transports
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	httptransport "github.com/go-kit/kit/transport/http"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/progress"
+	"github.com/cage1016/gokit-gae/internal/pkg/responses"
+)
+
+const (
+	eventStreamContentType string = "text/event-stream"
+
+	// sseHeartbeat is well below the idle timeout of the GAE front end, so
+	// quiet computations don't get their connection dropped.
+	sseHeartbeat = 15 * time.Second
+	// sseMaxDuration stays under the 10 minute GAE request deadline so the
+	// client gets an error event rather than a cut connection.
+	sseMaxDuration = 9*time.Minute + 30*time.Second
+)
+
+type sseServer struct {
+	e      endpoint.Endpoint
+	dec    httptransport.DecodeRequestFunc
+	before []httptransport.RequestFunc
+	logger log.Logger
+}
+
+// NewSSEServer returns a handler that runs e and streams its progress as
+// Server-Sent Events: "progress" events for every progress.Report, such as
+// the ones of service.HistoryMiddleware on Sum and Concat, then a single
+// "result" or "error" event. Heartbeat comments keep the connection alive in
+// between.
+func NewSSEServer(e endpoint.Endpoint, dec httptransport.DecodeRequestFunc, logger log.Logger, before ...httptransport.RequestFunc) http.Handler {
+	return &sseServer{e: e, dec: dec, before: before, logger: logger}
+}
+
+func (s *sseServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpEncodeError(r.Context(), fmt.Errorf("streaming unsupported"), w)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), sseMaxDuration)
+	defer cancel()
+	for _, f := range s.before {
+		ctx = f(ctx, r)
+	}
+
+	request, err := s.dec(ctx, r)
+	if err != nil {
+		httpEncodeError(ctx, err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", eventStreamContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	type result struct {
+		response interface{}
+		err      error
+	}
+	events := make(chan progress.Event, 16)
+	done := make(chan result, 1)
+	go func() {
+		ctx := progress.WithReporter(ctx, func(ev progress.Event) {
+			select {
+			case events <- ev:
+			default: // drop progress rather than stall the computation
+			}
+		})
+		response, err := s.e(ctx, request)
+		done <- result{response, err}
+	}()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case ev := <-events:
+			s.write(w, "progress", ev)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case res := <-done:
+			s.drain(w, events)
+			if res.err != nil {
+				s.write(w, "error", responses.ErrorRes{Error: httpErrorItem(ctx, res.err)})
+			} else if ar, ok := res.response.(responses.Responser); ok {
+				s.write(w, "result", ar.Response())
+			} else {
+				s.write(w, "result", res.response)
+			}
+			flusher.Flush()
+			return
+		case <-ctx.Done():
+			s.write(w, "error", responses.ErrorRes{Error: httpErrorItem(ctx, ctx.Err())})
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// drain writes the progress events reported before the result.
+func (s *sseServer) drain(w http.ResponseWriter, events <-chan progress.Event) {
+	for {
+		select {
+		case ev := <-events:
+			s.write(w, "progress", ev)
+		default:
+			return
+		}
+	}
+}
+
+func (s *sseServer) write(w http.ResponseWriter, event string, data interface{}) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		level.Error(s.logger).Log("protocol", "SSE", "event", event, "err", err)
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+}
+
+// acceptsEventStream reports whether the client asked for Server-Sent Events.
+func acceptsEventStream(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part)); err == nil && mediaType == eventStreamContentType {
+			return true
+		}
+	}
+	return false
+}
+
+// withSSEMode serves requests accepting text/event-stream with sse, and
+// everything else with next, so one route supports both modes.
+func withSSEMode(sse http.Handler, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if acceptsEventStream(r) {
+			sse.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
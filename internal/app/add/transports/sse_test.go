@@ -0,0 +1,43 @@
+package transports
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/endpoints"
+	"github.com/cage1016/gokit-gae/internal/pkg/progress"
+)
+
+func TestSSEServer(t *testing.T) {
+	e := func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(endpoints.SumRequest)
+		progress.Report(ctx, 50, "calculated")
+		progress.Report(ctx, 100, "history recorded")
+		return endpoints.SumResponse{Res: req.A + req.B}, nil
+	}
+	h := NewSSEServer(e, decodeHTTPSumRequest, log.NewNopLogger())
+
+	// the result is ready as soon as the progress is, run it a few times to
+	// catch the progress events written after it
+	for i := 0; i < 20; i++ {
+		r := httptest.NewRequest(http.MethodPost, "/api/add/sum", strings.NewReader(`{"a":1,"b":2}`))
+		r.Header.Set("Accept", eventStreamContentType)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if ct := w.Header().Get("Content-Type"); ct != eventStreamContentType {
+			t.Fatalf("Content-Type = %q", ct)
+		}
+		want := "event: progress\ndata: {\"percent\":50,\"message\":\"calculated\"}\n\n" +
+			"event: progress\ndata: {\"percent\":100,\"message\":\"history recorded\"}\n\n" +
+			"event: result\n"
+		if body := w.Body.String(); !strings.HasPrefix(body, want) || !strings.Contains(body, `"res":3`) {
+			t.Fatalf("body = %q, want the progress events, then the result", body)
+		}
+	}
+}
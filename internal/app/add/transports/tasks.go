@@ -0,0 +1,47 @@
+package transports
+
+import (
+	"context"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/endpoints"
+	"github.com/cage1016/gokit-gae/internal/pkg/tasks"
+	"github.com/cage1016/gokit-gae/internal/pkg/tenant"
+)
+
+// TasksProducer enqueues Sum and Concat work to Cloud Tasks, pushed to the
+// HTTP routes of the service, which run it as any request with the task
+// metadata in their context.
+type TasksProducer struct {
+	p *tasks.Producer
+}
+
+// NewTasksProducer returns a TasksProducer enqueuing with p, its target
+// being the service.
+func NewTasksProducer(p *tasks.Producer) TasksProducer {
+	return TasksProducer{p: p}
+}
+
+// EnqueueSum enqueues the Sum of req and returns the name of the task.
+func (tp TasksProducer) EnqueueSum(ctx context.Context, req endpoints.SumRequest, o tasks.Options) (string, error) {
+	return tp.p.Enqueue(ctx, "/api/add/sum", req, withTenant(ctx, o))
+}
+
+// EnqueueConcat enqueues the Concat of req and returns the name of the task.
+func (tp TasksProducer) EnqueueConcat(ctx context.Context, req endpoints.ConcatRequest, o tasks.Options) (string, error) {
+	return tp.p.Enqueue(ctx, "/api/add/concat", req, withTenant(ctx, o))
+}
+
+// withTenant returns o with the tenant of ctx in the headers, the task
+// running for the tenant enqueuing it.
+func withTenant(ctx context.Context, o tasks.Options) tasks.Options {
+	id := tenant.FromContext(ctx)
+	if id == "" {
+		return o
+	}
+	headers := map[string]string{tenant.Header: id}
+	for k, v := range o.Headers {
+		headers[k] = v
+	}
+	o.Headers = headers
+	return o
+}
@@ -0,0 +1,120 @@
+//go:build thrift
+// +build thrift
+
+package transports
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/endpoint"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/endpoints"
+	"github.com/cage1016/gokit-gae/internal/app/add/service"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/tracing"
+	addthrift "github.com/cage1016/gokit-gae/pb/add/thrift/gen-go/add"
+)
+
+type thriftServer struct {
+	endpoints endpoints.Endpoints
+}
+
+// NewThriftServer makes the Sum and Concat endpoints available as a Thrift
+// service, see pb/add/thrift/add.thrift. Errors of the service are answered
+// in the err field of the replies, with their code when they have one.
+func NewThriftServer(endpoints endpoints.Endpoints) addthrift.AddService {
+	return &thriftServer{endpoints: endpoints}
+}
+
+func (s *thriftServer) Sum(ctx context.Context, a int64, b int64) (*addthrift.SumReply, error) {
+	response, err := s.endpoints.SumEndpoint(ctx, endpoints.SumRequest{A: a, B: b})
+	if err != nil {
+		return &addthrift.SumReply{Err: thriftError(err)}, nil
+	}
+	resp := response.(endpoints.SumResponse)
+	return &addthrift.SumReply{Value: resp.Res, Err: thriftError(resp.Err)}, nil
+}
+
+func (s *thriftServer) Concat(ctx context.Context, a string, b string) (*addthrift.ConcatReply, error) {
+	response, err := s.endpoints.ConcatEndpoint(ctx, endpoints.ConcatRequest{A: a, B: b})
+	if err != nil {
+		return &addthrift.ConcatReply{Err: thriftError(err)}, nil
+	}
+	resp := response.(endpoints.ConcatResponse)
+	return &addthrift.ConcatReply{Value: resp.Res, Err: thriftError(resp.Err)}, nil
+}
+
+// thriftError returns the err field reporting err, prefixed with its code.
+func thriftError(err error) string {
+	if err == nil {
+		return ""
+	}
+	if code := errors.Code(err); code != "" {
+		return code + ": " + err.Error()
+	}
+	return err.Error()
+}
+
+// NewThriftClient returns an AddService backed by the Thrift server at the
+// other end of client. The caller is responsible for constructing the
+// client, and eventually closing the underlying transport. History isn't
+// served over Thrift.
+func NewThriftClient(client *addthrift.AddServiceClient) service.AddService {
+	var sumEndpoint endpoint.Endpoint
+	{
+		sumEndpoint = MakeThriftSumEndpoint(client)
+		sumEndpoint = tracing.TraceClient("Sum")(sumEndpoint)
+	}
+
+	var concatEndpoint endpoint.Endpoint
+	{
+		concatEndpoint = MakeThriftConcatEndpoint(client)
+		concatEndpoint = tracing.TraceClient("Concat")(concatEndpoint)
+	}
+
+	historyEndpoint := func(context.Context, interface{}) (interface{}, error) {
+		return nil, errors.New("History is only served over HTTP")
+	}
+	exportEndpoint := func(context.Context, interface{}) (interface{}, error) {
+		return nil, errors.New("Export is only served over HTTP")
+	}
+
+	return endpoints.Endpoints{
+		SumEndpoint:     sumEndpoint,
+		ConcatEndpoint:  concatEndpoint,
+		HistoryEndpoint: historyEndpoint,
+		ExportEndpoint:  exportEndpoint,
+	}
+}
+
+// MakeThriftSumEndpoint returns an endpoint that invokes Sum on the Thrift
+// client. Primarily useful in a client.
+func MakeThriftSumEndpoint(client *addthrift.AddServiceClient) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(endpoints.SumRequest)
+		reply, err := client.Sum(ctx, req.A, req.B)
+		if err != nil {
+			return nil, err
+		}
+		if reply.Err != "" {
+			return nil, errors.New(reply.Err)
+		}
+		return endpoints.SumResponse{Res: reply.Value}, nil
+	}
+}
+
+// MakeThriftConcatEndpoint returns an endpoint that invokes Concat on the
+// Thrift client. Primarily useful in a client.
+func MakeThriftConcatEndpoint(client *addthrift.AddServiceClient) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(endpoints.ConcatRequest)
+		reply, err := client.Concat(ctx, req.A, req.B)
+		if err != nil {
+			return nil, err
+		}
+		if reply.Err != "" {
+			return nil, errors.New(reply.Err)
+		}
+		return endpoints.ConcatResponse{Res: reply.Value}, nil
+	}
+}
@@ -0,0 +1,61 @@
+package transports
+
+import (
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/tracing/opentracing"
+	"github.com/go-kit/kit/tracing/zipkin"
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+	httptransport "github.com/go-kit/kit/transport/http"
+	stdopentracing "github.com/opentracing/opentracing-go"
+	stdzipkin "github.com/openzipkin/zipkin-go"
+)
+
+// The clients accept nil opentracing and zipkin tracers, in which case
+// these stacks aren't built at all rather than fed no-op tracers.
+
+func passThrough(next endpoint.Endpoint) endpoint.Endpoint {
+	return next
+}
+
+// openTracingClient returns the opentracing client middleware of otTracer.
+func openTracingClient(otTracer stdopentracing.Tracer, operationName string) endpoint.Middleware {
+	if otTracer == nil {
+		return passThrough
+	}
+	return opentracing.TraceClient(otTracer, operationName)
+}
+
+// zipkinClient returns the zipkin client middleware of zipkinTracer.
+func zipkinClient(zipkinTracer *stdzipkin.Tracer, operationName string) endpoint.Middleware {
+	if zipkinTracer == nil {
+		return passThrough
+	}
+	return zipkin.TraceEndpoint(zipkinTracer, operationName)
+}
+
+// httpClientTracing returns the client options propagating the spans of the
+// tracers set, followed by the other request funcs in before.
+func httpClientTracing(otTracer stdopentracing.Tracer, zipkinTracer *stdzipkin.Tracer, logger log.Logger, before ...httptransport.RequestFunc) []httptransport.ClientOption {
+	var options []httptransport.ClientOption
+	if zipkinTracer != nil {
+		options = append(options, zipkin.HTTPClientTrace(zipkinTracer))
+	}
+	if otTracer != nil {
+		before = append([]httptransport.RequestFunc{opentracing.ContextToHTTP(otTracer, logger)}, before...)
+	}
+	return append(options, httptransport.ClientBefore(before...))
+}
+
+// grpcClientTracing returns the client options propagating the spans of the
+// tracers set, followed by the other request funcs in before.
+func grpcClientTracing(otTracer stdopentracing.Tracer, zipkinTracer *stdzipkin.Tracer, logger log.Logger, before ...grpctransport.ClientRequestFunc) []grpctransport.ClientOption {
+	var options []grpctransport.ClientOption
+	if zipkinTracer != nil {
+		options = append(options, zipkin.GRPCClientTrace(zipkinTracer))
+	}
+	if otTracer != nil {
+		before = append([]grpctransport.ClientRequestFunc{opentracing.ContextToGRPC(otTracer, logger)}, before...)
+	}
+	return append(options, grpctransport.ClientBefore(before...))
+}
@@ -0,0 +1,175 @@
+package transports
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/websocket"
+
+	"github.com/cage1016/gokit-gae/internal/app/add/endpoints"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/responses"
+	"github.com/cage1016/gokit-gae/internal/pkg/tenant"
+)
+
+const (
+	// wsMaxMessageSize bounds a single client message.
+	wsMaxMessageSize int64 = 64 << 10
+	// wsMaxInFlight bounds how many messages of one connection run at once.
+	wsMaxInFlight int = 8
+)
+
+// ErrUnknownMethod indicates a stream message naming a method that doesn't exist.
+var ErrUnknownMethod = errors.Register(errors.KindNotFound, errors.NewCoded("ADD-004", "unknown method"))
+
+// wsRequest is a single message sent by the client on the stream. ID is
+// chosen by the client and echoed on the matching response.
+type wsRequest struct {
+	ID     string          `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// wsResponse is pushed back for every wsRequest, in completion order.
+type wsResponse struct {
+	ID     string                  `json:"id"`
+	Method string                  `json:"method"`
+	Data   interface{}             `json:"data,omitempty"`
+	Error  *responses.ErrorResItem `json:"error,omitempty"`
+}
+
+type wsMethod struct {
+	endpoint endpoint.Endpoint
+	decode   func(json.RawMessage) (interface{}, error)
+}
+
+type wsServer struct {
+	methods  map[string]wsMethod
+	upgrader websocket.Upgrader
+	before   []httptransport.RequestFunc
+	logger   log.Logger
+}
+
+// NewWSHandler returns a handler that upgrades the connection to a WebSocket
+// and serves a sequence of Sum/Concat requests over it. Each message is
+// {"id", "method", "params"}; responses carry the same id so clients can
+// correlate them, as they may arrive out of order.
+func NewWSHandler(endpoints endpoints.Endpoints, logger log.Logger) http.Handler {
+	return &wsServer{
+		methods: map[string]wsMethod{
+			"sum":    {endpoint: endpoints.SumEndpoint, decode: decodeWSSumRequest},
+			"concat": {endpoint: endpoints.ConcatEndpoint, decode: decodeWSConcatRequest},
+		},
+		before: []httptransport.RequestFunc{
+			httptransport.PopulateRequestContext,
+			tenant.HTTPToContext(),
+			kitjwt.HTTPToContext(),
+		},
+		logger: logger,
+	}
+}
+
+func (s *wsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// the upgrade request carries the credentials for the whole stream
+	ctx := r.Context()
+	for _, f := range s.before {
+		ctx = f(ctx, r)
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		level.Error(s.logger).Log("protocol", "WS", "err", err)
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(wsMaxMessageSize)
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		inFlight = make(chan struct{}, wsMaxInFlight)
+	)
+	write := func(res wsResponse) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := conn.WriteJSON(res); err != nil {
+			level.Error(s.logger).Log("protocol", "WS", "id", res.ID, "err", err)
+		}
+	}
+
+	for {
+		var req wsRequest
+		if err := conn.ReadJSON(&req); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				level.Info(s.logger).Log("protocol", "WS", "read", err)
+			}
+			break
+		}
+
+		inFlight <- struct{}{}
+		wg.Add(1)
+		go func(req wsRequest) {
+			defer func() {
+				<-inFlight
+				wg.Done()
+			}()
+			write(s.serve(ctx, req))
+		}(req)
+	}
+
+	wg.Wait()
+}
+
+func (s *wsServer) serve(ctx context.Context, req wsRequest) wsResponse {
+	res := wsResponse{ID: req.ID, Method: req.Method}
+
+	m, ok := s.methods[req.Method]
+	if !ok {
+		return s.fail(ctx, res, ErrUnknownMethod)
+	}
+
+	request, err := m.decode(req.Params)
+	if err != nil {
+		return s.fail(ctx, res, err)
+	}
+
+	response, err := m.endpoint(ctx, request)
+	if err != nil {
+		return s.fail(ctx, res, err)
+	}
+
+	if ar, ok := response.(responses.Responser); ok {
+		res.Data = ar.Response()
+	} else {
+		res.Data = response
+	}
+	return res
+}
+
+func (s *wsServer) fail(ctx context.Context, res wsResponse, err error) wsResponse {
+	level.Error(s.logger).Log("protocol", "WS", "id", res.ID, "method", res.Method, "err", err)
+	item := httpErrorItem(ctx, err)
+	res.Error = &item
+	return res
+}
+
+// decodeWSSumRequest decodes the params of a "sum" stream message.
+func decodeWSSumRequest(params json.RawMessage) (interface{}, error) {
+	var req endpoints.SumRequest
+	err := json.Unmarshal(params, &req)
+	return req, err
+}
+
+// decodeWSConcatRequest decodes the params of a "concat" stream message.
+func decodeWSConcatRequest(params json.RawMessage) (interface{}, error) {
+	var req endpoints.ConcatRequest
+	err := json.Unmarshal(params, &req)
+	return req, err
+}
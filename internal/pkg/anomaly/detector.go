@@ -0,0 +1,157 @@
+// Package anomaly watches business metrics for values deviating from their
+// recent history, to catch the functional regressions no error reports,
+// such as a client silently stopping to call.
+package anomaly
+
+import (
+	"math"
+	"sort"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// ErrUnknownDetector indicates a detector name NewDetector doesn't know.
+var ErrUnknownDetector = errors.New("unknown anomaly detector")
+
+// A Detector tells whether a sample deviates from the samples observed
+// before, over a rolling window. It needs half a window before it reports
+// anomalies. Detectors are not safe for concurrent use.
+type Detector interface {
+	// Observe adds v to the window, returning how far it deviates and
+	// whether that's an anomaly.
+	Observe(v float64) (score float64, anomalous bool)
+}
+
+// NewDetector returns the detector named name, "zscore" or "percentile",
+// with their default thresholds, over window samples.
+func NewDetector(name string, window int) (Detector, error) {
+	switch name {
+	case "zscore":
+		return NewZScore(window, 3), nil
+	case "percentile":
+		return NewPercentile(window, 0.99), nil
+	}
+	return nil, errors.Wrap(ErrUnknownDetector, errors.New(name))
+}
+
+// window is a ring of the last samples.
+type window struct {
+	samples []float64
+	next    int
+	full    bool
+}
+
+func newWindow(size int) *window {
+	if size < 2 {
+		size = 2
+	}
+	return &window{samples: make([]float64, size)}
+}
+
+func (w *window) len() int {
+	if w.full {
+		return len(w.samples)
+	}
+	return w.next
+}
+
+// warm reports whether the window holds enough samples to judge.
+func (w *window) warm() bool {
+	return w.len() >= len(w.samples)/2 && w.len() >= 2
+}
+
+func (w *window) values() []float64 {
+	return append([]float64(nil), w.samples[:w.len()]...)
+}
+
+func (w *window) add(v float64) {
+	w.samples[w.next] = v
+	w.next = (w.next + 1) % len(w.samples)
+	if w.next == 0 {
+		w.full = true
+	}
+}
+
+type zscore struct {
+	w         *window
+	threshold float64
+}
+
+// NewZScore returns a Detector of the samples more than threshold standard
+// deviations away from the mean of the window.
+func NewZScore(size int, threshold float64) Detector {
+	return &zscore{w: newWindow(size), threshold: threshold}
+}
+
+func (d *zscore) Observe(v float64) (float64, bool) {
+	defer d.w.add(v)
+	if !d.w.warm() {
+		return 0, false
+	}
+	var sum, sq float64
+	values := d.w.values()
+	for _, x := range values {
+		sum += x
+	}
+	mean := sum / float64(len(values))
+	for _, x := range values {
+		sq += (x - mean) * (x - mean)
+	}
+	sd := math.Sqrt(sq / float64(len(values)))
+	if sd == 0 {
+		// a flat history: any change is an anomaly
+		if v == mean {
+			return 0, false
+		}
+		return math.Inf(1), true
+	}
+	score := math.Abs(v-mean) / sd
+	return score, score > d.threshold
+}
+
+type percentile struct {
+	w *window
+	p float64
+}
+
+// NewPercentile returns a Detector of the samples above the p percentile of
+// the window, or below its 1-p percentile. The score is the distance to
+// the band they delimit, relative to its width.
+func NewPercentile(size int, p float64) Detector {
+	if p < 0.5 {
+		p = 1 - p
+	}
+	return &percentile{w: newWindow(size), p: p}
+}
+
+func (d *percentile) Observe(v float64) (float64, bool) {
+	defer d.w.add(v)
+	if !d.w.warm() {
+		return 0, false
+	}
+	values := d.w.values()
+	sort.Float64s(values)
+	low, high := rank(values, 1-d.p), rank(values, d.p)
+	var dist float64
+	switch {
+	case v > high:
+		dist = v - high
+	case v < low:
+		dist = low - v
+	default:
+		return 0, false
+	}
+	if width := high - low; width > 0 {
+		return dist / width, true
+	}
+	return math.Inf(1), true
+}
+
+// rank returns the p percentile of the sorted values, by nearest rank.
+func rank(sorted []float64, p float64) float64 {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
@@ -0,0 +1,133 @@
+package anomaly
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Alert reports a metric deviating from its recent history.
+type Alert struct {
+	Metric string    `json:"metric"`
+	Value  float64   `json:"value"`
+	Score  float64   `json:"score"`
+	Time   time.Time `json:"time"`
+}
+
+// Source returns the current value of the metrics watched, by name. A
+// metric it doesn't return is skipped for the round.
+type Source func() (map[string]float64, error)
+
+// PrometheusSource returns a Source of the metrics names gathered by g,
+// their series summed: the increase since the previous round for counters,
+// the current value for gauges, and the mean observation of the round for
+// histograms and summaries.
+func PrometheusSource(g stdprometheus.Gatherer, names ...string) Source {
+	watched := map[string]bool{}
+	for _, n := range names {
+		watched[n] = true
+	}
+	// last are the totals of the previous round, [sum, count] of histograms
+	last := map[string][2]float64{}
+	return func() (map[string]float64, error) {
+		families, err := g.Gather()
+		if err != nil {
+			return nil, err
+		}
+		values := map[string]float64{}
+		for _, f := range families {
+			name := f.GetName()
+			if !watched[name] {
+				continue
+			}
+			var total, count float64
+			for _, m := range f.GetMetric() {
+				switch f.GetType() {
+				case dto.MetricType_COUNTER:
+					total += m.GetCounter().GetValue()
+				case dto.MetricType_GAUGE:
+					total += m.GetGauge().GetValue()
+				case dto.MetricType_UNTYPED:
+					total += m.GetUntyped().GetValue()
+				case dto.MetricType_HISTOGRAM:
+					total += m.GetHistogram().GetSampleSum()
+					count += float64(m.GetHistogram().GetSampleCount())
+				case dto.MetricType_SUMMARY:
+					total += m.GetSummary().GetSampleSum()
+					count += float64(m.GetSummary().GetSampleCount())
+				}
+			}
+
+			prev, seen := last[name]
+			last[name] = [2]float64{total, count}
+			switch f.GetType() {
+			case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+				values[name] = total
+			case dto.MetricType_COUNTER:
+				if seen {
+					values[name] = total - prev[0]
+				}
+			default:
+				if seen && count > prev[1] {
+					values[name] = (total - prev[0]) / (count - prev[1])
+				}
+			}
+		}
+		return values, nil
+	}
+}
+
+// Evaluator feeds the values of a Source to a Detector per metric, and
+// alerts of the anomalies they detect.
+type Evaluator struct {
+	source    Source
+	detector  func() Detector
+	alert     func(ctx context.Context, a Alert)
+	logger    log.Logger
+	detectors map[string]Detector
+}
+
+// NewEvaluator returns an Evaluator of the metrics of source, detecting with
+// detectors made by detector and reporting to alert.
+func NewEvaluator(source Source, detector func() Detector, alert func(ctx context.Context, a Alert), logger log.Logger) *Evaluator {
+	return &Evaluator{source: source, detector: detector, alert: alert, logger: logger, detectors: map[string]Detector{}}
+}
+
+// Evaluate runs a round: it reads the source and judges every value.
+func (e *Evaluator) Evaluate(ctx context.Context) {
+	values, err := e.source()
+	if err != nil {
+		level.Warn(e.logger).Log("anomaly", "source", "err", err)
+		return
+	}
+	now := time.Now()
+	for name, v := range values {
+		d, ok := e.detectors[name]
+		if !ok {
+			d = e.detector()
+			e.detectors[name] = d
+		}
+		if score, anomalous := d.Observe(v); anomalous {
+			level.Warn(e.logger).Log("anomaly", name, "value", v, "score", score)
+			e.alert(ctx, Alert{Metric: name, Value: v, Score: score, Time: now})
+		}
+	}
+}
+
+// Run evaluates every interval until ctx is done.
+func (e *Evaluator) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			e.Evaluate(ctx)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Package appengine serves the lifecycle requests App Engine sends to an
+// instance: /_ah/warmup before it gets traffic, for the service to
+// initialize its clients ahead of the first request, and /_ah/stop before it
+// shuts down, for the service to flush its state. Services register the
+// hooks to run on either.
+package appengine
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// The paths of the lifecycle requests.
+const (
+	WarmupPath = "/_ah/warmup"
+	StopPath   = "/_ah/stop"
+)
+
+// ErrHookFailed indicates lifecycle hooks that failed.
+var ErrHookFailed = errors.New("lifecycle hook failed")
+
+// Hook is run on a lifecycle request.
+type Hook func(ctx context.Context) error
+
+type namedHook struct {
+	name string
+	hook Hook
+}
+
+// Hooks holds the hooks of the lifecycle requests. It's safe for concurrent
+// use.
+type Hooks struct {
+	logger log.Logger
+
+	mu     sync.Mutex
+	warmup []namedHook
+	stop   []namedHook
+	warmed bool
+}
+
+// New returns Hooks without any hook, logging their runs to logger.
+func New(logger log.Logger) *Hooks {
+	return &Hooks{logger: logger}
+}
+
+// OnWarmup registers hook, called name, to run on warmup.
+func (h *Hooks) OnWarmup(name string, hook Hook) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.warmup = append(h.warmup, namedHook{name, hook})
+}
+
+// OnStop registers hook, called name, to run on stop.
+func (h *Hooks) OnStop(name string, hook Hook) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.stop = append(h.stop, namedHook{name, hook})
+}
+
+// Warmup runs the warmup hooks, once: App Engine may send the warmup
+// request again, an instance is only warmed once. Every hook runs even when
+// others fail; the first error is returned.
+func (h *Hooks) Warmup(ctx context.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.warmed {
+		return nil
+	}
+	h.warmed = true
+	return h.run(ctx, "warmup", h.warmup)
+}
+
+// Stop runs the stop hooks, on every call, the hooks flushing state being
+// worth running again at the actual shutdown. Every hook runs even when
+// others fail; the first error is returned.
+func (h *Hooks) Stop(ctx context.Context) error {
+	h.mu.Lock()
+	hooks := h.stop
+	h.mu.Unlock()
+	return h.run(ctx, "stop", hooks)
+}
+
+func (h *Hooks) run(ctx context.Context, phase string, hooks []namedHook) error {
+	var first error
+	for _, nh := range hooks {
+		begin := time.Now()
+		err := nh.hook(ctx)
+		if err != nil {
+			level.Warn(h.logger).Log("lifecycle", phase, "hook", nh.name, "took", time.Since(begin), "err", err)
+			if first == nil {
+				first = errors.Wrap(ErrHookFailed, err)
+			}
+			continue
+		}
+		level.Info(h.logger).Log("lifecycle", phase, "hook", nh.name, "took", time.Since(begin))
+	}
+	return first
+}
+
+// WarmupHandler returns the handler of WarmupPath running the warmup hooks.
+// A failing hook answers 500, App Engine then sending traffic anyway.
+func (h *Hooks) WarmupHandler() http.Handler {
+	return handler(h.Warmup)
+}
+
+// StopHandler returns the handler of StopPath running the stop hooks.
+func (h *Hooks) StopHandler() http.Handler {
+	return handler(h.Stop)
+}
+
+func handler(run func(ctx context.Context) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := run(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
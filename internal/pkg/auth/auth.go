@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"context"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/endpoint"
+)
+
+// Principal returns the subject of the verified JWT claims a kitjwt.NewParser
+// middleware put in ctx, or "" for anonymous callers.
+func Principal(ctx context.Context) string {
+	switch claims := ctx.Value(kitjwt.JWTClaimsContextKey).(type) {
+	case jwt.MapClaims:
+		sub, _ := claims["sub"].(string)
+		return sub
+	case *jwt.StandardClaims:
+		return claims.Subject
+	}
+	return ""
+}
+
+// Authenticate returns the principal of ctx, verifying the JWT of the request
+// with authn, e.g. the middleware of NewParser, when no middleware verified it
+// yet. Callers without a JWT, or whose JWT doesn't verify, are anonymous.
+// authn may be nil when the endpoints verify the JWT themselves.
+func Authenticate(ctx context.Context, request interface{}, authn endpoint.Middleware) string {
+	if p := Principal(ctx); p != "" || authn == nil {
+		return p
+	}
+	if _, ok := ctx.Value(kitjwt.JWTTokenContextKey).(string); !ok {
+		return ""
+	}
+	var p string
+	authn(func(ctx context.Context, _ interface{}) (interface{}, error) {
+		p = Principal(ctx)
+		return nil, nil
+	})(ctx, request)
+	return p
+}
+
+// Middleware returns an endpoint middleware authenticating the callers with
+// authn, e.g. the middleware of NewParser: the claims of their verified JWT
+// are put in the context, for Principal, and the requests whose JWT doesn't
+// verify fail with its error. Requests without a JWT are let through
+// anonymous, and the ones already authenticated as they are.
+func Middleware(authn endpoint.Middleware) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		verified := authn(next)
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if Principal(ctx) != "" {
+				return next(ctx, request)
+			}
+			if _, ok := ctx.Value(kitjwt.JWTTokenContextKey).(string); !ok {
+				return next(ctx, request)
+			}
+			return verified(ctx, request)
+		}
+	}
+}
+
+// HasScope reports whether the verified JWT claims in ctx grant scope, listed
+// in the space separated "scope" claim.
+func HasScope(ctx context.Context, scope string) bool {
+	for _, s := range Scopes(ctx) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Scopes returns the scopes the verified JWT claims in ctx grant.
+func Scopes(ctx context.Context) []string {
+	claims, ok := ctx.Value(kitjwt.JWTClaimsContextKey).(jwt.MapClaims)
+	if !ok {
+		return nil
+	}
+	granted, _ := claims["scope"].(string)
+	return strings.Fields(granted)
+}
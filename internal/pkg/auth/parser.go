@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/endpoint"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/clock"
+)
+
+// NewParser returns the middleware of kitjwt.NewParser verifying the JWT
+// signed with method and the key of keyFunc, whose time claims, exp, nbf and
+// iat, are checked against c tolerating leeway of clock skew between the
+// issuer and the service. The verified claims are put in the context as
+// jwt.MapClaims, as kitjwt.MapClaimsFactory does.
+func NewParser(keyFunc jwt.Keyfunc, method jwt.SigningMethod, c clock.Clock, leeway time.Duration) endpoint.Middleware {
+	parser := kitjwt.NewParser(keyFunc, method, func() jwt.Claims {
+		return &leewayClaims{MapClaims: jwt.MapClaims{}, clock: c, leeway: leeway}
+	})
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return parser(func(ctx context.Context, request interface{}) (interface{}, error) {
+			if lc, ok := ctx.Value(kitjwt.JWTClaimsContextKey).(*leewayClaims); ok {
+				ctx = context.WithValue(ctx, kitjwt.JWTClaimsContextKey, lc.MapClaims)
+			}
+			return next(ctx, request)
+		})
+	}
+}
+
+// leewayClaims are the claims of a token validated against a clock, with
+// leeway.
+type leewayClaims struct {
+	jwt.MapClaims
+	clock  clock.Clock
+	leeway time.Duration
+}
+
+// UnmarshalJSON decodes the claims of the token into MapClaims.
+func (lc *leewayClaims) UnmarshalJSON(b []byte) error {
+	return json.Unmarshal(b, &lc.MapClaims)
+}
+
+// Valid implements jwt.Claims, as jwt.MapClaims does but at the time of the
+// clock, with leeway.
+func (lc *leewayClaims) Valid() error {
+	now := lc.clock.Now().Unix()
+	leeway := int64(lc.leeway / time.Second)
+	verr := new(jwt.ValidationError)
+	if !lc.VerifyExpiresAt(now-leeway, false) {
+		verr.Inner = errors.New("Token is expired")
+		verr.Errors |= jwt.ValidationErrorExpired
+	}
+	if !lc.VerifyIssuedAt(now+leeway, false) {
+		verr.Inner = errors.New("Token used before issued")
+		verr.Errors |= jwt.ValidationErrorIssuedAt
+	}
+	if !lc.VerifyNotBefore(now+leeway, false) {
+		verr.Inner = errors.New("Token is not valid yet")
+		verr.Errors |= jwt.ValidationErrorNotValidYet
+	}
+	if verr.Errors == 0 {
+		return nil
+	}
+	return verr
+}
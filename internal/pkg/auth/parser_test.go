@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/clock"
+)
+
+var testKey = []byte("parser-test")
+
+func sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestParserLeeway(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := clock.NewFrozen(now)
+	at := func(d time.Duration) int64 { return now.Add(d).Unix() }
+
+	cases := []struct {
+		name   string
+		claims jwt.MapClaims
+		leeway time.Duration
+		valid  bool
+	}{
+		{"no time claims", jwt.MapClaims{"sub": "alice"}, 0, true},
+		{"valid", jwt.MapClaims{"exp": at(time.Minute), "nbf": at(-time.Minute), "iat": at(-time.Minute)}, 0, true},
+		{"expired", jwt.MapClaims{"exp": at(-time.Second)}, 0, false},
+		{"expired within leeway", jwt.MapClaims{"exp": at(-30 * time.Second)}, time.Minute, true},
+		{"expired beyond leeway", jwt.MapClaims{"exp": at(-2 * time.Minute)}, time.Minute, false},
+		{"not yet valid", jwt.MapClaims{"nbf": at(time.Second)}, 0, false},
+		{"not yet valid within leeway", jwt.MapClaims{"nbf": at(30 * time.Second)}, time.Minute, true},
+		{"issued in the future", jwt.MapClaims{"iat": at(time.Second)}, 0, false},
+		{"issued in the future within leeway", jwt.MapClaims{"iat": at(30 * time.Second)}, time.Minute, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			parser := NewParser(func(*jwt.Token) (interface{}, error) { return testKey, nil }, jwt.SigningMethodHS256, c, tc.leeway)
+			var claims jwt.MapClaims
+			e := parser(func(ctx context.Context, _ interface{}) (interface{}, error) {
+				claims, _ = ctx.Value(kitjwt.JWTClaimsContextKey).(jwt.MapClaims)
+				return nil, nil
+			})
+			ctx := context.WithValue(context.Background(), kitjwt.JWTTokenContextKey, sign(t, tc.claims))
+			_, err := e(ctx, nil)
+			if valid := err == nil; valid != tc.valid {
+				t.Fatalf("err = %v, want valid %v", err, tc.valid)
+			}
+			if tc.valid && claims == nil {
+				t.Error("the claims weren't put in the context as jwt.MapClaims")
+			}
+		})
+	}
+}
+
+// TestParserFollowsClock checks a token expires as the clock advances.
+func TestParserFollowsClock(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := clock.NewFrozen(now)
+	parser := NewParser(func(*jwt.Token) (interface{}, error) { return testKey, nil }, jwt.SigningMethodHS256, c, 0)
+	e := parser(func(context.Context, interface{}) (interface{}, error) { return nil, nil })
+	ctx := context.WithValue(context.Background(), kitjwt.JWTTokenContextKey, sign(t, jwt.MapClaims{"exp": now.Add(time.Hour).Unix()}))
+
+	if _, err := e(ctx, nil); err != nil {
+		t.Fatalf("err = %v before expiry", err)
+	}
+	c.Advance(time.Hour + time.Second)
+	if _, err := e(ctx, nil); err != kitjwt.ErrTokenExpired {
+		t.Errorf("err = %v after expiry, want %v", err, kitjwt.ErrTokenExpired)
+	}
+}
@@ -0,0 +1,184 @@
+// Package bloom provides a bloom filter, and a negative cache built on it
+// for existence checks dominated by misses: keys the filter never saw are
+// known to be absent without asking the store.
+package bloom
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+)
+
+// Filter is a bloom filter of strings, safe for concurrent use. Test never
+// reports an added key as absent, and reports absent keys as present with
+// about the false positive rate the filter was sized for.
+type Filter struct {
+	mu   sync.RWMutex
+	bits []uint64
+	m    uint64
+	k    uint64
+}
+
+// New returns a Filter sized for n keys at the false positive rate p.
+func New(n int, p float64) *Filter {
+	if n < 1 {
+		n = 1
+	}
+	if p <= 0 || p >= 1 {
+		p = 0.01
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &Filter{bits: make([]uint64, (m+63)/64), m: m, k: k}
+}
+
+// hashes returns the two hashes the k bit positions of key derive from.
+func hashes(key string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	return sum, sum>>32 | 1
+}
+
+// Add adds key.
+func (f *Filter) Add(key string) {
+	h1, h2 := hashes(key)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// Test reports whether key may have been added.
+func (f *Filter) Test(key string) bool {
+	h1, h2 := hashes(key)
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// The results of the lookups counted by NegativeCache.
+const (
+	// Negative lookups were answered by the filter alone.
+	Negative = "negative"
+	// TruePositive lookups were confirmed by the store.
+	TruePositive = "true_positive"
+	// FalsePositive lookups went to the store for nothing.
+	FalsePositive = "false_positive"
+	// StaleNegative lookups were reported absent by a filter missing a key
+	// written elsewhere since the last refresh; the store corrected them.
+	StaleNegative = "stale_negative"
+)
+
+// Loader returns the keys currently in the store.
+type Loader func(ctx context.Context) ([]string, error)
+
+// NegativeCache is a per-instance bloom filter of the keys of a store,
+// rebuilt from the store every refresh and fed with the keys written in
+// between. The store stays authoritative: the cache only saves lookups of
+// keys it knows to be absent.
+type NegativeCache struct {
+	load    Loader
+	n       int
+	p       float64
+	lookups metrics.Counter
+
+	filter atomic.Value // *Filter
+}
+
+// NewNegativeCache returns an empty NegativeCache sized for n keys at the
+// false positive rate p, refreshed with load. lookups, labelled by result,
+// counts the outcome of the lookups; the false positive rate is
+// false_positive / (false_positive + true_positive).
+func NewNegativeCache(load Loader, n int, p float64, lookups metrics.Counter) *NegativeCache {
+	c := &NegativeCache{load: load, n: n, p: p, lookups: lookups}
+	c.filter.Store(New(n, p))
+	return c
+}
+
+// MayContain reports whether key may be in the store. When it's false, the
+// lookup is counted as negative.
+func (c *NegativeCache) MayContain(key string) bool {
+	if c.filter.Load().(*Filter).Test(key) {
+		return true
+	}
+	c.lookups.With("result", Negative).Add(1)
+	return false
+}
+
+// Add records that key was written to the store.
+func (c *NegativeCache) Add(key string) {
+	c.filter.Load().(*Filter).Add(key)
+}
+
+// Observe counts what the store answered for key: after MayContain reported
+// true, found tells a true from a false positive; after it reported false,
+// a found key was a stale negative.
+func (c *NegativeCache) Observe(mayContain, found bool) {
+	switch {
+	case mayContain && found:
+		c.lookups.With("result", TruePositive).Add(1)
+	case mayContain:
+		c.lookups.With("result", FalsePositive).Add(1)
+	case found:
+		c.lookups.With("result", StaleNegative).Add(1)
+	}
+}
+
+// Refresh rebuilds the filter from the keys of the store, dropping the keys
+// deleted since.
+func (c *NegativeCache) Refresh(ctx context.Context) error {
+	keys, err := c.load(ctx)
+	if err != nil {
+		return err
+	}
+	n := c.n
+	if len(keys)*2 > n {
+		n = len(keys) * 2
+	}
+	f := New(n, c.p)
+	for _, k := range keys {
+		f.Add(k)
+	}
+	c.filter.Store(f)
+	return nil
+}
+
+// Watch refreshes the filter every interval until ctx is done, the first
+// Refresh being left to the caller. A failing refresh keeps the previous
+// filter.
+func (c *NegativeCache) Watch(ctx context.Context, interval time.Duration, logger log.Logger) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := c.Refresh(ctx); err != nil {
+				level.Warn(logger).Log("bloom", "refresh", "err", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Package breaker implements circuit breakers guarding the dependencies of
+// the service: after too many consecutive failures, calls are rejected right
+// away for a cooldown, then a single trial call decides whether to close the
+// breaker again.
+package breaker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// ErrOpen indicates a call rejected by an open breaker.
+var ErrOpen = errors.Register(errors.KindUnavailable, errors.NewCoded("BRK-001", "dependency unavailable"))
+
+type state int
+
+const (
+	closed state = iota
+	open
+	halfOpen
+)
+
+// Breaker is a circuit breaker, safe for concurrent use.
+type Breaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    state
+	failures int
+	openedAt time.Time
+}
+
+// New returns a closed Breaker of the dependency name, opening after
+// threshold consecutive failures for cooldown.
+func New(name string, threshold int, cooldown time.Duration) *Breaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &Breaker{name: name, threshold: threshold, cooldown: cooldown}
+}
+
+// Name returns the name of the dependency guarded by b.
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+// Allow reports whether a call may proceed. Once the cooldown is over, the
+// first call allowed is the trial: the others are rejected until it's done.
+// Every call allowed must report its outcome with Success, Failure or
+// Release.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case open:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = halfOpen
+		return true
+	case halfOpen:
+		return false
+	}
+	return true
+}
+
+// Open reports whether calls are rejected, that is until the cooldown is
+// over, then while the trial call runs.
+func (b *Breaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case open:
+		return time.Since(b.openedAt) < b.cooldown
+	case halfOpen:
+		return true
+	}
+	return false
+}
+
+// Success reports a successful call, closing b.
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state, b.failures = closed, 0
+}
+
+// Failure reports a failed call, opening b after threshold consecutive
+// failures or a failed trial.
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == halfOpen || b.failures >= b.threshold {
+		b.state, b.openedAt = open, time.Now()
+	}
+}
+
+// Release reports a call whose outcome says nothing of the dependency, e.g.
+// canceled by its caller. A trial call gives its turn to the next call.
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == halfOpen {
+		b.state, b.openedAt = open, time.Now().Add(-b.cooldown)
+	}
+}
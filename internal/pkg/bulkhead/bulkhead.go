@@ -0,0 +1,100 @@
+// Package bulkhead caps the requests in flight of an endpoint, so that one
+// slow endpoint can't take all the resources of an instance: past the cap,
+// a bounded number of requests wait a bounded time for a slot, and the
+// others are shed at once with a hint to retry later.
+package bulkhead
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// ErrFull indicates a request shed as the bulkhead was full.
+var ErrFull = errors.Register(errors.KindUnavailable, errors.NewCoded("BULK-001", "too many requests in flight"))
+
+// Bulkhead is a semaphore of request slots with a bounded queue of
+// waiters. It's safe for concurrent use.
+type Bulkhead struct {
+	slots   chan struct{}
+	waiters int64
+	queue   int64
+	maxWait time.Duration
+}
+
+// New returns a Bulkhead of limit slots, queuing up to queue requests for at
+// most maxWait.
+func New(limit, queue int, maxWait time.Duration) *Bulkhead {
+	return &Bulkhead{slots: make(chan struct{}, limit), queue: int64(queue), maxWait: maxWait}
+}
+
+// Acquire takes a slot, waiting for one if the queue has room, and returns
+// the function releasing it. It fails with ErrFull, telling to retry after
+// the maximum wait, when no slot frees up in time, or with the error of ctx
+// when it's done first.
+func (b *Bulkhead) Acquire(ctx context.Context) (release func(), err error) {
+	select {
+	case b.slots <- struct{}{}:
+		return b.release, nil
+	default:
+	}
+
+	if atomic.AddInt64(&b.waiters, 1) > b.queue {
+		atomic.AddInt64(&b.waiters, -1)
+		return nil, b.full()
+	}
+	defer atomic.AddInt64(&b.waiters, -1)
+	t := time.NewTimer(b.maxWait)
+	defer t.Stop()
+	select {
+	case b.slots <- struct{}{}:
+		return b.release, nil
+	case <-t.C:
+		return nil, b.full()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (b *Bulkhead) release() {
+	<-b.slots
+}
+
+func (b *Bulkhead) full() error {
+	after := b.maxWait
+	if after < time.Second {
+		after = time.Second
+	}
+	return errors.WithRetryAfter(ErrFull, after)
+}
+
+// InFlight returns the number of slots taken.
+func (b *Bulkhead) InFlight() int {
+	return len(b.slots)
+}
+
+// Waiting returns the number of requests waiting for a slot.
+func (b *Bulkhead) Waiting() int {
+	return int(atomic.LoadInt64(&b.waiters))
+}
+
+// Middleware returns an endpoint middleware running the requests in the
+// slots of b, counting the ones shed in rejected.
+func (b *Bulkhead) Middleware(rejected metrics.Counter) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			release, err := b.Acquire(ctx)
+			if err != nil {
+				rejected.Add(1)
+				return nil, err
+			}
+			defer release()
+			return next(ctx, request)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// Package cache provides a read-through cache for reference data: small,
+// rarely changing data read on many requests. Concurrent misses of a key
+// share one load, entries expire with jitter so they don't all reload at
+// once, and expired entries are served while they revalidate.
+package cache
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+)
+
+// The results of the lookups counted by Cache.
+const (
+	Hit   = "hit"
+	Miss  = "miss"
+	Stale = "stale"
+)
+
+// refreshTimeout bounds the background revalidations, which outlive the
+// request that triggered them.
+const refreshTimeout = 30 * time.Second
+
+// Loader loads the value of key from the source of truth.
+type Loader func(ctx context.Context, key string) (interface{}, error)
+
+// Options tunes the expiration of the entries.
+type Options struct {
+	// TTL is how long a loaded value is fresh.
+	TTL time.Duration
+	// Jitter adds up to Jitter to the TTL of every entry.
+	Jitter time.Duration
+	// Stale is how long after expiring a value is still served while it
+	// revalidates in the background. Zero waits for the reload.
+	Stale time.Duration
+}
+
+type entry struct {
+	value      interface{}
+	freshUntil time.Time
+	staleUntil time.Time
+}
+
+// call is a load in flight, shared by the lookups of its key.
+type call struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// Cache is a read-through cache of the values of load, safe for concurrent
+// use. Errors aren't cached. It holds every key ever loaded, so it suits
+// bounded key sets.
+type Cache struct {
+	name    string
+	load    Loader
+	opts    Options
+	lookups metrics.Counter
+
+	mu      sync.Mutex
+	entries map[string]entry
+	calls   map[string]*call
+}
+
+// New returns an empty Cache of the values of load. lookups, labelled by
+// cache and result, counts the hits, misses and stale hits of the lookups
+// under name.
+func New(name string, load Loader, opts Options, lookups metrics.Counter) *Cache {
+	return &Cache{
+		name:    name,
+		load:    load,
+		opts:    opts,
+		lookups: lookups,
+		entries: map[string]entry{},
+		calls:   map[string]*call{},
+	}
+}
+
+// Get returns the value of key, loading it on a miss. A stale value is
+// returned right away while a single background load revalidates it.
+func (c *Cache) Get(ctx context.Context, key string) (interface{}, error) {
+	now := time.Now()
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	switch {
+	case ok && now.Before(e.freshUntil):
+		c.mu.Unlock()
+		c.lookups.With("cache", c.name, "result", Hit).Add(1)
+		return e.value, nil
+	case ok && now.Before(e.staleUntil):
+		if _, loading := c.calls[key]; !loading {
+			cl := c.start(key)
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+				defer cancel()
+				c.run(ctx, key, cl)
+			}()
+		}
+		c.mu.Unlock()
+		c.lookups.With("cache", c.name, "result", Stale).Add(1)
+		return e.value, nil
+	}
+
+	c.lookups.With("cache", c.name, "result", Miss).Add(1)
+	cl, loading := c.calls[key]
+	if !loading {
+		cl = c.start(key)
+	}
+	c.mu.Unlock()
+	if !loading {
+		c.run(ctx, key, cl)
+	}
+
+	select {
+	case <-cl.done:
+		return cl.value, cl.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Invalidate drops the value of key, the next Get loads it again.
+func (c *Cache) Invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+// start registers a load of key; it must be called with mu held.
+func (c *Cache) start(key string) *call {
+	cl := &call{done: make(chan struct{})}
+	c.calls[key] = cl
+	return cl
+}
+
+// run loads key, stores the value and releases the lookups waiting on cl.
+func (c *Cache) run(ctx context.Context, key string, cl *call) {
+	cl.value, cl.err = c.load(ctx, key)
+
+	c.mu.Lock()
+	delete(c.calls, key)
+	if cl.err == nil {
+		ttl := c.opts.TTL
+		if c.opts.Jitter > 0 {
+			ttl += time.Duration(rand.Int63n(int64(c.opts.Jitter)))
+		}
+		now := time.Now()
+		c.entries[key] = entry{value: cl.value, freshUntil: now.Add(ttl), staleUntil: now.Add(ttl + c.opts.Stale)}
+	}
+	c.mu.Unlock()
+	close(cl.done)
+}
@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+)
+
+// counter counts the lookups by result.
+type counter struct {
+	mu      *sync.Mutex
+	results map[string]int
+	result  string
+}
+
+func (c *counter) With(labelValues ...string) metrics.Counter {
+	return &counter{mu: c.mu, results: c.results, result: labelValues[len(labelValues)-1]}
+}
+
+func (c *counter) Add(delta float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results[c.result] += int(delta)
+}
+
+func TestCache(t *testing.T) {
+	var loads int32
+	release := make(chan struct{})
+	load := func(ctx context.Context, key string) (interface{}, error) {
+		<-release
+		return atomic.AddInt32(&loads, 1), nil
+	}
+	lookups := &counter{mu: &sync.Mutex{}, results: map[string]int{}}
+	c := New("test", load, Options{TTL: 50 * time.Millisecond, Stale: time.Hour}, lookups)
+
+	// concurrent misses share one load
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := c.Get(context.Background(), "k"); err != nil || v.(int32) != 1 {
+				t.Errorf("Get = %v, %v, want 1", v, err)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Fatalf("%d loads, want 1", n)
+	}
+	// the lookups starting after the load are hits
+	late := 10 - lookups.results[Miss]
+
+	if v, _ := c.Get(context.Background(), "k"); v.(int32) != 1 {
+		t.Errorf("hit = %v, want 1", v)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if v, _ := c.Get(context.Background(), "k"); v.(int32) != 1 {
+		t.Errorf("stale = %v, want 1", v)
+	}
+	for deadline := time.Now().Add(time.Second); atomic.LoadInt32(&loads) < 2 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if v, _ := c.Get(context.Background(), "k"); v.(int32) != 2 {
+		t.Errorf("revalidated = %v, want 2", v)
+	}
+
+	if lookups.results[Hit] != 2+late || lookups.results[Stale] != 1 {
+		t.Errorf("lookups = %v", lookups.results)
+	}
+}
@@ -0,0 +1,148 @@
+// Package canary routes a share of the calls of an endpoint to an
+// alternative implementation, such as the next version of the service
+// deployed aside, to try it on production traffic. In shadow mode the
+// callers are always answered by the primary endpoint, the alternative
+// being called as well for its response to be compared, so that a
+// divergence shows up in the metrics before any caller sees it.
+package canary
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+)
+
+// The targets of the calls, see Canary.Middleware.
+const (
+	TargetPrimary = "primary"
+	TargetCanary  = "canary"
+	TargetShadow  = "shadow"
+)
+
+// The results of the comparisons of shadow mode.
+const (
+	ResultMatch    = "match"
+	ResultDiverged = "diverged"
+	ResultError    = "error"
+)
+
+// Canary splits the calls of endpoints between the primary and the
+// alternative implementations.
+type Canary struct {
+	percent float64
+	shadow  bool
+	timeout time.Duration
+	equal   func(a, b interface{}) bool
+	calls   metrics.Counter
+	results metrics.Counter
+	logger  log.Logger
+}
+
+// Option configures a Canary.
+type Option func(*Canary)
+
+// Shadow makes the Canary answer every call with the primary endpoint,
+// calling the alternative as well in the background, within timeout, to
+// compare their responses.
+func Shadow(timeout time.Duration) Option {
+	return func(c *Canary) {
+		c.shadow, c.timeout = true, timeout
+	}
+}
+
+// Equal makes the Canary compare the responses with equal rather than
+// reflect.DeepEqual.
+func Equal(equal func(a, b interface{}) bool) Option {
+	return func(c *Canary) {
+		c.equal = equal
+	}
+}
+
+// New returns a Canary routing percent of the calls to the alternative.
+// calls, labeled by method and target, counts the calls, and results,
+// labeled by method and result, the comparisons of shadow mode.
+func New(percent float64, calls, results metrics.Counter, logger log.Logger, options ...Option) *Canary {
+	c := &Canary{percent: percent, equal: reflect.DeepEqual, calls: calls, results: results, logger: logger}
+	for _, o := range options {
+		o(c)
+	}
+	return c
+}
+
+// Middleware returns the endpoint middleware of method routing its share of
+// the calls to alt. In shadow mode, alt must have no side effect the
+// primary endpoint already has, as both serve the call.
+func (c *Canary) Middleware(method string, alt endpoint.Endpoint) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if rand.Float64()*100 >= c.percent {
+				c.calls.With("method", method, "target", TargetPrimary).Add(1)
+				return next(ctx, request)
+			}
+			if !c.shadow {
+				c.calls.With("method", method, "target", TargetCanary).Add(1)
+				return alt(ctx, request)
+			}
+
+			c.calls.With("method", method, "target", TargetShadow).Add(1)
+			shadowed := make(chan outcome, 1)
+			go func() {
+				sctx, cancel := context.WithTimeout(detach(ctx), c.timeout)
+				defer cancel()
+				response, err := alt(sctx, request)
+				shadowed <- outcome{response, err}
+			}()
+			response, err := next(ctx, request)
+			go c.compare(method, outcome{response, err}, shadowed)
+			return response, err
+		}
+	}
+}
+
+// outcome is the response of an endpoint, or its error.
+type outcome struct {
+	response interface{}
+	err      error
+}
+
+func (c *Canary) compare(method string, primary outcome, shadowed <-chan outcome) {
+	shadow := <-shadowed
+	result := ResultMatch
+	switch {
+	case shadow.err != nil && primary.err == nil:
+		result = ResultError
+		level.Warn(c.logger).Log("method", method, "canary", "error", "err", shadow.err)
+	case (shadow.err != nil) != (primary.err != nil) || !c.equal(primary.response, shadow.response):
+		result = ResultDiverged
+		level.Warn(c.logger).Log("method", method, "canary", "diverged", "primary", describe(primary), "shadow", describe(shadow))
+	}
+	c.results.With("method", method, "result", result).Add(1)
+}
+
+func describe(o outcome) string {
+	if o.err != nil {
+		return o.err.Error()
+	}
+	return fmt.Sprintf("%+v", o.response)
+}
+
+// detached is a context carrying the values of its parent without its
+// deadline and cancellation, for the shadow calls to outlive the request.
+type detached struct {
+	context.Context
+}
+
+func detach(ctx context.Context) context.Context {
+	return detached{ctx}
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
@@ -0,0 +1,181 @@
+// Package canonjson encodes values as canonical JSON, byte for byte the same
+// for equal values whatever the Go version or the map iteration order, so
+// consumers can sign or hash the responses. The encoding follows RFC 8785:
+// object keys sorted by their UTF-16 code units, no insignificant
+// whitespace, minimal string escaping and ECMAScript number formatting. As a
+// deviation, integers are kept exact instead of being rounded to float64.
+package canonjson
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// ErrUnsupportedValue indicates a value without canonical encoding, such as
+// a NaN or infinite number.
+var ErrUnsupportedValue = errors.New("value has no canonical JSON encoding")
+
+const hex = "0123456789abcdef"
+
+// Marshal returns the canonical JSON encoding of v. v is first encoded with
+// encoding/json, so json tags and Marshaler implementations apply.
+func Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(&buf)
+	dec.UseNumber()
+	var tree interface{}
+	if err := dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	if err := write(&out, tree); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// write writes the canonical encoding of the decoded JSON value v.
+func write(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case string:
+		writeString(buf, v)
+	case json.Number:
+		s, err := formatNumber(v)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(s)
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := write(buf, e); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return lessUTF16(keys[i], keys[j]) })
+
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeString(buf, k)
+			buf.WriteByte(':')
+			if err := write(buf, v[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return ErrUnsupportedValue
+	}
+	return nil
+}
+
+// lessUTF16 orders a and b by their UTF-16 code units, as RFC 8785 sorts
+// object keys. It differs from the byte order of UTF-8 only for characters
+// outside the Basic Multilingual Plane.
+func lessUTF16(a, b string) bool {
+	ua, ub := utf16.Encode([]rune(a)), utf16.Encode([]rune(b))
+	for i := 0; i < len(ua) && i < len(ub); i++ {
+		if ua[i] != ub[i] {
+			return ua[i] < ub[i]
+		}
+	}
+	return len(ua) < len(ub)
+}
+
+// writeString writes s quoted, escaping only the quote, the backslash and
+// the control characters, the latter with their short form when they have
+// one. encoding/json also escapes U+2028 and U+2029, RFC 8785 doesn't.
+func writeString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '"', '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if c < 0x20 {
+				buf.WriteString(`\u00`)
+				buf.WriteByte(hex[c>>4])
+				buf.WriteByte(hex[c&0xf])
+				continue
+			}
+			buf.WriteByte(c)
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// formatNumber returns the canonical form of n. Integer literals are kept
+// as they are, so integers beyond 2^53 survive; other numbers are formatted
+// from their float64 value as ECMAScript does: without exponent from 1e-6 up
+// to 1e21, otherwise with the shortest mantissa and an exponent without
+// leading zeros.
+func formatNumber(n json.Number) (string, error) {
+	s := string(n)
+	if !strings.ContainsAny(s, ".eE") {
+		if s == "-0" {
+			return "0", nil
+		}
+		return s, nil
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return "", errors.Wrap(ErrUnsupportedValue, err)
+	}
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return "", ErrUnsupportedValue
+	}
+	if f == 0 {
+		return "0", nil
+	}
+	if abs := math.Abs(f); abs >= 1e-6 && abs < 1e21 {
+		return strconv.FormatFloat(f, 'f', -1, 64), nil
+	}
+
+	s = strconv.FormatFloat(f, 'e', -1, 64)
+	i := strings.IndexByte(s, 'e')
+	mant, sign, exp := s[:i], s[i+1], strings.TrimLeft(s[i+2:], "0")
+	return mant + "e" + string(sign) + exp, nil
+}
@@ -0,0 +1,106 @@
+package canonjson
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+func TestMarshal(t *testing.T) {
+	type tagged struct {
+		Z      int    `json:"z"`
+		A      string `json:"a"`
+		Hidden string `json:"-"`
+		Empty  string `json:"empty,omitempty"`
+	}
+	cases := []struct {
+		name string
+		in   interface{}
+		want string
+	}{
+		{"null", nil, `null`},
+		{"bool", true, `true`},
+		{"sorted keys", map[string]int{"b": 2, "a": 1, "c": 3}, `{"a":1,"b":2,"c":3}`},
+		{"nested", map[string]interface{}{"b": []interface{}{map[string]int{"y": 1, "x": 2}}, "a": nil}, `{"a":null,"b":[{"x":2,"y":1}]}`},
+		{"struct tags", tagged{Z: 1, A: "x", Hidden: "h"}, `{"a":"x","z":1}`},
+		{"utf-16 key order", map[string]int{"\U0001F600": 1, "דּ": 2}, `{"` + "\U0001F600" + `":1,"` + "דּ" + `":2}`},
+		{"html unescaped", "<a&b>", `"<a&b>"`},
+		{"line separators unescaped", "  ", `"` + "  " + `"`},
+		{"short escapes", "\"\\\b\f\n\r\t", `"\"\\\b\f\n\r\t"`},
+		{"control escape", "\x01\x1f", `"\u0001\u001f"`},
+		{"big integer exact", json.Number("9007199254740993"), `9007199254740993`},
+		{"negative zero integer", json.Number("-0"), `0`},
+		{"zero float", 0.0, `0`},
+		{"negative zero float", json.Number("-0.0"), `0`},
+		{"fraction", 4.5, `4.5`},
+		{"small fraction", 0.002, `0.002`},
+		{"exponent normalized", json.Number("1E3"), `1000`},
+		{"large", 1e30, `1e+30`},
+		{"tiny", 1e-7, `1e-7`},
+		{"lower bound", 1e-6, `0.000001`},
+		{"upper bound", 1e21, `1e+21`},
+		{"below upper bound", 1e20, `100000000000000000000`},
+		{"shortest", 333333333.33333329, `333333333.3333333`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Marshal(tc.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("Marshal(%#v) = %s, want %s", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+// TestMarshalStable checks maps, iterated in random order, always encode
+// the same.
+func TestMarshalStable(t *testing.T) {
+	v := map[string]interface{}{}
+	for _, k := range []string{"q", "w", "e", "r", "t", "y", "u", "i", "o", "p", "ä", "Z", "1"} {
+		v[k] = map[string]interface{}{k + "2": k, k + "1": []interface{}{1.5, k}}
+	}
+	want, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		got, err := Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(want) {
+			t.Fatalf("Marshal is unstable:\n%s\n%s", got, want)
+		}
+	}
+}
+
+// TestMarshalIdempotent checks canonical JSON, decoded, encodes to itself.
+func TestMarshalIdempotent(t *testing.T) {
+	for _, in := range []string{
+		`{"a":[1,2.5,"x",null,true],"b":{"c":1e+30}}`,
+		`{"":0,"a":-1,"aa":1e-7}`,
+	} {
+		var v json.RawMessage = []byte(in)
+		got, err := Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != in {
+			t.Errorf("Marshal(%s) = %s", in, got)
+		}
+	}
+}
+
+func TestMarshalUnsupported(t *testing.T) {
+	if _, err := Marshal(json.Number("1e999")); !errors.Contains(errors.Cast(err), ErrUnsupportedValue) {
+		t.Errorf("Marshal(1e999) err = %v, want %v", err, ErrUnsupportedValue)
+	}
+	if _, err := Marshal(math.NaN()); err == nil {
+		t.Error("Marshal(NaN) err = nil")
+	}
+}
@@ -0,0 +1,146 @@
+package capability
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// QueryParam is the URL query parameter carrying the capability token.
+const QueryParam = "access_token"
+
+var (
+	// ErrInvalidToken indicates a malformed token or a bad signature.
+	ErrInvalidToken = errors.Register(errors.KindUnauthenticated, errors.NewCoded("CAP-001", "invalid capability token"))
+
+	// ErrTokenExpired indicates the token lifetime has passed.
+	ErrTokenExpired = errors.Register(errors.KindUnauthenticated, errors.NewCoded("CAP-002", "capability token expired"))
+
+	// ErrTokenRevoked indicates the operation the token grants access to has
+	// completed.
+	ErrTokenRevoked = errors.Register(errors.KindPermissionDenied, errors.NewCoded("CAP-003", "capability token revoked"))
+
+	// ErrScopeMismatch indicates the token was issued for another operation or scope.
+	ErrScopeMismatch = errors.Register(errors.KindPermissionDenied, errors.NewCoded("CAP-004", "capability token scope mismatch"))
+)
+
+// Scope limits what a capability token may be used for.
+type Scope string
+
+// ScopeDownload allows fetching the operation result.
+const ScopeDownload Scope = "download"
+
+type claims struct {
+	Operation string `json:"op"`
+	Scope     Scope  `json:"scope"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Issuer issues and verifies short-lived capability tokens bound to a single
+// operation and scope, so a caller can download an operation result without
+// re-authenticating.
+type Issuer interface {
+	// Issue returns a token granting scope on operation for ttl.
+	Issue(operation string, scope Scope, ttl time.Duration) (string, error)
+
+	// Verify checks token grants scope on operation.
+	Verify(ctx context.Context, token string, operation string, scope Scope) error
+
+	// Revoke invalidates every token issued for operation, once it
+	// completed.
+	Revoke(ctx context.Context, operation string) error
+
+	// SignURL returns u with a token granting scope on operation for ttl.
+	SignURL(u *url.URL, operation string, scope Scope, ttl time.Duration) (*url.URL, error)
+}
+
+type issuer struct {
+	key         []byte
+	maxTTL      time.Duration
+	revocations Revocations
+}
+
+// New returns an Issuer signing tokens with key, keeping its revocations in
+// revocations. Token lifetimes are capped at maxTTL, which is also how long
+// revocations are kept.
+func New(key []byte, maxTTL time.Duration, revocations Revocations) Issuer {
+	return &issuer{key: key, maxTTL: maxTTL, revocations: revocations}
+}
+
+func (i *issuer) Issue(operation string, scope Scope, ttl time.Duration) (string, error) {
+	if ttl <= 0 || ttl > i.maxTTL {
+		ttl = i.maxTTL
+	}
+	payload, err := json.Marshal(claims{
+		Operation: operation,
+		Scope:     scope,
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding.EncodeToString(payload)
+	return enc + "." + i.sign(enc), nil
+}
+
+func (i *issuer) Verify(ctx context.Context, token string, operation string, scope Scope) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(i.sign(parts[0]))) {
+		return ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return ErrInvalidToken
+	}
+	var c claims
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return ErrInvalidToken
+	}
+
+	if time.Now().Unix() >= c.ExpiresAt {
+		return ErrTokenExpired
+	}
+	if c.Operation != operation || c.Scope != scope {
+		return ErrScopeMismatch
+	}
+
+	revoked, err := i.revocations.Revoked(ctx, operation)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// Revoke revokes the tokens of operation for maxTTL, by when the last of
+// them expired.
+func (i *issuer) Revoke(ctx context.Context, operation string) error {
+	return i.revocations.Revoke(ctx, operation, time.Now().Add(i.maxTTL))
+}
+
+func (i *issuer) SignURL(u *url.URL, operation string, scope Scope, ttl time.Duration) (*url.URL, error) {
+	token, err := i.Issue(operation, scope, ttl)
+	if err != nil {
+		return nil, err
+	}
+	next := *u
+	q := next.Query()
+	q.Set(QueryParam, token)
+	next.RawQuery = q.Encode()
+	return &next, nil
+}
+
+func (i *issuer) sign(payload string) string {
+	mac := hmac.New(sha256.New, i.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
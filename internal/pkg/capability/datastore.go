@@ -0,0 +1,47 @@
+package capability
+
+import (
+	"context"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/gcp"
+)
+
+// Kind is the Datastore kind of the revocations, keyed by operation.
+const Kind = "CapabilityRevocation"
+
+type datastoreRevocations struct {
+	ds *gcp.Datastore
+}
+
+// NewDatastoreRevocations returns Revocations shared by the instances,
+// kept in Datastore. The lapsed ones are left to a TTL policy on the
+// expiresAt property of the kind.
+func NewDatastoreRevocations(ds *gcp.Datastore) Revocations {
+	return &datastoreRevocations{ds: ds}
+}
+
+// revocationProperties are the properties of a revocation entity.
+type revocationProperties struct {
+	ExpiresAt struct {
+		TimestampValue time.Time `json:"timestampValue"`
+	} `json:"expiresAt"`
+}
+
+func (r *datastoreRevocations) Revoke(ctx context.Context, operation string, until time.Time) error {
+	return r.ds.Commit(ctx, map[string]interface{}{"upsert": map[string]interface{}{
+		"key": r.ds.Key(Kind, operation),
+		"properties": map[string]interface{}{
+			"expiresAt": map[string]interface{}{"timestampValue": until.UTC().Format(time.RFC3339Nano)},
+		},
+	}})
+}
+
+func (r *datastoreRevocations) Revoked(ctx context.Context, operation string) (bool, error) {
+	var p revocationProperties
+	found, err := r.ds.Lookup(ctx, "", r.ds.Key(Kind, operation), &p)
+	if err != nil {
+		return false, err
+	}
+	return found && time.Now().Before(p.ExpiresAt.TimestampValue), nil
+}
@@ -0,0 +1,26 @@
+package capability
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+// NewVerifier returns an endpoint middleware that only lets the request
+// through when the context carries a capability token granting scope on the
+// operation the request refers to. operation extracts the operation ID from
+// the decoded request.
+func NewVerifier(issuer Issuer, scope Scope, operation func(request interface{}) string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			token, ok := FromContext(ctx)
+			if !ok {
+				return nil, ErrInvalidToken
+			}
+			if err := issuer.Verify(ctx, token, operation(request), scope); err != nil {
+				return nil, err
+			}
+			return next(ctx, request)
+		}
+	}
+}
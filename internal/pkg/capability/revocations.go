@@ -0,0 +1,51 @@
+package capability
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Revocations keeps the operations whose tokens are revoked. Deployments
+// running more than one instance need a shared implementation, such as
+// NewDatastoreRevocations, so a token revoked on one instance is refused by
+// the others.
+type Revocations interface {
+	// Revoke revokes the tokens of operation until until, by when they all
+	// expired.
+	Revoke(ctx context.Context, operation string, until time.Time) error
+
+	// Revoked reports whether the tokens of operation are revoked.
+	Revoked(ctx context.Context, operation string) (bool, error)
+}
+
+type memoryRevocations struct {
+	mu      sync.Mutex
+	revoked map[string]time.Time
+}
+
+// NewMemoryRevocations returns instance-local Revocations.
+func NewMemoryRevocations() Revocations {
+	return &memoryRevocations{revoked: make(map[string]time.Time)}
+}
+
+func (m *memoryRevocations) Revoke(_ context.Context, operation string, until time.Time) error {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for op, u := range m.revoked {
+		if now.After(u) {
+			delete(m.revoked, op)
+		}
+	}
+	m.revoked[operation] = until
+	return nil
+}
+
+func (m *memoryRevocations) Revoked(_ context.Context, operation string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	until, ok := m.revoked[operation]
+	return ok && time.Now().Before(until), nil
+}
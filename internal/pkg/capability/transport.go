@@ -0,0 +1,30 @@
+package capability
+
+import (
+	"context"
+	"net/http"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+type contextKey string
+
+// TokenContextKey holds the capability token taken from the request URL.
+const TokenContextKey contextKey = "CapabilityToken"
+
+// HTTPToContext moves the capability token from the request URL into the context.
+func HTTPToContext() httptransport.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		token := r.URL.Query().Get(QueryParam)
+		if token == "" {
+			return ctx
+		}
+		return context.WithValue(ctx, TokenContextKey, token)
+	}
+}
+
+// FromContext returns the capability token stored by HTTPToContext.
+func FromContext(ctx context.Context) (string, bool) {
+	token, ok := ctx.Value(TokenContextKey).(string)
+	return token, ok && token != ""
+}
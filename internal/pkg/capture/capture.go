@@ -0,0 +1,264 @@
+// Package capture records sanitized request/response pairs of one route for
+// a limited time, so hard to trigger bugs reported by users can be
+// reproduced. Captures are started by operators and bounded in duration,
+// count and size.
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// The bounds of a capture session.
+const (
+	MaxDuration  = time.Hour
+	MaxCount     = 1000
+	MaxBodyBytes = 1 << 20
+)
+
+var (
+	// ErrInvalidSession indicates a capture session is missing its route or
+	// exceeds the bounds.
+	ErrInvalidSession = errors.NewCoded("CAPTURE-001", "invalid capture session")
+
+	// ErrNoSession indicates no capture session is running.
+	ErrNoSession = errors.NewCoded("CAPTURE-002", "no capture session")
+)
+
+// Sink stores the captured exchanges, e.g. a gcp.Bucket.
+type Sink interface {
+	Upload(ctx context.Context, name, contentType string, data []byte) error
+}
+
+// Session captures up to MaxCount requests to Route until Until, keeping at
+// most MaxBytes of every body. Route is "METHOD /path" or "/path" for all
+// methods.
+type Session struct {
+	ID        string    `json:"id"`
+	Route     string    `json:"route"`
+	Until     time.Time `json:"until"`
+	MaxCount  int       `json:"max_count"`
+	MaxBytes  int       `json:"max_bytes"`
+	Captured  int       `json:"captured"`
+	StartedBy string    `json:"started_by,omitempty"`
+}
+
+func (s Session) validate() error {
+	switch {
+	case s.Route == "":
+		return errors.Wrap(ErrInvalidSession, errors.New("missing route"))
+	case s.MaxCount <= 0 || s.MaxCount > MaxCount:
+		return errors.Wrap(ErrInvalidSession, fmt.Errorf("max_count must be within 1 and %d", MaxCount))
+	case s.MaxBytes <= 0 || s.MaxBytes > MaxBodyBytes:
+		return errors.Wrap(ErrInvalidSession, fmt.Errorf("max_bytes must be within 1 and %d", MaxBodyBytes))
+	case !s.Until.After(time.Now()) || time.Until(s.Until) > MaxDuration:
+		return errors.Wrap(ErrInvalidSession, fmt.Errorf("duration must be within 0 and %s", MaxDuration))
+	}
+	return nil
+}
+
+func (s Session) match(r *http.Request) bool {
+	route := s.Route
+	if i := strings.IndexByte(route, ' '); i > 0 {
+		if !strings.EqualFold(route[:i], r.Method) {
+			return false
+		}
+		route = strings.TrimSpace(route[i+1:])
+	}
+	return r.URL.Path == route
+}
+
+// Message is one side of a captured exchange.
+type Message struct {
+	Method    string          `json:"method,omitempty"`
+	URL       string          `json:"url,omitempty"`
+	Status    int             `json:"status,omitempty"`
+	Header    http.Header     `json:"header"`
+	Body      json.RawMessage `json:"body,omitempty"`
+	Truncated bool            `json:"truncated,omitempty"`
+}
+
+// Exchange is a captured request/response pair.
+type Exchange struct {
+	Session  string    `json:"session"`
+	Seq      int       `json:"seq"`
+	Time     time.Time `json:"time"`
+	Duration string    `json:"duration"`
+	Request  Message   `json:"request"`
+	Response Message   `json:"response"`
+}
+
+// Recorder runs at most one capture session at a time, writing every
+// captured exchange to its sink as prefix/<session>/<seq>.json.
+type Recorder struct {
+	sink   Sink
+	prefix string
+	logger log.Logger
+
+	mu      sync.Mutex
+	session *Session
+}
+
+// NewRecorder returns a Recorder with no session running.
+func NewRecorder(sink Sink, prefix string, logger log.Logger) *Recorder {
+	return &Recorder{sink: sink, prefix: strings.Trim(prefix, "/"), logger: logger}
+}
+
+// Start starts s, replacing the running session if any.
+func (rec *Recorder) Start(s Session) (Session, error) {
+	if err := s.validate(); err != nil {
+		return Session{}, err
+	}
+	s.ID = time.Now().UTC().Format("20060102T150405Z")
+	s.Captured = 0
+
+	rec.mu.Lock()
+	rec.session = &s
+	rec.mu.Unlock()
+	level.Info(rec.logger).Log("capture", "start", "session", s.ID, "route", s.Route, "until", s.Until, "started_by", s.StartedBy)
+	return s, nil
+}
+
+// Stop stops the running session and returns it.
+func (rec *Recorder) Stop() (Session, error) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.session == nil {
+		return Session{}, ErrNoSession
+	}
+	s := *rec.session
+	rec.session = nil
+	level.Info(rec.logger).Log("capture", "stop", "session", s.ID, "captured", s.Captured)
+	return s, nil
+}
+
+// Current returns the running session.
+func (rec *Recorder) Current() (Session, error) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.session == nil || time.Now().After(rec.session.Until) {
+		rec.session = nil
+		return Session{}, ErrNoSession
+	}
+	return *rec.session, nil
+}
+
+// claim reserves a capture slot of the running session for r.
+func (rec *Recorder) claim(r *http.Request) (Session, bool) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	s := rec.session
+	if s == nil || !s.match(r) {
+		return Session{}, false
+	}
+	if s.Captured >= s.MaxCount || time.Now().After(s.Until) {
+		level.Info(rec.logger).Log("capture", "done", "session", s.ID, "captured", s.Captured)
+		rec.session = nil
+		return Session{}, false
+	}
+	s.Captured++
+	return *s, true
+}
+
+// Middleware returns an http middleware capturing the requests matching the
+// running session. Requests outside of a session only pay a mutex.
+func (rec *Recorder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, ok := rec.claim(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ex := Exchange{Session: s.ID, Seq: s.Captured, Time: time.Now().UTC()}
+		ex.Request = Message{Method: r.Method, URL: sanitizeURL(r.URL), Header: sanitizeHeader(r.Header)}
+		if r.Body != nil {
+			b, _ := ioutil.ReadAll(io.LimitReader(r.Body, int64(s.MaxBytes)+1))
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(b), r.Body), r.Body}
+			ex.Request.Body, ex.Request.Truncated = sanitizeBody(b, s.MaxBytes)
+		}
+
+		cw := &captureWriter{ResponseWriter: w, max: s.MaxBytes, status: http.StatusOK}
+		next.ServeHTTP(cw, r)
+
+		ex.Duration = time.Since(ex.Time).String()
+		ex.Response = Message{Status: cw.status, Header: sanitizeHeader(w.Header())}
+		ex.Response.Body, ex.Response.Truncated = sanitizeBody(cw.body.Bytes(), s.MaxBytes)
+		go rec.save(ex)
+	})
+}
+
+// save writes ex out of the request path.
+func (rec *Recorder) save(ex Exchange) {
+	b, err := json.MarshalIndent(ex, "", "  ")
+	if err != nil {
+		level.Warn(rec.logger).Log("capture", "save", "session", ex.Session, "err", err)
+		return
+	}
+	name := fmt.Sprintf("%s/%04d.json", ex.Session, ex.Seq)
+	if rec.prefix != "" {
+		name = rec.prefix + "/" + name
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := rec.sink.Upload(ctx, name, "application/json", b); err != nil {
+		level.Warn(rec.logger).Log("capture", "save", "session", ex.Session, "object", name, "err", err)
+	}
+}
+
+// captureWriter keeps the status and up to max+1 bytes of the response body.
+type captureWriter struct {
+	http.ResponseWriter
+	max    int
+	status int
+	body   bytes.Buffer
+}
+
+func (w *captureWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	if n := w.max + 1 - w.body.Len(); n > 0 {
+		if n > len(b) {
+			n = len(b)
+		}
+		w.body.Write(b[:n])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush keeps server-sent events streaming while captured.
+func (w *captureWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack keeps websocket upgrades working while captured.
+func (w *captureWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return h.Hijack()
+}
@@ -0,0 +1,279 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Source lists and reads the exchanges a Recorder saved.
+type Source interface {
+	// List returns the names of the exchanges of session, in order.
+	List(ctx context.Context, session string) ([]string, error)
+	// Read returns the exchange name.
+	Read(ctx context.Context, name string) ([]byte, error)
+}
+
+// Bucket is the part of gcp.Bucket a Source reads.
+type Bucket interface {
+	List(ctx context.Context, prefix string) ([]string, error)
+	Download(ctx context.Context, name string) ([]byte, error)
+}
+
+type bucketSource struct {
+	bucket Bucket
+	prefix string
+}
+
+// NewBucketSource returns a Source reading the exchanges a Recorder of
+// prefix saved to bucket.
+func NewBucketSource(bucket Bucket, prefix string) Source {
+	return bucketSource{bucket: bucket, prefix: strings.Trim(prefix, "/")}
+}
+
+func (s bucketSource) List(ctx context.Context, session string) ([]string, error) {
+	prefix := session + "/"
+	if s.prefix != "" {
+		prefix = s.prefix + "/" + prefix
+	}
+	names, err := s.bucket.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s bucketSource) Read(ctx context.Context, name string) ([]byte, error) {
+	return s.bucket.Download(ctx, name)
+}
+
+type dirSource struct {
+	dir string
+}
+
+// NewDirSource returns a Source reading the exchanges from dir, laid out as
+// in the bucket, e.g. after gsutil cp -r.
+func NewDirSource(dir string) Source {
+	return dirSource{dir: dir}
+}
+
+func (s dirSource) List(_ context.Context, session string) ([]string, error) {
+	names, err := filepath.Glob(filepath.Join(s.dir, session, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no exchanges in %s", filepath.Join(s.dir, session))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s dirSource) Read(_ context.Context, name string) ([]byte, error) {
+	return ioutil.ReadFile(name)
+}
+
+// DefaultIgnored are the fields of the response bodies which differ on every
+// call, ignored when comparing the replayed responses to the captured ones.
+var DefaultIgnored = []string{"timestamp", "trace_id", "stack_id", "stack", "createdAt", "id"}
+
+// Result is the outcome of replaying an exchange.
+type Result struct {
+	Exchange Exchange
+	Status   int
+	// Diffs tell how the replayed response differs from the captured one.
+	Diffs []string
+	// Redacted tells the request was sanitized when captured, so the
+	// replay may legitimately differ.
+	Redacted bool
+	Err      error
+}
+
+// OK reports whether the replayed response matches the captured one.
+func (r Result) OK() bool {
+	return r.Err == nil && len(r.Diffs) == 0
+}
+
+// Replayer sends captured requests to a target instance and compares its
+// responses to the captured ones, for regression testing.
+type Replayer struct {
+	target  string
+	client  *http.Client
+	header  http.Header
+	ignored map[string]bool
+}
+
+// NewReplayer returns a Replayer sending the requests to the target base
+// URL with client, header replacing the headers redacted when captured,
+// such as Authorization, and ignoring the ignored fields of the bodies,
+// DefaultIgnored when nil.
+func NewReplayer(target string, client *http.Client, header http.Header, ignored []string) *Replayer {
+	if ignored == nil {
+		ignored = DefaultIgnored
+	}
+	r := &Replayer{target: strings.TrimRight(target, "/"), client: client, header: header, ignored: map[string]bool{}}
+	for _, f := range ignored {
+		r.ignored[f] = true
+	}
+	return r
+}
+
+// Replay replays every exchange of session of src, calling report with
+// the result of each, in order.
+func (r *Replayer) Replay(ctx context.Context, src Source, session string, report func(Result)) error {
+	names, err := src.List(ctx, session)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		b, err := src.Read(ctx, name)
+		if err != nil {
+			return err
+		}
+		var ex Exchange
+		if err := json.Unmarshal(b, &ex); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		report(r.ReplayExchange(ctx, ex))
+	}
+	return nil
+}
+
+// ReplayExchange replays ex.
+func (r *Replayer) ReplayExchange(ctx context.Context, ex Exchange) Result {
+	res := Result{Exchange: ex, Redacted: redactedMessage(ex.Request)}
+	if ex.Request.Truncated {
+		res.Err = fmt.Errorf("request body exceeded the capture bound")
+		return res
+	}
+	req, err := http.NewRequest(ex.Request.Method, r.target+ex.Request.URL, bytes.NewReader(requestBody(ex.Request.Body)))
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	for k, v := range ex.Request.Header {
+		if len(v) == 1 && v[0] == redacted {
+			continue
+		}
+		req.Header[k] = v
+	}
+	req.Header.Del("Content-Length")
+	for k, v := range r.header {
+		req.Header[k] = v
+	}
+
+	resp, err := r.client.Do(req.WithContext(ctx))
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	res.Status = resp.StatusCode
+	if resp.StatusCode != ex.Response.Status {
+		res.Diffs = append(res.Diffs, fmt.Sprintf("status: captured %d, replayed %d", ex.Response.Status, resp.StatusCode))
+	}
+	if !ex.Response.Truncated {
+		res.Diffs = append(res.Diffs, r.diffBodies(ex.Response.Body, body)...)
+	}
+	return res
+}
+
+// requestBody returns the bytes of a captured body, the strings non-JSON
+// bodies are kept as being unquoted.
+func requestBody(raw json.RawMessage) []byte {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return []byte(s)
+	}
+	return raw
+}
+
+func redactedMessage(m Message) bool {
+	if bytes.Contains(m.Body, []byte(`"`+redacted+`"`)) || strings.Contains(m.URL, redacted) {
+		return true
+	}
+	for _, v := range m.Header {
+		if len(v) == 1 && v[0] == redacted {
+			return true
+		}
+	}
+	return false
+}
+
+// diffBodies compares the captured body with the replayed one, as JSON
+// without the ignored fields when both are, else as strings.
+func (r *Replayer) diffBodies(captured json.RawMessage, replayed []byte) []string {
+	var want, got interface{}
+	if len(captured) > 0 {
+		json.Unmarshal(captured, &want)
+	}
+	if len(replayed) > 0 {
+		if err := json.Unmarshal(replayed, &got); err != nil {
+			got = string(replayed)
+		}
+	}
+	var diffs []string
+	r.diff("body", r.strip(want), r.strip(got), &diffs)
+	return diffs
+}
+
+// strip removes the ignored fields of v, and the redacted values, which
+// can't be compared.
+func (r *Replayer) strip(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if r.ignored[k] || e == redacted {
+				delete(v, k)
+				continue
+			}
+			v[k] = r.strip(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = r.strip(e)
+		}
+	}
+	return v
+}
+
+func (r *Replayer) diff(path string, want, got interface{}, diffs *[]string) {
+	wm, wok := want.(map[string]interface{})
+	gm, gok := got.(map[string]interface{})
+	if wok && gok {
+		keys := map[string]bool{}
+		for k := range wm {
+			keys[k] = true
+		}
+		for k := range gm {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			r.diff(path+"."+k, wm[k], gm[k], diffs)
+		}
+		return
+	}
+	if !reflect.DeepEqual(want, got) {
+		w, _ := json.Marshal(want)
+		g, _ := json.Marshal(got)
+		*diffs = append(*diffs, fmt.Sprintf("%s: captured %s, replayed %s", path, w, g))
+	}
+}
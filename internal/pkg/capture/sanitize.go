@@ -0,0 +1,97 @@
+package capture
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const redacted = "REDACTED"
+
+// sensitiveHeaders carry credentials and are never captured.
+var sensitiveHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Signature",
+	"X-Api-Key",
+	"X-Goog-Iap-Jwt-Assertion",
+}
+
+// sensitiveNames are the fragments of query parameter and JSON field names
+// whose values are never captured.
+var sensitiveNames = []string{"password", "secret", "token", "key", "signature", "credential"}
+
+func sensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range sensitiveNames {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func sanitizeHeader(h http.Header) http.Header {
+	out := make(http.Header, len(h))
+	for k, v := range h {
+		out[k] = v
+	}
+	for _, k := range sensitiveHeaders {
+		if _, ok := out[k]; ok {
+			out[k] = []string{redacted}
+		}
+	}
+	return out
+}
+
+func sanitizeURL(u *url.URL) string {
+	q := u.Query()
+	for k := range q {
+		if sensitive(k) {
+			q[k] = []string{redacted}
+		}
+	}
+	s := *u
+	s.RawQuery = q.Encode()
+	return s.RequestURI()
+}
+
+// sanitizeBody returns the body as JSON, with the sensitive fields redacted.
+// Bodies which aren't JSON are kept as strings. Bodies exceeding max can't be
+// sanitized and are dropped.
+func sanitizeBody(b []byte, max int) (json.RawMessage, bool) {
+	if len(b) == 0 {
+		return nil, false
+	}
+	if len(b) > max {
+		return nil, true
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		raw, _ := json.Marshal(string(b))
+		return raw, false
+	}
+	raw, _ := json.Marshal(redact(v))
+	return raw, false
+}
+
+func redact(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if sensitive(k) {
+				v[k] = redacted
+				continue
+			}
+			v[k] = redact(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = redact(e)
+		}
+	}
+	return v
+}
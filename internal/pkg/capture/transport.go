@@ -0,0 +1,147 @@
+package capture
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/go-zoo/bone"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/auth"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/responses"
+)
+
+// Path is where the capture sessions are managed.
+const Path = "/api/admin/capture"
+
+// Scope is the JWT scope required to manage capture sessions.
+const Scope = "capture:admin"
+
+// The defaults of a capture session.
+const (
+	defaultDuration = 10 * time.Minute
+	defaultCount    = 100
+	defaultBytes    = 64 << 10
+)
+
+// ErrForbidden indicates the caller lacks the Scope.
+var ErrForbidden = errors.NewCoded("CAPTURE-003", "capture requires the "+Scope+" scope")
+
+type startRequest struct {
+	Route    string `json:"route"`
+	Duration string `json:"duration"`
+	MaxCount int    `json:"max_count"`
+	MaxBytes int    `json:"max_bytes"`
+}
+
+// MakeHTTPHandler returns a handler managing the capture session of rec at
+// Path: GET returns the running session, PUT starts one and DELETE stops it.
+// Callers need a JWT verified by authn, e.g. a kitjwt.NewParser middleware,
+// granting Scope.
+func MakeHTTPHandler(rec *Recorder, authn endpoint.Middleware, logger log.Logger) http.Handler {
+	options := []httptransport.ServerOption{
+		httptransport.ServerErrorEncoder(encodeError),
+		httptransport.ServerErrorLogger(logger),
+		httptransport.ServerBefore(kitjwt.HTTPToContext()),
+	}
+	noRequest := func(context.Context, *http.Request) (interface{}, error) { return nil, nil }
+
+	m := bone.New()
+	m.Get(Path, httptransport.NewServer(
+		authn(authorize(func(context.Context, interface{}) (interface{}, error) { return rec.Current() })),
+		noRequest,
+		encodeResponse,
+		options...,
+	))
+	m.Put(Path, httptransport.NewServer(
+		authn(authorize(makeStartEndpoint(rec))),
+		decodeStartRequest,
+		encodeResponse,
+		options...,
+	))
+	m.Delete(Path, httptransport.NewServer(
+		authn(authorize(func(context.Context, interface{}) (interface{}, error) { return rec.Stop() })),
+		noRequest,
+		encodeResponse,
+		options...,
+	))
+	return m
+}
+
+func authorize(next endpoint.Endpoint) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		if !auth.HasScope(ctx, Scope) {
+			return nil, ErrForbidden
+		}
+		return next(ctx, request)
+	}
+}
+
+func makeStartEndpoint(rec *Recorder) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(startRequest)
+		d := defaultDuration
+		if req.Duration != "" {
+			var err error
+			if d, err = time.ParseDuration(req.Duration); err != nil {
+				return nil, errors.Wrap(ErrInvalidSession, err)
+			}
+		}
+		s := Session{
+			Route:     req.Route,
+			Until:     time.Now().Add(d),
+			MaxCount:  req.MaxCount,
+			MaxBytes:  req.MaxBytes,
+			StartedBy: auth.Principal(ctx),
+		}
+		if s.MaxCount == 0 {
+			s.MaxCount = defaultCount
+		}
+		if s.MaxBytes == 0 {
+			s.MaxBytes = defaultBytes
+		}
+		return rec.Start(s)
+	}
+}
+
+func decodeStartRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req startRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	return req, err
+}
+
+func encodeResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(responses.DataRes{Data: response})
+}
+
+func encodeError(_ context.Context, err error, w http.ResponseWriter) {
+	code := http.StatusInternalServerError
+	ce := errors.Cast(err)
+	switch {
+	case errors.Contains(ce, kitjwt.ErrTokenContextMissing), errors.Contains(ce, kitjwt.ErrTokenInvalid),
+		errors.Contains(ce, kitjwt.ErrTokenExpired), errors.Contains(ce, kitjwt.ErrTokenMalformed),
+		errors.Contains(ce, kitjwt.ErrTokenNotActive):
+		code = http.StatusUnauthorized
+	case errors.Contains(ce, ErrForbidden):
+		code = http.StatusForbidden
+	case errors.Contains(ce, ErrNoSession):
+		code = http.StatusNotFound
+	case errors.Contains(ce, ErrInvalidSession):
+		code = http.StatusBadRequest
+	default:
+		switch err.(type) {
+		case *json.SyntaxError, *json.UnmarshalTypeError:
+			code = http.StatusBadRequest
+		}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(responses.ErrorRes{Error: responses.ErrorResItem{Code: code, ErrorCode: errors.Code(err), Message: ce.Msg(), Errors: ce.Errors()}})
+}
@@ -0,0 +1,118 @@
+// Package chain composes the endpoint middlewares in an order declared once,
+// typically from configuration, instead of every endpoint wiring its own:
+// the stages of a Chain always wrap an endpoint in the same order, the first
+// stage outermost.
+package chain
+
+import (
+	"strings"
+
+	"github.com/go-kit/kit/endpoint"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// Stage names a slot of the chain.
+type Stage string
+
+// The stages known to a Chain.
+const (
+	Logging        Stage = "logging"
+	Tracing        Stage = "tracing"
+	Metrics        Stage = "metrics"
+	Auth           Stage = "auth"
+	RateLimit      Stage = "ratelimit"
+	CircuitBreaker Stage = "circuitbreaker"
+)
+
+// DefaultOrder is the order of the stages, outermost first, unless
+// configured otherwise.
+var DefaultOrder = []Stage{Logging, Tracing, Metrics, Auth, RateLimit, CircuitBreaker}
+
+var (
+	// ErrUnknownStage indicates an order naming a stage that doesn't exist.
+	ErrUnknownStage = errors.New("unknown middleware stage")
+
+	// ErrDuplicateStage indicates an order naming a stage twice.
+	ErrDuplicateStage = errors.New("duplicate middleware stage")
+
+	// ErrMissingStage indicates an order naming a stage no middleware was
+	// given for.
+	ErrMissingStage = errors.New("middleware stage without middleware")
+)
+
+// Factory returns the middleware of a stage for the endpoint of method.
+type Factory func(method string) endpoint.Middleware
+
+// Chain wraps endpoints with the middlewares of its stages. It's immutable,
+// Use returns a new Chain.
+type Chain struct {
+	order     []Stage
+	factories map[Stage]Factory
+}
+
+// New returns a Chain of the stages of order, outermost first, without
+// middlewares yet. Stages left out of order are never applied.
+func New(order []Stage) (*Chain, error) {
+	known := map[Stage]bool{}
+	for _, s := range DefaultOrder {
+		known[s] = true
+	}
+	seen := map[Stage]bool{}
+	for _, s := range order {
+		if !known[s] {
+			return nil, errors.Wrap(ErrUnknownStage, errors.New(string(s)))
+		}
+		if seen[s] {
+			return nil, errors.Wrap(ErrDuplicateStage, errors.New(string(s)))
+		}
+		seen[s] = true
+	}
+	return &Chain{order: order, factories: map[Stage]Factory{}}, nil
+}
+
+// Parse returns the Chain of the comma separated stages of s, such as
+// "logging,tracing,metrics", or of DefaultOrder when s is empty.
+func Parse(s string) (*Chain, error) {
+	if strings.TrimSpace(s) == "" {
+		return New(DefaultOrder)
+	}
+	var order []Stage
+	for _, name := range strings.Split(s, ",") {
+		order = append(order, Stage(strings.ToLower(strings.TrimSpace(name))))
+	}
+	return New(order)
+}
+
+// Order returns the stages of c, outermost first.
+func (c *Chain) Order() []Stage {
+	return append([]Stage(nil), c.order...)
+}
+
+// Use returns a copy of c with f as the middleware factory of stage,
+// replacing the previous one. A nil f disables the stage: Then skips it.
+func (c *Chain) Use(stage Stage, f Factory) *Chain {
+	factories := make(map[Stage]Factory, len(c.factories)+1)
+	for s, f := range c.factories {
+		factories[s] = f
+	}
+	factories[stage] = f
+	return &Chain{order: c.order, factories: factories}
+}
+
+// Then returns e, the endpoint of method, wrapped with the middlewares of
+// the stages of c. It fails with ErrMissingStage when a stage of c was
+// given no factory with Use, for a stage of the order not to be skipped
+// silently.
+func (c *Chain) Then(method string, e endpoint.Endpoint) (endpoint.Endpoint, error) {
+	for i := len(c.order) - 1; i >= 0; i-- {
+		f, ok := c.factories[c.order[i]]
+		if !ok {
+			return nil, errors.Wrap(ErrMissingStage, errors.New(string(c.order[i])))
+		}
+		if f != nil {
+			e = f(method)(e)
+		}
+	}
+	return e, nil
+}
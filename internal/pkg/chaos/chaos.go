@@ -0,0 +1,195 @@
+// Package chaos injects faults in a share of the requests: latency, errors
+// and aborted connections, for teams to test their circuit breakers,
+// retries and timeouts against the service in staging. Faults are only
+// injected into the requests carrying the Header, unless told otherwise,
+// which may also ask for other faults than the configured ones.
+package chaos
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+const (
+	// Header opts a request in the faults, "1" for the configured ones, or
+	// a Spec for others.
+	Header = "X-Chaos"
+	// MetadataKey is the gRPC metadata key of Header.
+	MetadataKey = "x-chaos"
+)
+
+// The faults, labeling the injected counter.
+const (
+	FaultLatency = "latency"
+	FaultError   = "error"
+	FaultAbort   = "abort"
+)
+
+var (
+	// ErrInjected is the error injected in the requests, unavailable for
+	// the clients to retry them.
+	ErrInjected = errors.Register(errors.KindUnavailable, errors.NewCoded("CHAOS-001", "injected fault"))
+
+	// ErrAborted is returned by the requests whose connection is to be
+	// aborted, see Aborted.
+	ErrAborted = errors.Register(errors.KindUnavailable, errors.NewCoded("CHAOS-002", "injected connection abort"))
+)
+
+// Spec describes the faults injected, as percentages of the requests.
+type Spec struct {
+	Latency        time.Duration
+	LatencyPercent float64
+	ErrorPercent   float64
+	AbortPercent   float64
+}
+
+// ParseSpec returns the Spec of s, comma separated key=value pairs, e.g.
+// "latency=300ms,latency_percent=25,error=10,abort=2": 300ms of latency on
+// 25% of the requests, 10% failing and 2% aborted.
+func ParseSpec(s string) (Spec, error) {
+	var spec Spec
+	for _, kv := range strings.Split(s, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		i := strings.IndexByte(kv, '=')
+		if i < 0 {
+			return Spec{}, fmt.Errorf("chaos: %q isn't key=value", kv)
+		}
+		k, v := strings.TrimSpace(kv[:i]), strings.TrimSpace(kv[i+1:])
+		var err error
+		switch k {
+		case "latency":
+			spec.Latency, err = time.ParseDuration(v)
+		case "latency_percent":
+			spec.LatencyPercent, err = parsePercent(v)
+		case "error":
+			spec.ErrorPercent, err = parsePercent(v)
+		case "abort":
+			spec.AbortPercent, err = parsePercent(v)
+		default:
+			err = fmt.Errorf("unknown fault")
+		}
+		if err != nil {
+			return Spec{}, fmt.Errorf("chaos: %s: %v", k, err)
+		}
+	}
+	if spec.Latency > 0 && spec.LatencyPercent == 0 {
+		spec.LatencyPercent = 100
+	}
+	return spec, nil
+}
+
+func parsePercent(s string) (float64, error) {
+	p, err := strconv.ParseFloat(s, 64)
+	if err == nil && (p < 0 || p > 100) {
+		err = fmt.Errorf("%v not a percentage", p)
+	}
+	return p, err
+}
+
+// Chaos injects the faults of a Spec.
+type Chaos struct {
+	spec          Spec
+	requireHeader bool
+	maxLatency    time.Duration
+	injected      metrics.Counter
+}
+
+// New returns a Chaos injecting the faults of spec, only in the requests
+// carrying the Header when requireHeader, whose latency, the Header's
+// included, is at most maxLatency. injected, labeled by method and fault,
+// counts the faults.
+func New(spec Spec, requireHeader bool, maxLatency time.Duration, injected metrics.Counter) *Chaos {
+	return &Chaos{spec: spec, requireHeader: requireHeader, maxLatency: maxLatency, injected: injected}
+}
+
+// specOf returns the Spec of the request of ctx, and false when it's not
+// to be injected faults.
+func (c *Chaos) specOf(ctx context.Context) (Spec, bool) {
+	h, ok := ctx.Value(contextKey{}).(string)
+	switch {
+	case !ok || h == "":
+		return c.spec, !c.requireHeader
+	case h == "1" || h == "true":
+		return c.spec, true
+	}
+	spec, err := ParseSpec(h)
+	if err != nil {
+		return Spec{}, false
+	}
+	return spec, true
+}
+
+func hit(percent float64) bool {
+	return percent > 0 && rand.Float64()*100 < percent
+}
+
+// Middleware returns the endpoint middleware of method injecting the
+// faults, the latency first, then an abort or an error.
+func (c *Chaos) Middleware(method string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			spec, ok := c.specOf(ctx)
+			if !ok {
+				return next(ctx, request)
+			}
+			if spec.Latency > 0 && hit(spec.LatencyPercent) {
+				c.injected.With("method", method, "fault", FaultLatency).Add(1)
+				d := spec.Latency
+				if d > c.maxLatency {
+					d = c.maxLatency
+				}
+				t := time.NewTimer(d)
+				select {
+				case <-ctx.Done():
+					t.Stop()
+					return nil, ctx.Err()
+				case <-t.C:
+				}
+			}
+			switch {
+			case hit(spec.AbortPercent):
+				c.injected.With("method", method, "fault", FaultAbort).Add(1)
+				return nil, ErrAborted
+			case hit(spec.ErrorPercent):
+				c.injected.With("method", method, "fault", FaultError).Add(1)
+				return nil, ErrInjected
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// Aborted reports whether err asks for the connection to be aborted, which
+// the HTTP transports do by panicking with http.ErrAbortHandler, the gRPC
+// one reporting ErrAborted as unavailable.
+func Aborted(err error) bool {
+	return err != nil && errors.Contains(errors.Cast(err), ErrAborted)
+}
+
+type contextKey struct{}
+
+// HTTPToContext is an http RequestFunc moving the Header into ctx.
+func HTTPToContext(ctx context.Context, r *http.Request) context.Context {
+	return context.WithValue(ctx, contextKey{}, r.Header.Get(Header))
+}
+
+// GRPCToContext is a grpc RequestFunc moving the Header metadata into ctx.
+func GRPCToContext(ctx context.Context, md metadata.MD) context.Context {
+	if v := md.Get(MetadataKey); len(v) > 0 {
+		return context.WithValue(ctx, contextKey{}, v[0])
+	}
+	return ctx
+}
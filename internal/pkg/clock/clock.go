@@ -0,0 +1,102 @@
+// Package clock abstracts the time the middlewares read, so that tests can
+// freeze it and advance it at will instead of sleeping: the components take
+// a Clock, System in production, a Frozen one in tests.
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// Since returns the time elapsed since t.
+	Since(t time.Time) time.Duration
+	// After returns a channel receiving the current time once d elapsed.
+	After(d time.Duration) <-chan time.Time
+}
+
+// System is the Clock of the system, the time package's.
+var System Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) Since(t time.Time) time.Duration        { return time.Since(t) }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Frozen is a Clock whose time only changes when set or advanced. It's safe
+// for concurrent use.
+type Frozen struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+type waiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+// NewFrozen returns a Frozen clock at t.
+func NewFrozen(t time.Time) *Frozen {
+	return &Frozen{now: t}
+}
+
+// Now returns the time of f.
+func (f *Frozen) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Since returns the time elapsed since t at the time of f.
+func (f *Frozen) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+// After returns a channel receiving the time of f once it's advanced by d,
+// right away when d isn't positive.
+func (f *Frozen) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := make(chan time.Time, 1)
+	if d <= 0 {
+		c <- f.now
+		return c
+	}
+	f.waiters = append(f.waiters, waiter{at: f.now.Add(d), c: c})
+	return c
+}
+
+// Advance advances f by d, firing the After channels it reaches.
+func (f *Frozen) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.set(f.now.Add(d))
+}
+
+// Set sets the time of f to t, firing the After channels it reaches. The
+// time may go backwards, as the one of the system may.
+func (f *Frozen) Set(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.set(t)
+}
+
+func (f *Frozen) set(t time.Time) {
+	f.now = t
+	sort.Slice(f.waiters, func(i, j int) bool { return f.waiters[i].at.Before(f.waiters[j].at) })
+	n := 0
+	for _, w := range f.waiters {
+		if w.at.After(t) {
+			break
+		}
+		w.c <- t
+		n++
+	}
+	f.waiters = f.waiters[n:]
+}
@@ -0,0 +1,88 @@
+package clock
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestFrozen(t *testing.T) {
+	f := NewFrozen(epoch)
+	if got := f.Now(); !got.Equal(epoch) {
+		t.Fatalf("Now() = %v, want %v", got, epoch)
+	}
+	f.Advance(time.Minute)
+	if got := f.Since(epoch); got != time.Minute {
+		t.Errorf("Since(epoch) = %v after Advance(1m), want 1m", got)
+	}
+	f.Set(epoch.Add(-time.Hour))
+	if got := f.Since(epoch); got != -time.Hour {
+		t.Errorf("Since(epoch) = %v after Set backwards, want -1h", got)
+	}
+}
+
+func fired(c <-chan time.Time) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestFrozenAfter(t *testing.T) {
+	f := NewFrozen(epoch)
+	if !fired(f.After(0)) {
+		t.Error("After(0) didn't fire right away")
+	}
+
+	short, long := f.After(time.Second), f.After(time.Minute)
+	if fired(short) || fired(long) {
+		t.Fatal("After fired before the clock was advanced")
+	}
+	f.Advance(999 * time.Millisecond)
+	if fired(short) {
+		t.Fatal("After(1s) fired after 999ms")
+	}
+	f.Advance(time.Millisecond)
+	select {
+	case at := <-short:
+		if !at.Equal(epoch.Add(time.Second)) {
+			t.Errorf("After(1s) received %v, want %v", at, epoch.Add(time.Second))
+		}
+	default:
+		t.Fatal("After(1s) didn't fire after 1s")
+	}
+	if fired(long) {
+		t.Fatal("After(1m) fired after 1s")
+	}
+	f.Set(epoch.Add(time.Hour))
+	if !fired(long) {
+		t.Error("After(1m) didn't fire after Set past it")
+	}
+}
+
+// TestFrozenConcurrent checks Frozen is safe for concurrent use; run it
+// with -race.
+func TestFrozenConcurrent(t *testing.T) {
+	f := NewFrozen(epoch)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				c := f.After(time.Second)
+				f.Advance(time.Second)
+				<-c
+				f.Since(f.Now())
+			}
+		}()
+	}
+	wg.Wait()
+	if got := f.Since(epoch); got != 400*time.Second {
+		t.Errorf("Since(epoch) = %v, want 400s", got)
+	}
+}
@@ -0,0 +1,216 @@
+// Package compat probes the contract version served by the downstream
+// services, so that a client expecting an incompatible contract degrades the
+// readiness of the instance instead of failing its requests at runtime.
+package compat
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/drift"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// ErrMalformedContract indicates a contract version that isn't MAJOR.MINOR.
+var ErrMalformedContract = errors.New("malformed contract version")
+
+// Contract is the version of the contract of an API. Minor versions only add
+// to the contract, major versions break it.
+type Contract struct {
+	Major, Minor int
+}
+
+// Parse parses a MAJOR.MINOR contract version, a leading "v" allowed.
+func Parse(s string) (Contract, error) {
+	parts := strings.SplitN(strings.TrimPrefix(s, "v"), ".", 2)
+	if len(parts) != 2 {
+		return Contract{}, errors.Wrap(ErrMalformedContract, fmt.Errorf("%q", s))
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil || major < 0 {
+		return Contract{}, errors.Wrap(ErrMalformedContract, fmt.Errorf("%q", s))
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil || minor < 0 {
+		return Contract{}, errors.Wrap(ErrMalformedContract, fmt.Errorf("%q", s))
+	}
+	return Contract{Major: major, Minor: minor}, nil
+}
+
+func (c Contract) String() string {
+	return fmt.Sprintf("%d.%d", c.Major, c.Minor)
+}
+
+// Satisfies reports whether a client expecting the contract want can call an
+// API serving c: same major version, and at least the minor version wanted.
+func (c Contract) Satisfies(want Contract) bool {
+	return c.Major == want.Major && c.Minor >= want.Minor
+}
+
+// Mismatch is a downstream service serving a contract the client can't call.
+type Mismatch struct {
+	Target string
+	// Served is the contract version the target reported, empty when it
+	// reported none.
+	Served string
+}
+
+// MismatchError reports the downstream services serving an incompatible
+// contract.
+type MismatchError struct {
+	Want       Contract
+	Mismatches []Mismatch
+}
+
+func (e *MismatchError) Error() string {
+	ms := make([]string, 0, len(e.Mismatches))
+	for _, m := range e.Mismatches {
+		served := m.Served
+		if served == "" {
+			served = "none"
+		}
+		ms = append(ms, fmt.Sprintf("%s=%s", m.Target, served))
+	}
+	return fmt.Sprintf("contract %s not served by: %s", e.Want, strings.Join(ms, ", "))
+}
+
+// Probe periodically fetches the status of the downstream services, see
+// drift.StatusHandler, and checks that their contract satisfies the one the
+// client expects. Its readiness follows the last verdict; a target that
+// can't be reached leaves it untouched, that's not a contract issue.
+type Probe struct {
+	want         Contract
+	targets      []string
+	peers        []drift.Peers
+	incompatible metrics.Gauge
+	logger       log.Logger
+
+	mtx      sync.Mutex
+	verdicts map[string]bool
+	onChange func(ready bool)
+}
+
+// NewProbe returns a Probe of the base URLs targets, for a client expecting
+// the contract want. The incompatible gauge is set per target, labeled
+// "target".
+func NewProbe(want Contract, targets []string, incompatible metrics.Gauge, logger log.Logger) *Probe {
+	peers := make([]drift.Peers, len(targets))
+	for i, target := range targets {
+		peers[i] = drift.NewHTTPPeers([]string{target}, nil)
+	}
+	return &Probe{
+		want:         want,
+		targets:      targets,
+		peers:        peers,
+		incompatible: incompatible,
+		logger:       logger,
+		verdicts:     map[string]bool{},
+	}
+}
+
+// OnChange registers f to be called with the readiness of the probe every
+// time it changes, e.g. to update the health status of the instance.
+func (p *Probe) OnChange(f func(ready bool)) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.onChange = f
+}
+
+// Ready reports whether no target was last seen serving an incompatible
+// contract.
+func (p *Probe) Ready() bool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.ready()
+}
+
+func (p *Probe) ready() bool {
+	for _, ok := range p.verdicts {
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Check probes every target once. Incompatible targets are returned as a
+// *MismatchError, unreachable ones as the first error met.
+func (p *Probe) Check(ctx context.Context) error {
+	var (
+		mismatches []Mismatch
+		unreached  error
+	)
+	verdicts := map[string]bool{}
+	for i, target := range p.targets {
+		statuses, err := p.peers[i].Statuses(ctx)
+		if err != nil {
+			if unreached == nil {
+				unreached = err
+			}
+			continue
+		}
+		ok := len(statuses) > 0
+		for _, s := range statuses {
+			served, err := Parse(s.Contract)
+			if err != nil || !served.Satisfies(p.want) {
+				ok = false
+				mismatches = append(mismatches, Mismatch{Target: target, Served: s.Contract})
+			}
+		}
+		verdicts[target] = ok
+		if ok {
+			p.incompatible.With("target", target).Set(0)
+		} else {
+			p.incompatible.With("target", target).Set(1)
+		}
+	}
+	p.record(verdicts)
+
+	if len(mismatches) > 0 {
+		return &MismatchError{Want: p.want, Mismatches: mismatches}
+	}
+	return unreached
+}
+
+// record merges the verdicts of a check, calling onChange when the
+// readiness flips.
+func (p *Probe) record(verdicts map[string]bool) {
+	p.mtx.Lock()
+	was := p.ready()
+	for target, ok := range verdicts {
+		p.verdicts[target] = ok
+	}
+	now, f := p.ready(), p.onChange
+	p.mtx.Unlock()
+	if now != was && f != nil {
+		f(now)
+	}
+}
+
+// Run calls Check right away, then every interval until ctx is done.
+func (p *Probe) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		switch err := p.Check(ctx).(type) {
+		case nil:
+		case *MismatchError:
+			level.Warn(p.logger).Log("compat", "mismatch", "want", err.Want, "err", err)
+		default:
+			level.Error(p.logger).Log("compat", "probe", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// Package cron runs the jobs of the App Engine cron service, cron.yaml
+// requesting them as GET /cron/<name>. Jobs are endpoints registered by
+// name; the requests not coming from cron are rejected, and a job isn't run
+// again while a run of it is in progress on the instance.
+package cron
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// PathPrefix is the path of the jobs, followed by their name.
+const PathPrefix = "/cron/"
+
+// HeaderCron is set to true by App Engine on the requests of its cron
+// service, and stripped from external requests.
+const HeaderCron = "X-Appengine-Cron"
+
+var (
+	// ErrNotCron indicates a job requested by other than the cron service.
+	ErrNotCron = errors.Register(errors.KindPermissionDenied, errors.NewCoded("CRON-001", "not a cron request"))
+
+	// ErrUnknownJob indicates a job that isn't registered.
+	ErrUnknownJob = errors.Register(errors.KindNotFound, errors.NewCoded("CRON-002", "unknown cron job"))
+
+	// ErrRunning indicates a job requested while a run of it is in
+	// progress.
+	ErrRunning = errors.Register(errors.KindAborted, errors.NewCoded("CRON-003", "cron job already running"))
+)
+
+// validName matches the names of the jobs, path segments of their URL.
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Request is the request the job endpoints are called with.
+type Request struct {
+	// Job is the name of the job.
+	Job string
+}
+
+// Jobs is the set of the cron jobs of a service. It's safe for concurrent
+// use.
+type Jobs struct {
+	runs     metrics.Counter
+	duration metrics.Histogram
+	logger   log.Logger
+
+	mu      sync.Mutex
+	jobs    map[string]endpoint.Endpoint
+	running map[string]bool
+}
+
+// New returns Jobs without any job, counting the runs in runs by job and
+// result ("ok", "error" or "skipped"), timing them in duration by job.
+func New(runs metrics.Counter, duration metrics.Histogram, logger log.Logger) *Jobs {
+	return &Jobs{
+		runs:     runs,
+		duration: duration,
+		logger:   logger,
+		jobs:     map[string]endpoint.Endpoint{},
+		running:  map[string]bool{},
+	}
+}
+
+// Register registers the job name, run by calling e with a Request. It
+// panics when name is already registered or isn't a lowercase path segment.
+func (j *Jobs) Register(name string, e endpoint.Endpoint) {
+	if !validName.MatchString(name) {
+		panic(fmt.Sprintf("cron: invalid job name %q", name))
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, ok := j.jobs[name]; ok {
+		panic(fmt.Sprintf("cron: job %q already registered", name))
+	}
+	j.jobs[name] = e
+}
+
+// Run runs the job name and returns its response. It fails with
+// ErrUnknownJob when name isn't registered, and with ErrRunning when a run
+// of it is in progress.
+func (j *Jobs) Run(ctx context.Context, name string) (interface{}, error) {
+	j.mu.Lock()
+	e, ok := j.jobs[name]
+	if !ok {
+		j.mu.Unlock()
+		return nil, ErrUnknownJob
+	}
+	if j.running[name] {
+		j.mu.Unlock()
+		j.runs.With("job", name, "result", "skipped").Add(1)
+		level.Warn(j.logger).Log("job", name, "msg", "skipped, still running")
+		return nil, ErrRunning
+	}
+	j.running[name] = true
+	j.mu.Unlock()
+	defer func() {
+		j.mu.Lock()
+		delete(j.running, name)
+		j.mu.Unlock()
+	}()
+
+	begin := time.Now()
+	response, err := e(ctx, Request{Job: name})
+	took := time.Since(begin)
+	j.duration.With("job", name).Observe(took.Seconds())
+	if err != nil {
+		j.runs.With("job", name, "result", "error").Add(1)
+		level.Error(j.logger).Log("job", name, "took", took, "err", err)
+		return nil, err
+	}
+	j.runs.With("job", name, "result", "ok").Add(1)
+	level.Info(j.logger).Log("job", name, "took", took)
+	return response, nil
+}
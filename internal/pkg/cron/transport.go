@@ -0,0 +1,50 @@
+package cron
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kit/kit/log"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/go-zoo/bone"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/responses"
+)
+
+// MakeHTTPHandler returns a handler running the jobs of j at PathPrefix
+// followed by their name, for the requests of the cron service only.
+func MakeHTTPHandler(j *Jobs, logger log.Logger) http.Handler {
+	mux := bone.New()
+	mux.Get(PathPrefix+":name", httptransport.NewServer(
+		func(ctx context.Context, request interface{}) (interface{}, error) {
+			return j.Run(ctx, request.(string))
+		},
+		decodeRequest,
+		encodeResponse,
+		httptransport.ServerErrorEncoder(encodeError),
+		httptransport.ServerErrorLogger(logger),
+	))
+	return mux
+}
+
+func decodeRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Header.Get(HeaderCron) != "true" {
+		return nil, ErrNotCron
+	}
+	return bone.GetValue(r, "name"), nil
+}
+
+func encodeResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(responses.DataRes{Data: response})
+}
+
+func encodeError(_ context.Context, err error, w http.ResponseWriter) {
+	code := errors.HTTPStatus(errors.KindOf(err))
+	ce := errors.Cast(err)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(responses.ErrorRes{Error: responses.ErrorResItem{Code: code, ErrorCode: errors.Code(err), Message: ce.Msg(), Errors: ce.Errors()}})
+}
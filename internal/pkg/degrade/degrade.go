@@ -0,0 +1,234 @@
+// Package degrade applies the fallbacks endpoints declare for the outages of
+// their dependencies: while the breaker of a dependency is open, its
+// endpoints serve stale data, skip the work depending on it, or queue that
+// work for later, instead of failing. Degraded responses are marked with
+// the Degraded header.
+package degrade
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+	"google.golang.org/grpc/metadata"
+)
+
+// Header marks the degraded responses with the dependencies down and the
+// fallback applied, e.g. "history; mode=serve-stale".
+const Header = "X-Degraded"
+
+// Mode is the kind of fallback applied.
+type Mode string
+
+// The modes of the fallbacks. Only ModeServeStale changes the response; the
+// others let the endpoint run, the code depending on the dependency being
+// expected to check FromContext.
+const (
+	// ModeServeStale serves the data last served, see Stale.
+	ModeServeStale Mode = "serve-stale"
+	// ModeSkipEnrichment skips the work depending on the dependency.
+	ModeSkipEnrichment Mode = "skip-enrichment"
+	// ModeQueue defers the work depending on the dependency until it's back,
+	// see Defer.
+	ModeQueue Mode = "queue"
+)
+
+// Fallback is the fallback of an endpoint for the outage of a dependency.
+type Fallback struct {
+	Dependency string
+	Mode       Mode
+	// Endpoint serves the degraded requests. When nil, the endpoint itself
+	// does, the degradation in their context.
+	Endpoint endpoint.Endpoint
+	// Queue takes the work deferred in ModeQueue.
+	Queue *Queue
+}
+
+// Degradation is a fallback applied to a request.
+type Degradation struct {
+	Dependency string
+	Mode       Mode
+	queue      *Queue
+}
+
+func (d Degradation) String() string {
+	return d.Dependency + "; mode=" + string(d.Mode)
+}
+
+// Policy holds the fallbacks of every endpoint.
+type Policy struct {
+	open      func(dependency string) bool
+	degraded  metrics.Counter
+	fallbacks map[string][]Fallback
+}
+
+// NewPolicy returns a Policy without fallbacks. open reports whether the
+// breaker of a dependency is open. degraded, labeled by method, dependency
+// and mode, counts the degraded requests.
+func NewPolicy(open func(dependency string) bool, degraded metrics.Counter) *Policy {
+	return &Policy{open: open, degraded: degraded, fallbacks: map[string][]Fallback{}}
+}
+
+// Declare adds the fallbacks of method, tried in order.
+func (p *Policy) Declare(method string, fallbacks ...Fallback) *Policy {
+	p.fallbacks[method] = append(p.fallbacks[method], fallbacks...)
+	return p
+}
+
+// Middleware returns the endpoint middleware of method, applying its first
+// fallback whose dependency is down. A request failing because the
+// dependency went down meanwhile is served degraded as well.
+func (p *Policy) Middleware(method string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		fallbacks := p.fallbacks[method]
+		if len(fallbacks) == 0 {
+			return next
+		}
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if fb, ok := p.down(fallbacks); ok {
+				return p.degrade(ctx, method, fb, next, request)
+			}
+			response, err := next(ctx, request)
+			if err != nil {
+				if fb, ok := p.down(fallbacks); ok {
+					return p.degrade(ctx, method, fb, next, request)
+				}
+			}
+			return response, err
+		}
+	}
+}
+
+func (p *Policy) down(fallbacks []Fallback) (Fallback, bool) {
+	for _, fb := range fallbacks {
+		if p.open(fb.Dependency) {
+			return fb, true
+		}
+	}
+	return Fallback{}, false
+}
+
+func (p *Policy) degrade(ctx context.Context, method string, fb Fallback, next endpoint.Endpoint, request interface{}) (interface{}, error) {
+	d := Degradation{Dependency: fb.Dependency, Mode: fb.Mode, queue: fb.Queue}
+	ctx = NewContext(ctx, d)
+	if t, ok := ctx.Value(trackerContextKey).(*tracker); ok {
+		t.add(d)
+	}
+	p.degraded.With("method", method, "dependency", fb.Dependency, "mode", string(fb.Mode)).Add(1)
+	if fb.Endpoint != nil {
+		return fb.Endpoint(ctx, request)
+	}
+	return next(ctx, request)
+}
+
+type contextKey int
+
+const (
+	degradationsContextKey contextKey = iota
+	trackerContextKey
+)
+
+// NewContext returns ctx carrying d, on top of the degradations ctx already
+// carries.
+func NewContext(ctx context.Context, d Degradation) context.Context {
+	ds, _ := ctx.Value(degradationsContextKey).(map[string]Degradation)
+	next := make(map[string]Degradation, len(ds)+1)
+	for k, v := range ds {
+		next[k] = v
+	}
+	next[d.Dependency] = d
+	return context.WithValue(ctx, degradationsContextKey, next)
+}
+
+// FromContext returns the fallback mode applied to the request of ctx for
+// the outage of dependency, and false when it isn't degraded.
+func FromContext(ctx context.Context, dependency string) (Mode, bool) {
+	ds, _ := ctx.Value(degradationsContextKey).(map[string]Degradation)
+	d, ok := ds[dependency]
+	return d.Mode, ok
+}
+
+// Defer queues job for when dependency is back, when the request of ctx is
+// degraded in ModeQueue. It returns false when job should rather run now:
+// the request isn't queuing, or the queue is full.
+func Defer(ctx context.Context, dependency string, job Job) bool {
+	ds, _ := ctx.Value(degradationsContextKey).(map[string]Degradation)
+	d, ok := ds[dependency]
+	if !ok || d.Mode != ModeQueue || d.queue == nil {
+		return false
+	}
+	return d.queue.Defer(job)
+}
+
+// tracker collects the degradations of a request for the transports, which
+// don't see the context of the endpoint.
+type tracker struct {
+	mu sync.Mutex
+	ds []Degradation
+}
+
+func (t *tracker) add(d Degradation) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ds = append(t.ds, d)
+}
+
+func (t *tracker) header() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	values := make([]string, len(t.ds))
+	for i, d := range t.ds {
+		values[i] = d.String()
+	}
+	return strings.Join(values, ", ")
+}
+
+// Track returns ctx collecting the degradations of its request, for
+// HTTPResponseHeaders and GRPCResponseHeaders to report.
+func Track(ctx context.Context) context.Context {
+	return context.WithValue(ctx, trackerContextKey, &tracker{})
+}
+
+// HTTPToContext is an http RequestFunc tracking the degradations of the
+// request.
+func HTTPToContext(ctx context.Context, _ *http.Request) context.Context {
+	return Track(ctx)
+}
+
+// HTTPResponseHeaders is an http ServerResponseFunc marking degraded
+// responses with the Degraded header, and stale ones with a Warning.
+func HTTPResponseHeaders(ctx context.Context, w http.ResponseWriter) context.Context {
+	t, ok := ctx.Value(trackerContextKey).(*tracker)
+	if !ok {
+		return ctx
+	}
+	if h := t.header(); h != "" {
+		w.Header().Set(Header, h)
+		if strings.Contains(h, string(ModeServeStale)) {
+			w.Header().Set("Warning", `110 - "Response is Stale"`)
+		}
+	}
+	return ctx
+}
+
+// GRPCToContext is a grpc RequestFunc tracking the degradations of the
+// request.
+func GRPCToContext(ctx context.Context, _ metadata.MD) context.Context {
+	return Track(ctx)
+}
+
+// GRPCResponseHeaders is a grpc ServerResponseFunc marking degraded
+// responses with the Degraded header metadata.
+func GRPCResponseHeaders(ctx context.Context, header *metadata.MD, _ *metadata.MD) context.Context {
+	t, ok := ctx.Value(trackerContextKey).(*tracker)
+	if !ok {
+		return ctx
+	}
+	if h := t.header(); h != "" {
+		header.Set(strings.ToLower(Header), h)
+	}
+	return ctx
+}
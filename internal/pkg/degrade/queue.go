@@ -0,0 +1,101 @@
+package degrade
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// Job is work deferred while its dependency is down. It runs with a context
+// of its own, the request it comes from being long gone.
+type Job func(ctx context.Context) error
+
+// jobTimeout bounds every deferred job.
+const jobTimeout = 10 * time.Second
+
+// Queue holds the jobs deferred in ModeQueue, in memory: they're lost if the
+// instance stops before the dependency is back.
+type Queue struct {
+	max int
+
+	mu   sync.Mutex
+	jobs []Job
+}
+
+// NewQueue returns an empty Queue of up to max jobs.
+func NewQueue(max int) *Queue {
+	return &Queue{max: max}
+}
+
+// Defer appends job to q, and returns false when q is full.
+func (q *Queue) Defer(job Job) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.jobs) >= q.max {
+		return false
+	}
+	q.jobs = append(q.jobs, job)
+	return true
+}
+
+// Len returns the number of jobs in q.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.jobs)
+}
+
+// Drain runs the jobs of q in order, until one fails: it's kept with the
+// ones after it, and its error returned. It returns the number of jobs run.
+func (q *Queue) Drain(ctx context.Context) (int, error) {
+	n := 0
+	for {
+		q.mu.Lock()
+		if len(q.jobs) == 0 {
+			q.mu.Unlock()
+			return n, nil
+		}
+		job := q.jobs[0]
+		q.mu.Unlock()
+
+		jctx, cancel := context.WithTimeout(ctx, jobTimeout)
+		err := job(jctx)
+		cancel()
+		if err != nil {
+			return n, err
+		}
+		q.mu.Lock()
+		q.jobs = q.jobs[1:]
+		q.mu.Unlock()
+		n++
+	}
+}
+
+// Run drains q every interval while ready reports the dependency back,
+// until ctx is done.
+func (q *Queue) Run(ctx context.Context, ready func() bool, interval time.Duration, logger log.Logger) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if n := q.Len(); n > 0 {
+				level.Warn(logger).Log("queue", "dropped", "jobs", n)
+			}
+			return
+		case <-t.C:
+			if q.Len() == 0 || !ready() {
+				continue
+			}
+			n, err := q.Drain(ctx)
+			if err != nil {
+				level.Warn(logger).Log("queue", "drain", "ran", n, "left", q.Len(), "err", err)
+			} else {
+				level.Info(logger).Log("queue", "drained", "ran", n)
+			}
+		}
+	}
+}
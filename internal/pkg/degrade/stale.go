@@ -0,0 +1,72 @@
+package degrade
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-kit/kit/endpoint"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// ErrNoStaleData indicates a degraded request with no data to serve
+// instead.
+var ErrNoStaleData = errors.Register(errors.KindUnavailable, errors.NewCoded("DEG-001", "dependency unavailable and no stale data"))
+
+// Stale keeps the last response of every request key, to serve in
+// ModeServeStale. It's safe for concurrent use.
+type Stale struct {
+	key func(ctx context.Context, request interface{}) string
+	max int
+
+	mu        sync.Mutex
+	responses map[string]interface{}
+}
+
+// NewStale returns a Stale of up to max responses. key identifies the
+// requests served the same response; it must tell apart the callers who
+// can't see each other's data.
+func NewStale(key func(ctx context.Context, request interface{}) string, max int) *Stale {
+	return &Stale{key: key, max: max, responses: map[string]interface{}{}}
+}
+
+// Middleware returns an endpoint middleware keeping the successful
+// responses of the endpoint.
+func (s *Stale) Middleware() endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			response, err := next(ctx, request)
+			if err == nil {
+				s.put(s.key(ctx, request), response)
+			}
+			return response, err
+		}
+	}
+}
+
+func (s *Stale) put(key string, response interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.responses[key]; !ok && len(s.responses) >= s.max {
+		// evict any: stale data is best effort
+		for k := range s.responses {
+			delete(s.responses, k)
+			break
+		}
+	}
+	s.responses[key] = response
+}
+
+// Endpoint returns the endpoint serving the last response kept for the
+// request, or failing with ErrNoStaleData.
+func (s *Stale) Endpoint() endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		response, ok := s.responses[s.key(ctx, request)]
+		if !ok {
+			return nil, ErrNoStaleData
+		}
+		return response, nil
+	}
+}
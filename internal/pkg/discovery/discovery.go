@@ -0,0 +1,86 @@
+// Package discovery builds go-kit sd.Instancers from a compact
+// specification, so clients find the instances of a service without a
+// hard-coded address.
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/sd"
+	"github.com/go-kit/kit/sd/dnssrv"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// DefaultTTL is how often DNS records are resolved again.
+const DefaultTTL = 30 * time.Second
+
+// ErrInvalidSpec indicates a specification NewInstancer doesn't understand.
+var ErrInvalidSpec = errors.New("invalid service discovery specification")
+
+// NewInstancer returns the instancer described by spec:
+//
+//	host:port,host:port      a static list of instances
+//	dnssrv://_name._tcp.zone the targets of a DNS SRV record
+//	dns://host:port          the addresses of a DNS A/AAAA record, such as
+//	                         the GCP internal DNS name of an instance group
+//
+// DNS records are resolved every ttl, DefaultTTL when zero. Consul, etcd and
+// the other registries of go-kit's sd package plug in as their own
+// sd.Instancer.
+func NewInstancer(spec string, ttl time.Duration, logger log.Logger) (sd.Instancer, error) {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	switch {
+	case strings.HasPrefix(spec, "dnssrv://"):
+		name := strings.TrimPrefix(spec, "dnssrv://")
+		if name == "" {
+			return nil, errors.Wrap(ErrInvalidSpec, errors.New(spec))
+		}
+		return dnssrv.NewInstancer(name, ttl, logger), nil
+	case strings.HasPrefix(spec, "dns://"):
+		host, port, err := net.SplitHostPort(strings.TrimPrefix(spec, "dns://"))
+		if err != nil {
+			return nil, errors.Wrap(ErrInvalidSpec, err)
+		}
+		return NewDNSInstancer(host, port, ttl, logger), nil
+	}
+
+	var instances []string
+	for _, instance := range strings.Split(spec, ",") {
+		if instance = strings.TrimSpace(instance); instance != "" {
+			instances = append(instances, instance)
+		}
+	}
+	if len(instances) == 0 {
+		return nil, errors.Wrap(ErrInvalidSpec, fmt.Errorf("no instance in %q", spec))
+	}
+	return sd.FixedInstancer(instances), nil
+}
+
+// NewDNSInstancer returns an instancer yielding host:port for every address
+// of host, resolved every ttl. It suits DNS names without SRV records, like
+// the GCP internal DNS names.
+func NewDNSInstancer(host, port string, ttl time.Duration, logger log.Logger) *dnssrv.Instancer {
+	lookup := func(_, _, name string) (string, []*net.SRV, error) {
+		addrs, err := net.LookupHost(name)
+		if err != nil {
+			return "", nil, err
+		}
+		p, err := net.LookupPort("tcp", port)
+		if err != nil {
+			return "", nil, err
+		}
+		srvs := make([]*net.SRV, len(addrs))
+		for i, addr := range addrs {
+			srvs[i] = &net.SRV{Target: addr, Port: uint16(p)}
+		}
+		return name, srvs, nil
+	}
+	return dnssrv.NewInstancerDetailed(host, time.NewTicker(ttl), lookup, logger)
+}
@@ -0,0 +1,278 @@
+// Package download serves generated artifacts, such as exports, for
+// reliable transfer over flaky connections: the artifact is kept under an
+// ID and served with its checksums, Digest and Content-MD5, and with Range
+// support, so a client resumes an interrupted
+// download where it stopped and checks the bytes it pieced together.
+package download
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/clock"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/gcp"
+)
+
+// HeaderToken is the response header of the token granting the download of
+// an artifact.
+const HeaderToken = "X-Download-Token"
+
+var (
+	// ErrNotFound indicates a download token unknown or expired.
+	ErrNotFound = errors.Register(errors.KindNotFound, errors.NewCoded("DOWNLOAD-001", "download not found or expired"))
+
+	// ErrTooLarge indicates an artifact larger than downloads are kept for.
+	ErrTooLarge = errors.Register(errors.KindOutOfRange, errors.NewCoded("DOWNLOAD-002", "artifact too large to download"))
+)
+
+// Artifact is a generated file.
+type Artifact struct {
+	// Name is the file name suggested to the client, e.g. export.csv.
+	Name        string    `json:"name"`
+	ContentType string    `json:"contentType"`
+	Created     time.Time `json:"created"`
+	Data        []byte    `json:"data"`
+}
+
+// Digest returns the Digest header value of a, its SHA-256 as RFC 3230 has
+// it.
+func (a Artifact) Digest() string {
+	sum := sha256.Sum256(a.Data)
+	return "sha-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// ContentMD5 returns the Content-MD5 header value of a.
+func (a Artifact) ContentMD5() string {
+	sum := md5.Sum(a.Data)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// ETag returns the strong entity tag of a, which If-Range requests send
+// back to resume the same bytes.
+func (a Artifact) ETag() string {
+	sum := sha256.Sum256(a.Data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// NewToken returns a new, unguessable, artifact ID.
+func NewToken() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
+
+// Store keeps the artifacts under their IDs. Deployments running more
+// than one instance need a shared implementation, such as NewBucketStore,
+// so a download resumes on any instance.
+type Store interface {
+	Put(ctx context.Context, id string, a Artifact) error
+	// Get returns the artifact of id, failing with ErrNotFound once it
+	// expired.
+	Get(ctx context.Context, id string) (Artifact, error)
+	// Delete deletes the artifact of id, if any.
+	Delete(ctx context.Context, id string) error
+}
+
+type memoryStore struct {
+	mu        sync.Mutex
+	clock     clock.Clock
+	ttl       time.Duration
+	maxBytes  int
+	size      int
+	artifacts map[string]Artifact
+	order     []string
+}
+
+// NewMemoryStore returns a Store keeping the artifacts in memory for ttl,
+// at most maxBytes of them, the oldest evicted first.
+func NewMemoryStore(clk clock.Clock, ttl time.Duration, maxBytes int) Store {
+	return &memoryStore{clock: clk, ttl: ttl, maxBytes: maxBytes, artifacts: map[string]Artifact{}}
+}
+
+func (s *memoryStore) Put(_ context.Context, token string, a Artifact) error {
+	if len(a.Data) > s.maxBytes {
+		return ErrTooLarge
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evict(len(a.Data))
+	s.artifacts[token] = a
+	s.order = append(s.order, token)
+	s.size += len(a.Data)
+	return nil
+}
+
+// evict drops the expired artifacts, then the oldest until n more bytes
+// fit.
+func (s *memoryStore) evict(n int) {
+	for len(s.order) > 0 {
+		a := s.artifacts[s.order[0]]
+		if s.clock.Since(a.Created) < s.ttl && s.size+n <= s.maxBytes {
+			return
+		}
+		delete(s.artifacts, s.order[0])
+		s.order = s.order[1:]
+		s.size -= len(a.Data)
+	}
+}
+
+func (s *memoryStore) Get(_ context.Context, token string) (Artifact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.artifacts[token]
+	if !ok || s.clock.Since(a.Created) >= s.ttl {
+		return Artifact{}, ErrNotFound
+	}
+	return a, nil
+}
+
+func (s *memoryStore) Delete(_ context.Context, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.artifacts[token]
+	if !ok {
+		return nil
+	}
+	delete(s.artifacts, token)
+	for i, t := range s.order {
+		if t == token {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	s.size -= len(a.Data)
+	return nil
+}
+
+// Bucket is the part of gcp.Bucket a bucket Store uses.
+type Bucket interface {
+	Upload(ctx context.Context, name, contentType string, data []byte) error
+	Download(ctx context.Context, name string) ([]byte, error)
+	Delete(ctx context.Context, name string) error
+}
+
+type bucketStore struct {
+	bucket   Bucket
+	prefix   string
+	clock    clock.Clock
+	ttl      time.Duration
+	maxBytes int
+}
+
+// NewBucketStore returns a Store keeping the artifacts, at most maxBytes
+// each, as objects of bucket under prefix, served for ttl. A lifecycle rule
+// of the bucket deletes them afterwards.
+func NewBucketStore(bucket Bucket, prefix string, clk clock.Clock, ttl time.Duration, maxBytes int) Store {
+	return bucketStore{bucket: bucket, prefix: prefix, clock: clk, ttl: ttl, maxBytes: maxBytes}
+}
+
+func (s bucketStore) Put(ctx context.Context, token string, a Artifact) error {
+	if len(a.Data) > s.maxBytes {
+		return ErrTooLarge
+	}
+	b, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return s.bucket.Upload(ctx, s.prefix+token+".json", "application/json", b)
+}
+
+func (s bucketStore) Get(ctx context.Context, token string) (Artifact, error) {
+	b, err := s.bucket.Download(ctx, s.prefix+token+".json")
+	if err != nil {
+		if errors.Contains(errors.Cast(err), gcp.ErrObjectNotFound) {
+			return Artifact{}, ErrNotFound
+		}
+		return Artifact{}, err
+	}
+	var a Artifact
+	if err := json.Unmarshal(b, &a); err != nil {
+		return Artifact{}, err
+	}
+	if s.clock.Since(a.Created) >= s.ttl {
+		return Artifact{}, ErrNotFound
+	}
+	return a, nil
+}
+
+func (s bucketStore) Delete(ctx context.Context, token string) error {
+	err := s.bucket.Delete(ctx, s.prefix+token+".json")
+	if err != nil && errors.Contains(errors.Cast(err), gcp.ErrObjectNotFound) {
+		return nil
+	}
+	return err
+}
+
+// Serve writes a to w with its checksums and token, answering the Range,
+// If-Range and conditional headers of r. Digest is that of the whole
+// artifact, for the client to check the bytes it pieced together;
+// Content-MD5, that of the body sent, is only set on the whole ones. It
+// reports whether the end of the artifact was sent, the whole of it or the
+// last range of a resumed download, which completes the download.
+func Serve(w http.ResponseWriter, r *http.Request, token string, a Artifact) bool {
+	h := w.Header()
+	h.Set("Content-Type", a.ContentType)
+	h.Set("Content-Disposition", `attachment; filename="`+a.Name+`"`)
+	h.Set("Digest", a.Digest())
+	if r.Header.Get("Range") == "" {
+		h.Set("Content-MD5", a.ContentMD5())
+	}
+	h.Set("ETag", a.ETag())
+	h.Set("Cache-Control", "private, no-transform")
+	h.Set(HeaderToken, token)
+	sw := &sentWriter{ResponseWriter: w}
+	er := &endReader{Reader: bytes.NewReader(a.Data)}
+	http.ServeContent(sw, r, a.Name, a.Created, er)
+	if len(a.Data) == 0 {
+		return r.Method == http.MethodGet && sw.status == http.StatusOK
+	}
+	return er.end && !sw.failed
+}
+
+// endReader records whether the last byte of the artifact was read.
+type endReader struct {
+	*bytes.Reader
+	end bool
+}
+
+func (r *endReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 && r.Len() == 0 {
+		r.end = true
+	}
+	return n, err
+}
+
+// sentWriter records the status of the response and whether a write of its
+// body failed.
+type sentWriter struct {
+	http.ResponseWriter
+	status int
+	failed bool
+}
+
+func (w *sentWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *sentWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	if err != nil {
+		w.failed = true
+	}
+	return n, err
+}
@@ -0,0 +1,37 @@
+package download
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServeComplete(t *testing.T) {
+	a := Artifact{Name: "export.csv", ContentType: "text/csv", Created: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), Data: []byte("0123456789")}
+
+	cases := []struct {
+		name     string
+		method   string
+		rng      string
+		complete bool
+	}{
+		{"whole", http.MethodGet, "", true},
+		{"head", http.MethodHead, "", false},
+		{"first range", http.MethodGet, "bytes=0-4", false},
+		{"last range", http.MethodGet, "bytes=5-", true},
+		{"suffix", http.MethodGet, "bytes=-1", true},
+		{"unsatisfiable", http.MethodGet, "bytes=20-", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, "/", nil)
+			if tc.rng != "" {
+				r.Header.Set("Range", tc.rng)
+			}
+			if complete := Serve(httptest.NewRecorder(), r, "token", a); complete != tc.complete {
+				t.Errorf("Serve() = %v, want %v", complete, tc.complete)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package drift
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/responses"
+)
+
+// StatusPath is where StatusHandler is expected to be mounted.
+const StatusPath = "/api/status"
+
+// ErrPeerUnavailable indicates the status of a peer couldn't be fetched.
+var ErrPeerUnavailable = errors.New("peer status unavailable")
+
+// Peers returns the statuses of the instances of the fleet.
+type Peers interface {
+	Statuses(ctx context.Context) ([]Status, error)
+}
+
+type httpPeers struct {
+	urls   []string
+	client *http.Client
+}
+
+// NewHTTPPeers returns Peers fetching the status endpoint of each base URL.
+func NewHTTPPeers(urls []string, client *http.Client) Peers {
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	return &httpPeers{urls: urls, client: client}
+}
+
+func (p *httpPeers) Statuses(ctx context.Context) ([]Status, error) {
+	statuses := make([]Status, 0, len(p.urls))
+	for _, u := range p.urls {
+		req, err := http.NewRequest(http.MethodGet, u+StatusPath, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := p.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, errors.Wrap(ErrPeerUnavailable, err)
+		}
+		var res struct {
+			Data Status `json:"data"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, errors.Wrap(ErrPeerUnavailable, fmt.Errorf("%s: %s", u, resp.Status))
+		}
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, res.Data)
+	}
+	return statuses, nil
+}
+
+// StatusHandler serves the status of this instance.
+func StatusHandler(self Status) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(responses.DataRes{Data: self})
+	})
+}
+
+// Checker periodically compares the fingerprint of this instance with the
+// ones of its peers, logging a warning and raising the drifted gauge when
+// they disagree.
+type Checker struct {
+	self    Status
+	peers   Peers
+	drifted metrics.Gauge
+	logger  log.Logger
+}
+
+// NewChecker returns a Checker for the instance described by self.
+func NewChecker(self Status, peers Peers, drifted metrics.Gauge, logger log.Logger) *Checker {
+	return &Checker{self: self, peers: peers, drifted: drifted, logger: logger}
+}
+
+// Check compares the fleet once; a drift is returned as a *DriftError.
+func (c *Checker) Check(ctx context.Context) error {
+	statuses, err := c.peers.Statuses(ctx)
+	if err != nil {
+		return err
+	}
+	err = Compare(c.self.Version, append(statuses, c.self))
+	if _, ok := err.(*DriftError); ok {
+		c.drifted.Set(1)
+	} else if err == nil {
+		c.drifted.Set(0)
+	}
+	return err
+}
+
+// Run calls Check every interval until ctx is done.
+func (c *Checker) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			switch err := c.Check(ctx).(type) {
+			case nil:
+			case *DriftError:
+				level.Warn(c.logger).Log("drift", "detected", "version", err.Version, "err", err)
+			default:
+				level.Error(c.logger).Log("drift", "check", "err", err)
+			}
+		}
+	}
+}
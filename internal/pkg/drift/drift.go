@@ -0,0 +1,75 @@
+// Package drift detects instances of the same version serving with a
+// different effective configuration.
+package drift
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Fingerprint returns a stable hash of the effective configuration values.
+// Values are hashed, never exposed, so secrets may be included.
+func Fingerprint(values map[string]string) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s=%q\n", k, values[k])
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// Status describes the configuration an instance serves with.
+type Status struct {
+	Instance    string    `json:"instance"`
+	Version     string    `json:"version"`
+	Fingerprint string    `json:"fingerprint"`
+	StartedAt   time.Time `json:"startedAt"`
+	// Contract is the MAJOR.MINOR version of the API contract served, see
+	// package compat.
+	Contract string `json:"contract,omitempty"`
+}
+
+// DriftError reports the instances of a version that disagree on their
+// configuration fingerprint.
+type DriftError struct {
+	Version string
+	// Instances maps every fingerprint seen to the instances serving it.
+	Instances map[string][]string
+}
+
+func (e *DriftError) Error() string {
+	fps := make([]string, 0, len(e.Instances))
+	for fp, instances := range e.Instances {
+		fps = append(fps, fmt.Sprintf("%s=[%s]", fp, strings.Join(instances, " ")))
+	}
+	sort.Strings(fps)
+	return fmt.Sprintf("config drift in version %s: %s", e.Version, strings.Join(fps, ", "))
+}
+
+// Compare returns a *DriftError when the statuses of version don't all
+// share a single fingerprint, nil otherwise. Statuses of other versions are
+// ignored, as a rollout legitimately changes the configuration.
+func Compare(version string, statuses []Status) error {
+	instances := map[string][]string{}
+	for _, s := range statuses {
+		if s.Version == version {
+			instances[s.Fingerprint] = append(instances[s.Fingerprint], s.Instance)
+		}
+	}
+	if len(instances) <= 1 {
+		return nil
+	}
+	for _, v := range instances {
+		sort.Strings(v)
+	}
+	return &DriftError{Version: version, Instances: instances}
+}
@@ -0,0 +1,162 @@
+// Package dsindex tells the composite indexes Datastore queries need, and
+// checks them against the ones declared in index.yaml, so that a query
+// lacking its index fails in development rather than with
+// FailedPrecondition in production.
+package dsindex
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/manifest"
+)
+
+// ErrMissingIndex indicates queries needing composite indexes that aren't
+// declared.
+var ErrMissingIndex = errors.New("missing composite index")
+
+// The directions of the properties of an index.
+const (
+	Asc  = "asc"
+	Desc = "desc"
+)
+
+// Query is the shape of a Datastore query: what it filters and sorts on,
+// regardless of the values.
+type Query struct {
+	Kind string
+	// Equality are the properties of the equality filters.
+	Equality []string
+	// Inequality is the property of the inequality filters, if any.
+	Inequality string
+	// Orders are the sort orders, in their order.
+	Orders []manifest.Property
+}
+
+// Index returns the composite index q needs: its equality properties, then
+// its inequality property and its sort orders, the inequality property
+// leading the orders. It returns false when the built-in indexes serve q:
+// queries on equality filters only, and queries filtering and sorting on a
+// single property.
+func (q Query) Index() (manifest.Index, bool) {
+	eq, sorted := q.split()
+	if len(sorted) == 0 || len(eq) == 0 && len(sorted) == 1 {
+		return manifest.Index{}, false
+	}
+	idx := manifest.Index{Kind: q.Kind}
+	for _, p := range eq {
+		idx.Properties = append(idx.Properties, manifest.Property{Name: p, Direction: Asc})
+	}
+	idx.Properties = append(idx.Properties, sorted...)
+	return idx, true
+}
+
+// CoveredBy reports whether the declared index d serves q: the equality
+// properties of q first, in any order, then its sorted ones.
+func (q Query) CoveredBy(d manifest.Index) bool {
+	idx, ok := q.Index()
+	if !ok {
+		return true
+	}
+	if d.Kind != idx.Kind || len(d.Properties) != len(idx.Properties) {
+		return false
+	}
+	eq, sorted := q.split()
+	lead := map[string]bool{}
+	for _, p := range d.Properties[:len(eq)] {
+		lead[p.Name] = true
+	}
+	for _, p := range eq {
+		if !lead[p] {
+			return false
+		}
+	}
+	for i, p := range sorted {
+		if d.Properties[len(eq)+i] != p {
+			return false
+		}
+	}
+	return true
+}
+
+// split returns the equality properties of q sorted by name, and the
+// properties it sorts on, the inequality one leading and the ones moot
+// under an equality filter left out.
+func (q Query) split() (eq []string, sorted []manifest.Property) {
+	orders := q.Orders
+	if q.Inequality != "" && (len(orders) == 0 || orders[0].Name != q.Inequality) {
+		orders = append([]manifest.Property{{Name: q.Inequality, Direction: Asc}}, orders...)
+	}
+	filtered := map[string]bool{}
+	for _, p := range q.Equality {
+		if !filtered[p] {
+			filtered[p] = true
+			eq = append(eq, p)
+		}
+	}
+	sort.Strings(eq)
+	for _, o := range orders {
+		if !filtered[o.Name] {
+			sorted = append(sorted, o)
+		}
+	}
+	return eq, sorted
+}
+
+// Required returns the composite indexes qs need, once each.
+func Required(qs []Query) []manifest.Index {
+	var out []manifest.Index
+	seen := map[string]bool{}
+	for _, q := range qs {
+		idx, ok := q.Index()
+		if !ok || seen[key(idx)] {
+			continue
+		}
+		seen[key(idx)] = true
+		out = append(out, idx)
+	}
+	return out
+}
+
+// Missing returns the composite indexes qs need that none of declared
+// serves, once each.
+func Missing(qs []Query, declared []manifest.Index) []manifest.Index {
+	var uncovered []Query
+	for _, q := range qs {
+		covered := false
+		for _, d := range declared {
+			if q.CoveredBy(d) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			uncovered = append(uncovered, q)
+		}
+	}
+	return Required(uncovered)
+}
+
+// Check returns ErrMissingIndex, listing them, when declared misses indexes
+// qs need.
+func Check(qs []Query, declared []manifest.Index) error {
+	missing := Missing(qs, declared)
+	if len(missing) == 0 {
+		return nil
+	}
+	keys := make([]string, len(missing))
+	for i, idx := range missing {
+		keys[i] = key(idx)
+	}
+	return errors.Wrap(ErrMissingIndex, fmt.Errorf("%s", strings.Join(keys, "; ")))
+}
+
+func key(idx manifest.Index) string {
+	props := make([]string, len(idx.Properties))
+	for i, p := range idx.Properties {
+		props[i] = p.Name + " " + p.Direction
+	}
+	return idx.Kind + "(" + strings.Join(props, ", ") + ")"
+}
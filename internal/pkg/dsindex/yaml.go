@@ -0,0 +1,78 @@
+package dsindex
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/manifest"
+)
+
+// ErrInvalidFile indicates an index.yaml that couldn't be read.
+var ErrInvalidFile = errors.New("invalid index file")
+
+// Parse reads the composite indexes declared in an index.yaml: the kind and
+// properties of its entries, the property directions defaulting to
+// ascending. Ancestor indexes are read as the others.
+func Parse(r io.Reader) ([]manifest.Index, error) {
+	var out []manifest.Index
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := s.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		field := strings.TrimSpace(text)
+		if field == "" || field == "indexes:" {
+			continue
+		}
+		invalid := func() error {
+			return errors.Wrap(ErrInvalidFile, fmt.Errorf("line %d: %q", line, s.Text()))
+		}
+		name, value := field, ""
+		if i := strings.IndexByte(field, ':'); i >= 0 {
+			name, value = strings.TrimSpace(field[:i]), strings.TrimSpace(field[i+1:])
+		}
+		item := strings.HasPrefix(name, "- ")
+		name = strings.TrimSpace(strings.TrimPrefix(name, "- "))
+
+		switch {
+		case name == "kind" && item:
+			out = append(out, manifest.Index{Kind: value})
+		case len(out) == 0:
+			return nil, invalid()
+		case name == "ancestor" || name == "properties":
+		case name == "name" && item:
+			idx := &out[len(out)-1]
+			idx.Properties = append(idx.Properties, manifest.Property{Name: value, Direction: Asc})
+		case name == "direction" && len(out[len(out)-1].Properties) > 0 && (value == Asc || value == Desc):
+			props := out[len(out)-1].Properties
+			props[len(props)-1].Direction = value
+		default:
+			return nil, invalid()
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, errors.Wrap(ErrInvalidFile, err)
+	}
+	return out, nil
+}
+
+// Write writes idxs to w as entries of index.yaml.
+func Write(w io.Writer, idxs []manifest.Index) error {
+	var b strings.Builder
+	for _, idx := range idxs {
+		fmt.Fprintf(&b, "- kind: %s\n  properties:\n", idx.Kind)
+		for _, p := range idx.Properties {
+			fmt.Fprintf(&b, "  - name: %s\n", p.Name)
+			if p.Direction == Desc {
+				fmt.Fprintf(&b, "    direction: %s\n", p.Direction)
+			}
+		}
+		b.WriteString("\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
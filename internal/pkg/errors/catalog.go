@@ -0,0 +1,95 @@
+package errors
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+var (
+	catalogMu sync.RWMutex
+	catalog   = map[string]string{}
+	helpURL   string
+	helpURLs  = map[string]string{}
+)
+
+// NewCoded returns an Error that formats as the given text and carries the
+// stable code, e.g. "ADD-001", clients can branch on instead of parsing
+// messages. The code is registered in the catalog; registering it twice
+// panics, so codes are meant for package level error variables.
+func NewCoded(code, text string) Error {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	if prev, ok := catalog[code]; ok {
+		panic(fmt.Sprintf("errors: code %s already registered for %q", code, prev))
+	}
+	catalog[code] = text
+	return &customError{
+		code: code,
+		msg:  text,
+		err:  nil,
+	}
+}
+
+// Lookup returns the message registered for code.
+func Lookup(code string) (string, bool) {
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+	text, ok := catalog[code]
+	return text, ok
+}
+
+// Catalog returns the registered codes and their messages.
+func Catalog() map[string]string {
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+	res := make(map[string]string, len(catalog))
+	for code, text := range catalog {
+		res[code] = text
+	}
+	return res
+}
+
+// Code returns the outermost catalog code carried by err, or "" when none of
+// its layers has one.
+func Code(err error) string {
+	for ce := Cast(err); ce != nil; ce = ce.Err() {
+		if code := ce.Code(); code != "" {
+			return code
+		}
+	}
+	return ""
+}
+
+// SetHelpURL sets the template of the URLs documenting the codes, whose
+// {code} placeholder is replaced by the code, e.g.
+// "https://example.com/errors#{code}". An empty template documents none.
+func SetHelpURL(template string) {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	helpURL = template
+}
+
+// RegisterHelpURL documents code with url rather than the template of
+// SetHelpURL.
+func RegisterHelpURL(code, url string) {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	helpURLs[code] = url
+}
+
+// HelpURL returns the URL documenting code, or "" when none does.
+func HelpURL(code string) string {
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+	if code == "" {
+		return ""
+	}
+	if url, ok := helpURLs[code]; ok {
+		return url
+	}
+	if _, ok := catalog[code]; !ok || helpURL == "" {
+		return ""
+	}
+	return strings.Replace(helpURL, "{code}", code, -1)
+}
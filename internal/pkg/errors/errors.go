@@ -6,11 +6,14 @@ import (
 )
 
 type Errors struct {
+	Code         string `json:"code,omitempty"`
 	Domain       string `json:"domain,omitempty"`
 	Message      string `json:"message"`
 	Reason       string `json:"reason,omitempty"`
 	Location     string `json:"location,omitempty"`
 	LocationType string `json:"locationType,omitempty"`
+	// Index is the position of the failed item of a batch, see MultiError.
+	Index *int `json:"index,omitempty"`
 }
 
 func FromError(err string) []Errors {
@@ -36,27 +39,33 @@ type Error interface {
 
 	// Err returns wrapped error
 	Err() Error
+
+	// Code returns the catalog code of this layer, see NewCoded
+	Code() string
 }
 
 var _ Error = (*customError)(nil)
 
 // customError struct represents a Mainflux error
 type customError struct {
+	code         string
 	msg          string
 	domain       string
 	reason       string
 	location     string
 	locationType string
 	err          Error
+	// stack is where the error was wrapped, see StackID.
+	stack []uintptr
 }
 
 func (ce *customError) Errors() []Errors {
 	if ce != nil {
 		if ce.err != nil {
-			return append([]Errors{Errors{Message: ce.msg}}, ce.err.Errors()...)
+			return append([]Errors{Errors{Code: ce.code, Message: ce.msg}}, ce.err.Errors()...)
 		}
 
-		return []Errors{Errors{Message: ce.msg}}
+		return []Errors{Errors{Code: ce.code, Message: ce.msg}}
 	}
 	return []Errors{}
 }
@@ -80,6 +89,10 @@ func (ce *customError) Err() Error {
 	return ce.err
 }
 
+func (ce *customError) Code() string {
+	return ce.code
+}
+
 // Contains inspects if Error's message is same as error
 // in argument. If not it continues further unwrapping
 // layers of Error until it founds it or unwrap all layers
@@ -103,8 +116,10 @@ func Wrap(wrapper Error, err error) Error {
 		return nil
 	}
 	return &customError{
-		msg: wrapper.Msg(),
-		err: Cast(err),
+		code:  wrapper.Code(),
+		msg:   wrapper.Msg(),
+		err:   Cast(err),
+		stack: callers(),
 	}
 }
 
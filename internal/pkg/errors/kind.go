@@ -0,0 +1,295 @@
+package errors
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Kind classifies errors by what went wrong, independently of the transport
+// reporting them. The kinds follow google.rpc.Code, see
+// https://github.com/googleapis/googleapis/blob/master/google/rpc/code.proto
+type Kind codes.Code
+
+// The error kinds.
+const (
+	KindOK                 = Kind(codes.OK)
+	KindCanceled           = Kind(codes.Canceled)
+	KindUnknown            = Kind(codes.Unknown)
+	KindInvalidArgument    = Kind(codes.InvalidArgument)
+	KindDeadlineExceeded   = Kind(codes.DeadlineExceeded)
+	KindNotFound           = Kind(codes.NotFound)
+	KindAlreadyExists      = Kind(codes.AlreadyExists)
+	KindPermissionDenied   = Kind(codes.PermissionDenied)
+	KindResourceExhausted  = Kind(codes.ResourceExhausted)
+	KindFailedPrecondition = Kind(codes.FailedPrecondition)
+	KindAborted            = Kind(codes.Aborted)
+	KindOutOfRange         = Kind(codes.OutOfRange)
+	KindUnimplemented      = Kind(codes.Unimplemented)
+	KindInternal           = Kind(codes.Internal)
+	KindUnavailable        = Kind(codes.Unavailable)
+	KindDataLoss           = Kind(codes.DataLoss)
+	KindUnauthenticated    = Kind(codes.Unauthenticated)
+)
+
+// httpStatuses maps every kind to its HTTP status.
+var httpStatuses = map[Kind]int{
+	KindOK:                 http.StatusOK,
+	KindCanceled:           http.StatusRequestTimeout,
+	KindUnknown:            http.StatusInternalServerError,
+	KindInvalidArgument:    http.StatusBadRequest,
+	KindDeadlineExceeded:   http.StatusGatewayTimeout,
+	KindNotFound:           http.StatusNotFound,
+	KindAlreadyExists:      http.StatusConflict,
+	KindPermissionDenied:   http.StatusForbidden,
+	KindResourceExhausted:  http.StatusTooManyRequests,
+	KindFailedPrecondition: http.StatusPreconditionFailed,
+	KindAborted:            http.StatusConflict,
+	KindOutOfRange:         http.StatusBadRequest,
+	KindUnimplemented:      http.StatusNotImplemented,
+	KindInternal:           http.StatusInternalServerError,
+	KindUnavailable:        http.StatusServiceUnavailable,
+	KindDataLoss:           http.StatusInternalServerError,
+	KindUnauthenticated:    http.StatusUnauthorized,
+}
+
+// httpKinds maps HTTP statuses back to the kind they most likely report,
+// where several kinds share a status.
+var httpKinds = map[int]Kind{
+	http.StatusOK:                  KindOK,
+	http.StatusBadRequest:          KindInvalidArgument,
+	http.StatusUnauthorized:        KindUnauthenticated,
+	http.StatusForbidden:           KindPermissionDenied,
+	http.StatusNotFound:            KindNotFound,
+	http.StatusRequestTimeout:      KindCanceled,
+	http.StatusConflict:            KindAlreadyExists,
+	http.StatusGone:                KindNotFound,
+	http.StatusPreconditionFailed:  KindFailedPrecondition,
+	http.StatusTooManyRequests:     KindResourceExhausted,
+	http.StatusInternalServerError: KindInternal,
+	http.StatusNotImplemented:      KindUnimplemented,
+	http.StatusServiceUnavailable:  KindUnavailable,
+	http.StatusGatewayTimeout:      KindDeadlineExceeded,
+}
+
+type registered struct {
+	kind Kind
+	err  Error
+}
+
+var (
+	kindsMu sync.RWMutex
+	kinds   = map[string]registered{}
+)
+
+// Register records err as being of kind and returns it, so domain errors
+// declare their kind where they're defined:
+//
+//	var ErrNotFound = errors.Register(errors.KindNotFound, errors.New("not found"))
+//
+// Errors are matched by message, like Contains does.
+func Register(kind Kind, err Error) Error {
+	kindsMu.Lock()
+	defer kindsMu.Unlock()
+	kinds[err.Msg()] = registered{kind: kind, err: err}
+	return err
+}
+
+// KindOf returns the kind of the outermost registered layer of err. gRPC
+// status errors have the kind of their code. Other errors are KindUnknown.
+func KindOf(err error) Kind {
+	if err == nil {
+		return KindOK
+	}
+	if s, ok := status.FromError(err); ok {
+		return KindFromGRPCCode(s.Code())
+	}
+	if m, ok := err.(*MultiError); ok {
+		if kind, ok := m.kind(); ok {
+			return kind
+		}
+	}
+	kindsMu.RLock()
+	defer kindsMu.RUnlock()
+	for ce := Cast(err); ce != nil; ce = ce.Err() {
+		if r, ok := kinds[ce.Msg()]; ok {
+			return r.kind
+		}
+	}
+	return KindUnknown
+}
+
+// GRPCCode returns the gRPC code reporting kind.
+func GRPCCode(kind Kind) codes.Code {
+	if _, ok := httpStatuses[kind]; !ok {
+		return codes.Unknown
+	}
+	return codes.Code(kind)
+}
+
+// KindFromGRPCCode returns the kind reported by the gRPC code.
+func KindFromGRPCCode(code codes.Code) Kind {
+	if _, ok := httpStatuses[Kind(code)]; !ok {
+		return KindUnknown
+	}
+	return Kind(code)
+}
+
+// HTTPStatus returns the HTTP status reporting kind.
+func HTTPStatus(kind Kind) int {
+	if code, ok := httpStatuses[kind]; ok {
+		return code
+	}
+	return http.StatusInternalServerError
+}
+
+// KindFromHTTPStatus returns the kind most likely reported by the HTTP status.
+func KindFromHTTPStatus(code int) Kind {
+	if kind, ok := httpKinds[code]; ok {
+		return kind
+	}
+	switch {
+	case code >= 200 && code < 300:
+		return KindOK
+	case code >= 400 && code < 500:
+		return KindFailedPrecondition
+	}
+	return KindUnknown
+}
+
+// GRPCStatus returns err as a gRPC status error with the code of its kind,
+// unknown and data loss errors being internal ones. Its message is the one
+// of err sanitized when the Verbosity is sanitized, and a DebugInfo detail
+// carries the stack it was wrapped at when it's full. The errors of the
+// items of a MultiError are the field violations of a BadRequest detail.
+func GRPCStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if s, ok := status.FromError(err); ok {
+		return s.Err()
+	}
+	kind := KindOf(err)
+	code := GRPCCode(kind)
+	switch kind {
+	case KindUnknown, KindInternal, KindDataLoss:
+		code = codes.Internal
+	}
+	full := CurrentVerbosity() == VerbosityFull
+	if !full {
+		err = Sanitize(err)
+	}
+	if m, ok := err.(*MultiError); ok {
+		return multiStatus(code, m)
+	}
+	s := status.New(code, err.Error())
+	if id := StackID(err); full && id != "" {
+		if d, derr := s.WithDetails(&errdetails.DebugInfo{StackEntries: Stack(err), Detail: "stack_id=" + id}); derr == nil {
+			s = d
+		}
+	}
+	return s.Err()
+}
+
+// multiStatus returns m as a gRPC status error of code whose message is the
+// one of the wrapper, the errors of the items being the field violations of
+// a BadRequest detail.
+func multiStatus(code codes.Code, m *MultiError) error {
+	br := &errdetails.BadRequest{}
+	for _, e := range m.Errors()[1:] {
+		br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       e.Location,
+			Description: e.Message,
+		})
+	}
+	s, err := status.New(code, m.Msg()).WithDetails(br)
+	if err != nil {
+		return status.Error(code, m.Error())
+	}
+	return s.Err()
+}
+
+// fromMultiStatus returns the MultiError wrapping r reported by s, when it
+// has a BadRequest detail.
+func fromMultiStatus(s *status.Status, r Error) (*MultiError, bool) {
+	for _, d := range s.Details() {
+		br, ok := d.(*errdetails.BadRequest)
+		if !ok {
+			continue
+		}
+		var m *MultiError
+		for _, v := range br.GetFieldViolations() {
+			i := strings.LastIndexByte(v.GetField(), '[')
+			if i < 0 {
+				continue
+			}
+			index, err := strconv.Atoi(strings.TrimSuffix(v.GetField()[i+1:], "]"))
+			if err != nil {
+				continue
+			}
+			if m == nil {
+				m = NewMulti(r, v.GetField()[:i])
+			}
+			m.Add(index, FromMessage(v.GetDescription()))
+		}
+		return m, m != nil
+	}
+	return nil, false
+}
+
+// FromGRPCStatus returns the registered error reported by the gRPC status
+// error err, the reverse of GRPCStatus, so clients can match it with
+// Contains. A MultiError is rebuilt with the errors of its items. Other
+// errors are returned as is. The reference of a RequestInfo detail is kept,
+// see Reference.
+func FromGRPCStatus(err error) error {
+	s, ok := status.FromError(err)
+	if !ok || err == nil {
+		return err
+	}
+	res := fromStatus(s, err)
+	for _, d := range s.Details() {
+		if ri, ok := d.(*errdetails.RequestInfo); ok && ri.GetRequestId() != "" {
+			return WithReference(res, ri.GetRequestId())
+		}
+	}
+	return res
+}
+
+// fromStatus returns the registered error reported by s, of err.
+func fromStatus(s *status.Status, err error) error {
+	parts := strings.SplitN(s.Message(), " → ", 2)
+	kindsMu.RLock()
+	r, ok := kinds[parts[0]]
+	kindsMu.RUnlock()
+	if !ok {
+		return err
+	}
+	if m, ok := fromMultiStatus(s, r.err); ok {
+		return m
+	}
+	return FromMessage(s.Message())
+}
+
+// FromMessage returns the registered error formatting as msg, wrapping the
+// rest of its message, and New(msg) when it's not registered, for clients
+// to rebuild the errors reported by the servers.
+func FromMessage(msg string) Error {
+	parts := strings.SplitN(msg, " → ", 2)
+	kindsMu.RLock()
+	r, ok := kinds[parts[0]]
+	kindsMu.RUnlock()
+	switch {
+	case !ok:
+		return New(msg)
+	case len(parts) == 2:
+		return Wrap(r.err, New(parts[1]))
+	default:
+		return r.err
+	}
+}
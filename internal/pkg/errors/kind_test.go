@@ -0,0 +1,152 @@
+package errors
+
+import (
+	stderrors "errors"
+	"net/http"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestKindMapping(t *testing.T) {
+	cases := []struct {
+		kind Kind
+		code codes.Code
+		http int
+		// fromHTTP is the kind the status maps back to, another one when
+		// kinds share it.
+		fromHTTP Kind
+	}{
+		{KindOK, codes.OK, http.StatusOK, KindOK},
+		{KindCanceled, codes.Canceled, http.StatusRequestTimeout, KindCanceled},
+		{KindUnknown, codes.Unknown, http.StatusInternalServerError, KindInternal},
+		{KindInvalidArgument, codes.InvalidArgument, http.StatusBadRequest, KindInvalidArgument},
+		{KindDeadlineExceeded, codes.DeadlineExceeded, http.StatusGatewayTimeout, KindDeadlineExceeded},
+		{KindNotFound, codes.NotFound, http.StatusNotFound, KindNotFound},
+		{KindAlreadyExists, codes.AlreadyExists, http.StatusConflict, KindAlreadyExists},
+		{KindPermissionDenied, codes.PermissionDenied, http.StatusForbidden, KindPermissionDenied},
+		{KindResourceExhausted, codes.ResourceExhausted, http.StatusTooManyRequests, KindResourceExhausted},
+		{KindFailedPrecondition, codes.FailedPrecondition, http.StatusPreconditionFailed, KindFailedPrecondition},
+		{KindAborted, codes.Aborted, http.StatusConflict, KindAlreadyExists},
+		{KindOutOfRange, codes.OutOfRange, http.StatusBadRequest, KindInvalidArgument},
+		{KindUnimplemented, codes.Unimplemented, http.StatusNotImplemented, KindUnimplemented},
+		{KindInternal, codes.Internal, http.StatusInternalServerError, KindInternal},
+		{KindUnavailable, codes.Unavailable, http.StatusServiceUnavailable, KindUnavailable},
+		{KindDataLoss, codes.DataLoss, http.StatusInternalServerError, KindInternal},
+		{KindUnauthenticated, codes.Unauthenticated, http.StatusUnauthorized, KindUnauthenticated},
+	}
+	if len(cases) != len(httpStatuses) {
+		t.Fatalf("%d kinds tested, %d declared", len(cases), len(httpStatuses))
+	}
+	for _, tc := range cases {
+		t.Run(tc.code.String(), func(t *testing.T) {
+			if got := GRPCCode(tc.kind); got != tc.code {
+				t.Errorf("GRPCCode = %v, want %v", got, tc.code)
+			}
+			if got := KindFromGRPCCode(tc.code); got != tc.kind {
+				t.Errorf("KindFromGRPCCode = %v, want %v", got, tc.kind)
+			}
+			if got := HTTPStatus(tc.kind); got != tc.http {
+				t.Errorf("HTTPStatus = %d, want %d", got, tc.http)
+			}
+			if got := KindFromHTTPStatus(tc.http); got != tc.fromHTTP {
+				t.Errorf("KindFromHTTPStatus(%d) = %v, want %v", tc.http, got, tc.fromHTTP)
+			}
+		})
+	}
+}
+
+func TestUnknownKind(t *testing.T) {
+	if got := GRPCCode(Kind(99)); got != codes.Unknown {
+		t.Errorf("GRPCCode(99) = %v, want Unknown", got)
+	}
+	if got := KindFromGRPCCode(codes.Code(99)); got != KindUnknown {
+		t.Errorf("KindFromGRPCCode(99) = %v, want KindUnknown", got)
+	}
+	if got := HTTPStatus(Kind(99)); got != http.StatusInternalServerError {
+		t.Errorf("HTTPStatus(99) = %d, want 500", got)
+	}
+
+	statuses := []struct {
+		status int
+		want   Kind
+	}{
+		{http.StatusNoContent, KindOK},
+		{http.StatusGone, KindNotFound},
+		{http.StatusTeapot, KindFailedPrecondition},
+		{http.StatusBadGateway, KindUnknown},
+		{http.StatusMovedPermanently, KindUnknown},
+	}
+	for _, tc := range statuses {
+		if got := KindFromHTTPStatus(tc.status); got != tc.want {
+			t.Errorf("KindFromHTTPStatus(%d) = %v, want %v", tc.status, got, tc.want)
+		}
+	}
+}
+
+var (
+	errTestNotFound = Register(KindNotFound, New("kind test: not found"))
+	errTestDenied   = Register(KindPermissionDenied, NewCoded("KINDTEST-001", "kind test: denied"))
+)
+
+func TestKindOf(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want Kind
+	}{
+		{"nil", nil, KindOK},
+		{"registered", errTestNotFound, KindNotFound},
+		{"registered coded", errTestDenied, KindPermissionDenied},
+		{"wrapping unregistered", Wrap(errTestNotFound, New("cause")), KindNotFound},
+		{"wrapped in unregistered", Wrap(New("context"), errTestNotFound), KindNotFound},
+		{"outermost registered layer", Wrap(errTestDenied, errTestNotFound), KindPermissionDenied},
+		{"unregistered", New("kind test: unregistered"), KindUnknown},
+		{"standard error", stderrors.New("kind test: standard"), KindUnknown},
+		{"standard error of a registered message", stderrors.New("kind test: not found"), KindNotFound},
+		{"grpc status", status.Error(codes.Unavailable, "down"), KindUnavailable},
+		{"grpc status of unknown code", status.Error(codes.Code(99), "?"), KindUnknown},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := KindOf(tc.err); got != tc.want {
+				t.Errorf("KindOf(%v) = %v, want %v", tc.err, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGRPCStatusRoundTrip(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		code codes.Code
+		// match is the registered error the client matches, nil when none.
+		match Error
+	}{
+		{"registered", errTestNotFound, codes.NotFound, errTestNotFound},
+		{"wrapped", Wrap(errTestDenied, New("cause")), codes.PermissionDenied, errTestDenied},
+		{"wrapped in unregistered", Wrap(New("context"), errTestNotFound), codes.NotFound, nil},
+		{"unregistered is internal", New("kind test: boom"), codes.Internal, nil},
+		{"grpc status kept", status.Error(codes.Aborted, "retry"), codes.Aborted, nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := GRPCStatus(tc.err)
+			if got := status.Code(err); got != tc.code {
+				t.Errorf("code = %v, want %v", got, tc.code)
+			}
+			back := FromGRPCStatus(err)
+			if got := KindOf(back); got != KindFromGRPCCode(tc.code) {
+				t.Errorf("KindOf(FromGRPCStatus) = %v, want %v", got, KindFromGRPCCode(tc.code))
+			}
+			if tc.match != nil && !Contains(Cast(back), tc.match) {
+				t.Errorf("FromGRPCStatus = %v, doesn't contain %v", back, tc.match)
+			}
+		})
+	}
+	if GRPCStatus(nil) != nil || FromGRPCStatus(nil) != nil {
+		t.Error("nil errors aren't kept nil")
+	}
+}
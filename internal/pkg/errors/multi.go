@@ -0,0 +1,125 @@
+package errors
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var _ Error = (*MultiError)(nil)
+
+// ItemError is the error of the item at Index of a batch.
+type ItemError struct {
+	Index int
+	Err   Error
+}
+
+// MultiError aggregates the errors of the items of a batch, each with its
+// index, under the wrapper describing the operation as a whole. Its Errors
+// hold the wrapper, then an entry per item carrying its index and located
+// at location[index], which the HTTP encoders write as is and GRPCStatus
+// turns into the field violations of a BadRequest. It's safe for
+// concurrent use, for the items of a batch to fail in parallel.
+type MultiError struct {
+	wrapper  Error
+	location string
+
+	mu    sync.Mutex
+	items []ItemError
+}
+
+// NewMulti returns an empty MultiError, the items located at location, e.g.
+// "operations". Its kind is the kind shared by all its items, else the kind
+// wrapper is registered with, see KindOf.
+func NewMulti(wrapper Error, location string) *MultiError {
+	return &MultiError{wrapper: wrapper, location: location}
+}
+
+// Add records err as the error of the item at index, doing nothing when err
+// is nil.
+func (m *MultiError) Add(index int, err error) {
+	if err == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.items = append(m.items, ItemError{Index: index, Err: Cast(err)})
+}
+
+// Items returns the errors of the items, sorted by index.
+func (m *MultiError) Items() []ItemError {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	items := append([]ItemError(nil), m.items...)
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Index < items[j].Index })
+	return items
+}
+
+// Len returns the number of items in error.
+func (m *MultiError) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.items)
+}
+
+// ErrOrNil returns m, or nil when no item failed, so that a batch returns
+// it as is.
+func (m *MultiError) ErrOrNil() Error {
+	if m == nil || m.Len() == 0 {
+		return nil
+	}
+	return m
+}
+
+// Errors returns the wrapper, then an entry per item.
+func (m *MultiError) Errors() []Errors {
+	res := []Errors{{Code: m.wrapper.Code(), Message: m.wrapper.Msg()}}
+	for _, it := range m.Items() {
+		index := it.Index
+		res = append(res, Errors{
+			Code:     Code(it.Err),
+			Message:  it.Err.Error(),
+			Location: fmt.Sprintf("%s[%d]", m.location, it.Index),
+			Index:    &index,
+		})
+	}
+	return res
+}
+
+func (m *MultiError) Error() string {
+	items := m.Items()
+	msgs := make([]string, len(items))
+	for i, it := range items {
+		msgs[i] = fmt.Sprintf("%s[%d]: %s", m.location, it.Index, it.Err.Error())
+	}
+	return fmt.Sprintf("%s → %s", m.wrapper.Msg(), strings.Join(msgs, "; "))
+}
+
+func (m *MultiError) Msg() string {
+	return m.wrapper.Msg()
+}
+
+// Err returns nil: the items aren't layers of m, see Items.
+func (m *MultiError) Err() Error {
+	return nil
+}
+
+func (m *MultiError) Code() string {
+	return m.wrapper.Code()
+}
+
+// kind returns the kind shared by all the items of m, if any.
+func (m *MultiError) kind() (Kind, bool) {
+	items := m.Items()
+	if len(items) == 0 {
+		return KindUnknown, false
+	}
+	kind := KindOf(items[0].Err)
+	for _, it := range items[1:] {
+		if KindOf(it.Err) != kind {
+			return KindUnknown, false
+		}
+	}
+	return kind, true
+}
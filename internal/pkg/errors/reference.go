@@ -0,0 +1,69 @@
+package errors
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+
+	"google.golang.org/grpc/status"
+)
+
+// referenceEncoding writes the references in the Crockford alphabet, which
+// reads aloud without confusing 0 and O or 1 and I.
+var referenceEncoding = base32.NewEncoding("0123456789ABCDEFGHJKMNPQRSTVWXYZ").WithPadding(base32.NoPadding)
+
+// NewReference returns a new error reference, 10 characters such as
+// 7K2M9Q4XBE, short enough for users to read it to support.
+func NewReference() string {
+	var b [6]byte
+	rand.Read(b[:])
+	return referenceEncoding.EncodeToString(b[:])[:10]
+}
+
+// referenceError is an Error carrying the reference the server logged it
+// with.
+type referenceError struct {
+	err Error
+	ref string
+}
+
+func (re *referenceError) Errors() []Errors { return re.err.Errors() }
+func (re *referenceError) Error() string    { return re.err.Error() }
+func (re *referenceError) Msg() string      { return re.err.Msg() }
+func (re *referenceError) Err() Error       { return re.err.Err() }
+func (re *referenceError) Code() string     { return re.err.Code() }
+
+// statusReferenceError is a referenceError of a gRPC status error, which
+// keeps being one.
+type statusReferenceError struct {
+	*referenceError
+	s *status.Status
+}
+
+func (se statusReferenceError) GRPCStatus() *status.Status { return se.s }
+
+// WithReference returns err carrying the reference ref, for clients to
+// report it, see Reference.
+func WithReference(err error, ref string) Error {
+	if err == nil {
+		return nil
+	}
+	re := &referenceError{err: Cast(err), ref: ref}
+	if s, ok := status.FromError(err); ok {
+		return statusReferenceError{re, s}
+	}
+	return re
+}
+
+// Reference returns the reference of the outermost layer of err made by
+// WithReference, if any.
+func Reference(err error) (string, bool) {
+	for ce := Cast(err); ce != nil; ce = ce.Err() {
+		switch e := ce.(type) {
+		case *referenceError:
+			return e.ref, true
+		case statusReferenceError:
+			return e.ref, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,32 @@
+package errors
+
+import "time"
+
+// retryError is an Error telling the caller when to retry.
+type retryError struct {
+	err   Error
+	after time.Duration
+}
+
+func (re *retryError) Errors() []Errors { return re.err.Errors() }
+func (re *retryError) Error() string    { return re.err.Error() }
+func (re *retryError) Msg() string      { return re.err.Msg() }
+func (re *retryError) Err() Error       { return re.err.Err() }
+func (re *retryError) Code() string     { return re.err.Code() }
+
+// WithRetryAfter returns err telling the caller to retry after d, e.g. in a
+// Retry-After header. The layers wrapping it keep telling it.
+func WithRetryAfter(err Error, d time.Duration) Error {
+	return &retryError{err: err, after: d}
+}
+
+// RetryAfter returns the delay of the outermost layer of err made by
+// WithRetryAfter, if any.
+func RetryAfter(err error) (time.Duration, bool) {
+	for ce := Cast(err); ce != nil; ce = ce.Err() {
+		if re, ok := ce.(*retryError); ok {
+			return re.after, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,151 @@
+package errors
+
+import (
+	"fmt"
+	"hash/fnv"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+)
+
+// Verbosity tells how much of the errors the transports disclose.
+type Verbosity int32
+
+const (
+	// VerbosityFull discloses every layer of the errors, and where they
+	// were wrapped, for development.
+	VerbosityFull Verbosity = iota
+	// VerbositySanitized discloses the catalog codes and messages of the
+	// errors only, hiding the messages of the errors they wrap, which may
+	// tell about the internals, for production.
+	VerbositySanitized
+)
+
+// internalMsg replaces the messages of errors without any public layer.
+const internalMsg = "internal server error"
+
+var verbosity int32
+
+// ParseVerbosity returns the Verbosity named s, "full" or "sanitized".
+func ParseVerbosity(s string) (Verbosity, error) {
+	switch s {
+	case "full":
+		return VerbosityFull, nil
+	case "sanitized":
+		return VerbositySanitized, nil
+	default:
+		return 0, fmt.Errorf("unknown error verbosity %q", s)
+	}
+}
+
+// SetVerbosity sets the Verbosity of the transports, VerbosityFull by
+// default.
+func SetVerbosity(v Verbosity) {
+	atomic.StoreInt32(&verbosity, int32(v))
+}
+
+// CurrentVerbosity returns the Verbosity set by SetVerbosity.
+func CurrentVerbosity() Verbosity {
+	return Verbosity(atomic.LoadInt32(&verbosity))
+}
+
+// public reports whether the layer ce is meant to be disclosed: it has a
+// catalog code or is registered with a kind.
+func public(ce Error) bool {
+	if ce.Code() != "" {
+		return true
+	}
+	kindsMu.RLock()
+	defer kindsMu.RUnlock()
+	_, ok := kinds[ce.Msg()]
+	return ok
+}
+
+// Sanitize returns err without the layers that aren't meant to be
+// disclosed, see VerbositySanitized, the items of a MultiError being
+// sanitized in turn. Errors without any such layer become an internal
+// server error.
+func Sanitize(err error) Error {
+	if err == nil {
+		return nil
+	}
+	if m, ok := err.(*MultiError); ok {
+		res := NewMulti(m.wrapper, m.location)
+		for _, it := range m.Items() {
+			res.Add(it.Index, Sanitize(it.Err))
+		}
+		return res
+	}
+	var layers []Error
+	for ce := Cast(err); ce != nil; ce = ce.Err() {
+		if public(ce) {
+			layers = append(layers, ce)
+		}
+	}
+	if len(layers) == 0 {
+		return New(internalMsg)
+	}
+	res := &customError{code: layers[len(layers)-1].Code(), msg: layers[len(layers)-1].Msg()}
+	for i := len(layers) - 2; i >= 0; i-- {
+		res = &customError{code: layers[i].Code(), msg: layers[i].Msg(), err: res}
+	}
+	return res
+}
+
+// maxStackDepth bounds the frames recorded by Wrap.
+const maxStackDepth = 32
+
+// callers returns the stack of the caller of the function calling it, when
+// the Verbosity is full.
+func callers() []uintptr {
+	if CurrentVerbosity() != VerbosityFull {
+		return nil
+	}
+	pcs := make([]uintptr, maxStackDepth)
+	return pcs[:runtime.Callers(3, pcs)]
+}
+
+// stackOf returns the stack of the outermost layer of err wrapped while the
+// Verbosity was full.
+func stackOf(err error) []uintptr {
+	for ce := Cast(err); ce != nil; ce = ce.Err() {
+		if c, ok := ce.(*customError); ok && len(c.stack) > 0 {
+			return c.stack
+		}
+	}
+	return nil
+}
+
+// StackID returns a short ID of the stack err was wrapped at, the same for
+// every error wrapped at the same place, or "" when it wasn't wrapped while
+// the Verbosity was full.
+func StackID(err error) string {
+	stack := stackOf(err)
+	if len(stack) == 0 {
+		return ""
+	}
+	h := fnv.New64a()
+	for _, pc := range stack {
+		h.Write([]byte(strconv.FormatUint(uint64(pc), 16)))
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// Stack returns the frames of the stack err was wrapped at, "function
+// file:line", see StackID.
+func Stack(err error) []string {
+	stack := stackOf(err)
+	if len(stack) == 0 {
+		return nil
+	}
+	var res []string
+	frames := runtime.CallersFrames(stack)
+	for {
+		f, more := frames.Next()
+		res = append(res, fmt.Sprintf("%s %s:%d", f.Function, f.File, f.Line))
+		if !more {
+			break
+		}
+	}
+	return res
+}
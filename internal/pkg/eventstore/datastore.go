@@ -0,0 +1,295 @@
+package eventstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/gcp"
+)
+
+// The Datastore kinds of the streams, holding their version, and of their
+// events, children of their stream keyed by their version.
+const (
+	StreamKind = "EventStream"
+	EventKind  = "StreamEvent"
+)
+
+// appendAttempts is the number of times an append at AnyVersion is tried,
+// the transaction being aborted by concurrent appends.
+const appendAttempts = 3
+
+type datastoreStore struct {
+	baseURL   string
+	projectID string
+	namespace string
+	client    *http.Client
+}
+
+// NewDatastoreStore returns a Store keeping the events in Cloud Datastore (or
+// Firestore in Datastore mode) through its REST API, in namespace of
+// projectID. It goes unauthenticated to the emulator when
+// DATASTORE_EMULATOR_HOST is set. Appends are transactions checking the
+// version of the stream; the events are queried by key within their stream,
+// which the built-in indexes serve.
+func NewDatastoreStore(projectID, namespace string) Store {
+	s := &datastoreStore{
+		baseURL:   "https://datastore.googleapis.com/v1/projects/" + projectID,
+		projectID: projectID,
+		namespace: namespace,
+		client:    gcp.NewClient("https://www.googleapis.com/auth/datastore"),
+	}
+	if host := os.Getenv("DATASTORE_EMULATOR_HOST"); host != "" {
+		s.baseURL = "http://" + host + "/v1/projects/" + projectID
+		s.client = http.DefaultClient
+	}
+	return s
+}
+
+func (s *datastoreStore) partition() map[string]string {
+	p := map[string]string{"projectId": s.projectID}
+	if s.namespace != "" {
+		p["namespaceId"] = s.namespace
+	}
+	return p
+}
+
+func (s *datastoreStore) streamKey(stream string) map[string]interface{} {
+	return map[string]interface{}{
+		"partitionId": s.partition(),
+		"path":        []interface{}{map[string]string{"kind": StreamKind, "name": stream}},
+	}
+}
+
+func (s *datastoreStore) eventKey(stream string, version int64) map[string]interface{} {
+	return map[string]interface{}{
+		"partitionId": s.partition(),
+		"path": []interface{}{
+			map[string]string{"kind": StreamKind, "name": stream},
+			map[string]string{"kind": EventKind, "id": strconv.FormatInt(version, 10)},
+		},
+	}
+}
+
+func (s *datastoreStore) Append(ctx context.Context, stream string, expected int64, events ...Event) (int64, error) {
+	for attempt := 1; ; attempt++ {
+		version, err := s.append(ctx, stream, expected, events)
+		if err == nil || expected != AnyVersion || attempt == appendAttempts || !errors.Contains(errors.Cast(err), ErrConflict) {
+			return version, err
+		}
+	}
+}
+
+func (s *datastoreStore) append(ctx context.Context, stream string, expected int64, events []Event) (int64, error) {
+	var tx struct {
+		Transaction string `json:"transaction"`
+	}
+	if err := s.call(ctx, "beginTransaction", map[string]interface{}{"transactionOptions": map[string]interface{}{"readWrite": map[string]interface{}{}}}, &tx); err != nil {
+		return 0, err
+	}
+	version, err := s.version(ctx, stream, tx.Transaction)
+	if err == nil && expected != AnyVersion && expected != version {
+		err = errors.Wrap(ErrConflict, fmt.Errorf("%s at version %d, expected %d", stream, version, expected))
+	}
+	if err != nil {
+		s.call(ctx, "rollback", map[string]string{"transaction": tx.Transaction}, nil)
+		return version, err
+	}
+
+	mutations := make([]interface{}, 0, len(events)+1)
+	for _, e := range events {
+		version++
+		mutations = append(mutations, map[string]interface{}{"insert": map[string]interface{}{
+			"key": s.eventKey(stream, version),
+			"properties": map[string]interface{}{
+				"type":       map[string]interface{}{"stringValue": e.Type},
+				"data":       map[string]interface{}{"blobValue": base64.StdEncoding.EncodeToString(e.Data), "excludeFromIndexes": true},
+				"recordedAt": map[string]interface{}{"timestampValue": e.RecordedAt.UTC().Format(time.RFC3339Nano)},
+			},
+		}})
+	}
+	mutations = append(mutations, map[string]interface{}{"upsert": map[string]interface{}{
+		"key": s.streamKey(stream),
+		"properties": map[string]interface{}{
+			"version": map[string]interface{}{"integerValue": strconv.FormatInt(version, 10), "excludeFromIndexes": true},
+		},
+	}})
+	err = s.call(ctx, "commit", map[string]interface{}{"mode": "TRANSACTIONAL", "transaction": tx.Transaction, "mutations": mutations}, nil)
+	return version, err
+}
+
+// version returns the version of stream, read in transaction.
+func (s *datastoreStore) version(ctx context.Context, stream, transaction string) (int64, error) {
+	var resp struct {
+		Found []struct {
+			Entity struct {
+				Properties struct {
+					Version struct {
+						IntegerValue string `json:"integerValue"`
+					} `json:"version"`
+				} `json:"properties"`
+			} `json:"entity"`
+		} `json:"found"`
+	}
+	in := map[string]interface{}{
+		"readOptions": map[string]string{"transaction": transaction},
+		"keys":        []interface{}{s.streamKey(stream)},
+	}
+	if err := s.call(ctx, "lookup", in, &resp); err != nil {
+		return 0, err
+	}
+	if len(resp.Found) == 0 {
+		return 0, nil
+	}
+	return strconv.ParseInt(resp.Found[0].Entity.Properties.Version.IntegerValue, 10, 64)
+}
+
+func (s *datastoreStore) Load(ctx context.Context, stream string, from int64, limit int) ([]Event, error) {
+	filter := map[string]interface{}{"propertyFilter": map[string]interface{}{
+		"property": map[string]string{"name": "__key__"},
+		"op":       "HAS_ANCESTOR",
+		"value":    map[string]interface{}{"keyValue": s.streamKey(stream)},
+	}}
+	if from > 0 {
+		filter = map[string]interface{}{"compositeFilter": map[string]interface{}{
+			"op": "AND",
+			"filters": []interface{}{filter, map[string]interface{}{"propertyFilter": map[string]interface{}{
+				"property": map[string]string{"name": "__key__"},
+				"op":       "GREATER_THAN",
+				"value":    map[string]interface{}{"keyValue": s.eventKey(stream, from)},
+			}}},
+		}}
+	}
+	query := map[string]interface{}{
+		"kind":   []interface{}{map[string]string{"name": EventKind}},
+		"filter": filter,
+		"order":  []interface{}{map[string]interface{}{"property": map[string]string{"name": "__key__"}, "direction": "ASCENDING"}},
+		"limit":  limit,
+	}
+	var resp struct {
+		Batch struct {
+			EntityResults []struct {
+				Entity struct {
+					Key struct {
+						Path []struct {
+							ID string `json:"id"`
+						} `json:"path"`
+					} `json:"key"`
+					Properties struct {
+						Type struct {
+							StringValue string `json:"stringValue"`
+						} `json:"type"`
+						Data struct {
+							BlobValue []byte `json:"blobValue"`
+						} `json:"data"`
+						RecordedAt struct {
+							TimestampValue time.Time `json:"timestampValue"`
+						} `json:"recordedAt"`
+					} `json:"properties"`
+				} `json:"entity"`
+			} `json:"entityResults"`
+		} `json:"batch"`
+	}
+	if err := s.call(ctx, "runQuery", map[string]interface{}{"partitionId": s.partition(), "query": query}, &resp); err != nil {
+		return nil, err
+	}
+	events := make([]Event, 0, len(resp.Batch.EntityResults))
+	for _, r := range resp.Batch.EntityResults {
+		e := r.Entity
+		if len(e.Key.Path) == 0 {
+			continue
+		}
+		version, err := strconv.ParseInt(e.Key.Path[len(e.Key.Path)-1].ID, 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(ErrStore, err)
+		}
+		events = append(events, Event{
+			Stream:     stream,
+			Version:    version,
+			Type:       e.Properties.Type.StringValue,
+			Data:       e.Properties.Data.BlobValue,
+			RecordedAt: e.Properties.RecordedAt.TimestampValue,
+		})
+	}
+	return events, nil
+}
+
+func (s *datastoreStore) Streams(ctx context.Context) ([]string, error) {
+	var streams []string
+	cursor := ""
+	for {
+		query := map[string]interface{}{
+			"kind":       []interface{}{map[string]string{"name": StreamKind}},
+			"projection": []interface{}{map[string]interface{}{"property": map[string]string{"name": "__key__"}}},
+		}
+		if cursor != "" {
+			query["startCursor"] = cursor
+		}
+		var resp struct {
+			Batch struct {
+				EntityResults []struct {
+					Entity struct {
+						Key struct {
+							Path []struct {
+								Name string `json:"name"`
+							} `json:"path"`
+						} `json:"key"`
+					} `json:"entity"`
+				} `json:"entityResults"`
+				EndCursor   string `json:"endCursor"`
+				MoreResults string `json:"moreResults"`
+			} `json:"batch"`
+		}
+		if err := s.call(ctx, "runQuery", map[string]interface{}{"partitionId": s.partition(), "query": query}, &resp); err != nil {
+			return nil, err
+		}
+		for _, r := range resp.Batch.EntityResults {
+			if path := r.Entity.Key.Path; len(path) > 0 {
+				streams = append(streams, path[len(path)-1].Name)
+			}
+		}
+		if resp.Batch.MoreResults != "NOT_FINISHED" || len(resp.Batch.EntityResults) == 0 {
+			return streams, nil
+		}
+		cursor = resp.Batch.EndCursor
+	}
+}
+
+func (s *datastoreStore) call(ctx context.Context, method string, in interface{}, out interface{}) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.baseURL+":"+method, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(ErrStore, err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusConflict:
+		// the transaction was aborted by a concurrent one, or the event
+		// inserted exists already
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return errors.Wrap(ErrConflict, fmt.Errorf("%s: %s", method, bytes.TrimSpace(msg)))
+	case resp.StatusCode != http.StatusOK:
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return errors.Wrap(ErrStore, fmt.Errorf("%s: %s %s", method, resp.Status, bytes.TrimSpace(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
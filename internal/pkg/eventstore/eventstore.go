@@ -0,0 +1,104 @@
+// Package eventstore records what happened as streams of versioned events,
+// the source of truth the projections, e.g. a history view, are rebuilt
+// from by replaying them. Appending to a stream checks its version, so that
+// writers deciding on a state they read don't overwrite each other.
+package eventstore
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// AnyVersion appends to a stream whatever its version.
+const AnyVersion int64 = -1
+
+// replayPageSize is the number of events Replay loads at a time.
+const replayPageSize = 100
+
+var (
+	// ErrConflict indicates an append to a stream whose version isn't the
+	// expected one, another writer having appended to it first.
+	ErrConflict = errors.Register(errors.KindAborted, errors.NewCoded("EVENTS-001", "event stream version conflict"))
+
+	// ErrStore indicates the event store failed.
+	ErrStore = errors.New("event store failed")
+)
+
+// Event is an event of a stream.
+type Event struct {
+	// Stream and Version are set by Append: the version of the first
+	// event of a stream is 1.
+	Stream     string          `json:"stream"`
+	Version    int64           `json:"version"`
+	Type       string          `json:"type"`
+	Data       json.RawMessage `json:"data"`
+	RecordedAt time.Time       `json:"recordedAt"`
+}
+
+// NewEvent returns the event of type typ carrying data, encoded as JSON.
+func NewEvent(typ string, data interface{}) (Event, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{Type: typ, Data: b, RecordedAt: time.Now().UTC()}, nil
+}
+
+// Store keeps the streams of events.
+type Store interface {
+	// Append appends events to stream, numbering them after its version,
+	// and returns its new version. It fails with ErrConflict when the
+	// version of the stream isn't expected, 0 for a new stream, unless
+	// expected is AnyVersion.
+	Append(ctx context.Context, stream string, expected int64, events ...Event) (int64, error)
+
+	// Load returns up to limit events of stream, in order, from the one
+	// after version from.
+	Load(ctx context.Context, stream string, from int64, limit int) ([]Event, error)
+
+	// Streams returns the names of the streams.
+	Streams(ctx context.Context) ([]string, error)
+}
+
+// Replay calls apply with the events of stream after version from, in
+// order, and returns the version of the last one applied: from when there
+// are none. It stops at the first error of apply, the version returned
+// being the one to resume from.
+func Replay(ctx context.Context, s Store, stream string, from int64, apply func(ctx context.Context, e Event) error) (int64, error) {
+	for {
+		events, err := s.Load(ctx, stream, from, replayPageSize)
+		if err != nil {
+			return from, err
+		}
+		for _, e := range events {
+			if err := apply(ctx, e); err != nil {
+				return from, err
+			}
+			from = e.Version
+		}
+		if len(events) < replayPageSize {
+			return from, nil
+		}
+	}
+}
+
+// ReplayAll replays every stream of s from its start, see Replay, and returns
+// the number of events applied.
+func ReplayAll(ctx context.Context, s Store, apply func(ctx context.Context, e Event) error) (int64, error) {
+	streams, err := s.Streams(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, stream := range streams {
+		v, err := Replay(ctx, s, stream, 0, apply)
+		n += v
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,64 @@
+package eventstore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+type memoryStore struct {
+	mu      sync.RWMutex
+	streams map[string][]Event
+}
+
+// NewMemoryStore returns a Store keeping the events in memory, for
+// development: they're lost when the instance stops.
+func NewMemoryStore() Store {
+	return &memoryStore{streams: map[string][]Event{}}
+}
+
+func (s *memoryStore) Append(_ context.Context, stream string, expected int64, events ...Event) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	version := int64(len(s.streams[stream]))
+	if expected != AnyVersion && expected != version {
+		return version, errors.Wrap(ErrConflict, fmt.Errorf("%s at version %d, expected %d", stream, version, expected))
+	}
+	for _, e := range events {
+		version++
+		e.Stream, e.Version = stream, version
+		s.streams[stream] = append(s.streams[stream], e)
+	}
+	return version, nil
+}
+
+func (s *memoryStore) Load(_ context.Context, stream string, from int64, limit int) ([]Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	events := s.streams[stream]
+	if from < 0 {
+		from = 0
+	}
+	if from >= int64(len(events)) {
+		return nil, nil
+	}
+	events = events[from:]
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return append([]Event(nil), events...), nil
+}
+
+func (s *memoryStore) Streams(_ context.Context) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	streams := make([]string, 0, len(s.streams))
+	for stream := range s.streams {
+		streams = append(streams, stream)
+	}
+	sort.Strings(streams)
+	return streams, nil
+}
@@ -0,0 +1,140 @@
+// Package examples is the registry of the example requests and responses of
+// the endpoints. Endpoints register their examples once, and every tool
+// needing some reads them from here: the examples served to API tooling,
+// the stub endpoints and the checks comparing live responses to the
+// registered ones.
+package examples
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/go-kit/kit/endpoint"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/responses"
+)
+
+// ErrMismatch indicates an endpoint answering an example request with
+// another response than the registered one.
+var ErrMismatch = errors.New("response differs from the example")
+
+// Example is an example request of an endpoint and the response it gets.
+type Example struct {
+	// Name identifies the example among those of its endpoint.
+	Name string `json:"name"`
+	// Summary describes the example in a sentence.
+	Summary string `json:"summary,omitempty"`
+	// Request is the request of the endpoint, e.g. a SumRequest.
+	Request interface{} `json:"request"`
+	// Response is the response of the endpoint to Request, e.g. a
+	// SumResponse.
+	Response interface{} `json:"response"`
+}
+
+var (
+	mu       sync.RWMutex
+	registry = map[string][]Example{}
+)
+
+// Register adds the examples of the endpoint of method. Registering a name
+// twice for the same method panics, so examples are meant to be registered
+// at init.
+func Register(method string, examples ...Example) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, e := range examples {
+		for _, prev := range registry[method] {
+			if prev.Name == e.Name {
+				panic(fmt.Sprintf("examples: %s example %q already registered", method, e.Name))
+			}
+		}
+		registry[method] = append(registry[method], e)
+	}
+}
+
+// For returns the examples of the endpoint of method, in their registration
+// order.
+func For(method string) []Example {
+	mu.RLock()
+	defer mu.RUnlock()
+	return append([]Example(nil), registry[method]...)
+}
+
+// Methods returns the methods with examples, sorted.
+func Methods() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	methods := make([]string, 0, len(registry))
+	for m := range registry {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// Stub returns an endpoint answering the response of the example of method
+// whose request equals the request, or of the first example otherwise, for
+// stub servers and clients. It fails when method has no examples.
+func Stub(method string) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		examples := For(method)
+		if len(examples) == 0 {
+			return nil, errors.New("no example for " + method)
+		}
+		for _, e := range examples {
+			if reflect.DeepEqual(e.Request, request) {
+				return e.Response, nil
+			}
+		}
+		return examples[0].Response, nil
+	}
+}
+
+// Verify runs the example requests of method through e and reports the
+// first response differing from its example, compared as JSON, with
+// ErrMismatch. It's meant for golden checks of the endpoints.
+func Verify(ctx context.Context, method string, e endpoint.Endpoint) error {
+	for _, ex := range For(method) {
+		res, err := e(ctx, ex.Request)
+		if err != nil {
+			return fmt.Errorf("%s %s: %v", method, ex.Name, err)
+		}
+		got, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+		want, err := json.Marshal(ex.Response)
+		if err != nil {
+			return err
+		}
+		if string(got) != string(want) {
+			return errors.Wrap(ErrMismatch, fmt.Errorf("%s %s: got %s, want %s", method, ex.Name, got, want))
+		}
+	}
+	return nil
+}
+
+// Handler returns a handler serving the examples of every method as JSON,
+// the responses as the transports render them, for API documentation and
+// collection exports.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		all := map[string][]Example{}
+		for _, m := range Methods() {
+			for _, e := range For(m) {
+				if ar, ok := e.Response.(responses.Responser); ok {
+					e.Response = ar.Response()
+				}
+				all[m] = append(all[m], e)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(all)
+	})
+}
@@ -0,0 +1,117 @@
+// Package expand resolves ${...} references in configuration values, so a
+// single configuration can serve several services and environments.
+//
+// The supported forms are:
+//
+//	${NAME}            value of the environment variable NAME
+//	${NAME:-fallback}  as above, fallback when NAME is unset or empty
+//	${SOURCE:key}      key looked up in the resolver registered as SOURCE
+//	$$                 a literal $
+package expand
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+var (
+	// ErrUnterminated indicates a ${ without its closing brace.
+	ErrUnterminated = errors.New("unterminated reference")
+
+	// ErrUnknownSource indicates a reference to an unregistered resolver.
+	ErrUnknownSource = errors.New("unknown reference source")
+)
+
+// Resolver returns the value of key in some source, such as a secret store.
+type Resolver interface {
+	Resolve(ctx context.Context, key string) (string, error)
+}
+
+// ResolverFunc is an adapter to allow the use of ordinary functions as Resolvers.
+type ResolverFunc func(ctx context.Context, key string) (string, error)
+
+// Resolve calls f(ctx, key).
+func (f ResolverFunc) Resolve(ctx context.Context, key string) (string, error) {
+	return f(ctx, key)
+}
+
+// Expander expands references using the environment and its registered
+// resolvers. Resolved values are cached, so every key is fetched only once.
+type Expander struct {
+	sources map[string]Resolver
+	lookup  func(string) string
+	cache   map[string]string
+}
+
+// New returns an Expander resolving ${SOURCE:key} references with sources.
+func New(sources map[string]Resolver) *Expander {
+	return &Expander{sources: sources, lookup: os.Getenv, cache: map[string]string{}}
+}
+
+// Expand returns s with all references replaced by their values.
+func (e *Expander) Expand(ctx context.Context, s string) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '$')
+		if i < 0 || i == len(s)-1 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		b.WriteString(s[:i])
+		switch s[i+1] {
+		case '$':
+			b.WriteByte('$')
+			s = s[i+2:]
+			continue
+		case '{':
+		default:
+			b.WriteByte('$')
+			s = s[i+1:]
+			continue
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", errors.Wrap(ErrUnterminated, errors.New(s[i:]))
+		}
+		v, err := e.resolve(ctx, s[i+2:i+end])
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(v)
+		s = s[i+end+1:]
+	}
+}
+
+func (e *Expander) resolve(ctx context.Context, ref string) (string, error) {
+	if v, ok := e.cache[ref]; ok {
+		return v, nil
+	}
+
+	var v string
+	switch i := strings.IndexByte(ref, ':'); {
+	case i < 0:
+		v = e.lookup(ref)
+	case strings.HasPrefix(ref[i:], ":-"):
+		if v = e.lookup(ref[:i]); v == "" {
+			v = ref[i+2:]
+		}
+	default:
+		src, ok := e.sources[ref[:i]]
+		if !ok {
+			return "", errors.Wrap(ErrUnknownSource, errors.New(ref[:i]))
+		}
+		var err error
+		if v, err = src.Resolve(ctx, ref[i+1:]); err != nil {
+			return "", err
+		}
+	}
+	e.cache[ref] = v
+	return v, nil
+}
@@ -0,0 +1,334 @@
+// Package experiment routes requests to the variants of an A/B experiment.
+// A request is assigned the variant it asks for with the Header, else the
+// one its cookie keeps, else the one its caller hashes to, so that a user
+// sees the same variant on every request, else a random one its cookie then
+// keeps. The variant is carried in the context for the service to branch
+// on, returned in the Header, and tags the metrics and traces of the
+// request.
+package experiment
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/auth"
+	"github.com/cage1016/gokit-gae/internal/pkg/tracing"
+)
+
+const (
+	// Header names the variants of a request, e.g. "history-v2=treatment",
+	// comma separated: those asked for on the request, those assigned on
+	// the response.
+	Header = "X-Experiment"
+	// MetadataKey is the gRPC metadata key of Header.
+	MetadataKey = "x-experiment"
+	// CookiePrefix prefixes the name of the experiment to name the cookie
+	// keeping the variant of a browser, e.g. exp-history-v2.
+	CookiePrefix = "exp-"
+)
+
+// How a request was assigned its variant.
+const (
+	SourceHeader = "header"
+	SourceCookie = "cookie"
+	SourceUser   = "user"
+	SourceRandom = "random"
+)
+
+// cookieMaxAge is how long a browser keeps its variant.
+const cookieMaxAge = 30 * 24 * time.Hour
+
+// Variant is a variant of an experiment, assigned to Weight of every total
+// weight of the variants requests.
+type Variant struct {
+	Name   string
+	Weight int
+}
+
+// Experiment splits requests between variants.
+type Experiment struct {
+	Name     string
+	Variants []Variant
+	total    int
+}
+
+// New returns the Experiment name of variants, which must be named and
+// have a positive weight.
+func New(name string, variants ...Variant) (*Experiment, error) {
+	if name == "" || len(variants) == 0 {
+		return nil, fmt.Errorf("experiment %q without variants", name)
+	}
+	e := &Experiment{Name: name, Variants: variants}
+	seen := map[string]bool{}
+	for _, v := range variants {
+		if v.Name == "" || v.Weight <= 0 || seen[v.Name] {
+			return nil, fmt.Errorf("experiment %q: invalid variant %q of weight %d", name, v.Name, v.Weight)
+		}
+		seen[v.Name] = true
+		e.total += v.Weight
+	}
+	return e, nil
+}
+
+// Parse returns the Experiment of s, its name then its weighted variants,
+// e.g. "history-v2:control=90,treatment=10".
+func Parse(s string) (*Experiment, error) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return nil, fmt.Errorf("experiment %q without variants", s)
+	}
+	var variants []Variant
+	for _, kv := range strings.Split(s[i+1:], ",") {
+		j := strings.IndexByte(kv, '=')
+		if j < 0 {
+			return nil, fmt.Errorf("experiment %q: variant %q without weight", s[:i], kv)
+		}
+		w, err := strconv.Atoi(strings.TrimSpace(kv[j+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("experiment %q: variant %q: %v", s[:i], kv[:j], err)
+		}
+		variants = append(variants, Variant{Name: strings.TrimSpace(kv[:j]), Weight: w})
+	}
+	return New(strings.TrimSpace(s[:i]), variants...)
+}
+
+func (e *Experiment) has(variant string) bool {
+	for _, v := range e.Variants {
+		if v.Name == variant {
+			return true
+		}
+	}
+	return false
+}
+
+// Bucket returns the variant key hashes to, the same for the same key as
+// long as the variants don't change.
+func (e *Experiment) Bucket(key string) string {
+	h := fnv.New32a()
+	h.Write([]byte(e.Name + "/" + key))
+	n := int(h.Sum32() % uint32(e.total))
+	for _, v := range e.Variants {
+		if n < v.Weight {
+			return v.Name
+		}
+		n -= v.Weight
+	}
+	return e.Variants[len(e.Variants)-1].Name
+}
+
+// Assignment is the variant of an experiment assigned to a request.
+type Assignment struct {
+	Experiment string
+	Variant    string
+	// Source tells how the variant was assigned, see SourceHeader.
+	Source string
+}
+
+func (a Assignment) String() string {
+	return a.Experiment + "=" + a.Variant
+}
+
+// assign returns the variant of the request of ctx.
+func (e *Experiment) assign(ctx context.Context) Assignment {
+	a := Assignment{Experiment: e.Name}
+	h, _ := ctx.Value(hintsContextKey).(hints)
+	switch {
+	case e.has(h.header[e.Name]):
+		a.Variant, a.Source = h.header[e.Name], SourceHeader
+	case e.has(h.cookie[e.Name]):
+		a.Variant, a.Source = h.cookie[e.Name], SourceCookie
+	case auth.Principal(ctx) != "":
+		a.Variant, a.Source = e.Bucket(auth.Principal(ctx)), SourceUser
+	default:
+		b := make([]byte, 8)
+		rand.Read(b)
+		a.Variant, a.Source = e.Bucket(hex.EncodeToString(b)), SourceRandom
+	}
+	return a
+}
+
+// Middleware returns the endpoint middleware of method assigning its
+// requests a variant, see FromContext. requests, labeled by experiment,
+// variant, method and status, counts them, and duration, labeled by
+// experiment, variant and method, observes their latency in seconds. It
+// must run after the JWT claims are verified.
+func (e *Experiment) Middleware(method string, requests metrics.Counter, duration metrics.Histogram) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			a := e.assign(ctx)
+			ctx = NewContext(ctx, a)
+			ctx = tracing.ContextWithAttributes(ctx,
+				attribute.String("experiment."+e.Name, a.Variant),
+			)
+			if t, ok := ctx.Value(trackerContextKey).(*tracker); ok {
+				t.add(a)
+			}
+			defer func(begin time.Time) {
+				status := "ok"
+				if err != nil {
+					status = "error"
+				} else if f, ok := response.(endpoint.Failer); ok && f.Failed() != nil {
+					status = "error"
+				}
+				requests.With("experiment", e.Name, "variant", a.Variant, "method", method, "status", status).Add(1)
+				duration.With("experiment", e.Name, "variant", a.Variant, "method", method).Observe(time.Since(begin).Seconds())
+			}(time.Now())
+			return next(ctx, request)
+		}
+	}
+}
+
+type contextKey int
+
+const (
+	assignmentsContextKey contextKey = iota
+	hintsContextKey
+	trackerContextKey
+)
+
+// NewContext returns ctx carrying a, on top of the assignments of the other
+// experiments ctx already carries.
+func NewContext(ctx context.Context, a Assignment) context.Context {
+	as, _ := ctx.Value(assignmentsContextKey).(map[string]Assignment)
+	next := make(map[string]Assignment, len(as)+1)
+	for k, v := range as {
+		next[k] = v
+	}
+	next[a.Experiment] = a
+	return context.WithValue(ctx, assignmentsContextKey, next)
+}
+
+// FromContext returns the variant of experiment assigned to the request of
+// ctx, and false when it isn't in the experiment.
+func FromContext(ctx context.Context, experiment string) (string, bool) {
+	as, _ := ctx.Value(assignmentsContextKey).(map[string]Assignment)
+	a, ok := as[experiment]
+	return a.Variant, ok
+}
+
+// hints are the variants a request asks for, by experiment.
+type hints struct {
+	header map[string]string
+	cookie map[string]string
+}
+
+// parseHeader returns the variants of a Header value by experiment.
+func parseHeader(values []string) map[string]string {
+	m := map[string]string{}
+	for _, value := range values {
+		for _, kv := range strings.Split(value, ",") {
+			if i := strings.IndexByte(kv, '='); i > 0 {
+				m[strings.TrimSpace(kv[:i])] = strings.TrimSpace(kv[i+1:])
+			}
+		}
+	}
+	return m
+}
+
+// tracker collects the assignments of a request for the transports, which
+// don't see the context of the endpoint.
+type tracker struct {
+	mu sync.Mutex
+	as []Assignment
+}
+
+func (t *tracker) add(a Assignment) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.as {
+		if t.as[i].Experiment == a.Experiment {
+			return
+		}
+	}
+	t.as = append(t.as, a)
+}
+
+func (t *tracker) assignments() []Assignment {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Assignment(nil), t.as...)
+}
+
+func header(as []Assignment) string {
+	values := make([]string, len(as))
+	for i, a := range as {
+		values[i] = a.String()
+	}
+	return strings.Join(values, ", ")
+}
+
+// HTTPToContext is an http RequestFunc moving the variants the request asks
+// for, with the Header or its cookies, into ctx, and tracking the ones it's
+// assigned for HTTPResponseHeaders.
+func HTTPToContext(ctx context.Context, r *http.Request) context.Context {
+	h := hints{header: parseHeader(r.Header[http.CanonicalHeaderKey(Header)]), cookie: map[string]string{}}
+	for _, c := range r.Cookies() {
+		if strings.HasPrefix(c.Name, CookiePrefix) {
+			h.cookie[c.Name[len(CookiePrefix):]] = c.Value
+		}
+	}
+	ctx = context.WithValue(ctx, hintsContextKey, h)
+	return context.WithValue(ctx, trackerContextKey, &tracker{})
+}
+
+// HTTPResponseHeaders is an http ServerResponseFunc returning the variants
+// assigned in the Header, and setting the cookie keeping the ones assigned
+// at random.
+func HTTPResponseHeaders(ctx context.Context, w http.ResponseWriter) context.Context {
+	t, ok := ctx.Value(trackerContextKey).(*tracker)
+	if !ok {
+		return ctx
+	}
+	as := t.assignments()
+	if len(as) == 0 {
+		return ctx
+	}
+	w.Header().Set(Header, header(as))
+	for _, a := range as {
+		if a.Source == SourceRandom {
+			http.SetCookie(w, &http.Cookie{
+				Name:     CookiePrefix + a.Experiment,
+				Value:    a.Variant,
+				Path:     "/",
+				MaxAge:   int(cookieMaxAge / time.Second),
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+	}
+	return ctx
+}
+
+// GRPCToContext is a grpc RequestFunc moving the variants the request asks
+// for with the Header metadata into ctx, and tracking the ones it's
+// assigned for GRPCResponseHeaders.
+func GRPCToContext(ctx context.Context, md metadata.MD) context.Context {
+	ctx = context.WithValue(ctx, hintsContextKey, hints{header: parseHeader(md.Get(MetadataKey))})
+	return context.WithValue(ctx, trackerContextKey, &tracker{})
+}
+
+// GRPCResponseHeaders is a grpc ServerResponseFunc returning the variants
+// assigned in the Header metadata.
+func GRPCResponseHeaders(ctx context.Context, hdr *metadata.MD, _ *metadata.MD) context.Context {
+	t, ok := ctx.Value(trackerContextKey).(*tracker)
+	if !ok {
+		return ctx
+	}
+	if as := t.assignments(); len(as) > 0 {
+		hdr.Set(MetadataKey, header(as))
+	}
+	return ctx
+}
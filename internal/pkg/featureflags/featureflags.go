@@ -0,0 +1,224 @@
+// Package featureflags toggles features at runtime, for everyone or for some
+// tenants, from the environment, Datastore or a remote document reloaded
+// periodically. Endpoints are gated with Gate, and service code branches
+// with Enabled on the flags Middleware puts in the context.
+package featureflags
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/tenant"
+)
+
+// ErrFeatureDisabled indicates a request to an endpoint whose flag is off for
+// the caller, as if it didn't exist.
+var ErrFeatureDisabled = errors.Register(errors.KindNotFound, errors.NewCoded("FLAG-001", "feature not available"))
+
+// Flag toggles a feature.
+type Flag struct {
+	Name string `json:"name"`
+	// Enabled turns the feature on for everyone.
+	Enabled bool `json:"enabled"`
+	// Tenants turns the feature on for these tenants only, when not
+	// Enabled.
+	Tenants []string `json:"tenants,omitempty"`
+}
+
+// EnabledFor reports whether f turns the feature on for tenant id.
+func (f Flag) EnabledFor(id string) bool {
+	if f.Enabled {
+		return true
+	}
+	for _, t := range f.Tenants {
+		if t == id && id != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Listener is called with the flags changed by an update, the zero Flag
+// standing for a flag that didn't exist, or doesn't any more.
+type Listener func(old, new Flag)
+
+// Flags holds the current flags, swapped atomically on every update so
+// requests never wait on a reload.
+type Flags struct {
+	flags atomic.Value
+
+	mu        sync.Mutex
+	listeners []Listener
+}
+
+// New returns Flags without any flag.
+func New() *Flags {
+	f := &Flags{}
+	f.flags.Store(map[string]Flag{})
+	return f
+}
+
+// OnChange registers l to be called on every flag an update changes.
+func (f *Flags) OnChange(l Listener) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.listeners = append(f.listeners, l)
+}
+
+// Update replaces the flags, calling the listeners with the ones changed.
+func (f *Flags) Update(flags []Flag) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	old := f.flags.Load().(map[string]Flag)
+	next := make(map[string]Flag, len(flags))
+	for _, fl := range flags {
+		next[fl.Name] = fl
+	}
+	f.flags.Store(next)
+
+	for name, n := range next {
+		if o, ok := old[name]; !ok || !equal(o, n) {
+			f.notify(o, n)
+		}
+	}
+	for name, o := range old {
+		if _, ok := next[name]; !ok {
+			f.notify(o, Flag{})
+		}
+	}
+}
+
+func (f *Flags) notify(old, new Flag) {
+	for _, l := range f.listeners {
+		l(old, new)
+	}
+}
+
+func equal(a, b Flag) bool {
+	if a.Name != b.Name || a.Enabled != b.Enabled || len(a.Tenants) != len(b.Tenants) {
+		return false
+	}
+	for i := range a.Tenants {
+		if a.Tenants[i] != b.Tenants[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Lookup returns the flag name, if any.
+func (f *Flags) Lookup(name string) (Flag, bool) {
+	fl, ok := f.flags.Load().(map[string]Flag)[name]
+	return fl, ok
+}
+
+// Enabled reports whether the flag name is on for tenant id, def when there
+// is no such flag.
+func (f *Flags) Enabled(name, id string, def bool) bool {
+	fl, ok := f.Lookup(name)
+	if !ok {
+		return def
+	}
+	return fl.EnabledFor(id)
+}
+
+// Snapshot returns the current flags, see snapshot.Cache.
+func (f *Flags) Snapshot() (json.RawMessage, error) {
+	m := f.flags.Load().(map[string]Flag)
+	flags := make([]Flag, 0, len(m))
+	for _, fl := range m {
+		flags = append(flags, fl)
+	}
+	return json.Marshal(flags)
+}
+
+// Restore replaces the flags with a snapshot, see snapshot.Cache.
+func (f *Flags) Restore(raw json.RawMessage) error {
+	var flags []Flag
+	if err := json.Unmarshal(raw, &flags); err != nil {
+		return err
+	}
+	f.Update(flags)
+	return nil
+}
+
+// Watch reloads the flags from src every interval until ctx is done. A
+// failing reload keeps the previous flags.
+func (f *Flags) Watch(ctx context.Context, src Source, interval time.Duration, logger log.Logger) {
+	reload := func() {
+		flags, err := src.Load(ctx)
+		if err != nil {
+			level.Warn(logger).Log("featureflags", "reload", "err", err)
+			return
+		}
+		f.Update(flags)
+	}
+
+	reload()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			reload()
+		}
+	}
+}
+
+type contextKey struct{}
+
+// NewContext returns ctx carrying f.
+func NewContext(ctx context.Context, f *Flags) context.Context {
+	return context.WithValue(ctx, contextKey{}, f)
+}
+
+// FromContext returns the flags in ctx, if any.
+func FromContext(ctx context.Context) (*Flags, bool) {
+	f, ok := ctx.Value(contextKey{}).(*Flags)
+	return f, ok
+}
+
+// Enabled reports whether the flag name is on for the tenant of ctx, def when
+// there is no such flag or no flags in ctx.
+func Enabled(ctx context.Context, name string, def bool) bool {
+	f, ok := FromContext(ctx)
+	if !ok {
+		return def
+	}
+	return f.Enabled(name, tenant.FromContext(ctx), def)
+}
+
+// Middleware returns an endpoint middleware putting f in the context, for
+// the service code to branch on with Enabled.
+func Middleware(f *Flags) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			return next(NewContext(ctx, f), request)
+		}
+	}
+}
+
+// Gate returns an endpoint middleware failing with ErrFeatureDisabled when
+// the flag name is off for the tenant of the request, def telling whether
+// it's on when there is no such flag. It must run after the tenant is
+// resolved, see tenant.Middleware.
+func Gate(f *Flags, name string, def bool) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if !f.Enabled(name, tenant.FromContext(ctx), def) {
+				return nil, ErrFeatureDisabled
+			}
+			return next(ctx, request)
+		}
+	}
+}
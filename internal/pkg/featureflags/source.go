@@ -0,0 +1,221 @@
+package featureflags
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/gcp"
+)
+
+// Kind is the Datastore kind of the flags, keyed by name.
+const Kind = "FeatureFlag"
+
+// ErrInvalidFlag indicates a flag without a name.
+var ErrInvalidFlag = errors.New("invalid feature flag")
+
+// Source loads the flags. The documents are JSON objects {"flags": [...]}.
+type Source interface {
+	Load(ctx context.Context) ([]Flag, error)
+}
+
+type envSource struct {
+	prefix string
+}
+
+// NewEnvSource returns a Source reading the flags from the environment
+// variables named prefix followed by the name of the flag, uppercase with
+// underscores for dashes, e.g. QS_ADD_FLAG_NEW_EXPORT for new-export. The
+// value is a boolean, or the comma separated tenants the flag is on for.
+func NewEnvSource(prefix string) Source {
+	return envSource{prefix: prefix}
+}
+
+func (s envSource) Load(context.Context) ([]Flag, error) {
+	var flags []Flag
+	for _, kv := range os.Environ() {
+		i := strings.IndexByte(kv, '=')
+		if i < 0 || !strings.HasPrefix(kv[:i], s.prefix) || i == len(s.prefix) {
+			continue
+		}
+		f := Flag{Name: strings.ReplaceAll(strings.ToLower(kv[len(s.prefix):i]), "_", "-")}
+		v := strings.TrimSpace(kv[i+1:])
+		if b, err := strconv.ParseBool(v); err == nil {
+			f.Enabled = b
+		} else {
+			for _, t := range strings.Split(v, ",") {
+				if t = strings.TrimSpace(t); t != "" {
+					f.Tenants = append(f.Tenants, t)
+				}
+			}
+		}
+		flags = append(flags, f)
+	}
+	sort.Slice(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+	return flags, nil
+}
+
+type fileSource struct {
+	path string
+}
+
+// NewFileSource returns a Source reading the flags from path.
+func NewFileSource(path string) Source {
+	return fileSource{path: path}
+}
+
+func (s fileSource) Load(context.Context) ([]Flag, error) {
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	return parse(b)
+}
+
+type urlSource struct {
+	url    string
+	client *http.Client
+}
+
+// NewURLSource returns a Source fetching the flags from url with client,
+// e.g. a remote config service or an object in Cloud Storage.
+func NewURLSource(url string, client *http.Client) Source {
+	return urlSource{url: url, client: client}
+}
+
+func (s urlSource) Load(ctx context.Context) ([]Flag, error) {
+	req, err := http.NewRequest(http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", s.url, resp.Status)
+	}
+	return parse(b)
+}
+
+func parse(b []byte) ([]Flag, error) {
+	var doc struct {
+		Flags []Flag `json:"flags"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	for _, f := range doc.Flags {
+		if strings.TrimSpace(f.Name) == "" {
+			return nil, ErrInvalidFlag
+		}
+	}
+	return doc.Flags, nil
+}
+
+type datastoreSource struct {
+	url       string
+	projectID string
+	namespace string
+	client    *http.Client
+}
+
+// NewDatastoreSource returns a Source reading the flags from the entities of
+// Kind in namespace of projectID, named after their flag, with an enabled
+// boolean and a tenants array of strings. It goes unauthenticated to the
+// emulator when DATASTORE_EMULATOR_HOST is set.
+func NewDatastoreSource(projectID, namespace string) Source {
+	s := &datastoreSource{
+		url:       "https://datastore.googleapis.com/v1/projects/" + projectID + ":runQuery",
+		projectID: projectID,
+		namespace: namespace,
+		client:    gcp.NewClient("https://www.googleapis.com/auth/datastore"),
+	}
+	if host := os.Getenv("DATASTORE_EMULATOR_HOST"); host != "" {
+		s.url = "http://" + host + "/v1/projects/" + projectID + ":runQuery"
+		s.client = http.DefaultClient
+	}
+	return s
+}
+
+func (s *datastoreSource) Load(ctx context.Context) ([]Flag, error) {
+	partition := map[string]string{"projectId": s.projectID}
+	if s.namespace != "" {
+		partition["namespaceId"] = s.namespace
+	}
+	b, err := json.Marshal(map[string]interface{}{
+		"partitionId": partition,
+		"query":       map[string]interface{}{"kind": []interface{}{map[string]string{"name": Kind}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("runQuery: %s %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	var out struct {
+		Batch struct {
+			EntityResults []struct {
+				Entity struct {
+					Key struct {
+						Path []struct {
+							Name string `json:"name"`
+						} `json:"path"`
+					} `json:"key"`
+					Properties struct {
+						Enabled struct {
+							BooleanValue bool `json:"booleanValue"`
+						} `json:"enabled"`
+						Tenants struct {
+							ArrayValue struct {
+								Values []struct {
+									StringValue string `json:"stringValue"`
+								} `json:"values"`
+							} `json:"arrayValue"`
+						} `json:"tenants"`
+					} `json:"properties"`
+				} `json:"entity"`
+			} `json:"entityResults"`
+		} `json:"batch"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	flags := make([]Flag, 0, len(out.Batch.EntityResults))
+	for _, r := range out.Batch.EntityResults {
+		e := r.Entity
+		if len(e.Key.Path) == 0 {
+			continue
+		}
+		f := Flag{Name: e.Key.Path[len(e.Key.Path)-1].Name, Enabled: e.Properties.Enabled.BooleanValue}
+		for _, v := range e.Properties.Tenants.ArrayValue.Values {
+			f.Tenants = append(f.Tenants, v.StringValue)
+		}
+		flags = append(flags, f)
+	}
+	return flags, nil
+}
@@ -0,0 +1,96 @@
+// Package fieldmask selects the fields of JSON values, for partial responses
+// only carrying the fields a caller asked for, as in fields=a,b.c: the field
+// a, and the field c of b. The fields of the objects in arrays are selected
+// element by element, and unknown fields are ignored.
+package fieldmask
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// Mask is a set of field paths, each field mapped to the mask of its own
+// fields, nil when it's selected whole. The nil Mask selects every field.
+type Mask map[string]Mask
+
+// Parse returns the Mask of the comma separated field paths of s, their
+// parts separated by dots. Empty paths and parts are ignored.
+func Parse(s string) Mask {
+	var m Mask
+	for _, path := range strings.Split(s, ",") {
+		var parts []string
+		for _, p := range strings.Split(path, ".") {
+			if p = strings.TrimSpace(p); p != "" {
+				parts = append(parts, p)
+			}
+		}
+		if len(parts) == 0 {
+			continue
+		}
+		if m == nil {
+			m = Mask{}
+		}
+		m.add(parts)
+	}
+	return m
+}
+
+func (m Mask) add(parts []string) {
+	sub, ok := m[parts[0]]
+	if len(parts) == 1 {
+		m[parts[0]] = nil
+		return
+	}
+	if ok && sub == nil {
+		// already selected whole
+		return
+	}
+	if sub == nil {
+		sub = Mask{}
+		m[parts[0]] = sub
+	}
+	sub.add(parts[1:])
+}
+
+// Apply returns the JSON encoding of v reduced to the fields of m, decoded
+// as generic JSON values, numbers as json.Number so they're kept exact.
+func (m Mask) Apply(v interface{}) (interface{}, error) {
+	if m == nil {
+		return v, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var tree interface{}
+	if err := dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+	return m.filter(tree), nil
+}
+
+func (m Mask) filter(v interface{}) interface{} {
+	if m == nil {
+		return v
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(m))
+		for k, sub := range m {
+			if f, ok := v[k]; ok {
+				out[k] = sub.filter(f)
+			}
+		}
+		return out
+	case []interface{}:
+		for i, e := range v {
+			v[i] = m.filter(e)
+		}
+		return v
+	default:
+		return v
+	}
+}
@@ -0,0 +1,158 @@
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+const datastoreURL = "https://datastore.googleapis.com/v1/projects/"
+
+var (
+	// ErrDatastore indicates Datastore rejected a request.
+	ErrDatastore = errors.New("datastore request failed")
+
+	// ErrDatastoreConflict indicates a transaction aborted by a concurrent
+	// one, or an inserted entity that exists already.
+	ErrDatastoreConflict = errors.New("datastore conflict")
+)
+
+// Datastore calls the REST API of Cloud Datastore (or Firestore in Datastore
+// mode) in a namespace of a project. It goes unauthenticated to the
+// emulator when DATASTORE_EMULATOR_HOST is set.
+type Datastore struct {
+	baseURL   string
+	projectID string
+	namespace string
+	client    *http.Client
+}
+
+// NewDatastore returns a Datastore for namespace of projectID, the default
+// namespace when empty.
+func NewDatastore(projectID, namespace string) *Datastore {
+	d := &Datastore{
+		baseURL:   datastoreURL + projectID,
+		projectID: projectID,
+		namespace: namespace,
+		client:    NewClient("https://www.googleapis.com/auth/datastore"),
+	}
+	if host := os.Getenv("DATASTORE_EMULATOR_HOST"); host != "" {
+		d.baseURL = "http://" + host + "/v1/projects/" + projectID
+		d.client = http.DefaultClient
+	}
+	return d
+}
+
+// Partition returns the partition ID of the namespace, e.g. of a query.
+func (d *Datastore) Partition() map[string]string {
+	p := map[string]string{"projectId": d.projectID}
+	if d.namespace != "" {
+		p["namespaceId"] = d.namespace
+	}
+	return p
+}
+
+// Key returns the key of the entity of kind named name.
+func (d *Datastore) Key(kind, name string) map[string]interface{} {
+	return map[string]interface{}{
+		"partitionId": d.Partition(),
+		"path":        []interface{}{map[string]string{"kind": kind, "name": name}},
+	}
+}
+
+// Lookup reads the properties of the entity of key into properties, in
+// transaction unless empty. It returns false when there's no such entity.
+func (d *Datastore) Lookup(ctx context.Context, transaction string, key map[string]interface{}, properties interface{}) (bool, error) {
+	in := map[string]interface{}{"keys": []interface{}{key}}
+	if transaction != "" {
+		in["readOptions"] = map[string]string{"transaction": transaction}
+	}
+	var resp struct {
+		Found []struct {
+			Entity struct {
+				Properties json.RawMessage `json:"properties"`
+			} `json:"entity"`
+		} `json:"found"`
+	}
+	if err := d.Call(ctx, "lookup", in, &resp); err != nil {
+		return false, err
+	}
+	if len(resp.Found) == 0 {
+		return false, nil
+	}
+	return true, json.Unmarshal(resp.Found[0].Entity.Properties, properties)
+}
+
+// Commit applies mutations, e.g. {"upsert": entity}, outside of any
+// transaction.
+func (d *Datastore) Commit(ctx context.Context, mutations ...interface{}) error {
+	return d.Call(ctx, "commit", map[string]interface{}{"mode": "NON_TRANSACTIONAL", "mutations": mutations}, nil)
+}
+
+// RunInTransaction calls fn in a read-write transaction, and commits the
+// mutations it returns along. fn is called again, up to attempts times in
+// all, while the commit is aborted by a concurrent transaction. An error of
+// fn rolls the transaction back, and is returned.
+func (d *Datastore) RunInTransaction(ctx context.Context, attempts int, fn func(transaction string) ([]interface{}, error)) error {
+	for attempt := 1; ; attempt++ {
+		err := d.runInTransaction(ctx, fn)
+		if err == nil || attempt >= attempts || !errors.Contains(errors.Cast(err), ErrDatastoreConflict) {
+			return err
+		}
+	}
+}
+
+func (d *Datastore) runInTransaction(ctx context.Context, fn func(transaction string) ([]interface{}, error)) error {
+	var tx struct {
+		Transaction string `json:"transaction"`
+	}
+	if err := d.Call(ctx, "beginTransaction", map[string]interface{}{"transactionOptions": map[string]interface{}{"readWrite": map[string]interface{}{}}}, &tx); err != nil {
+		return err
+	}
+	mutations, err := fn(tx.Transaction)
+	if err != nil {
+		d.Call(ctx, "rollback", map[string]string{"transaction": tx.Transaction}, nil)
+		return err
+	}
+	if mutations == nil {
+		mutations = []interface{}{}
+	}
+	return d.Call(ctx, "commit", map[string]interface{}{"mode": "TRANSACTIONAL", "transaction": tx.Transaction, "mutations": mutations}, nil)
+}
+
+// Call calls method of the API, e.g. runQuery, with in, and decodes the
+// response into out unless nil.
+func (d *Datastore) Call(ctx context.Context, method string, in interface{}, out interface{}) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, d.baseURL+":"+method, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := d.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(ErrDatastore, err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusConflict:
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return errors.Wrap(ErrDatastoreConflict, fmt.Errorf("%s: %s", method, bytes.TrimSpace(msg)))
+	case resp.StatusCode != http.StatusOK:
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return errors.Wrap(ErrDatastore, fmt.Errorf("%s: %s %s", method, resp.Status, bytes.TrimSpace(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
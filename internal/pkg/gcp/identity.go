@@ -0,0 +1,130 @@
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+const iamCredentialsURL = "https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/"
+
+var (
+	// ErrIdentityToken indicates an identity token couldn't be minted.
+	ErrIdentityToken = errors.New("identity token unavailable")
+
+	// ErrMalformedIdentityToken indicates a minted token that isn't a JWT.
+	ErrMalformedIdentityToken = errors.New("malformed identity token")
+)
+
+// idTokenSource caches the identity tokens minted by fetch until a minute
+// before they expire.
+type idTokenSource struct {
+	fetch func(ctx context.Context) (string, error)
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+func (ts *idTokenSource) Token(ctx context.Context) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.token != "" && time.Now().Add(time.Minute).Before(ts.expiresAt) {
+		return ts.token, nil
+	}
+
+	token, err := ts.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	exp, err := expiry(token)
+	if err != nil {
+		return "", err
+	}
+	ts.token, ts.expiresAt = token, exp
+	return token, nil
+}
+
+// expiry returns the exp claim of the JWT token. The signature isn't
+// verified, the token comes from Google.
+func expiry(token string) (time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, ErrMalformedIdentityToken
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, errors.Wrap(ErrMalformedIdentityToken, err)
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(b, &claims); err != nil {
+		return time.Time{}, errors.Wrap(ErrMalformedIdentityToken, err)
+	}
+	return time.Unix(claims.Exp, 0), nil
+}
+
+// NewIDTokenSource returns a TokenSource of Google-signed identity tokens of
+// the default service account for audience, such as the URL of a Cloud Run
+// service or the OAuth client ID of an IAP-protected app, served by the
+// metadata server.
+func NewIDTokenSource(audience string) TokenSource {
+	path := "instance/service-accounts/default/identity?format=full&audience=" + url.QueryEscape(audience)
+	return &idTokenSource{fetch: func(ctx context.Context) (string, error) {
+		token, err := Metadata(ctx, path)
+		if err != nil {
+			return "", errors.Wrap(ErrIdentityToken, err)
+		}
+		return token, nil
+	}}
+}
+
+// NewImpersonatedIDTokenSource returns a TokenSource of Google-signed
+// identity tokens of serviceAccount for audience, minted by the IAM
+// credentials API. The default service account needs the Service Account
+// OpenID Connect Identity Token Creator role on serviceAccount.
+func NewImpersonatedIDTokenSource(serviceAccount, audience string) TokenSource {
+	client := NewClient("https://www.googleapis.com/auth/cloud-platform")
+	u := iamCredentialsURL + url.PathEscape(serviceAccount) + ":generateIdToken"
+	return &idTokenSource{fetch: func(ctx context.Context) (string, error) {
+		body, err := json.Marshal(map[string]interface{}{"audience": audience, "includeEmail": true})
+		if err != nil {
+			return "", err
+		}
+		req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return "", errors.Wrap(ErrIdentityToken, err)
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return "", err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return "", errors.Wrap(ErrIdentityToken, fmt.Errorf("%s: %s", resp.Status, b))
+		}
+		var res struct {
+			Token string `json:"token"`
+		}
+		if err := json.Unmarshal(b, &res); err != nil {
+			return "", err
+		}
+		return res.Token, nil
+	}}
+}
@@ -0,0 +1,145 @@
+package gcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+const metadataURL = "http://metadata.google.internal/computeMetadata/v1/"
+
+// ErrMetadataUnavailable indicates the metadata server couldn't be reached,
+// typically when running outside of Google Cloud.
+var ErrMetadataUnavailable = errors.New("metadata server unavailable")
+
+// metadataClient has a short timeout so local runs fail fast.
+var metadataClient = &http.Client{Timeout: 5 * time.Second}
+
+// Metadata returns the value of the metadata server entry at path.
+func Metadata(ctx context.Context, path string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, metadataURL+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return "", errors.Wrap(ErrMetadataUnavailable, err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Wrap(ErrMetadataUnavailable, fmt.Errorf("%s: %s", path, resp.Status))
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// ProjectID returns the project the service runs in, preferring the
+// GOOGLE_CLOUD_PROJECT environment variable set by App Engine.
+func ProjectID(ctx context.Context) (string, error) {
+	if p := os.Getenv("GOOGLE_CLOUD_PROJECT"); p != "" {
+		return p, nil
+	}
+	return Metadata(ctx, "project/project-id")
+}
+
+// TokenSource returns OAuth2 access tokens.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+type metadataTokenSource struct {
+	scopes string
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// NewTokenSource returns a TokenSource for the default service account
+// served by the metadata server, cached until a minute before expiry.
+func NewTokenSource(scopes ...string) TokenSource {
+	return &metadataTokenSource{scopes: strings.Join(scopes, ",")}
+}
+
+func (ts *metadataTokenSource) Token(ctx context.Context) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.token != "" && time.Now().Add(time.Minute).Before(ts.expiresAt) {
+		return ts.token, nil
+	}
+
+	path := "instance/service-accounts/default/token"
+	if ts.scopes != "" {
+		path += "?scopes=" + url.QueryEscape(ts.scopes)
+	}
+	raw, err := Metadata(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal([]byte(raw), &tok); err != nil {
+		return "", err
+	}
+	ts.token = tok.AccessToken
+	ts.expiresAt = time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second)
+	return ts.token, nil
+}
+
+// StaticTokenSource returns token, e.g. the one printed by gcloud auth
+// print-access-token, for tools running off GCP.
+type StaticTokenSource string
+
+// Token returns ts.
+func (ts StaticTokenSource) Token(context.Context) (string, error) {
+	return string(ts), nil
+}
+
+// Transport is an http.RoundTripper adding the access token of Source to
+// every request.
+type Transport struct {
+	Source TokenSource
+	Base   http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	token, err := t.Source.Token(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	// RoundTrippers must not modify the request they were given
+	req := r.WithContext(r.Context())
+	req.Header = make(http.Header, len(r.Header)+1)
+	for k, v := range r.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return base.RoundTrip(req)
+}
+
+// NewClient returns an http.Client authenticated as the default service account.
+func NewClient(scopes ...string) *http.Client {
+	return &http.Client{Transport: &Transport{Source: NewTokenSource(scopes...)}}
+}
@@ -0,0 +1,187 @@
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+const pubsubURL = "https://pubsub.googleapis.com/v1/"
+
+// ErrPublish indicates Pub/Sub rejected a publish request.
+var ErrPublish = errors.New("pubsub publish failed")
+
+// Publisher publishes messages to a Pub/Sub topic through the REST API.
+type Publisher struct {
+	topic  string
+	client *http.Client
+}
+
+// NewPublisher returns a Publisher for topic of projectID.
+func NewPublisher(projectID, topic string) *Publisher {
+	return &Publisher{
+		topic:  fmt.Sprintf("projects/%s/topics/%s", projectID, topic),
+		client: NewClient("https://www.googleapis.com/auth/pubsub"),
+	}
+}
+
+// Publish publishes data with the given attributes and returns the message ID.
+func (p *Publisher) Publish(ctx context.Context, data []byte, attrs map[string]string) (string, error) {
+	type message struct {
+		Data       string            `json:"data"`
+		Attributes map[string]string `json:"attributes,omitempty"`
+	}
+	body, err := json.Marshal(map[string][]message{
+		"messages": {{Data: base64.StdEncoding.EncodeToString(data), Attributes: attrs}},
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, pubsubURL+p.topic+":publish", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", errors.Wrap(ErrPublish, err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Wrap(ErrPublish, fmt.Errorf("%s: %s", resp.Status, b))
+	}
+	var res struct {
+		MessageIDs []string `json:"messageIds"`
+	}
+	if err := json.Unmarshal(b, &res); err != nil {
+		return "", err
+	}
+	if len(res.MessageIDs) == 0 {
+		return "", ErrPublish
+	}
+	return res.MessageIDs[0], nil
+}
+
+// ErrPull indicates Pub/Sub rejected a pull, acknowledge or deadline
+// modification request.
+var ErrPull = errors.New("pubsub pull failed")
+
+// Message is a message pulled from a subscription.
+type Message struct {
+	// AckID acknowledges the message, or modifies its deadline.
+	AckID       string
+	ID          string
+	Data        []byte
+	Attributes  map[string]string
+	PublishTime time.Time
+	// OrderingKey is the ordering key the message was published with, the
+	// messages of a key being delivered in order by the subscriptions with
+	// message ordering enabled.
+	OrderingKey string
+}
+
+// Subscription pulls the messages of a Pub/Sub subscription through the
+// REST API.
+type Subscription struct {
+	name   string
+	client *http.Client
+}
+
+// NewSubscription returns a Subscription for subscription of projectID.
+func NewSubscription(projectID, subscription string) *Subscription {
+	return &Subscription{
+		name:   fmt.Sprintf("projects/%s/subscriptions/%s", projectID, subscription),
+		client: NewClient("https://www.googleapis.com/auth/pubsub"),
+	}
+}
+
+// Pull returns up to max messages, waiting for some until ctx is done.
+func (s *Subscription) Pull(ctx context.Context, max int) ([]Message, error) {
+	var res struct {
+		ReceivedMessages []struct {
+			AckID   string `json:"ackId"`
+			Message struct {
+				Data        string            `json:"data"`
+				Attributes  map[string]string `json:"attributes"`
+				MessageID   string            `json:"messageId"`
+				PublishTime time.Time         `json:"publishTime"`
+				OrderingKey string            `json:"orderingKey"`
+			} `json:"message"`
+		} `json:"receivedMessages"`
+	}
+	if err := s.call(ctx, "pull", map[string]int{"maxMessages": max}, &res); err != nil {
+		return nil, err
+	}
+	msgs := make([]Message, 0, len(res.ReceivedMessages))
+	for _, rm := range res.ReceivedMessages {
+		data, err := base64.StdEncoding.DecodeString(rm.Message.Data)
+		if err != nil {
+			return nil, errors.Wrap(ErrPull, err)
+		}
+		msgs = append(msgs, Message{
+			AckID:       rm.AckID,
+			ID:          rm.Message.MessageID,
+			Data:        data,
+			Attributes:  rm.Message.Attributes,
+			PublishTime: rm.Message.PublishTime,
+			OrderingKey: rm.Message.OrderingKey,
+		})
+	}
+	return msgs, nil
+}
+
+// Acknowledge acknowledges the messages of ackIDs, which aren't delivered
+// again.
+func (s *Subscription) Acknowledge(ctx context.Context, ackIDs []string) error {
+	return s.call(ctx, "acknowledge", map[string][]string{"ackIds": ackIDs}, nil)
+}
+
+// ModifyAckDeadline leases the messages of ackIDs for seconds from now, 0
+// making them available for redelivery at once.
+func (s *Subscription) ModifyAckDeadline(ctx context.Context, ackIDs []string, seconds int) error {
+	return s.call(ctx, "modifyAckDeadline", struct {
+		AckIDs  []string `json:"ackIds"`
+		Seconds int      `json:"ackDeadlineSeconds"`
+	}{ackIDs, seconds}, nil)
+}
+
+// call posts the method of the subscription with in, decoding the response
+// into out unless nil.
+func (s *Subscription) call(ctx context.Context, method string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, pubsubURL+s.name+":"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(ErrPull, err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.Wrap(ErrPull, fmt.Errorf("%s: %s", resp.Status, b))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
@@ -0,0 +1,71 @@
+package gcp
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+const secretManagerURL = "https://secretmanager.googleapis.com/v1/"
+
+// ErrSecretUnavailable indicates a secret couldn't be read from Secret Manager.
+var ErrSecretUnavailable = errors.New("secret unavailable")
+
+// SecretAccessor reads secret payloads from Secret Manager.
+type SecretAccessor struct {
+	projectID string
+	client    *http.Client
+}
+
+// NewSecretAccessor returns a SecretAccessor for the secrets of projectID.
+func NewSecretAccessor(projectID string) *SecretAccessor {
+	return &SecretAccessor{
+		projectID: projectID,
+		client:    NewClient("https://www.googleapis.com/auth/cloud-platform"),
+	}
+}
+
+// Access returns the payload of the named secret. The name may carry a
+// version as "name/version"; the latest version is read otherwise.
+func (sa *SecretAccessor) Access(ctx context.Context, name string) (string, error) {
+	version := "latest"
+	if i := strings.Index(name, "/"); i > 0 {
+		name, version = name[:i], name[i+1:]
+	}
+	u := fmt.Sprintf("%sprojects/%s/secrets/%s/versions/%s:access", secretManagerURL, sa.projectID, name, version)
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := sa.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", errors.Wrap(ErrSecretUnavailable, err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Wrap(ErrSecretUnavailable, fmt.Errorf("%s: %s", name, resp.Status))
+	}
+	var res struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(b, &res); err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(res.Payload.Data)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
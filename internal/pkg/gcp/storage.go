@@ -0,0 +1,166 @@
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+const (
+	storageURL       = "https://storage.googleapis.com/storage/v1/b/"
+	storageUploadURL = "https://storage.googleapis.com/upload/storage/v1/b/"
+)
+
+var (
+	// ErrUpload indicates Cloud Storage rejected an upload.
+	ErrUpload = errors.New("storage upload failed")
+
+	// ErrDownload indicates Cloud Storage rejected a download.
+	ErrDownload = errors.New("storage download failed")
+
+	// ErrObjectNotFound indicates a downloaded object doesn't exist.
+	ErrObjectNotFound = errors.New("storage object not found")
+
+	// ErrList indicates Cloud Storage rejected a listing.
+	ErrList = errors.New("storage listing failed")
+
+	// ErrDelete indicates Cloud Storage rejected a deletion.
+	ErrDelete = errors.New("storage deletion failed")
+)
+
+// Bucket reads and writes objects of a Cloud Storage bucket through the JSON
+// API.
+type Bucket struct {
+	name   string
+	client *http.Client
+}
+
+// NewBucket returns a Bucket for the bucket name.
+func NewBucket(name string) *Bucket {
+	return &Bucket{
+		name:   name,
+		client: NewClient("https://www.googleapis.com/auth/devstorage.read_write"),
+	}
+}
+
+// NewBucketClient returns a Bucket for the bucket name going through
+// client, e.g. one authenticated with a StaticTokenSource off GCP.
+func NewBucketClient(name string, client *http.Client) *Bucket {
+	return &Bucket{name: name, client: client}
+}
+
+// Upload creates or replaces the object name with data.
+func (b *Bucket) Upload(ctx context.Context, name, contentType string, data []byte) error {
+	u := storageUploadURL + url.PathEscape(b.name) + "/o?uploadType=media&name=" + url.QueryEscape(name)
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := b.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(ErrUpload, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return errors.Wrap(ErrUpload, fmt.Errorf("%s: %s", resp.Status, body))
+	}
+	return nil
+}
+
+// Download returns the content of the object name.
+func (b *Bucket) Download(ctx context.Context, name string) ([]byte, error) {
+	u := storageURL + url.PathEscape(b.name) + "/o/" + url.PathEscape(name) + "?alt=media"
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(ErrDownload, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errors.Wrap(ErrObjectNotFound, errors.New(name))
+	case resp.StatusCode != http.StatusOK:
+		return nil, errors.Wrap(ErrDownload, fmt.Errorf("%s: %s", resp.Status, body))
+	case err != nil:
+		return nil, errors.Wrap(ErrDownload, err)
+	}
+	return body, nil
+}
+
+// Delete deletes the object name, failing with ErrObjectNotFound when it
+// doesn't exist.
+func (b *Bucket) Delete(ctx context.Context, name string) error {
+	req, err := http.NewRequest(http.MethodDelete, storageURL+url.PathEscape(b.name)+"/o/"+url.PathEscape(name), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := b.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(ErrDelete, err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return errors.Wrap(ErrObjectNotFound, errors.New(name))
+	case resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK:
+		return errors.Wrap(ErrDelete, fmt.Errorf("%s: %s", resp.Status, body))
+	}
+	return nil
+}
+
+// List returns the names of the objects starting with prefix, in
+// lexicographic order.
+func (b *Bucket) List(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	pageToken := ""
+	for {
+		q := url.Values{"prefix": {prefix}, "fields": {"items(name),nextPageToken"}}
+		if pageToken != "" {
+			q.Set("pageToken", pageToken)
+		}
+		req, err := http.NewRequest(http.MethodGet, storageURL+url.PathEscape(b.name)+"/o?"+q.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := b.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, errors.Wrap(ErrList, err)
+		}
+		var page struct {
+			Items []struct {
+				Name string `json:"name"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if resp.StatusCode != http.StatusOK {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			return nil, errors.Wrap(ErrList, fmt.Errorf("%s: %s", resp.Status, body))
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(ErrList, err)
+		}
+		for _, it := range page.Items {
+			names = append(names, it.Name)
+		}
+		if page.NextPageToken == "" {
+			return names, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
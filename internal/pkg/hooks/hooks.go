@@ -0,0 +1,182 @@
+// Package hooks lets deployments compile in extensions of the request
+// lifecycle, such as billing, custom response headers or a corporate audit
+// trail, without modifying the transports. An extension registers its hooks
+// from the init function of a file added to the main package:
+//
+//	func init() {
+//		hooks.OnRequestEnd(func(ctx context.Context, r *hooks.Request, _ interface{}, took time.Duration) {
+//			billing.Charge(tenant.FromContext(ctx), r.Method, took)
+//		})
+//	}
+//
+// Hooks run in the calling goroutine of every endpoint call, the operations
+// of a batch included, so they must be quick and must not block.
+package hooks
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"google.golang.org/grpc/metadata"
+)
+
+// Request describes an endpoint call to the hooks.
+type Request struct {
+	// Method is the name of the endpoint, e.g. "sum".
+	Method string
+	// Request is the request of the endpoint.
+	Request interface{}
+	// Start is when the call started.
+	Start time.Time
+
+	headers *headers
+}
+
+// SetHeader sets the response header key to value, on the transports
+// collecting them with the ToContext and ResponseHeaders functions of this
+// package. It's a no-op elsewhere.
+func (r *Request) SetHeader(key, value string) {
+	if r.headers != nil {
+		r.headers.set(key, value)
+	}
+}
+
+// StartHook is called before an endpoint call, returning the context of the
+// call.
+type StartHook func(ctx context.Context, r *Request) context.Context
+
+// EndHook is called after an endpoint call, failed or not, with its
+// response and duration.
+type EndHook func(ctx context.Context, r *Request, response interface{}, took time.Duration)
+
+// ErrorHook is called after an endpoint call failing with err, before the
+// EndHooks.
+type ErrorHook func(ctx context.Context, r *Request, err error)
+
+var registry struct {
+	mu    sync.RWMutex
+	start []StartHook
+	end   []EndHook
+	error []ErrorHook
+}
+
+// OnRequestStart registers h to be called before every endpoint call, in
+// the order registered.
+func OnRequestStart(h StartHook) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.start = append(registry.start, h)
+}
+
+// OnRequestEnd registers h to be called after every endpoint call, in the
+// order registered.
+func OnRequestEnd(h EndHook) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.end = append(registry.end, h)
+}
+
+// OnRequestError registers h to be called after every endpoint call
+// failing, in the order registered.
+func OnRequestError(h ErrorHook) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.error = append(registry.error, h)
+}
+
+// Middleware returns an endpoint middleware calling the hooks registered
+// around the calls of the endpoint method. It costs nothing when none is.
+func Middleware(method string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			registry.mu.RLock()
+			start, end, errs := registry.start, registry.end, registry.error
+			registry.mu.RUnlock()
+			if len(start)+len(end)+len(errs) == 0 {
+				return next(ctx, request)
+			}
+
+			r := &Request{Method: method, Request: request, Start: time.Now()}
+			r.headers, _ = ctx.Value(headersContextKey{}).(*headers)
+			for _, h := range start {
+				ctx = h(ctx, r)
+			}
+			response, err := next(ctx, request)
+			if err != nil {
+				for _, h := range errs {
+					h(ctx, r, err)
+				}
+			}
+			took := time.Since(r.Start)
+			for _, h := range end {
+				h(ctx, r, response, took)
+			}
+			return response, err
+		}
+	}
+}
+
+type headersContextKey struct{}
+
+// headers collects the response headers set by the hooks for the
+// transports, which don't see the context of the endpoint.
+type headers struct {
+	mu     sync.Mutex
+	header http.Header
+}
+
+func (h *headers) set(key, value string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header.Set(key, value)
+}
+
+func (h *headers) each(f func(key string, values []string)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for k, v := range h.header {
+		f(k, v)
+	}
+}
+
+func collect(ctx context.Context) context.Context {
+	return context.WithValue(ctx, headersContextKey{}, &headers{header: http.Header{}})
+}
+
+// HTTPToContext is an http RequestFunc collecting the response headers set
+// by the hooks.
+func HTTPToContext(ctx context.Context, _ *http.Request) context.Context {
+	return collect(ctx)
+}
+
+// HTTPResponseHeaders is an http ServerResponseFunc writing the response
+// headers set by the hooks.
+func HTTPResponseHeaders(ctx context.Context, w http.ResponseWriter) context.Context {
+	if h, ok := ctx.Value(headersContextKey{}).(*headers); ok {
+		h.each(func(key string, values []string) {
+			w.Header()[key] = values
+		})
+	}
+	return ctx
+}
+
+// GRPCToContext is a grpc RequestFunc collecting the response headers set
+// by the hooks.
+func GRPCToContext(ctx context.Context, _ metadata.MD) context.Context {
+	return collect(ctx)
+}
+
+// GRPCResponseHeaders is a grpc ServerResponseFunc sending the response
+// headers set by the hooks as header metadata.
+func GRPCResponseHeaders(ctx context.Context, header *metadata.MD, _ *metadata.MD) context.Context {
+	if h, ok := ctx.Value(headersContextKey{}).(*headers); ok {
+		h.each(func(key string, values []string) {
+			header.Set(strings.ToLower(key), values...)
+		})
+	}
+	return ctx
+}
@@ -0,0 +1,263 @@
+// Package id generates the IDs of the requests, events and entities. The
+// generators take the clock and the entropy they read, so that tests
+// generate the same IDs on every run, and those of time ordered kinds sort
+// as they were generated, as Datastore sorts key names, at the price of
+// writing to neighboring keys.
+package id
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/clock"
+)
+
+// The kinds of generators, see NewGenerator.
+const (
+	KindUUIDv7    = "uuidv7"
+	KindULID      = "ulid"
+	KindSonyflake = "sonyflake"
+)
+
+// Generator returns new IDs. It's safe for concurrent use.
+type Generator interface {
+	New() string
+}
+
+// Func adapts a function to Generator.
+type Func func() string
+
+// New returns f().
+func (f Func) New() string { return f() }
+
+// NewGenerator returns the Generator of kind, reading the time of c and the
+// entropy of crypto/rand, machineID telling apart the instances generating
+// sonyflake IDs.
+func NewGenerator(kind string, c clock.Clock, machineID uint16) (Generator, error) {
+	switch kind {
+	case KindUUIDv7:
+		return NewUUIDv7(c, rand.Reader), nil
+	case KindULID:
+		return NewULID(c, rand.Reader), nil
+	case KindSonyflake:
+		return NewSonyflake(c, machineID), nil
+	default:
+		return nil, fmt.Errorf("unknown id generator %s", kind)
+	}
+}
+
+// monotonic hands out the millisecond timestamps of the IDs, never behind
+// the last one, so that IDs sort as generated even when the clock goes
+// backwards.
+type monotonic struct {
+	clock clock.Clock
+	last  int64
+}
+
+// next returns the timestamp of the next ID and whether it's the one of the
+// last ID.
+func (m *monotonic) next() (int64, bool) {
+	ms := m.clock.Now().UnixNano() / int64(time.Millisecond)
+	if ms <= m.last {
+		return m.last, true
+	}
+	m.last = ms
+	return ms, false
+}
+
+type uuidv7 struct {
+	mu      sync.Mutex
+	ts      monotonic
+	entropy io.Reader
+	seq     uint16
+}
+
+// NewUUIDv7 returns a Generator of RFC 9562 version 7 UUIDs, such as
+// 01890a5d-ac96-774b-bcce-b302099a8057: a millisecond timestamp, a 12 bit
+// counter ordering the UUIDs of a millisecond, and random bits read from
+// entropy.
+func NewUUIDv7(c clock.Clock, entropy io.Reader) Generator {
+	return &uuidv7{ts: monotonic{clock: c}, entropy: entropy}
+}
+
+func (g *uuidv7) New() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var b [16]byte
+	io.ReadFull(g.entropy, b[6:])
+	ms, same := g.ts.next()
+	if same {
+		g.seq++
+		if g.seq > 0xfff {
+			// The counter of the millisecond is exhausted: borrow the next.
+			g.ts.last++
+			ms, g.seq = g.ts.last, 0
+		}
+	} else {
+		// Start the counter low, leaving room to count up.
+		g.seq = binary.BigEndian.Uint16(b[6:8]) & 0x7ff
+	}
+	b[0], b[1], b[2], b[3], b[4], b[5] = byte(ms>>40), byte(ms>>32), byte(ms>>24), byte(ms>>16), byte(ms>>8), byte(ms)
+	b[6], b[7] = 0x70|byte(g.seq>>8), byte(g.seq)
+	b[8] = 0x80 | b[8]&0x3f
+	s := hex.EncodeToString(b[:])
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+// crockford is the alphabet of ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+type ulid struct {
+	mu      sync.Mutex
+	ts      monotonic
+	entropy io.Reader
+	last    [10]byte
+}
+
+// NewULID returns a Generator of ULIDs, such as 01ARZ3NDEKTSV4RRFFQ69G5FAV:
+// a millisecond timestamp and 80 random bits read from entropy, incremented
+// instead for the ULIDs of the same millisecond.
+func NewULID(c clock.Clock, entropy io.Reader) Generator {
+	return &ulid{ts: monotonic{clock: c}, entropy: entropy}
+}
+
+func (g *ulid) New() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	ms, same := g.ts.next()
+	if same && increment(g.last[:]) {
+		return g.encode(ms)
+	}
+	if same {
+		// The random bits overflowed: borrow the next millisecond.
+		g.ts.last++
+		ms = g.ts.last
+	}
+	io.ReadFull(g.entropy, g.last[:])
+	return g.encode(ms)
+}
+
+// increment increments the big endian b, reporting false on overflow.
+func increment(b []byte) bool {
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i]++; b[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *ulid) encode(ms int64) string {
+	var b [16]byte
+	b[0], b[1], b[2], b[3], b[4], b[5] = byte(ms>>40), byte(ms>>32), byte(ms>>24), byte(ms>>16), byte(ms>>8), byte(ms)
+	copy(b[6:], g.last[:])
+	// 128 bits make 26 characters of 5 bits, the first one holding 3.
+	var s [26]byte
+	hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+	for i := 25; i >= 0; i-- {
+		s[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(s[:])
+}
+
+// sonyflakeEpoch is the start of the time of sonyflake IDs.
+var sonyflakeEpoch = time.Date(2014, 9, 1, 0, 0, 0, 0, time.UTC)
+
+// sonyflakeUnit is the resolution of the time of sonyflake IDs.
+const sonyflakeUnit = 10 * time.Millisecond
+
+type sonyflake struct {
+	mu        sync.Mutex
+	clock     clock.Clock
+	machineID uint16
+	elapsed   int64
+	seq       uint16
+}
+
+// NewSonyflake returns a Generator of sonyflake IDs: 39 bits of time in
+// units of 10ms since 2014-09-01, an 8 bit sequence number and the 16 bit
+// machineID, written in decimal on 19 digits so that they sort as numbers.
+// Unlike the sonyflake package, it doesn't sleep when the sequence of a
+// unit is exhausted, but borrows the next unit.
+func NewSonyflake(c clock.Clock, machineID uint16) Generator {
+	return &sonyflake{clock: c, machineID: machineID}
+}
+
+func (g *sonyflake) New() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	elapsed := int64(g.clock.Now().Sub(sonyflakeEpoch) / sonyflakeUnit)
+	if elapsed <= g.elapsed {
+		if g.seq = (g.seq + 1) & 0xff; g.seq == 0 {
+			g.elapsed++
+		}
+	} else {
+		g.elapsed, g.seq = elapsed, 0
+	}
+	n := g.elapsed<<24 | int64(g.seq)<<16 | int64(g.machineID)
+	return fmt.Sprintf("%019d", n)
+}
+
+// MachineID returns the lower 16 bits of the first private IPv4 address of
+// the host, as the sonyflake package does, or 16 random bits when it has
+// none, as on App Engine.
+func MachineID() uint16 {
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			ipnet, ok := a.(*net.IPNet)
+			if !ok || ipnet.IP.IsLoopback() {
+				continue
+			}
+			if ip := ipnet.IP.To4(); ip != nil && isPrivate(ip) {
+				return uint16(ip[2])<<8 | uint16(ip[3])
+			}
+		}
+	}
+	var b [2]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint16(b[:])
+}
+
+func isPrivate(ip net.IP) bool {
+	return ip[0] == 10 || ip[0] == 172 && ip[1]&0xf0 == 16 || ip[0] == 192 && ip[1] == 168
+}
+
+// RequestIDHandler returns a handler giving the requests to next without
+// header an ID of g in it, and returning the ID of every request in the
+// header of its response.
+func RequestIDHandler(g Generator, header string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := r.Header.Get(header)
+		if v == "" {
+			v = g.New()
+			r.Header.Set(header, v)
+		}
+		w.Header().Set(header, v)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// NewSequence returns a Generator of the IDs prefix1, prefix2 and so on,
+// for tests.
+func NewSequence(prefix string) Generator {
+	var (
+		mu sync.Mutex
+		n  int
+	)
+	return Func(func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		n++
+		return prefix + strconv.Itoa(n)
+	})
+}
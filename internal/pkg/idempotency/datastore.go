@@ -0,0 +1,204 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/gcp"
+)
+
+// Kind is the Datastore kind of the idempotency records, named by the Digest
+// of their key.
+const Kind = "IdempotencyRecord"
+
+// reserveAttempts is the number of times a key is tried, the transaction
+// being aborted by a concurrent request with the same key.
+const reserveAttempts = 3
+
+type datastoreStore struct {
+	ds *gcp.Datastore
+}
+
+// NewDatastoreStore returns a Store shared by the instances, keeping the
+// records in Datastore, claimed in transactions. The expired ones are left
+// to a TTL policy on the expiresAt property of the kind.
+func NewDatastoreStore(ds *gcp.Datastore) Store {
+	return &datastoreStore{ds: ds}
+}
+
+// recordProperties are the properties of a record entity.
+type recordProperties struct {
+	Fingerprint struct {
+		StringValue string `json:"stringValue"`
+	} `json:"fingerprint"`
+	Done struct {
+		BooleanValue bool `json:"booleanValue"`
+	} `json:"done"`
+	StatusCode struct {
+		IntegerValue int `json:"integerValue,string"`
+	} `json:"statusCode"`
+	Header struct {
+		StringValue string `json:"stringValue"`
+	} `json:"header"`
+	Body struct {
+		BlobValue []byte `json:"blobValue"`
+	} `json:"body"`
+	ExpiresAt struct {
+		TimestampValue time.Time `json:"timestampValue"`
+	} `json:"expiresAt"`
+}
+
+func (p recordProperties) record() (Record, error) {
+	rec := Record{
+		Fingerprint: p.Fingerprint.StringValue,
+		Done:        p.Done.BooleanValue,
+		StatusCode:  p.StatusCode.IntegerValue,
+		Body:        p.Body.BlobValue,
+	}
+	if p.Header.StringValue != "" {
+		if err := json.Unmarshal([]byte(p.Header.StringValue), &rec.Header); err != nil {
+			return Record{}, err
+		}
+	}
+	return rec, nil
+}
+
+func (s *datastoreStore) key(key string) map[string]interface{} {
+	return s.ds.Key(Kind, Digest(key))
+}
+
+// entity returns the upsert mutation of rec under key, expiring after ttl.
+func (s *datastoreStore) entity(key string, rec Record, ttl time.Duration) (map[string]interface{}, error) {
+	return s.mutation("upsert", key, rec, ttl)
+}
+
+// mutation returns the op mutation, upsert or insert, of rec under key,
+// expiring after ttl.
+func (s *datastoreStore) mutation(op string, key string, rec Record, ttl time.Duration) (map[string]interface{}, error) {
+	header := rec.Header
+	if header == nil {
+		header = http.Header{}
+	}
+	h, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{op: map[string]interface{}{
+		"key": s.key(key),
+		"properties": map[string]interface{}{
+			"fingerprint": map[string]interface{}{"stringValue": rec.Fingerprint, "excludeFromIndexes": true},
+			"done":        map[string]interface{}{"booleanValue": rec.Done, "excludeFromIndexes": true},
+			"statusCode":  map[string]interface{}{"integerValue": strconv.Itoa(rec.StatusCode), "excludeFromIndexes": true},
+			"header":      map[string]interface{}{"stringValue": string(h), "excludeFromIndexes": true},
+			"body":        map[string]interface{}{"blobValue": base64.StdEncoding.EncodeToString(rec.Body), "excludeFromIndexes": true},
+			"expiresAt":   map[string]interface{}{"timestampValue": time.Now().Add(ttl).UTC().Format(time.RFC3339Nano)},
+		},
+	}}, nil
+}
+
+func (s *datastoreStore) Reserve(ctx context.Context, key string, fingerprint string, ttl time.Duration) (Record, bool, error) {
+	var existing Record
+	reserved := false
+	err := s.ds.RunInTransaction(ctx, reserveAttempts, func(tx string) ([]interface{}, error) {
+		var p recordProperties
+		found, err := s.ds.Lookup(ctx, tx, s.key(key), &p)
+		if err != nil {
+			return nil, err
+		}
+		if found && time.Now().Before(p.ExpiresAt.TimestampValue) {
+			existing, err = p.record()
+			reserved = false
+			return nil, err
+		}
+		m, err := s.entity(key, Record{Fingerprint: fingerprint}, ttl)
+		reserved = err == nil
+		return []interface{}{m}, err
+	})
+	if err != nil {
+		return Record{}, false, err
+	}
+	return existing, reserved, nil
+}
+
+func (s *datastoreStore) Complete(ctx context.Context, key string, rec Record, ttl time.Duration) error {
+	m, err := s.entity(key, rec, ttl)
+	if err != nil {
+		return err
+	}
+	return s.ds.Commit(ctx, m)
+}
+
+func (s *datastoreStore) Release(ctx context.Context, key string) error {
+	return s.ds.Commit(ctx, map[string]interface{}{"delete": s.key(key)})
+}
+
+// Claim inserts the claim of key, failing on any entity of key, even an
+// expired one, which Reserve then replaces.
+func (s *datastoreStore) Claim(ctx context.Context, key string, fingerprint string, ttl time.Duration) (bool, error) {
+	m, err := s.mutation("insert", key, Record{Fingerprint: fingerprint}, ttl)
+	if err != nil {
+		return false, err
+	}
+	err = s.ds.Commit(ctx, m)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Contains(errors.Cast(err), gcp.ErrDatastoreConflict):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// Keys queries the names of the live records, with a keys-only query on
+// expiresAt which the built-in indexes serve.
+func (s *datastoreStore) Keys(ctx context.Context) ([]string, error) {
+	var keys []string
+	cursor := ""
+	for {
+		query := map[string]interface{}{
+			"kind":       []interface{}{map[string]string{"name": Kind}},
+			"projection": []interface{}{map[string]interface{}{"property": map[string]string{"name": "__key__"}}},
+			"filter": map[string]interface{}{"propertyFilter": map[string]interface{}{
+				"property": map[string]string{"name": "expiresAt"},
+				"op":       "GREATER_THAN",
+				"value":    map[string]interface{}{"timestampValue": time.Now().UTC().Format(time.RFC3339Nano)},
+			}},
+		}
+		if cursor != "" {
+			query["startCursor"] = cursor
+		}
+		var resp struct {
+			Batch struct {
+				EntityResults []struct {
+					Entity struct {
+						Key struct {
+							Path []struct {
+								Name string `json:"name"`
+							} `json:"path"`
+						} `json:"key"`
+					} `json:"entity"`
+				} `json:"entityResults"`
+				EndCursor   string `json:"endCursor"`
+				MoreResults string `json:"moreResults"`
+			} `json:"batch"`
+		}
+		if err := s.ds.Call(ctx, "runQuery", map[string]interface{}{"partitionId": s.ds.Partition(), "query": query}, &resp); err != nil {
+			return nil, err
+		}
+		for _, r := range resp.Batch.EntityResults {
+			if path := r.Entity.Key.Path; len(path) > 0 {
+				keys = append(keys, path[len(path)-1].Name)
+			}
+		}
+		if resp.Batch.MoreResults != "NOT_FINISHED" || len(resp.Batch.EntityResults) == 0 {
+			return keys, nil
+		}
+		cursor = resp.Batch.EndCursor
+	}
+}
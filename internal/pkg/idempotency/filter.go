@@ -0,0 +1,73 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/bloom"
+)
+
+// Claimer is implemented by stores that can claim a key believed to be new
+// more cheaply than Reserve, e.g. with a Datastore insert mutation instead
+// of a read-write transaction. Claim fails without error when key exists.
+type Claimer interface {
+	Claim(ctx context.Context, key string, fingerprint string, ttl time.Duration) (claimed bool, err error)
+}
+
+// KeyLister is implemented by stores that can list their live keys, to
+// refresh a bloom.NegativeCache. The keys are listed by Digest, as the
+// shared stores don't keep them in the clear.
+type KeyLister interface {
+	Keys(ctx context.Context) ([]string, error)
+}
+
+// Digest returns the hex SHA-256 of key, what KeyLister lists and the
+// Datastore entities are named by.
+func Digest(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+type filteredStore struct {
+	Store
+	claimer Claimer
+	cache   *bloom.NegativeCache
+}
+
+// NewFilteredStore returns store with its reservations of keys never seen,
+// most of them, short-circuited by cache: they're claimed with the store's
+// Claim instead of being looked up by Reserve. Keys the cache missed fall
+// back to Reserve, so the store stays authoritative. store must implement
+// Claimer, and cache be loaded with the Keys of store.
+func NewFilteredStore(store Store, cache *bloom.NegativeCache) Store {
+	claimer, ok := store.(Claimer)
+	if !ok {
+		return store
+	}
+	return &filteredStore{Store: store, claimer: claimer, cache: cache}
+}
+
+func (fs *filteredStore) Reserve(ctx context.Context, key string, fingerprint string, ttl time.Duration) (Record, bool, error) {
+	digest := Digest(key)
+	mayContain := fs.cache.MayContain(digest)
+	if !mayContain {
+		claimed, err := fs.claimer.Claim(ctx, key, fingerprint, ttl)
+		if err != nil {
+			return Record{}, false, err
+		}
+		if claimed {
+			fs.cache.Add(digest)
+			return Record{}, true, nil
+		}
+	}
+
+	existing, reserved, err := fs.Store.Reserve(ctx, key, fingerprint, ttl)
+	if err != nil {
+		return Record{}, false, err
+	}
+	fs.cache.Observe(mayContain, !reserved)
+	fs.cache.Add(digest)
+	return existing, reserved, nil
+}
@@ -0,0 +1,42 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/metrics/discard"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/bloom"
+	"github.com/cage1016/gokit-gae/internal/pkg/clock"
+)
+
+func TestFilteredStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(clock.NewFrozen(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+	store.Reserve(ctx, "old", "f", time.Hour)
+
+	lookups := discard.NewCounter()
+	cache := bloom.NewNegativeCache(store.(KeyLister).Keys, 100, 0.01, lookups)
+	if err := cache.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// written by another instance since the refresh
+	store.Reserve(ctx, "stale", "f", time.Hour)
+	fs := NewFilteredStore(store, cache)
+
+	cases := []struct {
+		key      string
+		reserved bool
+	}{
+		{"new", true},
+		{"new", false},
+		{"old", false},
+		{"stale", false},
+	}
+	for _, tc := range cases {
+		if _, reserved, err := fs.Reserve(ctx, tc.key, "f", time.Hour); err != nil || reserved != tc.reserved {
+			t.Errorf("Reserve(%q) = %v, %v, want %v", tc.key, reserved, err, tc.reserved)
+		}
+	}
+}
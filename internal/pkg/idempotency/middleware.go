@@ -0,0 +1,195 @@
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/auth"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/responses"
+)
+
+const (
+	// HeaderKey is the request header carrying the client-chosen key.
+	HeaderKey = "Idempotency-Key"
+	// HeaderReplayed marks responses served from the store.
+	HeaderReplayed = "Idempotent-Replayed"
+)
+
+var (
+	// ErrKeyReused indicates the key was first used with a different request.
+	ErrKeyReused = errors.NewCoded("IDEM-001", "idempotency key reused with a different request")
+
+	// ErrInProgress indicates the first request with the key hasn't finished yet.
+	ErrInProgress = errors.NewCoded("IDEM-002", "request with this idempotency key is in progress")
+
+	// ErrBodyTooLarge indicates a body over the limit of the middleware.
+	ErrBodyTooLarge = errors.NewCoded("IDEM-003", "request body too large")
+)
+
+// Middleware returns an HTTP middleware that deduplicates POST requests
+// carrying an Idempotency-Key header. The first request with a key runs and
+// its response is stored for ttl; later requests with the same key and the
+// same method, path and body get the stored response replayed instead of
+// invoking the service again. Server errors aren't stored so they can be
+// retried, nor are the responses of handlers panicking. Keys are scoped to
+// the principal of the JWT verified by authn, see auth.Authenticate, so a
+// caller never gets the response stored for another one. Bodies over
+// maxBody bytes, unless 0, are answered with 413 Request Entity Too Large.
+func Middleware(store Store, ttl time.Duration, maxBody int64, authn endpoint.Middleware, logger log.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(HeaderKey)
+			if r.Method != http.MethodPost || key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if maxBody > 0 {
+				if r.ContentLength > maxBody {
+					writeError(w, http.StatusRequestEntityTooLarge, ErrBodyTooLarge)
+					return
+				}
+				r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+			}
+			body, err := ioutil.ReadAll(r.Body)
+			if _, ok := err.(*http.MaxBytesError); ok {
+				writeError(w, http.StatusRequestEntityTooLarge, ErrBodyTooLarge)
+				return
+			}
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			r.Body.Close()
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			ctx := r.Context()
+			principal := auth.Authenticate(kitjwt.HTTPToContext()(ctx, r), nil, authn)
+			key = scope(principal, key)
+			fp := fingerprint(r, principal, body)
+			rec, reserved, err := store.Reserve(ctx, key, fp, ttl)
+			if err != nil {
+				level.Error(logger).Log("idempotency_key", key, "err", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !reserved {
+				switch {
+				case rec.Fingerprint != fp:
+					writeError(w, http.StatusUnprocessableEntity, ErrKeyReused)
+				case !rec.Done:
+					writeError(w, http.StatusConflict, ErrInProgress)
+				default:
+					replay(w, rec)
+				}
+				return
+			}
+
+			defer func() {
+				if p := recover(); p != nil {
+					if err := store.Release(ctx, key); err != nil {
+						level.Error(logger).Log("idempotency_key", key, "err", err)
+					}
+					panic(p)
+				}
+			}()
+			rw := &recorder{ResponseWriter: w, code: http.StatusOK}
+			next.ServeHTTP(rw, r)
+
+			if rw.code >= http.StatusInternalServerError {
+				err = store.Release(ctx, key)
+			} else {
+				err = store.Complete(ctx, key, Record{
+					Fingerprint: fp,
+					Done:        true,
+					StatusCode:  rw.code,
+					Header:      copyHeader(w.Header()),
+					Body:        rw.body.Bytes(),
+				}, ttl)
+			}
+			if err != nil {
+				level.Error(logger).Log("idempotency_key", key, "err", err)
+			}
+		})
+	}
+}
+
+// scope returns the store key of the Idempotency-Key key sent by principal,
+// empty for anonymous callers.
+func scope(principal, key string) string {
+	return fmt.Sprintf("%q %s", principal, key)
+}
+
+func fingerprint(r *http.Request, principal string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(fmt.Sprintf("%s %s %q\n", r.Method, r.URL.Path, principal)))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func copyHeader(h http.Header) http.Header {
+	c := make(http.Header, len(h))
+	for k, v := range h {
+		c[k] = append([]string(nil), v...)
+	}
+	return c
+}
+
+func replay(w http.ResponseWriter, rec Record) {
+	for k, values := range rec.Header {
+		for _, v := range values {
+			w.Header().Add(k, v)
+		}
+	}
+	w.Header().Set(HeaderReplayed, "true")
+	w.WriteHeader(rec.StatusCode)
+	w.Write(rec.Body)
+}
+
+// recorder tees the response so it can be stored.
+type recorder struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rw *recorder) WriteHeader(code int) {
+	if !rw.wroteHeader {
+		rw.code, rw.wroteHeader = code, true
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recorder) Write(b []byte) (int, error) {
+	rw.wroteHeader = true
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher for the handlers streaming their response.
+func (rw *recorder) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		rw.wroteHeader = true
+		f.Flush()
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	errs := errors.FromError(err.Error())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(responses.ErrorRes{Error: responses.ErrorResItem{Code: code, ErrorCode: errors.Code(err), Message: errs[0].Message, Errors: errs}})
+}
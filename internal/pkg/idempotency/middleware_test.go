@@ -0,0 +1,48 @@
+package idempotency
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/clock"
+)
+
+func TestMiddlewareBodyLimit(t *testing.T) {
+	calls := 0
+	h := Middleware(NewMemoryStore(clock.System), time.Minute, 8, nil, log.NewNopLogger())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		ioutil.ReadAll(r.Body)
+	}))
+
+	cases := []struct {
+		name   string
+		body   string
+		length int64
+		code   int
+	}{
+		{"within", "12345678", 8, http.StatusOK},
+		{"declared over", "123456789", 9, http.StatusRequestEntityTooLarge},
+		{"undeclared over", "123456789", -1, http.StatusRequestEntityTooLarge},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.body))
+			r.ContentLength = tc.length
+			r.Header.Set(HeaderKey, tc.name)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tc.code {
+				t.Errorf("code = %d, want %d", w.Code, tc.code)
+			}
+		})
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times, want once", calls)
+	}
+}
@@ -0,0 +1,131 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// RedisPrefix prefixes the Redis keys of the records.
+const RedisPrefix = "idempotency:"
+
+type redisStore struct {
+	pool *redis.Pool
+}
+
+// NewRedisStore returns a Store shared by the instances, keeping the records
+// in the Redis server at addr, e.g. a Memorystore instance, claimed with SET
+// NX and expiring with the key.
+func NewRedisStore(addr string) Store {
+	return &redisStore{pool: &redis.Pool{
+		MaxIdle:     10,
+		IdleTimeout: 5 * time.Minute,
+		DialContext: func(ctx context.Context) (redis.Conn, error) {
+			return redis.DialContext(ctx, "tcp", addr)
+		},
+	}}
+}
+
+func (s *redisStore) Reserve(ctx context.Context, key string, fingerprint string, ttl time.Duration) (Record, bool, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return Record{}, false, err
+	}
+	defer conn.Close()
+
+	claim, err := json.Marshal(Record{Fingerprint: fingerprint})
+	if err != nil {
+		return Record{}, false, err
+	}
+	// the record may expire between SET and GET, claim it again then
+	for {
+		_, err := redis.String(redis.DoContext(conn, ctx, "SET", RedisPrefix+key, claim, "NX", "PX", ttl.Milliseconds()))
+		if err == nil {
+			return Record{}, true, nil
+		}
+		if err != redis.ErrNil {
+			return Record{}, false, err
+		}
+		b, err := redis.Bytes(redis.DoContext(conn, ctx, "GET", RedisPrefix+key))
+		if err == redis.ErrNil {
+			continue
+		}
+		if err != nil {
+			return Record{}, false, err
+		}
+		var rec Record
+		return rec, false, json.Unmarshal(b, &rec)
+	}
+}
+
+func (s *redisStore) Complete(ctx context.Context, key string, rec Record, ttl time.Duration) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = redis.DoContext(conn, ctx, "SET", RedisPrefix+key, b, "PX", ttl.Milliseconds())
+	return err
+}
+
+func (s *redisStore) Release(ctx context.Context, key string) error {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = redis.DoContext(conn, ctx, "DEL", RedisPrefix+key)
+	return err
+}
+
+func (s *redisStore) Claim(ctx context.Context, key string, fingerprint string, ttl time.Duration) (bool, error) {
+	claim, err := json.Marshal(Record{Fingerprint: fingerprint})
+	if err != nil {
+		return false, err
+	}
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	_, err = redis.String(redis.DoContext(conn, ctx, "SET", RedisPrefix+key, claim, "NX", "PX", ttl.Milliseconds()))
+	if err == redis.ErrNil {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Keys scans the keys under RedisPrefix, the expired ones being gone.
+func (s *redisStore) Keys(ctx context.Context) ([]string, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var keys []string
+	cursor := 0
+	for {
+		values, err := redis.Values(redis.DoContext(conn, ctx, "SCAN", cursor, "MATCH", RedisPrefix+"*", "COUNT", 1000))
+		if err != nil {
+			return nil, err
+		}
+		var batch []string
+		if _, err := redis.Scan(values, &cursor, &batch); err != nil {
+			return nil, err
+		}
+		for _, k := range batch {
+			keys = append(keys, Digest(strings.TrimPrefix(k, RedisPrefix)))
+		}
+		if cursor == 0 {
+			return keys, nil
+		}
+	}
+}
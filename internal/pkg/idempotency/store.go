@@ -0,0 +1,107 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/clock"
+)
+
+// Record is what is kept per idempotency key: the fingerprint of the first
+// request and, once it completed, its response.
+type Record struct {
+	Fingerprint string      `json:"fingerprint"`
+	Done        bool        `json:"done"`
+	StatusCode  int         `json:"status_code"`
+	Header      http.Header `json:"header"`
+	Body        []byte      `json:"body"`
+}
+
+// Store persists idempotency records. Deployments running more than one
+// instance need a shared implementation, the Datastore or the Redis one, so
+// retries that land on another instance are still deduplicated.
+type Store interface {
+	// Reserve atomically claims key for a request with fingerprint. If key
+	// is already claimed, the existing record is returned and reserved is false.
+	Reserve(ctx context.Context, key string, fingerprint string, ttl time.Duration) (existing Record, reserved bool, err error)
+
+	// Complete stores the response of the request holding key.
+	Complete(ctx context.Context, key string, rec Record, ttl time.Duration) error
+
+	// Release drops a claim whose request failed so it can be retried.
+	Release(ctx context.Context, key string) error
+}
+
+type entry struct {
+	rec       Record
+	expiresAt time.Time
+}
+
+type memoryStore struct {
+	clock   clock.Clock
+	mu      sync.Mutex
+	entries map[string]entry
+}
+
+// NewMemoryStore returns an instance-local Store, its records expiring as
+// time goes by on c.
+func NewMemoryStore(c clock.Clock) Store {
+	return &memoryStore{clock: c, entries: make(map[string]entry)}
+}
+
+func (ms *memoryStore) Reserve(_ context.Context, key string, fingerprint string, ttl time.Duration) (Record, bool, error) {
+	now := ms.clock.Now()
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if e, ok := ms.entries[key]; ok && now.Before(e.expiresAt) {
+		return e.rec, false, nil
+	}
+	for k, e := range ms.entries {
+		if now.After(e.expiresAt) {
+			delete(ms.entries, k)
+		}
+	}
+	ms.entries[key] = entry{rec: Record{Fingerprint: fingerprint}, expiresAt: now.Add(ttl)}
+	return Record{}, true, nil
+}
+
+func (ms *memoryStore) Complete(_ context.Context, key string, rec Record, ttl time.Duration) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.entries[key] = entry{rec: rec, expiresAt: ms.clock.Now().Add(ttl)}
+	return nil
+}
+
+func (ms *memoryStore) Release(_ context.Context, key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.entries, key)
+	return nil
+}
+
+func (ms *memoryStore) Claim(_ context.Context, key string, fingerprint string, ttl time.Duration) (bool, error) {
+	now := ms.clock.Now()
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if e, ok := ms.entries[key]; ok && now.Before(e.expiresAt) {
+		return false, nil
+	}
+	ms.entries[key] = entry{rec: Record{Fingerprint: fingerprint}, expiresAt: now.Add(ttl)}
+	return true, nil
+}
+
+func (ms *memoryStore) Keys(context.Context) ([]string, error) {
+	now := ms.clock.Now()
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	keys := make([]string, 0, len(ms.entries))
+	for k, e := range ms.entries {
+		if now.Before(e.expiresAt) {
+			keys = append(keys, Digest(k))
+		}
+	}
+	return keys, nil
+}
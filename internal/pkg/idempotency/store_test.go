@@ -0,0 +1,71 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/clock"
+)
+
+func TestMemoryStoreTTL(t *testing.T) {
+	ctx := context.Background()
+	c := clock.NewFrozen(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	s := NewMemoryStore(c)
+
+	if _, reserved, err := s.Reserve(ctx, "k", "f1", time.Minute); err != nil || !reserved {
+		t.Fatalf("first Reserve = %v, %v, want reserved", reserved, err)
+	}
+	c.Advance(59 * time.Second)
+	existing, reserved, err := s.Reserve(ctx, "k", "f2", time.Minute)
+	if err != nil || reserved {
+		t.Fatalf("Reserve before expiry = %v, %v, want not reserved", reserved, err)
+	}
+	if existing.Fingerprint != "f1" || existing.Done {
+		t.Errorf("existing = %+v, want the pending claim of f1", existing)
+	}
+
+	rec := Record{Fingerprint: "f1", Done: true, StatusCode: 200, Body: []byte("ok")}
+	if err := s.Complete(ctx, "k", rec, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	c.Advance(59 * time.Minute)
+	existing, reserved, _ = s.Reserve(ctx, "k", "f1", time.Minute)
+	if reserved || !existing.Done || string(existing.Body) != "ok" {
+		t.Errorf("Reserve before the completed record expired = %+v, %v, want the response", existing, reserved)
+	}
+	c.Advance(2 * time.Minute)
+	if _, reserved, _ = s.Reserve(ctx, "k", "f3", time.Minute); !reserved {
+		t.Error("Reserve after expiry wasn't reserved")
+	}
+}
+
+func TestMemoryStoreRelease(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(clock.NewFrozen(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	s.Reserve(ctx, "k", "f", time.Hour)
+	if err := s.Release(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if _, reserved, _ := s.Reserve(ctx, "k", "f", time.Hour); !reserved {
+		t.Error("Reserve after Release wasn't reserved")
+	}
+}
+
+func TestMemoryStoreSweepsExpired(t *testing.T) {
+	ctx := context.Background()
+	c := clock.NewFrozen(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	s := NewMemoryStore(c).(*memoryStore)
+
+	s.Reserve(ctx, "old", "f", time.Second)
+	c.Advance(2 * time.Second)
+	s.Reserve(ctx, "new", "f", time.Second)
+	if _, ok := s.entries["old"]; ok {
+		t.Error("the expired record wasn't swept")
+	}
+	keys, _ := s.Keys(ctx)
+	if len(keys) != 1 || keys[0] != Digest("new") {
+		t.Errorf("Keys() = %v, want the digest of new", keys)
+	}
+}
@@ -0,0 +1,70 @@
+// Package jsonnum converts JSON numbers decoded as json.Number to Go
+// integers exactly: integral values are accepted in any notation, such as
+// 1e3 or 3.0, while values that don't fit or have a fractional part are
+// rejected with a typed error instead of being rounded through float64.
+package jsonnum
+
+import (
+	"encoding/json"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// maxExponent bounds the exponents considered, so numbers such as 1e999999999
+// are rejected without being expanded.
+const maxExponent = 64
+
+var (
+	// ErrNotANumber indicates a value that isn't a JSON number.
+	ErrNotANumber = errors.Register(errors.KindInvalidArgument, errors.NewCoded("NUM-001", "not a number"))
+
+	// ErrOverflow indicates an integer out of the range of its type.
+	ErrOverflow = errors.Register(errors.KindInvalidArgument, errors.NewCoded("NUM-002", "integer overflow"))
+
+	// ErrPrecisionLoss indicates a number with a fractional part where an
+	// integer is expected.
+	ErrPrecisionLoss = errors.Register(errors.KindInvalidArgument, errors.NewCoded("NUM-003", "number would lose precision"))
+)
+
+// Int64 returns n as an int64, or ErrOverflow or ErrPrecisionLoss when it
+// can't be represented exactly.
+func Int64(n json.Number) (int64, error) {
+	s := string(n)
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err == nil {
+		return i, nil
+	}
+	if ne, ok := err.(*strconv.NumError); ok && ne.Err == strconv.ErrRange {
+		return 0, ErrOverflow
+	}
+
+	// not a plain integer: a decimal or exponent notation
+	if e := strings.IndexAny(s, "eE"); e >= 0 {
+		exp, err := strconv.Atoi(strings.TrimPrefix(s[e+1:], "+"))
+		if err != nil {
+			return 0, ErrNotANumber
+		}
+		if exp > maxExponent || exp < -maxExponent {
+			if mantissa, ok := new(big.Rat).SetString(s[:e]); ok && mantissa.Sign() != 0 {
+				if exp > 0 {
+					return 0, ErrOverflow
+				}
+				return 0, ErrPrecisionLoss
+			}
+			return 0, nil
+		}
+	}
+	r, ok := new(big.Rat).SetString(s)
+	switch {
+	case !ok:
+		return 0, ErrNotANumber
+	case !r.IsInt():
+		return 0, ErrPrecisionLoss
+	case !r.Num().IsInt64():
+		return 0, ErrOverflow
+	}
+	return r.Num().Int64(), nil
+}
@@ -0,0 +1,153 @@
+// Package killswitch disables routes at runtime from dynamic configuration,
+// so a misbehaving endpoint can be turned off without redeploying.
+package killswitch
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/responses"
+)
+
+// The modes of a disabled route.
+const (
+	// Disabled routes answer 503 Service Unavailable, they're expected back.
+	Disabled = "disabled"
+	// Removed routes answer 410 Gone.
+	Removed = "removed"
+)
+
+var (
+	// ErrRouteDisabled is reported for routes switched off temporarily.
+	ErrRouteDisabled = errors.NewCoded("ROUTE-001", "route temporarily disabled")
+
+	// ErrRouteRemoved is reported for routes switched off for good.
+	ErrRouteRemoved = errors.NewCoded("ROUTE-002", "route removed")
+)
+
+// Rule switches a route off. Route is "METHOD /path" or "/path" for all
+// methods; a path ending with "/*" matches the whole subtree.
+type Rule struct {
+	Route   string `json:"route"`
+	Mode    string `json:"mode"`
+	Message string `json:"message,omitempty"`
+}
+
+func (r Rule) match(req *http.Request) bool {
+	route := r.Route
+	if i := strings.IndexByte(route, ' '); i > 0 {
+		if !strings.EqualFold(route[:i], req.Method) {
+			return false
+		}
+		route = strings.TrimSpace(route[i+1:])
+	}
+	if strings.HasSuffix(route, "/*") {
+		return strings.HasPrefix(req.URL.Path, strings.TrimSuffix(route, "*"))
+	}
+	return req.URL.Path == route
+}
+
+// Switches holds the current rules, swapped atomically on every update so
+// requests never wait on a reload.
+type Switches struct {
+	rules atomic.Value
+}
+
+// New returns Switches with no route disabled.
+func New() *Switches {
+	s := &Switches{}
+	s.rules.Store([]Rule{})
+	return s
+}
+
+// Update replaces the rules.
+func (s *Switches) Update(rules []Rule) {
+	s.rules.Store(rules)
+}
+
+// Snapshot returns the current rules, see snapshot.Cache.
+func (s *Switches) Snapshot() (json.RawMessage, error) {
+	return json.Marshal(s.rules.Load().([]Rule))
+}
+
+// Restore replaces the rules with a snapshot, see snapshot.Cache.
+func (s *Switches) Restore(raw json.RawMessage) error {
+	var rules []Rule
+	if err := json.Unmarshal(raw, &rules); err != nil {
+		return err
+	}
+	s.Update(rules)
+	return nil
+}
+
+// Match returns the rule disabling req, if any.
+func (s *Switches) Match(req *http.Request) (Rule, bool) {
+	for _, r := range s.rules.Load().([]Rule) {
+		if r.match(req) {
+			return r, true
+		}
+	}
+	return Rule{}, false
+}
+
+// Middleware returns an http middleware answering requests to disabled
+// routes with a structured error instead of passing them to next.
+func (s *Switches) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule, ok := s.Match(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		code, err := http.StatusServiceUnavailable, ErrRouteDisabled
+		if rule.Mode == Removed {
+			code, err = http.StatusGone, ErrRouteRemoved
+		}
+		msg := err.Error()
+		if rule.Message != "" {
+			msg = rule.Message
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(responses.ErrorRes{Error: responses.ErrorResItem{
+			Code:      code,
+			ErrorCode: err.Code(),
+			Message:   msg,
+			Errors:    []errors.Errors{{Code: err.Code(), Message: err.Error(), Reason: rule.Mode, Location: rule.Route, LocationType: "route"}},
+		}})
+	})
+}
+
+// Watch reloads the rules from src every interval until ctx is done. A
+// failing reload keeps the previous rules.
+func (s *Switches) Watch(ctx context.Context, src Source, interval time.Duration, logger log.Logger) {
+	reload := func() {
+		rules, err := src.Load(ctx)
+		if err != nil {
+			level.Warn(logger).Log("killswitch", "reload", "err", err)
+			return
+		}
+		s.Update(rules)
+	}
+
+	reload()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			reload()
+		}
+	}
+}
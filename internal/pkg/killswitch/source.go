@@ -0,0 +1,83 @@
+package killswitch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// ErrInvalidRule indicates a rule without a route or with an unknown mode.
+var ErrInvalidRule = errors.New("invalid kill switch rule")
+
+// Source loads the rules, e.g. from a file or an object in Cloud Storage.
+// The document is a JSON object {"rules": [...]}.
+type Source interface {
+	Load(ctx context.Context) ([]Rule, error)
+}
+
+type fileSource struct {
+	path string
+}
+
+// NewFileSource returns a Source reading the rules from path.
+func NewFileSource(path string) Source {
+	return fileSource{path: path}
+}
+
+func (s fileSource) Load(context.Context) ([]Rule, error) {
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	return parse(b)
+}
+
+type urlSource struct {
+	url    string
+	client *http.Client
+}
+
+// NewURLSource returns a Source fetching the rules from url with client.
+func NewURLSource(url string, client *http.Client) Source {
+	return urlSource{url: url, client: client}
+}
+
+func (s urlSource) Load(ctx context.Context) ([]Rule, error) {
+	req, err := http.NewRequest(http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", s.url, resp.Status)
+	}
+	return parse(b)
+}
+
+func parse(b []byte) ([]Rule, error) {
+	var doc struct {
+		Rules []Rule `json:"rules"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	for _, r := range doc.Rules {
+		if strings.TrimSpace(r.Route) == "" || (r.Mode != Disabled && r.Mode != Removed) {
+			return nil, errors.Wrap(ErrInvalidRule, errors.New(r.Route))
+		}
+	}
+	return doc.Rules, nil
+}
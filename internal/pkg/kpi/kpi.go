@@ -0,0 +1,253 @@
+// Package kpi lets service code emit named business metrics, such as the
+// sums computed, next to the RED metrics of the endpoints. The metrics are
+// registered with the default Prometheus registry when first asked for,
+// and their labels kept tidy: names are sanitized, undeclared labels
+// dropped, missing ones left empty, and values trimmed, with the values
+// past a cardinality cap folded into "other", so a careless label can't
+// blow up the time series count.
+package kpi
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/go-kit/kit/metrics"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Subsystem is the Prometheus subsystem of the business metrics.
+	Subsystem = "kpi"
+
+	// MaxValues caps the distinct values of every label of a metric.
+	MaxValues = 100
+
+	// Other replaces the label values past MaxValues.
+	Other = "other"
+
+	// maxValueLen bounds the length of the label values, in bytes.
+	maxValueLen = 64
+)
+
+// Registry creates the business metrics of a service. It's safe for
+// concurrent use, and asking twice for a metric returns the same one.
+type Registry struct {
+	namespace string
+
+	mu      sync.Mutex
+	metrics map[string]registered
+}
+
+// New returns a Registry of metrics named namespace_kpi_<name>.
+func New(namespace string) *Registry {
+	return &Registry{namespace: Name(namespace), metrics: map[string]registered{}}
+}
+
+// Counter returns the counter name, with the _total suffix, of the labels
+// given.
+func (r *Registry) Counter(name, help string, labels ...string) metrics.Counter {
+	name = Name(name)
+	if !strings.HasSuffix(name, "_total") {
+		name += "_total"
+	}
+	return r.get("counter", name, labels, func(names []string) interface{} {
+		return &counter{set: newLabelSet(names), next: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: r.namespace,
+			Subsystem: Subsystem,
+			Name:      name,
+			Help:      help,
+		}, names)}
+	}).(*counter)
+}
+
+// Gauge returns the gauge name of the labels given.
+func (r *Registry) Gauge(name, help string, labels ...string) metrics.Gauge {
+	name = Name(name)
+	return r.get("gauge", name, labels, func(names []string) interface{} {
+		return &gauge{set: newLabelSet(names), next: kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: r.namespace,
+			Subsystem: Subsystem,
+			Name:      name,
+			Help:      help,
+		}, names)}
+	}).(*gauge)
+}
+
+// Histogram returns the histogram name of the labels given, observing in
+// buckets, the Prometheus default ones when nil.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) metrics.Histogram {
+	name = Name(name)
+	if buckets == nil {
+		buckets = stdprometheus.DefBuckets
+	}
+	return r.get("histogram", name, labels, func(names []string) interface{} {
+		return &histogram{set: newLabelSet(names), next: kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: r.namespace,
+			Subsystem: Subsystem,
+			Name:      name,
+			Help:      help,
+			Buckets:   buckets,
+		}, names)}
+	}).(*histogram)
+}
+
+// get returns the metric name, made by create the first time. Asking for a
+// metric of another kind or labels than the first time is a programming
+// error, which panics.
+func (r *Registry) get(kind, name string, labels []string, create func(names []string) interface{}) interface{} {
+	names := make([]string, len(labels))
+	for i, l := range labels {
+		names[i] = Name(l)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reg, ok := r.metrics[name]
+	if !ok {
+		reg = registered{kind: kind, metric: create(names)}
+		r.metrics[name] = reg
+	}
+	want, got := fmt.Sprintf("%s%v", kind, names), fmt.Sprintf("%s%v", reg.kind, reg.metric.(labeled).labels())
+	if got != want {
+		panic(fmt.Sprintf("kpi: metric %s is a %s, not a %s", name, got, want))
+	}
+	return reg.metric
+}
+
+type registered struct {
+	kind   string
+	metric interface{}
+}
+
+// Name returns s as a valid Prometheus metric or label name: lower case,
+// every other character than letters, digits and underscores replaced with
+// an underscore, not starting with a digit.
+func Name(s string) string {
+	b := []byte(strings.ToLower(s))
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_') {
+			b[i] = '_'
+		}
+	}
+	if len(b) > 0 && b[0] >= '0' && b[0] <= '9' {
+		b = append([]byte{'_'}, b...)
+	}
+	return string(b)
+}
+
+// labeled is implemented by the metrics, returning their label names.
+type labeled interface {
+	labels() []string
+}
+
+// labelSet tidies the label values of a metric.
+type labelSet struct {
+	names []string
+
+	mu   sync.Mutex
+	seen map[string]map[string]bool
+}
+
+func newLabelSet(names []string) *labelSet {
+	s := &labelSet{names: names, seen: map[string]map[string]bool{}}
+	for _, n := range names {
+		s.seen[n] = map[string]bool{}
+	}
+	return s
+}
+
+// clean returns the label values of lvs for every declared label, in the
+// declaration order.
+func (s *labelSet) clean(lvs []string) []string {
+	values := map[string]string{}
+	for i := 0; i+1 < len(lvs); i += 2 {
+		values[Name(lvs[i])] = lvs[i+1]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]string, 0, 2*len(s.names))
+	for _, n := range s.names {
+		v := value(values[n])
+		if seen := s.seen[n]; !seen[v] {
+			if len(seen) >= MaxValues {
+				v = Other
+			} else {
+				seen[v] = true
+			}
+		}
+		out = append(out, n, v)
+	}
+	return out
+}
+
+// value returns v valid UTF-8 of at most maxValueLen bytes.
+func value(v string) string {
+	if !utf8.ValidString(v) {
+		// Map replaces the invalid bytes with utf8.RuneError
+		v = strings.Map(func(r rune) rune { return r }, v)
+	}
+	if len(v) <= maxValueLen {
+		return v
+	}
+	v = v[:maxValueLen]
+	for !utf8.ValidString(v) {
+		v = v[:len(v)-1]
+	}
+	return v
+}
+
+type counter struct {
+	set  *labelSet
+	lvs  []string
+	next metrics.Counter
+}
+
+func (c *counter) labels() []string { return c.set.names }
+
+func (c *counter) With(labelValues ...string) metrics.Counter {
+	return &counter{set: c.set, lvs: append(append([]string(nil), c.lvs...), labelValues...), next: c.next}
+}
+
+func (c *counter) Add(delta float64) {
+	c.next.With(c.set.clean(c.lvs)...).Add(delta)
+}
+
+type gauge struct {
+	set  *labelSet
+	lvs  []string
+	next metrics.Gauge
+}
+
+func (g *gauge) labels() []string { return g.set.names }
+
+func (g *gauge) With(labelValues ...string) metrics.Gauge {
+	return &gauge{set: g.set, lvs: append(append([]string(nil), g.lvs...), labelValues...), next: g.next}
+}
+
+func (g *gauge) Set(value float64) {
+	g.next.With(g.set.clean(g.lvs)...).Set(value)
+}
+
+func (g *gauge) Add(delta float64) {
+	g.next.With(g.set.clean(g.lvs)...).Add(delta)
+}
+
+type histogram struct {
+	set  *labelSet
+	lvs  []string
+	next metrics.Histogram
+}
+
+func (h *histogram) labels() []string { return h.set.names }
+
+func (h *histogram) With(labelValues ...string) metrics.Histogram {
+	return &histogram{set: h.set, lvs: append(append([]string(nil), h.lvs...), labelValues...), next: h.next}
+}
+
+func (h *histogram) Observe(value float64) {
+	h.next.With(h.set.clean(h.lvs)...).Observe(value)
+}
@@ -0,0 +1,100 @@
+// Package lifecycle emits machine-readable events as the process moves through
+// its startup and shutdown phases, so deploy automation can gate traffic on
+// them instead of guessing.
+package lifecycle
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// Phase is a step of the process lifecycle.
+type Phase string
+
+// The phases, in the order they're entered.
+const (
+	Starting Phase = "starting"
+	Warmed   Phase = "warmed"
+	Serving  Phase = "serving"
+	Draining Phase = "draining"
+	Stopped  Phase = "stopped"
+)
+
+// Event is emitted when a phase is entered. Elapsed is the time spent in the
+// previous phase, Uptime the time since Starting.
+type Event struct {
+	Phase    Phase     `json:"phase"`
+	Instance string    `json:"instance"`
+	Version  string    `json:"version"`
+	At       time.Time `json:"at"`
+	Elapsed  float64   `json:"elapsedSeconds"`
+	Uptime   float64   `json:"uptimeSeconds"`
+}
+
+// Publisher sends events to an external sink such as Pub/Sub.
+type Publisher interface {
+	Publish(ctx context.Context, data []byte, attrs map[string]string) (string, error)
+}
+
+// Recorder emits the lifecycle events of a process.
+type Recorder struct {
+	instance  string
+	version   string
+	publisher Publisher
+	logger    log.Logger
+
+	mu      sync.Mutex
+	started time.Time
+	last    time.Time
+}
+
+// NewRecorder returns a Recorder logging events, and publishing them too when
+// publisher isn't nil. Starting is emitted right away.
+func NewRecorder(ctx context.Context, instance, version string, publisher Publisher, logger log.Logger) *Recorder {
+	now := time.Now()
+	r := &Recorder{
+		instance:  instance,
+		version:   version,
+		publisher: publisher,
+		logger:    logger,
+		started:   now,
+		last:      now,
+	}
+	r.Emit(ctx, Starting)
+	return r
+}
+
+// Emit records that phase was entered. Publishing failures are logged, they
+// never hold back the lifecycle itself.
+func (r *Recorder) Emit(ctx context.Context, phase Phase) {
+	r.mu.Lock()
+	now := time.Now()
+	ev := Event{
+		Phase:    phase,
+		Instance: r.instance,
+		Version:  r.version,
+		At:       now.UTC(),
+		Elapsed:  now.Sub(r.last).Seconds(),
+		Uptime:   now.Sub(r.started).Seconds(),
+	}
+	r.last = now
+	r.mu.Unlock()
+
+	level.Info(r.logger).Log("event", "lifecycle", "phase", ev.Phase, "elapsed", ev.Elapsed, "uptime", ev.Uptime)
+	if r.publisher == nil {
+		return
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		level.Error(r.logger).Log("event", "lifecycle", "phase", phase, "err", err)
+		return
+	}
+	if _, err := r.publisher.Publish(ctx, b, map[string]string{"phase": string(phase), "instance": r.instance}); err != nil {
+		level.Warn(r.logger).Log("event", "lifecycle", "phase", phase, "err", err)
+	}
+}
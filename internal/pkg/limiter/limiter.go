@@ -0,0 +1,74 @@
+// Package limiter rate limits by key, e.g. per principal or client address.
+package limiter
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/clock"
+)
+
+// Limiter decides whether the holder of key may proceed.
+type Limiter interface {
+	Allow(key string) bool
+}
+
+type entry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+type keyedLimiter struct {
+	limit rate.Limit
+	burst int
+	idle  time.Duration
+	clock clock.Clock
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	swept   time.Time
+}
+
+// NewKeyed returns a Limiter giving every key its own token bucket of burst
+// tokens refilled at limit per second, as time goes by on c. Buckets unused
+// for idle are dropped.
+func NewKeyed(c clock.Clock, limit rate.Limit, burst int, idle time.Duration) Limiter {
+	return &keyedLimiter{
+		limit:   limit,
+		burst:   burst,
+		idle:    idle,
+		clock:   c,
+		entries: map[string]*entry{},
+		swept:   c.Now(),
+	}
+}
+
+// Every converts an interval between events to a rate.Limit.
+func Every(interval time.Duration) rate.Limit {
+	return rate.Every(interval)
+}
+
+func (l *keyedLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	if now.Sub(l.swept) > l.idle {
+		for k, e := range l.entries {
+			if now.Sub(e.lastSeen) > l.idle {
+				delete(l.entries, k)
+			}
+		}
+		l.swept = now
+	}
+
+	e, ok := l.entries[key]
+	if !ok {
+		e = &entry{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.entries[key] = e
+	}
+	e.lastSeen = now
+	return e.limiter.AllowN(now, 1)
+}
@@ -0,0 +1,56 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/clock"
+)
+
+func TestKeyed(t *testing.T) {
+	c := clock.NewFrozen(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	l := NewKeyed(c, Every(time.Second), 2, time.Hour)
+
+	steps := []struct {
+		advance time.Duration
+		key     string
+		want    bool
+	}{
+		{0, "alice", true},
+		{0, "alice", true},
+		{0, "alice", false},
+		{0, "bob", true},
+		{500 * time.Millisecond, "alice", false},
+		{500 * time.Millisecond, "alice", true},
+		{0, "alice", false},
+		{10 * time.Second, "alice", true},
+		{0, "alice", true},
+		{0, "alice", false},
+	}
+	for i, s := range steps {
+		c.Advance(s.advance)
+		if got := l.Allow(s.key); got != s.want {
+			t.Errorf("step %d: Allow(%q) after %v = %v, want %v", i, s.key, s.advance, got, s.want)
+		}
+	}
+}
+
+func TestKeyedDropsIdle(t *testing.T) {
+	c := clock.NewFrozen(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	l := NewKeyed(c, Every(time.Hour), 1, time.Minute).(*keyedLimiter)
+
+	l.Allow("alice")
+	c.Advance(30 * time.Second)
+	l.Allow("bob")
+	c.Advance(45 * time.Second)
+	l.Allow("carol")
+	if _, ok := l.entries["alice"]; ok {
+		t.Error("the bucket of alice, idle for 75s, wasn't dropped")
+	}
+	if _, ok := l.entries["bob"]; !ok {
+		t.Error("the bucket of bob, idle for 45s, was dropped")
+	}
+	if !l.Allow("alice") {
+		t.Error("alice, whose bucket was dropped, was limited")
+	}
+}
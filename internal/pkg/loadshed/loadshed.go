@@ -0,0 +1,133 @@
+// Package loadshed admits requests up to a concurrency limit adapted to
+// their latency, shedding the others before they queue up: the limit grows
+// while the latency holds, and shrinks as it rises above the one the
+// instance serves with under no load, the gradient of the two.
+package loadshed
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// ErrOverloaded indicates a request shed as the instance was at its limit.
+var ErrOverloaded = errors.Register(errors.KindUnavailable, errors.NewCoded("SHED-001", "server overloaded"))
+
+const (
+	// tolerance is the rise of the latency, over the one of no load,
+	// tolerated before the limit shrinks.
+	tolerance = 1.5
+	// smoothing is the weight of every new limit computed.
+	smoothing = 0.2
+	// longWindow is the number of samples the latency of no load is
+	// averaged over.
+	longWindow = 600
+	// retryAfter is the delay the shed requests are told to retry after.
+	retryAfter = time.Second
+)
+
+// Limiter adapts the concurrency limit of a server to the latency of its
+// requests. It's safe for concurrent use.
+type Limiter struct {
+	mu       sync.Mutex
+	limit    float64
+	min, max float64
+	inFlight int
+	longRTT  float64
+	samples  int
+}
+
+// New returns a Limiter starting at initial requests in flight, never
+// adapting below min nor above max.
+func New(initial, min, max int) *Limiter {
+	return &Limiter{limit: float64(initial), min: float64(min), max: float64(max)}
+}
+
+// Limit returns the current concurrency limit.
+func (l *Limiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// InFlight returns the number of requests admitted and not done.
+func (l *Limiter) InFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight
+}
+
+// Acquire admits a request and returns the function to call once it's
+// done, with whether it succeeded: only the latency of successful requests
+// adapts the limit. It fails with ErrOverloaded, telling to retry later,
+// when the limit is reached.
+func (l *Limiter) Acquire() (done func(ok bool), err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if float64(l.inFlight) >= math.Floor(l.limit) {
+		return nil, errors.WithRetryAfter(ErrOverloaded, retryAfter)
+	}
+	l.inFlight++
+	start := time.Now()
+	return func(ok bool) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		inFlight := l.inFlight
+		l.inFlight--
+		if ok {
+			l.sample(time.Since(start), inFlight)
+		}
+	}, nil
+}
+
+// sample adapts the limit to the latency rtt of a request done with
+// inFlight requests in flight.
+func (l *Limiter) sample(rtt time.Duration, inFlight int) {
+	short := float64(rtt)
+	if short <= 0 {
+		return
+	}
+	if l.samples < longWindow {
+		l.samples++
+	}
+	// the average of the longest window, warming up on the first samples
+	l.longRTT += (short - l.longRTT) / float64(l.samples)
+	if l.longRTT/short > 2 {
+		// the load went away, let the latency of no load follow faster
+		l.longRTT *= 0.95
+	}
+
+	// the server isn't busy enough to tell whether it could take more
+	if float64(inFlight) < l.limit/2 {
+		return
+	}
+
+	gradient := math.Max(0.5, math.Min(1, tolerance*l.longRTT/short))
+	limit := l.limit*gradient + math.Sqrt(l.limit)
+	limit = l.limit*(1-smoothing) + limit*smoothing
+	l.limit = math.Max(l.min, math.Min(l.max, limit))
+}
+
+// Middleware returns an endpoint middleware admitting the requests through
+// l, counting the ones shed in shed and reporting the limit in limit.
+func (l *Limiter) Middleware(shed metrics.Counter, limit metrics.Gauge) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			done, err := l.Acquire()
+			if err != nil {
+				shed.Add(1)
+				return nil, err
+			}
+			response, err := next(ctx, request)
+			done(err == nil)
+			limit.Set(float64(l.Limit()))
+			return response, err
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Package locale formats the human-facing values of the responses as the
+// caller asks for: numbers in the language negotiated through
+// Accept-Language, scaled to the units of the X-Units header. The values
+// themselves are answered as they are, the formatted ones alongside.
+package locale
+
+import (
+	"math"
+	"net/http"
+	"strings"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// The headers of the negotiation: the languages of the caller, the units it
+// wants, and the language of the response.
+const (
+	HeaderAcceptLanguage  = "Accept-Language"
+	HeaderUnits           = "X-Units"
+	HeaderContentLanguage = "Content-Language"
+)
+
+// The units numbers can be scaled to.
+const (
+	// UnitsNone leaves numbers whole.
+	UnitsNone = "none"
+	// UnitsSI scales numbers by powers of 1000: k, M, G, ...
+	UnitsSI = "si"
+	// UnitsIEC scales numbers by powers of 1024: Ki, Mi, Gi, ...
+	UnitsIEC = "iec"
+)
+
+// Supported are the languages numbers are formatted in, the first one being
+// the default.
+var Supported = []language.Tag{
+	language.English,
+	language.German,
+	language.French,
+	language.Spanish,
+	language.Japanese,
+	language.TraditionalChinese,
+	language.SimplifiedChinese,
+}
+
+var matcher = language.NewMatcher(Supported)
+
+var (
+	siPrefixes  = []string{"", "k", "M", "G", "T", "P", "E"}
+	iecPrefixes = []string{"", "Ki", "Mi", "Gi", "Ti", "Pi", "Ei"}
+)
+
+// Formatter formats numbers in a language, scaled to units.
+type Formatter struct {
+	tag     language.Tag
+	units   string
+	printer *message.Printer
+}
+
+// New returns the Formatter of tag and units, UnitsNone when not one of the
+// units above.
+func New(tag language.Tag, units string) Formatter {
+	switch units {
+	case UnitsSI, UnitsIEC:
+	default:
+		units = UnitsNone
+	}
+	return Formatter{tag: tag, units: units, printer: message.NewPrinter(tag)}
+}
+
+// Negotiate returns the Formatter of the best match of the languages of r
+// among Supported, the default one when none matches, and of the units of r.
+// It returns false when r sets neither header, the caller wanting no
+// formatted values.
+func Negotiate(r *http.Request) (Formatter, bool) {
+	accept, units := r.Header.Get(HeaderAcceptLanguage), strings.ToLower(strings.TrimSpace(r.Header.Get(HeaderUnits)))
+	if accept == "" && units == "" {
+		return Formatter{}, false
+	}
+	tag := Supported[0]
+	if tags, _, err := language.ParseAcceptLanguage(accept); err == nil && len(tags) > 0 {
+		tag, _, _ = matcher.Match(tags...)
+	}
+	return New(tag, units), true
+}
+
+// Language returns the language of f, for the Content-Language header.
+func (f Formatter) Language() string {
+	return f.tag.String()
+}
+
+// Units returns the units of f.
+func (f Formatter) Units() string {
+	return f.units
+}
+
+// FormatInt returns n in the language and the units of f, e.g. 1,234,567 in
+// English, 1.234.567 in German, or 1.23M in English SI units.
+func (f Formatter) FormatInt(n int64) string {
+	base, prefixes := 0.0, []string(nil)
+	switch f.units {
+	case UnitsSI:
+		base, prefixes = 1000, siPrefixes
+	case UnitsIEC:
+		base, prefixes = 1024, iecPrefixes
+	}
+	if base == 0 || n > -int64(base) && n < int64(base) {
+		return f.printer.Sprintf("%d", n)
+	}
+	v, i := float64(n), 0
+	for math.Abs(v) >= base && i < len(prefixes)-1 {
+		v /= base
+		i++
+	}
+	return f.printer.Sprintf("%.2f", v) + prefixes[i]
+}
@@ -0,0 +1,25 @@
+package logger
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The keys WithContext adds to a logger.
+const (
+	TraceKey   = "trace"
+	SpanKey    = "spanId"
+	SampledKey = "traceSampled"
+)
+
+// WithContext returns logger annotated with the trace and span of ctx, if
+// any, so its lines are shown along the trace in Cloud Trace.
+func WithContext(ctx context.Context, logger log.Logger) log.Logger {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return logger
+	}
+	return log.With(logger, TraceKey, sc.TraceID().String(), SpanKey, sc.SpanID().String(), SampledKey, sc.IsSampled())
+}
@@ -0,0 +1,138 @@
+// Package logger provides a go-kit log.Logger writing the structured JSON
+// understood by Cloud Logging, with log lines correlated to Cloud Trace.
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// The special fields of Cloud Logging structured logs.
+const (
+	fieldSeverity       = "severity"
+	fieldMessage        = "message"
+	fieldTime           = "time"
+	fieldTrace          = "logging.googleapis.com/trace"
+	fieldSpanID         = "logging.googleapis.com/spanId"
+	fieldTraceSampled   = "logging.googleapis.com/trace_sampled"
+	fieldLabels         = "logging.googleapis.com/labels"
+	fieldSourceLocation = "logging.googleapis.com/sourceLocation"
+)
+
+type stackdriverLogger struct {
+	w         io.Writer
+	projectID string
+	labels    map[string]string
+	mu        sync.Mutex
+}
+
+// NewStackdriverLogger returns a logger writing one Cloud Logging JSON entry
+// per line to w. The "level", "msg", "ts" and "caller" keys become the
+// severity, message, time and source location of the entry, the trace keys
+// added by WithContext link it to its trace in the Cloud Trace of projectID,
+// and labels are attached to every entry.
+func NewStackdriverLogger(w io.Writer, projectID string, labels map[string]string) log.Logger {
+	return &stackdriverLogger{w: w, projectID: projectID, labels: labels}
+}
+
+func (l *stackdriverLogger) Log(keyvals ...interface{}) error {
+	entry := make(map[string]interface{}, len(keyvals)/2+2)
+	entry[fieldSeverity] = "DEFAULT"
+	if len(l.labels) > 0 {
+		entry[fieldLabels] = l.labels
+	}
+
+	for i := 0; i < len(keyvals); i += 2 {
+		k := fmt.Sprint(keyvals[i])
+		var v interface{} = log.ErrMissingValue
+		if i+1 < len(keyvals) {
+			v = keyvals[i+1]
+		}
+
+		switch k {
+		case "level":
+			entry[fieldSeverity] = severity(fmt.Sprint(v))
+		case "msg", "message":
+			entry[fieldMessage] = fmt.Sprint(v)
+		case "ts":
+			if t, ok := v.(time.Time); ok {
+				entry[fieldTime] = t.UTC().Format(time.RFC3339Nano)
+			} else {
+				entry[fieldTime] = fmt.Sprint(v)
+			}
+		case "caller":
+			entry[fieldSourceLocation] = sourceLocation(fmt.Sprint(v))
+		case TraceKey:
+			entry[fieldTrace] = fmt.Sprintf("projects/%s/traces/%s", l.projectID, v)
+		case SpanKey:
+			entry[fieldSpanID] = fmt.Sprint(v)
+		case SampledKey:
+			entry[fieldTraceSampled] = v
+		default:
+			entry[k] = jsonValue(v)
+		}
+	}
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.w.Write(append(b, '\n'))
+	return err
+}
+
+// severity maps go-kit levels to Cloud Logging severities.
+func severity(level string) string {
+	switch level {
+	case "debug":
+		return "DEBUG"
+	case "info":
+		return "INFO"
+	case "warn":
+		return "WARNING"
+	case "error":
+		return "ERROR"
+	default:
+		return "DEFAULT"
+	}
+}
+
+// sourceLocation parses the "file:line" of log.DefaultCaller.
+func sourceLocation(caller string) map[string]string {
+	loc := map[string]string{"file": caller}
+	if i := strings.LastIndexByte(caller, ':'); i > 0 {
+		if _, err := strconv.Atoi(caller[i+1:]); err == nil {
+			loc["file"], loc["line"] = caller[:i], caller[i+1:]
+		}
+	}
+	return loc
+}
+
+// jsonValue makes v encodable the way logfmt would print it.
+func jsonValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case nil:
+		return nil
+	case error:
+		return x.Error()
+	case fmt.Stringer:
+		return x.String()
+	case time.Duration:
+		return x.String()
+	case string, bool, int, int32, int64, uint, uint32, uint64, float32, float64:
+		return x
+	}
+	if _, err := json.Marshal(v); err != nil {
+		return fmt.Sprint(v)
+	}
+	return v
+}
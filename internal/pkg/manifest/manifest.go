@@ -0,0 +1,89 @@
+// Package manifest describes the cloud resources a deployment expects to
+// exist, derived from its own configuration, so that provisioning, e.g.
+// with Terraform, can be generated from it or validated against it.
+package manifest
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// Resource is a named resource the deployment uses.
+type Resource struct {
+	Name string `json:"name"`
+	// Env is the configuration variable naming it.
+	Env string `json:"env"`
+	// Purpose tells what the resource is used for.
+	Purpose string `json:"purpose"`
+}
+
+// Property is a property of a composite index.
+type Property struct {
+	Name string `json:"name"`
+	// Direction is "asc" or "desc".
+	Direction string `json:"direction"`
+}
+
+// Index is a composite Datastore index the queries of the deployment need.
+type Index struct {
+	Kind       string     `json:"kind"`
+	Properties []Property `json:"properties"`
+}
+
+// Manifest lists the resources of a deployment by type. Empty lists are
+// kept, so that consumers can iterate over them unconditionally.
+type Manifest struct {
+	Service string     `json:"service"`
+	Version string     `json:"version"`
+	Topics  []Resource `json:"topics"`
+	Queues  []Resource `json:"queues"`
+	Secrets []Resource `json:"secrets"`
+	Buckets []Resource `json:"buckets"`
+	Indexes []Index    `json:"indexes"`
+}
+
+// New returns the empty Manifest of a service version.
+func New(service, version string) *Manifest {
+	return &Manifest{
+		Service: service,
+		Version: version,
+		Topics:  []Resource{},
+		Queues:  []Resource{},
+		Secrets: []Resource{},
+		Buckets: []Resource{},
+		Indexes: []Index{},
+	}
+}
+
+// Write writes m to w as indented JSON, the resources of every type sorted
+// by name, and merged when used for several purposes.
+func (m *Manifest) Write(w io.Writer) error {
+	out := *m
+	out.Topics, out.Queues = merge(m.Topics), merge(m.Queues)
+	out.Secrets, out.Buckets = merge(m.Secrets), merge(m.Buckets)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+func merge(rs []Resource) []Resource {
+	out := []Resource{}
+	index := map[string]int{}
+	for _, r := range rs {
+		i, ok := index[r.Name]
+		if !ok {
+			index[r.Name] = len(out)
+			out = append(out, r)
+			continue
+		}
+		if out[i].Env != r.Env {
+			out[i].Env += "," + r.Env
+		}
+		if out[i].Purpose != r.Purpose {
+			out[i].Purpose += "; " + r.Purpose
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
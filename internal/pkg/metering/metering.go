@@ -0,0 +1,237 @@
+// Package metering records the API usage of every authenticated caller, by
+// day and method, enforces their daily quota, and reports it back to them,
+// so they can answer their capacity questions themselves.
+//
+// The usage is counted in a Store. Shared by the instances, e.g. the one of
+// NewRedisStore, the quota applies to them all; kept in the memory of each,
+// it applies per instance: a caller balanced over n instances may make up
+// to n times its quota a day. The reports say which, see Quota.Scope.
+package metering
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/auth"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+const (
+	// dateLayout is the layout of the days of the usage, in UTC.
+	dateLayout = "2006-01-02"
+
+	// ScopeInstance is the scope of the quotas and the usage counted in the
+	// memory of the instance serving the report.
+	ScopeInstance = "instance"
+
+	// ScopeGlobal is the scope of the quotas and the usage counted in a
+	// store the instances share.
+	ScopeGlobal = "global"
+)
+
+var (
+	// ErrQuotaExceeded indicates a caller past its daily quota.
+	ErrQuotaExceeded = errors.Register(errors.KindResourceExhausted, errors.NewCoded("METER-001", "daily quota exceeded"))
+
+	// ErrMissingPrincipal indicates a usage request without authenticated
+	// principal.
+	ErrMissingPrincipal = errors.Register(errors.KindUnauthenticated, errors.NewCoded("METER-002", "missing principal"))
+
+	// ErrUsageUnavailable indicates the usage couldn't be read from the
+	// store.
+	ErrUsageUnavailable = errors.Register(errors.KindUnavailable, errors.NewCoded("METER-003", "usage unavailable"))
+)
+
+// RateLimit is a rate limit applying to the callers.
+type RateLimit struct {
+	Scope    string `json:"scope"`
+	Burst    int    `json:"burst"`
+	Interval string `json:"interval"`
+}
+
+// Day is the usage of a caller during a day.
+type Day struct {
+	Date string `json:"date"`
+	// Requests counts the requests, the ones throttled included.
+	Requests int64 `json:"requests"`
+	// Throttled counts the requests denied by a quota or a rate limit.
+	Throttled int64            `json:"throttled"`
+	Methods   map[string]int64 `json:"methods"`
+}
+
+// Quota is the daily quota of a caller.
+type Quota struct {
+	// Scope is what the quota applies to, ScopeInstance or ScopeGlobal.
+	Scope string `json:"scope"`
+	// Limit is the number of requests allowed a day, 0 when unlimited.
+	Limit     int64     `json:"limit"`
+	Used      int64     `json:"used"`
+	Remaining int64     `json:"remaining"`
+	ResetsAt  time.Time `json:"resetsAt"`
+}
+
+// RateLimits is the rate limit status of a caller.
+type RateLimits struct {
+	Limits []RateLimit `json:"limits"`
+	// Throttled reports whether the last request of the caller was.
+	Throttled     bool      `json:"throttled"`
+	LastThrottled time.Time `json:"lastThrottled,omitempty"`
+}
+
+// Usage is the usage report of a caller.
+type Usage struct {
+	Principal string `json:"principal"`
+	// Instance is the instance serving the report, whose usage only is
+	// reported when the quota's scope is ScopeInstance.
+	Instance   string     `json:"instance"`
+	Quota      Quota      `json:"quota"`
+	RateLimits RateLimits `json:"rateLimits"`
+	// Daily is the usage of the last days, oldest first.
+	Daily []Day `json:"daily"`
+}
+
+// Status is the rate limit status of a caller.
+type Status struct {
+	// Throttled reports whether the last request of the caller was.
+	Throttled     bool
+	LastThrottled time.Time
+}
+
+// Meter records the usage of the callers in a Store. It's safe for
+// concurrent use.
+type Meter struct {
+	instance string
+	store    Store
+	scope    string
+	quota    int64
+	keep     int
+	authn    endpoint.Middleware
+	logger   log.Logger
+	now      func() time.Time
+
+	mu     sync.Mutex
+	limits []RateLimit
+}
+
+// New returns the Meter of instance counting the usage in store, whose
+// quotas have scope, ScopeInstance or ScopeGlobal, and allowing quota
+// requests a day to every caller, no limit when 0, and keeping their usage
+// of the last days. The callers are identified by the claims of their JWT
+// verified by authn, e.g. a kitjwt.NewParser middleware, when the endpoints
+// metered don't verify it themselves. authn may be nil when they do. The
+// requests the store fails to count are let through, and logged.
+func New(instance string, store Store, scope string, quota int64, days int, authn endpoint.Middleware, logger log.Logger) *Meter {
+	if days < 1 {
+		days = 1
+	}
+	return &Meter{instance: instance, store: store, scope: scope, quota: quota, keep: days, authn: authn, logger: logger, now: time.Now}
+}
+
+// SetRateLimits sets the rate limits reported to the callers. They're
+// enforced elsewhere, e.g. by privileged.Middleware, and their denials
+// counted as throttled.
+func (m *Meter) SetRateLimits(limits ...RateLimit) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.limits = limits
+}
+
+// Middleware returns an endpoint middleware recording the requests of the
+// authenticated callers to method, and failing the ones past the daily
+// quota with ErrQuotaExceeded. Anonymous requests aren't metered.
+func (m *Meter) Middleware(method string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			principal := m.principal(ctx, request)
+			if principal == "" {
+				return next(ctx, request)
+			}
+			if !m.admit(ctx, principal, method) {
+				return nil, ErrQuotaExceeded
+			}
+			response, err := next(ctx, request)
+			if errors.KindOf(err) == errors.KindResourceExhausted {
+				m.throttle(ctx, principal)
+			}
+			return response, err
+		}
+	}
+}
+
+// principal returns the authenticated caller of the request, empty for
+// anonymous ones and the ones whose JWT doesn't verify, left to fail where
+// authentication is required.
+func (m *Meter) principal(ctx context.Context, request interface{}) string {
+	return auth.Authenticate(ctx, request, m.authn)
+}
+
+// admit counts a request of principal to method, reporting whether it's
+// within the quota.
+func (m *Meter) admit(ctx context.Context, principal, method string) bool {
+	now := m.now().UTC()
+	d, err := m.store.Count(ctx, principal, now.Format(dateLayout), method, m.ttl(now))
+	if err != nil {
+		level.Warn(m.logger).Log("metering", "count", "principal", principal, "err", err)
+		return true
+	}
+	if m.quota > 0 && d.Requests-d.Throttled > m.quota {
+		m.throttle(ctx, principal)
+		return false
+	}
+	return true
+}
+
+func (m *Meter) throttle(ctx context.Context, principal string) {
+	now := m.now().UTC()
+	if err := m.store.Throttle(ctx, principal, now.Format(dateLayout), now, m.ttl(now)); err != nil {
+		level.Warn(m.logger).Log("metering", "throttle", "principal", principal, "err", err)
+	}
+}
+
+// ttl returns how long the counters of the day of now are kept: until the
+// end of the last day they're reported.
+func (m *Meter) ttl(now time.Time) time.Duration {
+	return time.Date(now.Year(), now.Month(), now.Day()+m.keep, 0, 0, 0, 0, time.UTC).Sub(now)
+}
+
+// Usage returns the usage report of principal.
+func (m *Meter) Usage(ctx context.Context, principal string) (Usage, error) {
+	now := m.now().UTC()
+	dates := make([]string, m.keep)
+	for i := range dates {
+		dates[i] = now.AddDate(0, 0, i+1-m.keep).Format(dateLayout)
+	}
+	days, status, err := m.store.Usage(ctx, principal, dates)
+	if err != nil {
+		return Usage{}, errors.Wrap(ErrUsageUnavailable, err)
+	}
+
+	m.mu.Lock()
+	limits := append([]RateLimit{}, m.limits...)
+	m.mu.Unlock()
+	u := Usage{
+		Principal:  principal,
+		Instance:   m.instance,
+		Quota:      Quota{Scope: m.scope, Limit: m.quota, ResetsAt: time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)},
+		RateLimits: RateLimits{Limits: limits, Throttled: status.Throttled, LastThrottled: status.LastThrottled},
+		Daily:      []Day{},
+	}
+	for _, d := range days {
+		if d.Requests == 0 && d.Throttled == 0 {
+			continue
+		}
+		if d.Date == dates[len(dates)-1] {
+			u.Quota.Used = d.Requests - d.Throttled
+		}
+		u.Daily = append(u.Daily, d)
+	}
+	if m.quota > 0 && u.Quota.Used < m.quota {
+		u.Quota.Remaining = m.quota - u.Quota.Used
+	}
+	return u, nil
+}
@@ -0,0 +1,45 @@
+package metering
+
+import (
+	"context"
+	"testing"
+
+	stdjwt "github.com/dgrijalva/jwt-go"
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/log"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// TestSharedQuota checks the quota applies to the meters sharing a store,
+// as the instances sharing the Redis store do.
+func TestSharedQuota(t *testing.T) {
+	store := NewMemoryStore()
+	instances := []*Meter{
+		New("a", store, ScopeGlobal, 3, 2, nil, log.NewNopLogger()),
+		New("b", store, ScopeGlobal, 3, 2, nil, log.NewNopLogger()),
+	}
+	ctx := context.WithValue(context.Background(), kitjwt.JWTClaimsContextKey, stdjwt.MapClaims{"sub": "alice"})
+	ok := func(context.Context, interface{}) (interface{}, error) { return nil, nil }
+
+	for i := 0; i < 4; i++ {
+		_, err := instances[i%2].Middleware("sum")(ok)(ctx, nil)
+		if i < 3 && err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		if i == 3 && (err == nil || !errors.Contains(errors.Cast(err), ErrQuotaExceeded)) {
+			t.Fatalf("request %d err = %v, want %v", i, err, ErrQuotaExceeded)
+		}
+	}
+
+	u, err := instances[0].Usage(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Quota.Scope != ScopeGlobal || u.Quota.Used != 3 || u.Quota.Remaining != 0 || !u.RateLimits.Throttled {
+		t.Errorf("quota = %+v, throttled %v", u.Quota, u.RateLimits.Throttled)
+	}
+	if len(u.Daily) != 1 || u.Daily[0].Requests != 4 || u.Daily[0].Throttled != 1 || u.Daily[0].Methods["sum"] != 4 {
+		t.Errorf("daily = %+v", u.Daily)
+	}
+}
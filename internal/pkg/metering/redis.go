@@ -0,0 +1,156 @@
+package metering
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// RedisPrefix prefixes the Redis keys of the usage.
+const RedisPrefix = "metering:"
+
+// The fields of the hash of a day, the methods being counted in
+// methodField followed by their name.
+const (
+	requestsField  = "requests"
+	throttledField = "throttled"
+	methodField    = "method:"
+)
+
+// The fields of the hash of the status of a caller.
+const (
+	statusThrottled     = "throttled"
+	statusLastThrottled = "lastThrottled"
+)
+
+type redisStore struct {
+	pool *redis.Pool
+}
+
+// NewRedisStore returns a Store shared by the instances, see ScopeGlobal,
+// counting the usage in the Redis server at addr, e.g. a Memorystore
+// instance: every caller has a hash a day, incremented with HINCRBY and
+// expiring with its ttl. Datastore can't take the place of Redis: a busy
+// caller would exceed the write rate of the entity of its day.
+func NewRedisStore(addr string) Store {
+	return &redisStore{pool: &redis.Pool{
+		MaxIdle:     10,
+		IdleTimeout: 5 * time.Minute,
+		DialContext: func(ctx context.Context) (redis.Conn, error) {
+			return redis.DialContext(ctx, "tcp", addr)
+		},
+	}}
+}
+
+// dayKey returns the key of the hash of principal on date. The date, of
+// fixed length, comes first for no principal to name the key of another.
+func dayKey(principal, date string) string {
+	return RedisPrefix + "day:" + date + ":" + principal
+}
+
+func statusKey(principal string) string {
+	return RedisPrefix + "status:" + principal
+}
+
+func (s *redisStore) Count(ctx context.Context, principal, date, method string, ttl time.Duration) (Day, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return Day{}, err
+	}
+	defer conn.Close()
+
+	key := dayKey(principal, date)
+	conn.Send("MULTI")
+	conn.Send("HINCRBY", key, requestsField, 1)
+	conn.Send("HINCRBY", key, methodField+method, 1)
+	conn.Send("PEXPIRE", key, ttl.Milliseconds())
+	conn.Send("HSET", statusKey(principal), statusThrottled, 0)
+	conn.Send("PEXPIRE", statusKey(principal), ttl.Milliseconds())
+	conn.Send("HGETALL", key)
+	values, err := redis.Values(redis.DoContext(conn, ctx, "EXEC"))
+	if err != nil {
+		return Day{}, err
+	}
+	return parseDay(date, values[len(values)-1])
+}
+
+func (s *redisStore) Throttle(ctx context.Context, principal, date string, at time.Time, ttl time.Duration) error {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	key := dayKey(principal, date)
+	conn.Send("MULTI")
+	conn.Send("HINCRBY", key, throttledField, 1)
+	conn.Send("PEXPIRE", key, ttl.Milliseconds())
+	conn.Send("HSET", statusKey(principal), statusThrottled, 1, statusLastThrottled, at.UTC().Format(time.RFC3339Nano))
+	conn.Send("PEXPIRE", statusKey(principal), ttl.Milliseconds())
+	_, err = redis.DoContext(conn, ctx, "EXEC")
+	return err
+}
+
+func (s *redisStore) Usage(ctx context.Context, principal string, dates []string) ([]Day, Status, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, Status{}, err
+	}
+	defer conn.Close()
+
+	for _, date := range dates {
+		conn.Send("HGETALL", dayKey(principal, date))
+	}
+	conn.Send("HGETALL", statusKey(principal))
+	if err := conn.Flush(); err != nil {
+		return nil, Status{}, err
+	}
+	days := make([]Day, len(dates))
+	for i, date := range dates {
+		reply, err := conn.Receive()
+		if err != nil {
+			return nil, Status{}, err
+		}
+		if days[i], err = parseDay(date, reply); err != nil {
+			return nil, Status{}, err
+		}
+	}
+	fields, err := redis.StringMap(conn.Receive())
+	if err != nil {
+		return nil, Status{}, err
+	}
+	status := Status{Throttled: fields[statusThrottled] == "1"}
+	if v := fields[statusLastThrottled]; v != "" {
+		if status.LastThrottled, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			return nil, Status{}, err
+		}
+	}
+	return days, status, nil
+}
+
+// parseDay returns the Day of date of the HGETALL reply of its hash.
+func parseDay(date string, reply interface{}) (Day, error) {
+	fields, err := redis.StringMap(reply, nil)
+	if err != nil {
+		return Day{}, err
+	}
+	d := Day{Date: date, Methods: map[string]int64{}}
+	for field, v := range fields {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return Day{}, err
+		}
+		switch {
+		case field == requestsField:
+			d.Requests = n
+		case field == throttledField:
+			d.Throttled = n
+		case strings.HasPrefix(field, methodField):
+			d.Methods[strings.TrimPrefix(field, methodField)] = n
+		}
+	}
+	return d, nil
+}
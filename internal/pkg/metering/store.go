@@ -0,0 +1,108 @@
+package metering
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store counts the requests of the callers by day. The counts of a day are
+// kept for the ttl given when they're counted.
+type Store interface {
+	// Count counts a request of principal to method on date, and returns
+	// the usage of principal on date, the request included.
+	Count(ctx context.Context, principal, date, method string, ttl time.Duration) (Day, error)
+	// Throttle counts a request of principal on date as throttled, at.
+	Throttle(ctx context.Context, principal, date string, at time.Time, ttl time.Duration) error
+	// Usage returns the usage of principal on each of dates, and its rate
+	// limit status.
+	Usage(ctx context.Context, principal string, dates []string) ([]Day, Status, error)
+}
+
+type memoryCaller struct {
+	days    map[string]*Day
+	expires map[string]time.Time
+	status  Status
+}
+
+type memoryStore struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	callers map[string]*memoryCaller
+}
+
+// NewMemoryStore returns a Store keeping the usage in memory, for this
+// instance only, see ScopeInstance.
+func NewMemoryStore() Store {
+	return &memoryStore{now: time.Now, callers: map[string]*memoryCaller{}}
+}
+
+func (s *memoryStore) Count(_ context.Context, principal, date, method string, ttl time.Duration) (Day, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.day(principal, date, ttl)
+	d.Requests++
+	d.Methods[method]++
+	s.callers[principal].status.Throttled = false
+	return copyDay(d), nil
+}
+
+func (s *memoryStore) Throttle(_ context.Context, principal, date string, at time.Time, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.day(principal, date, ttl).Throttled++
+	s.callers[principal].status = Status{Throttled: true, LastThrottled: at}
+	return nil
+}
+
+func (s *memoryStore) Usage(_ context.Context, principal string, dates []string) ([]Day, Status, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	days := make([]Day, len(dates))
+	c, ok := s.callers[principal]
+	for i, date := range dates {
+		days[i] = Day{Date: date, Methods: map[string]int64{}}
+		if ok {
+			if d, ok := c.days[date]; ok {
+				days[i] = copyDay(d)
+			}
+		}
+	}
+	if !ok {
+		return days, Status{}, nil
+	}
+	return days, c.status, nil
+}
+
+// day returns the usage of principal on date, dropping its expired days.
+func (s *memoryStore) day(principal, date string, ttl time.Duration) *Day {
+	c, ok := s.callers[principal]
+	if !ok {
+		c = &memoryCaller{days: map[string]*Day{}, expires: map[string]time.Time{}}
+		s.callers[principal] = c
+	}
+	now := s.now()
+	for k, expires := range c.expires {
+		if !now.Before(expires) {
+			delete(c.days, k)
+			delete(c.expires, k)
+		}
+	}
+	d, ok := c.days[date]
+	if !ok {
+		d = &Day{Date: date, Methods: map[string]int64{}}
+		c.days[date] = d
+	}
+	c.expires[date] = now.Add(ttl)
+	return d
+}
+
+func copyDay(d *Day) Day {
+	day := *d
+	day.Methods = make(map[string]int64, len(d.Methods))
+	for k, v := range d.Methods {
+		day.Methods[k] = v
+	}
+	return day
+}
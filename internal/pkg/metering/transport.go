@@ -0,0 +1,70 @@
+package metering
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/go-zoo/bone"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/auth"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/responses"
+)
+
+// Path is where the callers get their usage report.
+const Path = "/api/add/usage"
+
+// MakeHTTPHandler returns a handler serving the usage report of the caller
+// at Path. The caller is the "sub" claim of the JWT verified by authn, e.g.
+// a kitjwt.NewParser middleware.
+func MakeHTTPHandler(m *Meter, authn endpoint.Middleware, logger log.Logger) http.Handler {
+	options := []httptransport.ServerOption{
+		httptransport.ServerErrorEncoder(encodeError),
+		httptransport.ServerErrorLogger(logger),
+		httptransport.ServerBefore(kitjwt.HTTPToContext()),
+	}
+
+	mux := bone.New()
+	mux.Get(Path, httptransport.NewServer(
+		authn(makeUsageEndpoint(m)),
+		func(context.Context, *http.Request) (interface{}, error) { return nil, nil },
+		encodeResponse,
+		options...,
+	))
+	return mux
+}
+
+func makeUsageEndpoint(m *Meter) endpoint.Endpoint {
+	return func(ctx context.Context, _ interface{}) (interface{}, error) {
+		principal := auth.Principal(ctx)
+		if principal == "" {
+			return nil, ErrMissingPrincipal
+		}
+		return m.Usage(ctx, principal)
+	}
+}
+
+func encodeResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	return json.NewEncoder(w).Encode(responses.DataRes{Data: response})
+}
+
+func encodeError(_ context.Context, err error, w http.ResponseWriter) {
+	code := errors.HTTPStatus(errors.KindOf(err))
+	ce := errors.Cast(err)
+	switch {
+	case errors.Contains(ce, kitjwt.ErrTokenContextMissing), errors.Contains(ce, kitjwt.ErrTokenInvalid),
+		errors.Contains(ce, kitjwt.ErrTokenExpired), errors.Contains(ce, kitjwt.ErrTokenMalformed),
+		errors.Contains(ce, kitjwt.ErrTokenNotActive):
+		code = http.StatusUnauthorized
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(responses.ErrorRes{Error: responses.ErrorResItem{Code: code, ErrorCode: errors.Code(err), Message: ce.Msg(), Errors: ce.Errors()}})
+}
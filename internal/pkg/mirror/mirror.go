@@ -0,0 +1,248 @@
+// Package mirror copies a sampled share of the HTTP requests to a shadow
+// backend, such as a new deployment under load test. The copies are sent in
+// the background once the request is served and their responses are thrown
+// away, so the callers never wait on, nor see, the shadow. Copies beyond
+// the in-flight bound or with a body too large to buffer are dropped.
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+)
+
+// Header marks the copies, for the shadow to tell them from real traffic
+// and never to mirror them again.
+const Header = "X-Shadow-Request"
+
+// The results of the copies.
+const (
+	ResultSent    = "sent"
+	ResultDropped = "dropped"
+	ResultError   = "error"
+)
+
+// Defaults of a Mirror, see Option.
+const (
+	DefaultTimeout      = 10 * time.Second
+	DefaultMaxInFlight  = 100
+	DefaultMaxBodyBytes = 1 << 20
+)
+
+// Mirror sends copies of requests to a shadow backend.
+type Mirror struct {
+	target   *url.URL
+	percent  float64
+	client   *http.Client
+	timeout  time.Duration
+	maxBody  int64
+	prefixes []string
+	inFlight chan struct{}
+	requests metrics.Counter
+	logger   log.Logger
+}
+
+// Option configures a Mirror.
+type Option func(*Mirror)
+
+// Timeout bounds every copy, DefaultTimeout by default.
+func Timeout(d time.Duration) Option {
+	return func(m *Mirror) {
+		m.timeout = d
+	}
+}
+
+// MaxInFlight bounds the copies on their way, DefaultMaxInFlight by
+// default, the ones beyond being dropped so a slow shadow doesn't pile
+// goroutines up.
+func MaxInFlight(n int) Option {
+	return func(m *Mirror) {
+		m.inFlight = make(chan struct{}, n)
+	}
+}
+
+// MaxBodyBytes bounds the bodies buffered to be copied,
+// DefaultMaxBodyBytes by default, larger requests not being mirrored.
+func MaxBodyBytes(n int64) Option {
+	return func(m *Mirror) {
+		m.maxBody = n
+	}
+}
+
+// PathPrefixes restricts the copies to the requests whose path starts with
+// one of prefixes, e.g. the API rather than the metrics scrapes.
+func PathPrefixes(prefixes ...string) Option {
+	return func(m *Mirror) {
+		m.prefixes = prefixes
+	}
+}
+
+// Client sends the copies with c rather than http.DefaultClient.
+func Client(c *http.Client) Option {
+	return func(m *Mirror) {
+		m.client = c
+	}
+}
+
+// New returns a Mirror copying percent of the requests to the shadow at
+// target, its scheme and host replacing theirs. requests, labeled by
+// result, counts the copies.
+func New(target string, percent float64, requests metrics.Counter, logger log.Logger, options ...Option) (*Mirror, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	m := &Mirror{
+		target:   u,
+		percent:  percent,
+		client:   http.DefaultClient,
+		timeout:  DefaultTimeout,
+		maxBody:  DefaultMaxBodyBytes,
+		inFlight: make(chan struct{}, DefaultMaxInFlight),
+		requests: requests,
+		logger:   logger,
+	}
+	for _, o := range options {
+		o(m)
+	}
+	return m, nil
+}
+
+// sampled reports whether r is to be copied.
+func (m *Mirror) sampled(r *http.Request) bool {
+	if r.Header.Get(Header) != "" {
+		return false
+	}
+	if len(m.prefixes) > 0 && !hasPrefix(r.URL.Path, m.prefixes) {
+		return false
+	}
+	return rand.Float64()*100 < m.percent
+}
+
+func hasPrefix(path string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// buffer reads the body of r, putting it back for r to be sent, and returns
+// it, or false when it's too large to be copied.
+func (m *Mirror) buffer(r *http.Request) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+	if r.ContentLength > m.maxBody {
+		return nil, false
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, m.maxBody+1))
+	r.Body = readCloser{io.MultiReader(bytes.NewReader(b), r.Body), r.Body}
+	if err != nil || int64(len(b)) > m.maxBody {
+		return nil, false
+	}
+	return b, true
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// send copies r with body to the shadow in the background.
+func (m *Mirror) send(r *http.Request, body []byte) {
+	select {
+	case m.inFlight <- struct{}{}:
+	default:
+		m.requests.With("result", ResultDropped).Add(1)
+		return
+	}
+	u := *r.URL
+	u.Scheme, u.Host = m.target.Scheme, m.target.Host
+	header := r.Header.Clone()
+	header.Set(Header, "1")
+	method := r.Method
+	go func() {
+		defer func() { <-m.inFlight }()
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+		defer cancel()
+		req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+		if err != nil {
+			m.fail(method, u.Path, err)
+			return
+		}
+		req.Header = header
+		resp, err := m.client.Do(req.WithContext(ctx))
+		if err != nil {
+			m.fail(method, u.Path, err)
+			return
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		m.requests.With("result", ResultSent).Add(1)
+	}()
+}
+
+func (m *Mirror) fail(method, path string, err error) {
+	m.requests.With("result", ResultError).Add(1)
+	level.Debug(m.logger).Log("mirror", method+" "+path, "err", err)
+}
+
+// Middleware returns a handler serving the requests with next, and copying
+// its share of them to the shadow once served.
+func (m *Mirror) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.sampled(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, ok := m.buffer(r)
+		if !ok {
+			m.requests.With("result", ResultDropped).Add(1)
+			next.ServeHTTP(w, r)
+			return
+		}
+		// the copy is built before next, which may change r
+		copied := r.Clone(r.Context())
+		next.ServeHTTP(w, r)
+		m.send(copied, body)
+	})
+}
+
+// RoundTripper returns a RoundTripper sending the requests with next, and
+// copying its share of them to the shadow, for clients to mirror their
+// calls. next is http.DefaultTransport when nil.
+func (m *Mirror) RoundTripper(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return roundTripper(func(r *http.Request) (*http.Response, error) {
+		if !m.sampled(r) {
+			return next.RoundTrip(r)
+		}
+		// a RoundTripper mustn't change the request it's given
+		r = r.Clone(r.Context())
+		body, ok := m.buffer(r)
+		if !ok {
+			m.requests.With("result", ResultDropped).Add(1)
+			return next.RoundTrip(r)
+		}
+		m.send(r, body)
+		return next.RoundTrip(r)
+	})
+}
+
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
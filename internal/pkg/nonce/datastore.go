@@ -0,0 +1,59 @@
+package nonce
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/gcp"
+)
+
+// Kind is the Datastore kind of the nonces, keyed by their SHA-256.
+const Kind = "Nonce"
+
+// useAttempts is the number of times a nonce is tried, the transaction
+// being aborted by a concurrent use of the same nonce.
+const useAttempts = 3
+
+type datastoreStore struct {
+	ds *gcp.Datastore
+}
+
+// NewDatastoreStore returns a Store shared by the instances, recording the
+// nonces in Datastore with their expiry time. The expired ones are left to
+// a TTL policy on the expiresAt property of the kind.
+func NewDatastoreStore(ds *gcp.Datastore) Store {
+	return &datastoreStore{ds: ds}
+}
+
+func (s *datastoreStore) Use(ctx context.Context, nonce string, ttl time.Duration) (bool, error) {
+	sum := sha256.Sum256([]byte(nonce))
+	key := s.ds.Key(Kind, hex.EncodeToString(sum[:]))
+	fresh := false
+	err := s.ds.RunInTransaction(ctx, useAttempts, func(tx string) ([]interface{}, error) {
+		var props struct {
+			ExpiresAt struct {
+				TimestampValue time.Time `json:"timestampValue"`
+			} `json:"expiresAt"`
+		}
+		found, err := s.ds.Lookup(ctx, tx, key, &props)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		if fresh = !found || now.After(props.ExpiresAt.TimestampValue); !fresh {
+			return nil, nil
+		}
+		return []interface{}{map[string]interface{}{"upsert": map[string]interface{}{
+			"key": key,
+			"properties": map[string]interface{}{
+				"expiresAt": map[string]interface{}{"timestampValue": now.Add(ttl).UTC().Format(time.RFC3339Nano)},
+			},
+		}}}, nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return fresh, nil
+}
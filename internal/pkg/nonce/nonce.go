@@ -0,0 +1,49 @@
+package nonce
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store remembers nonces for a limited time. Deployments running more than
+// one instance must use a shared implementation, such as the Datastore one,
+// so a request replayed against another instance is still caught.
+type Store interface {
+	// Use records nonce for ttl. It returns false if nonce was already
+	// recorded and hasn't expired, i.e. the request is a replay.
+	Use(ctx context.Context, nonce string, ttl time.Duration) (bool, error)
+}
+
+type memoryStore struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+	sweep  time.Time
+}
+
+// NewMemoryStore returns an instance-local Store.
+func NewMemoryStore() Store {
+	return &memoryStore{nonces: make(map[string]time.Time)}
+}
+
+func (s *memoryStore) Use(_ context.Context, nonce string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.After(s.sweep) {
+		for n, exp := range s.nonces {
+			if now.After(exp) {
+				delete(s.nonces, n)
+			}
+		}
+		s.sweep = now.Add(ttl)
+	}
+
+	if exp, ok := s.nonces[nonce]; ok && now.Before(exp) {
+		return false, nil
+	}
+	s.nonces[nonce] = now.Add(ttl)
+	return true, nil
+}
@@ -0,0 +1,60 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/gcp"
+)
+
+// Kind is the Datastore kind of the preferences, keyed by principal.
+const Kind = "NotificationPreferences"
+
+type datastoreRepository struct {
+	ds *gcp.Datastore
+}
+
+// NewDatastoreRepository returns a Repository shared by the instances,
+// keeping the preferences in Datastore, their channels as JSON.
+func NewDatastoreRepository(ds *gcp.Datastore) Repository {
+	return &datastoreRepository{ds: ds}
+}
+
+// preferencesProperties are the properties of a preferences entity.
+type preferencesProperties struct {
+	Channels struct {
+		StringValue string `json:"stringValue"`
+	} `json:"channels"`
+	UpdatedAt struct {
+		TimestampValue time.Time `json:"timestampValue"`
+	} `json:"updatedAt"`
+}
+
+func (r *datastoreRepository) Get(ctx context.Context, principal string) (Preferences, error) {
+	var p preferencesProperties
+	found, err := r.ds.Lookup(ctx, "", r.ds.Key(Kind, principal), &p)
+	if err != nil {
+		return Preferences{}, err
+	}
+	prefs := Preferences{Principal: principal, Channels: []Channel{}}
+	if !found {
+		return prefs, nil
+	}
+	prefs.UpdatedAt = p.UpdatedAt.TimestampValue
+	return prefs, json.Unmarshal([]byte(p.Channels.StringValue), &prefs.Channels)
+}
+
+func (r *datastoreRepository) Save(ctx context.Context, prefs Preferences) error {
+	channels, err := json.Marshal(prefs.Channels)
+	if err != nil {
+		return err
+	}
+	return r.ds.Commit(ctx, map[string]interface{}{"upsert": map[string]interface{}{
+		"key": r.ds.Key(Kind, prefs.Principal),
+		"properties": map[string]interface{}{
+			"channels":  map[string]interface{}{"stringValue": string(channels), "excludeFromIndexes": true},
+			"updatedAt": map[string]interface{}{"timestampValue": prefs.UpdatedAt.UTC().Format(time.RFC3339Nano), "excludeFromIndexes": true},
+		},
+	}})
+}
@@ -0,0 +1,81 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// ErrDeliveryFailed indicates an event a channel didn't accept.
+var ErrDeliveryFailed = errors.NewCoded("NOTIFY-004", "notification delivery failed")
+
+// Event is a notification delivered to the channels subscribed to its type.
+type Event struct {
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// Sender delivers events to the channels of a type.
+type Sender interface {
+	Send(ctx context.Context, c Channel, e Event) error
+}
+
+type webhookSender struct {
+	client *http.Client
+}
+
+// NewWebhookSender returns a Sender POSTing the events as JSON to the https
+// URL of webhook channels, on public addresses only, expecting a 2xx
+// response.
+func NewWebhookSender(timeout time.Duration) Sender {
+	return &webhookSender{client: newWebhookClient(timeout)}
+}
+
+func (s *webhookSender) Send(ctx context.Context, c Channel, e Event) error {
+	if c.Type != Webhook {
+		return ErrInvalidChannel
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.Target, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(ErrDeliveryFailed, err)
+	}
+	if err := checkScheme(req.URL); err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errors.Wrap(ErrDeliveryFailed, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.Wrap(ErrDeliveryFailed, errors.New(resp.Status))
+	}
+	return nil
+}
+
+// Deliver sends e with sender to the channels subscribed to its type,
+// returning the first error after trying every channel.
+func Deliver(ctx context.Context, sender Sender, channels []Channel, e Event) error {
+	var first error
+	for _, c := range channels {
+		if !c.Wants(e.Type) {
+			continue
+		}
+		if err := sender.Send(ctx, c, e); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,84 @@
+package notify
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// ErrForbiddenTarget indicates a webhook URL that isn't https or whose host
+// resolves to an address of the service's own network, or a topic of the
+// service's own project.
+var ErrForbiddenTarget = errors.NewCoded("NOTIFY-005", "notification target not allowed")
+
+// newWebhookClient returns the client of the webhooks, given by the
+// principals: it only speaks https, redirects included, and only dials
+// public addresses, so a webhook can't reach the metadata server, the
+// loopback or the private network of the service.
+func newWebhookClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         publicDialContext(dialer, net.DefaultResolver),
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConnsPerHost: 2,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("stopped after 5 redirects")
+			}
+			return checkScheme(req.URL)
+		},
+	}
+}
+
+// checkScheme returns ErrForbiddenTarget unless u is an https URL.
+func checkScheme(u *url.URL) error {
+	if u.Scheme != "https" {
+		return errors.Wrap(ErrForbiddenTarget, errors.New("webhook target must be an https URL"))
+	}
+	return nil
+}
+
+// publicDialContext returns a DialContext resolving the host with resolver
+// and dialing its first address, failing when any of them isn't public. The
+// address dialed is the one checked, so the host can't be rebound to
+// another between the check and the dial.
+func publicDialContext(dialer *net.Dialer, resolver *net.Resolver) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		ips, err := resolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		if len(ips) == 0 {
+			return nil, errors.Wrap(ErrForbiddenTarget, errors.New(host+" has no address"))
+		}
+		for _, ip := range ips {
+			if !isPublic(ip.IP) {
+				return nil, errors.Wrap(ErrForbiddenTarget, errors.New(host+" resolves to "+ip.String()))
+			}
+		}
+		return dialer.DialContext(ctx, network, net.JoinHostPort(ips[0].IP.String(), port))
+	}
+}
+
+// isPublic reports whether ip is a public unicast address: neither private,
+// loopback, link-local, which includes the metadata server, unspecified nor
+// multicast.
+func isPublic(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !ip.IsLoopback() && !ip.IsLinkLocalUnicast() && !sharedAddressSpace.Contains(ip)
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, RFC 6598, private in
+// all but name.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
@@ -0,0 +1,162 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"net/url"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/capability"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// ConfirmPath is the route of the links confirming the email channels.
+const ConfirmPath = "/api/notifications/confirm"
+
+// scopeConfirm is the capability.Scope of the confirmation tokens.
+const scopeConfirm capability.Scope = "confirm"
+
+// Mailer sends plain text emails.
+type Mailer interface {
+	Mail(ctx context.Context, to, subject, body string) error
+}
+
+type smtpMailer struct {
+	addr     string
+	from     string
+	username string
+	password string
+}
+
+// NewSMTPMailer returns a Mailer sending from from through the SMTP relay
+// at addr, host:port, e.g. a SendGrid or Mailgun relay as App Engine has no
+// outbound port 25. It authenticates with username and password unless
+// empty, over STARTTLS when the relay offers it.
+func NewSMTPMailer(addr, from, username, password string) Mailer {
+	return &smtpMailer{addr: addr, from: from, username: username, password: password}
+}
+
+func (m *smtpMailer) Mail(ctx context.Context, to, subject, body string) error {
+	host, _, err := net.SplitHostPort(m.addr)
+	if err != nil {
+		return err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return errors.Wrap(ErrDeliveryFailed, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return errors.Wrap(ErrDeliveryFailed, err)
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return errors.Wrap(ErrDeliveryFailed, err)
+		}
+	}
+	if m.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.username, m.password, host)); err != nil {
+			return errors.Wrap(ErrDeliveryFailed, err)
+		}
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n", m.from, to, mime.QEncoding.Encode("utf-8", subject), time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n", body)
+	if err := c.Mail(m.from); err != nil {
+		return errors.Wrap(ErrDeliveryFailed, err)
+	}
+	if err := c.Rcpt(to); err != nil {
+		return errors.Wrap(ErrDeliveryFailed, err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return errors.Wrap(ErrDeliveryFailed, err)
+	}
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		return errors.Wrap(ErrDeliveryFailed, err)
+	}
+	if err := w.Close(); err != nil {
+		return errors.Wrap(ErrDeliveryFailed, err)
+	}
+	return c.Quit()
+}
+
+type emailVerifier struct {
+	mailer  Mailer
+	issuer  capability.Issuer
+	baseURL string
+	ttl     time.Duration
+}
+
+// NewEmailVerifier returns a Verifier mailing email channels a link to
+// ConfirmPath under baseURL, e.g. https://add.example.com, which verifies
+// them once followed within ttl. The link carries a token of issuer,
+// revoked once used.
+func NewEmailVerifier(mailer Mailer, issuer capability.Issuer, baseURL string, ttl time.Duration) Verifier {
+	return &emailVerifier{mailer: mailer, issuer: issuer, baseURL: baseURL, ttl: ttl}
+}
+
+// confirmOperation is the capability operation of the confirmation of the
+// email channel c of principal.
+func confirmOperation(principal string, c Channel) string {
+	return principal + "|" + string(c.Type) + "|" + c.Target
+}
+
+func (v *emailVerifier) Verify(ctx context.Context, principal string, c Channel) (bool, error) {
+	u, err := url.Parse(v.baseURL + ConfirmPath)
+	if err != nil {
+		return false, err
+	}
+	u.RawQuery = url.Values{"principal": {principal}, "type": {string(c.Type)}, "target": {c.Target}}.Encode()
+	u, err = v.issuer.SignURL(u, confirmOperation(principal, c), scopeConfirm, v.ttl)
+	if err != nil {
+		return false, err
+	}
+	body := fmt.Sprintf("Confirm that %s receives the notifications of %s by opening this link within %s:\r\n\r\n%s\r\n\r\nIgnore this email if you didn't ask for them.", c.Target, principal, v.ttl, u)
+	if err := v.mailer.Mail(ctx, c.Target, "Confirm your notification address", body); err != nil {
+		return false, errors.Wrap(ErrVerificationFailed, err)
+	}
+	return false, nil
+}
+
+func (v *emailVerifier) Confirm(ctx context.Context, principal string, c Channel, token string) error {
+	operation := confirmOperation(principal, c)
+	if err := v.issuer.Verify(ctx, token, operation, scopeConfirm); err != nil {
+		return err
+	}
+	return v.issuer.Revoke(ctx, operation)
+}
+
+type emailSender struct {
+	mailer Mailer
+}
+
+// NewEmailSender returns a Sender mailing the events, as indented JSON, to
+// the address of email channels.
+func NewEmailSender(mailer Mailer) Sender {
+	return &emailSender{mailer: mailer}
+}
+
+func (s *emailSender) Send(ctx context.Context, c Channel, e Event) error {
+	if c.Type != Email {
+		return ErrInvalidChannel
+	}
+	body, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	return s.mailer.Mail(ctx, c.Target, "Notification: "+e.Type, string(body))
+}
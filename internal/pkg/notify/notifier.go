@@ -0,0 +1,39 @@
+package notify
+
+import (
+	"context"
+)
+
+// Notifier delivers the events of principals to the channels they
+// subscribed to, as their preferences tell.
+type Notifier struct {
+	svc     Service
+	senders map[ChannelType]Sender
+}
+
+// NewNotifier returns a Notifier resolving the channels of the principals
+// with svc, and delivering the events with the sender of their type.
+func NewNotifier(svc Service, senders map[ChannelType]Sender) *Notifier {
+	return &Notifier{svc: svc, senders: senders}
+}
+
+// Notify delivers e to the verified channels of principal subscribed to its
+// type, returning the first error after trying every channel. The channels
+// of a type without sender are skipped.
+func (n *Notifier) Notify(ctx context.Context, principal string, e Event) error {
+	channels, err := n.svc.Channels(ctx, principal, e.Type)
+	if err != nil {
+		return err
+	}
+	var first error
+	for _, c := range channels {
+		sender, ok := n.senders[c.Type]
+		if !ok {
+			continue
+		}
+		if err := sender.Send(ctx, c, e); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
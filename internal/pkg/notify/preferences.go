@@ -0,0 +1,83 @@
+package notify
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ChannelType is the kind of destination a notification is delivered to.
+type ChannelType string
+
+const (
+	// Webhook delivers events as JSON POSTs to a URL.
+	Webhook ChannelType = "webhook"
+	// Email delivers events to an email address.
+	Email ChannelType = "email"
+	// PubSub publishes events to a Pub/Sub topic.
+	PubSub ChannelType = "pubsub"
+)
+
+// Channel is a single notification destination of a principal.
+type Channel struct {
+	Type ChannelType `json:"type"`
+	// Target is the webhook URL, email address or fully qualified topic name.
+	Target string `json:"target"`
+	// Events restricts the channel to these event types; empty means all.
+	Events   []string `json:"events,omitempty"`
+	Verified bool     `json:"verified"`
+	// VerifiedAt is when the channel was verified, nil until it is.
+	VerifiedAt *time.Time `json:"verified_at,omitempty"`
+}
+
+// Wants reports whether the channel subscribed to event.
+func (c Channel) Wants(event string) bool {
+	if len(c.Events) == 0 {
+		return true
+	}
+	for _, e := range c.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Preferences are the notification preferences of a principal.
+type Preferences struct {
+	Principal string    `json:"principal"`
+	Channels  []Channel `json:"channels"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Repository persists notification preferences.
+type Repository interface {
+	Get(ctx context.Context, principal string) (Preferences, error)
+	Save(ctx context.Context, prefs Preferences) error
+}
+
+type memoryRepository struct {
+	mu    sync.RWMutex
+	prefs map[string]Preferences
+}
+
+// NewMemoryRepository returns an instance-local Repository.
+func NewMemoryRepository() Repository {
+	return &memoryRepository{prefs: make(map[string]Preferences)}
+}
+
+func (r *memoryRepository) Get(_ context.Context, principal string) (Preferences, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if p, ok := r.prefs[principal]; ok {
+		return p, nil
+	}
+	return Preferences{Principal: principal, Channels: []Channel{}}, nil
+}
+
+func (r *memoryRepository) Save(_ context.Context, prefs Preferences) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prefs[prefs.Principal] = prefs
+	return nil
+}
@@ -0,0 +1,93 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/gcp"
+)
+
+// AttrEventType is the attribute of the messages published to the Pub/Sub
+// channels carrying the type of their event, channel_verification for the
+// test publish of the handshake.
+const AttrEventType = "eventType"
+
+// Publisher publishes messages to a Pub/Sub topic, e.g. a gcp.Publisher.
+type Publisher interface {
+	Publish(ctx context.Context, data []byte, attrs map[string]string) (string, error)
+}
+
+// Topics returns the Publisher of a fully qualified topic,
+// projects/<project>/topics/<topic>.
+type Topics func(topic string) Publisher
+
+// GCPTopics returns Topics of gcp.Publishers, made once per topic.
+func GCPTopics() Topics {
+	var mu sync.Mutex
+	publishers := map[string]Publisher{}
+	return func(topic string) Publisher {
+		mu.Lock()
+		defer mu.Unlock()
+		p, ok := publishers[topic]
+		if !ok {
+			parts := strings.Split(topic, "/")
+			p = gcp.NewPublisher(parts[1], parts[3])
+			publishers[topic] = p
+		}
+		return p
+	}
+}
+
+type pubsubVerifier struct {
+	topics     Topics
+	ownProject string
+}
+
+// NewPubSubVerifier returns a Verifier publishing a test message to the
+// topic of Pub/Sub channels, which verifies them once it's accepted. The
+// topics of ownProject, those of the service, are refused, so a principal
+// can't publish to them.
+func NewPubSubVerifier(topics Topics, ownProject string) Verifier {
+	return &pubsubVerifier{topics: topics, ownProject: ownProject}
+}
+
+func (v *pubsubVerifier) Verify(ctx context.Context, principal string, c Channel) (bool, error) {
+	if strings.HasPrefix(c.Target, "projects/"+v.ownProject+"/") {
+		return false, errors.Wrap(ErrForbiddenTarget, errors.New("topic of the service's own project"))
+	}
+	data, err := json.Marshal(map[string]string{"type": "channel_verification", "principal": principal})
+	if err != nil {
+		return false, err
+	}
+	if _, err := v.topics(c.Target).Publish(ctx, data, map[string]string{AttrEventType: "channel_verification"}); err != nil {
+		return false, errors.Wrap(ErrVerificationFailed, err)
+	}
+	return true, nil
+}
+
+type pubsubSender struct {
+	topics Topics
+}
+
+// NewPubSubSender returns a Sender publishing the events as JSON to the
+// topic of Pub/Sub channels, with their type in AttrEventType.
+func NewPubSubSender(topics Topics) Sender {
+	return &pubsubSender{topics: topics}
+}
+
+func (s *pubsubSender) Send(ctx context.Context, c Channel, e Event) error {
+	if c.Type != PubSub {
+		return ErrInvalidChannel
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := s.topics(c.Target).Publish(ctx, data, map[string]string{AttrEventType: e.Type}); err != nil {
+		return errors.Wrap(ErrDeliveryFailed, err)
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package notify
+
+import (
+	"context"
+	"net/mail"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+var (
+	// ErrInvalidChannel indicates a channel with an unknown type or malformed target.
+	ErrInvalidChannel = errors.NewCoded("NOTIFY-001", "invalid notification channel")
+
+	// ErrMissingPrincipal indicates the request carries no authenticated principal.
+	ErrMissingPrincipal = errors.NewCoded("NOTIFY-002", "missing principal")
+
+	// ErrUnknownChannel indicates a confirmation of a channel the principal
+	// doesn't have, or no longer.
+	ErrUnknownChannel = errors.NewCoded("NOTIFY-006", "unknown notification channel")
+)
+
+// Service manages the notification preferences of principals.
+type Service interface {
+	// GetPreferences returns the preferences of principal.
+	GetPreferences(ctx context.Context, principal string) (Preferences, error)
+
+	// SetPreferences replaces the channels of principal. The channels not
+	// verified yet go through the verification handshake of their type;
+	// those whose handshake completes later, the email ones, are saved
+	// unverified until confirmed.
+	SetPreferences(ctx context.Context, principal string, channels []Channel) (Preferences, error)
+
+	// Confirm completes the handshake of the channel c of principal with
+	// token, see Confirmer, and returns the channel verified.
+	Confirm(ctx context.Context, principal string, c Channel, token string) (Channel, error)
+
+	// Channels returns the verified channels of principal subscribed to
+	// event. This is what event and webhook delivery consume.
+	Channels(ctx context.Context, principal string, event string) ([]Channel, error)
+}
+
+type notifyService struct {
+	repo      Repository
+	verifiers map[ChannelType]Verifier
+	logger    log.Logger
+}
+
+// NewService returns a new instance of the notification preferences service,
+// verifying the channels with the verifier of their type. The types without
+// one are refused.
+func NewService(repo Repository, verifiers map[ChannelType]Verifier, logger log.Logger) Service {
+	return &notifyService{repo: repo, verifiers: verifiers, logger: logger}
+}
+
+func (s *notifyService) GetPreferences(ctx context.Context, principal string) (Preferences, error) {
+	if principal == "" {
+		return Preferences{}, ErrMissingPrincipal
+	}
+	return s.repo.Get(ctx, principal)
+}
+
+func (s *notifyService) SetPreferences(ctx context.Context, principal string, channels []Channel) (Preferences, error) {
+	if principal == "" {
+		return Preferences{}, ErrMissingPrincipal
+	}
+	current, err := s.repo.Get(ctx, principal)
+	if err != nil {
+		return Preferences{}, err
+	}
+	verified := map[string]Channel{}
+	for _, c := range current.Channels {
+		if c.Verified {
+			verified[string(c.Type)+"|"+c.Target] = c
+		}
+	}
+
+	next := make([]Channel, len(channels))
+	var pending []int
+	for i, c := range channels {
+		c, err := validate(c)
+		if err != nil {
+			return Preferences{}, err
+		}
+		next[i] = c
+		if prev, ok := verified[string(c.Type)+"|"+c.Target]; ok {
+			next[i].Verified, next[i].VerifiedAt = true, prev.VerifiedAt
+			continue
+		}
+		if _, ok := s.verifiers[c.Type]; !ok {
+			return Preferences{}, errors.Wrap(ErrInvalidChannel, errors.New(string(c.Type)+" channels aren't enabled"))
+		}
+		pending = append(pending, i)
+	}
+	// the handshakes completing later go last, so that no confirmation is
+	// sent for preferences another handshake fails
+	sort.SliceStable(pending, func(i, j int) bool {
+		_, later := s.verifiers[next[pending[j]].Type].(Confirmer)
+		_, first := s.verifiers[next[pending[i]].Type].(Confirmer)
+		return !first && later
+	})
+	for _, i := range pending {
+		c := next[i]
+		done, err := s.verifiers[c.Type].Verify(ctx, principal, c)
+		if err != nil {
+			level.Info(s.logger).Log("principal", principal, string(c.Type), c.Target, "err", err)
+			return Preferences{}, err
+		}
+		next[i].Verified, next[i].VerifiedAt = done, nil
+		if done {
+			next[i].VerifiedAt = now()
+		}
+	}
+
+	prefs := Preferences{Principal: principal, Channels: next, UpdatedAt: time.Now().UTC()}
+	if err := s.repo.Save(ctx, prefs); err != nil {
+		return Preferences{}, err
+	}
+	return prefs, nil
+}
+
+func (s *notifyService) Confirm(ctx context.Context, principal string, c Channel, token string) (Channel, error) {
+	confirmer, ok := s.verifiers[c.Type].(Confirmer)
+	if !ok {
+		return Channel{}, ErrInvalidChannel
+	}
+	if err := confirmer.Confirm(ctx, principal, c, token); err != nil {
+		return Channel{}, err
+	}
+	prefs, err := s.repo.Get(ctx, principal)
+	if err != nil {
+		return Channel{}, err
+	}
+	for i, pc := range prefs.Channels {
+		if pc.Type != c.Type || pc.Target != c.Target {
+			continue
+		}
+		if !pc.Verified {
+			pc.Verified, pc.VerifiedAt = true, now()
+			prefs.Channels[i] = pc
+			if err := s.repo.Save(ctx, prefs); err != nil {
+				return Channel{}, err
+			}
+		}
+		return pc, nil
+	}
+	return Channel{}, ErrUnknownChannel
+}
+
+func (s *notifyService) Channels(ctx context.Context, principal string, event string) ([]Channel, error) {
+	prefs, err := s.repo.Get(ctx, principal)
+	if err != nil {
+		return nil, err
+	}
+	var res []Channel
+	for _, c := range prefs.Channels {
+		if c.Verified && c.Wants(event) {
+			res = append(res, c)
+		}
+	}
+	return res, nil
+}
+
+// validate returns c with its target normalized, the bare address of the
+// email ones, or an error when it's invalid.
+func validate(c Channel) (Channel, error) {
+	switch c.Type {
+	case Webhook:
+		u, err := url.Parse(c.Target)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return c, errors.Wrap(ErrInvalidChannel, errors.New("webhook target must be an https URL"))
+		}
+	case Email:
+		addr, err := mail.ParseAddress(c.Target)
+		if err != nil {
+			return c, errors.Wrap(ErrInvalidChannel, err)
+		}
+		c.Target = addr.Address
+	case PubSub:
+		parts := strings.Split(c.Target, "/")
+		if len(parts) != 4 || parts[0] != "projects" || parts[1] == "" || parts[2] != "topics" || parts[3] == "" {
+			return c, errors.Wrap(ErrInvalidChannel, errors.New("topic must be projects/<project>/topics/<topic>"))
+		}
+	default:
+		return c, ErrInvalidChannel
+	}
+	return c, nil
+}
+
+// now returns the current time, in UTC.
+func now() *time.Time {
+	t := time.Now().UTC()
+	return &t
+}
@@ -0,0 +1,98 @@
+package notify
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/capability"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+type mailbox struct{ bodies []string }
+
+func (m *mailbox) Mail(_ context.Context, _, _, body string) error {
+	m.bodies = append(m.bodies, body)
+	return nil
+}
+
+type topic struct{ published []string }
+
+func (t *topic) Publish(_ context.Context, data []byte, _ map[string]string) (string, error) {
+	t.published = append(t.published, string(data))
+	return "1", nil
+}
+
+type recorder struct{ sent []Channel }
+
+func (r *recorder) Send(_ context.Context, c Channel, _ Event) error {
+	r.sent = append(r.sent, c)
+	return nil
+}
+
+func TestHandshakes(t *testing.T) {
+	ctx := context.Background()
+	mails, pubsub := &mailbox{}, &topic{}
+	topics := func(string) Publisher { return pubsub }
+	issuer := capability.New([]byte("key"), time.Hour, capability.NewMemoryRevocations())
+	svc := NewService(NewMemoryRepository(), map[ChannelType]Verifier{
+		Email:  NewEmailVerifier(mails, issuer, "https://add.example.com", time.Hour),
+		PubSub: NewPubSubVerifier(topics, "service"),
+	}, log.NewNopLogger())
+
+	email := Channel{Type: Email, Target: "Alice <alice@example.com>"}
+	topicChannel := Channel{Type: PubSub, Target: "projects/alice/topics/events"}
+	prefs, err := svc.SetPreferences(ctx, "alice", []Channel{email, topicChannel})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := prefs.Channels[0]; c.Verified || c.Target != "alice@example.com" {
+		t.Errorf("email channel = %+v, want alice@example.com unverified", c)
+	}
+	if !prefs.Channels[1].Verified || len(pubsub.published) != 1 {
+		t.Errorf("pubsub channel verified %v after %d test publishes, want verified after 1", prefs.Channels[1].Verified, len(pubsub.published))
+	}
+	if _, err := svc.SetPreferences(ctx, "alice", []Channel{{Type: PubSub, Target: "projects/service/topics/internal"}}); !errors.Contains(errors.Cast(err), ErrForbiddenTarget) {
+		t.Errorf("SetPreferences(topic of the service) = %v, want %v", err, ErrForbiddenTarget)
+	}
+	if _, err := svc.SetPreferences(ctx, "alice", []Channel{{Type: Webhook, Target: "https://example.com/hook"}}); !errors.Contains(errors.Cast(err), ErrInvalidChannel) {
+		t.Errorf("SetPreferences(webhook without verifier) = %v, want %v", err, ErrInvalidChannel)
+	}
+
+	if _, err := svc.SetPreferences(ctx, "bob", []Channel{{Type: Email, Target: "bob@example.com"}, {Type: PubSub, Target: "projects/service/topics/internal"}}); err == nil {
+		t.Error("SetPreferences(email and topic of the service) succeeded")
+	}
+	if len(mails.bodies) != 1 {
+		t.Fatalf("%d emails sent, want 1", len(mails.bodies))
+	}
+	link, err := url.Parse(regexp.MustCompile(`https://\S+`).FindString(mails.bodies[0]))
+	if err != nil || link.Path != ConfirmPath {
+		t.Fatalf("confirmation link = %v, %v", link, err)
+	}
+	q := link.Query()
+	confirm := func() (Channel, error) {
+		return svc.Confirm(ctx, q.Get("principal"), Channel{Type: ChannelType(q.Get("type")), Target: q.Get("target")}, q.Get(capability.QueryParam))
+	}
+	if _, err := svc.Confirm(ctx, "mallory", Channel{Type: Email, Target: "alice@example.com"}, q.Get(capability.QueryParam)); !errors.Contains(errors.Cast(err), capability.ErrScopeMismatch) {
+		t.Errorf("Confirm(another principal) = %v, want %v", err, capability.ErrScopeMismatch)
+	}
+	if c, err := confirm(); err != nil || !c.Verified {
+		t.Errorf("Confirm() = %+v, %v, want verified", c, err)
+	}
+	if _, err := confirm(); !errors.Contains(errors.Cast(err), capability.ErrTokenRevoked) {
+		t.Errorf("Confirm() again = %v, want %v", err, capability.ErrTokenRevoked)
+	}
+
+	webhooks, emails := &recorder{}, &recorder{}
+	n := NewNotifier(svc, map[ChannelType]Sender{Webhook: webhooks, Email: emails})
+	if err := n.Notify(ctx, "alice", Event{Type: "add.sum.computed"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(emails.sent) != 1 || len(webhooks.sent) != 0 {
+		t.Errorf("Notify() sent %d emails and %d webhooks, want 1 and 0", len(emails.sent), len(webhooks.sent))
+	}
+}
@@ -0,0 +1,124 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/go-zoo/bone"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/auth"
+	"github.com/cage1016/gokit-gae/internal/pkg/capability"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/responses"
+)
+
+type setPreferencesRequest struct {
+	Channels []Channel `json:"channels"`
+}
+
+type confirmRequest struct {
+	Principal string
+	Channel   Channel
+	Token     string
+}
+
+// MakeHTTPHandler returns a handler serving the preferences of the caller
+// under /api/notifications/preferences. The caller is the "sub" claim of the
+// JWT verified by authn, e.g. a kitjwt.NewParser middleware. The links
+// confirming the channels, on ConfirmPath, are authorized by their token.
+func MakeHTTPHandler(svc Service, authn endpoint.Middleware, logger log.Logger) http.Handler {
+	options := []httptransport.ServerOption{
+		httptransport.ServerErrorEncoder(encodeError),
+		httptransport.ServerErrorLogger(logger),
+		httptransport.ServerBefore(kitjwt.HTTPToContext()),
+	}
+
+	m := bone.New()
+	m.Get("/api/notifications/preferences", httptransport.NewServer(
+		authn(makeGetPreferencesEndpoint(svc)),
+		func(context.Context, *http.Request) (interface{}, error) { return nil, nil },
+		encodeResponse,
+		options...,
+	))
+	m.Put("/api/notifications/preferences", httptransport.NewServer(
+		authn(makeSetPreferencesEndpoint(svc)),
+		decodeSetPreferencesRequest,
+		encodeResponse,
+		options...,
+	))
+	m.Get(ConfirmPath, httptransport.NewServer(
+		makeConfirmEndpoint(svc),
+		decodeConfirmRequest,
+		encodeResponse,
+		options...,
+	))
+	return m
+}
+
+func makeGetPreferencesEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, _ interface{}) (interface{}, error) {
+		return svc.GetPreferences(ctx, auth.Principal(ctx))
+	}
+}
+
+func makeSetPreferencesEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(setPreferencesRequest)
+		return svc.SetPreferences(ctx, auth.Principal(ctx), req.Channels)
+	}
+}
+
+func makeConfirmEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(confirmRequest)
+		return svc.Confirm(ctx, req.Principal, req.Channel, req.Token)
+	}
+}
+
+func decodeConfirmRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	q := r.URL.Query()
+	return confirmRequest{
+		Principal: q.Get("principal"),
+		Channel:   Channel{Type: ChannelType(q.Get("type")), Target: q.Get("target")},
+		Token:     q.Get(capability.QueryParam),
+	}, nil
+}
+
+func decodeSetPreferencesRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req setPreferencesRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	return req, err
+}
+
+func encodeResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(responses.DataRes{Data: response})
+}
+
+func encodeError(_ context.Context, err error, w http.ResponseWriter) {
+	code := http.StatusInternalServerError
+	ce := errors.Cast(err)
+	switch {
+	case errors.Contains(ce, ErrMissingPrincipal), errors.Contains(ce, capability.ErrInvalidToken), errors.Contains(ce, capability.ErrTokenExpired):
+		code = http.StatusUnauthorized
+	case errors.Contains(ce, capability.ErrTokenRevoked), errors.Contains(ce, capability.ErrScopeMismatch):
+		code = http.StatusForbidden
+	case errors.Contains(ce, ErrUnknownChannel):
+		code = http.StatusNotFound
+	case errors.Contains(ce, ErrInvalidChannel), errors.Contains(ce, ErrVerificationFailed), errors.Contains(ce, ErrForbiddenTarget):
+		code = http.StatusBadRequest
+	default:
+		switch err.(type) {
+		case *json.SyntaxError, *json.UnmarshalTypeError:
+			code = http.StatusBadRequest
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(responses.ErrorRes{Error: responses.ErrorResItem{Code: code, ErrorCode: errors.Code(err), Message: ce.Msg(), Errors: ce.Errors()}})
+}
@@ -0,0 +1,108 @@
+// Package opbudget counts the store operations of every request against a
+// budget, catching the endpoints doing a query per item they return, the
+// N+1 pattern, before they reach production. The middleware gives every
+// request a counter in its context, the stores count their operations in it,
+// and a request over budget is logged, or fails when the budget is strict,
+// e.g. in dev.
+package opbudget
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// ErrExceeded indicates a request doing more store operations than its
+// budget allows, with a strict budget.
+var ErrExceeded = errors.Register(errors.KindInternal, errors.NewCoded("OPS-001", "operation budget exceeded"))
+
+type contextKey struct{}
+
+// counter counts the operations of a request, by name.
+type counter struct {
+	mu  sync.Mutex
+	ops map[string]int
+}
+
+// NewContext returns ctx carrying a new counter, the operations counted in
+// ctx before being dropped.
+func NewContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKey{}, &counter{ops: map[string]int{}})
+}
+
+// Count counts the operation op in the counter of ctx, if any.
+func Count(ctx context.Context, op string) {
+	c, ok := ctx.Value(contextKey{}).(*counter)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	c.ops[op]++
+	c.mu.Unlock()
+}
+
+// Ops returns the operations counted in ctx, by name, and their total.
+func Ops(ctx context.Context) (map[string]int, int) {
+	c, ok := ctx.Value(contextKey{}).(*counter)
+	if !ok {
+		return nil, 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ops, total := make(map[string]int, len(c.ops)), 0
+	for op, n := range c.ops {
+		ops[op] = n
+		total += n
+	}
+	return ops, total
+}
+
+// Middleware counts the store operations of the requests of method against
+// budget, counting the requests over it in exceeded and logging them with
+// their operations. With strict, such a request fails with ErrExceeded even
+// when it succeeded, its operations being done already. A budget of 0 or
+// less is unlimited.
+func Middleware(method string, budget int, strict bool, exceeded metrics.Counter, logger log.Logger) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		if budget <= 0 {
+			return next
+		}
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			ctx = NewContext(ctx)
+			response, err := next(ctx, request)
+			ops, total := Ops(ctx)
+			if total <= budget {
+				return response, err
+			}
+			exceeded.Add(1)
+			level.Warn(logger).Log("method", method, "ops", total, "budget", budget, "detail", format(ops))
+			if strict && err == nil {
+				return nil, errors.Wrap(ErrExceeded, fmt.Errorf("%s: %d operations, budget %d", method, total, budget))
+			}
+			return response, err
+		}
+	}
+}
+
+// format returns ops as name=count pairs, sorted by name.
+func format(ops map[string]int) string {
+	names := make([]string, 0, len(ops))
+	for op := range ops {
+		names = append(names, op)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, op := range names {
+		pairs[i] = fmt.Sprintf("%s=%d", op, ops[op])
+	}
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,157 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/gcp"
+)
+
+// Kind is the Datastore kind of the events.
+const Kind = "OutboxEvent"
+
+type datastoreStore struct {
+	baseURL   string
+	projectID string
+	namespace string
+	client    *http.Client
+}
+
+// NewDatastoreStore returns a Store keeping the events in Cloud Datastore (or
+// Firestore in Datastore mode) through its REST API, in namespace of
+// projectID. It goes unauthenticated to the emulator when
+// DATASTORE_EMULATOR_HOST is set. The events are queried by creation time
+// only, which the built-in indexes serve.
+func NewDatastoreStore(projectID, namespace string) Store {
+	s := &datastoreStore{
+		baseURL:   "https://datastore.googleapis.com/v1/projects/" + projectID,
+		projectID: projectID,
+		namespace: namespace,
+		client:    gcp.NewClient("https://www.googleapis.com/auth/datastore"),
+	}
+	if host := os.Getenv("DATASTORE_EMULATOR_HOST"); host != "" {
+		s.baseURL = "http://" + host + "/v1/projects/" + projectID
+		s.client = http.DefaultClient
+	}
+	return s
+}
+
+func (s *datastoreStore) partition() map[string]string {
+	p := map[string]string{"projectId": s.projectID}
+	if s.namespace != "" {
+		p["namespaceId"] = s.namespace
+	}
+	return p
+}
+
+func (s *datastoreStore) key(id string) map[string]interface{} {
+	return map[string]interface{}{
+		"partitionId": s.partition(),
+		"path":        []interface{}{map[string]string{"kind": Kind, "name": id}},
+	}
+}
+
+func (s *datastoreStore) Add(ctx context.Context, events ...Event) error {
+	mutations := make([]interface{}, len(events))
+	for i, e := range events {
+		mutations[i] = map[string]interface{}{"insert": map[string]interface{}{
+			"key": s.key(e.ID),
+			"properties": map[string]interface{}{
+				"type":      map[string]interface{}{"stringValue": e.Type},
+				"data":      map[string]interface{}{"blobValue": base64.StdEncoding.EncodeToString(e.Data), "excludeFromIndexes": true},
+				"createdAt": map[string]interface{}{"timestampValue": e.CreatedAt.UTC().Format(time.RFC3339Nano)},
+			},
+		}}
+	}
+	return s.call(ctx, "commit", map[string]interface{}{"mode": "NON_TRANSACTIONAL", "mutations": mutations}, nil)
+}
+
+func (s *datastoreStore) Pending(ctx context.Context, limit int) ([]Event, error) {
+	query := map[string]interface{}{
+		"kind":  []interface{}{map[string]string{"name": Kind}},
+		"order": []interface{}{map[string]interface{}{"property": map[string]string{"name": "createdAt"}, "direction": "ASCENDING"}},
+		"limit": limit,
+	}
+	var resp struct {
+		Batch struct {
+			EntityResults []struct {
+				Entity struct {
+					Key struct {
+						Path []struct {
+							Name string `json:"name"`
+						} `json:"path"`
+					} `json:"key"`
+					Properties struct {
+						Type struct {
+							StringValue string `json:"stringValue"`
+						} `json:"type"`
+						Data struct {
+							BlobValue []byte `json:"blobValue"`
+						} `json:"data"`
+						CreatedAt struct {
+							TimestampValue time.Time `json:"timestampValue"`
+						} `json:"createdAt"`
+					} `json:"properties"`
+				} `json:"entity"`
+			} `json:"entityResults"`
+		} `json:"batch"`
+	}
+	if err := s.call(ctx, "runQuery", map[string]interface{}{"partitionId": s.partition(), "query": query}, &resp); err != nil {
+		return nil, err
+	}
+	events := make([]Event, 0, len(resp.Batch.EntityResults))
+	for _, r := range resp.Batch.EntityResults {
+		e := r.Entity
+		if len(e.Key.Path) == 0 {
+			continue
+		}
+		events = append(events, Event{
+			ID:        e.Key.Path[len(e.Key.Path)-1].Name,
+			Type:      e.Properties.Type.StringValue,
+			Data:      e.Properties.Data.BlobValue,
+			CreatedAt: e.Properties.CreatedAt.TimestampValue,
+		})
+	}
+	return events, nil
+}
+
+func (s *datastoreStore) Remove(ctx context.Context, ids ...string) error {
+	mutations := make([]interface{}, len(ids))
+	for i, id := range ids {
+		mutations[i] = map[string]interface{}{"delete": s.key(id)}
+	}
+	return s.call(ctx, "commit", map[string]interface{}{"mode": "NON_TRANSACTIONAL", "mutations": mutations}, nil)
+}
+
+func (s *datastoreStore) call(ctx context.Context, method string, in interface{}, out interface{}) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.baseURL+":"+method, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(ErrStore, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return errors.Wrap(ErrStore, fmt.Errorf("%s: %s %s", method, resp.Status, bytes.TrimSpace(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
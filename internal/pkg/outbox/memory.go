@@ -0,0 +1,50 @@
+package outbox
+
+import (
+	"context"
+	"sync"
+)
+
+type memoryStore struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+// NewMemoryStore returns an instance-local Store, its events lost with the
+// instance: useful for development only.
+func NewMemoryStore() Store {
+	return &memoryStore{}
+}
+
+func (s *memoryStore) Add(_ context.Context, events ...Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func (s *memoryStore) Pending(_ context.Context, limit int) ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if limit > len(s.events) {
+		limit = len(s.events)
+	}
+	return append([]Event(nil), s.events[:limit]...), nil
+}
+
+func (s *memoryStore) Remove(_ context.Context, ids ...string) error {
+	removed := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		removed[id] = true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.events[:0]
+	for _, e := range s.events {
+		if !removed[e.ID] {
+			kept = append(kept, e)
+		}
+	}
+	s.events = kept
+	return nil
+}
@@ -0,0 +1,140 @@
+// Package outbox publishes domain events reliably: they're written to a
+// store as part of the operation raising them, and a dispatcher publishes
+// them to Pub/Sub afterwards, removing them once published. An event is
+// published at least once; its ID, in the eventId attribute of the message,
+// lets subscribers drop the duplicates.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// AttrEventID and AttrEventType are the attributes of the published
+// messages carrying the ID and the type of their event.
+const (
+	AttrEventID   = "eventId"
+	AttrEventType = "eventType"
+)
+
+// ErrStore indicates the outbox store failed.
+var ErrStore = errors.New("outbox store failed")
+
+// Event is a domain event waiting in the outbox.
+type Event struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// NewEvent returns the event id of type typ carrying data, encoded as JSON.
+func NewEvent(id, typ string, data interface{}) (Event, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{ID: id, Type: typ, Data: b, CreatedAt: time.Now().UTC()}, nil
+}
+
+// Store keeps the events until they're published.
+type Store interface {
+	// Add writes events to the outbox.
+	Add(ctx context.Context, events ...Event) error
+	// Pending returns up to limit events, oldest first.
+	Pending(ctx context.Context, limit int) ([]Event, error)
+	// Remove removes the events of ids, once published.
+	Remove(ctx context.Context, ids ...string) error
+}
+
+// Publisher publishes messages, e.g. a gcp.Publisher.
+type Publisher interface {
+	Publish(ctx context.Context, data []byte, attrs map[string]string) (string, error)
+}
+
+// Dispatcher publishes the events of a store.
+type Dispatcher struct {
+	store     Store
+	publisher Publisher
+	batch     int
+	published metrics.Counter
+	logger    log.Logger
+}
+
+// NewDispatcher returns a Dispatcher publishing the events of store with
+// publisher, batch at a time, counting them in published by result
+// ("ok" or "error").
+func NewDispatcher(store Store, publisher Publisher, batch int, published metrics.Counter, logger log.Logger) *Dispatcher {
+	return &Dispatcher{store: store, publisher: publisher, batch: batch, published: published, logger: logger}
+}
+
+// Dispatch publishes the pending events, oldest first, until there are no
+// more, and returns how many it published. It stops at the first event
+// failing to publish, which stays pending for the next dispatch, as do the
+// events after it. An event published but failing to be removed is
+// published again later.
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	n := 0
+	for {
+		events, err := d.store.Pending(ctx, d.batch)
+		if err != nil || len(events) == 0 {
+			return n, err
+		}
+		ids := make([]string, 0, len(events))
+		for _, e := range events {
+			_, err := d.publisher.Publish(ctx, e.Data, map[string]string{AttrEventID: e.ID, AttrEventType: e.Type})
+			if err != nil {
+				d.published.With("result", "error").Add(1)
+				if len(ids) > 0 {
+					err = d.remove(ctx, ids, err)
+				}
+				return n, err
+			}
+			d.published.With("result", "ok").Add(1)
+			ids = append(ids, e.ID)
+			n++
+		}
+		if err := d.remove(ctx, ids, nil); err != nil {
+			return n, err
+		}
+		if len(events) < d.batch {
+			return n, nil
+		}
+	}
+}
+
+// remove removes the published events of ids, returning cause, or the error
+// removing them when cause is nil.
+func (d *Dispatcher) remove(ctx context.Context, ids []string, cause error) error {
+	if err := d.store.Remove(ctx, ids...); err != nil {
+		level.Warn(d.logger).Log("outbox", "remove", "events", len(ids), "err", err)
+		if cause == nil {
+			cause = err
+		}
+	}
+	return cause
+}
+
+// Run dispatches the pending events every interval until ctx is done. A
+// failing dispatch is logged and resumed at the next interval.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if n, err := d.Dispatch(ctx); err != nil {
+				level.Warn(d.logger).Log("outbox", "dispatch", "published", n, "err", err)
+			}
+		}
+	}
+}
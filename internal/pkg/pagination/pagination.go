@@ -0,0 +1,177 @@
+// Package pagination decodes the page requested of list endpoints, by cursor
+// or by offset, and describes the page served, in a meta block of the
+// response and in its Link header (RFC 8288).
+package pagination
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// The query parameters of the pages.
+const (
+	ParamCursor = "cursor"
+	ParamOffset = "offset"
+	ParamLimit  = "limit"
+)
+
+// ErrInvalidParams indicates malformed or out of range page parameters.
+var ErrInvalidParams = errors.New("invalid pagination parameters")
+
+// Limits bounds the page size of a list.
+type Limits struct {
+	// Default is the page size when none is requested.
+	Default int
+	// Max is the largest page size.
+	Max int
+}
+
+func (l Limits) decode(q url.Values) (int, error) {
+	v := q.Get(ParamLimit)
+	if v == "" {
+		return l.Default, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, errors.Wrap(ErrInvalidParams, err)
+	}
+	if n < 1 || n > l.Max {
+		return 0, errors.Wrap(ErrInvalidParams, fmt.Errorf("limit %d not within 1 and %d", n, l.Max))
+	}
+	return n, nil
+}
+
+// Paged is implemented by the responses of lists, returning the Link header
+// of the page they are, u being the URL of the request.
+type Paged interface {
+	Link(u *url.URL) string
+}
+
+// Cursor is a page requested by cursor. The empty cursor is the first page.
+type Cursor struct {
+	Cursor string
+	Limit  int
+}
+
+// DecodeCursor returns the page requested by the cursor and limit
+// parameters of q.
+func DecodeCursor(q url.Values, l Limits) (Cursor, error) {
+	limit, err := l.decode(q)
+	if err != nil {
+		return Cursor{}, err
+	}
+	return Cursor{Cursor: q.Get(ParamCursor), Limit: limit}, nil
+}
+
+// Meta returns the meta block of p, served with count items, next being the
+// cursor of the next page, empty on the last page.
+func (p Cursor) Meta(count int, next string) CursorMeta {
+	return CursorMeta{Limit: p.Limit, Count: count, NextCursor: next}
+}
+
+// CursorMeta describes a page served by cursor.
+type CursorMeta struct {
+	Limit      int    `json:"limit"`
+	Count      int    `json:"count"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// Link returns the Link header of the page: its first and next pages.
+func (m CursorMeta) Link(u *url.URL) string {
+	limit := strconv.Itoa(m.Limit)
+	links := []string{link(u, "first", map[string]string{ParamCursor: "", ParamLimit: limit})}
+	if m.NextCursor != "" {
+		links = append(links, link(u, "next", map[string]string{ParamCursor: m.NextCursor, ParamLimit: limit}))
+	}
+	return strings.Join(links, ", ")
+}
+
+// Offset is a page requested by offset.
+type Offset struct {
+	Offset int
+	Limit  int
+}
+
+// DecodeOffset returns the page requested by the offset and limit
+// parameters of q.
+func DecodeOffset(q url.Values, l Limits) (Offset, error) {
+	limit, err := l.decode(q)
+	if err != nil {
+		return Offset{}, err
+	}
+	p := Offset{Limit: limit}
+	if v := q.Get(ParamOffset); v != "" {
+		if p.Offset, err = strconv.Atoi(v); err != nil {
+			return Offset{}, errors.Wrap(ErrInvalidParams, err)
+		}
+		if p.Offset < 0 {
+			return Offset{}, errors.Wrap(ErrInvalidParams, fmt.Errorf("negative offset %d", p.Offset))
+		}
+	}
+	return p, nil
+}
+
+// Meta returns the meta block of p, served with count items out of total,
+// negative when unknown.
+func (p Offset) Meta(count int, total int64) OffsetMeta {
+	return OffsetMeta{Offset: p.Offset, Limit: p.Limit, Count: count, Total: total}
+}
+
+// OffsetMeta describes a page served by offset.
+type OffsetMeta struct {
+	Offset int   `json:"offset"`
+	Limit  int   `json:"limit"`
+	Count  int   `json:"count"`
+	Total  int64 `json:"total"`
+}
+
+// Link returns the Link header of the page: its first, previous, next and
+// last pages, the ones that exist.
+func (m OffsetMeta) Link(u *url.URL) string {
+	page := func(rel string, offset int) string {
+		return link(u, rel, map[string]string{ParamOffset: strconv.Itoa(offset), ParamLimit: strconv.Itoa(m.Limit)})
+	}
+	links := []string{page("first", 0)}
+	if m.Offset > 0 {
+		prev := m.Offset - m.Limit
+		if prev < 0 {
+			prev = 0
+		}
+		links = append(links, page("prev", prev))
+	}
+	if m.Total < 0 {
+		// the last page is the one not filled
+		if m.Count == m.Limit {
+			links = append(links, page("next", m.Offset+m.Limit))
+		}
+		return strings.Join(links, ", ")
+	}
+	if int64(m.Offset+m.Limit) < m.Total {
+		links = append(links, page("next", m.Offset+m.Limit))
+	}
+	if m.Total > 0 {
+		last := int((m.Total - 1) / int64(m.Limit) * int64(m.Limit))
+		links = append(links, page("last", last))
+	}
+	return strings.Join(links, ", ")
+}
+
+// link returns the link of relation rel to u, its query parameters set as
+// in params, the empty values removed.
+func link(u *url.URL, rel string, params map[string]string) string {
+	q := u.Query()
+	for k, v := range params {
+		if v == "" {
+			q.Del(k)
+		} else {
+			q.Set(k, v)
+		}
+	}
+	l := *u
+	l.RawQuery = q.Encode()
+	return fmt.Sprintf("<%s>; rel=%q", l.String(), rel)
+}
@@ -0,0 +1,118 @@
+// Package privileged meters the diagnostic request headers, such as debug
+// tracing or dry runs, so they can't be used to degrade the service.
+package privileged
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+	httptransport "github.com/go-kit/kit/transport/http"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/auth"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/limiter"
+)
+
+// The privileged headers.
+const (
+	HeaderDebugTrace  = "X-Debug-Trace"
+	HeaderDryRun      = "X-Dry-Run"
+	HeaderConsistency = "X-Consistency"
+)
+
+// Headers lists the privileged headers.
+var Headers = []string{HeaderDebugTrace, HeaderDryRun, HeaderConsistency}
+
+// ErrQuotaExceeded indicates the caller used privileged headers too often.
+var ErrQuotaExceeded = errors.Register(errors.KindResourceExhausted, errors.NewCoded("PRIV-001", "privileged header quota exceeded"))
+
+type contextKey int
+
+const (
+	headersContextKey contextKey = iota
+	remoteAddrContextKey
+)
+
+// HTTPToContext returns an http RequestFunc moving the privileged headers of
+// the request, and the client address used when there's no principal, into
+// ctx. The address is the last X-Forwarded-For hop, the one appended by the
+// App Engine front end, or the peer address without it: the first hops are
+// whatever the client sent.
+func HTTPToContext() httptransport.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		used := map[string]string{}
+		for _, h := range Headers {
+			if v := r.Header.Get(h); v != "" {
+				used[h] = v
+			}
+		}
+		if len(used) == 0 {
+			return ctx
+		}
+		ctx = context.WithValue(ctx, remoteAddrContextKey, clientAddr(r))
+		return context.WithValue(ctx, headersContextKey, used)
+	}
+}
+
+// clientAddr returns the address of the client of r, see HTTPToContext.
+func clientAddr(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		hops := strings.Split(fwd, ",")
+		return strings.TrimSpace(hops[len(hops)-1])
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// Value returns the value of the privileged header h of the request, empty if
+// it wasn't sent or wasn't granted.
+func Value(ctx context.Context, h string) string {
+	used, _ := ctx.Value(headersContextKey).(map[string]string)
+	return used[h]
+}
+
+// Middleware returns an endpoint middleware charging requests carrying
+// privileged headers to the quota of their principal, identified by the JWT
+// verified by authn, see auth.Authenticate, or of their client address for
+// anonymous callers. Every use is audited; requests over quota fail with
+// ErrQuotaExceeded.
+func Middleware(lim limiter.Limiter, uses metrics.Counter, authn endpoint.Middleware, logger log.Logger) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			used, _ := ctx.Value(headersContextKey).(map[string]string)
+			if len(used) == 0 {
+				return next(ctx, request)
+			}
+
+			key := auth.Authenticate(ctx, request, authn)
+			if key == "" {
+				addr, _ := ctx.Value(remoteAddrContextKey).(string)
+				key = "ip:" + addr
+			}
+			names := make([]string, 0, len(used))
+			for h := range used {
+				names = append(names, h)
+			}
+			sort.Strings(names)
+			headers := strings.Join(names, ",")
+
+			if !lim.Allow(key) {
+				uses.With("outcome", "denied").Add(1)
+				level.Warn(logger).Log("audit", "privileged", "caller", key, "headers", headers, "outcome", "denied")
+				return nil, ErrQuotaExceeded
+			}
+			uses.With("outcome", "allowed").Add(1)
+			level.Info(logger).Log("audit", "privileged", "caller", key, "headers", headers, "outcome", "allowed")
+			return next(ctx, request)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package progress
+
+import "context"
+
+type contextKey string
+
+const reporterContextKey contextKey = "ProgressReporter"
+
+// Event describes how far a long-running computation got.
+type Event struct {
+	Percent float64 `json:"percent"`
+	Message string  `json:"message,omitempty"`
+}
+
+// Reporter receives progress events.
+type Reporter func(Event)
+
+// WithReporter returns a context whose Report calls go to r.
+func WithReporter(ctx context.Context, r Reporter) context.Context {
+	return context.WithValue(ctx, reporterContextKey, r)
+}
+
+// Report emits progress to the reporter in ctx, if any. Service code may call
+// it unconditionally; it is a no-op for transports that can't stream.
+func Report(ctx context.Context, percent float64, message string) {
+	if r, ok := ctx.Value(reporterContextKey).(Reporter); ok {
+		r(Event{Percent: percent, Message: message})
+	}
+}
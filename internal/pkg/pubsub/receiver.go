@@ -0,0 +1,440 @@
+// Package pubsub receives the messages of a Pub/Sub subscription with flow
+// control: the messages are pulled only while the outstanding ones, pulled
+// but not yet acknowledged, stay under the configured bounds and the
+// workers handling them keep up, and their leases are extended while
+// they're handled, up to a maximum after which they expire and are
+// delivered again. The messages of an ordering key are handled one at a
+// time, in order, while the ones of other keys are handled in parallel.
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/gcp"
+)
+
+// The results of the messages, labeling the ack latency.
+const (
+	ResultAck     = "ack"
+	ResultNack    = "nack"
+	ResultExpired = "expired"
+)
+
+// The reasons of the pauses of the pulls, labeling the paused counter.
+const (
+	PausedMessages = "messages"
+	PausedBytes    = "bytes"
+	PausedWorkers  = "workers"
+)
+
+// Subscription pulls and acknowledges messages, e.g. a gcp.Subscription.
+type Subscription interface {
+	Pull(ctx context.Context, max int) ([]gcp.Message, error)
+	Acknowledge(ctx context.Context, ackIDs []string) error
+	ModifyAckDeadline(ctx context.Context, ackIDs []string, seconds int) error
+}
+
+// Key returns the ordering key of a message, "" for the messages handled in
+// any order.
+type Key func(m gcp.Message) string
+
+// OrderingKey is the Key of the ordering keys the messages were published
+// with.
+func OrderingKey(m gcp.Message) string {
+	return m.OrderingKey
+}
+
+// AttributeKey returns the Key of the attribute name of the messages, for
+// the messages published without ordering keys.
+func AttributeKey(name string) Key {
+	return func(m gcp.Message) string {
+		return m.Attributes[name]
+	}
+}
+
+// errSkipped is the error of the messages of a key not handled after one of
+// them failed, see blocked.
+var errSkipped = errors.New("an earlier message of the ordering key failed")
+
+// Handler handles a message, acknowledged when it returns nil, else made
+// available for redelivery at once.
+type Handler func(ctx context.Context, m gcp.Message) error
+
+// FlowControl bounds the messages a Receiver handles at once.
+type FlowControl struct {
+	// MaxOutstandingMessages and MaxOutstandingBytes bound the messages
+	// pulled but not yet acknowledged. A message larger than the bytes is
+	// still pulled when no other is outstanding.
+	MaxOutstandingMessages int
+	MaxOutstandingBytes    int
+	// Workers is the number of messages handled at once. The pulls pause
+	// when the pool saturates, every worker having a message waiting for
+	// it on top of the one it handles.
+	Workers int
+	// AckDeadline is the lease of the messages, extended once two thirds
+	// of it elapsed, between 10s and 10m.
+	AckDeadline time.Duration
+	// MaxExtension bounds the time a message is leased for. The messages
+	// not handled by then expire, and are delivered again.
+	MaxExtension time.Duration
+}
+
+// DefaultFlowControl is the flow control of the Pub/Sub client libraries.
+var DefaultFlowControl = FlowControl{
+	MaxOutstandingMessages: 1000,
+	MaxOutstandingBytes:    1 << 30,
+	Workers:                10,
+	AckDeadline:            time.Minute,
+	MaxExtension:           time.Hour,
+}
+
+// Metrics are the metrics of a Receiver.
+type Metrics struct {
+	// AckLatency observes the seconds from the pull of the messages to
+	// their acknowledgement, labeled by result.
+	AckLatency metrics.Histogram
+	// Expired counts the messages whose lease expired before they were
+	// handled.
+	Expired metrics.Counter
+	// Paused counts the pauses of the pulls, labeled by reason.
+	Paused metrics.Counter
+	// Outstanding is the messages and bytes outstanding, labeled by unit.
+	Outstanding metrics.Gauge
+}
+
+// lease is an outstanding message.
+type lease struct {
+	received time.Time
+	size     int
+	expired  bool
+}
+
+// failure is the message of a key which failed.
+type failure struct {
+	id string
+	at time.Time
+}
+
+// Receiver receives the messages of a subscription with flow control.
+type Receiver struct {
+	sub    Subscription
+	fc     FlowControl
+	m      Metrics
+	key    Key
+	logger log.Logger
+
+	mu     sync.Mutex
+	leases map[string]*lease
+	// queued holds the messages of the keys being handled, waiting for
+	// the ones before them.
+	queued   map[string][]gcp.Message
+	failed   map[string]failure
+	bytes    int
+	busy     int
+	released chan struct{}
+}
+
+// NewReceiver returns a Receiver of sub, handling its messages under fc,
+// the ones of a key of key in order.
+func NewReceiver(sub Subscription, fc FlowControl, key Key, m Metrics, logger log.Logger) *Receiver {
+	// Pub/Sub only accepts deadlines between 10s and 10m.
+	if fc.AckDeadline < 10*time.Second {
+		fc.AckDeadline = 10 * time.Second
+	}
+	if fc.AckDeadline > 10*time.Minute {
+		fc.AckDeadline = 10 * time.Minute
+	}
+	if fc.Workers < 1 {
+		fc.Workers = 1
+	}
+	if fc.MaxOutstandingMessages < 1 {
+		fc.MaxOutstandingMessages = 1
+	}
+	return &Receiver{
+		sub:      sub,
+		fc:       fc,
+		m:        m,
+		logger:   logger,
+		key:      key,
+		leases:   map[string]*lease{},
+		queued:   map[string][]gcp.Message{},
+		failed:   map[string]failure{},
+		released: make(chan struct{}, 1),
+	}
+}
+
+// Receive pulls the messages and handles them with h until ctx is done,
+// then lets the workers finish the messages they handle, and makes the
+// ones waiting for them available for redelivery. h is given up to the
+// maximum extension to handle a message.
+func (r *Receiver) Receive(ctx context.Context, h Handler) error {
+	jobs := make(chan gcp.Message, r.fc.MaxOutstandingMessages)
+	var wg sync.WaitGroup
+	for i := 0; i < r.fc.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := range jobs {
+				r.handleKey(ctx, h, m)
+			}
+		}()
+	}
+	extending, stopExtending := context.WithCancel(context.Background())
+	go r.extend(extending)
+
+	for {
+		n, ok := r.wait(ctx)
+		if !ok {
+			break
+		}
+		msgs, err := r.sub.Pull(ctx, n)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			level.Warn(r.logger).Log("pubsub", "pull", "err", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		if len(msgs) == 0 {
+			continue
+		}
+		r.lease(msgs)
+		for _, m := range msgs {
+			if r.enqueue(m) {
+				jobs <- m
+			}
+		}
+	}
+
+	close(jobs)
+	wg.Wait()
+	stopExtending()
+	return nil
+}
+
+// wait waits for the flow control to let messages be pulled, and returns
+// how many, or false once ctx is done.
+func (r *Receiver) wait(ctx context.Context) (int, bool) {
+	paused := ""
+	for {
+		r.mu.Lock()
+		reason := r.saturated()
+		n := r.fc.MaxOutstandingMessages - len(r.leases)
+		r.mu.Unlock()
+		if reason == "" {
+			if paused != "" {
+				level.Debug(r.logger).Log("pubsub", "resumed")
+			}
+			return n, true
+		}
+		if reason != paused {
+			paused = reason
+			r.m.Paused.With("reason", reason).Add(1)
+			level.Debug(r.logger).Log("pubsub", "paused", "reason", reason)
+		}
+		select {
+		case <-ctx.Done():
+			return 0, false
+		case <-r.released:
+		}
+	}
+}
+
+// saturated returns the reason the pulls pause, or "" when they don't.
+func (r *Receiver) saturated() string {
+	switch {
+	case len(r.leases) >= r.fc.MaxOutstandingMessages:
+		return PausedMessages
+	case r.fc.MaxOutstandingBytes > 0 && len(r.leases) > 0 && r.bytes >= r.fc.MaxOutstandingBytes:
+		return PausedBytes
+	case r.busy >= r.fc.Workers && len(r.leases)-r.busy >= r.fc.Workers:
+		return PausedWorkers
+	}
+	return ""
+}
+
+// lease records msgs as outstanding and leases them for the ack deadline.
+func (r *Receiver) lease(msgs []gcp.Message) {
+	now := time.Now()
+	ids := make([]string, len(msgs))
+	r.mu.Lock()
+	for i, m := range msgs {
+		ids[i] = m.AckID
+		r.leases[m.AckID] = &lease{received: now, size: len(m.Data)}
+		r.bytes += len(m.Data)
+	}
+	r.observe()
+	r.mu.Unlock()
+	r.modify(ids, r.fc.AckDeadline)
+}
+
+// extend extends the leases of the outstanding messages until ctx is done,
+// letting the ones outliving the maximum extension expire.
+func (r *Receiver) extend(ctx context.Context) {
+	t := time.NewTicker(r.fc.AckDeadline * 2 / 3)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		var ids []string
+		now := time.Now()
+		r.mu.Lock()
+		for id, l := range r.leases {
+			switch {
+			case l.expired:
+			case now.Sub(l.received) >= r.fc.MaxExtension:
+				l.expired = true
+				r.m.Expired.Add(1)
+			default:
+				ids = append(ids, id)
+			}
+		}
+		r.mu.Unlock()
+		if len(ids) > 0 {
+			r.modify(ids, r.fc.AckDeadline)
+		}
+	}
+}
+
+// enqueue queues m behind the messages of its key being handled, and
+// returns false when it did.
+func (r *Receiver) enqueue(m gcp.Message) bool {
+	k := r.key(m)
+	if k == "" {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if q, handling := r.queued[k]; handling {
+		r.queued[k] = append(q, m)
+		return false
+	}
+	r.queued[k] = nil
+	return true
+}
+
+// handleKey handles m, then the messages of its key queued meanwhile, in
+// order, see blocked.
+func (r *Receiver) handleKey(ctx context.Context, h Handler, m gcp.Message) {
+	k := r.key(m)
+	if k == "" {
+		r.handle(ctx, h, m)
+		return
+	}
+	for {
+		if r.blocked(k, m) {
+			r.handle(ctx, nil, m)
+		} else if err := r.handle(ctx, h, m); err != nil && ctx.Err() == nil {
+			r.mu.Lock()
+			r.failed[k] = failure{id: m.ID, at: time.Now()}
+			r.mu.Unlock()
+		}
+
+		r.mu.Lock()
+		q := r.queued[k]
+		if len(q) == 0 {
+			delete(r.queued, k)
+			r.mu.Unlock()
+			return
+		}
+		m, r.queued[k] = q[0], q[1:]
+		r.mu.Unlock()
+	}
+}
+
+// blocked reports whether m of the key k is to be delivered again without
+// being handled: once a message of a key failed, the ones after it are,
+// until it's delivered again, or for the ack deadline at most.
+func (r *Receiver) blocked(k string, m gcp.Message) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, ok := r.failed[k]
+	if !ok {
+		return false
+	}
+	if m.ID == f.id || time.Since(f.at) >= r.fc.AckDeadline {
+		delete(r.failed, k)
+		return false
+	}
+	return true
+}
+
+// handle handles m with h, unless ctx is done or h is nil, and
+// acknowledges it. It returns the error of h.
+func (r *Receiver) handle(ctx context.Context, h Handler, m gcp.Message) error {
+	err := ctx.Err()
+	if err == nil && h == nil {
+		err = errSkipped
+	}
+	if err == nil {
+		r.mu.Lock()
+		r.busy++
+		received := r.leases[m.AckID].received
+		r.mu.Unlock()
+
+		hctx, cancel := context.WithDeadline(context.Background(), received.Add(r.fc.MaxExtension))
+		err = h(hctx, m)
+		cancel()
+
+		r.mu.Lock()
+		r.busy--
+		r.mu.Unlock()
+	}
+
+	r.mu.Lock()
+	l := r.leases[m.AckID]
+	delete(r.leases, m.AckID)
+	r.bytes -= l.size
+	r.observe()
+	r.mu.Unlock()
+	select {
+	case r.released <- struct{}{}:
+	default:
+	}
+
+	result := ResultAck
+	switch {
+	case l.expired:
+		result = ResultExpired
+	case err != nil:
+		result = ResultNack
+		r.modify([]string{m.AckID}, 0)
+	default:
+		actx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := r.sub.Acknowledge(actx, []string{m.AckID}); err != nil {
+			level.Warn(r.logger).Log("pubsub", "acknowledge", "message", m.ID, "err", err)
+		}
+		cancel()
+	}
+	r.m.AckLatency.With("result", result).Observe(time.Since(l.received).Seconds())
+	return err
+}
+
+// modify sets the deadline of the messages of ids to d from now.
+func (r *Receiver) modify(ids []string, d time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := r.sub.ModifyAckDeadline(ctx, ids, int(d/time.Second)); err != nil {
+		level.Warn(r.logger).Log("pubsub", "modify_ack_deadline", "messages", len(ids), "err", err)
+	}
+}
+
+// observe sets the outstanding gauges, with r.mu held.
+func (r *Receiver) observe() {
+	r.m.Outstanding.With("unit", "messages").Set(float64(len(r.leases)))
+	r.m.Outstanding.With("unit", "bytes").Set(float64(r.bytes))
+}
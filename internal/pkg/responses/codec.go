@@ -0,0 +1,123 @@
+package responses
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// JSONContentType is the media type of the default codec.
+const JSONContentType = "application/json"
+
+// Codec encodes response bodies in a media type.
+type Codec interface {
+	// ContentType is the media type of the encoding, e.g. "application/xml".
+	ContentType() string
+	// Encode writes the encoding of v to w.
+	Encode(w io.Writer, v interface{}) error
+}
+
+var codecs = struct {
+	sync.RWMutex
+	byType map[string]Codec
+	order  []string
+}{byType: map[string]Codec{}}
+
+// RegisterCodec makes c negotiable, replacing the codec of the same media
+// type. The JSON, XML and MessagePack codecs are registered by default.
+func RegisterCodec(c Codec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	if _, ok := codecs.byType[c.ContentType()]; !ok {
+		codecs.order = append(codecs.order, c.ContentType())
+	}
+	codecs.byType[c.ContentType()] = c
+}
+
+// ContentTypes returns the media types of the codecs registered, in the
+// order registered.
+func ContentTypes() []string {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	return append([]string(nil), codecs.order...)
+}
+
+// Negotiate returns the codec of the media type of accept, an Accept header,
+// that the caller prefers, JSON when it prefers none of the codecs.
+func Negotiate(accept string) Codec {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	json := codecs.byType[JSONContentType]
+
+	type choice struct {
+		codec Codec
+		q     float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil || q <= 0 {
+				continue
+			}
+		}
+		switch c, ok := codecs.byType[mediaType]; {
+		case ok:
+			choices = append(choices, choice{c, q})
+		case mediaType == "*/*" || mediaType == "application/*":
+			choices = append(choices, choice{json, q})
+		}
+	}
+	if len(choices) == 0 {
+		return json
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].q > choices[j].q })
+	return choices[0].codec
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string { return JSONContentType }
+
+func (jsonCodec) Encode(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+func init() {
+	RegisterCodec(jsonCodec{})
+	RegisterCodec(xmlCodec{})
+	RegisterCodec(msgpackCodec{})
+}
+
+// tree returns the JSON encoding of v decoded as generic JSON values,
+// numbers as json.Number, for the codecs writing any value.
+func tree(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var t interface{}
+	err = dec.Decode(&t)
+	return t, err
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,129 @@
+package responses
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// CSVContentType is the media type of the CSV writer.
+const CSVContentType = "text/csv"
+
+// utf8BOM makes spreadsheets read the CSV as UTF-8 rather than in the
+// encoding of the locale.
+const utf8BOM = "\ufeff"
+
+// CSVWriter writes values as the rows of a CSV table, a column per field of
+// their JSON encoding, the header row first. Rows are written as they come,
+// for tables streamed from an iterator. The fields are quoted as RFC 4180
+// has it, nested values written as JSON. Text fields a spreadsheet would take
+// for a formula, starting with =, +, -, @, a tab or a carriage return, are
+// prefixed with a single quote, so opening the table runs none.
+type CSVWriter struct {
+	w       io.Writer
+	csv     *csv.Writer
+	columns []string
+	bom     bool
+	started bool
+}
+
+// NewCSVWriter returns a CSVWriter of the columns, the JSON keys of the
+// fields, dot separated for nested ones, e.g. "page.limit", writing the
+// UTF-8 BOM first when bom.
+func NewCSVWriter(w io.Writer, columns []string, bom bool) *CSVWriter {
+	return &CSVWriter{w: w, csv: csv.NewWriter(w), columns: columns, bom: bom}
+}
+
+// start writes the BOM and the header row once.
+func (cw *CSVWriter) start() error {
+	if cw.started {
+		return nil
+	}
+	cw.started = true
+	if cw.bom {
+		if _, err := io.WriteString(cw.w, utf8BOM); err != nil {
+			return err
+		}
+	}
+	return cw.csv.Write(cw.columns)
+}
+
+// Write writes the row of v.
+func (cw *CSVWriter) Write(v interface{}) error {
+	if err := cw.start(); err != nil {
+		return err
+	}
+	t, err := tree(v)
+	if err != nil {
+		return err
+	}
+	row := make([]string, len(cw.columns))
+	for i, c := range cw.columns {
+		if row[i], err = csvField(lookup(t, c)); err != nil {
+			return err
+		}
+	}
+	return cw.csv.Write(row)
+}
+
+// WriteRecord writes a row of text fields, such as a trailing error row.
+func (cw *CSVWriter) WriteRecord(fields ...string) error {
+	if err := cw.start(); err != nil {
+		return err
+	}
+	row := make([]string, len(fields))
+	for i, f := range fields {
+		row[i] = defuse(f)
+	}
+	return cw.csv.Write(row)
+}
+
+// Flush writes the rows buffered, and the header row of an empty table.
+func (cw *CSVWriter) Flush() error {
+	if err := cw.start(); err != nil {
+		return err
+	}
+	cw.csv.Flush()
+	return cw.csv.Error()
+}
+
+// lookup returns the field of the JSON value t at the dot separated path,
+// nil when it has none.
+func lookup(t interface{}, path string) interface{} {
+	for _, k := range strings.Split(path, ".") {
+		m, ok := t.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		t = m[k]
+	}
+	return t
+}
+
+func csvField(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return defuse(v), nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		if v {
+			return "true", nil
+		}
+		return "false", nil
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// defuse prefixes the text field s with a single quote when a spreadsheet
+// would take it for a formula, see OWASP's CSV injection.
+func defuse(s string) string {
+	if s != "" && strings.IndexByte("=+-@\t\r", s[0]) >= 0 {
+		return "'" + s
+	}
+	return s
+}
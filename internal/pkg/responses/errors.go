@@ -9,9 +9,26 @@ type ErrorWrapper struct {
 }
 
 type ErrorResItem struct {
-	Code    int             `json:"code"`
-	Message string          `json:"message"`
-	Errors  []errors.Errors `json:"errors"`
+	Code      int             `json:"code"`
+	ErrorCode string          `json:"errorCode,omitempty"`
+	Message   string          `json:"message"`
+	Errors    []errors.Errors `json:"errors"`
+	// Timestamp is when the error occurred, RFC 3339 in UTC.
+	Timestamp string `json:"timestamp,omitempty"`
+	// TraceID is the trace of the failed request, to find it with.
+	TraceID string `json:"trace_id,omitempty"`
+	// HelpURL documents ErrorCode, see errors.HelpURL.
+	HelpURL string `json:"help_url,omitempty"`
+	// RetryAfter is the number of seconds to wait before retrying, as the
+	// Retry-After header says, when the error tells.
+	RetryAfter int `json:"retry_after,omitempty"`
+	// Reference identifies the server error in the logs of the server,
+	// for users to report it to support.
+	Reference string `json:"reference,omitempty"`
+	// StackID and Stack tell where the error was wrapped, when the
+	// verbosity is full, see errors.StackID.
+	StackID string   `json:"stack_id,omitempty"`
+	Stack   []string `json:"stack,omitempty"`
 }
 
 type ErrorRes struct {
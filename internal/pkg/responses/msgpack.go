@@ -0,0 +1,125 @@
+package responses
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+)
+
+// MsgpackContentType is the media type of the MessagePack codec.
+const MsgpackContentType = "application/msgpack"
+
+// msgpackCodec writes the JSON encoding of the responses as MessagePack,
+// the integers kept exact. Map keys are written in order.
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string { return MsgpackContentType }
+
+func (msgpackCodec) Encode(w io.Writer, v interface{}) error {
+	t, err := tree(v)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	if err := writeMsgpack(bw, t); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func writeMsgpack(w *bufio.Writer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		return w.WriteByte(0xc0)
+	case bool:
+		if v {
+			return w.WriteByte(0xc3)
+		}
+		return w.WriteByte(0xc2)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return writeMsgpackInt(w, i)
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		return writeMsgpackHeader(w, 0xcb, 8, math.Float64bits(f))
+	case string:
+		if err := writeMsgpackLen(w, len(v), 0xa0, 32, 0xd9, 0xda, 0xdb); err != nil {
+			return err
+		}
+		_, err := w.WriteString(v)
+		return err
+	case []interface{}:
+		if err := writeMsgpackLen(w, len(v), 0x90, 16, 0, 0xdc, 0xdd); err != nil {
+			return err
+		}
+		for _, e := range v {
+			if err := writeMsgpack(w, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		if err := writeMsgpackLen(w, len(v), 0x80, 16, 0, 0xde, 0xdf); err != nil {
+			return err
+		}
+		for _, k := range sortedKeys(v) {
+			if err := writeMsgpack(w, k); err != nil {
+				return err
+			}
+			if err := writeMsgpack(w, v[k]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+// writeMsgpackInt writes i in its shortest encoding.
+func writeMsgpackInt(w *bufio.Writer, i int64) error {
+	switch {
+	case i >= 0 && i <= 0x7f:
+		return w.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		return w.WriteByte(byte(int8(i)))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		return writeMsgpackHeader(w, 0xd0, 1, uint64(uint8(int8(i))))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		return writeMsgpackHeader(w, 0xd1, 2, uint64(uint16(int16(i))))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		return writeMsgpackHeader(w, 0xd2, 4, uint64(uint32(int32(i))))
+	}
+	return writeMsgpackHeader(w, 0xd3, 8, uint64(i))
+}
+
+// writeMsgpackLen writes the header of a string, array or map of n
+// elements: fix|n below fixMax, else the 8 bit (if any), 16 or 32 bit
+// length forms.
+func writeMsgpackLen(w *bufio.Writer, n int, fix byte, fixMax int, b8, b16, b32 byte) error {
+	switch {
+	case n < fixMax:
+		return w.WriteByte(fix | byte(n))
+	case b8 != 0 && n <= math.MaxUint8:
+		return writeMsgpackHeader(w, b8, 1, uint64(n))
+	case n <= math.MaxUint16:
+		return writeMsgpackHeader(w, b16, 2, uint64(n))
+	}
+	return writeMsgpackHeader(w, b32, 4, uint64(n))
+}
+
+// writeMsgpackHeader writes the type byte t followed by the size low bytes
+// of v, big endian.
+func writeMsgpackHeader(w *bufio.Writer, t byte, size int, v uint64) error {
+	if err := w.WriteByte(t); err != nil {
+		return err
+	}
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	_, err := w.Write(b[8-size:])
+	return err
+}
@@ -3,12 +3,27 @@ package responses
 type DataRes struct {
 	APIVersion string      `json:"apiVersion"`
 	Data       interface{} `json:"data"`
+	Meta       *Meta       `json:"meta,omitempty"`
+}
+
+// Meta describes the request a response answers, when the transports
+// envelope their responses.
+type Meta struct {
+	RequestID string `json:"request_id,omitempty"`
+	Duration  string `json:"duration"`
+	Version   string `json:"version"`
 }
 
 type Responser interface {
 	Response() interface{}
 }
 
+// Unenveloped is implemented by the responses opting out of the envelope:
+// they're written as is, or as their Response when they're Responsers.
+type Unenveloped interface {
+	Unenveloped()
+}
+
 type Paging struct {
 	CurrentItemCount int64 `json:"currentItemCount"`
 	ItemsPage        int64 `json:"itemsPage"`
@@ -0,0 +1,70 @@
+package responses
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+)
+
+// XMLContentType is the media type of the XML codec.
+const XMLContentType = "application/xml"
+
+// xmlCodec writes the JSON encoding of the responses as XML: a response
+// element holding an element per field, named after its JSON key, and an
+// item element per array element.
+type xmlCodec struct{}
+
+func (xmlCodec) ContentType() string { return XMLContentType }
+
+func (xmlCodec) Encode(w io.Writer, v interface{}) error {
+	t, err := tree(v)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	if err := writeXML(enc, "response", t); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+func writeXML(enc *xml.Encoder, name string, v interface{}) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, k := range sortedKeys(v) {
+			if err := writeXML(enc, k, v[k]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, e := range v {
+			if err := writeXML(enc, "item", e); err != nil {
+				return err
+			}
+		}
+	case string:
+		if err := enc.EncodeToken(xml.CharData(v)); err != nil {
+			return err
+		}
+	case json.Number:
+		if err := enc.EncodeToken(xml.CharData(v)); err != nil {
+			return err
+		}
+	case bool:
+		s := "false"
+		if v {
+			s = "true"
+		}
+		if err := enc.EncodeToken(xml.CharData(s)); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
@@ -0,0 +1,34 @@
+package router
+
+import "net/http"
+
+// Group registers routes under a common path prefix, such as an API version.
+type Group struct {
+	rt     *Router
+	prefix string
+	wrap   func(http.Handler) http.Handler
+}
+
+// Group returns a Group registering routes under prefix. wrap, when not nil,
+// wraps every handler of the group, e.g. with the headers of its version.
+func (rt *Router) Group(prefix string, wrap func(http.Handler) http.Handler) *Group {
+	return &Group{rt: rt, prefix: prefix, wrap: wrap}
+}
+
+// Handle registers h for method and the prefix followed by p.
+func (g *Group) Handle(method, p string, h http.Handler) {
+	if g.wrap != nil {
+		h = g.wrap(h)
+	}
+	g.rt.Handle(method, g.prefix+p, h)
+}
+
+// Get registers h for GET requests to the prefix followed by p.
+func (g *Group) Get(p string, h http.Handler) {
+	g.Handle(http.MethodGet, p, h)
+}
+
+// Post registers h for POST requests to the prefix followed by p.
+func (g *Group) Post(p string, h http.Handler) {
+	g.Handle(http.MethodPost, p, h)
+}
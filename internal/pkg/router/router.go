@@ -0,0 +1,85 @@
+// Package router dispatches requests over a fixed set of static routes. The
+// route table is precomputed when the routes are registered, so a lookup is
+// one map access and a scan of the few methods of the path, without
+// allocations, pattern matching or reflection per request.
+package router
+
+import (
+	"net/http"
+	"strings"
+)
+
+type route struct {
+	method  string
+	handler http.Handler
+}
+
+// path holds the routes of one path and the precomputed Allow header value.
+type path struct {
+	routes []route
+	allow  []string
+}
+
+// Router is an http.Handler dispatching on the exact method and path. Paths
+// are static; use a subtree handler such as http.ServeMux for anything else.
+// Routes must be registered before serving.
+type Router struct {
+	paths map[string]*path
+
+	// NotFound handles requests to unknown paths, http.NotFound by default.
+	NotFound http.Handler
+}
+
+// New returns an empty Router.
+func New() *Router {
+	return &Router{paths: map[string]*path{}, NotFound: http.NotFoundHandler()}
+}
+
+// Handle registers h for method and p, replacing any previous handler.
+func (rt *Router) Handle(method, p string, h http.Handler) {
+	entry, ok := rt.paths[p]
+	if !ok {
+		entry = &path{}
+		rt.paths[p] = entry
+	}
+	for i := range entry.routes {
+		if entry.routes[i].method == method {
+			entry.routes[i].handler = h
+			return
+		}
+	}
+	entry.routes = append(entry.routes, route{method: method, handler: h})
+	methods := make([]string, len(entry.routes))
+	for i, r := range entry.routes {
+		methods[i] = r.method
+	}
+	entry.allow = []string{strings.Join(methods, ", ")}
+}
+
+// Get registers h for GET requests to p.
+func (rt *Router) Get(p string, h http.Handler) {
+	rt.Handle(http.MethodGet, p, h)
+}
+
+// Post registers h for POST requests to p.
+func (rt *Router) Post(p string, h http.Handler) {
+	rt.Handle(http.MethodPost, p, h)
+}
+
+// ServeHTTP implements http.Handler. Known paths requested with another
+// method answer 405 Method Not Allowed.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	entry, ok := rt.paths[r.URL.Path]
+	if !ok {
+		rt.NotFound.ServeHTTP(w, r)
+		return
+	}
+	for i := range entry.routes {
+		if entry.routes[i].method == r.Method {
+			entry.routes[i].handler.ServeHTTP(w, r)
+			return
+		}
+	}
+	w.Header()["Allow"] = entry.allow
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
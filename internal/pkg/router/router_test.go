@@ -0,0 +1,181 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-zoo/bone"
+)
+
+func named(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Handler", name)
+	})
+}
+
+func TestRouter(t *testing.T) {
+	rt := New()
+	rt.Post("/sum", named("sum"))
+	rt.Get("/sum", named("sum-get"))
+	rt.Post("/concat", named("concat"))
+	rt.Post("/concat", named("concat-replaced"))
+	rt.Get("/history", named("history"))
+
+	cases := []struct {
+		name    string
+		method  string
+		path    string
+		status  int
+		handler string
+		allow   string
+	}{
+		{"post", http.MethodPost, "/sum", http.StatusOK, "sum", ""},
+		{"get same path", http.MethodGet, "/sum", http.StatusOK, "sum-get", ""},
+		{"replaced", http.MethodPost, "/concat", http.StatusOK, "concat-replaced", ""},
+		{"get", http.MethodGet, "/history", http.StatusOK, "history", ""},
+		{"method not allowed", http.MethodDelete, "/sum", http.StatusMethodNotAllowed, "", "POST, GET"},
+		{"replacement keeps allow", http.MethodGet, "/concat", http.StatusMethodNotAllowed, "", "POST"},
+		{"unknown path", http.MethodPost, "/nope", http.StatusNotFound, "", ""},
+		{"trailing slash", http.MethodPost, "/sum/", http.StatusNotFound, "", ""},
+		{"case sensitive", http.MethodPost, "/SUM", http.StatusNotFound, "", ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			rt.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+			if w.Code != tc.status {
+				t.Errorf("status = %d, want %d", w.Code, tc.status)
+			}
+			if got := w.Header().Get("X-Handler"); got != tc.handler {
+				t.Errorf("handler = %q, want %q", got, tc.handler)
+			}
+			if got := w.Header().Get("Allow"); got != tc.allow {
+				t.Errorf("Allow = %q, want %q", got, tc.allow)
+			}
+		})
+	}
+}
+
+func TestRouterNotFound(t *testing.T) {
+	rt := New()
+	rt.Get("/history", named("history"))
+	rt.NotFound = named("not-found")
+
+	w := httptest.NewRecorder()
+	rt.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/other", nil))
+	if got := w.Header().Get("X-Handler"); got != "not-found" {
+		t.Errorf("handler = %q, want not-found", got)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	rt := New()
+	v1 := rt.Group("/api/v1/add", Version{Name: "v1", Deprecated: true, Successor: "/api/v2/add"}.Headers)
+	v1.Post("/sum", named("v1-sum"))
+	v2 := rt.Group("/api/v2/add", nil)
+	v2.Post("/sum", named("v2-sum"))
+
+	cases := []struct {
+		path        string
+		handler     string
+		deprecation string
+	}{
+		{"/api/v1/add/sum", "v1-sum", "true"},
+		{"/api/v2/add/sum", "v2-sum", ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			rt.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tc.path, nil))
+			if got := w.Header().Get("X-Handler"); got != tc.handler {
+				t.Errorf("handler = %q, want %q", got, tc.handler)
+			}
+			if got := w.Header().Get("Deprecation"); got != tc.deprecation {
+				t.Errorf("Deprecation = %q, want %q", got, tc.deprecation)
+			}
+		})
+	}
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	known := []string{"v1", "v2"}
+	cases := []struct {
+		accept string
+		want   string
+	}{
+		{"", "v1"},
+		{"application/json", "v1"},
+		{"application/json; version=v2", "v2"},
+		{"application/json; version=2", "v2"},
+		{"application/vnd.example.v2+json", "v2"},
+		{"application/vnd.example.v3+json", "v1"},
+		{"text/html, application/json; version=v2", "v2"},
+		{"invalid;;", "v1"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.accept, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept", tc.accept)
+			if got := NegotiateVersion(r, known, "v1"); got != tc.want {
+				t.Errorf("NegotiateVersion(%q) = %q, want %q", tc.accept, got, tc.want)
+			}
+		})
+	}
+}
+
+// discard is a ResponseWriter allocating nothing, so the allocations
+// measured are the router's.
+type discard struct{ header http.Header }
+
+func (d discard) Header() http.Header       { return d.header }
+func (discard) Write(b []byte) (int, error) { return len(b), nil }
+func (discard) WriteHeader(statusCode int)  {}
+
+func TestRouterAllocs(t *testing.T) {
+	rt := New()
+	rt.Post("/sum", http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	r := httptest.NewRequest(http.MethodPost, "/sum", nil)
+	w := discard{header: http.Header{}}
+	if n := testing.AllocsPerRun(100, func() { rt.ServeHTTP(w, r) }); n != 0 {
+		t.Errorf("ServeHTTP allocates %v times, want 0", n)
+	}
+}
+
+// routes are the add routes, the last one being looked up.
+var routes = []string{"/sum", "/concat", "/history", "/batch", "/history/export", "/capabilities", "/health", "/ready"}
+
+func BenchmarkRouter(b *testing.B) {
+	noop := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	rt := New()
+	mux := http.NewServeMux()
+	bm := bone.New()
+	cm := chi.NewRouter()
+	for _, p := range routes {
+		rt.Get(p, noop)
+		mux.Handle(p, noop)
+		bm.Get(p, noop)
+		cm.Get(p, noop)
+	}
+	handlers := []struct {
+		name string
+		h    http.Handler
+	}{
+		{"router", rt},
+		{"servemux", mux},
+		{"bone", bm},
+		{"chi", cm},
+	}
+	for _, h := range handlers {
+		b.Run(h.name, func(b *testing.B) {
+			r := httptest.NewRequest(http.MethodGet, routes[len(routes)-1], nil)
+			w := discard{header: http.Header{}}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				h.h.ServeHTTP(w, r)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package router
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Version describes an API version served under /<prefix>/<Name>/...
+type Version struct {
+	// Name is the path segment of the version, e.g. "v2".
+	Name string
+	// Deprecated versions answer with a Deprecation header.
+	Deprecated bool
+	// Sunset, when set, is announced in the Sunset header of deprecated
+	// versions as the date they stop being served.
+	Sunset time.Time
+	// Successor, when set, is linked from deprecated versions as their
+	// successor-version, e.g. "/api/v2/add".
+	Successor string
+}
+
+// Headers returns h answering with the deprecation headers of v, see
+// https://tools.ietf.org/html/draft-ietf-httpapi-deprecation-header and
+// RFC 8594. Current versions are returned as is.
+func (v Version) Headers(h http.Handler) http.Handler {
+	if !v.Deprecated {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		header.Set("Deprecation", "true")
+		if !v.Sunset.IsZero() {
+			header.Set("Sunset", v.Sunset.UTC().Format(http.TimeFormat))
+		}
+		if v.Successor != "" {
+			header.Add("Link", "<"+v.Successor+`>; rel="successor-version"`)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// NegotiateVersion returns the version requested by the Accept header of r,
+// either as a parameter, "application/json; version=v2", or as a vendor
+// media type, "application/vnd.example.v2+json", if it's one of known.
+// Otherwise it returns def.
+func NegotiateVersion(r *http.Request, known []string, def string) string {
+	isKnown := func(v string) bool {
+		for _, k := range known {
+			if k == v {
+				return true
+			}
+		}
+		return false
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		if v := params["version"]; v != "" {
+			if !strings.HasPrefix(v, "v") {
+				v = "v" + v
+			}
+			if isKnown(v) {
+				return v
+			}
+		}
+		if sub := mediaType[strings.IndexByte(mediaType, '/')+1:]; strings.HasPrefix(sub, "vnd.") {
+			sub = strings.TrimSuffix(sub, "+json")
+			if v := sub[strings.LastIndexByte(sub, '.')+1:]; isKnown(v) {
+				return v
+			}
+		}
+	}
+	return def
+}
+
+// Negotiated returns a handler serving the request with the handler of the
+// version negotiated by NegotiateVersion, the one of def by default, or 404
+// Not Found when def has none. It answers the unversioned paths kept for the clients predating versioning.
+func Negotiated(handlers map[string]http.Handler, def string) http.Handler {
+	known := make([]string, 0, len(handlers))
+	for v := range handlers {
+		known = append(known, v)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		h, ok := handlers[NegotiateVersion(r, known, def)]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,124 @@
+package saga
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/gcp"
+)
+
+// Kind is the Datastore kind of the states.
+const Kind = "SagaState"
+
+type datastoreStore struct {
+	ds *gcp.Datastore
+}
+
+// NewDatastoreStore returns a Store keeping the states in ds, keyed by run
+// ID. The saga and the status of the states are indexed, for Unfinished,
+// and for an operator to find the failed runs.
+func NewDatastoreStore(ds *gcp.Datastore) Store {
+	return &datastoreStore{ds: ds}
+}
+
+// stateProperties are the properties of a state entity.
+type stateProperties struct {
+	State struct {
+		BlobValue []byte `json:"blobValue"`
+	} `json:"state"`
+	UpdatedAt struct {
+		TimestampValue time.Time `json:"timestampValue"`
+	} `json:"updatedAt"`
+}
+
+func (s *datastoreStore) Load(ctx context.Context, id string) (*State, error) {
+	var p stateProperties
+	found, err := s.ds.Lookup(ctx, "", s.ds.Key(Kind, id), &p)
+	if err != nil {
+		return nil, errors.Wrap(ErrStore, err)
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+	var st State
+	if err := json.Unmarshal(p.State.BlobValue, &st); err != nil {
+		return nil, errors.Wrap(ErrStore, err)
+	}
+	return &st, nil
+}
+
+func (s *datastoreStore) Save(ctx context.Context, st *State) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	mutation := map[string]interface{}{"upsert": map[string]interface{}{
+		"key": s.ds.Key(Kind, st.ID),
+		"properties": map[string]interface{}{
+			"saga":      map[string]interface{}{"stringValue": st.Saga},
+			"status":    map[string]interface{}{"stringValue": string(st.Status)},
+			"state":     map[string]interface{}{"blobValue": base64.StdEncoding.EncodeToString(b), "excludeFromIndexes": true},
+			"updatedAt": map[string]interface{}{"timestampValue": st.UpdatedAt.UTC().Format(time.RFC3339Nano)},
+		},
+	}}
+	if err := s.ds.Commit(ctx, mutation); err != nil {
+		return errors.Wrap(ErrStore, err)
+	}
+	return nil
+}
+
+// Unfinished queries the runs of each unfinished status on the equalities
+// of saga and status alone, which the built-in indexes serve, and leaves
+// the recent ones out itself.
+func (s *datastoreStore) Unfinished(ctx context.Context, saga string, before time.Time) ([]string, error) {
+	var ids []string
+	for _, status := range []Status{Running, Compensating} {
+		query := map[string]interface{}{
+			"kind": []interface{}{map[string]string{"name": Kind}},
+			"filter": map[string]interface{}{"compositeFilter": map[string]interface{}{
+				"op": "AND",
+				"filters": []interface{}{
+					equal("saga", saga),
+					equal("status", string(status)),
+				},
+			}},
+		}
+		var resp struct {
+			Batch struct {
+				EntityResults []struct {
+					Entity struct {
+						Key struct {
+							Path []struct {
+								Name string `json:"name"`
+							} `json:"path"`
+						} `json:"key"`
+						Properties stateProperties `json:"properties"`
+					} `json:"entity"`
+				} `json:"entityResults"`
+			} `json:"batch"`
+		}
+		if err := s.ds.Call(ctx, "runQuery", map[string]interface{}{"partitionId": s.ds.Partition(), "query": query}, &resp); err != nil {
+			return nil, errors.Wrap(ErrStore, err)
+		}
+		for _, r := range resp.Batch.EntityResults {
+			e := r.Entity
+			if len(e.Key.Path) == 0 || !e.Properties.UpdatedAt.TimestampValue.Before(before) {
+				continue
+			}
+			ids = append(ids, e.Key.Path[len(e.Key.Path)-1].Name)
+		}
+	}
+	return ids, nil
+}
+
+// equal returns the filter of the property name equal to value.
+func equal(name, value string) map[string]interface{} {
+	return map[string]interface{}{"propertyFilter": map[string]interface{}{
+		"property": map[string]string{"name": name},
+		"op":       "EQUAL",
+		"value":    map[string]interface{}{"stringValue": value},
+	}}
+}
@@ -0,0 +1,62 @@
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryStore struct {
+	mu     sync.Mutex
+	states map[string][]byte
+}
+
+// NewMemoryStore returns a Store keeping the states in memory, for
+// development: the runs can't be resumed once the instance stops.
+func NewMemoryStore() Store {
+	return &memoryStore{states: map[string][]byte{}}
+}
+
+func (m *memoryStore) Load(_ context.Context, id string) (*State, error) {
+	m.mu.Lock()
+	b, ok := m.states[id]
+	m.mu.Unlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	var s State
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (m *memoryStore) Save(_ context.Context, s *State) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.states[s.ID] = b
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *memoryStore) Unfinished(_ context.Context, saga string, before time.Time) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ids []string
+	for id, b := range m.states {
+		var s State
+		if err := json.Unmarshal(b, &s); err != nil {
+			return nil, err
+		}
+		if s.Saga == saga && (s.Status == Running || s.Status == Compensating) && s.UpdatedAt.Before(before) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
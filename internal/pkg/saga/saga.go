@@ -0,0 +1,338 @@
+// Package saga runs workflows spanning several services as a sequence of
+// steps whose compensations undo the steps done when a later one fails,
+// e.g. the export deliveries of package delivery: write the export to the
+// download store, sign its download link, then notify it, deleting the
+// export should the notification fail:
+//
+//	s := saga.New("export-delivery", store, logger,
+//		saga.Step{Name: "export", Action: export, Compensate: remove, Attempts: 3, Backoff: time.Second},
+//		saga.Step{Name: "link", Action: sign, Compensate: revoke},
+//		saga.Step{Name: "notify", Action: notify, Attempts: 5, Backoff: time.Second},
+//	)
+//	state, err := s.Run(ctx, id, input)
+//
+// The state of a run is saved to a Store after every step, so that running
+// the saga again with the same ID resumes it where it stopped, e.g. from a
+// cron job resuming the runs of the instances that stopped, see
+// Store.Unfinished: a step may then run again, the actions and
+// compensations must be idempotent. A run is traced as one span, the
+// attempts of its steps as its children.
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+const instrumentationName = "github.com/cage1016/gokit-gae/internal/pkg/saga"
+
+// The attributes of the spans of the sagas.
+const (
+	AttributeSagaName    = attribute.Key("saga.name")
+	AttributeSagaID      = attribute.Key("saga.id")
+	AttributeSagaStep    = attribute.Key("saga.step")
+	AttributeSagaAttempt = attribute.Key("saga.attempt")
+)
+
+var (
+	// ErrAborted indicates a saga whose step failed, the steps done before
+	// it compensated.
+	ErrAborted = errors.Register(errors.KindAborted, errors.NewCoded("SAGA-001", "saga aborted"))
+
+	// ErrCompensationFailed indicates a saga whose step failed and whose
+	// compensation of a step done before it failed too, leaving it for an
+	// operator to resolve.
+	ErrCompensationFailed = errors.Register(errors.KindInternal, errors.NewCoded("SAGA-002", "saga compensation failed"))
+
+	// ErrNotFound indicates a state a Store doesn't have.
+	ErrNotFound = errors.New("saga state not found")
+
+	// ErrExists indicates a run started already.
+	ErrExists = errors.New("saga run exists")
+
+	// ErrStore indicates the saga store failed.
+	ErrStore = errors.New("saga store failed")
+)
+
+// Status is the status of a run of a saga.
+type Status string
+
+// The statuses of the runs.
+const (
+	// Running runs the steps, from State.Step.
+	Running Status = "running"
+	// Completed has done every step.
+	Completed Status = "completed"
+	// Compensating compensates the steps done before State.Step, which
+	// failed, in reverse order.
+	Compensating Status = "compensating"
+	// Compensated has compensated the steps done.
+	Compensated Status = "compensated"
+	// Failed failed to compensate a step.
+	Failed Status = "failed"
+)
+
+// State is the state of a run of a saga.
+type State struct {
+	ID     string `json:"id"`
+	Saga   string `json:"saga"`
+	Status Status `json:"status"`
+	// Step is the index of the next step to run, or of the failed step
+	// whose predecessors are compensated.
+	Step    int                        `json:"step"`
+	Input   json.RawMessage            `json:"input"`
+	Results map[string]json.RawMessage `json:"results"`
+	// Error is the error of the failed step.
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// DecodeInput decodes the input of the run into v.
+func (s *State) DecodeInput(v interface{}) error {
+	return json.Unmarshal(s.Input, v)
+}
+
+// DecodeResult decodes the response of the action of step into v.
+func (s *State) DecodeResult(step string, v interface{}) error {
+	b, ok := s.Results[step]
+	if !ok {
+		return fmt.Errorf("saga: no result of step %q", step)
+	}
+	return json.Unmarshal(b, v)
+}
+
+// Store keeps the states of the runs.
+type Store interface {
+	// Load returns the state of run id, failing with ErrNotFound when
+	// there is none.
+	Load(ctx context.Context, id string) (*State, error)
+	// Save saves s, replacing the state of its run.
+	Save(ctx context.Context, s *State) error
+	// Unfinished returns the IDs of the runs of saga running or
+	// compensating, last saved before before.
+	Unfinished(ctx context.Context, saga string, before time.Time) ([]string, error)
+}
+
+// Action does, or compensates, a step of a run with state s. The response of
+// an action is saved in the state, encoded as JSON, for the next steps.
+type Action func(ctx context.Context, s *State) (interface{}, error)
+
+// Endpoint returns the action calling e with the request built from the
+// state by request, failing as well when the response is an
+// endpoint.Failer that failed.
+func Endpoint(e endpoint.Endpoint, request func(s *State) (interface{}, error)) Action {
+	return func(ctx context.Context, s *State) (interface{}, error) {
+		req, err := request(s)
+		if err != nil {
+			return nil, err
+		}
+		response, err := e(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		if f, ok := response.(endpoint.Failer); ok && f.Failed() != nil {
+			return nil, f.Failed()
+		}
+		return response, nil
+	}
+}
+
+// Step is a step of a saga.
+type Step struct {
+	Name string
+	// Action does the step.
+	Action Action
+	// Compensate undoes the step when a later one fails; the step isn't
+	// compensated when nil.
+	Compensate Action
+	// Attempts is the number of times the action, and the compensation,
+	// are tried before giving up, 1 when 0.
+	Attempts int
+	// Backoff is the delay before the second attempt, doubled at every
+	// attempt after it.
+	Backoff time.Duration
+}
+
+// Saga is a workflow of steps.
+type Saga struct {
+	name   string
+	steps  []Step
+	store  Store
+	logger log.Logger
+}
+
+// New returns the saga name running steps, in order, saving its runs to
+// store. It panics when two steps share a name.
+func New(name string, store Store, logger log.Logger, steps ...Step) *Saga {
+	seen := map[string]bool{}
+	for _, st := range steps {
+		if seen[st.Name] {
+			panic(fmt.Sprintf("saga: step %q of %s defined twice", st.Name, name))
+		}
+		seen[st.Name] = true
+	}
+	return &Saga{name: name, steps: steps, store: store, logger: log.With(logger, "saga", name)}
+}
+
+// Start saves run id of the saga with input, encoded as JSON, for Run to
+// run it. It fails with ErrExists when run id is saved already.
+func (sg *Saga) Start(ctx context.Context, id string, input interface{}) (*State, error) {
+	_, err := sg.store.Load(ctx, id)
+	switch {
+	case err == nil:
+		return nil, ErrExists
+	case !errors.Contains(errors.Cast(err), ErrNotFound):
+		return nil, err
+	}
+	b, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	s := &State{ID: id, Saga: sg.name, Status: Running, Input: b, Results: map[string]json.RawMessage{}}
+	return s, sg.save(ctx, s)
+}
+
+// Run runs the saga as run id with input, encoded as JSON, or resumes run id
+// when its state is saved already, input then being ignored, and returns its
+// state. It fails with ErrAborted when a step failed and the steps done were
+// compensated, and with ErrCompensationFailed when a compensation failed
+// too. A run that ended returns its state, and its error, again.
+func (sg *Saga) Run(ctx context.Context, id string, input interface{}) (s *State, err error) {
+	ctx, span := otel.Tracer(instrumentationName).Start(ctx, "saga "+sg.name, trace.WithAttributes(AttributeSagaName.String(sg.name), AttributeSagaID.String(id)))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	s, err = sg.store.Load(ctx, id)
+	if err != nil && errors.Contains(errors.Cast(err), ErrNotFound) {
+		var b []byte
+		if b, err = json.Marshal(input); err != nil {
+			return nil, err
+		}
+		s = &State{ID: id, Saga: sg.name, Status: Running, Input: b, Results: map[string]json.RawMessage{}}
+		err = sg.save(ctx, s)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Status == Running {
+		if err := sg.forward(ctx, s); err != nil {
+			return s, err
+		}
+	}
+	if s.Status == Compensating {
+		if err := sg.backward(ctx, s); err != nil {
+			return s, err
+		}
+	}
+	switch s.Status {
+	case Compensated:
+		return s, errors.Wrap(ErrAborted, fmt.Errorf("%s", s.Error))
+	case Failed:
+		return s, errors.Wrap(ErrCompensationFailed, fmt.Errorf("%s", s.Error))
+	}
+	return s, nil
+}
+
+// forward runs the steps of s from s.Step, turning s to Compensating at the
+// first one failing. It returns the errors saving s.
+func (sg *Saga) forward(ctx context.Context, s *State) error {
+	for s.Step < len(sg.steps) {
+		st := sg.steps[s.Step]
+		response, err := sg.attempt(ctx, s, st, "action", st.Action)
+		if err == nil {
+			var b []byte
+			if b, err = json.Marshal(response); err == nil {
+				s.Results[st.Name] = b
+				s.Step++
+				if err := sg.save(ctx, s); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		level.Warn(sg.logger).Log("id", s.ID, "step", st.Name, "err", err)
+		s.Status, s.Error = Compensating, fmt.Sprintf("%s: %v", st.Name, err)
+		return sg.save(ctx, s)
+	}
+	s.Status = Completed
+	return sg.save(ctx, s)
+}
+
+// backward compensates the steps of s before s.Step, in reverse order,
+// turning s to Compensated, or to Failed at the first compensation failing.
+// It returns the errors saving s.
+func (sg *Saga) backward(ctx context.Context, s *State) error {
+	for s.Step > 0 {
+		st := sg.steps[s.Step-1]
+		if st.Compensate != nil {
+			if _, err := sg.attempt(ctx, s, st, "compensate", st.Compensate); err != nil {
+				level.Error(sg.logger).Log("id", s.ID, "step", st.Name, "compensate", "failed", "err", err)
+				s.Status, s.Error = Failed, fmt.Sprintf("%s; compensating %s: %v", s.Error, st.Name, err)
+				return sg.save(ctx, s)
+			}
+		}
+		s.Step--
+		if err := sg.save(ctx, s); err != nil {
+			return err
+		}
+	}
+	s.Status = Compensated
+	return sg.save(ctx, s)
+}
+
+// attempt runs the action, or the compensation, a of step st, up to its
+// attempts, each one in a span of its own.
+func (sg *Saga) attempt(ctx context.Context, s *State, st Step, phase string, a Action) (response interface{}, err error) {
+	attempts := st.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	backoff := st.Backoff
+	for i := 1; ; i++ {
+		sctx, span := otel.Tracer(instrumentationName).Start(ctx, "saga "+sg.name+"/"+st.Name+" "+phase, trace.WithAttributes(
+			AttributeSagaName.String(sg.name),
+			AttributeSagaID.String(s.ID),
+			AttributeSagaStep.String(st.Name),
+			AttributeSagaAttempt.Int(i),
+		))
+		response, err = a(sctx, s)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+		if err == nil || i == attempts {
+			return response, err
+		}
+		level.Warn(sg.logger).Log("id", s.ID, "step", st.Name, "phase", phase, "attempt", i, "err", err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (sg *Saga) save(ctx context.Context, s *State) error {
+	s.UpdatedAt = time.Now().UTC()
+	return sg.store.Save(ctx, s)
+}
@@ -0,0 +1,109 @@
+package saga
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// recorder records the actions and compensations run, failing the actions
+// of fail for their first failures attempts, every attempt when negative.
+type recorder struct {
+	calls    []string
+	fail     string
+	failures int
+}
+
+func (r *recorder) step(name string) Step {
+	return Step{
+		Name: name,
+		Action: func(ctx context.Context, s *State) (interface{}, error) {
+			r.calls = append(r.calls, name)
+			if name == r.fail && r.failures != 0 {
+				r.failures--
+				return nil, errors.New(name + " failed")
+			}
+			return name + " done", nil
+		},
+		Compensate: func(ctx context.Context, s *State) (interface{}, error) {
+			r.calls = append(r.calls, "undo "+name)
+			return nil, nil
+		},
+		Attempts: 3,
+		Backoff:  time.Millisecond,
+	}
+}
+
+func (r *recorder) saga(store Store) *Saga {
+	return New("test", store, log.NewNopLogger(), r.step("a"), r.step("b"), r.step("c"))
+}
+
+func TestRetry(t *testing.T) {
+	r := &recorder{fail: "b", failures: 2}
+	s, err := r.saga(NewMemoryStore()).Run(context.Background(), "1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "b", "b", "b", "c"}; !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("calls = %v, want %v", r.calls, want)
+	}
+	var res string
+	if s.Status != Completed || s.DecodeResult("c", &res) != nil || res != "c done" {
+		t.Errorf("state = %+v", s)
+	}
+}
+
+func TestCompensate(t *testing.T) {
+	r := &recorder{fail: "c", failures: -1}
+	s, err := r.saga(NewMemoryStore()).Run(context.Background(), "1", nil)
+	if err == nil || !errors.Contains(errors.Cast(err), ErrAborted) {
+		t.Fatalf("err = %v, want %v", err, ErrAborted)
+	}
+	if want := []string{"a", "b", "c", "c", "c", "undo b", "undo a"}; !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("calls = %v, want %v", r.calls, want)
+	}
+	if s.Status != Compensated || s.Step != 0 {
+		t.Errorf("state = %+v", s)
+	}
+}
+
+func TestResume(t *testing.T) {
+	store := NewMemoryStore()
+	r := &recorder{}
+	sg := r.saga(store)
+	s, err := sg.Start(context.Background(), "1", map[string]string{"k": "v"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the instance stopped once a was done
+	s.Step, s.Results["a"] = 1, []byte(`"a done"`)
+	if err := store.Save(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sg.Start(context.Background(), "1", nil); err != ErrExists {
+		t.Errorf("Start again err = %v, want %v", err, ErrExists)
+	}
+	ids, err := store.Unfinished(context.Background(), "test", time.Now().Add(time.Minute))
+	if err != nil || !reflect.DeepEqual(ids, []string{"1"}) {
+		t.Fatalf("Unfinished = %v, %v", ids, err)
+	}
+
+	if s, err = sg.Run(context.Background(), "1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"b", "c"}; !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("calls = %v, want %v", r.calls, want)
+	}
+	var in map[string]string
+	if s.Status != Completed || s.DecodeInput(&in) != nil || in["k"] != "v" {
+		t.Errorf("state = %+v", s)
+	}
+	if ids, _ := store.Unfinished(context.Background(), "test", time.Now().Add(time.Minute)); len(ids) != 0 {
+		t.Errorf("Unfinished = %v after completion", ids)
+	}
+}
@@ -0,0 +1,66 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// ErrInvalidCookie indicates a cookie that can't be decrypted or was tampered with.
+var ErrInvalidCookie = errors.NewCoded("SESSION-002", "invalid session cookie")
+
+// Codec seals cookie values with AES-GCM, so their contents are both secret
+// and tamper-proof. The cookie name is bound as additional data so a value
+// can't be moved to another cookie.
+type Codec struct {
+	aead cipher.AEAD
+}
+
+// NewCodec returns a Codec for a 16, 24 or 32 byte key.
+func NewCodec(key []byte) (*Codec, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Codec{aead: aead}, nil
+}
+
+// Encode seals v for the cookie called name.
+func (c *Codec) Encode(name string, v interface{}) (string, error) {
+	plain, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := c.aead.Seal(nonce, nonce, plain, []byte(name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Decode opens value of the cookie called name into v.
+func (c *Codec) Decode(name string, value string, v interface{}) error {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return ErrInvalidCookie
+	}
+	n := c.aead.NonceSize()
+	plain, err := c.aead.Open(nil, sealed[:n], sealed[n:], []byte(name))
+	if err != nil {
+		return ErrInvalidCookie
+	}
+	if err := json.Unmarshal(plain, v); err != nil {
+		return ErrInvalidCookie
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package session
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/gcp"
+)
+
+// Kind is the Datastore kind of the sessions, keyed by ID.
+const Kind = "Session"
+
+type datastoreStore struct {
+	ds *gcp.Datastore
+}
+
+// NewDatastoreStore returns a Store shared by the instances, keeping the
+// sessions in Datastore. The sessions of a principal are queried by
+// principal only, which the built-in indexes serve; the expired ones are
+// left to a TTL policy on the expiresAt property of the kind.
+func NewDatastoreStore(ds *gcp.Datastore) Store {
+	return &datastoreStore{ds: ds}
+}
+
+// sessionProperties are the properties of a session entity.
+type sessionProperties struct {
+	Principal struct {
+		StringValue string `json:"stringValue"`
+	} `json:"principal"`
+	Roles struct {
+		ArrayValue struct {
+			Values []struct {
+				StringValue string `json:"stringValue"`
+			} `json:"values"`
+		} `json:"arrayValue"`
+	} `json:"roles"`
+	CreatedAt struct {
+		TimestampValue time.Time `json:"timestampValue"`
+	} `json:"createdAt"`
+	ExpiresAt struct {
+		TimestampValue time.Time `json:"timestampValue"`
+	} `json:"expiresAt"`
+}
+
+func (p sessionProperties) session(id string) Session {
+	s := Session{
+		ID:        id,
+		Principal: p.Principal.StringValue,
+		CreatedAt: p.CreatedAt.TimestampValue,
+		ExpiresAt: p.ExpiresAt.TimestampValue,
+	}
+	for _, v := range p.Roles.ArrayValue.Values {
+		s.Roles = append(s.Roles, v.StringValue)
+	}
+	return s
+}
+
+func (ds *datastoreStore) Save(ctx context.Context, s Session) error {
+	roles := make([]interface{}, len(s.Roles))
+	for i, r := range s.Roles {
+		roles[i] = map[string]interface{}{"stringValue": r}
+	}
+	return ds.ds.Commit(ctx, map[string]interface{}{"upsert": map[string]interface{}{
+		"key": ds.ds.Key(Kind, s.ID),
+		"properties": map[string]interface{}{
+			"principal": map[string]interface{}{"stringValue": s.Principal},
+			"roles":     map[string]interface{}{"arrayValue": map[string]interface{}{"values": roles}, "excludeFromIndexes": true},
+			"createdAt": map[string]interface{}{"timestampValue": s.CreatedAt.UTC().Format(time.RFC3339Nano), "excludeFromIndexes": true},
+			"expiresAt": map[string]interface{}{"timestampValue": s.ExpiresAt.UTC().Format(time.RFC3339Nano)},
+		},
+	}})
+}
+
+func (ds *datastoreStore) Get(ctx context.Context, id string) (Session, error) {
+	var p sessionProperties
+	found, err := ds.ds.Lookup(ctx, "", ds.ds.Key(Kind, id), &p)
+	if err != nil {
+		return Session{}, err
+	}
+	if !found || time.Now().After(p.ExpiresAt.TimestampValue) {
+		return Session{}, ErrNotFound
+	}
+	return p.session(id), nil
+}
+
+func (ds *datastoreStore) Delete(ctx context.Context, id string) error {
+	return ds.ds.Commit(ctx, map[string]interface{}{"delete": ds.ds.Key(Kind, id)})
+}
+
+func (ds *datastoreStore) List(ctx context.Context, principal string) ([]Session, error) {
+	query := map[string]interface{}{
+		"kind": []interface{}{map[string]string{"name": Kind}},
+		"filter": map[string]interface{}{"propertyFilter": map[string]interface{}{
+			"property": map[string]string{"name": "principal"},
+			"op":       "EQUAL",
+			"value":    map[string]interface{}{"stringValue": principal},
+		}},
+	}
+	var resp struct {
+		Batch struct {
+			EntityResults []struct {
+				Entity struct {
+					Key struct {
+						Path []struct {
+							Name string `json:"name"`
+						} `json:"path"`
+					} `json:"key"`
+					Properties sessionProperties `json:"properties"`
+				} `json:"entity"`
+			} `json:"entityResults"`
+		} `json:"batch"`
+	}
+	if err := ds.ds.Call(ctx, "runQuery", map[string]interface{}{"partitionId": ds.ds.Partition(), "query": query}, &resp); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var res []Session
+	for _, r := range resp.Batch.EntityResults {
+		e := r.Entity
+		if len(e.Key.Path) == 0 || now.After(e.Properties.ExpiresAt.TimestampValue) {
+			continue
+		}
+		res = append(res, e.Properties.session(e.Key.Path[len(e.Key.Path)-1].Name))
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].CreatedAt.Before(res[j].CreatedAt) })
+	return res, nil
+}
@@ -0,0 +1,252 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/endpoint"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/auth"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/responses"
+)
+
+// CookieName is the name of the session cookie.
+const CookieName = "__Host-session"
+
+var (
+	// ErrMethodNotAllowed indicates a request with the wrong HTTP method.
+	ErrMethodNotAllowed = errors.NewCoded("SESSION-003", "method not allowed")
+
+	// ErrUnauthenticated indicates a sign-in request whose JWT is missing or
+	// doesn't verify.
+	ErrUnauthenticated = errors.NewCoded("SESSION-004", "unauthenticated")
+)
+
+// cookieValue is what the encrypted cookie carries.
+type cookieValue struct {
+	ID        string `json:"id"`
+	Principal string `json:"principal"`
+}
+
+// Manager issues sessions as encrypted cookies backed by a Store.
+type Manager struct {
+	store  Store
+	codec  *Codec
+	ttl    time.Duration
+	maxPer int
+}
+
+// NewManager returns a Manager whose sessions live for ttl. A principal may
+// hold at most maxPerPrincipal sessions; starting another one ends the
+// oldest. Zero means unlimited.
+func NewManager(store Store, codec *Codec, ttl time.Duration, maxPerPrincipal int) *Manager {
+	return &Manager{store: store, codec: codec, ttl: ttl, maxPer: maxPerPrincipal}
+}
+
+// Start creates a session for principal and sets its cookie.
+func (m *Manager) Start(ctx context.Context, w http.ResponseWriter, principal string, roles []string) (Session, error) {
+	id, err := newID()
+	if err != nil {
+		return Session{}, err
+	}
+	now := time.Now()
+	s := Session{ID: id, Principal: principal, Roles: roles, CreatedAt: now, ExpiresAt: now.Add(m.ttl)}
+	if err := m.store.Save(ctx, s); err != nil {
+		return Session{}, err
+	}
+	if err := m.enforceLimit(ctx, principal); err != nil {
+		return Session{}, err
+	}
+	return s, m.setCookie(w, s)
+}
+
+// Load returns the session referenced by the request cookie.
+func (m *Manager) Load(r *http.Request) (Session, error) {
+	c, err := r.Cookie(CookieName)
+	if err != nil {
+		return Session{}, ErrNotFound
+	}
+	var v cookieValue
+	if err := m.codec.Decode(CookieName, c.Value, &v); err != nil {
+		return Session{}, err
+	}
+	s, err := m.store.Get(r.Context(), v.ID)
+	if err != nil {
+		return Session{}, err
+	}
+	if s.Principal != v.Principal {
+		return Session{}, ErrNotFound
+	}
+	return s, nil
+}
+
+// SetRoles changes the roles of s. Any privilege change moves the session to
+// a fresh ID and invalidates the old one, so an ID captured before the change
+// can't ride on the new privileges.
+func (m *Manager) SetRoles(ctx context.Context, w http.ResponseWriter, s Session, roles []string) (Session, error) {
+	if sameRoles(s.Roles, roles) {
+		return s, nil
+	}
+	id, err := newID()
+	if err != nil {
+		return Session{}, err
+	}
+	next := s
+	next.ID = id
+	next.Roles = roles
+	if err := m.store.Save(ctx, next); err != nil {
+		return Session{}, err
+	}
+	if err := m.store.Delete(ctx, s.ID); err != nil {
+		return Session{}, err
+	}
+	return next, m.setCookie(w, next)
+}
+
+// End deletes s and clears its cookie.
+func (m *Manager) End(ctx context.Context, w http.ResponseWriter, s Session) error {
+	http.SetCookie(w, &http.Cookie{Name: CookieName, Value: "", Path: "/", MaxAge: -1, Secure: true, HttpOnly: true})
+	return m.store.Delete(ctx, s.ID)
+}
+
+// RevokeOthers ends every session of the principal of s except s itself.
+func (m *Manager) RevokeOthers(ctx context.Context, s Session) (int, error) {
+	sessions, err := m.store.List(ctx, s.Principal)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, other := range sessions {
+		if other.ID == s.ID {
+			continue
+		}
+		if err := m.store.Delete(ctx, other.ID); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// StartHandler serves a sign-in request: the JWT of its Authorization
+// header, verified by authn, e.g. the middleware of auth.NewParser, starts a
+// session of its subject whose roles are its scopes. A caller signing in
+// again keeps its session, moved to a fresh ID when its roles changed, see
+// SetRoles; the session of another principal is ended.
+func (m *Manager) StartHandler(authn endpoint.Middleware) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed)
+			return
+		}
+		var principal string
+		var roles []string
+		verify := authn(func(ctx context.Context, _ interface{}) (interface{}, error) {
+			principal, roles = auth.Principal(ctx), auth.Scopes(ctx)
+			return nil, nil
+		})
+		if _, err := verify(kitjwt.HTTPToContext()(r.Context(), r), nil); err != nil || principal == "" {
+			writeError(w, http.StatusUnauthorized, ErrUnauthenticated)
+			return
+		}
+
+		ctx := r.Context()
+		s, err := m.Load(r)
+		switch {
+		case err == nil && s.Principal == principal:
+			s, err = m.SetRoles(ctx, w, s, roles)
+		case err == nil:
+			if err = m.store.Delete(ctx, s.ID); err == nil {
+				s, err = m.Start(ctx, w, principal, roles)
+			}
+		default:
+			s, err = m.Start(ctx, w, principal, roles)
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		json.NewEncoder(w).Encode(responses.DataRes{Data: struct {
+			Principal string    `json:"principal"`
+			Roles     []string  `json:"roles"`
+			ExpiresAt time.Time `json:"expiresAt"`
+		}{s.Principal, s.Roles, s.ExpiresAt}})
+	})
+}
+
+// RevokeOthersHandler serves a request revoking every other session of the
+// caller, e.g. "sign out everywhere else".
+func (m *Manager) RevokeOthersHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if r.Method != http.MethodDelete {
+			writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed)
+			return
+		}
+		s, err := m.Load(r)
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+		n, err := m.RevokeOthers(r.Context(), s)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		json.NewEncoder(w).Encode(responses.DataRes{Data: struct {
+			Revoked int `json:"revoked"`
+		}{n}})
+	})
+}
+
+func (m *Manager) enforceLimit(ctx context.Context, principal string) error {
+	if m.maxPer <= 0 {
+		return nil
+	}
+	sessions, err := m.store.List(ctx, principal)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(sessions)-m.maxPer; i++ {
+		if err := m.store.Delete(ctx, sessions[i].ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Manager) setCookie(w http.ResponseWriter, s Session) error {
+	value, err := m.codec.Encode(CookieName, cookieValue{ID: s.ID, Principal: s.Principal})
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     CookieName,
+		Value:    value,
+		Path:     "/",
+		Expires:  s.ExpiresAt,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+func sameRoles(a, b []string) bool {
+	x, y := append([]string(nil), a...), append([]string(nil), b...)
+	sort.Strings(x)
+	sort.Strings(y)
+	return strings.Join(x, "\x00") == strings.Join(y, "\x00")
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(responses.ErrorRes{Error: responses.ErrorResItem{Code: code, ErrorCode: errors.Code(err), Message: err.Error()}})
+}
@@ -0,0 +1,97 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// ErrNotFound indicates the session doesn't exist, expired or was revoked.
+var ErrNotFound = errors.NewCoded("SESSION-001", "session not found")
+
+// Session is a server-side session of a principal.
+type Session struct {
+	ID        string    `json:"id"`
+	Principal string    `json:"principal"`
+	Roles     []string  `json:"roles"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Store persists sessions.
+type Store interface {
+	Save(ctx context.Context, s Session) error
+	Get(ctx context.Context, id string) (Session, error)
+	Delete(ctx context.Context, id string) error
+	// List returns the live sessions of principal, oldest first.
+	List(ctx context.Context, principal string) ([]Session, error)
+}
+
+type memoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]Session
+}
+
+// NewMemoryStore returns an instance-local Store.
+func NewMemoryStore() Store {
+	return &memoryStore{sessions: make(map[string]Session)}
+}
+
+func (ms *memoryStore) Save(_ context.Context, s Session) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.sessions[s.ID] = s
+	return nil
+}
+
+func (ms *memoryStore) Get(_ context.Context, id string) (Session, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	s, ok := ms.sessions[id]
+	if !ok {
+		return Session{}, ErrNotFound
+	}
+	if time.Now().After(s.ExpiresAt) {
+		delete(ms.sessions, id)
+		return Session{}, ErrNotFound
+	}
+	return s, nil
+}
+
+func (ms *memoryStore) Delete(_ context.Context, id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.sessions, id)
+	return nil
+}
+
+func (ms *memoryStore) List(_ context.Context, principal string) ([]Session, error) {
+	now := time.Now()
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	var res []Session
+	for id, s := range ms.sessions {
+		if now.After(s.ExpiresAt) {
+			delete(ms.sessions, id)
+			continue
+		}
+		if s.Principal == principal {
+			res = append(res, s)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].CreatedAt.Before(res[j].CreatedAt) })
+	return res, nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
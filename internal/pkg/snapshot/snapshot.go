@@ -0,0 +1,163 @@
+// Package snapshot shares the contents of instance-local caches through a
+// single stored object. A periodic job writes the hot caches of a running
+// instance, and new instances bulk-load the snapshot while warming up
+// instead of issuing many cold fetches.
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// ErrStale indicates a snapshot older than the accepted age.
+var ErrStale = errors.New("stale cache snapshot")
+
+// Cache is an instance-local cache that can be snapshotted. Only caches of
+// data safe to share across instances, such as reference data or public
+// keys, should be registered; never credentials.
+type Cache interface {
+	// Snapshot returns the contents of the cache.
+	Snapshot() (json.RawMessage, error)
+	// Restore fills the cache with contents returned by Snapshot.
+	Restore(json.RawMessage) error
+}
+
+// Store stores the snapshot object, e.g. a gcp.Bucket.
+type Store interface {
+	Upload(ctx context.Context, name, contentType string, data []byte) error
+	Download(ctx context.Context, name string) ([]byte, error)
+}
+
+// Snapshot is the stored object, the contents of every cache by name.
+type Snapshot struct {
+	Version string                     `json:"version"`
+	TakenAt time.Time                  `json:"taken_at"`
+	Caches  map[string]json.RawMessage `json:"caches"`
+}
+
+// Manager loads and saves the snapshot of the registered caches. A nil
+// *Manager ignores registrations, so callers needn't check whether
+// snapshots are enabled.
+type Manager struct {
+	store   Store
+	object  string
+	version string
+	logger  log.Logger
+
+	mu     sync.Mutex
+	caches map[string]Cache
+	loaded map[string]json.RawMessage
+}
+
+// NewManager returns a Manager keeping the snapshot of the caches of version
+// in the object of store.
+func NewManager(store Store, object, version string, logger log.Logger) *Manager {
+	return &Manager{
+		store:   store,
+		object:  object,
+		version: version,
+		logger:  logger,
+		caches:  map[string]Cache{},
+		loaded:  map[string]json.RawMessage{},
+	}
+}
+
+// Register adds c to the snapshot as name. When a snapshot was loaded
+// already, c is restored from it right away.
+func (m *Manager) Register(name string, c Cache) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.caches[name] = c
+	if raw, ok := m.loaded[name]; ok {
+		m.restore(name, c, raw)
+	}
+}
+
+// Load downloads the snapshot and restores the registered caches, and the
+// ones registered later, from it. Snapshots older than maxAge, or taken by
+// another version, are ignored; zero accepts any age.
+func (m *Manager) Load(ctx context.Context, maxAge time.Duration) error {
+	b, err := m.store.Download(ctx, m.object)
+	if err != nil {
+		return err
+	}
+	var s Snapshot
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	switch {
+	case s.Version != m.version:
+		return errors.Wrap(ErrStale, fmt.Errorf("taken by version %q", s.Version))
+	case maxAge > 0 && time.Since(s.TakenAt) > maxAge:
+		return errors.Wrap(ErrStale, fmt.Errorf("taken at %s", s.TakenAt.Format(time.RFC3339)))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.loaded = s.Caches
+	for name, c := range m.caches {
+		if raw, ok := s.Caches[name]; ok {
+			m.restore(name, c, raw)
+		}
+	}
+	level.Info(m.logger).Log("snapshot", "load", "object", m.object, "taken_at", s.TakenAt, "caches", len(s.Caches))
+	return nil
+}
+
+// restore must be called with mu held. A cache failing to restore is left
+// to fill itself.
+func (m *Manager) restore(name string, c Cache, raw json.RawMessage) {
+	if err := c.Restore(raw); err != nil {
+		level.Warn(m.logger).Log("snapshot", "restore", "cache", name, "err", err)
+	}
+}
+
+// Save uploads the snapshot of the registered caches. Caches failing to
+// snapshot are left out.
+func (m *Manager) Save(ctx context.Context) error {
+	s := Snapshot{Version: m.version, TakenAt: time.Now().UTC(), Caches: map[string]json.RawMessage{}}
+	m.mu.Lock()
+	for name, c := range m.caches {
+		raw, err := c.Snapshot()
+		if err != nil {
+			level.Warn(m.logger).Log("snapshot", "save", "cache", name, "err", err)
+			continue
+		}
+		s.Caches[name] = raw
+	}
+	m.mu.Unlock()
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return m.store.Upload(ctx, m.object, "application/json", b)
+}
+
+// Run saves the snapshot every interval until ctx is done. A failing save is
+// logged and retried at the next interval.
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := m.Save(ctx); err != nil {
+				level.Warn(m.logger).Log("snapshot", "save", "object", m.object, "err", err)
+			}
+		}
+	}
+}
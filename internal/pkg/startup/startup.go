@@ -0,0 +1,89 @@
+// Package startup times the bootstrap steps of a process, so regressions in
+// cold start latency can be attributed to the init step that caused them.
+// The steps are summarized once per instance in a cold start report, logged
+// and exported as metrics.
+package startup
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+)
+
+// Step is a timed bootstrap step.
+type Step struct {
+	Name     string
+	Duration time.Duration
+}
+
+// Report collects the bootstrap steps of a process.
+type Report struct {
+	mu      sync.Mutex
+	started time.Time
+	last    time.Time
+	steps   []Step
+	done    bool
+}
+
+// NewReport returns a Report timing the steps from started, usually the time
+// the process started, so the runtime and package initialization count as
+// the first step.
+func NewReport(started time.Time) *Report {
+	return &Report{started: started, last: started}
+}
+
+// Mark ends the step name, started when the previous step ended. Steps
+// marked once the report is done are ignored.
+func (r *Report) Mark(name string) {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.done {
+		return
+	}
+	r.steps = append(r.steps, Step{Name: name, Duration: now.Sub(r.last)})
+	r.last = now
+}
+
+// Steps returns the steps marked so far, in order.
+func (r *Report) Steps() []Step {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Step(nil), r.steps...)
+}
+
+// Done ends the report: it logs the duration of every step, the total and the
+// slowest step, and sets them on durations, a gauge labelled by step, when
+// it isn't nil. The total is reported as step "total". Only the first call
+// reports.
+func (r *Report) Done(logger log.Logger, durations metrics.Gauge) {
+	r.mu.Lock()
+	if r.done {
+		r.mu.Unlock()
+		return
+	}
+	r.done = true
+	total := r.last.Sub(r.started)
+	steps := r.steps
+	r.mu.Unlock()
+
+	var slowest Step
+	keyvals := []interface{}{"startup", "cold_start_report", "total", total.String()}
+	for _, s := range steps {
+		keyvals = append(keyvals, s.Name, s.Duration.String())
+		if s.Duration > slowest.Duration {
+			slowest = s
+		}
+		if durations != nil {
+			durations.With("step", s.Name).Set(s.Duration.Seconds())
+		}
+	}
+	if durations != nil {
+		durations.With("step", "total").Set(total.Seconds())
+	}
+	keyvals = append(keyvals, "slowest", slowest.Name)
+	level.Info(logger).Log(keyvals...)
+}
@@ -0,0 +1,165 @@
+package tasks
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/gcp"
+)
+
+const cloudTasksURL = "https://cloudtasks.googleapis.com/v2/"
+
+var (
+	// ErrEnqueue indicates Cloud Tasks rejected a task.
+	ErrEnqueue = errors.New("cloud tasks enqueue failed")
+
+	// ErrDuplicate indicates a task named as one created, or deleted,
+	// recently in its queue: the work it carries is enqueued already.
+	ErrDuplicate = errors.Register(errors.KindAlreadyExists, errors.NewCoded("TASKS-001", "task already enqueued"))
+
+	// ErrInvalidName indicates a task name Cloud Tasks wouldn't accept.
+	ErrInvalidName = errors.Register(errors.KindInvalidArgument, errors.NewCoded("TASKS-002", "invalid task name"))
+)
+
+// validName matches the task IDs Cloud Tasks accepts.
+var validName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,500}$`)
+
+// Target is where the tasks of a Producer are pushed to.
+type Target struct {
+	// URL is the base URL of the HTTP target, the path of every task
+	// appended to it. When empty, the tasks go to the App Engine service
+	// Service instead, whose requests carry the X-AppEngine- headers.
+	URL string
+	// ServiceAccount signs the OIDC token of the HTTP target requests, with
+	// Audience as its audience, URL when empty. No token is sent when
+	// ServiceAccount is empty.
+	ServiceAccount string
+	Audience       string
+	// Service is the App Engine service of the tasks without URL, the
+	// default one when empty.
+	Service string
+}
+
+// Options are the per-task options of Enqueue.
+type Options struct {
+	// Name deduplicates the task: a task named as one created in the last
+	// hour or so is rejected with ErrDuplicate. Cloud Tasks names the task
+	// when empty.
+	Name string
+	// ScheduleTime is when the task is run, right away when zero.
+	ScheduleTime time.Time
+	// Headers are added to the task request.
+	Headers map[string]string
+}
+
+// Producer enqueues tasks to a Cloud Tasks queue through the REST API, for
+// the push handlers of a service to run, see Middleware.
+type Producer struct {
+	queue  string
+	target Target
+	client *http.Client
+}
+
+// NewProducer returns a Producer enqueuing to queue, in location of
+// projectID, the tasks pushed to target.
+func NewProducer(projectID, location, queue string, target Target) *Producer {
+	return &Producer{
+		queue:  fmt.Sprintf("projects/%s/locations/%s/queues/%s", projectID, location, queue),
+		target: target,
+		client: gcp.NewClient("https://www.googleapis.com/auth/cloud-platform"),
+	}
+}
+
+// Enqueue enqueues a POST of payload, encoded as JSON, to path of the target
+// and returns the name of the task.
+func (p *Producer) Enqueue(ctx context.Context, path string, payload interface{}, o Options) (string, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	headers := map[string]string{"Content-Type": "application/json"}
+	for k, v := range o.Headers {
+		headers[k] = v
+	}
+
+	task := map[string]interface{}{}
+	if o.Name != "" {
+		if !validName.MatchString(o.Name) {
+			return "", errors.Wrap(ErrInvalidName, fmt.Errorf("%q", o.Name))
+		}
+		task["name"] = p.queue + "/tasks/" + o.Name
+	}
+	if !o.ScheduleTime.IsZero() {
+		task["scheduleTime"] = o.ScheduleTime.UTC().Format(time.RFC3339Nano)
+	}
+	if p.target.URL != "" {
+		req := map[string]interface{}{
+			"url":        p.target.URL + path,
+			"httpMethod": http.MethodPost,
+			"headers":    headers,
+			"body":       base64.StdEncoding.EncodeToString(body),
+		}
+		if p.target.ServiceAccount != "" {
+			audience := p.target.Audience
+			if audience == "" {
+				audience = p.target.URL
+			}
+			req["oidcToken"] = map[string]string{"serviceAccountEmail": p.target.ServiceAccount, "audience": audience}
+		}
+		task["httpRequest"] = req
+	} else {
+		req := map[string]interface{}{
+			"relativeUri": path,
+			"httpMethod":  http.MethodPost,
+			"headers":     headers,
+			"body":        base64.StdEncoding.EncodeToString(body),
+		}
+		if p.target.Service != "" {
+			req["appEngineRouting"] = map[string]string{"service": p.target.Service}
+		}
+		task["appEngineHttpRequest"] = req
+	}
+	return p.create(ctx, task)
+}
+
+func (p *Producer) create(ctx context.Context, task map[string]interface{}) (string, error) {
+	b, err := json.Marshal(map[string]interface{}{"task": task})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, cloudTasksURL+p.queue+"/tasks", bytes.NewReader(b))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", errors.Wrap(ErrEnqueue, err)
+	}
+	defer resp.Body.Close()
+	b, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case resp.StatusCode == http.StatusConflict:
+		return "", errors.Wrap(ErrDuplicate, fmt.Errorf("%s", task["name"]))
+	case resp.StatusCode != http.StatusOK:
+		return "", errors.Wrap(ErrEnqueue, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(b)))
+	}
+	var created struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(b, &created); err != nil {
+		return "", err
+	}
+	return created.Name, nil
+}
@@ -0,0 +1,153 @@
+// Package tasks exposes the Cloud Tasks metadata of task requests, such as
+// their retry count, to the handlers, and gives up on tasks retried too many
+// times by handing them to a dead letter instead of running them again. Its
+// Producer enqueues the tasks those handlers run.
+package tasks
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+// The header prefixes of Cloud Tasks for App Engine and HTTP targets. App
+// Engine strips the X-AppEngine- headers from external requests, the
+// X-CloudTasks- ones are only trustworthy behind authentication.
+const (
+	AppEnginePrefix  = "X-Appengine-"
+	CloudTasksPrefix = "X-Cloudtasks-"
+)
+
+// Info is the Cloud Tasks metadata of a task request.
+type Info struct {
+	// Queue and Name identify the task.
+	Queue string
+	Name  string
+	// RetryCount is the number of times the task was retried, whatever the
+	// outcome of the attempts. It's 0 on the first attempt.
+	RetryCount int
+	// ExecutionCount is the number of attempts the handler answered, that
+	// is not counting the attempts rejected with 503 before reaching it.
+	ExecutionCount int
+	// ETA is the schedule time of the task.
+	ETA time.Time
+}
+
+// FromRequest returns the task metadata of r, and false when r isn't a task
+// request.
+func FromRequest(r *http.Request) (Info, bool) {
+	for _, prefix := range []string{CloudTasksPrefix, AppEnginePrefix} {
+		queue := r.Header.Get(prefix + "Queuename")
+		if queue == "" {
+			continue
+		}
+		info := Info{Queue: queue, Name: r.Header.Get(prefix + "Taskname")}
+		info.RetryCount, _ = strconv.Atoi(r.Header.Get(prefix + "Taskretrycount"))
+		info.ExecutionCount, _ = strconv.Atoi(r.Header.Get(prefix + "Taskexecutioncount"))
+		if eta, err := strconv.ParseFloat(r.Header.Get(prefix+"Tasketa"), 64); err == nil {
+			info.ETA = time.Unix(0, int64(eta*float64(time.Second))).UTC()
+		}
+		return info, true
+	}
+	return Info{}, false
+}
+
+type contextKey int
+
+const infoContextKey contextKey = iota
+
+// NewContext returns ctx carrying info.
+func NewContext(ctx context.Context, info Info) context.Context {
+	return context.WithValue(ctx, infoContextKey, info)
+}
+
+// FromContext returns the task metadata of ctx, and false outside of task
+// requests.
+func FromContext(ctx context.Context) (Info, bool) {
+	info, ok := ctx.Value(infoContextKey).(Info)
+	return info, ok
+}
+
+// HTTPToContext returns an http RequestFunc moving the task metadata of the
+// request into ctx.
+func HTTPToContext() httptransport.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		if info, ok := FromRequest(r); ok {
+			return NewContext(ctx, info)
+		}
+		return ctx
+	}
+}
+
+// DeadLetter takes the task requests given up on, e.g. to store them for
+// inspection. The request body is still unread.
+type DeadLetter interface {
+	DeadLetter(ctx context.Context, info Info, r *http.Request) error
+}
+
+// DeadLetterFunc adapts a function to DeadLetter.
+type DeadLetterFunc func(ctx context.Context, info Info, r *http.Request) error
+
+// DeadLetter calls f.
+func (f DeadLetterFunc) DeadLetter(ctx context.Context, info Info, r *http.Request) error {
+	return f(ctx, info, r)
+}
+
+// LogDeadLetter returns a DeadLetter logging the tasks given up on.
+func LogDeadLetter(logger log.Logger) DeadLetter {
+	return DeadLetterFunc(func(_ context.Context, info Info, r *http.Request) error {
+		level.Warn(logger).Log("task", info.Name, "queue", info.Queue, "retries", info.RetryCount, "executions", info.ExecutionCount, "path", r.URL.Path, "msg", "given up")
+		return nil
+	})
+}
+
+// Policy declares when the tasks of a route are given up on. Zero limits
+// don't apply.
+type Policy struct {
+	// MaxRetries gives up on the tasks retried more than MaxRetries times.
+	MaxRetries int
+	// MaxExecutions gives up on the tasks the handler already answered
+	// MaxExecutions times.
+	MaxExecutions int
+	// DeadLetter takes the tasks given up on.
+	DeadLetter DeadLetter
+}
+
+// exhausted reports whether p gives up on the task of info.
+func (p Policy) exhausted(info Info) bool {
+	return (p.MaxRetries > 0 && info.RetryCount > p.MaxRetries) ||
+		(p.MaxExecutions > 0 && info.ExecutionCount >= p.MaxExecutions)
+}
+
+// Middleware returns next behind p: the metadata of task requests is put in
+// their context, see FromContext, and the tasks p gives up on aren't run but
+// handed to the dead letter and acknowledged with 200 OK, so the queue stops
+// retrying them. When the dead letter fails, the request fails with 500 and
+// is retried. Other requests go to next untouched.
+func (p Policy) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, ok := FromRequest(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := NewContext(r.Context(), info)
+		if !p.exhausted(info) {
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		if p.DeadLetter != nil {
+			if err := p.DeadLetter.DeadLetter(ctx, info, r.WithContext(ctx)); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
@@ -0,0 +1,107 @@
+package tenant
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/metrics"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/cache"
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+	"github.com/cage1016/gokit-gae/internal/pkg/gcp"
+)
+
+// Kind is the Datastore kind of the tenants, keyed by their id.
+const Kind = "Tenant"
+
+var (
+	// ErrUnknownTenant indicates a request of a tenant the directory
+	// doesn't know.
+	ErrUnknownTenant = errors.Register(errors.KindPermissionDenied, errors.NewCoded("TENANT-003", "unknown tenant"))
+
+	// ErrTenantDisabled indicates a request of a disabled tenant.
+	ErrTenantDisabled = errors.Register(errors.KindPermissionDenied, errors.NewCoded("TENANT-004", "tenant disabled"))
+)
+
+// Tenant is the registration of a tenant.
+type Tenant struct {
+	ID       string
+	Name     string
+	Disabled bool
+}
+
+// Directory looks the tenants up.
+type Directory interface {
+	// Lookup returns the tenant id, nil when it isn't registered.
+	Lookup(ctx context.Context, id string) (*Tenant, error)
+}
+
+// Verify returns ErrMissingTenant when ctx carries no tenant, and
+// ErrUnknownTenant or ErrTenantDisabled when dir doesn't know it or has it
+// disabled.
+func Verify(ctx context.Context, dir Directory) error {
+	if err := Require(ctx); err != nil {
+		return err
+	}
+	t, err := dir.Lookup(ctx, FromContext(ctx))
+	switch {
+	case err != nil:
+		return err
+	case t == nil:
+		return ErrUnknownTenant
+	case t.Disabled:
+		return ErrTenantDisabled
+	}
+	return nil
+}
+
+type datastoreDirectory struct {
+	ds *gcp.Datastore
+}
+
+// NewDatastoreDirectory returns the Directory of the entities of Kind in
+// ds, with their name and disabled properties.
+func NewDatastoreDirectory(ds *gcp.Datastore) Directory {
+	return &datastoreDirectory{ds: ds}
+}
+
+// tenantProperties are the properties of a tenant entity.
+type tenantProperties struct {
+	Name struct {
+		StringValue string `json:"stringValue"`
+	} `json:"name"`
+	Disabled struct {
+		BooleanValue bool `json:"booleanValue"`
+	} `json:"disabled"`
+}
+
+func (d *datastoreDirectory) Lookup(ctx context.Context, id string) (*Tenant, error) {
+	var p tenantProperties
+	found, err := d.ds.Lookup(ctx, "", d.ds.Key(Kind, id), &p)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &Tenant{ID: id, Name: p.Name.StringValue, Disabled: p.Disabled.BooleanValue}, nil
+}
+
+type cachedDirectory struct {
+	cache *cache.Cache
+}
+
+// NewCachedDirectory returns dir behind a read-through cache.Cache named
+// "tenants", expiring its entries as opts tells. The tenants dir doesn't
+// know are cached as well, for the requests of unknown tenants not to reach
+// it every time. lookups counts the lookups, see cache.New.
+func NewCachedDirectory(dir Directory, opts cache.Options, lookups metrics.Counter) Directory {
+	load := func(ctx context.Context, id string) (interface{}, error) {
+		return dir.Lookup(ctx, id)
+	}
+	return &cachedDirectory{cache: cache.New("tenants", load, opts, lookups)}
+}
+
+func (d *cachedDirectory) Lookup(ctx context.Context, id string) (*Tenant, error) {
+	v, err := d.cache.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Tenant), nil
+}
@@ -0,0 +1,147 @@
+// Package tenant carries the tenant of a request through its context: it's
+// taken from the verified JWT claim or the tenant header, required by the
+// services serving tenants, and propagated on outgoing calls.
+package tenant
+
+import (
+	"context"
+	"net/http"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/endpoint"
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+const (
+	// Header is the HTTP header carrying the tenant.
+	Header = "X-Tenant-ID"
+	// MetadataKey is the gRPC metadata key carrying the tenant.
+	MetadataKey = "x-tenant-id"
+	// Claim is the JWT claim carrying the tenant.
+	Claim = "tenant"
+)
+
+var (
+	// ErrMissingTenant indicates a request without tenant to a service
+	// requiring one.
+	ErrMissingTenant = errors.Register(errors.KindInvalidArgument, errors.NewCoded("TENANT-001", "missing tenant"))
+
+	// ErrTenantMismatch indicates a tenant header contradicting the tenant
+	// claim of the caller's JWT.
+	ErrTenantMismatch = errors.Register(errors.KindPermissionDenied, errors.NewCoded("TENANT-002", "tenant mismatch"))
+)
+
+type contextKey int
+
+const (
+	tenantContextKey contextKey = iota
+	headerContextKey
+)
+
+// NewContext returns ctx carrying tenant id.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, tenantContextKey, id)
+}
+
+// FromContext returns the tenant of ctx, resolved by Middleware, or "".
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(tenantContextKey).(string)
+	return id
+}
+
+// HeaderToContext returns ctx carrying the tenant id named by a transport
+// header, to be resolved by Middleware. It backs the request funcs below,
+// for the transports without one.
+func HeaderToContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, headerContextKey, id)
+}
+
+// HTTPToContext returns an http RequestFunc moving the tenant header into
+// ctx, to be resolved by Middleware.
+func HTTPToContext() httptransport.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		if id := r.Header.Get(Header); id != "" {
+			return HeaderToContext(ctx, id)
+		}
+		return ctx
+	}
+}
+
+// GRPCToContext returns a grpc ServerRequestFunc moving the tenant metadata
+// into ctx, to be resolved by Middleware.
+func GRPCToContext() grpctransport.ServerRequestFunc {
+	return func(ctx context.Context, md metadata.MD) context.Context {
+		if v := md.Get(MetadataKey); len(v) > 0 && v[0] != "" {
+			return HeaderToContext(ctx, v[0])
+		}
+		return ctx
+	}
+}
+
+// ContextToHTTP returns an http RequestFunc propagating the tenant of ctx on
+// outgoing calls.
+func ContextToHTTP() httptransport.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		if id := FromContext(ctx); id != "" {
+			r.Header.Set(Header, id)
+		}
+		return ctx
+	}
+}
+
+// ContextToGRPC returns a grpc ClientRequestFunc propagating the tenant of
+// ctx on outgoing calls.
+func ContextToGRPC() grpctransport.ClientRequestFunc {
+	return func(ctx context.Context, md *metadata.MD) context.Context {
+		if id := FromContext(ctx); id != "" {
+			(*md)[MetadataKey] = []string{id}
+		}
+		return ctx
+	}
+}
+
+// claim returns the tenant claim of the verified JWT claims in ctx.
+func claim(ctx context.Context) string {
+	claims, ok := ctx.Value(kitjwt.JWTClaimsContextKey).(jwt.MapClaims)
+	if !ok {
+		return ""
+	}
+	id, _ := claims[Claim].(string)
+	return id
+}
+
+// Middleware returns an endpoint middleware resolving the tenant of the
+// request into ctx, see FromContext. The JWT claim wins over the header,
+// which may only repeat it; callers without tenant claim name their tenant
+// with the header. It must run after the JWT claims are verified.
+func Middleware() endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			header, _ := ctx.Value(headerContextKey).(string)
+			id := claim(ctx)
+			switch {
+			case id != "" && header != "" && header != id:
+				return nil, ErrTenantMismatch
+			case id == "":
+				id = header
+			}
+			if id != "" {
+				ctx = NewContext(ctx, id)
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// Require returns ErrMissingTenant when ctx carries no tenant.
+func Require(ctx context.Context) error {
+	if FromContext(ctx) == "" {
+		return ErrMissingTenant
+	}
+	return nil
+}
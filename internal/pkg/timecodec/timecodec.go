@@ -0,0 +1,135 @@
+// Package timecodec is the time policy of the APIs: timestamps are RFC 3339
+// with an explicit time zone on the way in, and UTC on the way out. The
+// formats a timestamp could be read from in more than one way, a local time
+// without zone, a date alone or a Unix time, are rejected with
+// ErrAmbiguousTime rather than guessed.
+package timecodec
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// Layout is the layout of the timestamps written.
+const Layout = time.RFC3339Nano
+
+var (
+	// ErrAmbiguousTime indicates a timestamp without time zone, or not
+	// written as RFC 3339, whose instant depends on how it's read.
+	ErrAmbiguousTime = errors.Register(errors.KindInvalidArgument, errors.NewCoded("TIME-001", "ambiguous timestamp, expected RFC 3339 with a time zone"))
+
+	// ErrInvalidTime indicates a value that isn't a timestamp.
+	ErrInvalidTime = errors.Register(errors.KindInvalidArgument, errors.NewCoded("TIME-002", "invalid timestamp"))
+
+	// ErrInvalidRange indicates a range whose start isn't before its end.
+	ErrInvalidRange = errors.Register(errors.KindInvalidArgument, errors.NewCoded("TIME-003", "invalid time range"))
+)
+
+var (
+	// rfc3339 matches RFC 3339 timestamps: date, T, time, optional
+	// fraction, and the zone, Z or an offset.
+	rfc3339 = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}[Tt]\d{2}:\d{2}:\d{2}(\.\d+)?([Zz]|[+-]\d{2}:\d{2})$`)
+	// ambiguous matches the timestamps missing a zone, written with a space,
+	// dates alone, and Unix times.
+	ambiguous = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}([Tt ]\d{2}:\d{2}(:\d{2}(\.\d+)?)?)?( ?([Zz]|[+-]\d{2}:?\d{2}|UTC))?|\d{9,})$`)
+)
+
+// Parse returns the UTC instant of the RFC 3339 timestamp s, failing with
+// ErrAmbiguousTime when s lacks its zone or is written otherwise, and with
+// ErrInvalidTime when it isn't a timestamp.
+func Parse(s string) (time.Time, error) {
+	if !rfc3339.MatchString(s) {
+		if ambiguous.MatchString(s) {
+			return time.Time{}, errors.Wrap(ErrAmbiguousTime, fmt.Errorf("%q", s))
+		}
+		return time.Time{}, errors.Wrap(ErrInvalidTime, fmt.Errorf("%q", s))
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, errors.Wrap(ErrInvalidTime, err)
+	}
+	return t.UTC(), nil
+}
+
+// Format returns t in UTC as RFC 3339, with the fraction of its second if
+// any.
+func Format(t time.Time) string {
+	return t.UTC().Format(Layout)
+}
+
+// Time is a time.Time encoded in JSON as Format does, and decoded as Parse
+// does, null being the zero time.
+type Time struct {
+	time.Time
+}
+
+// MarshalJSON implements json.Marshaler.
+func (t Time) MarshalJSON() ([]byte, error) {
+	return json.Marshal(Format(t.Time))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (t *Time) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		t.Time = time.Time{}
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.Wrap(ErrInvalidTime, err)
+	}
+	v, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	t.Time = v
+	return nil
+}
+
+// Range is a time range of a list query, its zero bounds open.
+type Range struct {
+	// Since is the inclusive start.
+	Since time.Time
+	// Until is the exclusive end.
+	Until time.Time
+}
+
+// ParseRange returns the range of the query parameters since and until of q,
+// parsed with Parse, failing with ErrInvalidRange when both are set and
+// since isn't before until.
+func ParseRange(q url.Values, since, until string) (Range, error) {
+	var (
+		r   Range
+		err error
+	)
+	if v := q.Get(since); v != "" {
+		if r.Since, err = Parse(v); err != nil {
+			return Range{}, err
+		}
+	}
+	if v := q.Get(until); v != "" {
+		if r.Until, err = Parse(v); err != nil {
+			return Range{}, err
+		}
+	}
+	if !r.Since.IsZero() && !r.Until.IsZero() && !r.Since.Before(r.Until) {
+		return Range{}, errors.Wrap(ErrInvalidRange, fmt.Errorf("%s %s not before %s %s", since, Format(r.Since), until, Format(r.Until)))
+	}
+	return r, nil
+}
+
+// Set sets the query parameters since and until of q to the bounds of r that
+// aren't open, see ParseRange.
+func (r Range) Set(q url.Values, since, until string) {
+	if !r.Since.IsZero() {
+		q.Set(since, Format(r.Since))
+	}
+	if !r.Until.IsZero() {
+		q.Set(until, Format(r.Until))
+	}
+}
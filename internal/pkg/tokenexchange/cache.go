@@ -0,0 +1,84 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultSkew is how long before expiry a cached token stops being handed out,
+// so a token never expires while the downstream call is in flight.
+const defaultSkew = 30 * time.Second
+
+type cachingExchanger struct {
+	mu     sync.Mutex
+	tokens map[string]Token
+	skew   time.Duration
+	next   Exchanger
+}
+
+// CachingMiddleware caches exchanged tokens per subject token, audience and
+// scope set until shortly before they expire. Subject tokens are only kept
+// as hashes.
+func CachingMiddleware(skew time.Duration) ExchangerMiddleware {
+	if skew <= 0 {
+		skew = defaultSkew
+	}
+	return func(next Exchanger) Exchanger {
+		return &cachingExchanger{
+			tokens: make(map[string]Token),
+			skew:   skew,
+			next:   next,
+		}
+	}
+}
+
+func (c *cachingExchanger) Exchange(ctx context.Context, subjectToken string, audience string, scopes []string) (Token, error) {
+	key := cacheKey(subjectToken, audience, scopes)
+	now := time.Now()
+
+	c.mu.Lock()
+	token, ok := c.tokens[key]
+	if ok && token.Expired(now, c.skew) {
+		delete(c.tokens, key)
+		ok = false
+	}
+	c.mu.Unlock()
+	if ok {
+		return token, nil
+	}
+
+	token, err := c.next.Exchange(ctx, subjectToken, audience, scopes)
+	if err != nil {
+		return Token{}, err
+	}
+	if token.ExpiresAt.IsZero() || token.Expired(now, c.skew) {
+		return token, nil
+	}
+
+	c.mu.Lock()
+	c.tokens[key] = token
+	c.evictExpired(now)
+	c.mu.Unlock()
+	return token, nil
+}
+
+// evictExpired must be called with mu held.
+func (c *cachingExchanger) evictExpired(now time.Time) {
+	for k, t := range c.tokens {
+		if t.Expired(now, c.skew) {
+			delete(c.tokens, k)
+		}
+	}
+}
+
+func cacheKey(subjectToken string, audience string, scopes []string) string {
+	sorted := append([]string(nil), scopes...)
+	sort.Strings(sorted)
+	sum := sha256.Sum256([]byte(subjectToken))
+	return hex.EncodeToString(sum[:]) + "|" + audience + "|" + strings.Join(sorted, " ")
+}
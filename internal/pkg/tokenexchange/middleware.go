@@ -0,0 +1,32 @@
+package tokenexchange
+
+import (
+	"context"
+
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/endpoint"
+)
+
+// Middleware returns a client endpoint middleware that swaps the caller's JWT
+// in the context for a token exchanged for audience and scopes, so the
+// downstream service never receives the original token. It must be applied
+// to endpoints whose transport forwards the context token, e.g. via
+// kitjwt.ContextToHTTP or kitjwt.ContextToGRPC.
+func Middleware(exchanger Exchanger, audience string, scopes ...string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			subjectToken, ok := ctx.Value(kitjwt.JWTTokenContextKey).(string)
+			if !ok || subjectToken == "" {
+				return nil, ErrMissingSubjectToken
+			}
+
+			token, err := exchanger.Exchange(ctx, subjectToken, audience, scopes)
+			if err != nil {
+				return nil, err
+			}
+
+			ctx = context.WithValue(ctx, kitjwt.JWTTokenContextKey, token.AccessToken)
+			return next(ctx, request)
+		}
+	}
+}
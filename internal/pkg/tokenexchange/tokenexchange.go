@@ -0,0 +1,127 @@
+package tokenexchange
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+const (
+	grantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	tokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+var (
+	// ErrMissingSubjectToken indicates there is no inbound token to exchange.
+	ErrMissingSubjectToken = errors.NewCoded("TOKEN-001", "missing subject token")
+
+	// ErrExchangeFailed indicates the token endpoint rejected the exchange.
+	ErrExchangeFailed = errors.NewCoded("TOKEN-002", "token exchange failed")
+)
+
+// Token is a delegated access token issued for a single downstream audience.
+type Token struct {
+	AccessToken string    `json:"access_token"`
+	Audience    string    `json:"audience"`
+	Scopes      []string  `json:"scopes"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// Expired reports whether the token is expired, or will be within skew.
+func (t Token) Expired(now time.Time, skew time.Duration) bool {
+	return !t.ExpiresAt.IsZero() && !now.Add(skew).Before(t.ExpiresAt)
+}
+
+// Exchanger trades the caller's token for a narrowed token that is only
+// valid for audience and the given scopes (on-behalf-of).
+type Exchanger interface {
+	Exchange(ctx context.Context, subjectToken string, audience string, scopes []string) (Token, error)
+}
+
+// ExchangerMiddleware describes an Exchanger middleware.
+type ExchangerMiddleware func(Exchanger) Exchanger
+
+type httpExchanger struct {
+	endpoint     string
+	clientID     string
+	clientSecret string
+	client       *http.Client
+}
+
+// NewHTTPExchanger returns an Exchanger speaking RFC 8693 against the token
+// endpoint of the authorization server. A nil client uses http.DefaultClient.
+func NewHTTPExchanger(endpoint, clientID, clientSecret string, client *http.Client) Exchanger {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpExchanger{
+		endpoint:     endpoint,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		client:       client,
+	}
+}
+
+func (e *httpExchanger) Exchange(ctx context.Context, subjectToken string, audience string, scopes []string) (Token, error) {
+	if subjectToken == "" {
+		return Token{}, ErrMissingSubjectToken
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", grantTypeTokenExchange)
+	form.Set("subject_token", subjectToken)
+	form.Set("subject_token_type", tokenTypeAccessToken)
+	form.Set("requested_token_type", tokenTypeAccessToken)
+	form.Set("audience", audience)
+	if len(scopes) > 0 {
+		form.Set("scope", strings.Join(scopes, " "))
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Token{}, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if e.clientID != "" {
+		req.SetBasicAuth(e.clientID, e.clientSecret)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return Token{}, errors.Wrap(ErrExchangeFailed, err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+		Scope       string `json:"scope"`
+		Error       string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return Token{}, errors.Wrap(ErrExchangeFailed, err)
+	}
+	if resp.StatusCode != http.StatusOK || body.AccessToken == "" {
+		reason := body.Error
+		if reason == "" {
+			reason = resp.Status
+		}
+		return Token{}, errors.Wrap(ErrExchangeFailed, errors.New(reason))
+	}
+
+	granted := scopes
+	if body.Scope != "" {
+		granted = strings.Fields(body.Scope)
+	}
+	token := Token{AccessToken: body.AccessToken, Audience: audience, Scopes: granted}
+	if body.ExpiresIn > 0 {
+		token.ExpiresAt = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
@@ -0,0 +1,100 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/gcp"
+)
+
+const cloudTraceURL = "https://cloudtrace.googleapis.com/v2/"
+
+// cloudTraceExporter exports spans to Cloud Trace through its REST API.
+type cloudTraceExporter struct {
+	projectID string
+	client    *http.Client
+}
+
+// NewCloudTraceExporter returns an exporter writing to the Cloud Trace of
+// projectID, authenticated as the default service account.
+func NewCloudTraceExporter(projectID string) sdktrace.SpanExporter {
+	return &cloudTraceExporter{
+		projectID: projectID,
+		client:    gcp.NewClient("https://www.googleapis.com/auth/trace.append"),
+	}
+}
+
+type truncatableString struct {
+	Value string `json:"value"`
+}
+
+func (e *cloudTraceExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	out := make([]map[string]interface{}, 0, len(spans))
+	for _, s := range spans {
+		sc := s.SpanContext()
+		span := map[string]interface{}{
+			"name":        fmt.Sprintf("projects/%s/traces/%s/spans/%s", e.projectID, sc.TraceID(), sc.SpanID()),
+			"spanId":      sc.SpanID().String(),
+			"displayName": truncatableString{Value: s.Name()},
+			"startTime":   s.StartTime().UTC().Format(time.RFC3339Nano),
+			"endTime":     s.EndTime().UTC().Format(time.RFC3339Nano),
+			"spanKind":    cloudTraceKind(s.SpanKind()),
+			"attributes": map[string]interface{}{
+				"attributeMap": cloudTraceAttributes(append(s.Resource().Attributes(), s.Attributes()...)),
+			},
+		}
+		if s.Parent().HasSpanID() {
+			span["parentSpanId"] = s.Parent().SpanID().String()
+		}
+		if s.Status().Code == codes.Error {
+			// google.rpc.Code UNKNOWN
+			span["status"] = map[string]interface{}{"code": 2, "message": s.Status().Description}
+		}
+		out = append(out, span)
+	}
+	url := fmt.Sprintf("%sprojects/%s/traces:batchWrite", cloudTraceURL, e.projectID)
+	return postJSON(ctx, e.client, url, map[string]interface{}{"spans": out})
+}
+
+func (e *cloudTraceExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+func cloudTraceKind(kind trace.SpanKind) string {
+	switch kind {
+	case trace.SpanKindServer:
+		return "SERVER"
+	case trace.SpanKindClient:
+		return "CLIENT"
+	case trace.SpanKindProducer:
+		return "PRODUCER"
+	case trace.SpanKindConsumer:
+		return "CONSUMER"
+	case trace.SpanKindInternal:
+		return "INTERNAL"
+	default:
+		return "SPAN_KIND_UNSPECIFIED"
+	}
+}
+
+func cloudTraceAttributes(attrs []attribute.KeyValue) map[string]interface{} {
+	res := make(map[string]interface{}, len(attrs))
+	for _, kv := range attrs {
+		switch kv.Value.Type() {
+		case attribute.BOOL:
+			res[string(kv.Key)] = map[string]interface{}{"boolValue": kv.Value.AsBool()}
+		case attribute.INT64:
+			res[string(kv.Key)] = map[string]interface{}{"intValue": fmt.Sprint(kv.Value.AsInt64())}
+		default:
+			res[string(kv.Key)] = map[string]interface{}{"stringValue": truncatableString{Value: kv.Value.Emit()}}
+		}
+	}
+	return res
+}
@@ -0,0 +1,55 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/endpoint"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TraceServer returns an endpoint middleware running the endpoint in a span
+// named operationName, child of the span extracted from the transport.
+func TraceServer(operationName string) endpoint.Middleware {
+	return traceEndpoint(operationName, trace.SpanKindServer)
+}
+
+// TraceClient returns an endpoint middleware running the client endpoint in a
+// span named operationName, to be propagated by ContextToHTTP or ContextToGRPC.
+func TraceClient(operationName string) endpoint.Middleware {
+	return traceEndpoint(operationName, trace.SpanKindClient)
+}
+
+type attributesContextKey struct{}
+
+// ContextWithAttributes returns ctx tagging the spans the middlewares of this
+// package start with attrs, on top of the attributes ctx already carries,
+// for the middlewares running before the span to annotate it.
+func ContextWithAttributes(ctx context.Context, attrs ...attribute.KeyValue) context.Context {
+	prev, _ := ctx.Value(attributesContextKey{}).([]attribute.KeyValue)
+	next := make([]attribute.KeyValue, 0, len(prev)+len(attrs))
+	next = append(append(next, prev...), attrs...)
+	return context.WithValue(ctx, attributesContextKey{}, next)
+}
+
+func traceEndpoint(operationName string, kind trace.SpanKind) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			attrs, _ := ctx.Value(attributesContextKey{}).([]attribute.KeyValue)
+			ctx, span := otel.Tracer(instrumentationName).Start(ctx, operationName, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+			defer func() {
+				if err != nil {
+					span.RecordError(err)
+					span.SetStatus(codes.Error, err.Error())
+				} else if f, ok := response.(endpoint.Failer); ok && f.Failed() != nil {
+					span.RecordError(f.Failed())
+					span.SetStatus(codes.Error, f.Failed().Error())
+				}
+				span.End()
+			}()
+			return next(ctx, request)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// The attributes of the gRPC spans, as the OpenTelemetry RPC conventions
+// name them.
+const (
+	AttributeRPCSystem     = attribute.Key("rpc.system")
+	AttributeRPCService    = attribute.Key("rpc.service")
+	AttributeRPCMethod     = attribute.Key("rpc.method")
+	AttributeRPCStatusCode = attribute.Key("rpc.grpc.status_code")
+)
+
+// UnaryServerInterceptor returns a gRPC interceptor running every unary call
+// in a server span named after its full method, child of the trace context
+// of the incoming metadata, and tagged with its status code.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, span := startServerSpan(ctx, info.FullMethod)
+		resp, err := handler(ctx, req)
+		endSpan(span, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns the UnaryServerInterceptor of the streaming
+// calls, whose span lasts until their handler returns.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, span := startServerSpan(ss.Context(), info.FullMethod)
+		err := handler(srv, serverStream{ServerStream: ss, ctx: ctx})
+		endSpan(span, err)
+		return err
+	}
+}
+
+// UnaryClientInterceptor returns a gRPC interceptor running every unary call
+// in a client span named after its full method, whose trace context it adds
+// to the outgoing metadata.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, span := otel.Tracer(instrumentationName).Start(ctx, spanName(method), trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(rpcAttributes(method)...))
+		md, _ := metadata.FromOutgoingContext(ctx)
+		md = md.Copy()
+		otel.GetTextMapPropagator().Inject(ctx, metadataCarrier(md))
+		err := invoker(metadata.NewOutgoingContext(ctx, md), method, req, reply, cc, opts...)
+		endSpan(span, err)
+		return err
+	}
+}
+
+func startServerSpan(ctx context.Context, method string) (context.Context, trace.Span) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx = otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+	return otel.Tracer(instrumentationName).Start(ctx, spanName(method), trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(rpcAttributes(method)...))
+}
+
+// endSpan tags span with the status code of err, and ends it.
+func endSpan(span trace.Span, err error) {
+	s, _ := status.FromError(err)
+	span.SetAttributes(AttributeRPCStatusCode.Int64(int64(s.Code())))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, s.Message())
+	}
+	span.End()
+}
+
+// spanName returns the name of the span of the full method /package.Service/Method.
+func spanName(method string) string {
+	return strings.TrimPrefix(method, "/")
+}
+
+func rpcAttributes(method string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{AttributeRPCSystem.String("grpc")}
+	if i := strings.LastIndex(method, "/"); i > 0 {
+		attrs = append(attrs, AttributeRPCService.String(method[1:i]), AttributeRPCMethod.String(method[i+1:]))
+	}
+	return attrs
+}
+
+// serverStream is a grpc.ServerStream of the context of its span.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s serverStream) Context() context.Context { return s.ctx }
@@ -0,0 +1,156 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// otlpExporter exports spans with the JSON encoding of OTLP/HTTP, which every
+// OpenTelemetry collector accepts.
+type otlpExporter struct {
+	url    string
+	client *http.Client
+}
+
+// NewOTLPExporter returns an exporter posting to the OTLP/HTTP collector at
+// endpoint, e.g. http://localhost:4318.
+func NewOTLPExporter(endpoint string, client *http.Client) sdktrace.SpanExporter {
+	return &otlpExporter{url: strings.TrimSuffix(endpoint, "/") + "/v1/traces", client: client}
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Status            struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []*otlpScopeSpans `json:"scopeSpans"`
+}
+
+func (e *otlpExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	var resources []*otlpResourceSpans
+	byResource := map[attribute.Distinct]*otlpResourceSpans{}
+	byScope := map[attribute.Distinct]map[string]*otlpScopeSpans{}
+
+	for _, s := range spans {
+		key := s.Resource().Equivalent()
+		rs, ok := byResource[key]
+		if !ok {
+			rs = &otlpResourceSpans{}
+			rs.Resource.Attributes = otlpAttributes(s.Resource().Attributes())
+			byResource[key] = rs
+			byScope[key] = map[string]*otlpScopeSpans{}
+			resources = append(resources, rs)
+		}
+		scope := s.InstrumentationScope()
+		ss, ok := byScope[key][scope.Name+"@"+scope.Version]
+		if !ok {
+			ss = &otlpScopeSpans{}
+			ss.Scope.Name, ss.Scope.Version = scope.Name, scope.Version
+			byScope[key][scope.Name+"@"+scope.Version] = ss
+			rs.ScopeSpans = append(rs.ScopeSpans, ss)
+		}
+		ss.Spans = append(ss.Spans, otlpFromSpan(s))
+	}
+
+	return postJSON(ctx, e.client, e.url, map[string]interface{}{"resourceSpans": resources})
+}
+
+func (e *otlpExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+func otlpFromSpan(s sdktrace.ReadOnlySpan) otlpSpan {
+	span := otlpSpan{
+		TraceID:           s.SpanContext().TraceID().String(),
+		SpanID:            s.SpanContext().SpanID().String(),
+		Name:              s.Name(),
+		Kind:              int(s.SpanKind()),
+		StartTimeUnixNano: unixNano(s.StartTime()),
+		EndTimeUnixNano:   unixNano(s.EndTime()),
+		Attributes:        otlpAttributes(s.Attributes()),
+	}
+	if s.Parent().HasSpanID() {
+		span.ParentSpanID = s.Parent().SpanID().String()
+	}
+	for _, ev := range s.Events() {
+		span.Events = append(span.Events, otlpEvent{
+			TimeUnixNano: unixNano(ev.Time),
+			Name:         ev.Name,
+			Attributes:   otlpAttributes(ev.Attributes),
+		})
+	}
+	// OTLP numbers the status codes differently than the API does
+	switch s.Status().Code {
+	case codes.Ok:
+		span.Status.Code = 1
+	case codes.Error:
+		span.Status.Code = 2
+		span.Status.Message = s.Status().Description
+	}
+	return span
+}
+
+func otlpAttributes(attrs []attribute.KeyValue) []otlpKeyValue {
+	res := make([]otlpKeyValue, 0, len(attrs))
+	for _, kv := range attrs {
+		var v map[string]interface{}
+		switch kv.Value.Type() {
+		case attribute.BOOL:
+			v = map[string]interface{}{"boolValue": kv.Value.AsBool()}
+		case attribute.INT64:
+			// int64 values are strings in the JSON encoding of protobuf
+			v = map[string]interface{}{"intValue": strconv.FormatInt(kv.Value.AsInt64(), 10)}
+		case attribute.FLOAT64:
+			v = map[string]interface{}{"doubleValue": kv.Value.AsFloat64()}
+		default:
+			v = map[string]interface{}{"stringValue": kv.Value.Emit()}
+		}
+		res = append(res, otlpKeyValue{Key: string(kv.Key), Value: v})
+	}
+	return res
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
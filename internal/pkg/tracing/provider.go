@@ -0,0 +1,119 @@
+// Package tracing sets up OpenTelemetry tracing: a tracer provider exporting
+// to OTLP, Cloud Trace or Zipkin, and the go-kit middlewares and request funcs
+// creating and propagating spans.
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// The supported exporters.
+const (
+	ExporterNone       = "none"
+	ExporterOTLP       = "otlp"
+	ExporterCloudTrace = "cloudtrace"
+	ExporterZipkin     = "zipkin"
+)
+
+// instrumentationName names the tracer of this module.
+const instrumentationName = "github.com/cage1016/gokit-gae"
+
+var (
+	// ErrUnknownExporter indicates an unsupported exporter name.
+	ErrUnknownExporter = errors.New("unknown trace exporter")
+
+	// ErrExport indicates a collector rejected exported spans.
+	ErrExport = errors.New("trace export failed")
+)
+
+// Config configures the tracer provider.
+type Config struct {
+	// ServiceName is reported as the service.name resource attribute.
+	ServiceName string
+	// Version is reported as the service.version resource attribute.
+	Version string
+	// Exporter is one of the Exporter constants.
+	Exporter string
+	// Endpoint is the collector URL of the OTLP and Zipkin exporters, or the
+	// project ID of the Cloud Trace exporter.
+	Endpoint string
+	// SampleRatio is the fraction of root spans sampled; child spans follow
+	// their parent.
+	SampleRatio float64
+}
+
+// NewTracerProvider returns a tracer provider exporting to cfg.Exporter and
+// installs it, together with the W3C trace context propagator, as the global
+// one. The caller shuts it down to flush pending spans.
+//
+// Without an exporter the provider is a no-op one: spans aren't recorded,
+// and no SDK pipeline is built, but the incoming trace context still flows
+// to outgoing requests and logs.
+func NewTracerProvider(cfg Config) (trace.TracerProvider, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	var exporter sdktrace.SpanExporter
+	switch cfg.Exporter {
+	case ExporterNone, "":
+		tp := noop.NewTracerProvider()
+		otel.SetTracerProvider(tp)
+		return tp, nil
+	case ExporterOTLP:
+		exporter = NewOTLPExporter(cfg.Endpoint, client)
+	case ExporterCloudTrace:
+		exporter = NewCloudTraceExporter(cfg.Endpoint)
+	case ExporterZipkin:
+		exporter = NewZipkinExporter(cfg.Endpoint, cfg.ServiceName, client)
+	default:
+		return nil, errors.Wrap(ErrUnknownExporter, errors.New(cfg.Exporter))
+	}
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", cfg.ServiceName),
+			attribute.String("service.version", cfg.Version),
+		)),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithBatcher(exporter),
+	}
+	tp := sdktrace.NewTracerProvider(opts...)
+
+	otel.SetTracerProvider(tp)
+	return tp, nil
+}
+
+// Shutdown flushes and stops tp, waiting no longer than timeout. No-op
+// providers have nothing to flush.
+func Shutdown(tp trace.TracerProvider, timeout time.Duration) error {
+	sdk, ok := tp.(*sdktrace.TracerProvider)
+	if !ok {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return sdk.Shutdown(ctx)
+}
+
+// Flush exports the spans tp has buffered, waiting no longer than ctx
+// allows. No-op providers have nothing to flush.
+func Flush(ctx context.Context, tp trace.TracerProvider) error {
+	sdk, ok := tp.(*sdktrace.TracerProvider)
+	if !ok {
+		return nil
+	}
+	return sdk.ForceFlush(ctx)
+}
@@ -0,0 +1,128 @@
+package tracing
+
+import (
+	"context"
+	"crypto/sha256"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/tasks"
+)
+
+// The headers of the cron invocations, by App Engine cron or Cloud
+// Scheduler. The task headers are read by package tasks.
+const (
+	headerAppEngineCron = "X-Appengine-Cron"
+	headerScheduler     = "X-Cloudscheduler"
+	headerSchedulerJob  = "X-Cloudscheduler-Jobname"
+	headerSchedulerTime = "X-Cloudscheduler-Scheduletime"
+)
+
+// The attributes of the task and cron spans.
+const (
+	AttributeTaskQueue          = attribute.Key("task.queue")
+	AttributeTaskName           = attribute.Key("task.name")
+	AttributeTaskRetryCount     = attribute.Key("task.retry_count")
+	AttributeTaskExecutionCount = attribute.Key("task.execution_count")
+	AttributeTaskScheduleTime   = attribute.Key("task.schedule_time")
+	AttributeCronJob            = attribute.Key("cron.job")
+	AttributeCronScheduleTime   = attribute.Key("cron.schedule_time")
+)
+
+// TaskHandler returns a handler tracing the Cloud Tasks and cron invocations
+// of next in spans of their own, named after the queue or the job and tagged
+// with the task name, retry count and schedule time.
+//
+// Every attempt of a task is a root span of the same trace, whose ID derives
+// from the queue and task names, so the retries of a task chain up in one
+// trace; the trace the task was enqueued from, if propagated, is linked.
+// Scheduler jobs get the same treatment per schedule time. Other requests go
+// to next untouched. It's meant to wrap the handler starting the HTTP server
+// spans, which become children of the task spans.
+func TaskHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, key, attrs := invocation(r)
+		if name == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// the enqueuer's trace is linked, not continued
+		var opts []trace.SpanStartOption
+		if sc := trace.SpanContextFromContext(otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(r.Header))); sc.IsValid() {
+			opts = append(opts, trace.WithLinks(trace.Link{SpanContext: sc}))
+		}
+		r.Header.Del("Traceparent")
+		r.Header.Del("Tracestate")
+
+		ctx := r.Context()
+		if key != "" {
+			ctx = trace.ContextWithRemoteSpanContext(ctx, chainSpanContext(key))
+		} else {
+			opts = append(opts, trace.WithNewRoot())
+		}
+		opts = append(opts, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+		ctx, span := otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+		defer span.End()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// invocation returns the span name, the chain key and the attributes of the
+// task or cron invocation r, or an empty name for other requests.
+func invocation(r *http.Request) (name, key string, attrs []attribute.KeyValue) {
+	if info, ok := tasks.FromRequest(r); ok {
+		attrs = append(attrs,
+			AttributeTaskQueue.String(info.Queue),
+			AttributeTaskName.String(info.Name),
+			AttributeTaskRetryCount.Int(info.RetryCount),
+			AttributeTaskExecutionCount.Int(info.ExecutionCount),
+		)
+		if !info.ETA.IsZero() {
+			attrs = append(attrs, AttributeTaskScheduleTime.String(info.ETA.Format(time.RFC3339Nano)))
+		}
+		if info.Name != "" {
+			key = "task/" + info.Queue + "/" + info.Name
+		}
+		return "task " + info.Queue, key, attrs
+	}
+
+	h := r.Header
+	if job := h.Get(headerSchedulerJob); job != "" || h.Get(headerScheduler) == "true" {
+		attrs = append(attrs, AttributeCronJob.String(job))
+		if t := h.Get(headerSchedulerTime); t != "" {
+			attrs = append(attrs, AttributeCronScheduleTime.String(t))
+			if job != "" {
+				key = "cron/" + job + "/" + t
+			}
+		}
+		return "cron " + job, key, attrs
+	}
+	if h.Get(headerAppEngineCron) == "true" {
+		return "cron " + r.URL.Path, "", nil
+	}
+	return "", "", nil
+}
+
+// chainSpanContext returns the sampled remote span context the attempts of
+// the invocation identified by key descend from. It's never exported: the
+// attempts show as the roots of its trace. Being sampled, it makes
+// parent-based samplers keep every task trace.
+func chainSpanContext(key string) trace.SpanContext {
+	sum := sha256.Sum256([]byte(key))
+	var tid trace.TraceID
+	var sid trace.SpanID
+	copy(tid[:], sum[:16])
+	copy(sid[:], sum[16:24])
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    tid,
+		SpanID:     sid,
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	})
+}
@@ -0,0 +1,74 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+// HTTPToContext returns an http RequestFunc extracting the trace context of
+// the incoming request into ctx.
+func HTTPToContext() httptransport.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(r.Header))
+	}
+}
+
+// ContextToHTTP returns an http RequestFunc injecting the trace context of
+// ctx into the outgoing request.
+func ContextToHTTP() httptransport.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(r.Header))
+		return ctx
+	}
+}
+
+// GRPCToContext returns a grpc ServerRequestFunc extracting the trace context
+// of the incoming metadata into ctx, unless ctx has a span already, such as
+// the one of UnaryServerInterceptor.
+func GRPCToContext() grpctransport.ServerRequestFunc {
+	return func(ctx context.Context, md metadata.MD) context.Context {
+		if trace.SpanContextFromContext(ctx).IsValid() {
+			return ctx
+		}
+		return otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+	}
+}
+
+// ContextToGRPC returns a grpc ClientRequestFunc injecting the trace context
+// of ctx into the outgoing metadata.
+func ContextToGRPC() grpctransport.ClientRequestFunc {
+	return func(ctx context.Context, md *metadata.MD) context.Context {
+		otel.GetTextMapPropagator().Inject(ctx, metadataCarrier(*md))
+		return ctx
+	}
+}
+
+// metadataCarrier adapts gRPC metadata to propagation.TextMapCarrier.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if v := metadata.MD(c).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(strings.ToLower(key), value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
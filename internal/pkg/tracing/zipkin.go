@@ -0,0 +1,91 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// zipkinExporter exports spans to the Zipkin v2 JSON API.
+type zipkinExporter struct {
+	url         string
+	serviceName string
+	client      *http.Client
+}
+
+// NewZipkinExporter returns an exporter posting to the Zipkin collector at
+// url, e.g. http://localhost:9411/api/v2/spans.
+func NewZipkinExporter(url, serviceName string, client *http.Client) sdktrace.SpanExporter {
+	return &zipkinExporter{url: url, serviceName: serviceName, client: client}
+}
+
+type zipkinAnnotation struct {
+	Timestamp int64  `json:"timestamp"`
+	Value     string `json:"value"`
+}
+
+type zipkinSpan struct {
+	TraceID       string             `json:"traceId"`
+	ID            string             `json:"id"`
+	ParentID      string             `json:"parentId,omitempty"`
+	Name          string             `json:"name"`
+	Kind          string             `json:"kind,omitempty"`
+	Timestamp     int64              `json:"timestamp"`
+	Duration      int64              `json:"duration"`
+	LocalEndpoint map[string]string  `json:"localEndpoint"`
+	Tags          map[string]string  `json:"tags,omitempty"`
+	Annotations   []zipkinAnnotation `json:"annotations,omitempty"`
+}
+
+func (e *zipkinExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	out := make([]zipkinSpan, 0, len(spans))
+	for _, s := range spans {
+		span := zipkinSpan{
+			TraceID:       s.SpanContext().TraceID().String(),
+			ID:            s.SpanContext().SpanID().String(),
+			Name:          s.Name(),
+			Kind:          zipkinKind(s.SpanKind()),
+			Timestamp:     s.StartTime().UnixNano() / 1e3,
+			Duration:      s.EndTime().Sub(s.StartTime()).Nanoseconds() / 1e3,
+			LocalEndpoint: map[string]string{"serviceName": e.serviceName},
+			Tags:          map[string]string{},
+		}
+		if s.Parent().HasSpanID() {
+			span.ParentID = s.Parent().SpanID().String()
+		}
+		for _, kv := range s.Attributes() {
+			span.Tags[string(kv.Key)] = kv.Value.Emit()
+		}
+		if s.Status().Code == codes.Error {
+			span.Tags["error"] = s.Status().Description
+		}
+		for _, ev := range s.Events() {
+			span.Annotations = append(span.Annotations, zipkinAnnotation{Timestamp: ev.Time.UnixNano() / 1e3, Value: ev.Name})
+		}
+		out = append(out, span)
+	}
+	return postJSON(ctx, e.client, e.url, out)
+}
+
+func (e *zipkinExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+// zipkinKind maps the span kind, internal spans have none in Zipkin.
+func zipkinKind(kind trace.SpanKind) string {
+	switch kind {
+	case trace.SpanKindServer:
+		return "SERVER"
+	case trace.SpanKindClient:
+		return "CLIENT"
+	case trace.SpanKindProducer:
+		return "PRODUCER"
+	case trace.SpanKindConsumer:
+		return "CONSUMER"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,212 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+func init() {
+	RegisterStep("rename", buildRename)
+	RegisterStep("remove", buildRemove)
+	RegisterStep("map", buildMap)
+	RegisterStep("headers", buildHeaders)
+}
+
+// decode decodes def into v, numbers as json.Number, rejecting unknown
+// fields.
+func decode(def json.RawMessage, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(def))
+	dec.UseNumber()
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// A path addresses fields of a body, their names separated with dots, "*"
+// standing for every element of an array or field of an object, e.g.
+// "items.*.res".
+func splitPath(path string) ([]string, error) {
+	parts := strings.Split(path, ".")
+	for _, p := range parts {
+		if p == "" {
+			return nil, fmt.Errorf("invalid path %q", path)
+		}
+	}
+	return parts, nil
+}
+
+// walk calls fn with every object of v holding the field of path, and the
+// name of the field.
+func walk(v interface{}, path []string, fn func(obj map[string]interface{}, field string)) {
+	if len(path) == 0 {
+		return
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			if path[0] == "*" {
+				for k := range v {
+					fn(v, k)
+				}
+			} else if _, ok := v[path[0]]; ok {
+				fn(v, path[0])
+			}
+			return
+		}
+		if path[0] == "*" {
+			for _, e := range v {
+				walk(e, path[1:], fn)
+			}
+			return
+		}
+		walk(v[path[0]], path[1:], fn)
+	case []interface{}:
+		if path[0] != "*" {
+			return
+		}
+		for _, e := range v {
+			walk(e, path[1:], fn)
+		}
+	}
+}
+
+// buildRename builds the steps renaming fields, {"path": "new name"}, e.g.
+// {"rename": {"items.*.res": "result"}}, the renamed field replacing any
+// field of the new name. The renames apply in the order of their paths.
+func buildRename(def json.RawMessage) (Step, error) {
+	var renames map[string]string
+	if err := decode(def, &renames); err != nil {
+		return nil, err
+	}
+	type rename struct {
+		path []string
+		to   string
+	}
+	var rs []rename
+	for from, to := range renames {
+		path, err := splitPath(from)
+		if err != nil {
+			return nil, err
+		}
+		if to == "" || strings.Contains(to, ".") {
+			return nil, fmt.Errorf("invalid name %q", to)
+		}
+		rs = append(rs, rename{path, to})
+	}
+	sort.Slice(rs, func(i, j int) bool { return strings.Join(rs[i].path, ".") < strings.Join(rs[j].path, ".") })
+	return StepFunc(func(res *Response) error {
+		for _, r := range rs {
+			walk(res.Body, r.path, func(obj map[string]interface{}, field string) {
+				v := obj[field]
+				delete(obj, field)
+				obj[r.to] = v
+			})
+		}
+		return nil
+	}), nil
+}
+
+// buildRemove builds the steps removing fields, ["path", ...].
+func buildRemove(def json.RawMessage) (Step, error) {
+	var fields []string
+	if err := decode(def, &fields); err != nil {
+		return nil, err
+	}
+	var paths [][]string
+	for _, f := range fields {
+		path, err := splitPath(f)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return StepFunc(func(res *Response) error {
+		for _, path := range paths {
+			walk(res.Body, path, func(obj map[string]interface{}, field string) {
+				delete(obj, field)
+			})
+		}
+		return nil
+	}), nil
+}
+
+// buildMap builds the steps mapping the values of a field,
+// {"field": "path", "values": {"old": new, ...}, "default": new}. The old
+// values are compared with the text of the scalars, such as "ok", "42" or
+// "true"; the others are replaced with default, when given.
+func buildMap(def json.RawMessage) (Step, error) {
+	var m struct {
+		Field   string                 `json:"field"`
+		Values  map[string]interface{} `json:"values"`
+		Default json.RawMessage        `json:"default"`
+	}
+	if err := decode(def, &m); err != nil {
+		return nil, err
+	}
+	path, err := splitPath(m.Field)
+	if err != nil {
+		return nil, err
+	}
+	var fallback interface{}
+	hasDefault := len(m.Default) > 0
+	if hasDefault {
+		if err := decode(m.Default, &fallback); err != nil {
+			return nil, err
+		}
+	}
+	return StepFunc(func(res *Response) error {
+		walk(res.Body, path, func(obj map[string]interface{}, field string) {
+			var text string
+			switch v := obj[field].(type) {
+			case string:
+				text = v
+			case json.Number:
+				text = v.String()
+			case bool:
+				text = fmt.Sprint(v)
+			case nil:
+				text = "null"
+			default:
+				return
+			}
+			if nv, ok := m.Values[text]; ok {
+				obj[field] = nv
+			} else if hasDefault {
+				obj[field] = fallback
+			}
+		})
+		return nil
+	}), nil
+}
+
+// buildHeaders builds the steps rewriting the response header,
+// {"rename": {"Old": "New"}, "remove": ["Name"], "set": {"Name": "value"}},
+// applied in this order.
+func buildHeaders(def json.RawMessage) (Step, error) {
+	var h struct {
+		Rename map[string]string `json:"rename"`
+		Remove []string          `json:"remove"`
+		Set    map[string]string `json:"set"`
+	}
+	if err := decode(def, &h); err != nil {
+		return nil, err
+	}
+	return StepFunc(func(res *Response) error {
+		for from, to := range h.Rename {
+			if v, ok := res.Header[http.CanonicalHeaderKey(from)]; ok {
+				res.Header.Del(from)
+				res.Header[http.CanonicalHeaderKey(to)] = v
+			}
+		}
+		for _, k := range h.Remove {
+			res.Header.Del(k)
+		}
+		for k, v := range h.Set {
+			res.Header.Set(k, v)
+		}
+		return nil
+	}), nil
+}
@@ -0,0 +1,303 @@
+// Package transform rewrites the responses of API routes in gateway mode,
+// from pipelines of steps declared per route: field renames, value
+// mappings and header rewrites, so legacy clients keep their contract while
+// the service behind evolves. The kinds of steps are pluggable, see
+// RegisterStep.
+package transform
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+// ErrInvalidRule indicates a rule without a route, or with a step of an
+// unknown kind or an invalid definition.
+var ErrInvalidRule = errors.New("invalid transform rule")
+
+// Response is the response a Step transforms. Body is the decoded JSON
+// body, numbers as json.Number, nil when the response isn't JSON, in which
+// case only its header is transformed.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   interface{}
+}
+
+// Step is a step of a pipeline.
+type Step interface {
+	Apply(res *Response) error
+}
+
+// StepFunc is a Step of a function.
+type StepFunc func(res *Response) error
+
+// Apply calls f(res).
+func (f StepFunc) Apply(res *Response) error {
+	return f(res)
+}
+
+// A Builder builds a Step of its definition, the JSON value of the kind of
+// the step, decoded with json.Decoder.UseNumber.
+type Builder func(def json.RawMessage) (Step, error)
+
+var (
+	buildersMu sync.RWMutex
+	builders   = map[string]Builder{}
+)
+
+// RegisterStep registers the kind of steps build builds, the name of the
+// single field of their definitions, such as "rename" in
+// {"rename": {"res": "result"}}. It panics when kind is already registered.
+func RegisterStep(kind string, build Builder) {
+	buildersMu.Lock()
+	defer buildersMu.Unlock()
+	if _, ok := builders[kind]; ok {
+		panic("transform: step " + kind + " registered twice")
+	}
+	builders[kind] = build
+}
+
+// Rule is the pipeline of a route. Route is "METHOD /path" or "/path" for
+// all methods; a path ending with "/*" matches the whole subtree.
+type Rule struct {
+	Route string            `json:"route"`
+	Steps []json.RawMessage `json:"steps"`
+}
+
+func (r Rule) match(req *http.Request) bool {
+	route := r.Route
+	if i := strings.IndexByte(route, ' '); i > 0 {
+		if !strings.EqualFold(route[:i], req.Method) {
+			return false
+		}
+		route = strings.TrimSpace(route[i+1:])
+	}
+	if strings.HasSuffix(route, "/*") {
+		return strings.HasPrefix(req.URL.Path, strings.TrimSuffix(route, "*"))
+	}
+	return req.URL.Path == route
+}
+
+type pipeline struct {
+	rule  Rule
+	steps []Step
+}
+
+// Transformer applies the pipeline of the first rule matching a request to
+// its response.
+type Transformer struct {
+	pipelines []pipeline
+	logger    log.Logger
+}
+
+// Parse returns the Transformer of the JSON document b, {"routes": [...]}
+// listing the rules, e.g.
+//
+//	{"routes": [{
+//		"route": "POST /api/v1/sum",
+//		"steps": [
+//			{"rename": {"res": "result"}},
+//			{"map": {"field": "status", "values": {"ok": "OK"}}},
+//			{"headers": {"set": {"X-Api-Version": "1"}, "remove": ["Etag"]}}
+//		]
+//	}]}
+func Parse(b []byte, logger log.Logger) (*Transformer, error) {
+	var doc struct {
+		Routes []Rule `json:"routes"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	t := &Transformer{logger: logger}
+	for _, r := range doc.Routes {
+		if strings.TrimSpace(r.Route) == "" {
+			return nil, errors.Wrap(ErrInvalidRule, errors.New("missing route"))
+		}
+		p := pipeline{rule: r}
+		for i, def := range r.Steps {
+			s, err := buildStep(def)
+			if err != nil {
+				return nil, errors.Wrap(ErrInvalidRule, fmt.Errorf("%s: step %d: %v", r.Route, i, err))
+			}
+			p.steps = append(p.steps, s)
+		}
+		t.pipelines = append(t.pipelines, p)
+	}
+	return t, nil
+}
+
+func buildStep(def json.RawMessage) (Step, error) {
+	var kinds map[string]json.RawMessage
+	if err := json.Unmarshal(def, &kinds); err != nil {
+		return nil, err
+	}
+	if len(kinds) != 1 {
+		return nil, fmt.Errorf("a step has a single kind, got %d", len(kinds))
+	}
+	for kind, v := range kinds {
+		buildersMu.RLock()
+		build, ok := builders[kind]
+		buildersMu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown step %q", kind)
+		}
+		return build(v)
+	}
+	return nil, nil
+}
+
+func (t *Transformer) match(r *http.Request) (pipeline, bool) {
+	for _, p := range t.pipelines {
+		if p.rule.match(r) {
+			return p, true
+		}
+	}
+	return pipeline{}, false
+}
+
+// Middleware returns an http middleware transforming the responses of the
+// routes of the rules. JSON responses are buffered to be rewritten and are
+// never compressed; the others, such as server-sent events, stream through
+// with their header only transformed. A failing pipeline leaves the
+// response as it is.
+func (t *Transformer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := t.match(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r.Header.Del("Accept-Encoding")
+		tw := &transformWriter{ResponseWriter: w, t: t, p: p}
+		next.ServeHTTP(tw, r)
+		tw.finish()
+	})
+}
+
+// apply runs the steps of p on res.
+func (t *Transformer) apply(p pipeline, res *Response) bool {
+	for i, s := range p.steps {
+		if err := s.Apply(res); err != nil {
+			level.Warn(t.logger).Log("transform", p.rule.Route, "step", i, "err", err)
+			return false
+		}
+	}
+	return true
+}
+
+// transformWriter buffers the JSON responses to transform them once
+// complete, and transforms the header of the others before passing them
+// through.
+type transformWriter struct {
+	http.ResponseWriter
+	t         *Transformer
+	p         pipeline
+	status    int
+	buffering bool
+	body      bytes.Buffer
+}
+
+func (w *transformWriter) WriteHeader(code int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = code
+	h := w.Header()
+	if strings.Contains(h.Get("Content-Type"), "json") && h.Get("Content-Encoding") == "" &&
+		code != http.StatusNoContent && code != http.StatusNotModified {
+		w.buffering = true
+		return
+	}
+	res := &Response{Status: code, Header: h.Clone()}
+	if w.t.apply(w.p, res) {
+		replaceHeader(h, res.Header)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *transformWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.buffering {
+		return w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// finish writes the buffered response, transformed.
+func (w *transformWriter) finish() {
+	if !w.buffering {
+		return
+	}
+	h := w.Header()
+	body := w.body.Bytes()
+	res := &Response{Status: w.status, Header: h.Clone()}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	err := dec.Decode(&res.Body)
+	if err != nil && len(body) > 0 {
+		level.Warn(w.t.logger).Log("transform", w.p.rule.Route, "err", err)
+	}
+	if err == nil && w.t.apply(w.p, res) {
+		if b, err := marshal(res.Body); err == nil {
+			replaceHeader(h, res.Header)
+			body = b
+		}
+	}
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(body)
+}
+
+// Flush passes through the responses not buffered.
+func (w *transformWriter) Flush() {
+	if w.buffering {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack keeps websocket upgrades working.
+func (w *transformWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return h.Hijack()
+}
+
+func marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func replaceHeader(dst, src http.Header) {
+	for k := range dst {
+		if _, ok := src[k]; !ok {
+			delete(dst, k)
+		}
+	}
+	for k, v := range src {
+		dst[k] = v
+	}
+}
@@ -0,0 +1,130 @@
+// Package wiring builds the components of a service in the order their
+// declared dependencies require, so that adding a component only takes
+// naming what it needs rather than finding its place in a hand-written
+// sequence.
+//
+// Components hand their products over through the variables their Build
+// functions assign, typically locals of main captured by closures:
+//
+//	var cfg config
+//	g.Provide("config", nil, func(ctx context.Context) error {
+//		cfg = loadConfig()
+//		return nil
+//	})
+//	g.Provide("repository", []string{"config"}, func(ctx context.Context) error {
+//		repo = newRepository(cfg)
+//		return nil
+//	})
+package wiring
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cage1016/gokit-gae/internal/pkg/errors"
+)
+
+var (
+	// ErrMissingDependency indicates a component needing one that wasn't
+	// provided.
+	ErrMissingDependency = errors.New("missing dependency")
+
+	// ErrCycle indicates components needing each other.
+	ErrCycle = errors.New("dependency cycle")
+)
+
+// Component is a part of the service built by Build once the components it
+// needs are.
+type Component struct {
+	Name  string
+	Needs []string
+	Build func(ctx context.Context) error
+}
+
+// Graph is the set of the components of a service.
+type Graph struct {
+	components []Component
+	index      map[string]int
+}
+
+// New returns an empty Graph.
+func New() *Graph {
+	return &Graph{index: map[string]int{}}
+}
+
+// Provide declares the component name, built by build once the components
+// it needs are. It panics when name is already provided.
+func (g *Graph) Provide(name string, needs []string, build func(ctx context.Context) error) {
+	if _, ok := g.index[name]; ok {
+		panic(fmt.Sprintf("wiring: component %q already provided", name))
+	}
+	g.index[name] = len(g.components)
+	g.components = append(g.components, Component{Name: name, Needs: needs, Build: build})
+}
+
+// Order returns the names of the components in an order building every
+// component after the ones it needs, the order they were provided in when
+// it does. A missing dependency or a cycle is an error.
+func (g *Graph) Order() ([]string, error) {
+	for _, c := range g.components {
+		for _, n := range c.Needs {
+			if _, ok := g.index[n]; !ok {
+				return nil, errors.Wrap(ErrMissingDependency, fmt.Errorf("%s needs %s", c.Name, n))
+			}
+		}
+	}
+
+	built := make([]bool, len(g.components))
+	order := make([]string, 0, len(g.components))
+	for len(order) < len(g.components) {
+		progress := false
+		for i, c := range g.components {
+			if built[i] || !g.ready(c, built) {
+				continue
+			}
+			built[i], progress = true, true
+			order = append(order, c.Name)
+			// restart from the first, to stick to the order provided
+			break
+		}
+		if !progress {
+			var stuck []string
+			for i, c := range g.components {
+				if !built[i] {
+					stuck = append(stuck, c.Name)
+				}
+			}
+			return nil, errors.Wrap(ErrCycle, fmt.Errorf("between %s", strings.Join(stuck, ", ")))
+		}
+	}
+	return order, nil
+}
+
+func (g *Graph) ready(c Component, built []bool) bool {
+	for _, n := range c.Needs {
+		if !built[g.index[n]] {
+			return false
+		}
+	}
+	return true
+}
+
+// Build builds every component in Order, calling done with the name of
+// each one built, e.g. to time the steps of the startup. It stops at the
+// first component failing, before building any when the graph is invalid.
+func (g *Graph) Build(ctx context.Context, done func(name string)) error {
+	order, err := g.Order()
+	if err != nil {
+		return err
+	}
+	for _, name := range order {
+		if err := g.components[g.index[name]].Build(ctx); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		if done != nil {
+			done(name)
+		}
+	}
+	return nil
+}
@@ -7,8 +7,11 @@
 #
 # Update protoc Go bindings via
 #  go get -u github.com/golang/protobuf/{proto,protoc-gen-go}
+#  go get -u github.com/grpc-ecosystem/grpc-gateway/protoc-gen-grpc-gateway@v1.13.0
 #
 # See also
 #  https://github.com/grpc/grpc-go/tree/master/examples
+#  https://github.com/grpc-ecosystem/grpc-gateway
 
-protoc add.proto --go_out=plugins=grpc:.
\ No newline at end of file
+protoc add.proto --go_out=plugins=grpc:.
+protoc add.proto --grpc-gateway_out=logtostderr=true,grpc_api_configuration=add_gateway.yaml:.
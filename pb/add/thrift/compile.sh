@@ -0,0 +1,14 @@
+#!/usr/bin/env sh
+
+# Install the Thrift compiler, e.g. `brew install thrift`, see also
+# https://thrift.apache.org/tutorial/go. The generated code targets
+# github.com/apache/thrift v0.24.0.
+#
+# The generated files are built with the thrift build tag only, so the
+# default build doesn't depend on Thrift.
+
+thrift -r --gen "go:package_prefix=github.com/cage1016/gokit-gae/pb/add/thrift/gen-go/,thrift_import=github.com/apache/thrift/lib/go/thrift,skip_remote" add.thrift
+for f in gen-go/add/*.go; do
+	printf '//go:build thrift\n// +build thrift\n\n' | cat - "$f" > "$f.tmp" && mv "$f.tmp" "$f"
+done
+gofmt -w gen-go/add